@@ -8,6 +8,7 @@ import (
 	"bytes"
 	"crypto/rand"
 	"crypto/tls"
+	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
@@ -102,6 +103,17 @@ type Options struct {
 
 	PingInterval time.Duration // disabled if 0 or negative
 	MaxPingsOut  int
+
+	// TLSConfig is used for secure connections instead of one that skips
+	// verifying the server.
+	TLSConfig *tls.Config
+
+	// Nkey is the public NKey of the user, and UserJWT returns the JWT of
+	// the user, which are sent with the signature of the nonce of the
+	// server that SignatureCB makes.
+	Nkey        string
+	UserJWT     func() (string, error)
+	SignatureCB func(nonce []byte) ([]byte, error)
 }
 
 const (
@@ -209,6 +221,7 @@ type serverInfo struct {
 	AuthRequired bool   `json:"auth_required"`
 	SslRequired  bool   `json:"ssl_required"`
 	MaxPayload   int64  `json:"max_payload"`
+	Nonce        string `json:"nonce,omitempty"`
 }
 
 type connectInfo struct {
@@ -218,6 +231,9 @@ type connectInfo struct {
 	Pass     string `json:"pass,omitempty"`
 	Ssl      bool   `json:"ssl_required"`
 	Name     string `json:"name"`
+	Nkey     string `json:"nkey,omitempty"`
+	JWT      string `json:"jwt,omitempty"`
+	Sig      string `json:"sig,omitempty"`
 }
 
 // MsgHandler is a callback function that processes messages delivered to
@@ -424,7 +440,17 @@ func (nc *Conn) createConn() error {
 
 // makeSecureConn will wrap an existing Conn using TLS
 func (nc *Conn) makeTLSConn() {
-	nc.conn = tls.Client(nc.conn, &tls.Config{InsecureSkipVerify: true})
+	config := nc.Opts.TLSConfig
+	if config == nil {
+		config = &tls.Config{InsecureSkipVerify: true}
+	} else if config.ServerName == "" && !config.InsecureSkipVerify {
+		config = config.Clone()
+		config.ServerName = nc.url.Host
+		if host, _, err := net.SplitHostPort(nc.url.Host); err == nil {
+			config.ServerName = host
+		}
+	}
+	nc.conn = tls.Client(nc.conn, config)
 	nc.bw = bufio.NewWriterSize(nc.conn, defaultBufSize)
 }
 
@@ -626,7 +652,23 @@ func (nc *Conn) connectProto() (string, error) {
 		user = u.Username()
 		pass, _ = u.Password()
 	}
-	cinfo := connectInfo{o.Verbose, o.Pedantic, user, pass, o.Secure, o.Name}
+	cinfo := connectInfo{Verbose: o.Verbose, Pedantic: o.Pedantic, User: user, Pass: pass, Ssl: o.Secure, Name: o.Name, Nkey: o.Nkey}
+	if o.UserJWT != nil {
+		jwt, err := o.UserJWT()
+		if err != nil {
+			nc.err = err
+			return _EMPTY_, err
+		}
+		cinfo.JWT = jwt
+	}
+	if o.SignatureCB != nil && nc.info.Nonce != _EMPTY_ {
+		sig, err := o.SignatureCB([]byte(nc.info.Nonce))
+		if err != nil {
+			nc.err = err
+			return _EMPTY_, err
+		}
+		cinfo.Sig = base64.RawURLEncoding.EncodeToString(sig)
+	}
 	b, err := json.Marshal(cinfo)
 	if err != nil {
 		nc.err = ErrJsonParse
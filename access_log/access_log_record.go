@@ -2,11 +2,14 @@ package access_log
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
+	"sync"
 	"time"
 
+	"github.com/cloudfoundry/gorouter/common/correlation"
 	"github.com/cloudfoundry/gorouter/route"
 )
 
@@ -18,6 +21,31 @@ type AccessLogRecord struct {
 	FirstByteAt   time.Time
 	FinishedAt    time.Time
 	BodyBytesSent int64
+	TraceId       string
+}
+
+// records and recordBuffers are reused from one request to the next, so
+// that logging does not add to the garbage of every request.
+var (
+	records       = sync.Pool{New: func() interface{} { return new(AccessLogRecord) }}
+	recordBuffers = sync.Pool{New: func() interface{} { return new(bytes.Buffer) }}
+)
+
+// NewAccessLogRecord returns a record of request, reusing one that was
+// released when it can.
+func NewAccessLogRecord(request *http.Request, startedAt time.Time) *AccessLogRecord {
+	r := records.Get().(*AccessLogRecord)
+	r.Request = request
+	r.StartedAt = startedAt
+	return r
+}
+
+// Release has r reused by NewAccessLogRecord. Loggers are handed copies of
+// records, so a record can be released once it is logged, and must not be
+// used after.
+func (r *AccessLogRecord) Release() {
+	*r = AccessLogRecord{}
+	records.Put(r)
 }
 
 func (r *AccessLogRecord) FormatStartedAt() string {
@@ -32,12 +60,37 @@ func (r *AccessLogRecord) FormatRequestHeader(k string) (v string) {
 	return
 }
 
+// RequestId returns the correlation request id of the request, falling back
+// to the X-Vcap-Request-Id header for requests that were not correlated.
+func (r *AccessLogRecord) RequestId() string {
+	if id, ok := correlation.FromRequest(r.Request); ok && id.RequestId != "" {
+		return id.RequestId
+	}
+	if r.Request == nil {
+		return ""
+	}
+	return r.Request.Header.Get("X-Vcap-Request-Id")
+}
+
+// CorrelationTraceId returns TraceId when it was set explicitly and the
+// trace id of the request's correlation id otherwise.
+func (r *AccessLogRecord) CorrelationTraceId() string {
+	if r.TraceId != "" {
+		return r.TraceId
+	}
+	id, _ := correlation.FromRequest(r.Request)
+	return id.TraceId
+}
+
 func (r *AccessLogRecord) ResponseTime() float64 {
 	return float64(r.FinishedAt.UnixNano()-r.StartedAt.UnixNano()) / float64(time.Second)
 }
 
+// makeRecord formats r in a buffer that is put back in recordBuffers once
+// it is written.
 func (r *AccessLogRecord) makeRecord() *bytes.Buffer {
-	b := &bytes.Buffer{}
+	b := recordBuffers.Get().(*bytes.Buffer)
+	b.Reset()
 	fmt.Fprintf(b, `%s - `, r.Request.Host)
 	fmt.Fprintf(b, `[%s] `, r.FormatStartedAt())
 	fmt.Fprintf(b, `"%s %s %s" `, r.Request.Method, r.Request.URL.RequestURI(), r.Request.Proto)
@@ -53,7 +106,11 @@ func (r *AccessLogRecord) makeRecord() *bytes.Buffer {
 	fmt.Fprintf(b, `"%s" `, r.FormatRequestHeader("User-Agent"))
 	fmt.Fprintf(b, `%s `, r.Request.RemoteAddr)
 	fmt.Fprintf(b, `x_forwarded_for:"%s" `, r.FormatRequestHeader("X-Forwarded-For"))
-	fmt.Fprintf(b, `vcap_request_id:%s `, r.FormatRequestHeader("X-Vcap-Request-Id"))
+	requestId := r.RequestId()
+	if requestId == "" {
+		requestId = "-"
+	}
+	fmt.Fprintf(b, `vcap_request_id:%s `, requestId)
 
 	if r.ResponseTime() < 0 {
 		fmt.Fprintf(b, "response_time:MissingFinishedAt ")
@@ -67,13 +124,79 @@ func (r *AccessLogRecord) makeRecord() *bytes.Buffer {
 		fmt.Fprintf(b, `app_id:%s`, r.RouteEndpoint.ApplicationId)
 	}
 
+	if traceId := r.CorrelationTraceId(); traceId != "" {
+		fmt.Fprintf(b, ` x_b3_traceid:%s`, traceId)
+	}
+
 	fmt.Fprint(b, "\n")
 	return b
 }
 
 func (r *AccessLogRecord) WriteTo(w io.Writer) (int64, error) {
 	recordBuffer := r.makeRecord()
-	return recordBuffer.WriteTo(w)
+	n, err := recordBuffer.WriteTo(w)
+	recordBuffers.Put(recordBuffer)
+	return n, err
+}
+
+type jsonAccessLogRecord struct {
+	Timestamp     string            `json:"timestamp"`
+	Host          string            `json:"host"`
+	Method        string            `json:"method"`
+	Path          string            `json:"path"`
+	Protocol      string            `json:"protocol"`
+	Status        int               `json:"status"`
+	BodyBytesSent int64             `json:"body_bytes_sent"`
+	Referer       string            `json:"referer"`
+	UserAgent     string            `json:"user_agent"`
+	RemoteAddr    string            `json:"remote_addr"`
+	ForwardedFor  string            `json:"x_forwarded_for"`
+	RequestId     string            `json:"vcap_request_id"`
+	ResponseTime  *float64          `json:"response_time"`
+	AppId         string            `json:"app_id"`
+	BackendAddr   string            `json:"backend_addr"`
+	TraceId       string            `json:"trace_id"`
+	Tags          map[string]string `json:"tags"`
+}
+
+// MarshalJSON renders the record as a flat object whose fields are always
+// present, but for the tags the endpoint was registered with, which are an
+// object of their own. Missing values are empty, except for a missing
+// response time, which is null.
+func (r *AccessLogRecord) MarshalJSON() ([]byte, error) {
+	j := jsonAccessLogRecord{
+		Timestamp:     r.StartedAt.Format(time.RFC3339Nano),
+		Status:        r.StatusCode,
+		BodyBytesSent: r.BodyBytesSent,
+		AppId:         r.ApplicationId(),
+		RequestId:     r.RequestId(),
+		TraceId:       r.CorrelationTraceId(),
+		Tags:          map[string]string{},
+	}
+
+	if r.Request != nil {
+		j.Host = r.Request.Host
+		j.Method = r.Request.Method
+		j.Path = r.Request.URL.RequestURI()
+		j.Protocol = r.Request.Proto
+		j.Referer = r.Request.Header.Get("Referer")
+		j.UserAgent = r.Request.Header.Get("User-Agent")
+		j.RemoteAddr = r.Request.RemoteAddr
+		j.ForwardedFor = r.Request.Header.Get("X-Forwarded-For")
+	}
+
+	if r.RouteEndpoint != nil {
+		j.BackendAddr = r.RouteEndpoint.CanonicalAddr()
+		for name, value := range r.RouteEndpoint.Tags {
+			j.Tags[name] = value
+		}
+	}
+
+	if t := r.ResponseTime(); t >= 0 {
+		j.ResponseTime = &t
+	}
+
+	return json.Marshal(j)
 }
 
 func (r *AccessLogRecord) ApplicationId() string {
@@ -90,5 +213,7 @@ func (r *AccessLogRecord) LogMessage() string {
 	}
 
 	recordBuffer := r.makeRecord()
-	return recordBuffer.String()
+	message := recordBuffer.String()
+	recordBuffers.Put(recordBuffer)
+	return message
 }
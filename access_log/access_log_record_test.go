@@ -3,11 +3,14 @@ package access_log_test
 import (
 	. "github.com/cloudfoundry/gorouter/access_log"
 
+	"github.com/cloudfoundry/gorouter/common/correlation"
 	router_http "github.com/cloudfoundry/gorouter/common/http"
 	"github.com/cloudfoundry/gorouter/route"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 
+	"bytes"
+	"encoding/json"
 	"net/http"
 	"net/url"
 	"time"
@@ -67,11 +70,104 @@ var _ = Describe("AccessLogRecord", func() {
 		Expect(record.LogMessage()).To(Equal(recordString))
 	})
 
+	It("Makes a record with the trace id", func() {
+		record := CompleteAccessLogRecord()
+		record.TraceId = "463ac35c9f6413ad48485a3953bb6124"
+
+		Expect(record.LogMessage()).To(HaveSuffix("app_id:FakeApplicationId x_b3_traceid:463ac35c9f6413ad48485a3953bb6124\n"))
+	})
+
+	It("Makes a record with the correlation id of the request", func() {
+		record := CompleteAccessLogRecord()
+		record.Request = correlation.WithID(record.Request, correlation.ID{
+			RequestId: "0f5a4d1e-5b8c-4b9a-9e1f-3c2d1a0b9e8f",
+			TraceId:   "4bf92f3577b34da6a3ce929d0e0e4736",
+		})
+
+		Expect(record.LogMessage()).To(ContainSubstring("vcap_request_id:0f5a4d1e-5b8c-4b9a-9e1f-3c2d1a0b9e8f "))
+		Expect(record.LogMessage()).To(HaveSuffix(" x_b3_traceid:4bf92f3577b34da6a3ce929d0e0e4736\n"))
+	})
+
+	It("Makes a JSON record with all values", func() {
+		record := CompleteAccessLogRecord()
+		record.TraceId = "463ac35c9f6413ad48485a3953bb6124"
+
+		b, err := json.Marshal(&record)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(b).To(MatchJSON(`{
+			"timestamp": "2000-01-01T00:00:00Z",
+			"host": "FakeRequestHost",
+			"method": "FakeRequestMethod",
+			"path": "http://example.com/request",
+			"protocol": "FakeRequestProto",
+			"status": 200,
+			"body_bytes_sent": 23,
+			"referer": "FakeReferer",
+			"user_agent": "FakeUserAgent",
+			"remote_addr": "FakeRemoteAddr",
+			"x_forwarded_for": "FakeProxy1, FakeProxy2",
+			"vcap_request_id": "abc-123-xyz-pdq",
+			"response_time": 60,
+			"app_id": "FakeApplicationId",
+			"backend_addr": "",
+			"trace_id": "463ac35c9f6413ad48485a3953bb6124",
+			"tags": {"space": "prod"}
+		}`))
+	})
+
+	It("Makes a JSON record with values missing", func() {
+		record := AccessLogRecord{
+			Request: &http.Request{
+				Host:   "FakeRequestHost",
+				Method: "GET",
+				URL:    &url.URL{Path: "/"},
+				Header: http.Header{},
+			},
+			RouteEndpoint: route.NewEndpoint("", "10.0.0.1", 8080, "", nil, -1),
+			StartedAt:     time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC),
+		}
+
+		b, err := json.Marshal(&record)
+		Expect(err).NotTo(HaveOccurred())
+
+		var fields map[string]interface{}
+		Expect(json.Unmarshal(b, &fields)).To(Succeed())
+		Expect(fields).To(HaveLen(17))
+		Expect(fields["tags"]).To(BeEmpty())
+		Expect(fields["status"]).To(BeNumerically("==", 0))
+		Expect(fields["response_time"]).To(BeNil())
+		Expect(fields["backend_addr"]).To(Equal("10.0.0.1:8080"))
+		Expect(fields["app_id"]).To(Equal(""))
+	})
+
 	It("does not create a log message when route endpoint missing", func() {
 		record := AccessLogRecord{}
 		Expect(record.LogMessage()).To(Equal(""))
 	})
 
+	It("makes the same record every time it is written", func() {
+		record := CompleteAccessLogRecord()
+		message := record.LogMessage()
+
+		var b bytes.Buffer
+		record.WriteTo(&b)
+		record.WriteTo(&b)
+		Expect(b.String()).To(Equal(message + message))
+	})
+
+	It("starts released records over", func() {
+		complete := CompleteAccessLogRecord()
+		record := NewAccessLogRecord(complete.Request, complete.StartedAt)
+		record.StatusCode = 200
+		record.RouteEndpoint = complete.RouteEndpoint
+		record.Release()
+
+		request := &http.Request{Host: "other.example.com"}
+		startedAt := time.Date(2001, time.January, 1, 0, 0, 0, 0, time.UTC)
+		record = NewAccessLogRecord(request, startedAt)
+		Expect(*record).To(Equal(AccessLogRecord{Request: request, StartedAt: startedAt}))
+	})
+
 })
 
 func CompleteAccessLogRecord() AccessLogRecord {
@@ -95,6 +191,7 @@ func CompleteAccessLogRecord() AccessLogRecord {
 		StatusCode:    200,
 		RouteEndpoint: &route.Endpoint{
 			ApplicationId: "FakeApplicationId",
+			Tags:          map[string]string{"space": "prod"},
 		},
 		StartedAt:  time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC),
 		FinishedAt: time.Date(2000, time.January, 1, 0, 1, 0, 0, time.UTC),
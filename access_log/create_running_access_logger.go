@@ -10,7 +10,7 @@ import (
 
 func CreateRunningAccessLogger(config *config.Config) (AccessLogger, error) {
 
-	if config.AccessLog == "" && !config.Logging.LoggregatorEnabled {
+	if config.AccessLog == "" && !config.Logging.LoggregatorEnabled && config.AccessLogSyslog.Address == "" && len(config.AccessLogKafka.Brokers) == 0 {
 		return &NullAccessLogger{}, nil
 	}
 
@@ -32,6 +32,17 @@ func CreateRunningAccessLogger(config *config.Config) (AccessLogger, error) {
 	}
 
 	accessLogger := NewFileAndLoggregatorAccessLogger(file, dropsondeSourceInstance)
+	accessLogger.SetFormat(config.AccessLogFormat)
+	accessLogger.SetBufferSize(config.AccessLogBufferSize)
+	if config.AccessLogSyslog.Address != "" {
+		accessLogger.SetSyslogWriter(NewSyslogWriter(config.AccessLogSyslog))
+	}
+	if len(config.AccessLogKafka.Brokers) > 0 {
+		k := config.AccessLogKafka
+		sink := NewKafkaSink(NewKafkaProducer(k.Brokers, k.ClientId, k.Timeout), k)
+		go sink.Run()
+		accessLogger.SetKafkaSink(sink)
+	}
 	go accessLogger.Run()
 	return accessLogger, nil
 }
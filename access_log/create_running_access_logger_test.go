@@ -37,6 +37,32 @@ var _ = Describe("AccessLog", func() {
 
 	})
 
+	It("uses the configured access log format", func() {
+		config := config.DefaultConfig()
+		config.AccessLog = "/dev/null"
+		config.AccessLogFormat = "json"
+
+		accessLogger, _ := CreateRunningAccessLogger(config)
+		Expect(accessLogger.(*FileAndLoggregatorAccessLogger).Format()).To(Equal("json"))
+	})
+
+	It("ships records to syslog when a syslog address is configured", func() {
+		config := config.DefaultConfig()
+		config.AccessLogSyslog.Address = "127.0.0.1:514"
+
+		accessLogger, _ := CreateRunningAccessLogger(config)
+		Expect(accessLogger.(*FileAndLoggregatorAccessLogger).FileWriter()).To(BeNil())
+		Expect(accessLogger.(*FileAndLoggregatorAccessLogger).SyslogWriter().Address()).To(Equal("127.0.0.1:514"))
+	})
+
+	It("sends records to kafka when brokers are configured", func() {
+		config := config.DefaultConfig()
+		config.AccessLogKafka.Brokers = []string{"127.0.0.1:9092"}
+
+		accessLogger, _ := CreateRunningAccessLogger(config)
+		Expect(accessLogger.(*FileAndLoggregatorAccessLogger).KafkaSink()).ToNot(BeNil())
+	})
+
 	It("creates an AccessLogger if both access log and loggregator is enabled", func() {
 		config := config.DefaultConfig()
 		config.Logging.LoggregatorEnabled = true
@@ -1,17 +1,40 @@
 package access_log
 
 import (
+	"bufio"
+	"encoding/json"
 	"io"
 	"regexp"
+	"sync/atomic"
+	"time"
 
 	"github.com/cloudfoundry/dropsonde/logs"
+	"github.com/cloudfoundry/gorouter/config"
+	steno "github.com/cloudfoundry/gosteno"
 )
 
+// dropReportInterval is how often the number of records dropped since the
+// last report is logged.
+const dropReportInterval = 10 * time.Second
+
+// FileAndLoggregatorAccessLogger hands records to its sinks from a
+// goroutine of its own, so that a slow disk or endpoint does not hold up
+// the requests being logged. Records wait in a bounded buffer meanwhile;
+// when it is full they are dropped and counted.
 type FileAndLoggregatorAccessLogger struct {
 	dropsondeSourceInstance string
 	channel                 chan AccessLogRecord
 	stopCh                  chan struct{}
 	writer                  io.Writer
+	buffer                  *bufio.Writer
+	format                  string
+	syslog                  *SyslogWriter
+	kafka                   *KafkaSink
+	syslogFailing           bool
+
+	sent          uint64
+	dropped       uint64
+	reportedDrops uint64
 }
 
 func NewFileAndLoggregatorAccessLogger(f io.Writer, dropsondeSourceInstance string) *FileAndLoggregatorAccessLogger {
@@ -20,28 +43,141 @@ func NewFileAndLoggregatorAccessLogger(f io.Writer, dropsondeSourceInstance stri
 		writer:                  f,
 		channel:                 make(chan AccessLogRecord, 128),
 		stopCh:                  make(chan struct{}),
+		format:                  config.AccessLogFormatText,
+	}
+
+	if f != nil {
+		a.buffer = bufio.NewWriter(f)
 	}
 
 	return a
 }
 
+// SetBufferSize lets up to n records wait to be written. It must be called
+// before the logger runs.
+func (x *FileAndLoggregatorAccessLogger) SetBufferSize(n int) {
+	x.channel = make(chan AccessLogRecord, n)
+}
+
+// SetFormat selects how records are written to the file. Records sent to
+// loggregator always use the text format.
+func (x *FileAndLoggregatorAccessLogger) SetFormat(format string) {
+	x.format = format
+}
+
+func (x *FileAndLoggregatorAccessLogger) Format() string {
+	return x.format
+}
+
+// SetSyslogWriter makes the logger also ship every record to syslog.
+func (x *FileAndLoggregatorAccessLogger) SetSyslogWriter(w *SyslogWriter) {
+	x.syslog = w
+}
+
+func (x *FileAndLoggregatorAccessLogger) SyslogWriter() *SyslogWriter {
+	return x.syslog
+}
+
+// SetKafkaSink makes the logger also queue every record for Kafka. The
+// sink runs on its own and is stopped along with the logger.
+func (x *FileAndLoggregatorAccessLogger) SetKafkaSink(s *KafkaSink) {
+	x.kafka = s
+}
+
+func (x *FileAndLoggregatorAccessLogger) KafkaSink() *KafkaSink {
+	return x.kafka
+}
+
+// Run hands the records to the sinks as they arrive. Writes to the file are
+// buffered, and flushed whenever no more records are waiting. Run returns
+// after Stop, once the records logged so far have been handed over.
 func (x *FileAndLoggregatorAccessLogger) Run() {
+	ticker := time.NewTicker(dropReportInterval)
+	defer ticker.Stop()
+
 	for {
 		select {
 		case record := <-x.channel:
-			if x.writer != nil {
-				record.WriteTo(x.writer)
-			}
-
-			if x.dropsondeSourceInstance != "" && record.ApplicationId() != "" {
-				logs.SendAppLog(record.ApplicationId(), record.LogMessage(), "RTR", x.dropsondeSourceInstance)
+			x.handle(&record)
+			if len(x.channel) == 0 {
+				x.flush()
 			}
+		case <-ticker.C:
+			x.reportDrops()
 		case <-x.stopCh:
-			return
+			for {
+				select {
+				case record := <-x.channel:
+					x.handle(&record)
+				default:
+					x.flush()
+					x.reportDrops()
+					return
+				}
+			}
 		}
 	}
 }
 
+func (x *FileAndLoggregatorAccessLogger) handle(record *AccessLogRecord) {
+	if x.buffer != nil {
+		x.write(record)
+	}
+
+	if x.syslog != nil {
+		x.writeSyslog(record)
+	}
+
+	if x.kafka != nil {
+		x.kafka.Log(record)
+	}
+
+	if x.dropsondeSourceInstance != "" && record.ApplicationId() != "" {
+		logs.SendAppLog(record.ApplicationId(), record.LogMessage(), "RTR", x.dropsondeSourceInstance)
+	}
+
+	atomic.AddUint64(&x.sent, 1)
+}
+
+func (x *FileAndLoggregatorAccessLogger) write(record *AccessLogRecord) {
+	if x.format != config.AccessLogFormatJSON {
+		record.WriteTo(x.buffer)
+		return
+	}
+
+	b, err := json.Marshal(record)
+	if err != nil {
+		return
+	}
+	x.buffer.Write(append(b, '\n'))
+}
+
+func (x *FileAndLoggregatorAccessLogger) flush() {
+	if x.buffer != nil {
+		x.buffer.Flush()
+	}
+}
+
+func (x *FileAndLoggregatorAccessLogger) reportDrops() {
+	dropped := x.Dropped()
+	if dropped != x.reportedDrops {
+		logger := steno.NewLogger("access_log")
+		logger.Warnf("Dropped %d access log records while the access log was falling behind", dropped-x.reportedDrops)
+		x.reportedDrops = dropped
+	}
+}
+
+// writeSyslog logs a failure once until the syslog endpoint accepts records
+// again, so an unreachable endpoint does not flood the router log.
+func (x *FileAndLoggregatorAccessLogger) writeSyslog(record *AccessLogRecord) {
+	err := x.syslog.WriteRecord(record)
+	if err != nil && !x.syslogFailing {
+		logger := steno.NewLogger("access_log")
+		logger.Warnf("Error writing access log to syslog %s: %s", x.syslog.Address(), err.Error())
+	}
+	x.syslogFailing = err != nil
+}
+
 func (x *FileAndLoggregatorAccessLogger) FileWriter() io.Writer {
 	return x.writer
 }
@@ -52,10 +188,28 @@ func (x *FileAndLoggregatorAccessLogger) DropsondeSourceInstance() string {
 
 func (x *FileAndLoggregatorAccessLogger) Stop() {
 	close(x.stopCh)
+	if x.kafka != nil {
+		x.kafka.Stop()
+	}
 }
 
+// Log queues r without blocking, and drops it when the buffer is full.
 func (x *FileAndLoggregatorAccessLogger) Log(r AccessLogRecord) {
-	x.channel <- r
+	select {
+	case x.channel <- r:
+	default:
+		atomic.AddUint64(&x.dropped, 1)
+	}
+}
+
+// Sent is the number of records handed to the sinks.
+func (x *FileAndLoggregatorAccessLogger) Sent() uint64 {
+	return atomic.LoadUint64(&x.sent)
+}
+
+// Dropped is the number of records dropped because the buffer was full.
+func (x *FileAndLoggregatorAccessLogger) Dropped() uint64 {
+	return atomic.LoadUint64(&x.dropped)
 }
 
 var ipAddressRegex, _ = regexp.Compile(`^(([0-9]|[1-9][0-9]|1[0-9]{2}|2[0-4][0-9]|25[0-5])\.){3}([0-9]|[1-9][0-9]|1[0-9]{2}|2[0-4][0-9]|25[0-5])(:[0-9]{1,5}){1}$`)
@@ -4,14 +4,17 @@ import (
 	"github.com/cloudfoundry/dropsonde/log_sender/fake"
 	"github.com/cloudfoundry/dropsonde/logs"
 	. "github.com/cloudfoundry/gorouter/access_log"
+	"github.com/cloudfoundry/gorouter/config"
 	"github.com/cloudfoundry/gorouter/route"
 	"github.com/cloudfoundry/gorouter/test_util"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 
+	"encoding/json"
 	"net/http"
 	"net/url"
+	"sync/atomic"
 	"time"
 )
 
@@ -76,6 +79,64 @@ var _ = Describe("AccessLog", func() {
 
 			accessLogger.Stop()
 		})
+
+		It("writes JSON records to the log file", func() {
+			var fakeFile = new(test_util.FakeFile)
+
+			accessLogger := NewFileAndLoggregatorAccessLogger(fakeFile, "")
+			accessLogger.SetFormat(config.AccessLogFormatJSON)
+			go accessLogger.Run()
+			accessLogger.Log(*CreateAccessLogRecord())
+
+			var payload []byte
+			Eventually(func() int {
+				n, _ := fakeFile.Read(&payload)
+				return n
+			}).ShouldNot(Equal(0))
+			Ω(string(payload)).To(HaveSuffix("}\n"))
+
+			var fields map[string]interface{}
+			Ω(json.Unmarshal(payload, &fields)).To(Succeed())
+			Ω(fields["host"]).To(Equal("foo.bar"))
+
+			accessLogger.Stop()
+		})
+
+		It("drops and counts records while the file is too slow to take them", func() {
+			file := &slowFile{release: make(chan struct{})}
+
+			accessLogger := NewFileAndLoggregatorAccessLogger(file, "")
+			accessLogger.SetBufferSize(2)
+			go accessLogger.Run()
+
+			accessLogger.Log(*CreateAccessLogRecord())
+			Eventually(accessLogger.Sent).Should(BeEquivalentTo(1))
+			Eventually(file.Writes).Should(Equal(1))
+
+			for i := 0; i < 5; i++ {
+				accessLogger.Log(*CreateAccessLogRecord())
+			}
+			Ω(accessLogger.Dropped()).To(BeEquivalentTo(3))
+
+			close(file.release)
+			Eventually(accessLogger.Sent).Should(BeEquivalentTo(3))
+
+			accessLogger.Stop()
+		})
+
+		It("writes the records that are waiting when stopped", func() {
+			var fakeFile = new(test_util.FakeFile)
+
+			accessLogger := NewFileAndLoggregatorAccessLogger(fakeFile, "")
+			accessLogger.Log(*CreateAccessLogRecord())
+			accessLogger.Stop()
+			accessLogger.Run()
+
+			var payload []byte
+			fakeFile.Read(&payload)
+			Ω(string(payload)).To(MatchRegexp("^.*foo.bar.*\n"))
+			Ω(accessLogger.Sent()).To(BeEquivalentTo(1))
+		})
 	})
 
 	Measure("Log write speed", func(b Benchmarker) {
@@ -132,3 +193,19 @@ type nullWriter struct{}
 func (n nullWriter) Write(b []byte) (int, error) {
 	return len(b), nil
 }
+
+// slowFile takes writes only once released.
+type slowFile struct {
+	release chan struct{}
+	writes  int32
+}
+
+func (f *slowFile) Write(b []byte) (int, error) {
+	atomic.AddInt32(&f.writes, 1)
+	<-f.release
+	return len(b), nil
+}
+
+func (f *slowFile) Writes() int {
+	return int(atomic.LoadInt32(&f.writes))
+}
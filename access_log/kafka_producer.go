@@ -0,0 +1,346 @@
+package access_log
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+const (
+	kafkaApiProduce  = 0
+	kafkaApiMetadata = 3
+
+	kafkaMaxResponseSize = 64 * 1024 * 1024
+)
+
+var noKafkaPartitions = errors.New("kafka topic has no available partitions")
+
+type KafkaProducer interface {
+	Produce(topic string, messages [][]byte) error
+	Close()
+}
+
+// kafkaProducer speaks version 0 of the Kafka produce and metadata APIs,
+// which every broker supports. It waits for the partition leader to
+// acknowledge each batch and spreads batches over the partitions of a topic
+// in turn. It is not safe for concurrent use.
+type kafkaProducer struct {
+	brokers  []string
+	clientId string
+	timeout  time.Duration
+
+	correlationId int32
+	conns         map[string]net.Conn
+	leaders       map[string][]string
+	next          map[string]int
+}
+
+func NewKafkaProducer(brokers []string, clientId string, timeout time.Duration) KafkaProducer {
+	return &kafkaProducer{
+		brokers:  brokers,
+		clientId: clientId,
+		timeout:  timeout,
+		conns:    make(map[string]net.Conn),
+		leaders:  make(map[string][]string),
+		next:     make(map[string]int),
+	}
+}
+
+// Produce sends messages to one partition of topic. On failure the
+// producer forgets its connections and metadata so the next call starts
+// afresh.
+func (p *kafkaProducer) Produce(topic string, messages [][]byte) error {
+	err := p.produce(topic, messages)
+	if err != nil {
+		p.Close()
+		delete(p.leaders, topic)
+	}
+	return err
+}
+
+func (p *kafkaProducer) Close() {
+	for addr, conn := range p.conns {
+		conn.Close()
+		delete(p.conns, addr)
+	}
+}
+
+func (p *kafkaProducer) produce(topic string, messages [][]byte) error {
+	leaders, ok := p.leaders[topic]
+	if !ok {
+		var err error
+		leaders, err = p.fetchLeaders(topic)
+		if err != nil {
+			return err
+		}
+		p.leaders[topic] = leaders
+	}
+
+	partition, leader := p.pickPartition(topic, leaders)
+	if leader == "" {
+		return noKafkaPartitions
+	}
+
+	req := &kafkaEncoder{}
+	req.int16(1) // acks: the leader
+	req.int32(int32(p.timeout / time.Millisecond))
+	req.int32(1)
+	req.string(topic)
+	req.int32(1)
+	req.int32(int32(partition))
+	req.bytes(encodeKafkaMessageSet(messages))
+
+	resp, err := p.roundTrip(leader, kafkaApiProduce, req.Bytes())
+	if err != nil {
+		return err
+	}
+
+	// topics, topic name, partitions, partition id, error code
+	d := &kafkaDecoder{b: resp}
+	d.int32()
+	d.string()
+	d.int32()
+	d.int32()
+	code := d.int16()
+	if d.err != nil {
+		return d.err
+	}
+	if code != 0 {
+		return fmt.Errorf("kafka produce to %s/%d failed with error code %d", topic, partition, code)
+	}
+
+	return nil
+}
+
+// pickPartition returns the next partition of topic that has a leader.
+func (p *kafkaProducer) pickPartition(topic string, leaders []string) (int, string) {
+	for i := 0; i < len(leaders); i++ {
+		partition := (p.next[topic] + i) % len(leaders)
+		if leaders[partition] != "" {
+			p.next[topic] = partition + 1
+			return partition, leaders[partition]
+		}
+	}
+	return 0, ""
+}
+
+// fetchLeaders asks the configured brokers in turn for the leader of every
+// partition of topic. Partitions without a leader map to "".
+func (p *kafkaProducer) fetchLeaders(topic string) ([]string, error) {
+	req := &kafkaEncoder{}
+	req.int32(1)
+	req.string(topic)
+
+	var err error
+	for _, broker := range p.brokers {
+		var resp []byte
+		resp, err = p.roundTrip(broker, kafkaApiMetadata, req.Bytes())
+		if err != nil {
+			continue
+		}
+
+		var leaders []string
+		leaders, err = decodeKafkaLeaders(resp, topic)
+		if err == nil {
+			return leaders, nil
+		}
+	}
+
+	if err == nil {
+		err = errors.New("no kafka brokers configured")
+	}
+	return nil, err
+}
+
+func decodeKafkaLeaders(resp []byte, topic string) ([]string, error) {
+	d := &kafkaDecoder{b: resp}
+
+	brokers := make(map[int32]string)
+	for n := d.int32(); n > 0 && d.err == nil; n-- {
+		id := d.int32()
+		host := d.string()
+		port := d.int32()
+		brokers[id] = net.JoinHostPort(host, strconv.Itoa(int(port)))
+	}
+
+	var leaders []string
+	for n := d.int32(); n > 0 && d.err == nil; n-- {
+		code := d.int16()
+		name := d.string()
+
+		partitions := make(map[int32]string)
+		max := int32(-1)
+		for m := d.int32(); m > 0 && d.err == nil; m-- {
+			d.int16()
+			partition := d.int32()
+			leader := d.int32()
+			d.skipInt32Array()
+			d.skipInt32Array()
+
+			partitions[partition] = brokers[leader]
+			if partition > max {
+				max = partition
+			}
+		}
+
+		if name != topic {
+			continue
+		}
+		if code != 0 {
+			return nil, fmt.Errorf("kafka metadata for %s failed with error code %d", topic, code)
+		}
+
+		leaders = make([]string, max+1)
+		for partition, addr := range partitions {
+			leaders[partition] = addr
+		}
+	}
+
+	if d.err != nil {
+		return nil, d.err
+	}
+	if len(leaders) == 0 {
+		return nil, noKafkaPartitions
+	}
+	return leaders, nil
+}
+
+// roundTrip sends a request to addr and returns the response body that
+// follows the correlation id.
+func (p *kafkaProducer) roundTrip(addr string, apiKey int16, body []byte) ([]byte, error) {
+	conn, ok := p.conns[addr]
+	if !ok {
+		var err error
+		conn, err = net.DialTimeout("tcp", addr, p.timeout)
+		if err != nil {
+			return nil, err
+		}
+		p.conns[addr] = conn
+	}
+
+	p.correlationId++
+
+	header := &kafkaEncoder{}
+	header.int16(apiKey)
+	header.int16(0)
+	header.int32(p.correlationId)
+	header.string(p.clientId)
+
+	msg := &kafkaEncoder{}
+	msg.int32(int32(header.Len() + len(body)))
+	msg.Write(header.Bytes())
+	msg.Write(body)
+
+	conn.SetDeadline(time.Now().Add(p.timeout))
+	_, err := conn.Write(msg.Bytes())
+	if err != nil {
+		return nil, err
+	}
+
+	var size int32
+	err = binary.Read(conn, binary.BigEndian, &size)
+	if err != nil {
+		return nil, err
+	}
+	if size < 4 || size > kafkaMaxResponseSize {
+		return nil, fmt.Errorf("invalid kafka response size %d", size)
+	}
+
+	resp := make([]byte, size)
+	_, err = io.ReadFull(conn, resp)
+	if err != nil {
+		return nil, err
+	}
+
+	if int32(binary.BigEndian.Uint32(resp)) != p.correlationId {
+		return nil, errors.New("kafka response does not match its request")
+	}
+
+	return resp[4:], nil
+}
+
+// encodeKafkaMessageSet encodes messages as version 0 messages without keys.
+func encodeKafkaMessageSet(messages [][]byte) []byte {
+	set := &kafkaEncoder{}
+	for _, value := range messages {
+		msg := &kafkaEncoder{}
+		msg.WriteByte(0) // magic
+		msg.WriteByte(0) // attributes: no compression
+		msg.int32(-1)    // null key
+		msg.bytes(value)
+
+		set.int64(0)
+		set.int32(int32(4 + msg.Len()))
+		set.int32(int32(crc32.ChecksumIEEE(msg.Bytes())))
+		set.Write(msg.Bytes())
+	}
+	return set.Bytes()
+}
+
+type kafkaEncoder struct {
+	bytes.Buffer
+}
+
+func (e *kafkaEncoder) int16(v int16) { binary.Write(e, binary.BigEndian, v) }
+func (e *kafkaEncoder) int32(v int32) { binary.Write(e, binary.BigEndian, v) }
+func (e *kafkaEncoder) int64(v int64) { binary.Write(e, binary.BigEndian, v) }
+
+func (e *kafkaEncoder) string(s string) {
+	e.int16(int16(len(s)))
+	e.WriteString(s)
+}
+
+func (e *kafkaEncoder) bytes(b []byte) {
+	e.int32(int32(len(b)))
+	e.Write(b)
+}
+
+// kafkaDecoder reads big-endian values from b. After the first short read
+// it returns zero values and keeps the error in err.
+type kafkaDecoder struct {
+	b   []byte
+	err error
+}
+
+func (d *kafkaDecoder) take(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n < 0 || len(d.b) < n {
+		d.err = errors.New("short kafka response")
+		return nil
+	}
+	v := d.b[:n]
+	d.b = d.b[n:]
+	return v
+}
+
+func (d *kafkaDecoder) int16() int16 {
+	b := d.take(2)
+	if b == nil {
+		return 0
+	}
+	return int16(binary.BigEndian.Uint16(b))
+}
+
+func (d *kafkaDecoder) int32() int32 {
+	b := d.take(4)
+	if b == nil {
+		return 0
+	}
+	return int32(binary.BigEndian.Uint32(b))
+}
+
+func (d *kafkaDecoder) string() string {
+	return string(d.take(int(d.int16())))
+}
+
+func (d *kafkaDecoder) skipInt32Array() {
+	d.take(4 * int(d.int32()))
+}
@@ -0,0 +1,225 @@
+package access_log_test
+
+import (
+	. "github.com/cloudfoundry/gorouter/access_log"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type producedBatch struct {
+	partition int32
+	values    []string
+}
+
+// fakeKafkaBroker answers metadata requests for a topic with two
+// partitions led by itself and records the messages it is sent.
+type fakeKafkaBroker struct {
+	sync.Mutex
+	listener  net.Listener
+	produced  []producedBatch
+	errorCode int16
+}
+
+func newFakeKafkaBroker() *fakeKafkaBroker {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	Ω(err).NotTo(HaveOccurred())
+
+	b := &fakeKafkaBroker{listener: listener}
+	go b.serve()
+	return b
+}
+
+func (b *fakeKafkaBroker) Addr() string {
+	return b.listener.Addr().String()
+}
+
+func (b *fakeKafkaBroker) Close() {
+	b.listener.Close()
+}
+
+func (b *fakeKafkaBroker) Produced() []producedBatch {
+	b.Lock()
+	defer b.Unlock()
+	return b.produced
+}
+
+func (b *fakeKafkaBroker) serve() {
+	for {
+		conn, err := b.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		go func() {
+			defer conn.Close()
+			for b.handle(conn) == nil {
+			}
+		}()
+	}
+}
+
+func (b *fakeKafkaBroker) handle(conn net.Conn) error {
+	var size int32
+	err := binary.Read(conn, binary.BigEndian, &size)
+	if err != nil {
+		return err
+	}
+
+	req := make([]byte, size)
+	_, err = io.ReadFull(conn, req)
+	if err != nil {
+		return err
+	}
+
+	r := bytes.NewReader(req)
+	apiKey := readInt16(r)
+	readInt16(r)
+	correlationId := readInt32(r)
+	readString(r)
+
+	resp := &bytes.Buffer{}
+	writeInt32(resp, correlationId)
+
+	switch apiKey {
+	case 3:
+		host, port, _ := net.SplitHostPort(b.Addr())
+		p, _ := strconv.Atoi(port)
+
+		writeInt32(resp, 1)
+		writeInt32(resp, 7)
+		writeString(resp, host)
+		writeInt32(resp, int32(p))
+
+		readInt32(r)
+		topic := readString(r)
+		writeInt32(resp, 1)
+		writeInt16(resp, 0)
+		writeString(resp, topic)
+		writeInt32(resp, 2)
+		for partition := int32(0); partition < 2; partition++ {
+			writeInt16(resp, 0)
+			writeInt32(resp, partition)
+			writeInt32(resp, 7)
+			writeInt32(resp, 1)
+			writeInt32(resp, 7)
+			writeInt32(resp, 1)
+			writeInt32(resp, 7)
+		}
+	case 0:
+		readInt16(r)
+		readInt32(r)
+		readInt32(r)
+		topic := readString(r)
+		readInt32(r)
+		partition := readInt32(r)
+		set := make([]byte, readInt32(r))
+		io.ReadFull(r, set)
+
+		b.Lock()
+		b.produced = append(b.produced, producedBatch{partition: partition, values: decodeMessageSet(set)})
+		errorCode := b.errorCode
+		b.Unlock()
+
+		writeInt32(resp, 1)
+		writeString(resp, topic)
+		writeInt32(resp, 1)
+		writeInt32(resp, partition)
+		writeInt16(resp, errorCode)
+		binary.Write(resp, binary.BigEndian, int64(0))
+	}
+
+	binary.Write(conn, binary.BigEndian, int32(resp.Len()))
+	_, err = conn.Write(resp.Bytes())
+	return err
+}
+
+func decodeMessageSet(set []byte) []string {
+	var values []string
+	r := bytes.NewReader(set)
+	for r.Len() > 0 {
+		var offset int64
+		binary.Read(r, binary.BigEndian, &offset)
+		msg := make([]byte, readInt32(r))
+		io.ReadFull(r, msg)
+
+		Ω(binary.BigEndian.Uint32(msg)).To(Equal(crc32.ChecksumIEEE(msg[4:])))
+
+		m := bytes.NewReader(msg[6:])
+		Ω(readInt32(m)).To(Equal(int32(-1)))
+		value := make([]byte, readInt32(m))
+		io.ReadFull(m, value)
+		values = append(values, string(value))
+	}
+	return values
+}
+
+func readInt16(r io.Reader) (v int16) {
+	binary.Read(r, binary.BigEndian, &v)
+	return
+}
+
+func readInt32(r io.Reader) (v int32) {
+	binary.Read(r, binary.BigEndian, &v)
+	return
+}
+
+func readString(r io.Reader) string {
+	b := make([]byte, readInt16(r))
+	io.ReadFull(r, b)
+	return string(b)
+}
+
+func writeInt16(w io.Writer, v int16) { binary.Write(w, binary.BigEndian, v) }
+func writeInt32(w io.Writer, v int32) { binary.Write(w, binary.BigEndian, v) }
+
+func writeString(w io.Writer, s string) {
+	writeInt16(w, int16(len(s)))
+	io.WriteString(w, s)
+}
+
+var _ = Describe("KafkaProducer", func() {
+	var broker *fakeKafkaBroker
+	var producer KafkaProducer
+
+	BeforeEach(func() {
+		broker = newFakeKafkaBroker()
+		producer = NewKafkaProducer([]string{"127.0.0.1:1", broker.Addr()}, "gorouter", time.Second)
+	})
+
+	AfterEach(func() {
+		producer.Close()
+		broker.Close()
+	})
+
+	It("sends batches to the partition leaders in turn", func() {
+		Ω(producer.Produce("router-logs", [][]byte{[]byte("one"), []byte("two")})).To(Succeed())
+		Ω(producer.Produce("router-logs", [][]byte{[]byte("three")})).To(Succeed())
+
+		Ω(broker.Produced()).To(Equal([]producedBatch{
+			{partition: 0, values: []string{"one", "two"}},
+			{partition: 1, values: []string{"three"}},
+		}))
+	})
+
+	It("reports errors returned by the broker", func() {
+		broker.errorCode = 6
+
+		Ω(producer.Produce("router-logs", [][]byte{[]byte("one")})).ToNot(Succeed())
+	})
+
+	It("reports an error when no broker is reachable", func() {
+		producer = NewKafkaProducer([]string{"127.0.0.1:1"}, "gorouter", time.Second)
+
+		Ω(producer.Produce("router-logs", [][]byte{[]byte("one")})).ToNot(Succeed())
+	})
+})
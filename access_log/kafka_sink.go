@@ -0,0 +1,149 @@
+package access_log
+
+import (
+	"encoding/json"
+	"sync/atomic"
+	"time"
+
+	"github.com/cloudfoundry/gorouter/config"
+	"github.com/cloudfoundry/gorouter/telemetry"
+	steno "github.com/cloudfoundry/gosteno"
+)
+
+// KafkaSink batches access log records as JSON messages into a Kafka topic.
+// Records wait in a bounded buffer while a batch is being sent. When the
+// buffer is full, or a batch cannot be delivered, records are dropped and
+// counted rather than slowing down the proxy.
+type KafkaSink struct {
+	producer      KafkaProducer
+	topic         string
+	batchSize     int
+	flushInterval time.Duration
+
+	records chan []byte
+	stopCh  chan struct{}
+	doneCh  chan struct{}
+	logger  *steno.Logger
+	health  *telemetry.Sink
+
+	sent          uint64
+	dropped       uint64
+	reportedDrops uint64
+}
+
+func NewKafkaSink(producer KafkaProducer, c config.AccessLogKafkaConfig) *KafkaSink {
+	return &KafkaSink{
+		producer:      producer,
+		topic:         c.Topic,
+		batchSize:     c.BatchSize,
+		flushInterval: c.FlushInterval,
+
+		records: make(chan []byte, c.BufferSize),
+		stopCh:  make(chan struct{}),
+		doneCh:  make(chan struct{}),
+		logger:  steno.NewLogger("access_log.kafka"),
+	}
+}
+
+// SetHealth has the outcome of every batch recorded in h.
+func (s *KafkaSink) SetHealth(h *telemetry.Sink) {
+	s.health = h
+}
+
+// Log queues r without blocking.
+func (s *KafkaSink) Log(r *AccessLogRecord) {
+	b, err := json.Marshal(r)
+	if err != nil {
+		atomic.AddUint64(&s.dropped, 1)
+		return
+	}
+
+	select {
+	case s.records <- b:
+	default:
+		atomic.AddUint64(&s.dropped, 1)
+	}
+}
+
+func (s *KafkaSink) Sent() uint64 {
+	return atomic.LoadUint64(&s.sent)
+}
+
+func (s *KafkaSink) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+// Run sends a batch when it is full or when the flush interval passes,
+// whichever comes first. It returns after Stop, once the records queued so
+// far have been sent.
+func (s *KafkaSink) Run() {
+	defer close(s.doneCh)
+	defer s.producer.Close()
+
+	ticker := time.NewTicker(s.flushInterval)
+	defer ticker.Stop()
+
+	batch := make([][]byte, 0, s.batchSize)
+	for {
+		select {
+		case b := <-s.records:
+			batch = append(batch, b)
+			if len(batch) >= s.batchSize {
+				batch = s.flush(batch)
+			}
+		case <-ticker.C:
+			batch = s.flush(batch)
+			s.reportDrops()
+		case <-s.stopCh:
+			for {
+				select {
+				case b := <-s.records:
+					batch = append(batch, b)
+					if len(batch) >= s.batchSize {
+						batch = s.flush(batch)
+					}
+				default:
+					s.flush(batch)
+					return
+				}
+			}
+		}
+	}
+}
+
+func (s *KafkaSink) Stop() {
+	close(s.stopCh)
+	<-s.doneCh
+}
+
+// flush sends batch, retrying once since the producer refreshes its
+// metadata after a failure, and returns an empty batch to reuse.
+func (s *KafkaSink) flush(batch [][]byte) [][]byte {
+	if len(batch) == 0 {
+		return batch
+	}
+
+	err := s.producer.Produce(s.topic, batch)
+	if err != nil {
+		err = s.producer.Produce(s.topic, batch)
+	}
+
+	if err != nil {
+		atomic.AddUint64(&s.dropped, uint64(len(batch)))
+		s.logger.Warnf("Error sending access log records to kafka topic %s: %s", s.topic, err.Error())
+		s.health.Failed(err)
+	} else {
+		atomic.AddUint64(&s.sent, uint64(len(batch)))
+		s.health.Succeeded()
+	}
+
+	return batch[:0]
+}
+
+func (s *KafkaSink) reportDrops() {
+	dropped := s.Dropped()
+	if dropped != s.reportedDrops {
+		s.logger.Warnf("Dropped %d access log records for kafka topic %s", dropped-s.reportedDrops, s.topic)
+		s.reportedDrops = dropped
+	}
+}
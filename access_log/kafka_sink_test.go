@@ -0,0 +1,165 @@
+package access_log_test
+
+import (
+	. "github.com/cloudfoundry/gorouter/access_log"
+
+	"github.com/cloudfoundry/gorouter/clock"
+	"github.com/cloudfoundry/gorouter/config"
+	"github.com/cloudfoundry/gorouter/telemetry"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+)
+
+type fakeKafkaProducer struct {
+	sync.Mutex
+	batches [][][]byte
+	err     error
+	block   chan struct{}
+	closed  bool
+}
+
+func (f *fakeKafkaProducer) Produce(topic string, messages [][]byte) error {
+	if f.block != nil {
+		<-f.block
+	}
+
+	f.Lock()
+	defer f.Unlock()
+
+	if f.err != nil {
+		return f.err
+	}
+
+	batch := make([][]byte, len(messages))
+	copy(batch, messages)
+	f.batches = append(f.batches, batch)
+	return nil
+}
+
+func (f *fakeKafkaProducer) Close() {
+	f.Lock()
+	f.closed = true
+	f.Unlock()
+}
+
+func (f *fakeKafkaProducer) Batches() [][][]byte {
+	f.Lock()
+	defer f.Unlock()
+	return f.batches
+}
+
+var _ = Describe("KafkaSink", func() {
+	var producer *fakeKafkaProducer
+	var sinkConfig config.AccessLogKafkaConfig
+
+	BeforeEach(func() {
+		producer = &fakeKafkaProducer{}
+		sinkConfig = config.AccessLogKafkaConfig{
+			Topic:         "router-logs",
+			BatchSize:     2,
+			BufferSize:    10,
+			FlushInterval: time.Hour,
+		}
+	})
+
+	It("sends full batches of JSON records", func() {
+		sink := NewKafkaSink(producer, sinkConfig)
+		go sink.Run()
+		defer sink.Stop()
+
+		record := CompleteAccessLogRecord()
+		sink.Log(&record)
+		sink.Log(&record)
+
+		Eventually(producer.Batches).Should(HaveLen(1))
+		Ω(producer.Batches()[0]).To(HaveLen(2))
+
+		var fields map[string]interface{}
+		Ω(json.Unmarshal(producer.Batches()[0][0], &fields)).To(Succeed())
+		Ω(fields["host"]).To(Equal("FakeRequestHost"))
+		Eventually(sink.Sent).Should(Equal(uint64(2)))
+	})
+
+	It("sends partial batches when the flush interval passes", func() {
+		sinkConfig.FlushInterval = 10 * time.Millisecond
+		sink := NewKafkaSink(producer, sinkConfig)
+		go sink.Run()
+		defer sink.Stop()
+
+		record := CompleteAccessLogRecord()
+		sink.Log(&record)
+
+		Eventually(producer.Batches).Should(HaveLen(1))
+		Ω(producer.Batches()[0]).To(HaveLen(1))
+	})
+
+	It("sends the queued records when stopped", func() {
+		sinkConfig.BatchSize = 100
+		sink := NewKafkaSink(producer, sinkConfig)
+		go sink.Run()
+
+		record := CompleteAccessLogRecord()
+		sink.Log(&record)
+		sink.Log(&record)
+		sink.Stop()
+
+		Ω(producer.Batches()).To(HaveLen(1))
+		Ω(producer.Batches()[0]).To(HaveLen(2))
+		Ω(producer.closed).To(BeTrue())
+	})
+
+	It("drops records when the buffer is full", func() {
+		producer.block = make(chan struct{})
+		sinkConfig.BatchSize = 1
+		sinkConfig.BufferSize = 1
+		sink := NewKafkaSink(producer, sinkConfig)
+		go sink.Run()
+
+		record := CompleteAccessLogRecord()
+		for i := 0; i < 10; i++ {
+			sink.Log(&record)
+		}
+
+		Ω(sink.Dropped()).To(BeNumerically(">=", 8))
+
+		close(producer.block)
+		sink.Stop()
+	})
+
+	It("counts the records of batches that cannot be delivered as dropped", func() {
+		producer.err = errors.New("broker unavailable")
+		sink := NewKafkaSink(producer, sinkConfig)
+		go sink.Run()
+		defer sink.Stop()
+
+		record := CompleteAccessLogRecord()
+		sink.Log(&record)
+		sink.Log(&record)
+
+		Eventually(sink.Dropped).Should(Equal(uint64(2)))
+		Ω(sink.Sent()).To(BeZero())
+	})
+
+	It("records the outcome of batches in its health", func() {
+		health := telemetry.NewHealth(clock.New())
+		producer.err = errors.New("broker unavailable")
+		sink := NewKafkaSink(producer, sinkConfig)
+		sink.SetHealth(health.AddSink("kafka", 0, nil))
+		go sink.Run()
+		defer sink.Stop()
+
+		record := CompleteAccessLogRecord()
+		sink.Log(&record)
+		sink.Log(&record)
+
+		Eventually(func() string {
+			return health.Report().Sinks[0].Status
+		}).Should(Equal(telemetry.StatusFailing))
+		Ω(health.Report().Sinks[0].LastError).To(Equal("broker unavailable"))
+	})
+})
@@ -0,0 +1,195 @@
+package access_log
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cloudfoundry/gorouter/config"
+	"github.com/cloudfoundry/gorouter/telemetry"
+)
+
+const (
+	// local0.info
+	syslogPriority = 16*8 + 6
+
+	syslogMsgId          = "access"
+	syslogStructuredData = "access@47450"
+	syslogTimestamp      = "2006-01-02T15:04:05.000000Z07:00"
+	syslogDialTimeout    = 5 * time.Second
+	syslogWriteTimeout   = 5 * time.Second
+)
+
+// SyslogWriter ships access log records to a syslog endpoint as RFC5424
+// messages. Messages sent over UDP take one datagram each; over TCP and TLS
+// they are framed by octet counting as described in RFC6587 and RFC5425.
+//
+// A SyslogWriter is not safe for concurrent use.
+type SyslogWriter struct {
+	network   string
+	address   string
+	appName   string
+	hostname  string
+	procId    string
+	tlsConfig *tls.Config
+	health    *telemetry.Sink
+
+	conn net.Conn
+}
+
+func NewSyslogWriter(c config.AccessLogSyslogConfig) *SyslogWriter {
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		hostname = "-"
+	}
+
+	w := &SyslogWriter{
+		network:  c.Network,
+		address:  c.Address,
+		appName:  syslogHeaderField(c.AppName, 48),
+		hostname: syslogHeaderField(hostname, 255),
+		procId:   strconv.Itoa(os.Getpid()),
+	}
+
+	if c.Network == config.SyslogNetworkTLS {
+		w.tlsConfig = &tls.Config{InsecureSkipVerify: c.InsecureSkipVerify}
+	}
+
+	return w
+}
+
+func (w *SyslogWriter) Address() string {
+	return w.address
+}
+
+// SetHealth has the outcome of every record recorded in s.
+func (w *SyslogWriter) SetHealth(s *telemetry.Sink) {
+	w.health = s
+}
+
+// WriteRecord sends r, connecting first when there is no connection. After
+// a failed write the connection is dropped and the next record reconnects.
+func (w *SyslogWriter) WriteRecord(r *AccessLogRecord) error {
+	if w.conn == nil {
+		err := w.connect()
+		if err != nil {
+			w.health.Failed(err)
+			return err
+		}
+	}
+
+	msg := w.message(r)
+	if w.network != config.SyslogNetworkUDP {
+		msg = append([]byte(strconv.Itoa(len(msg))+" "), msg...)
+	}
+
+	w.conn.SetWriteDeadline(time.Now().Add(syslogWriteTimeout))
+	_, err := w.conn.Write(msg)
+	if err != nil {
+		w.Close()
+		w.health.Failed(err)
+	} else {
+		w.health.Succeeded()
+	}
+	return err
+}
+
+func (w *SyslogWriter) Close() {
+	if w.conn != nil {
+		w.conn.Close()
+		w.conn = nil
+	}
+}
+
+func (w *SyslogWriter) connect() error {
+	var conn net.Conn
+	var err error
+
+	switch w.network {
+	case config.SyslogNetworkTLS:
+		dialer := &net.Dialer{Timeout: syslogDialTimeout}
+		conn, err = tls.DialWithDialer(dialer, "tcp", w.address, w.tlsConfig)
+	default:
+		conn, err = net.DialTimeout(w.network, w.address, syslogDialTimeout)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	w.conn = conn
+	return nil
+}
+
+// message renders r as an RFC5424 message whose structured data carries
+// the fields that are most useful for filtering and whose free-form part is
+// the text access log line.
+func (w *SyslogWriter) message(r *AccessLogRecord) []byte {
+	b := &bytes.Buffer{}
+
+	fmt.Fprintf(b, "<%d>1 %s %s %s %s %s ",
+		syslogPriority,
+		r.StartedAt.Format(syslogTimestamp),
+		w.hostname,
+		w.appName,
+		w.procId,
+		syslogMsgId,
+	)
+
+	b.WriteString("[" + syslogStructuredData)
+	if r.Request != nil {
+		writeSyslogParam(b, "host", r.Request.Host)
+		writeSyslogParam(b, "method", r.Request.Method)
+		writeSyslogParam(b, "path", r.Request.URL.RequestURI())
+	}
+	writeSyslogParam(b, "status", strconv.Itoa(r.StatusCode))
+	writeSyslogParam(b, "app_id", r.ApplicationId())
+	writeSyslogParam(b, "vcap_request_id", r.RequestId())
+	writeSyslogParam(b, "trace_id", r.CorrelationTraceId())
+	b.WriteString("] ")
+
+	b.WriteString(strings.TrimSuffix(r.LogMessage(), "\n"))
+
+	return b.Bytes()
+}
+
+// writeSyslogParam writes an SD-PARAM, skipping empty values and escaping
+// the characters RFC5424 reserves inside PARAM-VALUE.
+func writeSyslogParam(b *bytes.Buffer, name, value string) {
+	if value == "" {
+		return
+	}
+
+	fmt.Fprintf(b, ` %s="`, name)
+	for _, c := range value {
+		if c == '"' || c == '\\' || c == ']' {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(c)
+	}
+	b.WriteByte('"')
+}
+
+// syslogHeaderField makes value a valid header field: printable ASCII
+// without spaces, at most max characters long, and "-" when empty.
+func syslogHeaderField(value string, max int) string {
+	field := strings.Map(func(c rune) rune {
+		if c < 33 || c > 126 {
+			return -1
+		}
+		return c
+	}, value)
+
+	if len(field) > max {
+		field = field[:max]
+	}
+	if field == "" {
+		return "-"
+	}
+	return field
+}
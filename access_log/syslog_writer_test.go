@@ -0,0 +1,119 @@
+package access_log_test
+
+import (
+	. "github.com/cloudfoundry/gorouter/access_log"
+
+	"github.com/cloudfoundry/gorouter/config"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"bufio"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+)
+
+var _ = Describe("SyslogWriter", func() {
+	var syslogConfig config.AccessLogSyslogConfig
+
+	BeforeEach(func() {
+		syslogConfig = config.AccessLogSyslogConfig{
+			AppName: "gorouter",
+		}
+	})
+
+	It("sends one RFC5424 message per datagram over UDP", func() {
+		conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+		Ω(err).NotTo(HaveOccurred())
+		defer conn.Close()
+
+		syslogConfig.Network = config.SyslogNetworkUDP
+		syslogConfig.Address = conn.LocalAddr().String()
+		writer := NewSyslogWriter(syslogConfig)
+		defer writer.Close()
+
+		record := CompleteAccessLogRecord()
+		Ω(writer.WriteRecord(&record)).To(Succeed())
+
+		buf := make([]byte, 4096)
+		n, _, err := conn.ReadFrom(buf)
+		Ω(err).NotTo(HaveOccurred())
+
+		msg := string(buf[:n])
+		Ω(msg).To(MatchRegexp(`^<134>1 2000-01-01T00:00:00.000000Z \S+ gorouter \d+ access \[access@47450 `))
+		Ω(msg).To(ContainSubstring(`host="FakeRequestHost" method="FakeRequestMethod" path="http://example.com/request" status="200" app_id="FakeApplicationId" vcap_request_id="abc-123-xyz-pdq"]`))
+		Ω(msg).To(HaveSuffix("] " + strings.TrimSuffix(record.LogMessage(), "\n")))
+	})
+
+	It("frames messages by octet counting over TCP", func() {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		Ω(err).NotTo(HaveOccurred())
+		defer listener.Close()
+
+		received := make(chan string, 2)
+		go func() {
+			defer GinkgoRecover()
+			conn, err := listener.Accept()
+			Ω(err).NotTo(HaveOccurred())
+			defer conn.Close()
+
+			reader := bufio.NewReader(conn)
+			for i := 0; i < 2; i++ {
+				length, err := reader.ReadString(' ')
+				Ω(err).NotTo(HaveOccurred())
+				n, err := strconv.Atoi(strings.TrimSpace(length))
+				Ω(err).NotTo(HaveOccurred())
+
+				msg := make([]byte, n)
+				_, err = io.ReadFull(reader, msg)
+				Ω(err).NotTo(HaveOccurred())
+				received <- string(msg)
+			}
+		}()
+
+		syslogConfig.Network = config.SyslogNetworkTCP
+		syslogConfig.Address = listener.Addr().String()
+		writer := NewSyslogWriter(syslogConfig)
+		defer writer.Close()
+
+		record := CompleteAccessLogRecord()
+		Ω(writer.WriteRecord(&record)).To(Succeed())
+		Ω(writer.WriteRecord(&record)).To(Succeed())
+
+		var msg string
+		Eventually(received).Should(Receive(&msg))
+		Ω(msg).To(HavePrefix("<134>1 "))
+		Eventually(received).Should(Receive(&msg))
+		Ω(msg).To(HavePrefix("<134>1 "))
+	})
+
+	It("escapes structured data values", func() {
+		conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+		Ω(err).NotTo(HaveOccurred())
+		defer conn.Close()
+
+		syslogConfig.Network = config.SyslogNetworkUDP
+		syslogConfig.Address = conn.LocalAddr().String()
+		writer := NewSyslogWriter(syslogConfig)
+		defer writer.Close()
+
+		record := CompleteAccessLogRecord()
+		record.Request.Host = `a"b]c\d`
+		Ω(writer.WriteRecord(&record)).To(Succeed())
+
+		buf := make([]byte, 4096)
+		n, _, err := conn.ReadFrom(buf)
+		Ω(err).NotTo(HaveOccurred())
+		Ω(string(buf[:n])).To(ContainSubstring(`host="a\"b\]c\\d"`))
+	})
+
+	It("reports an error when the endpoint cannot be reached", func() {
+		syslogConfig.Network = config.SyslogNetworkTCP
+		syslogConfig.Address = "127.0.0.1:1"
+		writer := NewSyslogWriter(syslogConfig)
+
+		record := CompleteAccessLogRecord()
+		Ω(writer.WriteRecord(&record)).ToNot(Succeed())
+	})
+})
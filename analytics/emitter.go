@@ -0,0 +1,267 @@
+package analytics
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	steno "github.com/cloudfoundry/gosteno"
+
+	"github.com/cloudfoundry/gorouter/clock"
+	"github.com/cloudfoundry/gorouter/config"
+	"github.com/cloudfoundry/gorouter/requestfilter"
+	"github.com/cloudfoundry/gorouter/telemetry"
+)
+
+// An Event is what is emitted for a request that a rule matches.
+type Event struct {
+	Event   string            `json:"event"`
+	Time    time.Time         `json:"time"`
+	Host    string            `json:"host"`
+	Method  string            `json:"method"`
+	Path    string            `json:"path"`
+	Status  int               `json:"status"`
+	Params  map[string]string `json:"params,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+}
+
+// Emitter emits an event for every rule that matches a request, and posts
+// the events in batches of JSON lines to the configured URL. Events wait in
+// a bounded buffer while a batch is being posted. When the buffer is full,
+// or a batch cannot be posted, events are dropped and counted rather than
+// slowing down the proxy. A nil Emitter emits nothing.
+type Emitter struct {
+	rules []rule
+	clock clock.Clock
+
+	url           string
+	client        *http.Client
+	batchSize     int
+	flushInterval time.Duration
+
+	events chan []byte
+	stopCh chan struct{}
+	doneCh chan struct{}
+	logger *steno.Logger
+	health *telemetry.Sink
+
+	sent    uint64
+	dropped uint64
+}
+
+type rule struct {
+	event    string
+	filter   *requestfilter.Rule
+	segments []string
+	headers  []string
+}
+
+// New returns an emitter for the rules of c, or nil when there are none.
+func New(c config.AnalyticsConfig, clk clock.Clock) *Emitter {
+	if len(c.Rules) == 0 {
+		return nil
+	}
+
+	e := &Emitter{
+		clock: clk,
+
+		url:           c.Url,
+		client:        &http.Client{Timeout: c.Timeout},
+		batchSize:     c.BatchSize,
+		flushInterval: c.FlushInterval,
+
+		events: make(chan []byte, c.BufferSize),
+		stopCh: make(chan struct{}),
+		doneCh: make(chan struct{}),
+		logger: steno.NewLogger("router.analytics"),
+	}
+	for _, r := range c.Rules {
+		e.rules = append(e.rules, newRule(r))
+	}
+
+	return e
+}
+
+func newRule(c config.AnalyticsRuleConfig) rule {
+	r := rule{
+		event:  c.Event,
+		filter: requestfilter.NewRule(config.RequestFilterConfig{Routes: c.Routes, Methods: c.Methods}),
+	}
+	if c.Path != "" {
+		r.segments = strings.Split(strings.TrimPrefix(c.Path, "/"), "/")
+	}
+	for _, h := range c.Headers {
+		r.headers = append(r.headers, http.CanonicalHeaderKey(h))
+	}
+	return r
+}
+
+// SetHealth has the outcome of every batch recorded in h.
+func (e *Emitter) SetHealth(h *telemetry.Sink) {
+	e.health = h
+}
+
+// Record queues the events of the rules that request, answered with status,
+// matches, without blocking.
+func (e *Emitter) Record(request *http.Request, status int) {
+	if e == nil {
+		return
+	}
+
+	for _, r := range e.rules {
+		params, ok := r.match(request)
+		if !ok {
+			continue
+		}
+
+		event := Event{
+			Event:  r.event,
+			Time:   e.clock.Now(),
+			Host:   requestfilter.Hostname(request.Host),
+			Method: request.Method,
+			Path:   request.URL.Path,
+			Status: status,
+			Params: params,
+		}
+		for _, name := range r.headers {
+			if v := request.Header.Get(name); v != "" {
+				if event.Headers == nil {
+					event.Headers = make(map[string]string)
+				}
+				event.Headers[name] = v
+			}
+		}
+
+		b, err := json.Marshal(event)
+		if err != nil {
+			atomic.AddUint64(&e.dropped, 1)
+			continue
+		}
+
+		select {
+		case e.events <- b:
+		default:
+			atomic.AddUint64(&e.dropped, 1)
+		}
+	}
+}
+
+// match tells whether r matches request, and returns the parameters its
+// path template extracts.
+func (r rule) match(request *http.Request) (map[string]string, bool) {
+	if !r.filter.Matches(request) {
+		return nil, false
+	}
+	if r.segments == nil {
+		return nil, true
+	}
+
+	segments := strings.Split(strings.TrimPrefix(request.URL.Path, "/"), "/")
+	if len(segments) != len(r.segments) {
+		return nil, false
+	}
+
+	var params map[string]string
+	for i, s := range r.segments {
+		if strings.HasPrefix(s, "{") && strings.HasSuffix(s, "}") {
+			if params == nil {
+				params = make(map[string]string)
+			}
+			params[s[1:len(s)-1]] = segments[i]
+		} else if s != segments[i] {
+			return nil, false
+		}
+	}
+	return params, true
+}
+
+func (e *Emitter) Sent() uint64 {
+	return atomic.LoadUint64(&e.sent)
+}
+
+func (e *Emitter) Dropped() uint64 {
+	return atomic.LoadUint64(&e.dropped)
+}
+
+// Run posts a batch when it is full or when the flush interval passes,
+// whichever comes first. It returns after Stop, once the events queued so
+// far have been posted.
+func (e *Emitter) Run() {
+	defer close(e.doneCh)
+
+	ticker := time.NewTicker(e.flushInterval)
+	defer ticker.Stop()
+
+	batch := make([][]byte, 0, e.batchSize)
+	for {
+		select {
+		case b := <-e.events:
+			batch = append(batch, b)
+			if len(batch) >= e.batchSize {
+				batch = e.flush(batch)
+			}
+		case <-ticker.C:
+			batch = e.flush(batch)
+		case <-e.stopCh:
+			for {
+				select {
+				case b := <-e.events:
+					batch = append(batch, b)
+					if len(batch) >= e.batchSize {
+						batch = e.flush(batch)
+					}
+				default:
+					e.flush(batch)
+					return
+				}
+			}
+		}
+	}
+}
+
+func (e *Emitter) Stop() {
+	close(e.stopCh)
+	<-e.doneCh
+}
+
+// flush posts batch and returns an empty batch to reuse.
+func (e *Emitter) flush(batch [][]byte) [][]byte {
+	if len(batch) == 0 {
+		return batch
+	}
+
+	err := e.post(batch)
+	if err != nil {
+		atomic.AddUint64(&e.dropped, uint64(len(batch)))
+		e.logger.Warnf("Error posting %d analytics events: %s", len(batch), err.Error())
+		e.health.Failed(err)
+	} else {
+		atomic.AddUint64(&e.sent, uint64(len(batch)))
+		e.health.Succeeded()
+	}
+
+	return batch[:0]
+}
+
+func (e *Emitter) post(batch [][]byte) error {
+	var body bytes.Buffer
+	for _, b := range batch {
+		body.Write(b)
+		body.WriteByte('\n')
+	}
+
+	res, err := e.client.Post(e.url, "application/x-ndjson", &body)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+
+	if res.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %d", res.StatusCode)
+	}
+	return nil
+}
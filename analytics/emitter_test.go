@@ -0,0 +1,146 @@
+package analytics_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+
+	"github.com/cloudfoundry/gorouter/analytics"
+	"github.com/cloudfoundry/gorouter/clock/fakeclock"
+	"github.com/cloudfoundry/gorouter/config"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Emitter", func() {
+	var (
+		server  *httptest.Server
+		status  int
+		lock    sync.Mutex
+		batches [][]analytics.Event
+		c       config.AnalyticsConfig
+		clock   *fakeclock.FakeClock
+	)
+
+	BeforeEach(func() {
+		status = http.StatusNoContent
+		batches = nil
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			defer r.Body.Close()
+			Ω(r.Header.Get("Content-Type")).To(Equal("application/x-ndjson"))
+
+			var batch []analytics.Event
+			scanner := bufio.NewScanner(r.Body)
+			for scanner.Scan() {
+				var e analytics.Event
+				Ω(json.Unmarshal(scanner.Bytes(), &e)).To(Succeed())
+				batch = append(batch, e)
+			}
+
+			lock.Lock()
+			batches = append(batches, batch)
+			lock.Unlock()
+			w.WriteHeader(status)
+		}))
+
+		c = config.AnalyticsConfig{
+			Url:           server.URL,
+			BatchSize:     10,
+			BufferSize:    10,
+			FlushInterval: time.Hour,
+			Timeout:       time.Second,
+			Rules: []config.AnalyticsRuleConfig{{
+				Event:   "order_viewed",
+				Routes:  []string{"api.example.com"},
+				Methods: []string{"GET"},
+				Path:    "/users/{user}/orders/{order}",
+				Headers: []string{"x-tenant-id"},
+			}},
+		}
+		clock = fakeclock.New(time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC))
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	newRequest := func(method, url string) *http.Request {
+		request, err := http.NewRequest(method, url, nil)
+		Ω(err).NotTo(HaveOccurred())
+		return request
+	}
+
+	posted := func() [][]analytics.Event {
+		lock.Lock()
+		defer lock.Unlock()
+		return batches
+	}
+
+	It("emits nothing without rules", func() {
+		e := analytics.New(config.AnalyticsConfig{}, clock)
+		Ω(e).To(BeNil())
+		e.Record(newRequest("GET", "http://api.example.com/"), http.StatusOK)
+	})
+
+	It("posts the fields it extracts from the requests that rules match", func() {
+		e := analytics.New(c, clock)
+		go e.Run()
+
+		request := newRequest("GET", "http://API.example.com:8080/users/42/orders/7")
+		request.Header.Set("X-Tenant-Id", "acme")
+		request.Header.Set("Authorization", "secret")
+		e.Record(request, http.StatusOK)
+
+		e.Record(newRequest("POST", "http://api.example.com/users/42/orders/7"), http.StatusOK)
+		e.Record(newRequest("GET", "http://api.example.com/users/42/orders"), http.StatusOK)
+		e.Record(newRequest("GET", "http://api.example.com/users/42/items/7"), http.StatusOK)
+		e.Record(newRequest("GET", "http://other.example.com/users/42/orders/7"), http.StatusOK)
+		e.Stop()
+
+		Ω(posted()).To(Equal([][]analytics.Event{{{
+			Event:   "order_viewed",
+			Time:    clock.Now(),
+			Host:    "api.example.com",
+			Method:  "GET",
+			Path:    "/users/42/orders/7",
+			Status:  http.StatusOK,
+			Params:  map[string]string{"user": "42", "order": "7"},
+			Headers: map[string]string{"X-Tenant-Id": "acme"},
+		}}}))
+		Ω(e.Sent()).To(Equal(uint64(1)))
+	})
+
+	It("posts a batch once it is full", func() {
+		c.BatchSize = 2
+		c.Rules = []config.AnalyticsRuleConfig{{Event: "request"}}
+		e := analytics.New(c, clock)
+		go e.Run()
+		defer e.Stop()
+
+		e.Record(newRequest("GET", "http://api.example.com/"), http.StatusOK)
+		e.Record(newRequest("GET", "http://api.example.com/"), http.StatusNotFound)
+
+		Eventually(posted).Should(HaveLen(1))
+		Ω(posted()[0]).To(HaveLen(2))
+	})
+
+	It("drops events that cannot be posted or queued", func() {
+		status = http.StatusInternalServerError
+		c.BufferSize = 1
+		c.Rules = []config.AnalyticsRuleConfig{{Event: "request"}}
+		e := analytics.New(c, clock)
+
+		e.Record(newRequest("GET", "http://api.example.com/"), http.StatusOK)
+		e.Record(newRequest("GET", "http://api.example.com/"), http.StatusOK)
+		Ω(e.Dropped()).To(Equal(uint64(1)))
+
+		go e.Run()
+		e.Stop()
+		Ω(e.Sent()).To(Equal(uint64(0)))
+		Ω(e.Dropped()).To(Equal(uint64(2)))
+	})
+})
@@ -0,0 +1,13 @@
+package banner_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestBanner(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Banner Suite")
+}
@@ -0,0 +1,274 @@
+package banner
+
+import (
+	"bytes"
+	"mime"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cloudfoundry/gorouter/clock"
+	"github.com/cloudfoundry/gorouter/config"
+)
+
+// maxSearchBytes is how much of a body the writer holds back looking for
+// its opening body tag before giving up and sending it on as it is.
+const maxSearchBytes = 64 * 1024
+
+// Injector injects the banners operators configured into the HTML
+// responses for their domains while they are shown. A nil Injector
+// injects nothing.
+type Injector struct {
+	banners []banner
+	clock   clock.Clock
+}
+
+type banner struct {
+	hosts    map[string]bool
+	suffixes []string
+	html     []byte
+	starts   time.Time
+	ends     time.Time
+}
+
+// New returns an injector for configs, or nil when there are none.
+func New(configs []config.BannerConfig, clk clock.Clock) *Injector {
+	if len(configs) == 0 {
+		return nil
+	}
+
+	i := &Injector{clock: clk}
+	for _, c := range configs {
+		b := banner{
+			hosts:  make(map[string]bool),
+			html:   []byte(c.Html),
+			starts: c.Starts,
+			ends:   c.Ends,
+		}
+		for _, d := range c.Domains {
+			d = strings.ToLower(d)
+			if strings.HasPrefix(d, "*.") {
+				b.suffixes = append(b.suffixes, d[1:])
+			} else {
+				b.hosts[d] = true
+			}
+		}
+		i.banners = append(i.banners, b)
+	}
+
+	return i
+}
+
+// ResponseWriter returns a writer that injects the banner shown for the
+// host of request into the response written to w, or nil when no banner is
+// shown for it now. The writer must be closed once the response is
+// written.
+func (i *Injector) ResponseWriter(w http.ResponseWriter, request *http.Request) *ResponseWriter {
+	if i == nil || request.Method == "HEAD" {
+		return nil
+	}
+
+	html := i.banner(request.Host)
+	if html == nil {
+		return nil
+	}
+
+	return &ResponseWriter{ResponseWriter: w, html: html}
+}
+
+// banner returns the first banner shown now for host.
+func (i *Injector) banner(host string) []byte {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.ToLower(host)
+
+	now := i.clock.Now()
+	for _, b := range i.banners {
+		if !b.starts.IsZero() && now.Before(b.starts) {
+			continue
+		}
+		if !b.ends.IsZero() && !now.Before(b.ends) {
+			continue
+		}
+		if b.covers(host) {
+			return b.html
+		}
+	}
+	return nil
+}
+
+func (b banner) covers(host string) bool {
+	if b.hosts[host] {
+		return true
+	}
+	for _, s := range b.suffixes {
+		if strings.HasSuffix(host, s) {
+			return true
+		}
+	}
+	return false
+}
+
+const (
+	undecided = iota
+	searching
+	passing
+)
+
+// ResponseWriter injects a banner right after the opening body tag of an
+// HTML response. It holds the body back until it finds the tag, and sends
+// it on as it is when the tag is not within the first bytes of the body,
+// or when the body ends or is flushed before. The Content-Length of the
+// response, when it has one, grows by the size of the banner, and its ETag
+// is dropped, since it no longer identifies the body.
+type ResponseWriter struct {
+	http.ResponseWriter
+
+	html []byte
+
+	state  int
+	status int
+	buf    []byte
+}
+
+func (w *ResponseWriter) WriteHeader(status int) {
+	if w.state != undecided {
+		return
+	}
+
+	if !bodyAllowed(status) || !injectable(w.Header()) {
+		w.pass(status)
+		return
+	}
+
+	w.state = searching
+	w.status = status
+}
+
+func (w *ResponseWriter) Write(b []byte) (int, error) {
+	if w.state == undecided {
+		w.WriteHeader(http.StatusOK)
+	}
+
+	if w.state != searching {
+		return w.ResponseWriter.Write(b)
+	}
+
+	w.buf = append(w.buf, b...)
+	if end := bodyTagEnd(w.buf); end >= 0 {
+		if err := w.inject(end); err != nil {
+			return 0, err
+		}
+	} else if len(w.buf) >= maxSearchBytes {
+		if err := w.release(); err != nil {
+			return 0, err
+		}
+	}
+	return len(b), nil
+}
+
+// Flush sends what was written so far. A body held back is sent without a
+// banner, since a flushing backend wants it to reach the client now.
+func (w *ResponseWriter) Flush() {
+	if w.state == searching {
+		w.release()
+	}
+
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Close ends the response, sending what the writer still holds.
+func (w *ResponseWriter) Close() error {
+	if w.state == searching {
+		return w.release()
+	}
+	return nil
+}
+
+func (w *ResponseWriter) pass(status int) {
+	w.state = passing
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *ResponseWriter) release() error {
+	w.pass(w.status)
+	_, err := w.ResponseWriter.Write(w.buf)
+	w.buf = nil
+	return err
+}
+
+func (w *ResponseWriter) inject(end int) error {
+	header := w.Header()
+	if v := header.Get("Content-Length"); v != "" {
+		if size, err := strconv.ParseInt(v, 10, 64); err == nil {
+			header.Set("Content-Length", strconv.FormatInt(size+int64(len(w.html)), 10))
+		}
+	}
+	header.Del("ETag")
+	w.pass(w.status)
+
+	buf := w.buf
+	w.buf = nil
+	for _, b := range [][]byte{buf[:end], w.html, buf[end:]} {
+		if _, err := w.ResponseWriter.Write(b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func injectable(header http.Header) bool {
+	if header.Get("Content-Range") != "" {
+		return false
+	}
+	if e := header.Get("Content-Encoding"); e != "" && !strings.EqualFold(e, "identity") {
+		return false
+	}
+
+	mediaType, _, err := mime.ParseMediaType(header.Get("Content-Type"))
+	return err == nil && mediaType == "text/html"
+}
+
+// bodyTagEnd returns the index just past the opening body tag in b, or -1
+// when b does not have all of it.
+func bodyTagEnd(b []byte) int {
+	// only ASCII is folded, so that indexes into lower are indexes into b
+	lower := make([]byte, len(b))
+	for i, c := range b {
+		if 'A' <= c && c <= 'Z' {
+			c += 'a' - 'A'
+		}
+		lower[i] = c
+	}
+
+	for offset := 0; ; {
+		i := bytes.Index(lower[offset:], []byte("<body"))
+		if i < 0 {
+			return -1
+		}
+		i += offset + len("<body")
+
+		if i == len(lower) {
+			return -1
+		}
+		switch lower[i] {
+		case '>', ' ', '\t', '\n', '\r', '\f', '/':
+			end := bytes.IndexByte(lower[i:], '>')
+			if end < 0 {
+				return -1
+			}
+			return i + end + 1
+		}
+		offset = i
+	}
+}
+
+func bodyAllowed(status int) bool {
+	return status >= http.StatusOK && status != http.StatusNoContent &&
+		status != http.StatusPartialContent && status != http.StatusNotModified
+}
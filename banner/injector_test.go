@@ -0,0 +1,116 @@
+package banner_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cloudfoundry/gorouter/banner"
+	"github.com/cloudfoundry/gorouter/clock/fakeclock"
+	"github.com/cloudfoundry/gorouter/config"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Injector", func() {
+	const html = `<div id="banner">Maintenance tonight</div>`
+
+	var (
+		clock    *fakeclock.FakeClock
+		configs  []config.BannerConfig
+		injector *banner.Injector
+		recorder *httptest.ResponseRecorder
+		request  *http.Request
+	)
+
+	BeforeEach(func() {
+		clock = fakeclock.New(time.Date(2026, 10, 20, 19, 0, 0, 0, time.UTC))
+		configs = []config.BannerConfig{{
+			Domains: []string{"www.example.com", "*.apps.example.com"},
+			Html:    html,
+			Starts:  time.Date(2026, 10, 20, 18, 0, 0, 0, time.UTC),
+			Ends:    time.Date(2026, 10, 20, 22, 0, 0, 0, time.UTC),
+		}}
+		recorder = httptest.NewRecorder()
+		request, _ = http.NewRequest("GET", "http://www.example.com/", nil)
+	})
+
+	JustBeforeEach(func() {
+		injector = banner.New(configs, clock)
+	})
+
+	write := func(w http.ResponseWriter, contentType string, chunks ...string) {
+		length := 0
+		for _, c := range chunks {
+			length += len(c)
+		}
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Content-Length", strconv.Itoa(length))
+		w.Header().Set("ETag", `"v1"`)
+		w.WriteHeader(http.StatusOK)
+		for _, c := range chunks {
+			w.Write([]byte(c))
+		}
+	}
+
+	It("injects nothing without banners", func() {
+		injector := banner.New(nil, clock)
+		Ω(injector).To(BeNil())
+		Ω(injector.ResponseWriter(recorder, request)).To(BeNil())
+	})
+
+	It("injects the banner after the opening body tag and fixes the length", func() {
+		w := injector.ResponseWriter(recorder, request)
+		Ω(w).NotTo(BeNil())
+
+		write(w, "text/html; charset=utf-8", "<html><BODY class=", `"page">`, "<p>Hello</p></body></html>")
+		Ω(w.Close()).To(Succeed())
+
+		body := recorder.Body.String()
+		Ω(body).To(Equal(`<html><BODY class="page">` + html + "<p>Hello</p></body></html>"))
+		Ω(recorder.Header().Get("Content-Length")).To(Equal(strconv.Itoa(len(body))))
+		Ω(recorder.Header().Get("ETag")).To(BeEmpty())
+	})
+
+	It("covers subdomains of wildcard domains", func() {
+		request.Host = "App.apps.example.com:8080"
+		Ω(injector.ResponseWriter(recorder, request)).NotTo(BeNil())
+
+		request.Host = "example.com"
+		Ω(injector.ResponseWriter(recorder, request)).To(BeNil())
+	})
+
+	It("shows the banner only within its window", func() {
+		clock.Increment(3 * time.Hour)
+		Ω(injector.ResponseWriter(recorder, request)).To(BeNil())
+	})
+
+	It("passes responses that are not HTML on as they are", func() {
+		w := injector.ResponseWriter(recorder, request)
+		write(w, "application/json", `{"body": "<body>"}`)
+		Ω(w.Close()).To(Succeed())
+
+		Ω(recorder.Body.String()).To(Equal(`{"body": "<body>"}`))
+		Ω(recorder.Header().Get("ETag")).To(Equal(`"v1"`))
+	})
+
+	It("passes HTML without a body tag on as it is", func() {
+		w := injector.ResponseWriter(recorder, request)
+		write(w, "text/html", "<html><bodyguard></bodyguard></html>")
+		Ω(w.Close()).To(Succeed())
+
+		Ω(recorder.Body.String()).To(Equal("<html><bodyguard></bodyguard></html>"))
+	})
+
+	It("gives up on bodies whose tag is not near the start", func() {
+		w := injector.ResponseWriter(recorder, request)
+		padding := strings.Repeat("a", 64*1024)
+		write(w, "text/html", padding, "<body>")
+		Ω(w.Close()).To(Succeed())
+
+		Ω(recorder.Body.String()).To(Equal(padding + "<body>"))
+	})
+})
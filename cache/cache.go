@@ -0,0 +1,134 @@
+package cache
+
+import (
+	"container/list"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/cloudfoundry/gorouter/clock"
+)
+
+type cacheEntry struct {
+	key   string
+	entry *Entry
+}
+
+// Cache keeps backend responses in memory, up to maxBytes in all. Entries
+// larger than maxEntryBytes are not kept, and the least recently used
+// entries make room for new ones. Entries are not changed once stored, so
+// they can be served while others replace them. Their freshness is judged
+// by the time of clock.
+type Cache struct {
+	sync.Mutex
+
+	clock clock.Clock
+
+	maxBytes      int64
+	maxEntryBytes int64
+	size          int64
+
+	lru     *list.List
+	entries map[string]*list.Element
+}
+
+func New(maxBytes, maxEntryBytes int64, clk clock.Clock) *Cache {
+	return &Cache{
+		clock:         clk,
+		maxBytes:      maxBytes,
+		maxEntryBytes: maxEntryBytes,
+		lru:           list.New(),
+		entries:       make(map[string]*list.Element),
+	}
+}
+
+// Key identifies the resource a request is for: its host, without the port
+// and in lower case, and its request URI.
+func Key(request *http.Request) string {
+	host := request.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.ToLower(host) + request.RequestURI
+}
+
+func (c *Cache) Get(key string) *Entry {
+	c.Lock()
+	defer c.Unlock()
+
+	element, ok := c.entries[key]
+	if !ok {
+		return nil
+	}
+
+	c.lru.MoveToFront(element)
+	return element.Value.(*cacheEntry).entry
+}
+
+// Fresh returns the entry for key when request may be answered from it
+// without asking the backend.
+func (c *Cache) Fresh(request *http.Request, key string) *Entry {
+	if !servable(request) {
+		return nil
+	}
+
+	entry := c.Get(key)
+	if entry == nil || !entry.Fresh(c.clock.Now()) {
+		return nil
+	}
+	return entry
+}
+
+func (c *Cache) Put(key string, entry *Entry) {
+	size := entry.size()
+	if size > c.maxEntryBytes || size > c.maxBytes {
+		c.Delete(key)
+		return
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	c.remove(key)
+
+	for c.size+size > c.maxBytes {
+		c.remove(c.lru.Back().Value.(*cacheEntry).key)
+	}
+
+	c.entries[key] = c.lru.PushFront(&cacheEntry{key: key, entry: entry})
+	c.size += size
+}
+
+func (c *Cache) Delete(key string) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.remove(key)
+}
+
+func (c *Cache) Len() int {
+	c.Lock()
+	defer c.Unlock()
+
+	return c.lru.Len()
+}
+
+// Size returns the number of bytes the entries take.
+func (c *Cache) Size() int64 {
+	c.Lock()
+	defer c.Unlock()
+
+	return c.size
+}
+
+func (c *Cache) remove(key string) {
+	element, ok := c.entries[key]
+	if !ok {
+		return
+	}
+
+	c.lru.Remove(element)
+	delete(c.entries, key)
+	c.size -= element.Value.(*cacheEntry).entry.size()
+}
@@ -0,0 +1,139 @@
+package cache_test
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/cloudfoundry/gorouter/cache"
+	"github.com/cloudfoundry/gorouter/clock/fakeclock"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Cache", func() {
+	var c *cache.Cache
+	var clk *fakeclock.FakeClock
+
+	entry := func(body string, ttl time.Duration) *cache.Entry {
+		return &cache.Entry{
+			StatusCode: http.StatusOK,
+			Header:     make(http.Header),
+			Body:       []byte(body),
+			StoredAt:   clk.Now(),
+			TTL:        ttl,
+		}
+	}
+
+	BeforeEach(func() {
+		clk = fakeclock.New(time.Now())
+		c = cache.New(100, 50, clk)
+	})
+
+	It("keeps entries by key", func() {
+		e := entry("hello", time.Minute)
+		c.Put("a", e)
+
+		Ω(c.Get("a")).To(Equal(e))
+		Ω(c.Get("b")).To(BeNil())
+		Ω(c.Size()).To(Equal(int64(5)))
+	})
+
+	It("replaces the entry of a key", func() {
+		c.Put("a", entry("hello", time.Minute))
+		c.Put("a", entry("hi", time.Minute))
+
+		Ω(c.Len()).To(Equal(1))
+		Ω(string(c.Get("a").Body)).To(Equal("hi"))
+		Ω(c.Size()).To(Equal(int64(2)))
+	})
+
+	It("does not keep entries over the entry size limit", func() {
+		c.Put("a", entry("hello", time.Minute))
+		c.Put("a", entry(strings.Repeat("x", 51), time.Minute))
+
+		Ω(c.Get("a")).To(BeNil())
+		Ω(c.Size()).To(BeZero())
+	})
+
+	It("forgets the least recently used entries to make room", func() {
+		c.Put("a", entry(strings.Repeat("a", 40), time.Minute))
+		c.Put("b", entry(strings.Repeat("b", 40), time.Minute))
+		c.Get("a")
+		c.Put("c", entry(strings.Repeat("c", 40), time.Minute))
+
+		Ω(c.Get("a")).ToNot(BeNil())
+		Ω(c.Get("b")).To(BeNil())
+		Ω(c.Get("c")).ToNot(BeNil())
+		Ω(c.Size()).To(Equal(int64(80)))
+	})
+
+	It("deletes entries", func() {
+		c.Put("a", entry("hello", time.Minute))
+		c.Delete("a")
+
+		Ω(c.Get("a")).To(BeNil())
+		Ω(c.Size()).To(BeZero())
+	})
+
+	Describe("Fresh", func() {
+		var request *http.Request
+
+		BeforeEach(func() {
+			request, _ = http.NewRequest("GET", "http://app.example.com/", nil)
+		})
+
+		It("returns fresh entries", func() {
+			c.Put("a", entry("hello", time.Minute))
+
+			Ω(c.Fresh(request, "a")).ToNot(BeNil())
+		})
+
+		It("does not return stale entries", func() {
+			c.Put("a", entry("hello", 0))
+
+			Ω(c.Fresh(request, "a")).To(BeNil())
+		})
+
+		It("judges freshness by its clock", func() {
+			c.Put("a", entry("hello", time.Minute))
+			clk.Increment(2 * time.Minute)
+
+			Ω(c.Fresh(request, "a")).To(BeNil())
+		})
+
+		It("does not answer requests that bypass caches", func() {
+			c.Put("a", entry("hello", time.Minute))
+
+			request.Header.Set("Cache-Control", "no-cache")
+			Ω(c.Fresh(request, "a")).To(BeNil())
+
+			request.Header.Del("Cache-Control")
+			request.Header.Set("Pragma", "no-cache")
+			Ω(c.Fresh(request, "a")).To(BeNil())
+		})
+
+		It("does not answer requests with credentials", func() {
+			c.Put("a", entry("hello", time.Minute))
+			request.Header.Set("Authorization", "Bearer token")
+
+			Ω(c.Fresh(request, "a")).To(BeNil())
+		})
+
+		It("does not answer other methods than GET and HEAD", func() {
+			c.Put("a", entry("hello", time.Minute))
+			request.Method = "POST"
+
+			Ω(c.Fresh(request, "a")).To(BeNil())
+		})
+	})
+
+	Describe("Key", func() {
+		It("is the host without the port in lower case and the request URI", func() {
+			request, _ := http.NewRequest("GET", "http://App.Example.com:8080/a?b=c", nil)
+			request.RequestURI = "/a?b=c"
+
+			Ω(cache.Key(request)).To(Equal("app.example.com/a?b=c"))
+		})
+	})
+})
@@ -0,0 +1,192 @@
+package cache
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// responseHeaders are the headers of the responses answered from entries,
+// reused once the responses are released.
+var responseHeaders = sync.Pool{New: func() interface{} { return make(http.Header) }}
+
+// The headers of a cached response that a 304 Not Modified response
+// carries (RFC 7232, section 4.1).
+var notModifiedHeaders = []string{
+	"Cache-Control",
+	"Content-Location",
+	"Date",
+	"ETag",
+	"Expires",
+	"Last-Modified",
+	"Vary",
+}
+
+// Entry is a cached response. It is fresh for TTL after it was stored, and
+// has to be revalidated with the backend after that.
+type Entry struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+	StoredAt   time.Time
+	TTL        time.Duration
+}
+
+func (e *Entry) Fresh(now time.Time) bool {
+	return now.Sub(e.StoredAt) < e.TTL
+}
+
+func (e *Entry) Age(now time.Time) time.Duration {
+	age := now.Sub(e.StoredAt)
+	if age < 0 {
+		return 0
+	}
+	return age
+}
+
+// Response answers request from the entry: with 304 Not Modified when the
+// conditional headers of request match it, and with the whole response
+// otherwise.
+func (e *Entry) Response(request *http.Request, now time.Time) *http.Response {
+	res := &http.Response{
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     responseHeaders.Get().(http.Header),
+		Request:    request,
+	}
+
+	if NotModified(request, e.Header) {
+		res.StatusCode = http.StatusNotModified
+		for _, h := range notModifiedHeaders {
+			h = http.CanonicalHeaderKey(h)
+			if v, ok := e.Header[h]; ok {
+				res.Header[h] = v
+			}
+		}
+	} else {
+		res.StatusCode = e.StatusCode
+		for k, v := range e.Header {
+			res.Header[k] = v
+		}
+		stripSurrogateHeaders(res.Header)
+		res.ContentLength = int64(len(e.Body))
+		res.Header.Set("Content-Length", strconv.Itoa(len(e.Body)))
+	}
+
+	res.Status = strconv.Itoa(res.StatusCode) + " " + http.StatusText(res.StatusCode)
+	res.Header.Set("Age", strconv.Itoa(int(e.Age(now)/time.Second)))
+
+	if res.StatusCode == http.StatusNotModified || request.Method == "HEAD" {
+		res.Body = ioutil.NopCloser(bytes.NewReader(nil))
+	} else {
+		res.Body = ioutil.NopCloser(bytes.NewReader(e.Body))
+	}
+
+	return res
+}
+
+// Release has the header of res, a response of Response that was written
+// out, reused by the responses of other entries. The values of its header
+// are those of the entry, and outlive it.
+func Release(res *http.Response) {
+	for k := range res.Header {
+		delete(res.Header, k)
+	}
+	responseHeaders.Put(res.Header)
+	res.Header = nil
+}
+
+func (e *Entry) size() int64 {
+	size := len(e.Body)
+	for k, values := range e.Header {
+		for _, v := range values {
+			size += len(k) + len(v)
+		}
+	}
+	return int64(size)
+}
+
+// NotModified tells whether the conditional headers of request match a
+// response with header. If-None-Match uses the weak comparison, so a weak
+// and a strong ETag with the same value match; If-Modified-Since is only
+// looked at when there is no If-None-Match (RFC 7232, section 6).
+func NotModified(request *http.Request, header http.Header) bool {
+	if request.Method != "GET" && request.Method != "HEAD" {
+		return false
+	}
+
+	if ifNoneMatch, ok := request.Header["If-None-Match"]; ok {
+		etag := header.Get("ETag")
+		if etag == "" {
+			return false
+		}
+
+		for _, tag := range parseETags(strings.Join(ifNoneMatch, ",")) {
+			if tag == "*" || WeakMatch(tag, etag) {
+				return true
+			}
+		}
+		return false
+	}
+
+	if ifModifiedSince := request.Header.Get("If-Modified-Since"); ifModifiedSince != "" {
+		since, err := http.ParseTime(ifModifiedSince)
+		if err != nil {
+			return false
+		}
+
+		lastModified, err := http.ParseTime(header.Get("Last-Modified"))
+		if err != nil {
+			return false
+		}
+
+		return !lastModified.After(since)
+	}
+
+	return false
+}
+
+// WeakMatch compares two entity tags ignoring whether they are weak.
+func WeakMatch(a, b string) bool {
+	return strings.TrimPrefix(a, "W/") == strings.TrimPrefix(b, "W/")
+}
+
+// parseETags splits a list of entity tags. Commas may appear within the
+// quotes of a tag, so the list is scanned rather than split on them.
+func parseETags(s string) []string {
+	var tags []string
+	for {
+		s = strings.TrimLeft(s, " \t,")
+		if s == "" {
+			return tags
+		}
+
+		if s[0] == '*' {
+			tags = append(tags, "*")
+			s = s[1:]
+			continue
+		}
+
+		start := 0
+		if strings.HasPrefix(s, "W/") {
+			start = 2
+		}
+		if len(s) <= start || s[start] != '"' {
+			return tags
+		}
+
+		end := strings.IndexByte(s[start+1:], '"')
+		if end < 0 {
+			return tags
+		}
+		end += start + 2
+
+		tags = append(tags, s[:end])
+		s = s[end:]
+	}
+}
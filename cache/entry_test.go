@@ -0,0 +1,146 @@
+package cache_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/cloudfoundry/gorouter/cache"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Entry", func() {
+	var request *http.Request
+	var header http.Header
+
+	BeforeEach(func() {
+		request, _ = http.NewRequest("GET", "http://app.example.com/", nil)
+		header = http.Header{
+			"Etag":          []string{`"v1"`},
+			"Last-Modified": []string{"Mon, 12 Oct 2026 10:00:00 GMT"},
+		}
+	})
+
+	Describe("NotModified", func() {
+		It("matches a strong If-None-Match with a strong ETag", func() {
+			request.Header.Set("If-None-Match", `"v1"`)
+			Ω(cache.NotModified(request, header)).To(BeTrue())
+		})
+
+		It("matches a weak If-None-Match with a strong ETag", func() {
+			request.Header.Set("If-None-Match", `W/"v1"`)
+			Ω(cache.NotModified(request, header)).To(BeTrue())
+		})
+
+		It("matches a strong If-None-Match with a weak ETag", func() {
+			header.Set("Etag", `W/"v1"`)
+			request.Header.Set("If-None-Match", `"v1"`)
+			Ω(cache.NotModified(request, header)).To(BeTrue())
+		})
+
+		It("does not match other ETags", func() {
+			request.Header.Set("If-None-Match", `"v2", W/"v3"`)
+			Ω(cache.NotModified(request, header)).To(BeFalse())
+		})
+
+		It("finds the ETag in a list, even with commas in tags", func() {
+			header.Set("Etag", `"a,b"`)
+			request.Header.Set("If-None-Match", `"v0", "a,b"`)
+			Ω(cache.NotModified(request, header)).To(BeTrue())
+		})
+
+		It("matches any ETag with *", func() {
+			request.Header.Set("If-None-Match", "*")
+			Ω(cache.NotModified(request, header)).To(BeTrue())
+		})
+
+		It("does not match a response without an ETag", func() {
+			header.Del("Etag")
+			request.Header.Set("If-None-Match", "*")
+			Ω(cache.NotModified(request, header)).To(BeFalse())
+		})
+
+		It("matches If-Modified-Since when the response was not modified since", func() {
+			request.Header.Set("If-Modified-Since", "Mon, 12 Oct 2026 10:00:00 GMT")
+			Ω(cache.NotModified(request, header)).To(BeTrue())
+
+			request.Header.Set("If-Modified-Since", "Sun, 11 Oct 2026 10:00:00 GMT")
+			Ω(cache.NotModified(request, header)).To(BeFalse())
+		})
+
+		It("ignores If-Modified-Since when If-None-Match is present", func() {
+			request.Header.Set("If-None-Match", `"v2"`)
+			request.Header.Set("If-Modified-Since", "Tue, 13 Oct 2026 10:00:00 GMT")
+			Ω(cache.NotModified(request, header)).To(BeFalse())
+		})
+
+		It("does not match unconditional requests", func() {
+			Ω(cache.NotModified(request, header)).To(BeFalse())
+		})
+	})
+
+	Describe("Response", func() {
+		var entry *cache.Entry
+		var now time.Time
+
+		BeforeEach(func() {
+			now = time.Now()
+			header.Set("Content-Type", "text/plain")
+			entry = &cache.Entry{
+				StatusCode: http.StatusOK,
+				Header:     header,
+				Body:       []byte("hello"),
+				StoredAt:   now.Add(-30 * time.Second),
+				TTL:        time.Minute,
+			}
+		})
+
+		It("is the whole response with its age", func() {
+			res := entry.Response(request, now)
+
+			Ω(res.StatusCode).To(Equal(http.StatusOK))
+			Ω(res.Header.Get("Content-Type")).To(Equal("text/plain"))
+			Ω(res.Header.Get("Content-Length")).To(Equal("5"))
+			Ω(res.Header.Get("Age")).To(Equal("30"))
+
+			body, _ := ioutil.ReadAll(res.Body)
+			Ω(string(body)).To(Equal("hello"))
+		})
+
+		It("is 304 Not Modified with the validators when the request matches", func() {
+			request.Header.Set("If-None-Match", `"v1"`)
+			res := entry.Response(request, now)
+
+			Ω(res.StatusCode).To(Equal(http.StatusNotModified))
+			Ω(res.Header.Get("Etag")).To(Equal(`"v1"`))
+			Ω(res.Header.Get("Last-Modified")).To(Equal("Mon, 12 Oct 2026 10:00:00 GMT"))
+			Ω(res.Header).ToNot(HaveKey("Content-Type"))
+
+			body, _ := ioutil.ReadAll(res.Body)
+			Ω(body).To(BeEmpty())
+		})
+
+		It("leaves the entry as it was once released", func() {
+			res := entry.Response(request, now)
+			cache.Release(res)
+
+			Ω(res.Header).To(BeNil())
+			Ω(entry.Header.Get("Content-Type")).To(Equal("text/plain"))
+			Ω(entry.Header).ToNot(HaveKey("Age"))
+
+			res = entry.Response(request, now)
+			Ω(res.Header.Get("Content-Type")).To(Equal("text/plain"))
+			Ω(res.Header.Get("Age")).To(Equal("30"))
+		})
+
+		It("has no body for HEAD requests", func() {
+			request.Method = "HEAD"
+			res := entry.Response(request, now)
+
+			Ω(res.StatusCode).To(Equal(http.StatusOK))
+			body, _ := ioutil.ReadAll(res.Body)
+			Ω(body).To(BeEmpty())
+		})
+	})
+})
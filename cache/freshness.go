@@ -0,0 +1,172 @@
+package cache
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SurrogateHeaders are meant for the router cache alone, and are removed
+// from responses before they are relayed to clients.
+var SurrogateHeaders = []string{
+	"Surrogate-Control",
+	"Surrogate-Key",
+}
+
+// CacheControl holds the directives of Cache-Control headers by lower case
+// name. Directives without an argument map to "".
+type CacheControl map[string]string
+
+func ParseCacheControl(values []string) CacheControl {
+	cc := make(CacheControl)
+	for _, v := range values {
+		for _, directive := range strings.Split(v, ",") {
+			directive = strings.TrimSpace(directive)
+			if directive == "" {
+				continue
+			}
+
+			name, arg := directive, ""
+			if i := strings.IndexByte(directive, '='); i >= 0 {
+				name, arg = directive[:i], strings.Trim(strings.TrimSpace(directive[i+1:]), `"`)
+			}
+			cc[strings.ToLower(strings.TrimSpace(name))] = arg
+		}
+	}
+	return cc
+}
+
+// ParseSurrogateControl parses Surrogate-Control headers like Cache-Control
+// headers, leaving out the directives that a ";" and a name target at
+// another surrogate.
+func ParseSurrogateControl(values []string) CacheControl {
+	var own []string
+	for _, v := range values {
+		for _, directive := range strings.Split(v, ",") {
+			if !strings.Contains(directive, ";") {
+				own = append(own, directive)
+			}
+		}
+	}
+	return ParseCacheControl(own)
+}
+
+func (cc CacheControl) Has(directive string) bool {
+	_, ok := cc[directive]
+	return ok
+}
+
+// Seconds returns the argument of directive as a duration in seconds.
+func (cc CacheControl) Seconds(directive string) (time.Duration, bool) {
+	arg, ok := cc[directive]
+	if !ok {
+		return 0, false
+	}
+
+	seconds, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil || seconds < 0 {
+		return 0, true
+	}
+	return time.Duration(seconds) * time.Second, true
+}
+
+// servable tells whether request may be answered from the cache.
+// Requests with credentials and requests that ask to bypass caches go to
+// the backend.
+func servable(request *http.Request) bool {
+	if request.Method != "GET" && request.Method != "HEAD" {
+		return false
+	}
+	if request.Header.Get("Authorization") != "" {
+		return false
+	}
+
+	cc := ParseCacheControl(request.Header["Cache-Control"])
+	if cc.Has("no-cache") || cc.Has("no-store") {
+		return false
+	}
+	if strings.Contains(strings.ToLower(request.Header.Get("Pragma")), "no-cache") {
+		return false
+	}
+
+	return true
+}
+
+// storable tells whether the response to request may be kept, and for how
+// long it is fresh. A response without a lifetime is kept when it has a
+// validator, so that it can be revalidated rather than fetched again.
+func storable(request *http.Request, res *http.Response, now time.Time) (time.Duration, bool) {
+	if request.Method != "GET" || res.StatusCode != http.StatusOK {
+		return 0, false
+	}
+	if request.Header.Get("Authorization") != "" {
+		return 0, false
+	}
+	if ParseCacheControl(request.Header["Cache-Control"]).Has("no-store") {
+		return 0, false
+	}
+
+	// Responses that vary by request headers or set cookies are
+	// particular to a client.
+	if len(res.Header["Vary"]) > 0 || len(res.Header["Set-Cookie"]) > 0 {
+		return 0, false
+	}
+
+	// Surrogate-Control speaks to the router cache alone, and overrides
+	// what Cache-Control tells clients
+	sc := ParseSurrogateControl(res.Header["Surrogate-Control"])
+	if sc.Has("no-store") {
+		return 0, false
+	}
+
+	cc := ParseCacheControl(res.Header["Cache-Control"])
+	if _, ok := sc.Seconds("max-age"); !ok && (cc.Has("no-store") || cc.Has("private")) {
+		return 0, false
+	}
+
+	ttl := lifetime(res.Header, now)
+	if ttl <= 0 && res.Header.Get("ETag") == "" && res.Header.Get("Last-Modified") == "" {
+		return 0, false
+	}
+
+	return ttl, true
+}
+
+// lifetime is how long a response is fresh for in the router cache: the
+// max-age of its Surrogate-Control, or else its s-maxage or max-age, or else
+// the time between its Date and Expires headers. Responses with no-cache
+// and no Surrogate-Control lifetime are stale from the start.
+func lifetime(header http.Header, now time.Time) time.Duration {
+	if maxAge, ok := ParseSurrogateControl(header["Surrogate-Control"]).Seconds("max-age"); ok {
+		return maxAge
+	}
+
+	cc := ParseCacheControl(header["Cache-Control"])
+	if cc.Has("no-cache") {
+		return 0
+	}
+
+	if sMaxAge, ok := cc.Seconds("s-maxage"); ok {
+		return sMaxAge
+	}
+	if maxAge, ok := cc.Seconds("max-age"); ok {
+		return maxAge
+	}
+
+	if expires := header.Get("Expires"); expires != "" {
+		expiresAt, err := http.ParseTime(expires)
+		if err != nil {
+			return 0
+		}
+
+		date, err := http.ParseTime(header.Get("Date"))
+		if err != nil {
+			date = now
+		}
+
+		return expiresAt.Sub(date)
+	}
+
+	return 0
+}
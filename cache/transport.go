@@ -0,0 +1,184 @@
+package cache
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"time"
+)
+
+// The headers a 304 Not Modified response from the backend updates in the
+// entry it revalidates.
+var revalidatedHeaders = []string{
+	"Cache-Control",
+	"Date",
+	"ETag",
+	"Expires",
+	"Last-Modified",
+	"Surrogate-Control",
+}
+
+// Transport passes the requests for the resource at Key on to Next,
+// keeping what it may in Cache:
+//
+// A stale entry is revalidated: unless the client made the request
+// conditional itself, the backend is asked whether the entry is still
+// current, and a 304 Not Modified response renews it instead of reaching
+// the client. Cacheable responses are stored as their body is read.
+// Requests that may change the resource, such as POST, remove its entry.
+// Entries keep the surrogate headers of their response, which clients get
+// without them.
+type Transport struct {
+	Next  http.RoundTripper
+	Cache *Cache
+	Key   string
+}
+
+func (t *Transport) RoundTrip(request *http.Request) (*http.Response, error) {
+	if request.Method != "GET" && request.Method != "HEAD" {
+		res, err := t.Next.RoundTrip(request)
+		if err == nil {
+			if res.StatusCode < 400 {
+				t.Cache.Delete(t.Key)
+			}
+			stripSurrogateHeaders(res.Header)
+		}
+		return res, err
+	}
+
+	entry := t.Cache.Get(t.Key)
+
+	outreq := request
+	revalidating := false
+	if entry != nil && !conditional(request) && servable(request) {
+		outreq = revalidationRequest(request, entry)
+		revalidating = outreq != request
+	}
+
+	res, err := t.Next.RoundTrip(outreq)
+	if err != nil {
+		return res, err
+	}
+
+	now := t.Cache.clock.Now()
+
+	if revalidating && res.StatusCode == http.StatusNotModified {
+		res.Body.Close()
+
+		entry = renew(entry, res.Header, now)
+		t.Cache.Put(t.Key, entry)
+		return entry.Response(request, now), nil
+	}
+
+	if ttl, ok := storable(request, res, now); ok {
+		header := cloneHeader(res.Header)
+		res.Body = &recorder{
+			ReadCloser: res.Body,
+			max:        t.Cache.maxEntryBytes,
+			store: func(body []byte) {
+				t.Cache.Put(t.Key, &Entry{
+					StatusCode: res.StatusCode,
+					Header:     header,
+					Body:       body,
+					StoredAt:   now,
+					TTL:        ttl,
+				})
+			},
+		}
+	}
+
+	stripSurrogateHeaders(res.Header)
+	return res, nil
+}
+
+func conditional(request *http.Request) bool {
+	return request.Header.Get("If-None-Match") != "" || request.Header.Get("If-Modified-Since") != ""
+}
+
+// revalidationRequest returns a copy of request that carries the
+// validators of entry. It returns request itself when entry has none.
+func revalidationRequest(request *http.Request, entry *Entry) *http.Request {
+	etag := entry.Header.Get("ETag")
+	lastModified := entry.Header.Get("Last-Modified")
+	if etag == "" && lastModified == "" {
+		return request
+	}
+
+	outreq := new(http.Request)
+	*outreq = *request
+	outreq.Header = cloneHeader(request.Header)
+
+	if etag != "" {
+		outreq.Header.Set("If-None-Match", etag)
+	}
+	if lastModified != "" {
+		outreq.Header.Set("If-Modified-Since", lastModified)
+	}
+
+	return outreq
+}
+
+// renew returns a copy of entry that is fresh again as of now, with the
+// headers of the 304 Not Modified response that renewed it.
+func renew(entry *Entry, header http.Header, now time.Time) *Entry {
+	renewed := *entry
+	renewed.Header = cloneHeader(entry.Header)
+	for _, h := range revalidatedHeaders {
+		h = http.CanonicalHeaderKey(h)
+		if v, ok := header[h]; ok {
+			renewed.Header[h] = v
+		}
+	}
+
+	renewed.StoredAt = now
+	renewed.TTL = lifetime(renewed.Header, now)
+
+	return &renewed
+}
+
+func stripSurrogateHeaders(h http.Header) {
+	for _, name := range SurrogateHeaders {
+		h.Del(name)
+	}
+}
+
+func cloneHeader(h http.Header) http.Header {
+	h2 := make(http.Header, len(h))
+	for k, v := range h {
+		v2 := make([]string, len(v))
+		copy(v2, v)
+		h2[k] = v2
+	}
+	return h2
+}
+
+// recorder keeps a copy of a response body as it is read, and stores it
+// once it has been read in full. Bodies over max bytes are not kept.
+type recorder struct {
+	io.ReadCloser
+
+	max     int64
+	store   func(body []byte)
+	buf     bytes.Buffer
+	stopped bool
+}
+
+func (r *recorder) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+
+	if !r.stopped {
+		if int64(r.buf.Len()+n) > r.max {
+			r.stopped = true
+			r.buf = bytes.Buffer{}
+		} else {
+			r.buf.Write(p[:n])
+		}
+	}
+
+	if err == io.EOF && !r.stopped {
+		r.stopped = true
+		r.store(r.buf.Bytes())
+	}
+
+	return n, err
+}
@@ -0,0 +1,288 @@
+package cache_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/cloudfoundry/gorouter/cache"
+	"github.com/cloudfoundry/gorouter/clock/fakeclock"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type fakeRoundTripper struct {
+	requests []*http.Request
+	status   int
+	header   http.Header
+	body     string
+}
+
+func (f *fakeRoundTripper) RoundTrip(request *http.Request) (*http.Response, error) {
+	f.requests = append(f.requests, request)
+
+	header := make(http.Header)
+	for k, v := range f.header {
+		header[k] = v
+	}
+
+	return &http.Response{
+		StatusCode: f.status,
+		Header:     header,
+		Body:       ioutil.NopCloser(strings.NewReader(f.body)),
+		Request:    request,
+	}, nil
+}
+
+var _ = Describe("Transport", func() {
+	var c *cache.Cache
+	var clk *fakeclock.FakeClock
+	var next *fakeRoundTripper
+	var transport *cache.Transport
+
+	BeforeEach(func() {
+		clk = fakeclock.New(time.Now())
+		c = cache.New(1024, 1024, clk)
+		next = &fakeRoundTripper{
+			status: http.StatusOK,
+			header: http.Header{
+				"Etag":          []string{`"v1"`},
+				"Cache-Control": []string{"max-age=60"},
+			},
+			body: "hello",
+		}
+		transport = &cache.Transport{Next: next, Cache: c, Key: "app/"}
+	})
+
+	roundTrip := func(method string, header http.Header) (*http.Response, string) {
+		request, _ := http.NewRequest(method, "http://app/", nil)
+		for k, v := range header {
+			request.Header[k] = v
+		}
+
+		res, err := transport.RoundTrip(request)
+		Ω(err).ToNot(HaveOccurred())
+
+		body, err := ioutil.ReadAll(res.Body)
+		Ω(err).ToNot(HaveOccurred())
+		res.Body.Close()
+
+		return res, string(body)
+	}
+
+	It("stores a cacheable response once its body is read", func() {
+		roundTrip("GET", nil)
+
+		entry := c.Get("app/")
+		Ω(entry).ToNot(BeNil())
+		Ω(string(entry.Body)).To(Equal("hello"))
+		Ω(entry.TTL).To(Equal(time.Minute))
+		Ω(entry.Header.Get("Etag")).To(Equal(`"v1"`))
+	})
+
+	It("stores responses as of its clock", func() {
+		clk.Increment(time.Hour)
+		roundTrip("GET", nil)
+
+		Ω(c.Get("app/").StoredAt).To(Equal(clk.Now()))
+	})
+
+	It("does not store a body that is not read in full", func() {
+		request, _ := http.NewRequest("GET", "http://app/", nil)
+		res, _ := transport.RoundTrip(request)
+		res.Body.Close()
+
+		Ω(c.Get("app/")).To(BeNil())
+	})
+
+	It("does not store bodies over the entry size limit", func() {
+		next.body = strings.Repeat("x", 2048)
+		roundTrip("GET", nil)
+
+		Ω(c.Get("app/")).To(BeNil())
+	})
+
+	It("stores a response without a lifetime when it has a validator", func() {
+		next.header.Set("Cache-Control", "no-cache")
+		roundTrip("GET", nil)
+
+		entry := c.Get("app/")
+		Ω(entry).ToNot(BeNil())
+		Ω(entry.TTL).To(BeZero())
+	})
+
+	It("takes the lifetime from Expires when there is no max-age", func() {
+		next.header.Del("Cache-Control")
+		next.header.Set("Date", "Mon, 12 Oct 2026 10:00:00 GMT")
+		next.header.Set("Expires", "Mon, 12 Oct 2026 10:05:00 GMT")
+		roundTrip("GET", nil)
+
+		Ω(c.Get("app/").TTL).To(Equal(5 * time.Minute))
+	})
+
+	It("prefers s-maxage to max-age", func() {
+		next.header.Set("Cache-Control", "max-age=60, s-maxage=600")
+		roundTrip("GET", nil)
+
+		Ω(c.Get("app/").TTL).To(Equal(10 * time.Minute))
+	})
+
+	Describe("Surrogate-Control", func() {
+		It("takes the lifetime from it over Cache-Control", func() {
+			next.header.Set("Cache-Control", "private, no-cache")
+			next.header.Set("Surrogate-Control", "max-age=300")
+			roundTrip("GET", nil)
+
+			Ω(c.Get("app/").TTL).To(Equal(5 * time.Minute))
+		})
+
+		It("does not store responses it says not to", func() {
+			next.header.Set("Surrogate-Control", "no-store")
+			roundTrip("GET", nil)
+
+			Ω(c.Get("app/")).To(BeNil())
+		})
+
+		It("ignores directives targeted at other surrogates", func() {
+			next.header.Set("Surrogate-Control", "max-age=300;cdn")
+			roundTrip("GET", nil)
+
+			Ω(c.Get("app/").TTL).To(Equal(time.Minute))
+		})
+
+		It("strips the surrogate headers from responses but keeps them in the entry", func() {
+			next.header.Set("Surrogate-Control", "max-age=300")
+			next.header.Set("Surrogate-Key", "items")
+
+			res, _ := roundTrip("GET", nil)
+			Ω(res.Header).ToNot(HaveKey("Surrogate-Control"))
+			Ω(res.Header).ToNot(HaveKey("Surrogate-Key"))
+			Ω(res.Header.Get("Cache-Control")).To(Equal("max-age=60"))
+
+			entry := c.Get("app/")
+			Ω(entry.Header.Get("Surrogate-Control")).To(Equal("max-age=300"))
+
+			request, _ := http.NewRequest("GET", "http://app/", nil)
+			cached := entry.Response(request, time.Now())
+			Ω(cached.Header).ToNot(HaveKey("Surrogate-Control"))
+			Ω(cached.Header).ToNot(HaveKey("Surrogate-Key"))
+		})
+
+		It("renews the lifetime from the Surrogate-Control of a 304", func() {
+			next.header.Set("Cache-Control", "max-age=0")
+			roundTrip("GET", nil)
+
+			next.status = http.StatusNotModified
+			next.header = http.Header{"Surrogate-Control": []string{"max-age=120"}}
+			next.body = ""
+
+			res, _ := roundTrip("GET", nil)
+			Ω(res.Header).ToNot(HaveKey("Surrogate-Control"))
+			Ω(c.Get("app/").TTL).To(Equal(2 * time.Minute))
+		})
+	})
+
+	Describe("responses it does not store", func() {
+		notStored := func(header http.Header, requestHeader http.Header) {
+			for k, v := range header {
+				next.header[k] = v
+			}
+			roundTrip("GET", requestHeader)
+
+			Ω(c.Get("app/")).To(BeNil())
+		}
+
+		It("does not store no-store responses", func() {
+			notStored(http.Header{"Cache-Control": []string{"no-store"}}, nil)
+		})
+
+		It("does not store private responses", func() {
+			notStored(http.Header{"Cache-Control": []string{"private, max-age=60"}}, nil)
+		})
+
+		It("does not store responses that set cookies", func() {
+			notStored(http.Header{"Set-Cookie": []string{"a=b"}}, nil)
+		})
+
+		It("does not store responses that vary", func() {
+			notStored(http.Header{"Vary": []string{"Accept-Language"}}, nil)
+		})
+
+		It("does not store responses without a lifetime or validators", func() {
+			notStored(http.Header{"Cache-Control": []string{"no-cache"}, "Etag": nil}, nil)
+		})
+
+		It("does not store responses to requests with credentials", func() {
+			notStored(nil, http.Header{"Authorization": []string{"Bearer token"}})
+		})
+
+		It("does not store responses to requests with no-store", func() {
+			notStored(nil, http.Header{"Cache-Control": []string{"no-store"}})
+		})
+	})
+
+	Context("with a stale entry", func() {
+		BeforeEach(func() {
+			next.header.Set("Cache-Control", "max-age=0")
+			next.header.Set("Last-Modified", "Mon, 12 Oct 2026 10:00:00 GMT")
+			roundTrip("GET", nil)
+			next.requests = nil
+		})
+
+		It("revalidates it with its validators", func() {
+			next.status = http.StatusNotModified
+			next.header = http.Header{"Cache-Control": []string{"max-age=30"}}
+			next.body = ""
+
+			res, body := roundTrip("GET", nil)
+			Ω(res.StatusCode).To(Equal(http.StatusOK))
+			Ω(body).To(Equal("hello"))
+
+			Ω(next.requests).To(HaveLen(1))
+			Ω(next.requests[0].Header.Get("If-None-Match")).To(Equal(`"v1"`))
+			Ω(next.requests[0].Header.Get("If-Modified-Since")).To(Equal("Mon, 12 Oct 2026 10:00:00 GMT"))
+		})
+
+		It("renews it when the backend says it was not modified", func() {
+			next.status = http.StatusNotModified
+			next.header = http.Header{"Cache-Control": []string{"max-age=30"}}
+			next.body = ""
+
+			roundTrip("GET", nil)
+
+			entry := c.Get("app/")
+			Ω(entry.TTL).To(Equal(30 * time.Second))
+			Ω(entry.Fresh(clk.Now())).To(BeTrue())
+			Ω(entry.Header.Get("Etag")).To(Equal(`"v1"`))
+		})
+
+		It("replaces it when the backend sends a new response", func() {
+			next.header.Set("Etag", `"v2"`)
+			next.body = "hello again"
+
+			_, body := roundTrip("GET", nil)
+			Ω(body).To(Equal("hello again"))
+			Ω(string(c.Get("app/").Body)).To(Equal("hello again"))
+		})
+
+		It("passes a conditional request of the client through as it is", func() {
+			next.status = http.StatusNotModified
+			next.body = ""
+
+			res, _ := roundTrip("GET", http.Header{"If-None-Match": []string{`"v0"`}})
+			Ω(res.StatusCode).To(Equal(http.StatusNotModified))
+
+			Ω(next.requests[0].Header.Get("If-None-Match")).To(Equal(`"v0"`))
+			Ω(next.requests[0].Header).ToNot(HaveKey("If-Modified-Since"))
+		})
+	})
+
+	It("removes the entry when a request changes the resource", func() {
+		roundTrip("GET", nil)
+		Ω(c.Get("app/")).ToNot(BeNil())
+
+		roundTrip("POST", nil)
+		Ω(c.Get("app/")).To(BeNil())
+	})
+})
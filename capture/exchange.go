@@ -0,0 +1,200 @@
+package capture
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"time"
+)
+
+// redactedHeaders are recorded without their values.
+var redactedHeaders = []string{
+	"Authorization",
+	"Proxy-Authorization",
+	"Cookie",
+	"Set-Cookie",
+}
+
+type record struct {
+	StartedAt  time.Time `json:"started_at"`
+	DurationMs int64     `json:"duration_ms"`
+	Request    message   `json:"request"`
+	Response   message   `json:"response"`
+	Hijacked   bool      `json:"hijacked,omitempty"`
+}
+
+// A message is one side of an exchange. Bodies are recorded only when the
+// capture asks for them, up to its body size limit, and are base64 encoded
+// since they need not be text.
+type message struct {
+	Method        string      `json:"method,omitempty"`
+	URI           string      `json:"uri,omitempty"`
+	Host          string      `json:"host,omitempty"`
+	Proto         string      `json:"proto,omitempty"`
+	RemoteAddr    string      `json:"remote_addr,omitempty"`
+	StatusCode    int         `json:"status_code,omitempty"`
+	Header        http.Header `json:"header"`
+	Body          []byte      `json:"body,omitempty"`
+	BodyBytes     int64       `json:"body_bytes"`
+	BodyTruncated bool        `json:"body_truncated,omitempty"`
+}
+
+// An Exchange records one request and the response the client gets for it.
+type Exchange struct {
+	recorder *Recorder
+	session  *session
+	record   record
+
+	requestBody  *bodyRecorder
+	responseBody *bodyRecorder
+	writer       *responseWriter
+}
+
+func newExchange(r *Recorder, s *session, request *http.Request) *Exchange {
+	x := &Exchange{
+		recorder: r,
+		session:  s,
+		record: record{
+			StartedAt: time.Now(),
+			Request: message{
+				Method:     request.Method,
+				URI:        request.RequestURI,
+				Host:       request.Host,
+				Proto:      request.Proto,
+				RemoteAddr: request.RemoteAddr,
+				Header:     redact(request.Header),
+			},
+		},
+		requestBody:  newBodyRecorder(s.options),
+		responseBody: newBodyRecorder(s.options),
+	}
+
+	if request.Body != nil {
+		request.Body = &teeBody{ReadCloser: request.Body, recorder: x.requestBody}
+	}
+
+	return x
+}
+
+// ResponseWriter returns a writer that records the response written to w.
+func (x *Exchange) ResponseWriter(w http.ResponseWriter) http.ResponseWriter {
+	x.writer = &responseWriter{ResponseWriter: w, exchange: x}
+	return x.writer
+}
+
+// Finish writes the exchange to the capture file. It is called once the
+// response is complete.
+func (x *Exchange) Finish() {
+	rec := &x.record
+	rec.DurationMs = time.Since(rec.StartedAt).Nanoseconds() / int64(time.Millisecond)
+	x.requestBody.fill(&rec.Request)
+	x.responseBody.fill(&rec.Response)
+
+	if rec.Response.Header == nil && x.writer != nil && !rec.Hijacked {
+		// nothing was written, which the server answers with an empty 200
+		rec.Response.StatusCode = http.StatusOK
+		rec.Response.Header = redact(x.writer.Header())
+	}
+
+	x.recorder.write(x.session, rec)
+}
+
+func redact(header http.Header) http.Header {
+	h := make(http.Header, len(header))
+	for k, v := range header {
+		h[k] = append([]string(nil), v...)
+	}
+	for _, k := range redactedHeaders {
+		if _, ok := h[k]; ok {
+			h[k] = []string{"[redacted]"}
+		}
+	}
+	return h
+}
+
+type bodyRecorder struct {
+	keep      bool
+	max       int64
+	body      []byte
+	size      int64
+	truncated bool
+}
+
+func newBodyRecorder(o Options) *bodyRecorder {
+	return &bodyRecorder{keep: o.Bodies, max: o.MaxBodyBytes}
+}
+
+func (b *bodyRecorder) Write(p []byte) {
+	b.size += int64(len(p))
+	if !b.keep {
+		return
+	}
+
+	room := b.max - int64(len(b.body))
+	if int64(len(p)) > room {
+		p = p[:room]
+		b.truncated = true
+	}
+	b.body = append(b.body, p...)
+}
+
+func (b *bodyRecorder) fill(m *message) {
+	m.Body = b.body
+	m.BodyBytes = b.size
+	m.BodyTruncated = b.truncated
+}
+
+type teeBody struct {
+	io.ReadCloser
+	recorder *bodyRecorder
+}
+
+func (t *teeBody) Read(p []byte) (int, error) {
+	n, err := t.ReadCloser.Read(p)
+	t.recorder.Write(p[:n])
+	return n, err
+}
+
+type responseWriter struct {
+	http.ResponseWriter
+	exchange *Exchange
+}
+
+func (w *responseWriter) WriteHeader(status int) {
+	rec := &w.exchange.record
+	if rec.Response.Header == nil {
+		rec.Response.StatusCode = status
+		rec.Response.Header = redact(w.Header())
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *responseWriter) Write(b []byte) (int, error) {
+	if w.exchange.record.Response.Header == nil {
+		w.WriteHeader(http.StatusOK)
+	}
+
+	n, err := w.ResponseWriter.Write(b)
+	w.exchange.responseBody.Write(b[:n])
+	return n, err
+}
+
+func (w *responseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack hands over the connection. What goes over it afterwards is not
+// recorded.
+func (w *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response writer cannot be hijacked")
+	}
+
+	w.exchange.record.Hijacked = true
+	return h.Hijack()
+}
@@ -0,0 +1,76 @@
+package capture
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// ServeHTTP shows the state of the capture on GET, stops it on DELETE and
+// starts one on POST. A capture is described by the query parameters
+// `count`, `duration` in seconds, the filters `host`, `path_prefix` and
+// `method`, and `bodies=true` with an optional `max_body_bytes` to keep
+// bodies.
+func (r *Recorder) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case "GET":
+	case "DELETE":
+		r.Stop()
+	case "POST":
+		o, err := parseOptions(req)
+		if err == nil {
+			err = r.Start(o)
+		}
+		if err == ErrInvalidCount {
+			http.Error(w, "count must be between 1 and "+strconv.Itoa(r.maxCount), http.StatusBadRequest)
+			return
+		}
+		if err == ErrInvalidDuration {
+			http.Error(w, "duration must be between 1 and "+strconv.Itoa(int(r.maxDuration/time.Second))+" seconds", http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(r.Status())
+}
+
+func parseOptions(req *http.Request) (Options, error) {
+	q := req.URL.Query()
+
+	o := Options{
+		Filter: Filter{
+			Host:       q.Get("host"),
+			PathPrefix: q.Get("path_prefix"),
+			Method:     q.Get("method"),
+		},
+		Bodies: q.Get("bodies") == "true",
+	}
+
+	var err error
+	o.Count, err = strconv.Atoi(q.Get("count"))
+	if err != nil {
+		return o, ErrInvalidCount
+	}
+
+	seconds, err := strconv.Atoi(q.Get("duration"))
+	if err != nil {
+		return o, ErrInvalidDuration
+	}
+	o.Duration = time.Duration(seconds) * time.Second
+
+	if v := q.Get("max_body_bytes"); v != "" {
+		o.MaxBodyBytes, _ = strconv.ParseInt(v, 10, 64)
+	}
+
+	return o, nil
+}
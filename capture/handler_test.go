@@ -0,0 +1,86 @@
+package capture_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/cloudfoundry/gorouter/capture"
+	"github.com/cloudfoundry/gorouter/config"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Recorder endpoint", func() {
+	var dir string
+	var recorder *capture.Recorder
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "capture")
+		Ω(err).ToNot(HaveOccurred())
+
+		recorder = capture.NewRecorder(config.CaptureConfig{
+			File:         filepath.Join(dir, "capture.log"),
+			MaxRequests:  10,
+			MaxDuration:  time.Minute,
+			MaxBodyBytes: 1024,
+		})
+	})
+
+	AfterEach(func() {
+		recorder.Stop()
+		os.RemoveAll(dir)
+	})
+
+	serve := func(method, url string) (*httptest.ResponseRecorder, capture.Status) {
+		w := httptest.NewRecorder()
+		recorder.ServeHTTP(w, httptest.NewRequest(method, url, nil))
+
+		var status capture.Status
+		if w.Code == http.StatusOK {
+			Ω(json.Unmarshal(w.Body.Bytes(), &status)).To(Succeed())
+		}
+		return w, status
+	}
+
+	It("starts a capture", func() {
+		w, status := serve("POST", "/capture?count=5&duration=30&host=app.example.com&bodies=true")
+		Ω(w.Code).To(Equal(http.StatusOK))
+		Ω(status.Active).To(BeTrue())
+		Ω(status.Remaining).To(Equal(5))
+		Ω(status.Bodies).To(BeTrue())
+		Ω(status.Filter.Host).To(Equal("app.example.com"))
+	})
+
+	It("shows the capture", func() {
+		serve("POST", "/capture?count=5&duration=30")
+
+		_, status := serve("GET", "/capture")
+		Ω(status.Active).To(BeTrue())
+	})
+
+	It("stops the capture", func() {
+		serve("POST", "/capture?count=5&duration=30")
+
+		_, status := serve("DELETE", "/capture")
+		Ω(status.Active).To(BeFalse())
+	})
+
+	It("refuses captures beyond its limits", func() {
+		w, _ := serve("POST", "/capture?count=50&duration=30")
+		Ω(w.Code).To(Equal(http.StatusBadRequest))
+
+		w, _ = serve("POST", "/capture?count=5")
+		Ω(w.Code).To(Equal(http.StatusBadRequest))
+	})
+
+	It("refuses other methods", func() {
+		w, _ := serve("PUT", "/capture")
+		Ω(w.Code).To(Equal(http.StatusMethodNotAllowed))
+	})
+})
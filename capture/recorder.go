@@ -0,0 +1,245 @@
+package capture
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	steno "github.com/cloudfoundry/gosteno"
+
+	"github.com/cloudfoundry/gorouter/config"
+)
+
+var (
+	ErrInvalidCount    = errors.New("count is out of range")
+	ErrInvalidDuration = errors.New("duration is out of range")
+)
+
+// Filter picks the requests to capture. Empty fields match every request.
+type Filter struct {
+	Host       string `json:"host,omitempty"`
+	PathPrefix string `json:"path_prefix,omitempty"`
+	Method     string `json:"method,omitempty"`
+}
+
+func (f Filter) Match(request *http.Request) bool {
+	if f.Host != "" && !strings.EqualFold(hostWithoutPort(request.Host), f.Host) {
+		return false
+	}
+	if f.PathPrefix != "" && !strings.HasPrefix(request.URL.Path, f.PathPrefix) {
+		return false
+	}
+	if f.Method != "" && !strings.EqualFold(request.Method, f.Method) {
+		return false
+	}
+	return true
+}
+
+// Options describe a capture: which requests, how many of them, for how
+// long, and whether to keep their bodies.
+type Options struct {
+	Filter
+	Count        int
+	Duration     time.Duration
+	Bodies       bool
+	MaxBodyBytes int64
+}
+
+type Status struct {
+	Active    bool       `json:"active"`
+	File      string     `json:"file"`
+	Filter    *Filter    `json:"filter,omitempty"`
+	Bodies    bool       `json:"bodies,omitempty"`
+	Captured  int        `json:"captured"`
+	Remaining int        `json:"remaining"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// Recorder captures complete requests and responses to a file while an
+// operator has a capture running. Only one capture runs at a time; it ends
+// after its count of requests or its duration, whichever comes first. A nil
+// recorder captures nothing.
+type Recorder struct {
+	lock sync.Mutex
+
+	path         string
+	maxCount     int
+	maxDuration  time.Duration
+	maxBodyBytes int64
+	logger       *steno.Logger
+
+	session *session
+}
+
+type session struct {
+	options   Options
+	file      *os.File
+	encoder   *json.Encoder
+	captured  int
+	remaining int
+	expiresAt time.Time
+	timer     *time.Timer
+
+	// inFlight counts the exchanges still to be written, so the file is
+	// closed after the last one
+	inFlight sync.WaitGroup
+}
+
+func NewRecorder(c config.CaptureConfig) *Recorder {
+	return &Recorder{
+		path:         c.File,
+		maxCount:     c.MaxRequests,
+		maxDuration:  c.MaxDuration,
+		maxBodyBytes: c.MaxBodyBytes,
+		logger:       steno.NewLogger("router.capture"),
+	}
+}
+
+// Start begins a capture, replacing the one that is running. Captured
+// exchanges are appended to the recorder's file.
+func (r *Recorder) Start(o Options) error {
+	if o.Count < 1 || o.Count > r.maxCount {
+		return ErrInvalidCount
+	}
+	if o.Duration <= 0 || o.Duration > r.maxDuration {
+		return ErrInvalidDuration
+	}
+	if o.MaxBodyBytes <= 0 || o.MaxBodyBytes > r.maxBodyBytes {
+		o.MaxBodyBytes = r.maxBodyBytes
+	}
+
+	// captured headers can carry credentials, so the file is for the
+	// router's user only
+	file, err := os.OpenFile(r.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+
+	s := &session{
+		options:   o,
+		file:      file,
+		encoder:   json.NewEncoder(file),
+		remaining: o.Count,
+		expiresAt: time.Now().Add(o.Duration),
+	}
+
+	r.lock.Lock()
+	r.end()
+	r.session = s
+	s.timer = time.AfterFunc(o.Duration, func() {
+		r.lock.Lock()
+		if r.session == s {
+			r.end()
+		}
+		r.lock.Unlock()
+	})
+	r.lock.Unlock()
+
+	r.logger.Infod(map[string]interface{}{
+		"count":    o.Count,
+		"duration": o.Duration.String(),
+		"filter":   o.Filter,
+		"bodies":   o.Bodies,
+	}, "capture.started")
+
+	return nil
+}
+
+// Stop ends the running capture, if there is one.
+func (r *Recorder) Stop() {
+	r.lock.Lock()
+	r.end()
+	r.lock.Unlock()
+}
+
+func (r *Recorder) Status() Status {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	status := Status{File: r.path}
+
+	s := r.session
+	if s != nil {
+		status.Active = true
+		status.Filter = &s.options.Filter
+		status.Bodies = s.options.Bodies
+		status.Captured = s.captured
+		status.Remaining = s.remaining
+		expiresAt := s.expiresAt
+		status.ExpiresAt = &expiresAt
+	}
+
+	return status
+}
+
+// Begin starts capturing request when a capture is running and request
+// matches its filter, and returns nil otherwise.
+func (r *Recorder) Begin(request *http.Request) *Exchange {
+	if r == nil {
+		return nil
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	s := r.session
+	if s == nil || !s.options.Match(request) {
+		return nil
+	}
+
+	s.remaining--
+	s.inFlight.Add(1)
+	if s.remaining == 0 {
+		r.end()
+	}
+
+	return newExchange(r, s, request)
+}
+
+// end stops the session from taking on exchanges, and closes its file once
+// those it took on are written. It is called with the lock held.
+func (r *Recorder) end() {
+	s := r.session
+	if s == nil {
+		return
+	}
+	r.session = nil
+
+	if s.timer != nil {
+		s.timer.Stop()
+	}
+
+	go func() {
+		s.inFlight.Wait()
+		s.file.Close()
+	}()
+
+	r.logger.Infod(map[string]interface{}{
+		"requests": s.options.Count - s.remaining,
+	}, "capture.stopped")
+}
+
+func (r *Recorder) write(s *session, rec *record) {
+	r.lock.Lock()
+	err := s.encoder.Encode(rec)
+	s.captured++
+	r.lock.Unlock()
+
+	if err != nil {
+		r.logger.Warnf("capture.write.failed: %s", err)
+	}
+
+	s.inFlight.Done()
+}
+
+func hostWithoutPort(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return host
+}
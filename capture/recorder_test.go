@@ -0,0 +1,212 @@
+package capture_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/cloudfoundry/gorouter/capture"
+	"github.com/cloudfoundry/gorouter/config"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type capturedMessage struct {
+	Method        string      `json:"method"`
+	URI           string      `json:"uri"`
+	Host          string      `json:"host"`
+	StatusCode    int         `json:"status_code"`
+	Header        http.Header `json:"header"`
+	Body          []byte      `json:"body"`
+	BodyBytes     int64       `json:"body_bytes"`
+	BodyTruncated bool        `json:"body_truncated"`
+}
+
+type capturedExchange struct {
+	Request  capturedMessage `json:"request"`
+	Response capturedMessage `json:"response"`
+}
+
+var _ = Describe("Recorder", func() {
+	var dir, path string
+	var recorder *capture.Recorder
+	var options capture.Options
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "capture")
+		Ω(err).ToNot(HaveOccurred())
+		path = filepath.Join(dir, "capture.log")
+
+		recorder = capture.NewRecorder(config.CaptureConfig{
+			File:         path,
+			MaxRequests:  10,
+			MaxDuration:  time.Minute,
+			MaxBodyBytes: 8,
+		})
+
+		options = capture.Options{Count: 2, Duration: time.Minute}
+	})
+
+	AfterEach(func() {
+		recorder.Stop()
+		os.RemoveAll(dir)
+	})
+
+	// exchange sends a request through the recorder to a handler that
+	// echoes its body.
+	exchange := func(method, url, body string) {
+		request := httptest.NewRequest(method, url, strings.NewReader(body))
+		request.Header.Set("Authorization", "Bearer secret")
+		w := httptest.NewRecorder()
+
+		x := recorder.Begin(request)
+		if x == nil {
+			return
+		}
+
+		rw := x.ResponseWriter(w)
+		b, _ := ioutil.ReadAll(request.Body)
+		rw.Header().Set("Content-Type", "text/plain")
+		rw.Header().Set("Set-Cookie", "session=secret")
+		rw.WriteHeader(http.StatusCreated)
+		rw.Write(b)
+		x.Finish()
+	}
+
+	captured := func() []capturedExchange {
+		var exchanges []capturedExchange
+
+		f, err := os.Open(path)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		Ω(err).ToNot(HaveOccurred())
+		defer f.Close()
+
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			var x capturedExchange
+			Ω(json.Unmarshal(scanner.Bytes(), &x)).To(Succeed())
+			exchanges = append(exchanges, x)
+		}
+		return exchanges
+	}
+
+	It("captures nothing until started", func() {
+		exchange("GET", "http://app.example.com/", "")
+		Ω(captured()).To(BeEmpty())
+		Ω(recorder.Status().Active).To(BeFalse())
+	})
+
+	It("captures nothing when nil", func() {
+		var nilRecorder *capture.Recorder
+		request := httptest.NewRequest("GET", "http://app.example.com/", nil)
+		Ω(nilRecorder.Begin(request)).To(BeNil())
+	})
+
+	It("captures requests and their responses with headers", func() {
+		Ω(recorder.Start(options)).To(Succeed())
+		exchange("POST", "http://app.example.com/things?a=b", "hello")
+
+		exchanges := captured()
+		Ω(exchanges).To(HaveLen(1))
+
+		x := exchanges[0]
+		Ω(x.Request.Method).To(Equal("POST"))
+		Ω(x.Request.URI).To(Equal("http://app.example.com/things?a=b"))
+		Ω(x.Request.Host).To(Equal("app.example.com"))
+		Ω(x.Request.BodyBytes).To(Equal(int64(5)))
+		Ω(x.Request.Body).To(BeEmpty())
+		Ω(x.Response.StatusCode).To(Equal(http.StatusCreated))
+		Ω(x.Response.Header.Get("Content-Type")).To(Equal("text/plain"))
+		Ω(x.Response.BodyBytes).To(Equal(int64(5)))
+	})
+
+	It("redacts credentials", func() {
+		Ω(recorder.Start(options)).To(Succeed())
+		exchange("GET", "http://app.example.com/", "")
+
+		x := captured()[0]
+		Ω(x.Request.Header.Get("Authorization")).To(Equal("[redacted]"))
+		Ω(x.Response.Header.Get("Set-Cookie")).To(Equal("[redacted]"))
+	})
+
+	It("keeps bodies up to the size limit when asked to", func() {
+		options.Bodies = true
+		Ω(recorder.Start(options)).To(Succeed())
+		exchange("POST", "http://app.example.com/", "hello")
+		exchange("POST", "http://app.example.com/", "hello, world")
+
+		exchanges := captured()
+		Ω(string(exchanges[0].Request.Body)).To(Equal("hello"))
+		Ω(string(exchanges[0].Response.Body)).To(Equal("hello"))
+		Ω(exchanges[0].Request.BodyTruncated).To(BeFalse())
+
+		Ω(string(exchanges[1].Request.Body)).To(Equal("hello, w"))
+		Ω(exchanges[1].Request.BodyTruncated).To(BeTrue())
+		Ω(exchanges[1].Request.BodyBytes).To(Equal(int64(12)))
+	})
+
+	It("captures only the requests that match its filter", func() {
+		options.Filter = capture.Filter{Host: "app.example.com", PathPrefix: "/api", Method: "GET"}
+		Ω(recorder.Start(options)).To(Succeed())
+
+		exchange("GET", "http://other.example.com/api", "")
+		exchange("GET", "http://app.example.com/", "")
+		exchange("POST", "http://app.example.com/api", "")
+		exchange("GET", "http://app.example.com:8080/api/things", "")
+
+		exchanges := captured()
+		Ω(exchanges).To(HaveLen(1))
+		Ω(exchanges[0].Request.URI).To(Equal("http://app.example.com:8080/api/things"))
+	})
+
+	It("stops after its count of requests", func() {
+		Ω(recorder.Start(options)).To(Succeed())
+		for i := 0; i < 3; i++ {
+			exchange("GET", "http://app.example.com/", "")
+		}
+
+		Ω(captured()).To(HaveLen(2))
+		Ω(recorder.Status().Active).To(BeFalse())
+	})
+
+	It("stops after its duration", func() {
+		options.Duration = 20 * time.Millisecond
+		Ω(recorder.Start(options)).To(Succeed())
+		Ω(recorder.Status().Active).To(BeTrue())
+
+		Eventually(func() bool { return recorder.Status().Active }).Should(BeFalse())
+
+		exchange("GET", "http://app.example.com/", "")
+		Ω(captured()).To(BeEmpty())
+	})
+
+	It("refuses captures beyond its limits", func() {
+		options.Count = 11
+		Ω(recorder.Start(options)).To(Equal(capture.ErrInvalidCount))
+
+		options.Count = 1
+		options.Duration = time.Hour
+		Ω(recorder.Start(options)).To(Equal(capture.ErrInvalidDuration))
+	})
+
+	It("reports its progress", func() {
+		Ω(recorder.Start(options)).To(Succeed())
+		exchange("GET", "http://app.example.com/", "")
+
+		status := recorder.Status()
+		Ω(status.Active).To(BeTrue())
+		Ω(status.File).To(Equal(path))
+		Ω(status.Captured).To(Equal(1))
+		Ω(status.Remaining).To(Equal(1))
+		Ω(status.ExpiresAt).ToNot(BeNil())
+	})
+})
@@ -0,0 +1,343 @@
+package certexpiry
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"sync"
+	"time"
+
+	steno "github.com/cloudfoundry/gosteno"
+
+	"github.com/cloudfoundry/gorouter/clock"
+	"github.com/cloudfoundry/gorouter/config"
+)
+
+// The kinds of certificates: those the router serves, those it presents to
+// the services it connects to, and the CAs it verifies others with.
+const (
+	KindFrontend = "frontend"
+	KindClient   = "client"
+	KindCA       = "ca"
+)
+
+const day = 24 * time.Hour
+
+// Expiry is when a certificate expires, and how many days it had left when
+// it was asked for.
+type Expiry struct {
+	Kind     string    `json:"kind"`
+	Name     string    `json:"name"`
+	NotAfter time.Time `json:"not_after"`
+	DaysLeft float64   `json:"days_left"`
+}
+
+// Readiness is what the monitor degrades while a certificate is about to
+// expire.
+type Readiness interface {
+	SetDegraded(reason string)
+}
+
+type source struct {
+	kind     string
+	expiries func() map[string]time.Time
+}
+
+// Monitor watches when the certificates of the router expire. A warning is
+// logged once for every warning day a certificate comes within, more urgent
+// for the last one, and an error at every check once it has expired.
+type Monitor struct {
+	clock     clock.Clock
+	logger    *steno.Logger
+	readiness Readiness
+
+	interval    time.Duration
+	warningDays []int
+	degradeDays int
+
+	lock     sync.Mutex
+	sources  []source
+	expiries []Expiry
+	stages   map[string]int
+
+	stopCh chan struct{}
+}
+
+func NewMonitor(c config.CertificateExpiryConfig, clk clock.Clock, readiness Readiness) *Monitor {
+	return &Monitor{
+		clock:     clk,
+		logger:    steno.NewLogger("router.certexpiry"),
+		readiness: readiness,
+
+		interval:    c.Interval,
+		warningDays: c.WarningDays,
+		degradeDays: c.DegradeReadinessDays,
+
+		stages: make(map[string]int),
+		stopCh: make(chan struct{}),
+	}
+}
+
+// Add has the certificates of expiries, by their names, watched as
+// certificates of kind. expiries is called at every check, so that
+// certificates that are reloaded are watched as they are now.
+func (m *Monitor) Add(kind string, expiries func() map[string]time.Time) {
+	m.lock.Lock()
+	m.sources = append(m.sources, source{kind: kind, expiries: expiries})
+	m.lock.Unlock()
+}
+
+// AddConfigured has the certificates that the router loads once from c
+// watched: those of the admin API and of the loggregator v2 client. All
+// that can be read are added, and the first one that cannot be is returned
+// as an error.
+func (m *Monitor) AddConfigured(c *config.Config) error {
+	var firstErr error
+	add := func(expiries map[string]time.Time, name string, notAfter time.Time, err error) {
+		if err != nil {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("%s: %s", name, err)
+			}
+			return
+		}
+		expiries[name] = notAfter
+	}
+
+	frontend := make(map[string]time.Time)
+	client := make(map[string]time.Time)
+	ca := make(map[string]time.Time)
+
+	if c.AdminApi.Certificate != nil {
+		notAfter, err := Leaf(c.AdminApi.Certificate)
+		add(frontend, "admin_api", notAfter, err)
+	}
+	if c.AdminApi.ClientCAPath != "" {
+		notAfter, err := PEMFile(c.AdminApi.ClientCAPath)
+		add(ca, "admin_api_client", notAfter, err)
+	}
+
+	if v2 := c.Logging.LoggregatorV2; v2.Enabled {
+		if v2.CertFile != "" {
+			notAfter, err := PEMFile(v2.CertFile)
+			add(client, "loggregator_v2", notAfter, err)
+		}
+		if v2.CAFile != "" {
+			notAfter, err := PEMFile(v2.CAFile)
+			add(ca, "loggregator_v2", notAfter, err)
+		}
+	}
+
+	for kind, expiries := range map[string]map[string]time.Time{KindFrontend: frontend, KindClient: client, KindCA: ca} {
+		if len(expiries) > 0 {
+			m.Add(kind, static(expiries))
+		}
+	}
+
+	return firstErr
+}
+
+func static(expiries map[string]time.Time) func() map[string]time.Time {
+	return func() map[string]time.Time {
+		return expiries
+	}
+}
+
+// Run checks the certificates right away and then every interval until Stop
+// is called.
+func (m *Monitor) Run() {
+	ticker := m.clock.NewTicker(m.interval)
+	defer ticker.Stop()
+
+	for {
+		m.Check()
+
+		select {
+		case <-ticker.C():
+		case <-m.stopCh:
+			return
+		}
+	}
+}
+
+func (m *Monitor) Stop() {
+	close(m.stopCh)
+}
+
+// Check finds out when every certificate expires, logs the warnings that
+// are due and degrades readiness or restores it.
+func (m *Monitor) Check() {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	now := m.clock.Now()
+
+	var expiries []Expiry
+	for _, s := range m.sources {
+		for name, notAfter := range s.expiries() {
+			expiries = append(expiries, Expiry{
+				Kind:     s.kind,
+				Name:     name,
+				NotAfter: notAfter,
+				DaysLeft: notAfter.Sub(now).Hours() / 24,
+			})
+		}
+	}
+	sort.Sort(byExpiry(expiries))
+
+	stages := make(map[string]int)
+	var degraded *Expiry
+	for i := range expiries {
+		e := &expiries[i]
+		key := e.Kind + "/" + e.Name
+		stages[key] = m.stage(e.DaysLeft)
+		m.warn(e, m.stages[key], stages[key])
+
+		if degraded == nil && m.degradeDays > 0 && e.DaysLeft < float64(m.degradeDays) {
+			degraded = e
+		}
+	}
+
+	m.expiries = expiries
+	m.stages = stages
+
+	if m.readiness != nil && m.degradeDays > 0 {
+		reason := ""
+		if degraded != nil {
+			reason = fmt.Sprintf("%s certificate %s expires %s", degraded.Kind, degraded.Name, degraded.NotAfter.UTC().Format(time.RFC3339))
+		}
+		m.readiness.SetDegraded(reason)
+	}
+}
+
+// stage counts the warning days a certificate has come within, and is past
+// them all once it has expired.
+func (m *Monitor) stage(daysLeft float64) int {
+	if daysLeft <= 0 {
+		return len(m.warningDays) + 1
+	}
+
+	stage := 0
+	for _, days := range m.warningDays {
+		if daysLeft < float64(days) {
+			stage++
+		}
+	}
+	return stage
+}
+
+func (m *Monitor) warn(e *Expiry, was, is int) {
+	data := map[string]interface{}{
+		"kind":      e.Kind,
+		"name":      e.Name,
+		"not_after": e.NotAfter,
+		"days_left": int(e.DaysLeft),
+	}
+
+	switch {
+	case is > len(m.warningDays):
+		m.logger.Errord(data, "certificate.expired")
+	case is > was && is == len(m.warningDays):
+		m.logger.Errord(data, "certificate.expiring")
+	case is > was:
+		m.logger.Warnd(data, "certificate.expiring")
+	case is < was && is == 0:
+		m.logger.Infod(data, "certificate.renewed")
+	}
+}
+
+// Expiries returns the certificates of the last check, the first to expire
+// first, with the days they have left now.
+func (m *Monitor) Expiries() []Expiry {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	now := m.clock.Now()
+	expiries := make([]Expiry, len(m.expiries))
+	for i, e := range m.expiries {
+		e.DaysLeft = e.NotAfter.Sub(now).Hours() / 24
+		expiries[i] = e
+	}
+	return expiries
+}
+
+// NotAfter returns when the certificates of the last check expire, by their
+// kind and name.
+func (m *Monitor) NotAfter() map[string]time.Time {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	notAfter := make(map[string]time.Time, len(m.expiries))
+	for _, e := range m.expiries {
+		notAfter[e.Kind+"/"+e.Name] = e.NotAfter
+	}
+	return notAfter
+}
+
+type byExpiry []Expiry
+
+func (e byExpiry) Len() int      { return len(e) }
+func (e byExpiry) Swap(i, j int) { e[i], e[j] = e[j], e[i] }
+func (e byExpiry) Less(i, j int) bool {
+	if !e[i].NotAfter.Equal(e[j].NotAfter) {
+		return e[i].NotAfter.Before(e[j].NotAfter)
+	}
+	if e[i].Kind != e[j].Kind {
+		return e[i].Kind < e[j].Kind
+	}
+	return e[i].Name < e[j].Name
+}
+
+// Leaf returns when the leaf of cert expires.
+func Leaf(cert *tls.Certificate) (time.Time, error) {
+	if cert.Leaf != nil {
+		return cert.Leaf.NotAfter, nil
+	}
+	if len(cert.Certificate) == 0 {
+		return time.Time{}, errors.New("no certificate")
+	}
+
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return time.Time{}, err
+	}
+	return leaf.NotAfter, nil
+}
+
+// PEMFile returns when the first of the certificates of the PEM file at
+// path to expire does, which for a chain is usually its leaf and for a CA
+// bundle the CA that needs replacing first.
+func PEMFile(path string) (time.Time, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	var first time.Time
+	for {
+		var block *pem.Block
+		block, b = pem.Decode(b)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if first.IsZero() || cert.NotAfter.Before(first) {
+			first = cert.NotAfter
+		}
+	}
+
+	if first.IsZero() {
+		return time.Time{}, errors.New("no certificates found in " + path)
+	}
+	return first, nil
+}
@@ -0,0 +1,18 @@
+package certexpiry_test
+
+import (
+	steno "github.com/cloudfoundry/gosteno"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestCertexpiry(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Certexpiry Suite")
+}
+
+var _ = BeforeSuite(func() {
+	steno.EnterTestMode(steno.LOG_INFO)
+})
@@ -0,0 +1,224 @@
+package certexpiry_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"time"
+
+	steno "github.com/cloudfoundry/gosteno"
+
+	"github.com/cloudfoundry/gorouter/certexpiry"
+	"github.com/cloudfoundry/gorouter/clock/fakeclock"
+	"github.com/cloudfoundry/gorouter/config"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+const day = 24 * time.Hour
+
+type fakeReadiness struct {
+	reasons []string
+}
+
+func (f *fakeReadiness) SetDegraded(reason string) {
+	f.reasons = append(f.reasons, reason)
+}
+
+func certificatePEM(notAfter time.Time) []byte {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	Ω(err).NotTo(HaveOccurred())
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "example.com"},
+		NotBefore:    notAfter.Add(-365 * day),
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	Ω(err).NotTo(HaveOccurred())
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+var _ = Describe("Monitor", func() {
+	var monitor *certexpiry.Monitor
+	var fakeClock *fakeclock.FakeClock
+	var readiness *fakeReadiness
+	var c config.CertificateExpiryConfig
+	var expiries map[string]time.Time
+	var logged int
+
+	// messages returns what the monitor logged since the last call.
+	messages := func() []string {
+		var messages []string
+		records := steno.GetMeTheGlobalTestSink().Records()
+		for _, r := range records[logged:] {
+			if r.Source == "router.certexpiry" {
+				messages = append(messages, r.Level.String()+" "+r.Message+" "+r.Data["name"].(string))
+			}
+		}
+		logged = len(records)
+		return messages
+	}
+
+	BeforeEach(func() {
+		fakeClock = fakeclock.New(time.Unix(1500000000, 0))
+		readiness = &fakeReadiness{}
+		c = config.DefaultConfig().CertificateExpiry
+		expiries = map[string]time.Time{
+			"default":     fakeClock.Now().Add(90 * day),
+			"example.com": fakeClock.Now().Add(20 * day),
+		}
+		logged = len(steno.GetMeTheGlobalTestSink().Records())
+	})
+
+	JustBeforeEach(func() {
+		monitor = certexpiry.NewMonitor(c, fakeClock, readiness)
+		monitor.Add(certexpiry.KindFrontend, func() map[string]time.Time { return expiries })
+	})
+
+	It("reports the days left of every certificate, the first to expire first", func() {
+		monitor.Check()
+		fakeClock.Increment(12 * time.Hour)
+
+		Ω(monitor.Expiries()).To(Equal([]certexpiry.Expiry{
+			{Kind: certexpiry.KindFrontend, Name: "example.com", NotAfter: expiries["example.com"], DaysLeft: 19.5},
+			{Kind: certexpiry.KindFrontend, Name: "default", NotAfter: expiries["default"], DaysLeft: 89.5},
+		}))
+		Ω(monitor.NotAfter()).To(HaveKeyWithValue("frontend/example.com", expiries["example.com"]))
+	})
+
+	It("warns once for every warning day a certificate comes within", func() {
+		monitor.Check()
+		Ω(messages()).To(Equal([]string{"warn certificate.expiring example.com"}))
+
+		monitor.Check()
+		fakeClock.Increment(5 * day)
+		monitor.Check()
+		Ω(messages()).To(BeEmpty())
+
+		fakeClock.Increment(day + time.Hour)
+		monitor.Check()
+		Ω(messages()).To(Equal([]string{"warn certificate.expiring example.com"}))
+
+		fakeClock.Increment(13 * day)
+		monitor.Check()
+		Ω(messages()).To(Equal([]string{"error certificate.expiring example.com"}))
+
+		fakeClock.Increment(day)
+		monitor.Check()
+		monitor.Check()
+		Ω(messages()).To(Equal([]string{"error certificate.expired example.com", "error certificate.expired example.com"}))
+	})
+
+	It("logs a certificate that was renewed", func() {
+		monitor.Check()
+		messages()
+
+		expiries["example.com"] = fakeClock.Now().Add(90 * day)
+		monitor.Check()
+		Ω(messages()).To(Equal([]string{"info certificate.renewed example.com"}))
+	})
+
+	It("leaves readiness alone by default", func() {
+		expiries["example.com"] = fakeClock.Now().Add(time.Hour)
+		monitor.Check()
+
+		Ω(readiness.reasons).To(BeEmpty())
+	})
+
+	Context("when readiness is degraded within a few days of expiry", func() {
+		BeforeEach(func() {
+			c.DegradeReadinessDays = 7
+		})
+
+		It("degrades it while a certificate is that close to expiring", func() {
+			monitor.Check()
+			Ω(readiness.reasons).To(Equal([]string{""}))
+
+			fakeClock.Increment(14 * day)
+			monitor.Check()
+			Ω(readiness.reasons[1]).To(Equal("frontend certificate example.com expires 2017-08-03T02:40:00Z"))
+
+			expiries["example.com"] = fakeClock.Now().Add(90 * day)
+			monitor.Check()
+			Ω(readiness.reasons[2]).To(BeEmpty())
+		})
+	})
+
+	Describe("AddConfigured", func() {
+		var dir string
+
+		BeforeEach(func() {
+			var err error
+			dir, err = ioutil.TempDir("", "certexpiry")
+			Ω(err).NotTo(HaveOccurred())
+		})
+
+		AfterEach(func() {
+			os.RemoveAll(dir)
+		})
+
+		write := func(name string, notAfter ...time.Time) string {
+			var b []byte
+			for _, t := range notAfter {
+				b = append(b, certificatePEM(t)...)
+			}
+			path := filepath.Join(dir, name)
+			Ω(ioutil.WriteFile(path, b, 0600)).To(Succeed())
+			return path
+		}
+
+		It("watches the certificates the router loads once", func() {
+			now := fakeClock.Now().Truncate(time.Second)
+			leaf, err := x509.ParseCertificate(mustDecode(certificatePEM(now.Add(40 * day))))
+			Ω(err).NotTo(HaveOccurred())
+
+			cfg := config.DefaultConfig()
+			cfg.AdminApi.Certificate = &tls.Certificate{Certificate: [][]byte{leaf.Raw}}
+			cfg.AdminApi.ClientCAPath = write("admin_ca.pem", now.Add(300*day))
+			cfg.Logging.LoggregatorV2.Enabled = true
+			cfg.Logging.LoggregatorV2.CertFile = write("client.pem", now.Add(50*day), now.Add(400*day))
+			cfg.Logging.LoggregatorV2.CAFile = write("ca.pem", now.Add(600*day), now.Add(200*day))
+
+			Ω(monitor.AddConfigured(cfg)).To(Succeed())
+			monitor.Check()
+
+			notAfter := monitor.NotAfter()
+			Ω(notAfter).To(HaveKeyWithValue("frontend/admin_api", now.Add(40*day).UTC()))
+			Ω(notAfter).To(HaveKeyWithValue("ca/admin_api_client", now.Add(300*day).UTC()))
+			Ω(notAfter).To(HaveKeyWithValue("client/loggregator_v2", now.Add(50*day).UTC()))
+			Ω(notAfter).To(HaveKeyWithValue("ca/loggregator_v2", now.Add(200*day).UTC()))
+		})
+
+		It("adds what it can read and fails on the rest", func() {
+			now := fakeClock.Now().Truncate(time.Second)
+
+			cfg := config.DefaultConfig()
+			cfg.AdminApi.ClientCAPath = write("admin_ca.pem", now.Add(300*day))
+			cfg.Logging.LoggregatorV2.Enabled = true
+			cfg.Logging.LoggregatorV2.CertFile = filepath.Join(dir, "missing.pem")
+
+			Ω(monitor.AddConfigured(cfg)).To(MatchError(ContainSubstring("loggregator_v2")))
+			monitor.Check()
+
+			Ω(monitor.NotAfter()).To(HaveKey("ca/admin_api_client"))
+			Ω(monitor.NotAfter()).NotTo(HaveKey("client/loggregator_v2"))
+		})
+	})
+})
+
+func mustDecode(b []byte) []byte {
+	block, _ := pem.Decode(b)
+	Ω(block).NotTo(BeNil())
+	return block.Bytes
+}
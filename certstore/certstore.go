@@ -0,0 +1,239 @@
+// Package certstore serves the TLS certificates of the domains the router
+// is configured to expect. The certificates are loaded and checked before
+// the router listens, so that the first handshake for each domain finds its
+// chain parsed and its OCSP response stapled rather than paying for them.
+package certstore
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cloudfoundry/gorouter/config"
+	steno "github.com/cloudfoundry/gosteno"
+)
+
+// A Store picks the certificate of a handshake by its server name. A nil
+// Store has no certificates, and leaves every handshake to the default
+// certificate of the router.
+type Store struct {
+	sync.RWMutex
+
+	configs []config.TlsCertificateConfig
+	logger  *steno.Logger
+
+	exact    map[string]*tls.Certificate
+	wildcard map[string]*tls.Certificate
+	loaded   []loadedCertificate
+	ready    bool
+}
+
+// A loadedCertificate is what the status of a store tells of a certificate.
+type loadedCertificate struct {
+	Domains    []string  `json:"domains"`
+	Subject    string    `json:"subject"`
+	NotAfter   time.Time `json:"not_after"`
+	OcspStaple bool      `json:"ocsp_staple"`
+}
+
+// New returns the store of the certificates of c, which have to be
+// preloaded before it serves them, or nil when there are none.
+func New(c []config.TlsCertificateConfig) *Store {
+	if len(c) == 0 {
+		return nil
+	}
+
+	return &Store{
+		configs: c,
+		logger:  steno.NewLogger("router.certstore"),
+	}
+}
+
+// Preload loads the certificate chains and OCSP staples of the store, and
+// checks that each certificate covers its domains. Certificates that have
+// expired are served anyway, with a warning, as the clients that accept
+// them are better served by them than by the default certificate.
+func (s *Store) Preload() error {
+	if s == nil {
+		return nil
+	}
+
+	s.RLock()
+	configs := s.configs
+	s.RUnlock()
+	return s.preload(configs)
+}
+
+// Reload preloads the certificates of c in place of those of the store,
+// which keeps serving its certificates while they load, and when they fail
+// to. A nil Store has nowhere to serve certificates from, so certificates
+// added to a router configured without any wait for its restart.
+func (s *Store) Reload(c []config.TlsCertificateConfig) error {
+	if s == nil {
+		if len(c) == 0 {
+			return nil
+		}
+		return errors.New("tls certificates are served once the router restarts with them")
+	}
+
+	return s.preload(c)
+}
+
+func (s *Store) preload(configs []config.TlsCertificateConfig) error {
+	exact := make(map[string]*tls.Certificate)
+	wildcard := make(map[string]*tls.Certificate)
+	var loaded []loadedCertificate
+
+	for _, c := range configs {
+		cert, err := load(c)
+		if err != nil {
+			return err
+		}
+
+		if time.Now().After(cert.Leaf.NotAfter) {
+			s.logger.Warnd(map[string]interface{}{
+				"cert_path": c.CertPath,
+				"not_after": cert.Leaf.NotAfter,
+			}, "certstore.certificate-expired")
+		}
+
+		for _, domain := range c.Domains {
+			if strings.HasPrefix(domain, "*.") {
+				wildcard[domain[1:]] = cert
+			} else {
+				exact[domain] = cert
+			}
+		}
+
+		loaded = append(loaded, loadedCertificate{
+			Domains:    c.Domains,
+			Subject:    cert.Leaf.Subject.CommonName,
+			NotAfter:   cert.Leaf.NotAfter,
+			OcspStaple: len(cert.OCSPStaple) > 0,
+		})
+	}
+
+	s.Lock()
+	s.configs = configs
+	s.exact = exact
+	s.wildcard = wildcard
+	s.loaded = loaded
+	s.ready = true
+	s.Unlock()
+
+	s.logger.Infod(map[string]interface{}{"certificates": len(loaded)}, "certstore.preloaded")
+	return nil
+}
+
+// load loads the certificate of c, with its leaf parsed and its OCSP
+// response stapled.
+func load(c config.TlsCertificateConfig) (*tls.Certificate, error) {
+	cert, err := tls.LoadX509KeyPair(c.CertPath, c.KeyPath)
+	if err != nil {
+		return nil, fmt.Errorf("tls certificate %s: %s", c.CertPath, err)
+	}
+
+	cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return nil, fmt.Errorf("tls certificate %s: %s", c.CertPath, err)
+	}
+	for _, intermediate := range cert.Certificate[1:] {
+		_, err = x509.ParseCertificate(intermediate)
+		if err != nil {
+			return nil, fmt.Errorf("tls certificate %s: intermediate: %s", c.CertPath, err)
+		}
+	}
+
+	for _, domain := range c.Domains {
+		host := domain
+		if strings.HasPrefix(domain, "*.") {
+			host = "preload" + domain[1:]
+		}
+		err = cert.Leaf.VerifyHostname(host)
+		if err != nil {
+			return nil, fmt.Errorf("tls certificate %s does not cover %s", c.CertPath, domain)
+		}
+	}
+
+	if c.OcspStaplePath != "" {
+		cert.OCSPStaple, err = ioutil.ReadFile(c.OcspStaplePath)
+		if err != nil {
+			return nil, fmt.Errorf("tls certificate %s: %s", c.CertPath, err)
+		}
+		if len(cert.OCSPStaple) == 0 {
+			return nil, errors.New("empty ocsp staple: " + c.OcspStaplePath)
+		}
+	}
+
+	return &cert, nil
+}
+
+// Ready tells whether the certificates of the store are loaded. A nil
+// Store is always ready.
+func (s *Store) Ready() bool {
+	if s == nil {
+		return true
+	}
+
+	s.RLock()
+	defer s.RUnlock()
+	return s.ready
+}
+
+// GetCertificate returns the certificate of the server name of hello, the
+// certificate of its exact domain before that of a wildcard, or nil to have
+// the handshake use the default certificate. It is meant for the
+// GetCertificate of a tls.Config.
+func (s *Store) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	if s == nil || hello.ServerName == "" {
+		return nil, nil
+	}
+
+	name := strings.ToLower(strings.TrimSuffix(hello.ServerName, "."))
+
+	s.RLock()
+	defer s.RUnlock()
+
+	if cert, ok := s.exact[name]; ok {
+		return cert, nil
+	}
+	if i := strings.IndexByte(name, '.'); i > 0 {
+		if cert, ok := s.wildcard[name[i:]]; ok {
+			return cert, nil
+		}
+	}
+	return nil, nil
+}
+
+// NotAfter returns when each loaded certificate expires, by the domains
+// it is served for.
+func (s *Store) NotAfter() map[string]time.Time {
+	if s == nil {
+		return nil
+	}
+
+	s.RLock()
+	defer s.RUnlock()
+
+	notAfter := make(map[string]time.Time, len(s.loaded))
+	for _, c := range s.loaded {
+		notAfter[strings.Join(c.Domains, ",")] = c.NotAfter
+	}
+	return notAfter
+}
+
+func (s *Store) MarshalJSON() ([]byte, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	return json.Marshal(struct {
+		Ready        bool                `json:"ready"`
+		Certificates []loadedCertificate `json:"certificates"`
+	}{s.ready, s.loaded})
+}
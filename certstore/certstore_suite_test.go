@@ -0,0 +1,13 @@
+package certstore_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestCertstore(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Certstore Suite")
+}
@@ -0,0 +1,246 @@
+package certstore_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/cloudfoundry/gorouter/certstore"
+	"github.com/cloudfoundry/gorouter/config"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Store", func() {
+	var dir string
+
+	// certificate writes a self-signed certificate for names and its key to
+	// dir, and returns the config of the certificate for domains.
+	certificate := func(name string, domains []string, names ...string) config.TlsCertificateConfig {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		Ω(err).NotTo(HaveOccurred())
+
+		template := &x509.Certificate{
+			SerialNumber: big.NewInt(1),
+			Subject:      pkix.Name{CommonName: names[0]},
+			DNSNames:     names,
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(time.Hour),
+		}
+		der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+		Ω(err).NotTo(HaveOccurred())
+		keyDer, err := x509.MarshalECPrivateKey(key)
+		Ω(err).NotTo(HaveOccurred())
+
+		c := config.TlsCertificateConfig{
+			Domains:  domains,
+			CertPath: filepath.Join(dir, name+".crt"),
+			KeyPath:  filepath.Join(dir, name+".key"),
+		}
+		err = ioutil.WriteFile(c.CertPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+		Ω(err).NotTo(HaveOccurred())
+		err = ioutil.WriteFile(c.KeyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600)
+		Ω(err).NotTo(HaveOccurred())
+		return c
+	}
+
+	serve := func(s *certstore.Store, name string) (*x509.Certificate, error) {
+		cert, err := s.GetCertificate(&tls.ClientHelloInfo{ServerName: name})
+		if cert == nil {
+			return nil, err
+		}
+		return cert.Leaf, err
+	}
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "certstore")
+		Ω(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	It("is nil without certificates, and leaves handshakes to the default certificate", func() {
+		s := certstore.New(nil)
+		Ω(s).To(BeNil())
+
+		Ω(s.Preload()).To(Succeed())
+		Ω(s.Ready()).To(BeTrue())
+		Ω(serve(s, "app.example.com")).To(BeNil())
+	})
+
+	It("is ready once its certificates are preloaded", func() {
+		s := certstore.New([]config.TlsCertificateConfig{
+			certificate("app", []string{"app.example.com"}, "app.example.com"),
+		})
+		Ω(s.Ready()).To(BeFalse())
+		Ω(serve(s, "app.example.com")).To(BeNil())
+
+		Ω(s.Preload()).To(Succeed())
+		Ω(s.Ready()).To(BeTrue())
+	})
+
+	It("serves the certificate of the exact domain, then of a wildcard", func() {
+		s := certstore.New([]config.TlsCertificateConfig{
+			certificate("wildcard", []string{"*.example.com"}, "*.example.com"),
+			certificate("app", []string{"app.example.com"}, "app.example.com"),
+		})
+		Ω(s.Preload()).To(Succeed())
+
+		cert, err := serve(s, "App.Example.com.")
+		Ω(err).NotTo(HaveOccurred())
+		Ω(cert.Subject.CommonName).To(Equal("app.example.com"))
+
+		cert, err = serve(s, "other.example.com")
+		Ω(err).NotTo(HaveOccurred())
+		Ω(cert.Subject.CommonName).To(Equal("*.example.com"))
+
+		Ω(serve(s, "a.b.example.com")).To(BeNil())
+		Ω(serve(s, "example.org")).To(BeNil())
+		Ω(serve(s, "")).To(BeNil())
+	})
+
+	It("staples the ocsp response", func() {
+		c := certificate("app", []string{"app.example.com"}, "app.example.com")
+		c.OcspStaplePath = filepath.Join(dir, "app.ocsp")
+		Ω(ioutil.WriteFile(c.OcspStaplePath, []byte("response"), 0600)).To(Succeed())
+
+		s := certstore.New([]config.TlsCertificateConfig{c})
+		Ω(s.Preload()).To(Succeed())
+
+		cert, err := s.GetCertificate(&tls.ClientHelloInfo{ServerName: "app.example.com"})
+		Ω(err).NotTo(HaveOccurred())
+		Ω(cert.OCSPStaple).To(Equal([]byte("response")))
+	})
+
+	It("fails to preload a certificate that does not cover its domains", func() {
+		s := certstore.New([]config.TlsCertificateConfig{
+			certificate("app", []string{"app.example.com", "api.example.com"}, "app.example.com"),
+		})
+
+		Ω(s.Preload()).To(MatchError(ContainSubstring("does not cover api.example.com")))
+		Ω(s.Ready()).To(BeFalse())
+	})
+
+	It("fails to preload a missing or empty ocsp staple", func() {
+		c := certificate("app", []string{"app.example.com"}, "app.example.com")
+		c.OcspStaplePath = filepath.Join(dir, "app.ocsp")
+
+		s := certstore.New([]config.TlsCertificateConfig{c})
+		Ω(s.Preload()).NotTo(Succeed())
+
+		Ω(ioutil.WriteFile(c.OcspStaplePath, nil, 0600)).To(Succeed())
+		Ω(s.Preload()).To(MatchError(ContainSubstring("empty ocsp staple")))
+	})
+
+	It("fails to preload a certificate without its key", func() {
+		c := certificate("app", []string{"app.example.com"}, "app.example.com")
+		c.KeyPath = filepath.Join(dir, "missing.key")
+
+		s := certstore.New([]config.TlsCertificateConfig{c})
+		Ω(s.Preload()).NotTo(Succeed())
+	})
+
+	It("reloads its certificates, keeping them when the new ones fail to load", func() {
+		s := certstore.New([]config.TlsCertificateConfig{
+			certificate("app", []string{"app.example.com"}, "app.example.com"),
+		})
+		Ω(s.Preload()).To(Succeed())
+
+		missing := certificate("api", []string{"api.example.com"}, "api.example.com")
+		missing.KeyPath = filepath.Join(dir, "missing.key")
+		Ω(s.Reload([]config.TlsCertificateConfig{missing})).NotTo(Succeed())
+		Ω(serve(s, "app.example.com")).NotTo(BeNil())
+
+		Ω(s.Reload([]config.TlsCertificateConfig{
+			certificate("api", []string{"api.example.com"}, "api.example.com"),
+		})).To(Succeed())
+		Ω(serve(s, "app.example.com")).To(BeNil())
+		cert, err := serve(s, "api.example.com")
+		Ω(err).NotTo(HaveOccurred())
+		Ω(cert.Subject.CommonName).To(Equal("api.example.com"))
+
+		var none *certstore.Store
+		Ω(none.Reload(nil)).To(Succeed())
+		Ω(none.Reload([]config.TlsCertificateConfig{missing})).NotTo(Succeed())
+	})
+
+	It("completes handshakes with the certificate of the server name", func() {
+		s := certstore.New([]config.TlsCertificateConfig{
+			certificate("app", []string{"app.example.com"}, "app.example.com"),
+		})
+		Ω(s.Preload()).To(Succeed())
+		fallback := certificate("default", []string{"default.example.com"}, "default.example.com")
+		defaultCert, err := tls.LoadX509KeyPair(fallback.CertPath, fallback.KeyPath)
+		Ω(err).NotTo(HaveOccurred())
+
+		listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+			Certificates:   []tls.Certificate{defaultCert},
+			GetCertificate: s.GetCertificate,
+		})
+		Ω(err).NotTo(HaveOccurred())
+		defer listener.Close()
+		go func() {
+			for {
+				conn, err := listener.Accept()
+				if err != nil {
+					return
+				}
+				conn.(*tls.Conn).Handshake()
+				conn.Close()
+			}
+		}()
+
+		peer := func(name string) string {
+			conn, err := tls.Dial("tcp", listener.Addr().String(), &tls.Config{ServerName: name, InsecureSkipVerify: true})
+			Ω(err).NotTo(HaveOccurred())
+			defer conn.Close()
+			return conn.ConnectionState().PeerCertificates[0].Subject.CommonName
+		}
+
+		Ω(peer("app.example.com")).To(Equal("app.example.com"))
+		Ω(peer("other.example.com")).To(Equal("default.example.com"))
+	})
+
+	It("reports its certificates", func() {
+		s := certstore.New([]config.TlsCertificateConfig{
+			certificate("app", []string{"app.example.com"}, "app.example.com"),
+		})
+		Ω(s.Preload()).To(Succeed())
+
+		b, err := json.Marshal(s)
+		Ω(err).NotTo(HaveOccurred())
+
+		var status struct {
+			Ready        bool `json:"ready"`
+			Certificates []struct {
+				Domains    []string `json:"domains"`
+				Subject    string   `json:"subject"`
+				OcspStaple bool     `json:"ocsp_staple"`
+			} `json:"certificates"`
+		}
+		Ω(json.Unmarshal(b, &status)).To(Succeed())
+		Ω(status.Ready).To(BeTrue())
+		Ω(status.Certificates).To(HaveLen(1))
+		Ω(status.Certificates[0].Domains).To(Equal([]string{"app.example.com"}))
+		Ω(status.Certificates[0].Subject).To(Equal("app.example.com"))
+		Ω(status.Certificates[0].OcspStaple).To(BeFalse())
+
+		notAfter := s.NotAfter()
+		Ω(notAfter).To(HaveKey("app.example.com"))
+		Ω(notAfter["app.example.com"]).To(BeTemporally("~", time.Now().Add(time.Hour), time.Minute))
+	})
+})
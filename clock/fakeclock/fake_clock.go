@@ -0,0 +1,124 @@
+// Package fakeclock provides a clock that only moves when a test moves it.
+package fakeclock
+
+import (
+	"sync"
+	"time"
+
+	"github.com/cloudfoundry/gorouter/clock"
+)
+
+// FakeClock stands still until Increment moves it forward, firing the
+// timers and tickers that come due on the way.
+type FakeClock struct {
+	sync.Mutex
+
+	now      time.Time
+	watchers []*watcher
+}
+
+type watcher struct {
+	c        chan time.Time
+	at       time.Time
+	interval time.Duration
+	stopped  bool
+}
+
+func New(now time.Time) *FakeClock {
+	return &FakeClock{now: now}
+}
+
+func (c *FakeClock) Now() time.Time {
+	c.Lock()
+	defer c.Unlock()
+
+	return c.now
+}
+
+func (c *FakeClock) Since(t time.Time) time.Duration {
+	return c.Now().Sub(t)
+}
+
+func (c *FakeClock) After(d time.Duration) <-chan time.Time {
+	return c.watch(d, 0).c
+}
+
+func (c *FakeClock) NewTicker(d time.Duration) clock.Ticker {
+	return &fakeTicker{clock: c, watcher: c.watch(d, d)}
+}
+
+// Increment moves the clock forward by d.
+func (c *FakeClock) Increment(d time.Duration) {
+	c.Lock()
+	c.now = c.now.Add(d)
+
+	pending := c.watchers[:0]
+	for _, w := range c.watchers {
+		if w.stopped {
+			continue
+		}
+
+		if !w.at.After(c.now) {
+			// like the channels of the time package, a watcher holds one
+			// tick at most
+			select {
+			case w.c <- c.now:
+			default:
+			}
+
+			if w.interval == 0 {
+				continue
+			}
+			for !w.at.After(c.now) {
+				w.at = w.at.Add(w.interval)
+			}
+		}
+		pending = append(pending, w)
+	}
+	c.watchers = pending
+	c.Unlock()
+}
+
+// WatcherCount returns the number of timers and tickers waiting on the
+// clock, so that a test can wait for code to start waiting before moving
+// the clock.
+func (c *FakeClock) WatcherCount() int {
+	c.Lock()
+	defer c.Unlock()
+
+	n := 0
+	for _, w := range c.watchers {
+		if !w.stopped {
+			n++
+		}
+	}
+	return n
+}
+
+func (c *FakeClock) watch(d, interval time.Duration) *watcher {
+	c.Lock()
+	defer c.Unlock()
+
+	w := &watcher{
+		c:        make(chan time.Time, 1),
+		at:       c.now.Add(d),
+		interval: interval,
+	}
+	c.watchers = append(c.watchers, w)
+	return w
+}
+
+type fakeTicker struct {
+	clock   *FakeClock
+	watcher *watcher
+}
+
+func (t *fakeTicker) C() <-chan time.Time {
+	return t.watcher.c
+}
+
+func (t *fakeTicker) Stop() {
+	t.clock.Lock()
+	t.watcher.stopped = true
+	t.clock.Unlock()
+}
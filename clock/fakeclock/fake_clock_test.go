@@ -0,0 +1,55 @@
+package fakeclock_test
+
+import (
+	"time"
+
+	"github.com/cloudfoundry/gorouter/clock/fakeclock"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("FakeClock", func() {
+	var start time.Time
+	var clock *fakeclock.FakeClock
+
+	BeforeEach(func() {
+		start = time.Unix(1500000000, 0)
+		clock = fakeclock.New(start)
+	})
+
+	It("only moves when incremented", func() {
+		Ω(clock.Now()).To(Equal(start))
+
+		clock.Increment(time.Minute)
+		Ω(clock.Now()).To(Equal(start.Add(time.Minute)))
+		Ω(clock.Since(start)).To(Equal(time.Minute))
+	})
+
+	It("fires timers once they come due", func() {
+		c := clock.After(10 * time.Second)
+
+		clock.Increment(9 * time.Second)
+		Consistently(c, 10*time.Millisecond).ShouldNot(Receive())
+
+		clock.Increment(time.Second)
+		Ω(c).Should(Receive(Equal(start.Add(10 * time.Second))))
+		Ω(clock.WatcherCount()).To(BeZero())
+	})
+
+	It("fires tickers on every interval", func() {
+		ticker := clock.NewTicker(time.Second)
+
+		clock.Increment(time.Second)
+		Ω(ticker.C()).Should(Receive())
+
+		clock.Increment(time.Second)
+		Ω(ticker.C()).Should(Receive())
+
+		ticker.Stop()
+		Ω(clock.WatcherCount()).To(BeZero())
+
+		clock.Increment(time.Second)
+		Ω(ticker.C()).ShouldNot(Receive())
+	})
+})
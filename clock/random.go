@@ -0,0 +1,35 @@
+package clock
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// Random is a source of random numbers that is safe for concurrent use.
+type Random interface {
+	Intn(n int) int
+}
+
+// NewRandom returns a source seeded from the time.
+func NewRandom() Random {
+	return NewSeededRandom(time.Now().UnixNano())
+}
+
+// NewSeededRandom returns a source that always yields the same numbers for
+// the same seed.
+func NewSeededRandom(seed int64) Random {
+	return &lockedRandom{random: rand.New(rand.NewSource(seed))}
+}
+
+type lockedRandom struct {
+	sync.Mutex
+	random *rand.Rand
+}
+
+func (r *lockedRandom) Intn(n int) int {
+	r.Lock()
+	defer r.Unlock()
+
+	return r.random.Intn(n)
+}
@@ -11,6 +11,7 @@ import (
 
 	"github.com/apcera/nats"
 	. "github.com/cloudfoundry/gorouter/common/http"
+	"github.com/cloudfoundry/gorouter/handoff"
 	steno "github.com/cloudfoundry/gosteno"
 	"github.com/cloudfoundry/yagnats"
 	"github.com/pivotal-golang/localip"
@@ -28,6 +29,7 @@ type VcapComponent struct {
 	Varz        *Varz                     `json:"-"`
 	Healthz     *Healthz                  `json:"-"`
 	InfoRoutes  map[string]json.Marshaler `json:"-"`
+	Routes      map[string]http.Handler   `json:"-"`
 	Logger      *steno.Logger             `json:"-"`
 
 	// These fields are automatically generated
@@ -36,6 +38,7 @@ type VcapComponent struct {
 	Uptime    Duration `json:"uptime"`
 
 	listener net.Listener
+	mux      *http.ServeMux
 	statusCh chan error
 	quitCh   chan struct{}
 }
@@ -146,25 +149,68 @@ func (c *VcapComponent) Stop() {
 	}
 }
 
+// Handle adds an authenticated route to a component that may already be
+// serving.
+func (c *VcapComponent) Handle(path string, handler http.Handler) {
+	if c.mux != nil {
+		c.mux.Handle(path, handler)
+		return
+	}
+
+	if c.Routes == nil {
+		c.Routes = make(map[string]http.Handler)
+	}
+	c.Routes[path] = handler
+}
+
 func (c *VcapComponent) ListenAndServe() {
 	hs := http.NewServeMux()
 
 	hs.HandleFunc("/healthz", func(w http.ResponseWriter, req *http.Request) {
 		w.Header().Set("Connection", "close")
+
+		if detail := c.Healthz.Detail(); detail != nil {
+			detail["uptime"] = c.StartTime.Elapsed()
+
+			w.Header().Set("Content-Type", "application/json")
+			if detail["healthy"] == true {
+				w.WriteHeader(http.StatusOK)
+			} else {
+				w.WriteHeader(http.StatusServiceUnavailable)
+			}
+			json.NewEncoder(w).Encode(detail)
+			return
+		}
+
 		w.Header().Set("Content-Type", "text/plain")
-		w.WriteHeader(http.StatusOK)
+		if c.Healthz.Healthy() {
+			w.WriteHeader(http.StatusOK)
+		} else {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
 
 		fmt.Fprintf(w, c.Healthz.Value())
 	})
 
 	hs.HandleFunc("/varz", func(w http.ResponseWriter, req *http.Request) {
 		w.Header().Set("Connection", "close")
+
+		var varz json.Marshaler = c.Varz
+		if req.URL.RawQuery != "" {
+			var problem string
+			varz, problem = c.Varz.Query(req.URL.Query())
+			if problem != "" {
+				http.Error(w, problem, http.StatusBadRequest)
+				return
+			}
+		}
+
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusOK)
 
 		enc := json.NewEncoder(w)
 		c.UpdateVarz()
-		enc.Encode(c.Varz)
+		enc.Encode(varz)
 	})
 
 	for path, marshaler := range c.InfoRoutes {
@@ -179,6 +225,12 @@ func (c *VcapComponent) ListenAndServe() {
 		})
 	}
 
+	for path, handler := range c.Routes {
+		hs.Handle(path, handler)
+	}
+
+	c.mux = hs
+
 	f := func(user, password string) bool {
 		return user == c.Credentials[0] && password == c.Credentials[1]
 	}
@@ -191,7 +243,7 @@ func (c *VcapComponent) ListenAndServe() {
 	}
 
 	c.statusCh = make(chan error, 1)
-	l, err := net.Listen("tcp", c.Host)
+	l, err := handoff.Listen(c.Host)
 	if err != nil {
 		c.statusCh <- err
 		return
@@ -11,9 +11,24 @@ import (
 	"io/ioutil"
 	"net"
 	"net/http"
+	"net/url"
+	"strconv"
 	"time"
 )
 
+type queryableVarz struct{}
+
+func (queryableVarz) MarshalJSON() ([]byte, error) {
+	return json.Marshal(map[string]string{"query": ""})
+}
+
+func (queryableVarz) QueryVarz(values url.Values) (interface{}, string) {
+	if _, err := strconv.Atoi(values.Get("top")); err != nil {
+		return nil, "top must be a number"
+	}
+	return map[string]string{"query": values.Encode()}, ""
+}
+
 type MarshalableValue struct {
 	Value map[string]string
 }
@@ -104,6 +119,89 @@ var _ = Describe("Component", func() {
 		Ω(body).Should(Equal(`{"key":"value"}` + "\n"))
 	})
 
+	It("serves additional handlers behind basic auth", func() {
+		path := "/handler"
+
+		component.Routes = map[string]http.Handler{
+			path: http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				w.WriteHeader(http.StatusAccepted)
+			}),
+		}
+		serveComponent(component)
+
+		req := buildGetRequest(component, path)
+		code, _, _ := doGetRequest(req)
+		Ω(code).Should(Equal(401))
+
+		req = buildGetRequest(component, path)
+		req.SetBasicAuth("username", "password")
+		code, _, _ = doGetRequest(req)
+		Ω(code).Should(Equal(http.StatusAccepted))
+	})
+
+	It("adds routes after it has started", func() {
+		path := "/test"
+
+		serveComponent(component)
+		component.Handle(path, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			w.WriteHeader(http.StatusAccepted)
+		}))
+
+		req := buildGetRequest(component, path)
+		req.SetBasicAuth("username", "password")
+		code, _, _ := doGetRequest(req)
+		Ω(code).Should(Equal(http.StatusAccepted))
+	})
+
+	It("serves the detail of its health as JSON without authentication", func() {
+		component.Healthz = &Healthz{}
+		component.Healthz.SetDetail(func() map[string]interface{} {
+			return map[string]interface{}{"routes": 3}
+		})
+		serveComponent(component)
+
+		code, header, body := doGetRequest(buildGetRequest(component, "/healthz"))
+		Ω(code).Should(Equal(http.StatusOK))
+		Ω(header.Get("Content-Type")).Should(Equal("application/json"))
+
+		var detail map[string]interface{}
+		Ω(json.Unmarshal([]byte(body), &detail)).Should(Succeed())
+		Ω(detail).Should(HaveKeyWithValue("status", "ok"))
+		Ω(detail).Should(HaveKeyWithValue("routes", BeEquivalentTo(3)))
+		Ω(detail).Should(HaveKey("uptime"))
+
+		component.Healthz.AddCheck(func() string { return "nats unreachable" })
+
+		code, _, body = doGetRequest(buildGetRequest(component, "/healthz"))
+		Ω(code).Should(Equal(http.StatusServiceUnavailable))
+		Ω(body).Should(ContainSubstring(`"status":"degraded: nats unreachable"`))
+	})
+
+	It("renders varz for the query of the request", func() {
+		component.Type = "Router"
+		component.Varz = &Varz{UniqueVarz: queryableVarz{}}
+		Ω(component.Start()).To(Succeed())
+
+		req := buildGetRequest(component, "/varz")
+		req.SetBasicAuth("username", "password")
+		code, _, body := doGetRequest(req)
+		Ω(code).Should(Equal(http.StatusOK))
+		Ω(body).Should(ContainSubstring(`"query":""`))
+
+		req = buildGetRequest(component, "/varz?top=5")
+		req.SetBasicAuth("username", "password")
+		code, _, body = doGetRequest(req)
+		Ω(code).Should(Equal(http.StatusOK))
+		Ω(body).Should(ContainSubstring(`"query":"top=5"`))
+		Ω(body).Should(ContainSubstring(`"num_cores"`))
+
+		req = buildGetRequest(component, "/varz?top=none")
+		req.SetBasicAuth("username", "password")
+		code, _, body = doGetRequest(req)
+		Ω(code).Should(Equal(http.StatusBadRequest))
+		Ω(body).Should(ContainSubstring("top must be a number"))
+	})
+
 	It("returns 404 for non existent paths", func() {
 		serveComponent(component)
 
@@ -0,0 +1,60 @@
+// Package correlation carries the identifiers that tie together everything
+// the router emits about a single request: access log records, request
+// handler logs, dropsonde HttpStartStop events and response headers.
+package correlation
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/cloudfoundry/gorouter/common"
+	"github.com/nu7hatch/gouuid"
+)
+
+// DropsondeRequestIdHeader is set by the dropsonde instrumented handler and
+// holds the request id of the HttpStartStop events it emits.
+const DropsondeRequestIdHeader = "X-CF-RequestID"
+
+type ID struct {
+	RequestId string
+	TraceId   string
+}
+
+type contextKey struct{}
+
+// NewRequestId returns the id dropsonde assigned to request, so that the
+// router's own output matches its HttpStartStop events, or a new one when
+// the request did not pass through dropsonde.
+func NewRequestId(request *http.Request) string {
+	if id, err := uuid.ParseHex(request.Header.Get(DropsondeRequestIdHeader)); err == nil {
+		return id.String()
+	}
+
+	id, err := common.GenerateUUID()
+	if err != nil {
+		return ""
+	}
+	return id
+}
+
+func NewContext(ctx context.Context, id ID) context.Context {
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+func FromContext(ctx context.Context) (ID, bool) {
+	id, ok := ctx.Value(contextKey{}).(ID)
+	return id, ok
+}
+
+// FromRequest returns the correlation id attached to the context of request.
+func FromRequest(request *http.Request) (ID, bool) {
+	if request == nil {
+		return ID{}, false
+	}
+	return FromContext(request.Context())
+}
+
+// WithID returns a shallow copy of request carrying id in its context.
+func WithID(request *http.Request, id ID) *http.Request {
+	return request.WithContext(NewContext(request.Context(), id))
+}
@@ -0,0 +1,13 @@
+package correlation_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestCorrelation(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Correlation Suite")
+}
@@ -0,0 +1,52 @@
+package correlation_test
+
+import (
+	. "github.com/cloudfoundry/gorouter/common/correlation"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"net/http"
+)
+
+var _ = Describe("Correlation", func() {
+	var request *http.Request
+
+	BeforeEach(func() {
+		request, _ = http.NewRequest("GET", "http://example.com/", nil)
+	})
+
+	Describe("NewRequestId", func() {
+		It("reuses the dropsonde request id", func() {
+			request.Header.Set(DropsondeRequestIdHeader, "0f5a4d1e-5b8c-4b9a-9e1f-3c2d1a0b9e8f")
+
+			Ω(NewRequestId(request)).To(Equal("0f5a4d1e-5b8c-4b9a-9e1f-3c2d1a0b9e8f"))
+		})
+
+		It("generates an id when the dropsonde request id is missing or invalid", func() {
+			first := NewRequestId(request)
+			Ω(first).To(MatchRegexp(`^[[:xdigit:]]{8}(-[[:xdigit:]]{4}){3}-[[:xdigit:]]{12}$`))
+
+			request.Header.Set(DropsondeRequestIdHeader, "bogus")
+			second := NewRequestId(request)
+			Ω(second).To(MatchRegexp(`^[[:xdigit:]]{8}(-[[:xdigit:]]{4}){3}-[[:xdigit:]]{12}$`))
+			Ω(second).ToNot(Equal(first))
+		})
+	})
+
+	It("carries the id in the request context", func() {
+		_, ok := FromRequest(request)
+		Ω(ok).To(BeFalse())
+
+		id := ID{RequestId: "abc", TraceId: "def"}
+		correlated := WithID(request, id)
+
+		found, ok := FromRequest(correlated)
+		Ω(ok).To(BeTrue())
+		Ω(found).To(Equal(id))
+	})
+
+	It("finds nothing on a nil request", func() {
+		_, ok := FromRequest(nil)
+		Ω(ok).To(BeFalse())
+	})
+})
@@ -1,8 +1,107 @@
 package common
 
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// Healthz is the health of the router as load balancers see it. It turns
+// unhealthy once the router starts draining, so that load balancers take it
+// out of rotation before it stops accepting connections, and while it is
+// degraded for a reason, such as a certificate about to expire, or one of
+// its checks fails.
 type Healthz struct {
+	draining int32
+
+	lock     sync.Mutex
+	degraded string
+	checks   []func() string
+	detail   func() map[string]interface{}
 }
 
 func (v *Healthz) Value() string {
+	return status(v.Draining(), v.Degraded())
+}
+
+func status(draining bool, degraded string) string {
+	if draining {
+		return "draining"
+	}
+	if degraded != "" {
+		return "degraded: " + degraded
+	}
 	return "ok"
 }
+
+func (v *Healthz) Healthy() bool {
+	return !v.Draining() && v.Degraded() == ""
+}
+
+func (v *Healthz) Draining() bool {
+	return atomic.LoadInt32(&v.draining) == 1
+}
+
+func (v *Healthz) SetDraining() {
+	atomic.StoreInt32(&v.draining, 1)
+}
+
+// Degraded returns why the router is degraded, and is empty when it is not.
+func (v *Healthz) Degraded() string {
+	v.lock.Lock()
+	degraded := v.degraded
+	checks := v.checks
+	v.lock.Unlock()
+
+	if degraded != "" {
+		return degraded
+	}
+	for _, check := range checks {
+		if reason := check(); reason != "" {
+			return reason
+		}
+	}
+	return ""
+}
+
+// AddCheck has the router degraded while check returns a reason.
+func (v *Healthz) AddCheck(check func() string) {
+	v.lock.Lock()
+	v.checks = append(v.checks[:len(v.checks):len(v.checks)], check)
+	v.lock.Unlock()
+}
+
+// SetDetail has the health of the router reported along with what detail
+// returns, as JSON.
+func (v *Healthz) SetDetail(detail func() map[string]interface{}) {
+	v.lock.Lock()
+	v.detail = detail
+	v.lock.Unlock()
+}
+
+// Detail returns the report of the health of the router, or nil when it
+// is only reported by its status.
+func (v *Healthz) Detail() map[string]interface{} {
+	v.lock.Lock()
+	detail := v.detail
+	v.lock.Unlock()
+
+	if detail == nil {
+		return nil
+	}
+
+	draining, degraded := v.Draining(), v.Degraded()
+
+	report := detail()
+	report["status"] = status(draining, degraded)
+	report["healthy"] = !draining && degraded == ""
+	report["draining"] = draining
+	return report
+}
+
+// SetDegraded has the router degraded for reason, or no longer degraded when
+// reason is empty.
+func (v *Healthz) SetDegraded(reason string) {
+	v.lock.Lock()
+	v.degraded = reason
+	v.lock.Unlock()
+}
@@ -11,5 +11,58 @@ var _ = Describe("Healthz", func() {
 		healthz := &Healthz{}
 		ok := healthz.Value()
 		Ω(ok).Should(Equal("ok"))
+		Ω(healthz.Healthy()).Should(BeTrue())
+	})
+
+	It("is unhealthy once draining", func() {
+		healthz := &Healthz{}
+		healthz.SetDraining()
+
+		Ω(healthz.Draining()).Should(BeTrue())
+		Ω(healthz.Healthy()).Should(BeFalse())
+		Ω(healthz.Value()).Should(Equal("draining"))
+	})
+
+	It("is unhealthy while degraded", func() {
+		healthz := &Healthz{}
+		healthz.SetDegraded("certificate expiring")
+
+		Ω(healthz.Healthy()).Should(BeFalse())
+		Ω(healthz.Value()).Should(Equal("degraded: certificate expiring"))
+
+		healthz.SetDegraded("")
+
+		Ω(healthz.Healthy()).Should(BeTrue())
+		Ω(healthz.Value()).Should(Equal("ok"))
+	})
+
+	It("is unhealthy while a check fails", func() {
+		reason := "nats unreachable"
+		healthz := &Healthz{}
+		healthz.AddCheck(func() string { return reason })
+
+		Ω(healthz.Healthy()).Should(BeFalse())
+		Ω(healthz.Value()).Should(Equal("degraded: nats unreachable"))
+
+		reason = ""
+
+		Ω(healthz.Healthy()).Should(BeTrue())
+	})
+
+	It("reports its detail along with its status", func() {
+		healthz := &Healthz{}
+		Ω(healthz.Detail()).Should(BeNil())
+
+		healthz.SetDetail(func() map[string]interface{} {
+			return map[string]interface{}{"routes": 3}
+		})
+		healthz.SetDraining()
+
+		Ω(healthz.Detail()).Should(Equal(map[string]interface{}{
+			"routes":   3,
+			"status":   "draining",
+			"healthy":  false,
+			"draining": true,
+		}))
 	})
 })
@@ -7,4 +7,7 @@ const (
 	VcapRequestIdHeader   = "X-Vcap-Request-Id"
 	VcapTraceHeader       = "X-Vcap-Trace"
 	CfInstanceIdHeader    = "X-CF-InstanceID"
+	B3TraceIdHeader       = "X-B3-TraceId"
+	B3SpanIdHeader        = "X-B3-SpanId"
+	B3ParentSpanIdHeader  = "X-B3-ParentSpanId"
 )
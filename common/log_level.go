@@ -0,0 +1,153 @@
+package common
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	steno "github.com/cloudfoundry/gosteno"
+)
+
+// LogLevel is the level of every logger of the router, which operators can
+// change while it runs, for as long as an incident lasts.
+type LogLevel struct {
+	sync.Mutex
+
+	level    string
+	revertTo string
+	revertAt time.Time
+	timer    *time.Timer
+	changes  int
+	logger   *steno.Logger
+}
+
+// NewLogLevel returns the log level, which starts at level, the level
+// steno was initialized with.
+func NewLogLevel(level string) *LogLevel {
+	return &LogLevel{
+		level:  level,
+		logger: steno.NewLogger("router.log-level"),
+	}
+}
+
+// Set changes the level of every logger to level, for good.
+func (l *LogLevel) Set(level string) error {
+	return l.SetFor(level, 0)
+}
+
+// SetFor changes the level of every logger to level, and back to the
+// current level after d when it is positive.
+func (l *LogLevel) SetFor(level string, d time.Duration) error {
+	lvl, err := steno.GetLogLevel(level)
+	if err != nil {
+		return err
+	}
+
+	l.Lock()
+	defer l.Unlock()
+
+	if l.timer != nil {
+		l.timer.Stop()
+		l.timer = nil
+	}
+
+	previous := l.level
+	if l.revertTo != "" {
+		previous = l.revertTo
+	}
+	l.revertTo = ""
+	l.revertAt = time.Time{}
+	l.changes++
+
+	if d > 0 {
+		change := l.changes
+		l.revertTo = previous
+		l.revertAt = time.Now().Add(d)
+		l.timer = time.AfterFunc(d, func() {
+			l.revert(change, previous)
+		})
+	}
+
+	l.set(level, lvl)
+	return nil
+}
+
+// revert changes the level back to level, unless it was changed again
+// since the change it reverts.
+func (l *LogLevel) revert(change int, level string) {
+	lvl, _ := steno.GetLogLevel(level)
+
+	l.Lock()
+	defer l.Unlock()
+
+	if l.changes != change {
+		return
+	}
+
+	l.timer = nil
+	l.revertTo = ""
+	l.revertAt = time.Time{}
+	l.set(level, lvl)
+}
+
+func (l *LogLevel) set(name string, level steno.LogLevel) {
+	from := l.level
+	l.level = name
+	steno.SetLoggerRegexp(".*", level)
+
+	l.logger.Infod(map[string]interface{}{
+		"from": from,
+		"to":   name,
+	}, "log-level.changed")
+}
+
+// ServeHTTP tells the log level on GET, and changes it to the `level` query
+// parameter on POST, back to the current level after the optional
+// `duration` in seconds.
+func (l *LogLevel) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case "GET":
+	case "POST":
+		q := req.URL.Query()
+
+		var duration int
+		if v := q.Get("duration"); v != "" {
+			var err error
+			duration, err = strconv.Atoi(v)
+			if err != nil || duration < 0 {
+				http.Error(w, "duration must be a number of seconds", http.StatusBadRequest)
+				return
+			}
+		}
+
+		err := l.SetFor(q.Get("level"), time.Duration(duration)*time.Second)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(l)
+}
+
+func (l *LogLevel) MarshalJSON() ([]byte, error) {
+	l.Lock()
+	defer l.Unlock()
+
+	status := struct {
+		Level    string     `json:"level"`
+		RevertTo string     `json:"revert_to,omitempty"`
+		RevertAt *time.Time `json:"revert_at,omitempty"`
+	}{Level: l.level, RevertTo: l.revertTo}
+	if !l.revertAt.IsZero() {
+		status.RevertAt = &l.revertAt
+	}
+	return json.Marshal(status)
+}
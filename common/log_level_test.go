@@ -0,0 +1,80 @@
+package common_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	. "github.com/cloudfoundry/gorouter/common"
+	steno "github.com/cloudfoundry/gosteno"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("LogLevel", func() {
+	var level *LogLevel
+	var logger *steno.Logger
+
+	BeforeEach(func() {
+		level = NewLogLevel("info")
+		Ω(level.Set("info")).To(Succeed())
+		logger = steno.NewLogger("log-level-test")
+	})
+
+	serve := func(method, target string) (*httptest.ResponseRecorder, map[string]interface{}) {
+		req, err := http.NewRequest(method, target, nil)
+		Ω(err).NotTo(HaveOccurred())
+		w := httptest.NewRecorder()
+		level.ServeHTTP(w, req)
+
+		var status map[string]interface{}
+		json.Unmarshal(w.Body.Bytes(), &status)
+		return w, status
+	}
+
+	It("changes the level of every logger, including those created before", func() {
+		Ω(level.Set("debug")).To(Succeed())
+		Ω(logger.Level()).To(Equal(steno.LOG_DEBUG))
+		Ω(steno.NewLogger("log-level-test-new").Level()).To(Equal(steno.LOG_DEBUG))
+
+		Ω(level.Set("unknown")).NotTo(Succeed())
+		Ω(logger.Level()).To(Equal(steno.LOG_DEBUG))
+	})
+
+	It("changes the level back after a duration, unless it changed again", func() {
+		Ω(level.SetFor("debug", 50*time.Millisecond)).To(Succeed())
+		Ω(logger.Level()).To(Equal(steno.LOG_DEBUG))
+		Eventually(logger.Level).Should(Equal(steno.LOG_INFO))
+
+		Ω(level.SetFor("debug", 50*time.Millisecond)).To(Succeed())
+		Ω(level.Set("warn")).To(Succeed())
+		Consistently(logger.Level, 100*time.Millisecond).Should(Equal(steno.LOG_WARN))
+	})
+
+	It("reverts to the level before a series of temporary changes", func() {
+		Ω(level.SetFor("debug", time.Hour)).To(Succeed())
+		Ω(level.SetFor("debug2", 50*time.Millisecond)).To(Succeed())
+		Eventually(logger.Level).Should(Equal(steno.LOG_INFO))
+	})
+
+	It("tells and changes the level over http", func() {
+		w, status := serve("GET", "/log-level")
+		Ω(w.Code).To(Equal(http.StatusOK))
+		Ω(status["level"]).To(Equal("info"))
+
+		w, status = serve("POST", "/log-level?level=debug&duration=600")
+		Ω(w.Code).To(Equal(http.StatusOK))
+		Ω(status["level"]).To(Equal("debug"))
+		Ω(status["revert_to"]).To(Equal("info"))
+		Ω(status["revert_at"]).NotTo(BeNil())
+		Ω(logger.Level()).To(Equal(steno.LOG_DEBUG))
+
+		w, _ = serve("POST", "/log-level?level=loud")
+		Ω(w.Code).To(Equal(http.StatusBadRequest))
+		w, _ = serve("POST", "/log-level?level=info&duration=soon")
+		Ω(w.Code).To(Equal(http.StatusBadRequest))
+		w, _ = serve("DELETE", "/log-level")
+		Ω(w.Code).To(Equal(http.StatusMethodNotAllowed))
+	})
+})
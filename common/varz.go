@@ -2,6 +2,7 @@ package common
 
 import (
 	"encoding/json"
+	"net/url"
 	"sync"
 )
 
@@ -25,6 +26,12 @@ type Varz struct {
 	component VcapComponent
 }
 
+// A VarzQuerier renders the unique metrics of a component for the query
+// parameters of a /varz request, or tells what is wrong with them.
+type VarzQuerier interface {
+	QueryVarz(values url.Values) (interface{}, string)
+}
+
 func transform(x interface{}, y *map[string]interface{}) error {
 	var b []byte
 	var err error
@@ -43,11 +50,39 @@ func transform(x interface{}, y *map[string]interface{}) error {
 }
 
 func (v *Varz) MarshalJSON() ([]byte, error) {
+	return v.marshal(v.UniqueVarz)
+}
+
+// Query renders the varz with the unique metrics that the component renders
+// for the query parameters, or as a whole when it takes no query.
+func (v *Varz) Query(values url.Values) (json.Marshaler, string) {
+	q, ok := v.UniqueVarz.(VarzQuerier)
+	if !ok {
+		return v, ""
+	}
+
+	unique, problem := q.QueryVarz(values)
+	if problem != "" {
+		return nil, problem
+	}
+
+	return marshalerFunc(func() ([]byte, error) {
+		return v.marshal(unique)
+	}), ""
+}
+
+type marshalerFunc func() ([]byte, error)
+
+func (f marshalerFunc) MarshalJSON() ([]byte, error) {
+	return f()
+}
+
+func (v *Varz) marshal(unique interface{}) ([]byte, error) {
 	r := make(map[string]interface{})
 
 	var err error
 
-	err = transform(v.UniqueVarz, &r)
+	err = transform(unique, &r)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,291 @@
+package compression
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/cloudfoundry/gorouter/config"
+)
+
+// Compressor compresses responses of compressible content types for clients
+// that accept gzip or deflate, or zstd when it is enabled. A nil compressor
+// compresses nothing.
+type Compressor struct {
+	minBytes int
+	types    map[string]bool
+	zstd     *zstdEncoders
+
+	// a slot of inFlight is taken by every response being compressed
+	inFlight chan struct{}
+}
+
+// New returns the compressor configured by c, or nil when compression is
+// not enabled.
+func New(c config.CompressionConfig) *Compressor {
+	if !c.Enabled {
+		return nil
+	}
+
+	types := make(map[string]bool, len(c.ContentTypes))
+	for _, t := range c.ContentTypes {
+		types[strings.ToLower(t)] = true
+	}
+
+	compressor := &Compressor{minBytes: c.MinBytes, types: types}
+	if c.MaxConcurrent > 0 {
+		compressor.inFlight = make(chan struct{}, c.MaxConcurrent)
+	}
+	if c.Zstd.Enabled {
+		compressor.zstd = newZstdEncoders(c.Zstd)
+	}
+	return compressor
+}
+
+// ResponseWriter returns a writer that compresses the response written to
+// w when it is worth it, or nil when the client accepts no compression the
+// compressor offers. The writer must be closed once the response is
+// written.
+func (c *Compressor) ResponseWriter(w http.ResponseWriter, request *http.Request) *ResponseWriter {
+	if c == nil || request.Method == "HEAD" {
+		return nil
+	}
+
+	accepted := acceptedEncodings(request.Header.Get("Accept-Encoding"))
+
+	w2 := &ResponseWriter{ResponseWriter: w, compressor: c}
+	if c.zstd != nil {
+		if accepted["dcz"] {
+			w2.dictionary = c.zstd.dictionary(request.Header.Get("Available-Dictionary"))
+		}
+		switch {
+		case w2.dictionary != nil:
+			w2.encoding = "dcz"
+		case accepted["zstd"]:
+			w2.encoding = "zstd"
+		}
+	}
+	if w2.encoding == "" {
+		switch {
+		case accepted["gzip"]:
+			w2.encoding = "gzip"
+		case accepted["deflate"]:
+			w2.encoding = "deflate"
+		default:
+			return nil
+		}
+	}
+
+	return w2
+}
+
+func (c *Compressor) compressible(header http.Header) bool {
+	if header.Get("Content-Encoding") != "" || header.Get("Content-Range") != "" {
+		return false
+	}
+
+	mediaType, _, err := mime.ParseMediaType(header.Get("Content-Type"))
+	return err == nil && c.types[mediaType]
+}
+
+// acceptedEncodings returns the codings the Accept-Encoding header of a
+// request accepts, honouring q=0 for any of them.
+func acceptedEncodings(header string) map[string]bool {
+	accepted := make(map[string]bool)
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(part, ";")
+		coding := strings.ToLower(strings.TrimSpace(fields[0]))
+
+		ok := true
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				q, err := strconv.ParseFloat(param[2:], 64)
+				ok = err == nil && q > 0
+			}
+		}
+		accepted[coding] = ok
+	}
+	return accepted
+}
+
+const (
+	undecided = iota
+	buffering
+	compressing
+	passing
+)
+
+// ResponseWriter compresses the response written to it when its content
+// type is compressible and its body is at least the minimum size. When the
+// response does not say how large its body is, the writer holds the body
+// back until it reaches the minimum size, and sends it uncompressed when it
+// ends or is flushed before that. Responses are sent uncompressed as well
+// while the compressor is compressing as many responses as it may.
+type ResponseWriter struct {
+	http.ResponseWriter
+
+	compressor *Compressor
+	encoding   string
+	dictionary *encoderPool
+
+	state   int
+	status  int
+	buf     []byte
+	encoder io.WriteCloser
+}
+
+func (w *ResponseWriter) WriteHeader(status int) {
+	if w.state != undecided {
+		return
+	}
+
+	header := w.Header()
+	if !bodyAllowed(status) || !w.compressor.compressible(header) {
+		w.pass(status)
+		return
+	}
+
+	header.Add("Vary", "Accept-Encoding")
+	if w.compressor.zstd != nil && len(w.compressor.zstd.dictionaries) > 0 {
+		header.Add("Vary", "Available-Dictionary")
+	}
+
+	size, err := strconv.Atoi(header.Get("Content-Length"))
+	switch {
+	case err != nil:
+		w.state = buffering
+		w.status = status
+	case size < w.compressor.minBytes:
+		w.pass(status)
+	default:
+		w.compress(status)
+	}
+}
+
+func (w *ResponseWriter) Write(b []byte) (int, error) {
+	if w.state == undecided {
+		w.WriteHeader(http.StatusOK)
+	}
+
+	switch w.state {
+	case buffering:
+		w.buf = append(w.buf, b...)
+		if len(w.buf) >= w.compressor.minBytes {
+			var err error
+			if w.compress(w.status) {
+				_, err = w.encoder.Write(w.buf)
+			} else {
+				_, err = w.ResponseWriter.Write(w.buf)
+			}
+			w.buf = nil
+			if err != nil {
+				return 0, err
+			}
+		}
+		return len(b), nil
+	case compressing:
+		return w.encoder.Write(b)
+	default:
+		return w.ResponseWriter.Write(b)
+	}
+}
+
+// Flush sends what was written so far. A body held back is sent
+// uncompressed, since a flushing backend wants it to reach the client now.
+func (w *ResponseWriter) Flush() {
+	switch w.state {
+	case buffering:
+		w.release()
+	case compressing:
+		if f, ok := w.encoder.(interface {
+			Flush() error
+		}); ok {
+			f.Flush()
+		}
+	}
+
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Close ends the response, sending what the writer still holds.
+func (w *ResponseWriter) Close() error {
+	switch w.state {
+	case buffering:
+		w.release()
+	case compressing:
+		err := w.encoder.Close()
+		w.compressor.release()
+		return err
+	}
+	return nil
+}
+
+func (w *ResponseWriter) pass(status int) {
+	w.state = passing
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *ResponseWriter) release() {
+	w.pass(w.status)
+	w.ResponseWriter.Write(w.buf)
+	w.buf = nil
+}
+
+// compress starts compressing the response, or passes it on uncompressed
+// when the compressor has no slot for it. It reports whether it compresses.
+func (w *ResponseWriter) compress(status int) bool {
+	if !w.compressor.acquire() {
+		w.pass(status)
+		return false
+	}
+
+	header := w.Header()
+	header.Del("Content-Length")
+	header.Set("Content-Encoding", w.encoding)
+
+	w.state = compressing
+	w.ResponseWriter.WriteHeader(status)
+
+	switch w.encoding {
+	case "gzip":
+		w.encoder = gzip.NewWriter(w.ResponseWriter)
+	case "deflate":
+		w.encoder = zlib.NewWriter(w.ResponseWriter)
+	case "dcz":
+		w.encoder = w.dictionary.writer(w.ResponseWriter)
+	default:
+		w.encoder = w.compressor.zstd.plain.writer(w.ResponseWriter)
+	}
+	return true
+}
+
+func (c *Compressor) acquire() bool {
+	if c.inFlight == nil {
+		return true
+	}
+
+	select {
+	case c.inFlight <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+func (c *Compressor) release() {
+	if c.inFlight != nil {
+		<-c.inFlight
+	}
+}
+
+func bodyAllowed(status int) bool {
+	return status >= http.StatusOK && status != http.StatusNoContent &&
+		status != http.StatusPartialContent && status != http.StatusNotModified
+}
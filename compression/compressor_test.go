@@ -0,0 +1,291 @@
+package compression_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"crypto/sha256"
+	"encoding/base64"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+
+	"github.com/cloudfoundry/gorouter/compression"
+	"github.com/cloudfoundry/gorouter/config"
+	"github.com/klauspost/compress/zstd"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Compressor", func() {
+	var compressor *compression.Compressor
+	var recorder *httptest.ResponseRecorder
+	var request *http.Request
+	var body string
+
+	BeforeEach(func() {
+		compressor = compression.New(config.CompressionConfig{
+			Enabled:      true,
+			MinBytes:     100,
+			ContentTypes: []string{"text/html", "application/json"},
+		})
+		recorder = httptest.NewRecorder()
+		request, _ = http.NewRequest("GET", "http://example.com/", nil)
+		request.Header.Set("Accept-Encoding", "gzip, deflate")
+		body = strings.Repeat("a", 200)
+	})
+
+	write := func(w http.ResponseWriter, contentType string, length bool, status int) {
+		w.Header().Set("Content-Type", contentType)
+		if length {
+			w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		}
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}
+
+	gunzip := func(b []byte) string {
+		r, err := gzip.NewReader(strings.NewReader(string(b)))
+		Ω(err).NotTo(HaveOccurred())
+		out, err := ioutil.ReadAll(r)
+		Ω(err).NotTo(HaveOccurred())
+		return string(out)
+	}
+
+	It("compresses nothing when it is not enabled", func() {
+		compressor = compression.New(config.CompressionConfig{})
+		Ω(compressor).To(BeNil())
+		Ω(compressor.ResponseWriter(recorder, request)).To(BeNil())
+	})
+
+	It("compresses nothing for clients that do not accept it", func() {
+		request.Header.Del("Accept-Encoding")
+		Ω(compressor.ResponseWriter(recorder, request)).To(BeNil())
+
+		request.Header.Set("Accept-Encoding", "gzip;q=0, br")
+		Ω(compressor.ResponseWriter(recorder, request)).To(BeNil())
+	})
+
+	It("compresses nothing for HEAD requests", func() {
+		request.Method = "HEAD"
+		Ω(compressor.ResponseWriter(recorder, request)).To(BeNil())
+	})
+
+	It("compresses with gzip when the client accepts it", func() {
+		w := compressor.ResponseWriter(recorder, request)
+		write(w, "text/html; charset=utf-8", true, http.StatusOK)
+		w.Close()
+
+		Ω(recorder.Code).To(Equal(http.StatusOK))
+		Ω(recorder.Header().Get("Content-Encoding")).To(Equal("gzip"))
+		Ω(recorder.Header().Get("Content-Length")).To(BeEmpty())
+		Ω(recorder.Header().Get("Vary")).To(Equal("Accept-Encoding"))
+		Ω(gunzip(recorder.Body.Bytes())).To(Equal(body))
+	})
+
+	It("compresses with deflate when the client accepts only that", func() {
+		request.Header.Set("Accept-Encoding", "gzip;q=0, deflate")
+		w := compressor.ResponseWriter(recorder, request)
+		write(w, "application/json", true, http.StatusOK)
+		w.Close()
+
+		Ω(recorder.Header().Get("Content-Encoding")).To(Equal("deflate"))
+		r, err := zlib.NewReader(recorder.Body)
+		Ω(err).NotTo(HaveOccurred())
+		out, err := ioutil.ReadAll(r)
+		Ω(err).NotTo(HaveOccurred())
+		Ω(string(out)).To(Equal(body))
+	})
+
+	It("does not compress content types that are not listed", func() {
+		w := compressor.ResponseWriter(recorder, request)
+		write(w, "image/png", true, http.StatusOK)
+		w.Close()
+
+		Ω(recorder.Header().Get("Content-Encoding")).To(BeEmpty())
+		Ω(recorder.Header().Get("Vary")).To(BeEmpty())
+		Ω(recorder.Body.String()).To(Equal(body))
+	})
+
+	It("does not compress responses that are already encoded", func() {
+		w := compressor.ResponseWriter(recorder, request)
+		w.Header().Set("Content-Encoding", "br")
+		write(w, "text/html", true, http.StatusOK)
+		w.Close()
+
+		Ω(recorder.Header().Get("Content-Encoding")).To(Equal("br"))
+		Ω(recorder.Body.String()).To(Equal(body))
+	})
+
+	It("does not compress responses without a body", func() {
+		w := compressor.ResponseWriter(recorder, request)
+		w.Header().Set("Content-Type", "text/html")
+		w.WriteHeader(http.StatusNotModified)
+		w.Close()
+
+		Ω(recorder.Code).To(Equal(http.StatusNotModified))
+		Ω(recorder.Header().Get("Content-Encoding")).To(BeEmpty())
+	})
+
+	It("does not compress bodies smaller than the minimum", func() {
+		body = "small"
+		w := compressor.ResponseWriter(recorder, request)
+		write(w, "text/html", true, http.StatusOK)
+		w.Close()
+
+		Ω(recorder.Header().Get("Content-Encoding")).To(BeEmpty())
+		Ω(recorder.Header().Get("Vary")).To(Equal("Accept-Encoding"))
+		Ω(recorder.Body.String()).To(Equal("small"))
+	})
+
+	Context("when the response has no Content-Length", func() {
+		It("compresses once the body reaches the minimum", func() {
+			w := compressor.ResponseWriter(recorder, request)
+			write(w, "text/html", false, http.StatusCreated)
+			w.Close()
+
+			Ω(recorder.Code).To(Equal(http.StatusCreated))
+			Ω(recorder.Header().Get("Content-Encoding")).To(Equal("gzip"))
+			Ω(gunzip(recorder.Body.Bytes())).To(Equal(body))
+		})
+
+		It("sends bodies that stay under the minimum uncompressed", func() {
+			body = "small"
+			w := compressor.ResponseWriter(recorder, request)
+			write(w, "text/html", false, http.StatusCreated)
+
+			Ω(recorder.Body.String()).To(BeEmpty())
+			w.Close()
+
+			Ω(recorder.Code).To(Equal(http.StatusCreated))
+			Ω(recorder.Header().Get("Content-Encoding")).To(BeEmpty())
+			Ω(recorder.Body.String()).To(Equal("small"))
+		})
+
+		It("sends what it holds back uncompressed when flushed", func() {
+			body = "small"
+			w := compressor.ResponseWriter(recorder, request)
+			write(w, "text/html", false, http.StatusOK)
+			w.Flush()
+
+			Ω(recorder.Flushed).To(BeTrue())
+			Ω(recorder.Body.String()).To(Equal("small"))
+
+			w.Write([]byte(strings.Repeat("b", 200)))
+			w.Close()
+			Ω(recorder.Header().Get("Content-Encoding")).To(BeEmpty())
+		})
+	})
+
+	Context("with zstd", func() {
+		var dictionary []byte
+
+		BeforeEach(func() {
+			dictionary = []byte(strings.Repeat("a dictionary of shared JSON ", 10))
+			compressor = compression.New(config.CompressionConfig{
+				Enabled:      true,
+				MinBytes:     100,
+				ContentTypes: []string{"application/json"},
+				Zstd: config.ZstdConfig{
+					Enabled:      true,
+					Level:        config.ZstdLevelDefault,
+					Dictionaries: [][]byte{dictionary},
+				},
+			})
+			request.Header.Set("Accept-Encoding", "gzip, zstd")
+		})
+
+		unzstd := func(b []byte, options ...zstd.DOption) string {
+			r, err := zstd.NewReader(bytes.NewReader(b), options...)
+			Ω(err).NotTo(HaveOccurred())
+			defer r.Close()
+			out, err := ioutil.ReadAll(r)
+			Ω(err).NotTo(HaveOccurred())
+			return string(out)
+		}
+
+		It("prefers zstd over gzip", func() {
+			w := compressor.ResponseWriter(recorder, request)
+			write(w, "application/json", true, http.StatusOK)
+			w.Close()
+
+			Ω(recorder.Header().Get("Content-Encoding")).To(Equal("zstd"))
+			Ω(recorder.Header()["Vary"]).To(Equal([]string{"Accept-Encoding", "Available-Dictionary"}))
+			Ω(unzstd(recorder.Body.Bytes())).To(Equal(body))
+		})
+
+		It("compresses with a dictionary the client has", func() {
+			sum := sha256.Sum256(dictionary)
+			request.Header.Set("Accept-Encoding", "gzip, zstd, dcz")
+			request.Header.Set("Available-Dictionary", ":"+base64.StdEncoding.EncodeToString(sum[:])+":")
+
+			w := compressor.ResponseWriter(recorder, request)
+			write(w, "application/json", true, http.StatusOK)
+			w.Close()
+
+			Ω(recorder.Header().Get("Content-Encoding")).To(Equal("dcz"))
+			b := recorder.Body.Bytes()
+			Ω(b[:8]).To(Equal([]byte{0x5e, 0x2a, 0x4d, 0x18, 0x20, 0x00, 0x00, 0x00}))
+			Ω(b[8:40]).To(Equal(sum[:]))
+			Ω(unzstd(b[40:], zstd.WithDecoderDictRaw(0, dictionary))).To(Equal(body))
+		})
+
+		It("falls back to zstd for dictionaries it does not have", func() {
+			request.Header.Set("Accept-Encoding", "zstd, dcz")
+			request.Header.Set("Available-Dictionary", ":"+base64.StdEncoding.EncodeToString(make([]byte, 32))+":")
+
+			w := compressor.ResponseWriter(recorder, request)
+			write(w, "application/json", true, http.StatusOK)
+			w.Close()
+
+			Ω(recorder.Header().Get("Content-Encoding")).To(Equal("zstd"))
+		})
+
+		It("does not offer zstd when it is not enabled", func() {
+			compressor = compression.New(config.CompressionConfig{
+				Enabled:      true,
+				ContentTypes: []string{"application/json"},
+			})
+			request.Header.Set("Accept-Encoding", "zstd")
+			Ω(compressor.ResponseWriter(recorder, request)).To(BeNil())
+		})
+	})
+
+	Context("when compressing as many responses as it may", func() {
+		BeforeEach(func() {
+			compressor = compression.New(config.CompressionConfig{
+				Enabled:       true,
+				MinBytes:      100,
+				MaxConcurrent: 1,
+				ContentTypes:  []string{"text/html"},
+			})
+		})
+
+		It("sends further responses uncompressed until one is done", func() {
+			first := compressor.ResponseWriter(recorder, request)
+			write(first, "text/html", true, http.StatusOK)
+
+			other := httptest.NewRecorder()
+			w := compressor.ResponseWriter(other, request)
+			write(w, "text/html", true, http.StatusOK)
+			w.Close()
+
+			Ω(other.Header().Get("Content-Encoding")).To(BeEmpty())
+			Ω(other.Header().Get("Content-Length")).To(Equal("200"))
+			Ω(other.Body.String()).To(Equal(body))
+
+			first.Close()
+			Ω(gunzip(recorder.Body.Bytes())).To(Equal(body))
+
+			other = httptest.NewRecorder()
+			w = compressor.ResponseWriter(other, request)
+			write(w, "text/html", false, http.StatusOK)
+			w.Close()
+
+			Ω(other.Header().Get("Content-Encoding")).To(Equal("gzip"))
+		})
+	})
+})
@@ -0,0 +1,107 @@
+package compression
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+
+	"github.com/cloudfoundry/gorouter/config"
+)
+
+// dczHeader starts every dcz body, ahead of the SHA-256 of the dictionary
+// and the zstd frame.
+var dczHeader = []byte{0x5e, 0x2a, 0x4d, 0x18, 0x20, 0x00, 0x00, 0x00}
+
+// zstdEncoders hand out zstd encoders, without a dictionary or with one of
+// the dictionaries by the hash clients know it by. Encoders are reused, as
+// setting one up takes far more than compressing a typical response.
+type zstdEncoders struct {
+	plain        *encoderPool
+	dictionaries map[string]*encoderPool
+}
+
+type encoderPool struct {
+	hash []byte
+	pool sync.Pool
+}
+
+func newZstdEncoders(c config.ZstdConfig) *zstdEncoders {
+	level := zstd.SpeedDefault
+	switch c.Level {
+	case config.ZstdLevelFastest:
+		level = zstd.SpeedFastest
+	case config.ZstdLevelBetter:
+		level = zstd.SpeedBetterCompression
+	}
+
+	// one goroutine per response, so that the budget of concurrent
+	// compressions bounds the CPU they take
+	options := []zstd.EOption{
+		zstd.WithEncoderLevel(level),
+		zstd.WithEncoderConcurrency(1),
+	}
+
+	z := &zstdEncoders{
+		plain:        newEncoderPool(nil, options),
+		dictionaries: make(map[string]*encoderPool),
+	}
+	for _, d := range c.Dictionaries {
+		sum := sha256.Sum256(d)
+		p := newEncoderPool(sum[:], append(options, zstd.WithEncoderDictRaw(0, d)))
+		z.dictionaries[base64.StdEncoding.EncodeToString(sum[:])] = p
+	}
+	return z
+}
+
+func newEncoderPool(hash []byte, options []zstd.EOption) *encoderPool {
+	p := &encoderPool{hash: hash}
+	p.pool.New = func() interface{} {
+		e, err := zstd.NewWriter(nil, options...)
+		if err != nil {
+			panic(err)
+		}
+		return e
+	}
+	return p
+}
+
+// dictionary returns the encoders of the dictionary that an
+// Available-Dictionary header names, a structured field byte sequence of
+// its SHA-256, or nil when the header names none of them.
+func (z *zstdEncoders) dictionary(header string) *encoderPool {
+	header = strings.TrimSpace(header)
+	if len(header) < 2 || header[0] != ':' || header[len(header)-1] != ':' {
+		return nil
+	}
+	return z.dictionaries[header[1:len(header)-1]]
+}
+
+// writer returns an encoder writing to w, which goes back to the pool once
+// it is closed. Bodies compressed with a dictionary start with the dcz
+// header and the hash of the dictionary.
+func (p *encoderPool) writer(w io.Writer) io.WriteCloser {
+	if p.hash != nil {
+		w.Write(dczHeader)
+		w.Write(p.hash)
+	}
+
+	e := p.pool.Get().(*zstd.Encoder)
+	e.Reset(w)
+	return &pooledEncoder{Encoder: e, pool: p}
+}
+
+type pooledEncoder struct {
+	*zstd.Encoder
+	pool *encoderPool
+}
+
+func (e *pooledEncoder) Close() error {
+	err := e.Encoder.Close()
+	e.Encoder.Reset(nil)
+	e.pool.pool.Put(e.Encoder)
+	return err
+}
@@ -2,8 +2,12 @@ package config
 
 import (
 	"crypto/tls"
+	"crypto/x509"
 	"fmt"
+	"net"
 	"net/url"
+	"path"
+	"regexp"
 
 	"github.com/cloudfoundry-incubator/candiedyaml"
 	token_fetcher "github.com/cloudfoundry-incubator/uaa-token-fetcher"
@@ -12,6 +16,7 @@ import (
 
 	"io/ioutil"
 	"runtime"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -23,6 +28,39 @@ type StatusConfig struct {
 	Pass string `yaml:"pass"`
 }
 
+// NatsTLSConfig secures the connections to a NATS cluster. The servers
+// are verified with the CA of CAPath, or the system CAs without one, and
+// the router presents the certificate of CertPath and KeyPath to those
+// that ask for one.
+type NatsTLSConfig struct {
+	Enabled            bool   `yaml:"enabled"`
+	CAPath             string `yaml:"ca_path"`
+	CertPath           string `yaml:"cert_path"`
+	KeyPath            string `yaml:"key_path"`
+	ServerName         string `yaml:"server_name"`
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify"`
+}
+
+// NatsClusterConfig is one of several independent NATS clusters the router
+// takes routes from, such as those of the control planes of two data
+// centers. The routes registered on all of them are merged.
+//
+// The router authenticates with the user and password of the servers, or
+// with the JWT and NKey of the NATS 2.x credentials file of CredentialsPath,
+// or with the NKey seed of NkeySeedPath.
+type NatsClusterConfig struct {
+	Name            string        `yaml:"name"`
+	Servers         []NatsConfig  `yaml:"servers"`
+	TLS             NatsTLSConfig `yaml:"tls"`
+	CredentialsPath string        `yaml:"credentials_path"`
+	NkeySeedPath    string        `yaml:"nkey_seed_path"`
+}
+
+// ServerURLs returns the URLs of the servers of the cluster.
+func (c NatsClusterConfig) ServerURLs() []string {
+	return natsServerURLs(c.Servers)
+}
+
 var defaultStatusConfig = StatusConfig{
 	Port: 8082,
 	User: "",
@@ -36,9 +74,946 @@ type NatsConfig struct {
 	Pass string `yaml:"pass"`
 }
 
+// RoutingApiConfig is where the routes of the routing API are fetched from.
+// With Streaming the routes are kept current by the event stream of the API,
+// and only fetched in full when subscribing, every FullSyncInterval to catch
+// what the stream missed, and every fetch interval while the stream is down.
+// A FullSyncInterval of 0 leaves out the periodic full syncs.
 type RoutingApiConfig struct {
-	Uri  string `yaml:"uri"`
-	Port int    `yaml:"port"`
+	Uri                       string `yaml:"uri"`
+	Port                      int    `yaml:"port"`
+	Streaming                 bool   `yaml:"streaming"`
+	FullSyncIntervalInSeconds int    `yaml:"full_sync_interval"`
+
+	// These fields are populated by the `Process` function.
+	FullSyncInterval time.Duration `yaml:"-"`
+}
+
+var defaultRoutingApiConfig = RoutingApiConfig{
+	FullSyncIntervalInSeconds: 300,
+}
+
+type TcpRoutingConfig struct {
+	Ports []uint16 `yaml:"ports"`
+}
+
+type LeaderElectionConfig struct {
+	Enabled                    bool `yaml:"enabled"`
+	HeartbeatIntervalInSeconds int  `yaml:"heartbeat_interval"`
+	TTLInSeconds               int  `yaml:"ttl"`
+	ReconcileIntervalInSeconds int  `yaml:"reconcile_interval"`
+
+	// These fields are populated by the `Process` function.
+	HeartbeatInterval time.Duration `yaml:"-"`
+	TTL               time.Duration `yaml:"-"`
+	ReconcileInterval time.Duration `yaml:"-"`
+}
+
+var defaultLeaderElectionConfig = LeaderElectionConfig{
+	Enabled:                    false,
+	HeartbeatIntervalInSeconds: 5,
+	TTLInSeconds:               15,
+	ReconcileIntervalInSeconds: 300,
+}
+
+const (
+	AccessLogFormatText = "text"
+	AccessLogFormatJSON = "json"
+)
+
+const (
+	SyslogNetworkUDP = "udp"
+	SyslogNetworkTCP = "tcp"
+	SyslogNetworkTLS = "tls"
+)
+
+// Access log records are also shipped to Address when it is set.
+type AccessLogSyslogConfig struct {
+	Address            string `yaml:"address"`
+	Network            string `yaml:"network"`
+	AppName            string `yaml:"app_name"`
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify"`
+}
+
+var defaultAccessLogSyslogConfig = AccessLogSyslogConfig{
+	Network: SyslogNetworkUDP,
+	AppName: "gorouter",
+}
+
+// Access log records are also sent to Topic when Brokers are set.
+type AccessLogKafkaConfig struct {
+	Brokers                []string `yaml:"brokers"`
+	Topic                  string   `yaml:"topic"`
+	ClientId               string   `yaml:"client_id"`
+	BatchSize              int      `yaml:"batch_size"`
+	BufferSize             int      `yaml:"buffer_size"`
+	FlushIntervalInSeconds int      `yaml:"flush_interval"`
+	TimeoutInSeconds       int      `yaml:"timeout"`
+
+	// These fields are populated by the `Process` function.
+	FlushInterval time.Duration `yaml:"-"`
+	Timeout       time.Duration `yaml:"-"`
+}
+
+var defaultAccessLogKafkaConfig = AccessLogKafkaConfig{
+	Topic:                  "gorouter-access-log",
+	ClientId:               "gorouter",
+	BatchSize:              500,
+	BufferSize:             10000,
+	FlushIntervalInSeconds: 1,
+	TimeoutInSeconds:       10,
+}
+
+const (
+	LimitModeEnforce = "enforce"
+	LimitModeWarn    = "warn"
+)
+
+// A limit with a mode of "warn" only reports violations. A Max of zero
+// disables the limit.
+type LimitConfig struct {
+	Max  int64  `yaml:"max"`
+	Mode string `yaml:"mode"`
+}
+
+type LimitsConfig struct {
+	Routes              LimitConfig `yaml:"routes"`
+	Connections         LimitConfig `yaml:"connections"`
+	RequestHeaderBytes  LimitConfig `yaml:"request_header_bytes"`
+	ResponseHeaderBytes LimitConfig `yaml:"response_header_bytes"`
+	ResponseHeaderCount LimitConfig `yaml:"response_header_count"`
+	EndpointInFlight    LimitConfig `yaml:"endpoint_in_flight"`
+	RequestBodyBytes    LimitConfig `yaml:"request_body_bytes"`
+}
+
+// Backend responses are cached in memory when Enabled, up to MaxBytes in
+// all and MaxEntryBytes per response.
+type CacheConfig struct {
+	Enabled       bool  `yaml:"enabled"`
+	MaxBytes      int64 `yaml:"max_bytes"`
+	MaxEntryBytes int64 `yaml:"max_entry_bytes"`
+}
+
+var defaultCacheConfig = CacheConfig{
+	MaxBytes:      64 * 1024 * 1024,
+	MaxEntryBytes: 1024 * 1024,
+}
+
+// Backends whose requests keep failing are ejected from their pool when
+// Enabled. A backend is ejected after ConsecutiveFailures failed requests in
+// a row, or once FailureRatePercent of its last RequestVolume requests
+// failed. It is sent a probe request after BaseEjectionTimeInSeconds, and
+// every failed probe doubles the time it stays out, up to
+// MaxEjectionTimeInSeconds.
+type CircuitBreakerConfig struct {
+	Enabled                   bool `yaml:"enabled"`
+	ConsecutiveFailures       int  `yaml:"consecutive_failures"`
+	FailureRatePercent        int  `yaml:"failure_rate_percent"`
+	RequestVolume             int  `yaml:"request_volume"`
+	BaseEjectionTimeInSeconds int  `yaml:"base_ejection_time"`
+	MaxEjectionTimeInSeconds  int  `yaml:"max_ejection_time"`
+
+	BaseEjectionTime time.Duration `yaml:"-"`
+	MaxEjectionTime  time.Duration `yaml:"-"`
+}
+
+var defaultCircuitBreakerConfig = CircuitBreakerConfig{
+	ConsecutiveFailures:       5,
+	FailureRatePercent:        50,
+	RequestVolume:             20,
+	BaseEjectionTimeInSeconds: 10,
+	MaxEjectionTimeInSeconds:  300,
+}
+
+// Endpoints registered with a health check path are probed every
+// IntervalInSeconds when Enabled. An endpoint is taken out of its pools after
+// UnhealthyThreshold failed checks in a row, and put back after
+// HealthyThreshold successful ones.
+type HealthCheckConfig struct {
+	Enabled            bool `yaml:"enabled"`
+	IntervalInSeconds  int  `yaml:"interval"`
+	TimeoutInSeconds   int  `yaml:"timeout"`
+	UnhealthyThreshold int  `yaml:"unhealthy_threshold"`
+	HealthyThreshold   int  `yaml:"healthy_threshold"`
+
+	Interval time.Duration `yaml:"-"`
+	Timeout  time.Duration `yaml:"-"`
+}
+
+var defaultHealthCheckConfig = HealthCheckConfig{
+	IntervalInSeconds:  10,
+	TimeoutInSeconds:   2,
+	UnhealthyThreshold: 3,
+	HealthyThreshold:   2,
+}
+
+// Services of the Consul agent at Address are routed to when Enabled. A
+// service tag that starts with TagPrefix names a hostname for the instances
+// that carry it, and TagRoutes maps whole tags to hostnames; ${service} in a
+// hostname is replaced with the service name. The catalog is read every
+// IntervalInSeconds, which has to be shorter than the droplet stale threshold
+// for the endpoints to stay registered.
+type ConsulConfig struct {
+	Enabled           bool              `yaml:"enabled"`
+	Address           string            `yaml:"address"`
+	Token             string            `yaml:"token"`
+	Datacenter        string            `yaml:"datacenter"`
+	TagPrefix         string            `yaml:"tag_prefix"`
+	TagRoutes         map[string]string `yaml:"tag_routes"`
+	IntervalInSeconds int               `yaml:"interval"`
+	TimeoutInSeconds  int               `yaml:"timeout"`
+
+	Interval time.Duration `yaml:"-"`
+	Timeout  time.Duration `yaml:"-"`
+}
+
+var defaultConsulConfig = ConsulConfig{
+	Address:           "http://127.0.0.1:8500",
+	TagPrefix:         "gorouter.route=",
+	IntervalInSeconds: 10,
+	TimeoutInSeconds:  5,
+}
+
+// Services of the Kubernetes cluster at ApiServer are routed to when
+// Enabled. A service is routed to for the comma separated hostnames of its
+// Annotation, on the port of its endpoints that PortAnnotation names, or the
+// first one. Services are read from Namespaces, or from all namespaces
+// without them, every IntervalInSeconds, which has to be shorter than the
+// droplet stale threshold for the endpoints to stay registered. The API
+// server is authenticated with the CA of CAPath, and the router with the
+// bearer token of TokenPath, which are those of the pod's service account
+// by default.
+type KubernetesConfig struct {
+	Enabled           bool     `yaml:"enabled"`
+	ApiServer         string   `yaml:"api_server"`
+	TokenPath         string   `yaml:"token_path"`
+	CAPath            string   `yaml:"ca_path"`
+	Namespaces        []string `yaml:"namespaces"`
+	Annotation        string   `yaml:"annotation"`
+	PortAnnotation    string   `yaml:"port_annotation"`
+	IntervalInSeconds int      `yaml:"interval"`
+	TimeoutInSeconds  int      `yaml:"timeout"`
+
+	Interval time.Duration `yaml:"-"`
+	Timeout  time.Duration `yaml:"-"`
+}
+
+var defaultKubernetesConfig = KubernetesConfig{
+	ApiServer:         "https://kubernetes.default.svc",
+	TokenPath:         "/var/run/secrets/kubernetes.io/serviceaccount/token",
+	CAPath:            "/var/run/secrets/kubernetes.io/serviceaccount/ca.crt",
+	Annotation:        "gorouter.cloudfoundry.org/routes",
+	PortAnnotation:    "gorouter.cloudfoundry.org/port",
+	IntervalInSeconds: 10,
+	TimeoutInSeconds:  5,
+}
+
+// Routes are also read from the etcd v3 cluster at Endpoints, when they are
+// set: every key under Prefix holds a router.register message, and is
+// watched for changes. The routes are registered again every
+// RefreshIntervalInSeconds, which has to be shorter than the droplet stale
+// threshold for them to stay registered.
+type EtcdConfig struct {
+	Endpoints                []string `yaml:"endpoints"`
+	Prefix                   string   `yaml:"prefix"`
+	RefreshIntervalInSeconds int      `yaml:"refresh_interval"`
+	TimeoutInSeconds         int      `yaml:"timeout"`
+
+	RefreshInterval time.Duration `yaml:"-"`
+	Timeout         time.Duration `yaml:"-"`
+}
+
+var defaultEtcdConfig = EtcdConfig{
+	Prefix:                   "/gorouter/routes/",
+	RefreshIntervalInSeconds: 10,
+	TimeoutInSeconds:         5,
+}
+
+// Operators can capture requests and their responses to File from the
+// status port when Enabled. A capture takes at most MaxRequests requests and
+// runs for at most MaxDurationInSeconds, and keeps at most MaxBodyBytes of
+// each body.
+type CaptureConfig struct {
+	Enabled              bool   `yaml:"enabled"`
+	File                 string `yaml:"file"`
+	MaxRequests          int    `yaml:"max_requests"`
+	MaxDurationInSeconds int    `yaml:"max_duration"`
+	MaxBodyBytes         int64  `yaml:"max_body_bytes"`
+
+	MaxDuration time.Duration `yaml:"-"`
+}
+
+var defaultCaptureConfig = CaptureConfig{
+	MaxRequests:          1000,
+	MaxDurationInSeconds: 3600,
+	MaxBodyBytes:         64 * 1024,
+}
+
+// The router records how it decided what to do with SampleRatio of the
+// requests to File, for compliance review, when DecisionLog is Enabled.
+type DecisionLogConfig struct {
+	Enabled     bool    `yaml:"enabled"`
+	File        string  `yaml:"file"`
+	SampleRatio float64 `yaml:"sample_ratio"`
+}
+
+var defaultDecisionLogConfig = DecisionLogConfig{
+	SampleRatio: 0.01,
+}
+
+// When Enabled, the router writes its route table to File every
+// IntervalInSeconds and when it stops, and restores it on startup so that
+// it serves the routes it knew before the first registrations arrive.
+type RouteSnapshotConfig struct {
+	Enabled           bool   `yaml:"enabled"`
+	File              string `yaml:"file"`
+	IntervalInSeconds int    `yaml:"interval"`
+
+	Interval time.Duration `yaml:"-"`
+}
+
+var defaultRouteSnapshotConfig = RouteSnapshotConfig{
+	IntervalInSeconds: 30,
+}
+
+// RateLimitConfig allows each application RequestsPerSecond requests, with
+// bursts of up to Burst requests, when Enabled.
+type RateLimitConfig struct {
+	Enabled           bool    `yaml:"enabled"`
+	RequestsPerSecond float64 `yaml:"requests_per_second"`
+	Burst             int     `yaml:"burst"`
+}
+
+var defaultRateLimitConfig = RateLimitConfig{
+	RequestsPerSecond: 100,
+	Burst:             200,
+}
+
+// ClientLimitsConfig protects the router from misbehaving clients. Each
+// client IP address may send requests at the rate of RateLimit when it is
+// enabled, and hold at most MaxConnectionsPerIp connections when it is set.
+// Clients in the Allowlist of IP addresses and CIDR ranges, such as load
+// balancers and health checkers, are not limited.
+type ClientLimitsConfig struct {
+	RateLimit           RateLimitConfig `yaml:"rate_limit"`
+	MaxConnectionsPerIp int             `yaml:"max_connections_per_ip"`
+	Allowlist           []string        `yaml:"allowlist"`
+
+	AllowedNetworks []*net.IPNet `yaml:"-"`
+}
+
+var defaultClientLimitsConfig = ClientLimitsConfig{
+	RateLimit: RateLimitConfig{
+		RequestsPerSecond: 50,
+		Burst:             100,
+	},
+}
+
+// RegistrationLimitsConfig limits the route registrations and
+// unregistrations that each source of routes may send to the rate of its
+// entry in Sources, or to the Default rate when that is enabled. A source
+// whose entry is not enabled is not limited. Sources are nats, or
+// nats/<origin> for messages with an origin, admin_api, routing_api, consul,
+// etcd and kubernetes.
+type RegistrationLimitsConfig struct {
+	Default RateLimitConfig            `yaml:"default"`
+	Sources map[string]RateLimitConfig `yaml:"sources"`
+}
+
+var defaultRegistrationLimitsConfig = RegistrationLimitsConfig{
+	Default: RateLimitConfig{
+		RequestsPerSecond: 1000,
+		Burst:             5000,
+	},
+}
+
+// RegistrationBatchConfig has the registrations and unregistrations that
+// arrive over NATS while earlier ones are being applied put in the route
+// table together, up to MaxSize at a time under one lock, so that lookups
+// are held up once per batch rather than once per message during a storm of
+// registrations. Up to QueueSize messages wait for their batch. A MaxSize
+// of 1 applies messages one at a time.
+type RegistrationBatchConfig struct {
+	MaxSize   int `yaml:"max_size"`
+	QueueSize int `yaml:"queue_size"`
+}
+
+var defaultRegistrationBatchConfig = RegistrationBatchConfig{
+	MaxSize:   1000,
+	QueueSize: 10000,
+}
+
+// SignedUrlsConfig holds the Key that URLs of routes registered as
+// requiring signed URLs are signed with.
+type SignedUrlsConfig struct {
+	Key string `yaml:"key"`
+}
+
+// StickySessionsConfig has the __VCAP_ID__ cookie that pins clients to an
+// instance signed with the first of Keys, and encrypted as well when
+// Encrypt is set, so that clients can neither forge it to pick an instance
+// nor read which instance they are pinned to. Cookies made with any of the
+// Keys are accepted, so that a key is rotated by putting the new key first
+// and dropping the old one once its cookies have gone out of use.
+//
+// RouterAddress is the host:port at which the other routers reach this
+// one. When it is set, cookies carry it as a signed hint, and requests
+// pinned to an instance the router does not know, as happens while routes
+// are rebalanced between routers, are forwarded to the router of the hint.
+type StickySessionsConfig struct {
+	Keys          []string `yaml:"keys"`
+	Encrypt       bool     `yaml:"encrypt"`
+	RouterAddress string   `yaml:"router_address"`
+}
+
+// PeerFailoverConfig has requests for routes without local endpoints
+// forwarded to the routers at PeerUrl, such as those of another region,
+// instead of being answered with 404. Name identifies this router's region
+// to its peers so that requests do not loop between them, and a request is
+// forwarded at most MaxHops times.
+type PeerFailoverConfig struct {
+	Name    string `yaml:"name"`
+	PeerUrl string `yaml:"peer_url"`
+	MaxHops int    `yaml:"max_hops"`
+}
+
+var defaultPeerFailoverConfig = PeerFailoverConfig{
+	MaxHops: 1,
+}
+
+// BackendConnectionsConfig has connections to backends kept open between
+// requests when KeepAlive is set, up to MaxIdlePerBackend of them for
+// IdleTimeoutInSeconds. MaxPerBackend caps the connections open to each
+// backend; zero means no cap.
+type BackendConnectionsConfig struct {
+	KeepAlive            bool `yaml:"keep_alive"`
+	MaxIdlePerBackend    int  `yaml:"max_idle_per_backend"`
+	MaxPerBackend        int  `yaml:"max_per_backend"`
+	IdleTimeoutInSeconds int  `yaml:"idle_timeout"`
+
+	IdleTimeout time.Duration `yaml:"-"`
+}
+
+var defaultBackendConnectionsConfig = BackendConnectionsConfig{
+	KeepAlive:            true,
+	MaxIdlePerBackend:    16,
+	IdleTimeoutInSeconds: 90,
+}
+
+// CompressionConfig has responses compressed with gzip or deflate for
+// clients that accept it, when Enabled. Only responses of the ContentTypes
+// with bodies of at least MinBytes are compressed. To bound the CPU that
+// compression takes, at most MaxConcurrent responses are compressed at a
+// time, and further responses are sent uncompressed.
+type CompressionConfig struct {
+	Enabled       bool       `yaml:"enabled"`
+	MinBytes      int        `yaml:"min_bytes"`
+	ContentTypes  []string   `yaml:"content_types"`
+	MaxConcurrent int        `yaml:"max_concurrent"`
+	Zstd          ZstdConfig `yaml:"zstd"`
+}
+
+// ZstdConfig has responses compressed with zstd at Level, which is one of
+// fastest, default and better, for clients that accept it, rather than
+// with gzip, when Enabled. Clients that accept dcz and send the SHA-256 of
+// one of the DictionaryFiles in an Available-Dictionary header get
+// responses compressed with that dictionary, which suits APIs whose JSON
+// responses share most of their keys and values. Dictionaries are shared
+// with clients out of band.
+type ZstdConfig struct {
+	Enabled         bool     `yaml:"enabled"`
+	Level           string   `yaml:"level"`
+	DictionaryFiles []string `yaml:"dictionaries"`
+
+	Dictionaries [][]byte `yaml:"-"`
+}
+
+const (
+	ZstdLevelFastest = "fastest"
+	ZstdLevelDefault = "default"
+	ZstdLevelBetter  = "better"
+)
+
+var defaultCompressionConfig = CompressionConfig{
+	MinBytes:      1024,
+	MaxConcurrent: 256,
+	Zstd: ZstdConfig{
+		Level: ZstdLevelDefault,
+	},
+	ContentTypes: []string{
+		"text/html",
+		"text/plain",
+		"text/css",
+		"text/javascript",
+		"application/javascript",
+		"application/json",
+		"application/xml",
+		"image/svg+xml",
+	},
+}
+
+const (
+	ForwardedHeadersAppend  = "append"
+	ForwardedHeadersReplace = "replace"
+	ForwardedHeadersTrusted = "trusted"
+)
+
+// ForwardedHeadersConfig decides what becomes of the X-Forwarded-For and
+// X-Forwarded-Proto headers that requests arrive with. With the append
+// Policy they are trusted and added to, with replace they are dropped and
+// set from the client's connection, and with trusted they are trusted only
+// on requests from the TrustedProxies, the IP addresses and CIDR ranges of
+// the load balancers in front of the router, and replaced on others.
+type ForwardedHeadersConfig struct {
+	Policy         string   `yaml:"policy"`
+	TrustedProxies []string `yaml:"trusted_proxies"`
+
+	TrustedNetworks []*net.IPNet `yaml:"-"`
+}
+
+var defaultForwardedHeadersConfig = ForwardedHeadersConfig{
+	Policy: ForwardedHeadersAppend,
+}
+
+const (
+	ClientIdentityAwsAlbOidc = "aws_alb_oidc"
+	ClientIdentityXfcc       = "xfcc"
+	ClientIdentityHeader     = "header"
+)
+
+// ClientIdentityConfig has the router pass on who the client of a request
+// is, as the load balancers in front of it established, to backends in
+// Header, with the type of the source that established it in SourceHeader.
+// The headers are the router's to set, and are dropped from the requests
+// that arrive with them.
+type ClientIdentityConfig struct {
+	Header       string                       `yaml:"header"`
+	SourceHeader string                       `yaml:"source_header"`
+	Sources      []ClientIdentitySourceConfig `yaml:"sources"`
+}
+
+// A ClientIdentitySourceConfig is where the identity of a client comes
+// from: the OIDC headers of an AWS Application Load Balancer, the
+// X-Forwarded-Client-Cert header of an mTLS terminating proxy, or a Header
+// that carries the identity as it is. The headers of a source are trusted
+// only on requests from its TrustedProxies, and dropped from others.
+type ClientIdentitySourceConfig struct {
+	Type           string   `yaml:"type"`
+	Header         string   `yaml:"header"`
+	TrustedProxies []string `yaml:"trusted_proxies"`
+
+	TrustedNetworks []*net.IPNet `yaml:"-"`
+}
+
+var defaultClientIdentityConfig = ClientIdentityConfig{
+	Header:       "X-Client-Identity",
+	SourceHeader: "X-Client-Identity-Source",
+}
+
+// ClientAccessConfig has the router answer requests from clients it does
+// not allow with 403 Forbidden. Clients in the DeniedCidrs are not
+// allowed, and neither are, when AllowedCidrs are given, the clients in
+// none of them. Both are lists of IP addresses and CIDR ranges.
+type ClientAccessConfig struct {
+	AllowedCidrs []string `yaml:"allowed_cidrs"`
+	DeniedCidrs  []string `yaml:"denied_cidrs"`
+
+	AllowedNetworks []*net.IPNet `yaml:"-"`
+	DeniedNetworks  []*net.IPNet `yaml:"-"`
+}
+
+// InspectionConfig has the router read the bodies of requests, with their
+// gzip or deflate coding undone, and refuse the requests whose body matches
+// any of DenyPatterns, which are regular expressions. Bodies of more than
+// MaxBodyBytes, and bodies that decode to more than MaxDecodedBytes or
+// MaxRatio times their size, are refused unread.
+type InspectionConfig struct {
+	Enabled         bool     `yaml:"enabled"`
+	MaxBodyBytes    int64    `yaml:"max_body_bytes"`
+	MaxDecodedBytes int64    `yaml:"max_decoded_bytes"`
+	MaxRatio        int64    `yaml:"max_ratio"`
+	DenyPatterns    []string `yaml:"deny_patterns"`
+
+	DenyRegexps []*regexp.Regexp `yaml:"-"`
+}
+
+// JwtConfig has the router check the bearer tokens of requests to routes
+// registered with auth jwt before it passes them on. Tokens must be signed
+// by one of the keys of the JSON Web Key Set at JwksUrl, which is fetched
+// again every JwksRefreshInterval, and be issued by Issuer to Audience.
+// ClaimHeaders names the request header each claim is passed on to the
+// backend in.
+type JwtConfig struct {
+	JwksUrl                      string            `yaml:"jwks_url"`
+	Issuer                       string            `yaml:"issuer"`
+	Audience                     string            `yaml:"audience"`
+	JwksRefreshIntervalInSeconds int               `yaml:"jwks_refresh_interval"`
+	ClaimHeaders                 map[string]string `yaml:"claim_headers"`
+
+	JwksRefreshInterval time.Duration `yaml:"-"`
+}
+
+// MirroringConfig has the requests for the routes of Routes copied to
+// their shadow routes in the background, to try a new version of a service
+// out with production traffic. The shadow's responses are discarded.
+// Requests with bodies of more than MaxBodyBytes are not mirrored, nor are
+// requests while MaxInFlight copies are in flight, so that mirroring never
+// holds up the traffic it copies. Copies are given up after
+// TimeoutInSeconds.
+type MirroringConfig struct {
+	Routes           []MirrorRouteConfig `yaml:"routes"`
+	MaxBodyBytes     int64               `yaml:"max_body_bytes"`
+	MaxInFlight      int                 `yaml:"max_in_flight"`
+	TimeoutInSeconds int                 `yaml:"timeout"`
+
+	Timeout time.Duration `yaml:"-"`
+}
+
+// A MirrorRouteConfig copies Percent of the requests for Route, or all of
+// them when it is not set, to Shadow. Both are host names of routes
+// registered with the router.
+type MirrorRouteConfig struct {
+	Route   string `yaml:"route"`
+	Shadow  string `yaml:"shadow"`
+	Percent int    `yaml:"percent"`
+}
+
+var defaultMirroringConfig = MirroringConfig{
+	MaxBodyBytes:     1024 * 1024,
+	MaxInFlight:      100,
+	TimeoutInSeconds: 10,
+}
+
+// RequestQueueConfig caps the requests the router proxies at once at
+// MaxInFlight when it is set. Requests over the cap wait in a queue of up
+// to MaxQueued requests for at most TimeoutInSeconds, and are let through
+// by weighted fair queuing between their routes rather than in the order
+// they came in, so that one overloaded route cannot starve the others.
+// Routes get shares of the requests let through in proportion to their
+// Weights by host, and a weight of 1 when they have none.
+type RequestQueueConfig struct {
+	MaxInFlight      int            `yaml:"max_in_flight"`
+	MaxQueued        int            `yaml:"max_queued"`
+	TimeoutInSeconds int            `yaml:"timeout"`
+	Weights          map[string]int `yaml:"weights"`
+
+	Timeout time.Duration `yaml:"-"`
+}
+
+var defaultRequestQueueConfig = RequestQueueConfig{
+	MaxQueued:        1000,
+	TimeoutInSeconds: 10,
+}
+
+// SessionAffinityConfig pins the requests that carry the same value of
+// Header, such as a tenant id, to the same endpoint of their route, for
+// clients that do not keep cookies. A value stays pinned until no request
+// carried it for TTLInSeconds.
+type SessionAffinityConfig struct {
+	Header       string `yaml:"header"`
+	TTLInSeconds int    `yaml:"ttl"`
+
+	TTL time.Duration `yaml:"-"`
+}
+
+var defaultSessionAffinityConfig = SessionAffinityConfig{
+	TTLInSeconds: 3600,
+}
+
+// AnalyticsConfig has an event posted to Url for every request that one of
+// Rules matches, with the fields the rule extracts from the request rather
+// than the request itself. Events are posted as JSON lines, in batches of
+// up to BatchSize at least every FlushInterval. Up to BufferSize events
+// wait to be posted; further events are dropped.
+type AnalyticsConfig struct {
+	Url                    string                `yaml:"url"`
+	BatchSize              int                   `yaml:"batch_size"`
+	BufferSize             int                   `yaml:"buffer_size"`
+	FlushIntervalInSeconds int                   `yaml:"flush_interval"`
+	TimeoutInSeconds       int                   `yaml:"timeout"`
+	Rules                  []AnalyticsRuleConfig `yaml:"rules"`
+
+	FlushInterval time.Duration `yaml:"-"`
+	Timeout       time.Duration `yaml:"-"`
+}
+
+// An AnalyticsRuleConfig emits Event for the requests to Routes whose
+// method is one of Methods and whose path fits Path, with the values of
+// Headers. Path is a template of literal segments and {name} segments,
+// each of which matches any one segment and extracts it as the parameter
+// name. Requests match any route, method or path when the rule has none.
+type AnalyticsRuleConfig struct {
+	Event   string   `yaml:"event"`
+	Routes  []string `yaml:"routes"`
+	Methods []string `yaml:"methods"`
+	Path    string   `yaml:"path"`
+	Headers []string `yaml:"headers"`
+}
+
+var defaultAnalyticsConfig = AnalyticsConfig{
+	BatchSize:              100,
+	BufferSize:             10000,
+	FlushIntervalInSeconds: 1,
+	TimeoutInSeconds:       5,
+}
+
+type UsageConfig struct {
+	Enabled        bool `yaml:"enabled"`
+	RetentionHours int  `yaml:"retention_hours"`
+}
+
+var defaultUsageConfig = UsageConfig{
+	RetentionHours: 24,
+}
+
+type TracingConfig struct {
+	EnableZipkin bool    `yaml:"enable_zipkin"`
+	OtlpEndpoint string  `yaml:"otlp_endpoint"`
+	ServiceName  string  `yaml:"service_name"`
+	SampleRatio  float64 `yaml:"sample_ratio"`
+}
+
+var defaultTracingConfig = TracingConfig{
+	ServiceName: "gorouter",
+	SampleRatio: 1,
+}
+
+type PrometheusConfig struct {
+	Port uint16 `yaml:"port"`
+}
+
+// HealthDetailConfig sets how /health/detail on the status port weighs its
+// subsystems. Each subsystem has a severity in Severities, one of critical,
+// warning and ignore, and those without one are warnings, but for nats and
+// listeners, which are critical. The route registry is stale when no route
+// was registered for RegistryStaleAfterInSeconds, the routing API when it
+// was not fetched from for RoutingApiStaleAfterInSeconds, and certificates
+// are due CertificateWarningDays before they expire.
+type HealthDetailConfig struct {
+	Severities                    map[string]string `yaml:"severities"`
+	RegistryStaleAfterInSeconds   int               `yaml:"registry_stale_after"`
+	RoutingApiStaleAfterInSeconds int               `yaml:"routing_api_stale_after"`
+	CertificateWarningDays        int               `yaml:"certificate_warning_days"`
+
+	RegistryStaleAfter   time.Duration `yaml:"-"`
+	RoutingApiStaleAfter time.Duration `yaml:"-"`
+}
+
+const (
+	SeverityCritical = "critical"
+	SeverityWarning  = "warning"
+	SeverityIgnore   = "ignore"
+)
+
+var defaultHealthDetailConfig = HealthDetailConfig{
+	RegistryStaleAfterInSeconds:   300,
+	RoutingApiStaleAfterInSeconds: 300,
+	CertificateWarningDays:        30,
+}
+
+// HealthzConfig sets what /healthz on the status port answers. With
+// Detailed it answers with a JSON report on the router rather than with its
+// bare status, and with Strict it also fails once NATS has not answered
+// pings, sent every NatsCheckIntervalInSeconds, for longer than
+// NatsUnreachableAfterInSeconds.
+type HealthzConfig struct {
+	Detailed                      bool `yaml:"detailed"`
+	Strict                        bool `yaml:"strict"`
+	NatsCheckIntervalInSeconds    int  `yaml:"nats_check_interval"`
+	NatsUnreachableAfterInSeconds int  `yaml:"nats_unreachable_after"`
+
+	NatsCheckInterval    time.Duration `yaml:"-"`
+	NatsUnreachableAfter time.Duration `yaml:"-"`
+}
+
+var defaultHealthzConfig = HealthzConfig{
+	NatsCheckIntervalInSeconds:    5,
+	NatsUnreachableAfterInSeconds: 60,
+}
+
+// CertificateExpiryConfig sets how the certificates the router holds are
+// watched for their expiry. They are checked every IntervalInSeconds, a
+// warning is logged once a certificate has fewer days left than each of
+// WarningDays, and readiness is degraded while one has fewer than
+// DegradeReadinessDays left, when that is set. Without WarningDays, warnings
+// are logged 30, 14, 7 and 1 days before expiry.
+type CertificateExpiryConfig struct {
+	IntervalInSeconds    int   `yaml:"interval"`
+	WarningDays          []int `yaml:"warning_days"`
+	DegradeReadinessDays int   `yaml:"degrade_readiness_days"`
+
+	Interval time.Duration `yaml:"-"`
+}
+
+var defaultCertificateExpiryConfig = CertificateExpiryConfig{
+	IntervalInSeconds: 3600,
+}
+
+// the yaml decoder writes lists into the slice it finds, so the default
+// warning days are not left in the default config for it to overwrite
+var defaultCertificateWarningDays = []int{30, 14, 7, 1}
+
+// AdminApiConfig serves an API to register and unregister routes without
+// NATS on Port, when it is set. Requests are authenticated by one of the
+// bearer Tokens, or by a client certificate signed by the CA of
+// ClientCAPath, which needs the API to be served over TLS with the
+// certificate of CertPath and KeyPath.
+type AdminApiConfig struct {
+	Port         uint16   `yaml:"port"`
+	Tokens       []string `yaml:"tokens"`
+	CertPath     string   `yaml:"cert_path"`
+	KeyPath      string   `yaml:"key_path"`
+	ClientCAPath string   `yaml:"client_ca_path"`
+
+	Certificate *tls.Certificate `yaml:"-"`
+	ClientCAs   *x509.CertPool   `yaml:"-"`
+}
+
+// A TlsCertificateConfig is a certificate the router serves over TLS to the
+// clients that ask for one of its Domains, which may start with "*." to
+// cover their subdomains. The OCSP response of OcspStaplePath, when it is
+// set, is stapled to the handshakes.
+type TlsCertificateConfig struct {
+	Domains        []string `yaml:"domains"`
+	CertPath       string   `yaml:"cert_path"`
+	KeyPath        string   `yaml:"key_path"`
+	OcspStaplePath string   `yaml:"ocsp_staple_path"`
+}
+
+type TlsPassthroughConfig struct {
+	Port uint16 `yaml:"port"`
+}
+
+type CutoverDomainConfig struct {
+	Domain  string `yaml:"domain"`
+	Percent int    `yaml:"percent"`
+}
+
+// An OAuth2ProxyConfig has the router log users in with an OAuth2 or OpenID
+// Connect provider before it passes on their requests to Domain, which may
+// start with "*." to cover its subdomains. Users are identified by the
+// provider's UserInfoUrl, or by the claims of the ID token when there is
+// none. Sessions are kept in a cookie encrypted with CookieSecret.
+type OAuth2ProxyConfig struct {
+	Domain       string   `yaml:"domain"`
+	ClientId     string   `yaml:"client_id"`
+	ClientSecret string   `yaml:"client_secret"`
+	AuthorizeUrl string   `yaml:"authorize_url"`
+	TokenUrl     string   `yaml:"token_url"`
+	UserInfoUrl  string   `yaml:"user_info_url"`
+	Scopes       []string `yaml:"scopes"`
+
+	CookieName               string   `yaml:"cookie_name"`
+	CookieSecret             string   `yaml:"cookie_secret"`
+	SessionLifetimeInSeconds int      `yaml:"session_lifetime"`
+	AllowedEmailDomains      []string `yaml:"allowed_email_domains"`
+	PassAccessToken          bool     `yaml:"pass_access_token"`
+
+	SessionLifetime time.Duration `yaml:"-"`
+}
+
+// A HeaderRuleConfig changes the headers of requests to, and responses
+// from, the routes whose host matches one of Routes, or of all routes when
+// there are none. Routes are host names, or "*." and a domain for its
+// subdomains. Header values may refer to the variables ${client_ip},
+// ${host}, ${scheme}, ${path} and ${request_id} of the request.
+type HeaderRuleConfig struct {
+	Routes   []string            `yaml:"routes"`
+	Request  HeaderActionsConfig `yaml:"request"`
+	Response HeaderActionsConfig `yaml:"response"`
+}
+
+// A RequestFilterConfig matches the requests for the routes whose host
+// matches one of Routes, whose path matches one of Paths and whose method is
+// one of Methods. Routes are host names, or "*." and a domain for its
+// subdomains, and Paths are patterns of path.Match, such as /healthz or
+// /status/*. Requests match any route, path or method when the list for it
+// is empty.
+type RequestFilterConfig struct {
+	Routes  []string `yaml:"routes"`
+	Paths   []string `yaml:"paths"`
+	Methods []string `yaml:"methods"`
+}
+
+// Policies for request headers that appear more than once. DuplicateMerge
+// joins their values into one, DuplicateFirst keeps the first value only
+// and DuplicateReject refuses the request.
+const (
+	DuplicateMerge  = "merge"
+	DuplicateFirst  = "first"
+	DuplicateReject = "reject"
+)
+
+// HttpParsingConfig sets how leniently requests on the HTTP port are
+// parsed: whether header values folded over several lines, the obsolete
+// line folding of RFC 7230, are accepted or rejected, and whether header
+// values with control characters are rejected or have them replaced with
+// spaces. Routes set the same for the requests whose host matches one of
+// their Routes, with the first of them to set a mode winning, and the modes
+// of the config applying to the others.
+type HttpParsingConfig struct {
+	ObsoleteLineFolding     string                   `yaml:"obsolete_line_folding"`
+	InvalidHeaderCharacters string                   `yaml:"invalid_header_characters"`
+	Routes                  []HttpParsingRouteConfig `yaml:"routes"`
+}
+
+// An HttpParsingRouteConfig sets the modes of parsing for the requests
+// whose host is one of Routes, or, for patterns starting with "*.", a
+// subdomain of the rest. Modes left empty are those of the config.
+type HttpParsingRouteConfig struct {
+	Routes                  []string `yaml:"routes"`
+	ObsoleteLineFolding     string   `yaml:"obsolete_line_folding"`
+	InvalidHeaderCharacters string   `yaml:"invalid_header_characters"`
+}
+
+// Modes of parsing. Obsolete line folding is accepted or rejected, and
+// invalid header characters are rejected or replaced.
+const (
+	HttpParsingAccept  = "accept"
+	HttpParsingReject  = "reject"
+	HttpParsingReplace = "replace"
+)
+
+var defaultHttpParsingConfig = HttpParsingConfig{
+	ObsoleteLineFolding:     HttpParsingAccept,
+	InvalidHeaderCharacters: HttpParsingReject,
+}
+
+// HeaderActionsConfig removes the headers of Remove, then sets the headers
+// of Set, replacing any values they had, and adds the headers of Add.
+type HeaderActionsConfig struct {
+	Remove []string          `yaml:"remove"`
+	Set    map[string]string `yaml:"set"`
+	Add    map[string]string `yaml:"add"`
+}
+
+// HeaderRuleVariables are the variables header rule values may refer to.
+var HeaderRuleVariables = []string{"client_ip", "host", "scheme", "path", "request_id"}
+
+// An ErrorPageConfig replaces the plain text body of the responses with
+// Status that the router generates itself, such as 404 for unknown routes
+// and 502 or 503 when no endpoint can handle a request. HtmlFile and
+// JsonFile are Go templates, of which the one the request's Accept header
+// prefers is used; a request that accepts neither gets the plain text.
+type ErrorPageConfig struct {
+	Status   int    `yaml:"status"`
+	HtmlFile string `yaml:"html_file"`
+	JsonFile string `yaml:"json_file"`
+}
+
+// A BannerConfig has the Html snippet injected right after the opening
+// body tag of the HTML responses for the hosts of Domains, to announce
+// maintenance. Domains starting with "*." cover their subdomains. The
+// banner is shown from StartsAt until EndsAt, given in RFC 3339, or
+// without either bound when it is left out.
+type BannerConfig struct {
+	Domains  []string `yaml:"domains"`
+	Html     string   `yaml:"html"`
+	StartsAt string   `yaml:"starts_at"`
+	EndsAt   string   `yaml:"ends_at"`
+
+	Starts time.Time `yaml:"-"`
+	Ends   time.Time `yaml:"-"`
 }
 
 var defaultNatsConfig = NatsConfig{
@@ -48,12 +1023,46 @@ var defaultNatsConfig = NatsConfig{
 	Pass: "",
 }
 
+// Router metrics and HttpStartStop timers are also sent over gRPC to the
+// v2 Ingress of the loggregator agent at Address when Enabled.
+type LoggregatorV2Config struct {
+	Enabled                  bool   `yaml:"enabled"`
+	Address                  string `yaml:"address"`
+	CAFile                   string `yaml:"ca_file"`
+	CertFile                 string `yaml:"cert_file"`
+	KeyFile                  string `yaml:"key_file"`
+	ServerName               string `yaml:"server_name"`
+	BatchSize                int    `yaml:"batch_size"`
+	BufferSize               int    `yaml:"buffer_size"`
+	FlushIntervalInSeconds   int    `yaml:"flush_interval"`
+	TimeoutInSeconds         int    `yaml:"timeout"`
+	MetricsIntervalInSeconds int    `yaml:"metrics_interval"`
+
+	// These fields are populated by the `Process` function.
+	FlushInterval   time.Duration `yaml:"-"`
+	Timeout         time.Duration `yaml:"-"`
+	MetricsInterval time.Duration `yaml:"-"`
+}
+
+var defaultLoggregatorV2Config = LoggregatorV2Config{
+	Address:                  "localhost:3458",
+	ServerName:               "metron",
+	BatchSize:                100,
+	BufferSize:               10000,
+	FlushIntervalInSeconds:   1,
+	TimeoutInSeconds:         10,
+	MetricsIntervalInSeconds: 15,
+}
+
 type LoggingConfig struct {
 	File               string `yaml:"file"`
 	Syslog             string `yaml:"syslog"`
 	Level              string `yaml:"level"`
 	LoggregatorEnabled bool   `yaml:"loggregator_enabled"`
 	MetronAddress      string `yaml:"metron_address"`
+	EmitHttpStartStop  bool   `yaml:"emit_http_start_stop"`
+
+	LoggregatorV2 LoggregatorV2Config `yaml:"loggregator_v2"`
 
 	// This field is populated by the `Process` function.
 	JobName string `yaml:"-"`
@@ -62,6 +1071,7 @@ type LoggingConfig struct {
 var defaultLoggingConfig = LoggingConfig{
 	Level:         "debug",
 	MetronAddress: "localhost:3457",
+	LoggregatorV2: defaultLoggregatorV2Config,
 }
 
 type Config struct {
@@ -69,22 +1079,35 @@ type Config struct {
 	Nats    []NatsConfig  `yaml:"nats"`
 	Logging LoggingConfig `yaml:"logging"`
 
-	Port           uint16 `yaml:"port"`
-	Index          uint   `yaml:"index"`
-	Zone           string `yaml:"zone"`
-	GoMaxProcs     int    `yaml:"go_max_procs,omitempty"`
-	TraceKey       string `yaml:"trace_key"`
-	AccessLog      string `yaml:"access_log"`
-	DebugAddr      string `yaml:"debug_addr"`
-	EnableSSL      bool   `yaml:"enable_ssl"`
-	SSLPort        uint16 `yaml:"ssl_port"`
-	SSLCertPath    string `yaml:"ssl_cert_path"`
-	SSLKeyPath     string `yaml:"ssl_key_path"`
-	SSLCertificate tls.Certificate
+	// NatsTLS, NatsCredentialsPath and NatsNkeySeedPath secure the
+	// connections to the servers of Nats.
+	NatsTLS             NatsTLSConfig `yaml:"nats_tls"`
+	NatsCredentialsPath string        `yaml:"nats_credentials_path"`
+	NatsNkeySeedPath    string        `yaml:"nats_nkey_seed_path"`
+
+	// NatsClusters replaces Nats when the router is to take routes from
+	// more than one NATS cluster.
+	NatsClusters []NatsClusterConfig `yaml:"nats_clusters"`
+
+	Port            uint16 `yaml:"port"`
+	Index           uint   `yaml:"index"`
+	Zone            string `yaml:"zone"`
+	GoMaxProcs      int    `yaml:"go_max_procs,omitempty"`
+	TraceKey        string `yaml:"trace_key"`
+	AccessLog       string `yaml:"access_log"`
+	AccessLogFormat string `yaml:"access_log_format"`
+	DebugAddr       string `yaml:"debug_addr"`
+	EnableSSL       bool   `yaml:"enable_ssl"`
+	SSLPort         uint16 `yaml:"ssl_port"`
+	SSLCertPath     string `yaml:"ssl_cert_path"`
+	SSLKeyPath      string `yaml:"ssl_key_path"`
+	SSLCertificate  tls.Certificate
 
 	CipherString string `yaml:"cipher_suites"`
 	CipherSuites []uint16
 
+	TlsCertificates []TlsCertificateConfig `yaml:"tls_certificates"`
+
 	PublishStartMessageIntervalInSeconds int  `yaml:"publish_start_message_interval"`
 	PruneStaleDropletsIntervalInSeconds  int  `yaml:"prune_stale_droplets_interval"`
 	DropletStaleThresholdInSeconds       int  `yaml:"droplet_stale_threshold"`
@@ -92,10 +1115,80 @@ type Config struct {
 	StartResponseDelayIntervalInSeconds  int  `yaml:"start_response_delay_interval"`
 	EndpointTimeoutInSeconds             int  `yaml:"endpoint_timeout"`
 	DrainTimeoutInSeconds                int  `yaml:"drain_timeout,omitempty"`
+	DrainWaitInSeconds                   int  `yaml:"drain_wait"`
+	UpgradeTimeoutInSeconds              int  `yaml:"upgrade_timeout"`
+	FailbackDelayInSeconds               int  `yaml:"failback_delay"`
 	SecureCookies                        bool `yaml:"secure_cookies"`
+	JsonErrors                           bool `yaml:"json_errors"`
+
+	StripResponseHeaders []string `yaml:"strip_response_headers"`
 
 	OAuth      token_fetcher.OAuthConfig `yaml:"oauth"`
 	RoutingApi RoutingApiConfig          `yaml:"routing_api"`
+	TcpRouting TcpRoutingConfig          `yaml:"tcp_routing"`
+
+	TlsPassthrough TlsPassthroughConfig `yaml:"tls_passthrough"`
+	Prometheus     PrometheusConfig     `yaml:"prometheus"`
+	AdminApi       AdminApiConfig       `yaml:"admin_api"`
+
+	LeaderElection LeaderElectionConfig `yaml:"leader_election"`
+	Tracing        TracingConfig        `yaml:"tracing"`
+	Usage          UsageConfig          `yaml:"usage"`
+	Limits         LimitsConfig         `yaml:"limits"`
+	Cache          CacheConfig          `yaml:"cache"`
+	CircuitBreaker CircuitBreakerConfig `yaml:"circuit_breaker"`
+	HealthCheck    HealthCheckConfig    `yaml:"health_check"`
+	Consul         ConsulConfig         `yaml:"consul"`
+	Etcd           EtcdConfig           `yaml:"etcd"`
+	Kubernetes     KubernetesConfig     `yaml:"kubernetes"`
+	Capture        CaptureConfig        `yaml:"capture"`
+	DecisionLog    DecisionLogConfig    `yaml:"decision_log"`
+	RouteSnapshot  RouteSnapshotConfig  `yaml:"route_snapshot"`
+	RateLimit      RateLimitConfig      `yaml:"rate_limit"`
+	ClientLimits   ClientLimitsConfig   `yaml:"client_limits"`
+	SignedUrls     SignedUrlsConfig     `yaml:"signed_urls"`
+	StickySessions StickySessionsConfig `yaml:"sticky_sessions"`
+	PeerFailover   PeerFailoverConfig   `yaml:"peer_failover"`
+
+	BackendConnections BackendConnectionsConfig `yaml:"backend_connections"`
+	RegistrationLimits RegistrationLimitsConfig `yaml:"registration_limits"`
+	RegistrationBatch  RegistrationBatchConfig  `yaml:"registration_batch"`
+	Compression        CompressionConfig        `yaml:"compression"`
+	ForwardedHeaders   ForwardedHeadersConfig   `yaml:"forwarded_headers"`
+	ClientIdentity     ClientIdentityConfig     `yaml:"client_identity"`
+	ClientAccess       ClientAccessConfig       `yaml:"client_access"`
+	Jwt                JwtConfig                `yaml:"jwt"`
+	Inspection         InspectionConfig         `yaml:"inspection"`
+	Mirroring          MirroringConfig          `yaml:"mirroring"`
+	RequestQueue       RequestQueueConfig       `yaml:"request_queue"`
+	SessionAffinity    SessionAffinityConfig    `yaml:"session_affinity"`
+	Analytics          AnalyticsConfig          `yaml:"analytics"`
+	HealthDetail       HealthDetailConfig       `yaml:"health_detail"`
+	Healthz            HealthzConfig            `yaml:"healthz"`
+	CertificateExpiry  CertificateExpiryConfig  `yaml:"certificate_expiry"`
+
+	AccessLogSyslog AccessLogSyslogConfig `yaml:"access_log_syslog"`
+	AccessLogKafka  AccessLogKafkaConfig  `yaml:"access_log_kafka"`
+
+	// Access log records wait in a buffer of AccessLogBufferSize records
+	// to be written, and are dropped when it is full.
+	AccessLogBufferSize int `yaml:"access_log_buffer_size"`
+
+	CutoverDomains []CutoverDomainConfig `yaml:"cutover_domains"`
+	OAuth2Proxies  []OAuth2ProxyConfig   `yaml:"oauth2_proxies"`
+	HeaderRules    []HeaderRuleConfig    `yaml:"header_rules"`
+	HttpParsing    HttpParsingConfig     `yaml:"http_parsing"`
+	ErrorPages     []ErrorPageConfig     `yaml:"error_pages"`
+	Banners        []BannerConfig        `yaml:"banners"`
+
+	// DuplicateHeaders has the policy, one of merge, first and reject, for
+	// each request header name that is handled when it appears more than
+	// once. Headers without a policy are passed on as they are.
+	DuplicateHeaders map[string]string `yaml:"duplicate_headers"`
+
+	// Requests that MonitoringExclusions match, such as health checks, are
+	// left out of the access log and the response metrics.
+	MonitoringExclusions []RequestFilterConfig `yaml:"monitoring_exclusions"`
 
 	// These fields are populated by the `Process` function.
 	PruneStaleDropletsInterval time.Duration `yaml:"-"`
@@ -104,21 +1197,64 @@ type Config struct {
 	StartResponseDelayInterval time.Duration `yaml:"-"`
 	EndpointTimeout            time.Duration `yaml:"-"`
 	DrainTimeout               time.Duration `yaml:"-"`
+	DrainWait                  time.Duration `yaml:"-"`
+	UpgradeTimeout             time.Duration `yaml:"-"`
+	FailbackDelay              time.Duration `yaml:"-"`
 	Ip                         string        `yaml:"-"`
 }
 
 var defaultConfig = Config{
-	Status:  defaultStatusConfig,
-	Nats:    []NatsConfig{defaultNatsConfig},
-	Logging: defaultLoggingConfig,
+	Status:     defaultStatusConfig,
+	Nats:       []NatsConfig{defaultNatsConfig},
+	Logging:    defaultLoggingConfig,
+	RoutingApi: defaultRoutingApiConfig,
+
+	LeaderElection: defaultLeaderElectionConfig,
+	Tracing:        defaultTracingConfig,
+	Usage:          defaultUsageConfig,
+	Cache:          defaultCacheConfig,
+	CircuitBreaker: defaultCircuitBreakerConfig,
+	HealthCheck:    defaultHealthCheckConfig,
+	Consul:         defaultConsulConfig,
+	Etcd:           defaultEtcdConfig,
+	Kubernetes:     defaultKubernetesConfig,
+	Capture:        defaultCaptureConfig,
+	DecisionLog:    defaultDecisionLogConfig,
+	RouteSnapshot:  defaultRouteSnapshotConfig,
+	RateLimit:      defaultRateLimitConfig,
+	ClientLimits:   defaultClientLimitsConfig,
+	PeerFailover:   defaultPeerFailoverConfig,
+
+	BackendConnections: defaultBackendConnectionsConfig,
+	RegistrationLimits: defaultRegistrationLimitsConfig,
+	RegistrationBatch:  defaultRegistrationBatchConfig,
+	Compression:        defaultCompressionConfig,
+	ForwardedHeaders:   defaultForwardedHeadersConfig,
+	ClientIdentity:     defaultClientIdentityConfig,
+	HttpParsing:        defaultHttpParsingConfig,
+	HealthDetail:       defaultHealthDetailConfig,
+	Healthz:            defaultHealthzConfig,
+	CertificateExpiry:  defaultCertificateExpiryConfig,
+	Mirroring:          defaultMirroringConfig,
+	RequestQueue:       defaultRequestQueueConfig,
+	SessionAffinity:    defaultSessionAffinityConfig,
+	Analytics:          defaultAnalyticsConfig,
 
-	Port:       8081,
-	Index:      0,
-	GoMaxProcs: -1,
-	EnableSSL:  false,
-	SSLPort:    443,
+	AccessLogSyslog: defaultAccessLogSyslogConfig,
+	AccessLogKafka:  defaultAccessLogKafkaConfig,
+
+	AccessLogBufferSize: 10000,
+
+	Port:            8081,
+	Index:           0,
+	AccessLogFormat: AccessLogFormatText,
+	GoMaxProcs:      -1,
+	EnableSSL:       false,
+	SSLPort:         443,
 
 	EndpointTimeoutInSeconds: 60,
+	UpgradeTimeoutInSeconds:  120,
+	FailbackDelayInSeconds:   30,
 
 	PublishStartMessageIntervalInSeconds: 30,
 	PruneStaleDropletsIntervalInSeconds:  30,
@@ -147,7 +1283,35 @@ func (c *Config) Process() {
 	c.PublishActiveAppsInterval = time.Duration(c.PublishActiveAppsIntervalInSeconds) * time.Second
 	c.StartResponseDelayInterval = time.Duration(c.StartResponseDelayIntervalInSeconds) * time.Second
 	c.EndpointTimeout = time.Duration(c.EndpointTimeoutInSeconds) * time.Second
+	c.FailbackDelay = time.Duration(c.FailbackDelayInSeconds) * time.Second
 	c.Logging.JobName = "router_" + c.Zone + "_" + strconv.Itoa(int(c.Index))
+	c.LeaderElection.HeartbeatInterval = time.Duration(c.LeaderElection.HeartbeatIntervalInSeconds) * time.Second
+	c.LeaderElection.TTL = time.Duration(c.LeaderElection.TTLInSeconds) * time.Second
+	c.LeaderElection.ReconcileInterval = time.Duration(c.LeaderElection.ReconcileIntervalInSeconds) * time.Second
+	c.AccessLogKafka.FlushInterval = time.Duration(c.AccessLogKafka.FlushIntervalInSeconds) * time.Second
+	c.AccessLogKafka.Timeout = time.Duration(c.AccessLogKafka.TimeoutInSeconds) * time.Second
+	c.Logging.LoggregatorV2.FlushInterval = time.Duration(c.Logging.LoggregatorV2.FlushIntervalInSeconds) * time.Second
+	c.Logging.LoggregatorV2.Timeout = time.Duration(c.Logging.LoggregatorV2.TimeoutInSeconds) * time.Second
+	c.Logging.LoggregatorV2.MetricsInterval = time.Duration(c.Logging.LoggregatorV2.MetricsIntervalInSeconds) * time.Second
+	c.CircuitBreaker.BaseEjectionTime = time.Duration(c.CircuitBreaker.BaseEjectionTimeInSeconds) * time.Second
+	c.CircuitBreaker.MaxEjectionTime = time.Duration(c.CircuitBreaker.MaxEjectionTimeInSeconds) * time.Second
+	c.HealthCheck.Interval = time.Duration(c.HealthCheck.IntervalInSeconds) * time.Second
+	c.HealthCheck.Timeout = time.Duration(c.HealthCheck.TimeoutInSeconds) * time.Second
+	c.RoutingApi.FullSyncInterval = time.Duration(c.RoutingApi.FullSyncIntervalInSeconds) * time.Second
+	c.Consul.Interval = time.Duration(c.Consul.IntervalInSeconds) * time.Second
+	c.Consul.Timeout = time.Duration(c.Consul.TimeoutInSeconds) * time.Second
+	c.Etcd.RefreshInterval = time.Duration(c.Etcd.RefreshIntervalInSeconds) * time.Second
+	c.Etcd.Timeout = time.Duration(c.Etcd.TimeoutInSeconds) * time.Second
+	c.Kubernetes.Interval = time.Duration(c.Kubernetes.IntervalInSeconds) * time.Second
+	c.Kubernetes.Timeout = time.Duration(c.Kubernetes.TimeoutInSeconds) * time.Second
+	c.Capture.MaxDuration = time.Duration(c.Capture.MaxDurationInSeconds) * time.Second
+	c.RouteSnapshot.Interval = time.Duration(c.RouteSnapshot.IntervalInSeconds) * time.Second
+	c.BackendConnections.IdleTimeout = time.Duration(c.BackendConnections.IdleTimeoutInSeconds) * time.Second
+	c.Mirroring.Timeout = time.Duration(c.Mirroring.TimeoutInSeconds) * time.Second
+	c.RequestQueue.Timeout = time.Duration(c.RequestQueue.TimeoutInSeconds) * time.Second
+	c.SessionAffinity.TTL = time.Duration(c.SessionAffinity.TTLInSeconds) * time.Second
+	c.Analytics.FlushInterval = time.Duration(c.Analytics.FlushIntervalInSeconds) * time.Second
+	c.Analytics.Timeout = time.Duration(c.Analytics.TimeoutInSeconds) * time.Second
 
 	if c.StartResponseDelayInterval > c.DropletStaleThreshold {
 		c.DropletStaleThreshold = c.StartResponseDelayInterval
@@ -160,12 +1324,190 @@ func (c *Config) Process() {
 		drain = c.EndpointTimeoutInSeconds
 	}
 	c.DrainTimeout = time.Duration(drain) * time.Second
+	c.DrainWait = time.Duration(c.DrainWaitInSeconds) * time.Second
+	c.UpgradeTimeout = time.Duration(c.UpgradeTimeoutInSeconds) * time.Second
 
 	c.Ip, err = localip.LocalIP()
 	if err != nil {
 		panic(err)
 	}
 
+	if c.AccessLogFormat != AccessLogFormatText && c.AccessLogFormat != AccessLogFormatJSON {
+		panic("invalid access log format: " + c.AccessLogFormat)
+	}
+
+	if c.AccessLogBufferSize < 1 {
+		panic("access log buffer size must be positive")
+	}
+
+	switch c.AccessLogSyslog.Network {
+	case SyslogNetworkUDP, SyslogNetworkTCP, SyslogNetworkTLS:
+	default:
+		panic("invalid access log syslog network: " + c.AccessLogSyslog.Network)
+	}
+
+	if c.Consul.Enabled && (c.Consul.Interval <= 0 || c.Consul.Interval >= c.DropletStaleThreshold) {
+		panic("consul interval has to be positive and shorter than droplet_stale_threshold")
+	}
+
+	if len(c.Etcd.Endpoints) > 0 && (c.Etcd.Prefix == "" || c.Etcd.RefreshInterval <= 0 || c.Etcd.RefreshInterval >= c.DropletStaleThreshold) {
+		panic("etcd needs a prefix and a refresh_interval that is positive and shorter than droplet_stale_threshold")
+	}
+
+	if c.Kubernetes.Enabled && (c.Kubernetes.Annotation == "" || c.Kubernetes.Interval <= 0 || c.Kubernetes.Interval >= c.DropletStaleThreshold) {
+		panic("kubernetes needs an annotation and an interval that is positive and shorter than droplet_stale_threshold")
+	}
+
+	if c.Capture.Enabled && c.Capture.File == "" {
+		panic("capture is enabled without a file")
+	}
+
+	if c.DecisionLog.Enabled && (c.DecisionLog.File == "" || c.DecisionLog.SampleRatio <= 0 || c.DecisionLog.SampleRatio > 1) {
+		panic("decision log is enabled without a file or a sample_ratio between 0 and 1")
+	}
+
+	if c.RouteSnapshot.Enabled && (c.RouteSnapshot.File == "" || c.RouteSnapshot.Interval <= 0) {
+		panic("route snapshot is enabled without a file or a positive interval")
+	}
+
+	if c.RateLimit.Enabled && (c.RateLimit.RequestsPerSecond <= 0 || c.RateLimit.Burst < 1) {
+		panic("rate limit needs a positive requests_per_second and burst")
+	}
+
+	c.ClientLimits.process()
+	c.RegistrationLimits.process()
+	if c.RegistrationBatch.MaxSize < 1 || c.RegistrationBatch.QueueSize < 0 {
+		panic("registration batch needs a positive max_size")
+	}
+	c.processNatsClusters()
+	c.Compression.Zstd.process()
+	c.ForwardedHeaders.process()
+	c.ClientIdentity.process()
+	c.ClientAccess.process()
+	c.Jwt.process()
+	c.Inspection.process()
+	c.AdminApi.process()
+	c.Analytics.process()
+
+	if c.SignedUrls.Key != "" && len(c.SignedUrls.Key) < 16 {
+		panic("signed urls key must be at least 16 bytes")
+	}
+
+	for _, key := range c.StickySessions.Keys {
+		if len(key) < 16 {
+			panic("sticky sessions keys must be at least 16 bytes")
+		}
+	}
+	if c.StickySessions.Encrypt && len(c.StickySessions.Keys) == 0 {
+		panic("sticky sessions encryption needs keys")
+	}
+	if c.StickySessions.RouterAddress != "" {
+		if len(c.StickySessions.Keys) == 0 {
+			panic("sticky sessions router_address needs keys")
+		}
+		if _, _, err := net.SplitHostPort(c.StickySessions.RouterAddress); err != nil {
+			panic("invalid sticky sessions router_address: " + c.StickySessions.RouterAddress)
+		}
+	}
+
+	if c.SessionAffinity.Header != "" && c.SessionAffinity.TTL <= 0 {
+		panic("session affinity needs a positive ttl")
+	}
+
+	if c.PeerFailover.PeerUrl != "" {
+		u, err := url.Parse(c.PeerFailover.PeerUrl)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			panic("invalid peer failover peer_url: " + c.PeerFailover.PeerUrl)
+		}
+		if c.PeerFailover.Name == "" {
+			panic("peer failover needs a name")
+		}
+	}
+
+	for i := range c.OAuth2Proxies {
+		c.OAuth2Proxies[i].process()
+	}
+
+	for i := range c.Mirroring.Routes {
+		m := &c.Mirroring.Routes[i]
+		if m.Route == "" || m.Shadow == "" {
+			panic("mirror needs a route and a shadow")
+		}
+		if strings.EqualFold(m.Route, m.Shadow) {
+			panic("mirror shadow must differ from its route: " + m.Route)
+		}
+		if m.Percent < 0 || m.Percent > 100 {
+			panic(fmt.Sprintf("invalid mirror percent: %d", m.Percent))
+		}
+		if m.Percent == 0 {
+			m.Percent = 100
+		}
+	}
+
+	for _, d := range c.CutoverDomains {
+		if d.Percent < 0 || d.Percent > 100 {
+			panic(fmt.Sprintf("invalid cutover percent for %s: %d", d.Domain, d.Percent))
+		}
+	}
+
+	for host, weight := range c.RequestQueue.Weights {
+		if weight < 1 {
+			panic(fmt.Sprintf("invalid request queue weight for %s: %d", host, weight))
+		}
+	}
+
+	for _, r := range c.HeaderRules {
+		r.Request.process()
+		r.Response.process()
+	}
+
+	c.HttpParsing.process()
+	c.HealthDetail.process()
+	c.Healthz.process()
+	c.CertificateExpiry.process()
+
+	for name, policy := range c.DuplicateHeaders {
+		switch policy {
+		case DuplicateMerge, DuplicateFirst, DuplicateReject:
+		default:
+			panic(fmt.Sprintf("invalid duplicate header policy for %s: %s", name, policy))
+		}
+	}
+
+	for _, e := range c.ErrorPages {
+		if e.Status < 400 || e.Status > 599 {
+			panic(fmt.Sprintf("invalid error page status: %d", e.Status))
+		}
+		if e.HtmlFile == "" && e.JsonFile == "" {
+			panic(fmt.Sprintf("error page needs an html_file or json_file: %d", e.Status))
+		}
+	}
+
+	for _, e := range c.MonitoringExclusions {
+		if len(e.Paths) == 0 {
+			panic("monitoring exclusion needs paths")
+		}
+		e.process()
+	}
+
+	for i := range c.Banners {
+		c.Banners[i].process()
+	}
+
+	for _, limit := range []LimitConfig{
+		c.Limits.Routes,
+		c.Limits.Connections,
+		c.Limits.RequestHeaderBytes,
+		c.Limits.ResponseHeaderBytes,
+		c.Limits.ResponseHeaderCount,
+		c.Limits.EndpointInFlight,
+		c.Limits.RequestBodyBytes,
+	} {
+		if limit.Mode != "" && limit.Mode != LimitModeEnforce && limit.Mode != LimitModeWarn {
+			panic("invalid limit mode: " + limit.Mode)
+		}
+	}
+
 	if c.EnableSSL {
 		c.CipherSuites = c.processCipherSuites()
 		cert, err := tls.LoadX509KeyPair(c.SSLCertPath, c.SSLKeyPath)
@@ -174,6 +1516,414 @@ func (c *Config) Process() {
 		}
 		c.SSLCertificate = cert
 	}
+
+	if len(c.TlsCertificates) > 0 && !c.EnableSSL {
+		panic("tls_certificates need enable_ssl")
+	}
+	for i := range c.TlsCertificates {
+		c.TlsCertificates[i].process()
+	}
+}
+
+func (c *TlsCertificateConfig) process() {
+	if len(c.Domains) == 0 || c.CertPath == "" || c.KeyPath == "" {
+		panic("tls certificates need domains, a cert_path and a key_path")
+	}
+	for i, domain := range c.Domains {
+		c.Domains[i] = strings.ToLower(domain)
+	}
+}
+
+func (o *OAuth2ProxyConfig) process() {
+	if o.Domain == "" || o.ClientId == "" || o.AuthorizeUrl == "" || o.TokenUrl == "" {
+		panic("oauth2 proxy needs a domain, client_id, authorize_url and token_url")
+	}
+	if len(o.CookieSecret) < 16 {
+		panic("oauth2 proxy cookie_secret must be at least 16 bytes: " + o.Domain)
+	}
+
+	if o.CookieName == "" {
+		o.CookieName = "_gorouter_oauth2"
+	}
+	if len(o.Scopes) == 0 {
+		o.Scopes = []string{"openid", "email", "profile"}
+	}
+	if o.SessionLifetimeInSeconds == 0 {
+		o.SessionLifetimeInSeconds = 12 * 60 * 60
+	}
+	o.SessionLifetime = time.Duration(o.SessionLifetimeInSeconds) * time.Second
+}
+
+func (a HeaderActionsConfig) process() {
+	for _, values := range []map[string]string{a.Set, a.Add} {
+		for name, value := range values {
+			if name == "" || !validHeaderRuleValue(value) {
+				panic("invalid header rule: " + name + ": " + value)
+			}
+		}
+	}
+}
+
+func validHeaderRuleValue(value string) bool {
+	for {
+		i := strings.Index(value, "${")
+		if i == -1 {
+			return true
+		}
+		value = value[i+2:]
+
+		j := strings.Index(value, "}")
+		if j == -1 {
+			return false
+		}
+
+		known := false
+		for _, v := range HeaderRuleVariables {
+			if value[:j] == v {
+				known = true
+			}
+		}
+		if !known {
+			return false
+		}
+		value = value[j+1:]
+	}
+}
+
+func (c *ClientLimitsConfig) process() {
+	if c.RateLimit.Enabled && (c.RateLimit.RequestsPerSecond <= 0 || c.RateLimit.Burst < 1) {
+		panic("client rate limit needs a positive requests_per_second and burst")
+	}
+
+	c.AllowedNetworks = parseNetworks(c.Allowlist, "client limits allowlist")
+}
+
+func (c *RegistrationLimitsConfig) process() {
+	if c.Default.Enabled && (c.Default.RequestsPerSecond <= 0 || c.Default.Burst < 1) {
+		panic("registration limits need a positive requests_per_second and burst")
+	}
+	for source, l := range c.Sources {
+		if l.Enabled && (l.RequestsPerSecond <= 0 || l.Burst < 1) {
+			panic("registration limits of " + source + " need a positive requests_per_second and burst")
+		}
+	}
+}
+
+func (c *Config) processNatsClusters() {
+	names := make(map[string]bool)
+	for _, cluster := range c.NatsClusters {
+		if cluster.Name == "" || names[cluster.Name] {
+			panic("nats clusters need distinct names")
+		}
+		if len(cluster.Servers) == 0 {
+			panic("nats cluster " + cluster.Name + " has no servers")
+		}
+		names[cluster.Name] = true
+	}
+
+	for _, cluster := range c.ConfiguredNatsClusters() {
+		if cluster.CredentialsPath != "" && cluster.NkeySeedPath != "" {
+			panic("nats cluster " + cluster.Name + " takes either a credentials file or an nkey seed")
+		}
+		if (cluster.TLS.CertPath == "") != (cluster.TLS.KeyPath == "") {
+			panic("nats cluster " + cluster.Name + " needs both a tls cert_path and key_path")
+		}
+	}
+}
+
+func (c *ForwardedHeadersConfig) process() {
+	switch c.Policy {
+	case ForwardedHeadersAppend, ForwardedHeadersReplace:
+	case ForwardedHeadersTrusted:
+		if len(c.TrustedProxies) == 0 {
+			panic("forwarded headers policy trusted needs trusted_proxies")
+		}
+	default:
+		panic("invalid forwarded headers policy: " + c.Policy)
+	}
+
+	c.TrustedNetworks = parseNetworks(c.TrustedProxies, "forwarded headers trusted_proxies")
+}
+
+func (c *HttpParsingConfig) process() {
+	checkHttpParsingModes(c.ObsoleteLineFolding, c.InvalidHeaderCharacters, false)
+	for _, r := range c.Routes {
+		if len(r.Routes) == 0 {
+			panic("http parsing routes need routes")
+		}
+		checkHttpParsingModes(r.ObsoleteLineFolding, r.InvalidHeaderCharacters, true)
+	}
+}
+
+func (c *HealthDetailConfig) process() {
+	for name, severity := range c.Severities {
+		switch severity {
+		case SeverityCritical, SeverityWarning, SeverityIgnore:
+		default:
+			panic(fmt.Sprintf("invalid health detail severity for %s: %s", name, severity))
+		}
+	}
+
+	c.RegistryStaleAfter = time.Duration(c.RegistryStaleAfterInSeconds) * time.Second
+	c.RoutingApiStaleAfter = time.Duration(c.RoutingApiStaleAfterInSeconds) * time.Second
+}
+
+func (c *HealthzConfig) process() {
+	if c.NatsCheckIntervalInSeconds <= 0 {
+		panic("healthz needs a positive nats_check_interval")
+	}
+
+	c.NatsCheckInterval = time.Duration(c.NatsCheckIntervalInSeconds) * time.Second
+	c.NatsUnreachableAfter = time.Duration(c.NatsUnreachableAfterInSeconds) * time.Second
+}
+
+func (c *CertificateExpiryConfig) process() {
+	if c.IntervalInSeconds <= 0 || c.DegradeReadinessDays < 0 {
+		panic("certificate expiry needs a positive interval and degrade_readiness_days that are not negative")
+	}
+	if len(c.WarningDays) == 0 {
+		c.WarningDays = append([]int(nil), defaultCertificateWarningDays...)
+	}
+	for _, days := range c.WarningDays {
+		if days <= 0 {
+			panic(fmt.Sprintf("invalid certificate expiry warning_days: %d", days))
+		}
+	}
+
+	// the largest comes first, so that warnings escalate as they go
+	sort.Sort(sort.Reverse(sort.IntSlice(c.WarningDays)))
+
+	c.Interval = time.Duration(c.IntervalInSeconds) * time.Second
+}
+
+func checkHttpParsingModes(folding, invalid string, inherit bool) {
+	if !(folding == HttpParsingAccept || folding == HttpParsingReject || inherit && folding == "") {
+		panic("invalid http parsing obsolete_line_folding: " + folding)
+	}
+	if !(invalid == HttpParsingReject || invalid == HttpParsingReplace || inherit && invalid == "") {
+		panic("invalid http parsing invalid_header_characters: " + invalid)
+	}
+}
+
+func (c *AdminApiConfig) process() {
+	if c.Port == 0 {
+		return
+	}
+	if len(c.Tokens) == 0 && c.ClientCAPath == "" {
+		panic("admin api needs tokens or a client_ca_path")
+	}
+	for _, token := range c.Tokens {
+		if len(token) < 16 {
+			panic("admin api tokens must be at least 16 bytes")
+		}
+	}
+
+	if c.CertPath != "" || c.KeyPath != "" {
+		cert, err := tls.LoadX509KeyPair(c.CertPath, c.KeyPath)
+		if err != nil {
+			panic(err)
+		}
+		c.Certificate = &cert
+	}
+
+	if c.ClientCAPath != "" {
+		if c.Certificate == nil {
+			panic("admin api needs a cert_path and key_path to verify client certificates")
+		}
+		b, err := ioutil.ReadFile(c.ClientCAPath)
+		if err != nil {
+			panic(err)
+		}
+		c.ClientCAs = x509.NewCertPool()
+		if !c.ClientCAs.AppendCertsFromPEM(b) {
+			panic("invalid admin api client_ca_path: " + c.ClientCAPath)
+		}
+	}
+}
+
+func (c *ClientAccessConfig) process() {
+	c.AllowedNetworks = parseNetworks(c.AllowedCidrs, "client access allowed_cidrs")
+	c.DeniedNetworks = parseNetworks(c.DeniedCidrs, "client access denied_cidrs")
+}
+
+func (c *InspectionConfig) process() {
+	if !c.Enabled {
+		return
+	}
+	if len(c.DenyPatterns) == 0 {
+		panic("inspection needs deny_patterns")
+	}
+
+	c.DenyRegexps = nil
+	for _, pattern := range c.DenyPatterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			panic("invalid inspection deny pattern: " + pattern)
+		}
+		c.DenyRegexps = append(c.DenyRegexps, re)
+	}
+
+	if c.MaxBodyBytes == 0 {
+		c.MaxBodyBytes = 1024 * 1024
+	}
+	if c.MaxDecodedBytes == 0 {
+		c.MaxDecodedBytes = 8 * 1024 * 1024
+	}
+	if c.MaxRatio == 0 {
+		c.MaxRatio = 100
+	}
+	if c.MaxBodyBytes < 0 || c.MaxDecodedBytes < 0 || c.MaxRatio < 0 {
+		panic("inspection limits must be positive")
+	}
+}
+
+func (c *JwtConfig) process() {
+	if c.JwksUrl == "" {
+		return
+	}
+
+	u, err := url.Parse(c.JwksUrl)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		panic("invalid jwt jwks_url: " + c.JwksUrl)
+	}
+	if c.Issuer == "" || c.Audience == "" {
+		panic("jwt needs an issuer and an audience")
+	}
+	for claim, header := range c.ClaimHeaders {
+		if claim == "" || header == "" {
+			panic("jwt claim_headers needs a claim and a header name")
+		}
+	}
+
+	if c.JwksRefreshIntervalInSeconds == 0 {
+		c.JwksRefreshIntervalInSeconds = 5 * 60
+	}
+	c.JwksRefreshInterval = time.Duration(c.JwksRefreshIntervalInSeconds) * time.Second
+}
+
+func (c *ClientIdentityConfig) process() {
+	if len(c.Sources) == 0 {
+		return
+	}
+	if c.Header == "" || c.SourceHeader == "" {
+		panic("client identity needs a header and a source_header")
+	}
+
+	for i := range c.Sources {
+		s := &c.Sources[i]
+		switch s.Type {
+		case ClientIdentityAwsAlbOidc:
+		case ClientIdentityXfcc:
+			if s.Header == "" {
+				s.Header = "X-Forwarded-Client-Cert"
+			}
+		case ClientIdentityHeader:
+			if s.Header == "" {
+				panic("client identity source of type header needs a header")
+			}
+		default:
+			panic("invalid client identity source type: " + s.Type)
+		}
+
+		if len(s.TrustedProxies) == 0 {
+			panic("client identity source " + s.Type + " needs trusted_proxies")
+		}
+		s.TrustedNetworks = parseNetworks(s.TrustedProxies, "client identity trusted_proxies")
+	}
+}
+
+func (c *AnalyticsConfig) process() {
+	if len(c.Rules) == 0 {
+		return
+	}
+
+	u, err := url.Parse(c.Url)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		panic("invalid analytics url: " + c.Url)
+	}
+	if c.BatchSize < 1 || c.FlushInterval <= 0 {
+		panic("analytics needs a positive batch_size and flush_interval")
+	}
+
+	for _, r := range c.Rules {
+		if r.Event == "" {
+			panic("analytics rule needs an event")
+		}
+		if r.Path != "" && !strings.HasPrefix(r.Path, "/") {
+			panic("invalid analytics rule path: " + r.Path)
+		}
+	}
+}
+
+func (c *ZstdConfig) process() {
+	switch c.Level {
+	case ZstdLevelFastest, ZstdLevelDefault, ZstdLevelBetter:
+	default:
+		panic("invalid zstd level: " + c.Level)
+	}
+
+	c.Dictionaries = nil
+	for _, file := range c.DictionaryFiles {
+		b, err := ioutil.ReadFile(file)
+		if err != nil || len(b) == 0 {
+			panic("invalid zstd dictionary: " + file)
+		}
+		c.Dictionaries = append(c.Dictionaries, b)
+	}
+}
+
+func (c RequestFilterConfig) process() {
+	for _, p := range c.Paths {
+		if _, err := path.Match(p, ""); err != nil {
+			panic("invalid request filter path: " + p)
+		}
+	}
+}
+
+func (c *BannerConfig) process() {
+	if len(c.Domains) == 0 || c.Html == "" {
+		panic("banner needs domains and html")
+	}
+
+	var err error
+	if c.StartsAt != "" {
+		c.Starts, err = time.Parse(time.RFC3339, c.StartsAt)
+		if err != nil {
+			panic("invalid banner starts_at: " + c.StartsAt)
+		}
+	}
+	if c.EndsAt != "" {
+		c.Ends, err = time.Parse(time.RFC3339, c.EndsAt)
+		if err != nil {
+			panic("invalid banner ends_at: " + c.EndsAt)
+		}
+	}
+	if !c.Starts.IsZero() && !c.Ends.IsZero() && !c.Ends.After(c.Starts) {
+		panic("banner ends_at must be after starts_at")
+	}
+}
+
+// parseNetworks parses a list of IP addresses and CIDR ranges, taking an
+// address for the range of just itself.
+func parseNetworks(entries []string, what string) []*net.IPNet {
+	var networks []*net.IPNet
+	for _, entry := range entries {
+		a := entry
+		if !strings.Contains(a, "/") {
+			if ip := net.ParseIP(a); ip != nil && ip.To4() != nil {
+				a += "/32"
+			} else {
+				a += "/128"
+			}
+		}
+
+		_, network, err := net.ParseCIDR(a)
+		if err != nil {
+			panic("invalid " + what + " entry: " + entry)
+		}
+		networks = append(networks, network)
+	}
+	return networks
 }
 
 func (c *Config) processCipherSuites() []uint16 {
@@ -211,8 +1961,27 @@ func (c *Config) processCipherSuites() []uint16 {
 }
 
 func (c *Config) NatsServers() []string {
+	return natsServerURLs(c.Nats)
+}
+
+// ConfiguredNatsClusters returns the NATS clusters to connect to: those of
+// nats_clusters, or else a single cluster named default of the nats servers.
+func (c *Config) ConfiguredNatsClusters() []NatsClusterConfig {
+	if len(c.NatsClusters) > 0 {
+		return c.NatsClusters
+	}
+	return []NatsClusterConfig{{
+		Name:            "default",
+		Servers:         c.Nats,
+		TLS:             c.NatsTLS,
+		CredentialsPath: c.NatsCredentialsPath,
+		NkeySeedPath:    c.NatsNkeySeedPath,
+	}}
+}
+
+func natsServerURLs(servers []NatsConfig) []string {
 	var natsServers []string
-	for _, info := range c.Nats {
+	for _, info := range servers {
 		uri := url.URL{
 			Scheme: "nats",
 			User:   url.UserPassword(info.User, info.Pass),
@@ -233,6 +2002,20 @@ func (c *Config) Initialize(configYAML []byte) error {
 	return candiedyaml.Unmarshal(configYAML, &c)
 }
 
+// LoadConfigFromFile is InitConfigFromFile for a router that is already
+// serving, which cannot be brought down by a bad file: the errors it would
+// panic on are returned instead.
+func LoadConfigFromFile(path string) (c *Config, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			c = nil
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+
+	return InitConfigFromFile(path), nil
+}
+
 func InitConfigFromFile(path string) *Config {
 	var c *Config = DefaultConfig()
 	var e error
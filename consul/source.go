@@ -0,0 +1,214 @@
+package consul
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	steno "github.com/cloudfoundry/gosteno"
+
+	"github.com/cloudfoundry/gorouter/config"
+	"github.com/cloudfoundry/gorouter/route"
+)
+
+// Registry is where the source registers the routes of the catalog.
+type Registry interface {
+	Register(uri route.Uri, endpoint *route.Endpoint)
+	Unregister(uri route.Uri, endpoint *route.Endpoint)
+}
+
+// Source routes to the services of a Consul catalog. Every service instance
+// that passes its checks is registered for the hostnames its tags map to,
+// next to whatever NATS registers for the same hostnames, and is unregistered
+// once it leaves the catalog, fails its checks or loses its tags.
+type Source struct {
+	registry Registry
+	client   *http.Client
+	logger   *steno.Logger
+
+	address    string
+	token      string
+	datacenter string
+	tagPrefix  string
+	tagRoutes  map[string]string
+	interval   time.Duration
+
+	registered map[string]registration
+	stopCh     chan struct{}
+}
+
+type registration struct {
+	uri      route.Uri
+	endpoint *route.Endpoint
+}
+
+type serviceEntry struct {
+	Node struct {
+		Address string
+	}
+	Service struct {
+		ID      string
+		Service string
+		Tags    []string
+		Address string
+		Port    uint16
+	}
+}
+
+func NewSource(registry Registry, c config.ConsulConfig) *Source {
+	return &Source{
+		registry: registry,
+		client:   &http.Client{Timeout: c.Timeout},
+		logger:   steno.NewLogger("router.consul"),
+
+		address:    strings.TrimSuffix(c.Address, "/"),
+		token:      c.Token,
+		datacenter: c.Datacenter,
+		tagPrefix:  c.TagPrefix,
+		tagRoutes:  c.TagRoutes,
+		interval:   c.Interval,
+
+		registered: make(map[string]registration),
+		stopCh:     make(chan struct{}),
+	}
+}
+
+// Run syncs the routes right away and then every interval until Stop is
+// called.
+func (s *Source) Run() {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		err := s.Sync()
+		if err != nil {
+			s.logger.Warnf("consul.sync.failed: %s", err)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-s.stopCh:
+			return
+		}
+	}
+}
+
+func (s *Source) Stop() {
+	close(s.stopCh)
+}
+
+// Sync reads the catalog once, registers the routes it has and unregisters
+// those it no longer has. The routes that were registered before are left
+// alone when the catalog cannot be read, and go stale if it stays that way.
+// It is not safe to call concurrently.
+func (s *Source) Sync() error {
+	var services map[string][]string
+	err := s.get("/v1/catalog/services", &services)
+	if err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(services))
+	for name, tags := range services {
+		if s.routed(name, tags) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	desired := make(map[string]registration)
+	for _, name := range names {
+		var entries []serviceEntry
+		err := s.get("/v1/health/service/"+url.PathEscape(name)+"?passing=1", &entries)
+		if err != nil {
+			return err
+		}
+
+		for _, entry := range entries {
+			address := entry.Service.Address
+			if address == "" {
+				address = entry.Node.Address
+			}
+
+			for _, hostname := range s.hostnames(name, entry.Service.Tags) {
+				endpoint := route.NewEndpoint(name, address, entry.Service.Port, entry.Service.ID, nil, 0)
+				uri := route.Uri(hostname)
+				desired[string(uri.ToLower())+" "+endpoint.CanonicalAddr()] = registration{uri: uri, endpoint: endpoint}
+			}
+		}
+	}
+
+	for key, r := range s.registered {
+		if _, ok := desired[key]; !ok {
+			s.registry.Unregister(r.uri, r.endpoint)
+		}
+	}
+
+	// registering again what is still there keeps it from going stale
+	for _, r := range desired {
+		s.registry.Register(r.uri, r.endpoint)
+	}
+
+	s.registered = desired
+
+	return nil
+}
+
+func (s *Source) routed(name string, tags []string) bool {
+	return len(s.hostnames(name, tags)) > 0
+}
+
+// hostnames maps the tags of a service instance to the hostnames it is
+// routed for.
+func (s *Source) hostnames(name string, tags []string) []string {
+	var hostnames []string
+	for _, tag := range tags {
+		hostname, ok := s.tagRoutes[tag]
+		if !ok && s.tagPrefix != "" && strings.HasPrefix(tag, s.tagPrefix) {
+			hostname, ok = strings.TrimPrefix(tag, s.tagPrefix), true
+		}
+		if !ok || hostname == "" {
+			continue
+		}
+
+		hostnames = append(hostnames, strings.Replace(hostname, "${service}", name, -1))
+	}
+	return hostnames
+}
+
+func (s *Source) get(path string, v interface{}) error {
+	if s.datacenter != "" {
+		separator := "?"
+		if strings.Contains(path, "?") {
+			separator = "&"
+		}
+		path += separator + "dc=" + url.QueryEscape(s.datacenter)
+	}
+
+	request, err := http.NewRequest("GET", s.address+path, nil)
+	if err != nil {
+		return err
+	}
+	if s.token != "" {
+		request.Header.Set("X-Consul-Token", s.token)
+	}
+
+	res, err := s.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		io.Copy(ioutil.Discard, res.Body)
+		return fmt.Errorf("consul answered %s with %d", path, res.StatusCode)
+	}
+
+	return json.NewDecoder(res.Body).Decode(v)
+}
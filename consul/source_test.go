@@ -0,0 +1,198 @@
+package consul_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"sync"
+
+	"github.com/cloudfoundry/gorouter/config"
+	"github.com/cloudfoundry/gorouter/consul"
+	"github.com/cloudfoundry/gorouter/route"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type fakeRegistry struct {
+	lock   sync.Mutex
+	routes map[string]int
+}
+
+func (f *fakeRegistry) Register(uri route.Uri, endpoint *route.Endpoint) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.routes[string(uri)+" "+endpoint.CanonicalAddr()]++
+}
+
+func (f *fakeRegistry) Unregister(uri route.Uri, endpoint *route.Endpoint) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	delete(f.routes, string(uri)+" "+endpoint.CanonicalAddr())
+}
+
+func (f *fakeRegistry) Routes() []string {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	var routes []string
+	for r := range f.routes {
+		routes = append(routes, r)
+	}
+	sort.Strings(routes)
+	return routes
+}
+
+type instance struct {
+	Node    map[string]string
+	Service map[string]interface{}
+}
+
+var _ = Describe("Source", func() {
+	var registry *fakeRegistry
+	var source *consul.Source
+	var server *httptest.Server
+	var c config.ConsulConfig
+	var lock sync.Mutex
+	var catalog map[string][]instance
+	var requests []*http.Request
+
+	newInstance := func(id, address string, port int, tags ...string) instance {
+		return instance{
+			Node:    map[string]string{"Address": "10.0.0.1"},
+			Service: map[string]interface{}{"ID": id, "Address": address, "Port": port, "Tags": tags},
+		}
+	}
+
+	setCatalog := func(services map[string][]instance) {
+		lock.Lock()
+		defer lock.Unlock()
+		catalog = services
+	}
+
+	BeforeEach(func() {
+		registry = &fakeRegistry{routes: make(map[string]int)}
+		requests = nil
+		catalog = nil
+
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			lock.Lock()
+			defer lock.Unlock()
+			requests = append(requests, r)
+
+			if r.URL.Path == "/v1/catalog/services" {
+				services := make(map[string][]string)
+				for name, instances := range catalog {
+					services[name] = []string{}
+					for _, i := range instances {
+						services[name] = append(services[name], i.Service["Tags"].([]string)...)
+					}
+				}
+				json.NewEncoder(w).Encode(services)
+				return
+			}
+
+			name := r.URL.Path[len("/v1/health/service/"):]
+			instances, ok := catalog[name]
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			json.NewEncoder(w).Encode(instances)
+		}))
+
+		c = config.DefaultConfig().Consul
+		c.Address = server.URL
+		c.TagRoutes = map[string]string{"web": "${service}.apps.internal"}
+	})
+
+	JustBeforeEach(func() {
+		source = consul.NewSource(registry, c)
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("registers the instances of services with routing tags", func() {
+		setCatalog(map[string][]instance{
+			"billing": {
+				newInstance("billing-1", "10.0.1.1", 8080, "gorouter.route=billing.example.com", "web"),
+				newInstance("billing-2", "", 8081, "web"),
+			},
+			"db": {newInstance("db-1", "10.0.2.1", 5432, "primary")},
+		})
+
+		Ω(source.Sync()).To(Succeed())
+
+		Ω(registry.Routes()).To(Equal([]string{
+			"billing.apps.internal 10.0.0.1:8081",
+			"billing.apps.internal 10.0.1.1:8080",
+			"billing.example.com 10.0.1.1:8080",
+		}))
+
+		for _, r := range requests {
+			Ω(r.URL.Path).NotTo(ContainSubstring("db"))
+		}
+	})
+
+	It("asks only for instances that pass their checks", func() {
+		setCatalog(map[string][]instance{
+			"billing": {newInstance("billing-1", "10.0.1.1", 8080, "web")},
+		})
+
+		Ω(source.Sync()).To(Succeed())
+
+		Ω(requests[len(requests)-1].URL.Query().Get("passing")).To(Equal("1"))
+	})
+
+	It("registers the routes again on every sync and unregisters those that are gone", func() {
+		setCatalog(map[string][]instance{
+			"billing": {
+				newInstance("billing-1", "10.0.1.1", 8080, "web"),
+				newInstance("billing-2", "10.0.1.2", 8080, "web"),
+			},
+		})
+		Ω(source.Sync()).To(Succeed())
+
+		setCatalog(map[string][]instance{
+			"billing": {newInstance("billing-1", "10.0.1.1", 8080, "web")},
+		})
+		Ω(source.Sync()).To(Succeed())
+
+		Ω(registry.Routes()).To(Equal([]string{"billing.apps.internal 10.0.1.1:8080"}))
+		Ω(registry.routes["billing.apps.internal 10.0.1.1:8080"]).To(Equal(2))
+	})
+
+	It("keeps the routes it has when the catalog cannot be read", func() {
+		setCatalog(map[string][]instance{
+			"billing": {newInstance("billing-1", "10.0.1.1", 8080, "web")},
+		})
+		Ω(source.Sync()).To(Succeed())
+
+		server.Close()
+
+		Ω(source.Sync()).NotTo(Succeed())
+		Ω(registry.Routes()).To(Equal([]string{"billing.apps.internal 10.0.1.1:8080"}))
+	})
+
+	Context("with a token and a datacenter", func() {
+		BeforeEach(func() {
+			c.Token = "secret"
+			c.Datacenter = "dc2"
+		})
+
+		It("passes them to consul", func() {
+			setCatalog(map[string][]instance{
+				"billing": {newInstance("billing-1", "10.0.1.1", 8080, "web")},
+			})
+
+			Ω(source.Sync()).To(Succeed())
+
+			Ω(requests).To(HaveLen(2))
+			for _, r := range requests {
+				Ω(r.Header.Get("X-Consul-Token")).To(Equal("secret"))
+				Ω(r.URL.Query().Get("dc")).To(Equal("dc2"))
+			}
+		})
+	})
+})
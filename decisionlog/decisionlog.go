@@ -0,0 +1,265 @@
+// Package decisionlog records, for a sample of requests, how the router
+// decided what to do with them: the route they matched, the policies that
+// were applied to them and what came of each, how they were authorized, and
+// which endpoints they were sent to and why. Unlike the access log, which
+// tells what happened to every request, the decision log is meant for
+// compliance review, and its records follow a schema that only changes
+// along with their version.
+package decisionlog
+
+import (
+	"encoding/json"
+	"math/rand"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/cloudfoundry/gorouter/common/correlation"
+	"github.com/cloudfoundry/gorouter/config"
+	"github.com/cloudfoundry/gorouter/route"
+	steno "github.com/cloudfoundry/gosteno"
+)
+
+// Version is the version of the schema of the records.
+const Version = 1
+
+// The outcomes of policies and authorizations.
+const (
+	Allowed   = "allowed"
+	Denied    = "denied"
+	Answered  = "answered"
+	Applied   = "applied"
+	Forwarded = "forwarded"
+)
+
+// The reasons an endpoint is picked for a request.
+const (
+	ReasonLoadBalancing = "load_balancing"
+	ReasonStickySession = "sticky_session"
+	ReasonAffinity      = "affinity"
+	ReasonRetry         = "retry"
+)
+
+// A Record is what the log holds of a request, one JSON object per line.
+type Record struct {
+	Version    int       `json:"version"`
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+	RequestId  string    `json:"request_id,omitempty"`
+	Method     string    `json:"method"`
+	Host       string    `json:"host"`
+	Path       string    `json:"path"`
+	ClientIp   string    `json:"client_ip"`
+
+	Route          *Route           `json:"route"`
+	Policies       []Outcome        `json:"policies"`
+	Authorizations []Outcome        `json:"authorizations"`
+	Endpoints      []EndpointChoice `json:"endpoints"`
+	StatusCode     int              `json:"status_code"`
+}
+
+// Route is the route a request matched: its URI, and the headers, cookies
+// or path its endpoints asked for when they did.
+type Route struct {
+	Uri   string       `json:"uri"`
+	Match *route.Match `json:"match,omitempty"`
+}
+
+// Outcome is what came of a policy or authorization.
+type Outcome struct {
+	Name    string `json:"name"`
+	Outcome string `json:"outcome"`
+}
+
+// EndpointChoice is an endpoint a request was sent to, in the order of
+// the attempts, and the reason it was picked.
+type EndpointChoice struct {
+	Address    string `json:"address"`
+	InstanceId string `json:"instance_id,omitempty"`
+	Group      string `json:"group,omitempty"`
+	AppVersion string `json:"app_version,omitempty"`
+	Backup     bool   `json:"backup,omitempty"`
+	Reason     string `json:"reason"`
+}
+
+// A Log writes the records of the requests it samples to its file. A nil
+// Log records nothing.
+type Log struct {
+	lock    sync.Mutex
+	file    *os.File
+	encoder *json.Encoder
+	ratio   float64
+	random  *rand.Rand
+	logger  *steno.Logger
+}
+
+// New opens the log of c, or returns nil when the decision log is disabled.
+func New(c config.DecisionLogConfig) (*Log, error) {
+	if !c.Enabled {
+		return nil, nil
+	}
+
+	// records carry client addresses, so the file is for the router's user
+	// only
+	file, err := os.OpenFile(c.File, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Log{
+		file:    file,
+		encoder: json.NewEncoder(file),
+		ratio:   c.SampleRatio,
+		random:  rand.New(rand.NewSource(time.Now().UnixNano())),
+		logger:  steno.NewLogger("router.decisionlog"),
+	}, nil
+}
+
+// Begin starts the record of request, which started at startedAt, or
+// returns nil when the request is not sampled.
+func (l *Log) Begin(request *http.Request, startedAt time.Time) *Decision {
+	if l == nil || !l.sample() {
+		return nil
+	}
+
+	r := Record{
+		Version:        Version,
+		StartedAt:      startedAt,
+		Method:         request.Method,
+		Host:           request.Host,
+		Path:           request.URL.Path,
+		ClientIp:       request.RemoteAddr,
+		Policies:       []Outcome{},
+		Authorizations: []Outcome{},
+		Endpoints:      []EndpointChoice{},
+	}
+	if host, _, err := net.SplitHostPort(request.RemoteAddr); err == nil {
+		r.ClientIp = host
+	}
+	if id, ok := correlation.FromRequest(request); ok {
+		r.RequestId = id.RequestId
+	}
+
+	return &Decision{record: r}
+}
+
+// Finish writes the record of d, whose request was answered with
+// statusCode at finishedAt.
+func (l *Log) Finish(d *Decision, statusCode int, finishedAt time.Time) {
+	if l == nil || d == nil {
+		return
+	}
+
+	d.record.StatusCode = statusCode
+	d.record.FinishedAt = finishedAt
+
+	l.lock.Lock()
+	err := l.encoder.Encode(d.record)
+	l.lock.Unlock()
+
+	if err != nil {
+		l.logger.Errord(map[string]interface{}{"error": err.Error()}, "decisionlog.write-failed")
+	}
+}
+
+// Close closes the file of the log.
+func (l *Log) Close() error {
+	if l == nil {
+		return nil
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.file.Close()
+}
+
+func (l *Log) sample() bool {
+	if l.ratio >= 1 {
+		return true
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.random.Float64() < l.ratio
+}
+
+// A Decision gathers the record of a request as the router serves it. Its
+// methods are called from the goroutine serving the request. A nil
+// Decision, of a request that is not sampled, records nothing.
+type Decision struct {
+	record Record
+}
+
+// Route records the route the request matched.
+func (d *Decision) Route(uri string, match route.Match) {
+	if d == nil {
+		return
+	}
+
+	d.record.Route = &Route{Uri: uri}
+	if match.Key() != "" {
+		d.record.Route.Match = &match
+	}
+}
+
+// Policy records the outcome of the policy of name.
+func (d *Decision) Policy(name, outcome string) {
+	if d == nil {
+		return
+	}
+	d.record.Policies = append(d.record.Policies, Outcome{Name: name, Outcome: outcome})
+}
+
+// Check records a policy that allowed the request or denied it.
+func (d *Decision) Check(name string, allowed bool) {
+	d.Policy(name, outcomeOf(allowed))
+}
+
+// Authorization records the outcome of the authorization of mechanism.
+func (d *Decision) Authorization(mechanism, outcome string) {
+	if d == nil {
+		return
+	}
+	d.record.Authorizations = append(d.record.Authorizations, Outcome{Name: mechanism, Outcome: outcome})
+}
+
+// Authorize records an authorization that allowed the request or denied
+// it.
+func (d *Decision) Authorize(mechanism string, allowed bool) {
+	d.Authorization(mechanism, outcomeOf(allowed))
+}
+
+// Endpoint records that the request was sent to e. Requests pinned to an
+// endpoint, by the instance id or address pinned, were pinned for the
+// reason pinnedBy; the attempts after the first are retries.
+func (d *Decision) Endpoint(e *route.Endpoint, pinned, pinnedBy string) {
+	if d == nil {
+		return
+	}
+
+	reason := ReasonLoadBalancing
+	switch {
+	case len(d.record.Endpoints) > 0:
+		reason = ReasonRetry
+	case pinned != "" && (pinned == e.PrivateInstanceId || pinned == e.CanonicalAddr()):
+		reason = pinnedBy
+	}
+
+	d.record.Endpoints = append(d.record.Endpoints, EndpointChoice{
+		Address:    e.CanonicalAddr(),
+		InstanceId: e.PrivateInstanceId,
+		Group:      e.Group,
+		AppVersion: e.AppVersion,
+		Backup:     e.Backup,
+		Reason:     reason,
+	})
+}
+
+func outcomeOf(allowed bool) string {
+	if allowed {
+		return Allowed
+	}
+	return Denied
+}
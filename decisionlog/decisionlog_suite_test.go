@@ -0,0 +1,13 @@
+package decisionlog_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestDecisionLog(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Decision Log Suite")
+}
@@ -0,0 +1,160 @@
+package decisionlog_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/cloudfoundry/gorouter/common/correlation"
+	"github.com/cloudfoundry/gorouter/config"
+	"github.com/cloudfoundry/gorouter/decisionlog"
+	"github.com/cloudfoundry/gorouter/route"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Log", func() {
+	var dir string
+	var c config.DecisionLogConfig
+	var request *http.Request
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "decisionlog")
+		Ω(err).NotTo(HaveOccurred())
+
+		c = config.DecisionLogConfig{
+			Enabled:     true,
+			File:        filepath.Join(dir, "decisions.log"),
+			SampleRatio: 1,
+		}
+
+		request, err = http.NewRequest("GET", "http://app.example.com/orders?id=1", nil)
+		Ω(err).NotTo(HaveOccurred())
+		request.RemoteAddr = "10.0.0.1:5000"
+		request = correlation.WithID(request, correlation.ID{RequestId: "request-id"})
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	records := func() []decisionlog.Record {
+		b, err := ioutil.ReadFile(c.File)
+		Ω(err).NotTo(HaveOccurred())
+
+		var rs []decisionlog.Record
+		for _, line := range strings.Split(strings.TrimSpace(string(b)), "\n") {
+			if line == "" {
+				continue
+			}
+			var r decisionlog.Record
+			Ω(json.Unmarshal([]byte(line), &r)).To(Succeed())
+			rs = append(rs, r)
+		}
+		return rs
+	}
+
+	It("is nil when disabled, and records nothing", func() {
+		l, err := decisionlog.New(config.DecisionLogConfig{})
+		Ω(err).NotTo(HaveOccurred())
+		Ω(l).To(BeNil())
+
+		d := l.Begin(request, time.Now())
+		Ω(d).To(BeNil())
+		d.Policy("maintenance", decisionlog.Answered)
+		d.Endpoint(route.NewEndpoint("app", "10.0.0.2", 80, "instance", nil, -1), "", "")
+		l.Finish(d, http.StatusOK, time.Now())
+		Ω(l.Close()).To(Succeed())
+	})
+
+	It("records the decision chain of a request", func() {
+		l, err := decisionlog.New(c)
+		Ω(err).NotTo(HaveOccurred())
+		defer l.Close()
+
+		startedAt := time.Now()
+		d := l.Begin(request, startedAt)
+		d.Check("client_rate_limit", true)
+		d.Route("app.example.com", route.Match{Headers: map[string]string{"X-Canary": "true"}})
+		d.Authorize("signed_url", true)
+		d.Policy("header_rules", decisionlog.Applied)
+
+		pinned := route.NewEndpoint("app", "10.0.0.2", 80, "instance-1", nil, -1)
+		other := route.NewEndpoint("app", "10.0.0.3", 80, "instance-2", nil, -1)
+		d.Endpoint(pinned, "instance-1", decisionlog.ReasonStickySession)
+		d.Endpoint(other, "instance-1", decisionlog.ReasonStickySession)
+		l.Finish(d, http.StatusOK, startedAt.Add(time.Second))
+
+		rs := records()
+		Ω(rs).To(HaveLen(1))
+		r := rs[0]
+		Ω(r.Version).To(Equal(decisionlog.Version))
+		Ω(r.RequestId).To(Equal("request-id"))
+		Ω(r.Method).To(Equal("GET"))
+		Ω(r.Host).To(Equal("app.example.com"))
+		Ω(r.Path).To(Equal("/orders"))
+		Ω(r.ClientIp).To(Equal("10.0.0.1"))
+		Ω(r.Route.Uri).To(Equal("app.example.com"))
+		Ω(r.Route.Match.Headers).To(Equal(map[string]string{"X-Canary": "true"}))
+		Ω(r.Policies).To(Equal([]decisionlog.Outcome{
+			{Name: "client_rate_limit", Outcome: decisionlog.Allowed},
+			{Name: "header_rules", Outcome: decisionlog.Applied},
+		}))
+		Ω(r.Authorizations).To(Equal([]decisionlog.Outcome{
+			{Name: "signed_url", Outcome: decisionlog.Allowed},
+		}))
+		Ω(r.Endpoints).To(HaveLen(2))
+		Ω(r.Endpoints[0].Address).To(Equal("10.0.0.2:80"))
+		Ω(r.Endpoints[0].InstanceId).To(Equal("instance-1"))
+		Ω(r.Endpoints[0].Reason).To(Equal(decisionlog.ReasonStickySession))
+		Ω(r.Endpoints[1].Reason).To(Equal(decisionlog.ReasonRetry))
+		Ω(r.StatusCode).To(Equal(http.StatusOK))
+		Ω(r.FinishedAt.Sub(r.StartedAt)).To(Equal(time.Second))
+	})
+
+	It("picks endpoints by load balancing unless the request is pinned to them", func() {
+		l, err := decisionlog.New(c)
+		Ω(err).NotTo(HaveOccurred())
+		defer l.Close()
+
+		d := l.Begin(request, time.Now())
+		d.Check("request_body_limit", false)
+		d.Endpoint(route.NewEndpoint("app", "10.0.0.2", 80, "instance-1", nil, -1), "10.0.0.3:80", decisionlog.ReasonAffinity)
+		l.Finish(d, http.StatusRequestEntityTooLarge, time.Now())
+
+		d = l.Begin(request, time.Now())
+		d.Endpoint(route.NewEndpoint("app", "10.0.0.3", 80, "instance-2", nil, -1), "10.0.0.3:80", decisionlog.ReasonAffinity)
+		l.Finish(d, http.StatusOK, time.Now())
+
+		rs := records()
+		Ω(rs).To(HaveLen(2))
+		Ω(rs[0].Policies).To(Equal([]decisionlog.Outcome{{Name: "request_body_limit", Outcome: decisionlog.Denied}}))
+		Ω(rs[0].Endpoints[0].Reason).To(Equal(decisionlog.ReasonLoadBalancing))
+		Ω(rs[1].Route).To(BeNil())
+		Ω(rs[1].Endpoints[0].Reason).To(Equal(decisionlog.ReasonAffinity))
+	})
+
+	It("records only a sample of the requests", func() {
+		c.SampleRatio = 0.5
+		l, err := decisionlog.New(c)
+		Ω(err).NotTo(HaveOccurred())
+		defer l.Close()
+
+		sampled := 0
+		for i := 0; i < 1000; i++ {
+			if d := l.Begin(request, time.Now()); d != nil {
+				sampled++
+				l.Finish(d, http.StatusOK, time.Now())
+			}
+		}
+
+		Ω(sampled).To(BeNumerically("~", 500, 100))
+		Ω(records()).To(HaveLen(sampled))
+	})
+})
@@ -0,0 +1,263 @@
+package dryrun
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/cloudfoundry/gorouter/access_log"
+	"github.com/cloudfoundry/gorouter/banner"
+	"github.com/cloudfoundry/gorouter/clock"
+	"github.com/cloudfoundry/gorouter/compression"
+	"github.com/cloudfoundry/gorouter/config"
+	"github.com/cloudfoundry/gorouter/errorpages"
+	"github.com/cloudfoundry/gorouter/headerrules"
+	"github.com/cloudfoundry/gorouter/limits"
+	"github.com/cloudfoundry/gorouter/proxy"
+	"github.com/cloudfoundry/gorouter/registry"
+	"github.com/cloudfoundry/gorouter/route"
+	"github.com/cloudfoundry/gorouter/signedurl"
+	"github.com/cloudfoundry/gorouter/varz"
+)
+
+// Kinds of changes in the outcome of a request.
+const (
+	// Rejected requests reached a backend, and would now be answered by
+	// the router.
+	Rejected = "rejection"
+	// Admitted requests were answered by the router, and would now reach
+	// a backend.
+	Admitted = "admission"
+	// Policy changes answer a request with another status, or have the
+	// router give another reason for answering it.
+	Policy = "policy"
+)
+
+// An Outcome is how a request is answered.
+type Outcome struct {
+	Status      int    `json:"status"`
+	RouterError string `json:"router_error,omitempty"`
+}
+
+// A Change is a request whose outcome would change.
+type Change struct {
+	Method string  `json:"method"`
+	Host   string  `json:"host"`
+	URI    string  `json:"uri"`
+	Kind   string  `json:"kind"`
+	Before Outcome `json:"before"`
+	After  Outcome `json:"after"`
+}
+
+// Replay sends the requests of sample, one at a time, through a proxy
+// configured by c, and returns those whose outcome differs from the
+// recorded one. Every host of the sample is routed to a backend that
+// answers as the recorded backend did, save hosts that had no route.
+//
+// The proxy leaves out what reaches beyond the router, peers, mirrors,
+// OAuth2 providers and analytics, and what depends on when requests arrive
+// or where from, rate limits and the request queue; exchanges that were
+// hijacked, by WebSockets for instance, are passed over.
+func Replay(c *config.Config, sample []Exchange) ([]Change, error) {
+	b := &backend{}
+	backendListener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	defer backendListener.Close()
+	go http.Serve(backendListener, b)
+
+	host, port, err := net.SplitHostPort(backendListener.Addr().String())
+	if err != nil {
+		return nil, err
+	}
+	p, err := strconv.Atoi(port)
+	if err != nil {
+		return nil, err
+	}
+
+	r := registry.NewRouteRegistry(c, nil)
+	for _, x := range sample {
+		if x.Response.routerError() != "unknown_route" {
+			r.Register(route.Uri(hostWithoutPort(x.Request.Host)), route.NewEndpoint("", host, uint16(p), "", nil, -1))
+		}
+	}
+
+	router, err := newProxy(c, r)
+	if err != nil {
+		return nil, err
+	}
+	routerListener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	defer routerListener.Close()
+	go http.Serve(routerListener, router)
+
+	client := &http.Client{
+		Transport: &http.Transport{DisableCompression: true},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	var changes []Change
+	for _, x := range sample {
+		if x.Hijacked {
+			continue
+		}
+
+		b.answer(x.Response)
+		after, err := send(client, "http://"+routerListener.Addr().String(), x.Request)
+		if err != nil {
+			return nil, err
+		}
+
+		before := Outcome{Status: x.Response.StatusCode, RouterError: x.Response.routerError()}
+		if kind := compare(before, after); kind != "" {
+			changes = append(changes, Change{
+				Method: x.Request.Method,
+				Host:   x.Request.Host,
+				URI:    x.Request.URI,
+				Kind:   kind,
+				Before: before,
+				After:  after,
+			})
+		}
+	}
+	return changes, nil
+}
+
+func newProxy(c *config.Config, r *registry.RouteRegistry) (proxy.Proxy, error) {
+	args := proxy.ProxyArgs{
+		EndpointTimeout: c.EndpointTimeout,
+		Ip:              c.Ip,
+		TraceKey:        c.TraceKey,
+		Registry:        r,
+		Reporter:        varz.NewVarz(r),
+		AccessLogger:    &access_log.NullAccessLogger{},
+		SecureCookies:   c.SecureCookies,
+
+		TrustForwardedHeaders: c.ForwardedHeaders.Policy == config.ForwardedHeadersAppend,
+		TrustedProxies:        c.ForwardedHeaders.TrustedNetworks,
+
+		RequestHeaderLimit:       limits.New("request_header_bytes", c.Limits.RequestHeaderBytes),
+		RequestBodyLimit:         limits.New("request_body_bytes", c.Limits.RequestBodyBytes),
+		ResponseHeaderBytesLimit: limits.New("response_header_bytes", c.Limits.ResponseHeaderBytes),
+		ResponseHeaderCountLimit: limits.New("response_header_count", c.Limits.ResponseHeaderCount),
+		StripResponseHeaders:     c.StripResponseHeaders,
+
+		SignedUrls:  signedurl.NewVerifier(c.SignedUrls, clock.New()),
+		Compression: compression.New(c.Compression),
+		HeaderRules: headerrules.New(c.HeaderRules),
+		Duplicates:  headerrules.NewDuplicates(c.DuplicateHeaders),
+		Banners:     banner.New(c.Banners, clock.New()),
+	}
+
+	var err error
+	args.ErrorPages, err = errorpages.New(c.ErrorPages, c.JsonErrors)
+	if err != nil {
+		return nil, err
+	}
+	return proxy.NewProxy(args), nil
+}
+
+// compare returns the kind of change from before to after, or "" when the
+// request fares the same.
+func compare(before, after Outcome) string {
+	switch {
+	case before == after:
+		return ""
+	case before.RouterError == "" && after.RouterError != "":
+		return Rejected
+	case before.RouterError != "" && after.RouterError == "":
+		return Admitted
+	}
+	return Policy
+}
+
+func send(client *http.Client, router string, m Message) (Outcome, error) {
+	var body io.Reader
+	if b := m.body(); len(b) > 0 {
+		body = bytes.NewReader(b)
+	}
+
+	request, err := http.NewRequest(m.Method, router+m.URI, body)
+	if err != nil {
+		return Outcome{}, err
+	}
+	request.Host = m.Host
+	for name, values := range m.Header {
+		request.Header[name] = values
+	}
+	if _, ok := request.Header["User-Agent"]; !ok {
+		// keeps the client from adding its own
+		request.Header.Set("User-Agent", "")
+	}
+
+	response, err := client.Do(request)
+	if err != nil {
+		return Outcome{}, err
+	}
+	io.Copy(io.Discard, response.Body)
+	response.Body.Close()
+
+	return Outcome{Status: response.StatusCode, RouterError: response.Header.Get("X-Cf-RouterError")}, nil
+}
+
+// unreplayedHeaders are the recorded response headers the backend leaves
+// out: they were set by the router, or describe the body as it was sent
+// rather than as it is replayed.
+var unreplayedHeaders = map[string]bool{
+	"Content-Length":    true,
+	"Content-Encoding":  true,
+	"Transfer-Encoding": true,
+	"Connection":        true,
+	"X-Cf-Routererror":  true,
+	"X-Vcap-Request-Id": true,
+}
+
+// A backend answers every request with the response it is told to, the
+// recorded response of the request being replayed.
+type backend struct {
+	sync.Mutex
+	response Message
+}
+
+// answer has b answer as the backend did in response, or with an empty 200
+// when the router answered in its place or nothing was answered.
+func (b *backend) answer(response Message) {
+	if response.routerError() != "" || response.StatusCode == 0 {
+		response = Message{StatusCode: http.StatusOK}
+	}
+
+	b.Lock()
+	b.response = response
+	b.Unlock()
+}
+
+func (b *backend) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	io.Copy(io.Discard, r.Body)
+
+	b.Lock()
+	response := b.response
+	b.Unlock()
+
+	for name, values := range response.Header {
+		if !unreplayedHeaders[http.CanonicalHeaderKey(name)] {
+			w.Header()[name] = values
+		}
+	}
+	w.WriteHeader(response.StatusCode)
+	w.Write(response.body())
+}
+
+func hostWithoutPort(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		return h
+	}
+	return host
+}
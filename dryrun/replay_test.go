@@ -0,0 +1,63 @@
+package dryrun_test
+
+import (
+	"strings"
+	"time"
+
+	"github.com/cloudfoundry/gorouter/config"
+	"github.com/cloudfoundry/gorouter/dryrun"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+const sample = `{"request": {"method": "GET", "uri": "/", "host": "app.example.com", "header": {"X-Tenant-Id": ["a", "b"]}}, "response": {"status_code": 200, "header": {}}}
+{"request": {"method": "POST", "uri": "/upload", "host": "app.example.com", "header": {}, "body_bytes": 2048}, "response": {"status_code": 413, "header": {"X-Cf-Routererror": ["request_body_too_large"]}}}
+{"request": {"method": "GET", "uri": "/missing", "host": "app.example.com:8080", "header": {}}, "response": {"status_code": 500, "header": {"Content-Type": ["text/plain"]}, "body": "ZmFpbGVk", "body_bytes": 6}}
+{"request": {"method": "GET", "uri": "/", "host": "gone.example.com", "header": {}}, "response": {"status_code": 404, "header": {"X-Cf-Routererror": ["unknown_route"]}}}
+{"request": {"method": "GET", "uri": "/ws", "host": "app.example.com", "header": {}}, "response": {"status_code": 101, "header": {}}, "hijacked": true}
+`
+
+var _ = Describe("Replay", func() {
+	var (
+		c        *config.Config
+		exchange []dryrun.Exchange
+	)
+
+	BeforeEach(func() {
+		c = config.DefaultConfig()
+		c.EndpointTimeout = 5 * time.Second
+
+		var err error
+		exchange, err = dryrun.ReadSample(strings.NewReader(sample))
+		Ω(err).NotTo(HaveOccurred())
+		Ω(exchange).To(HaveLen(5))
+	})
+
+	It("reports the requests whose outcome changes", func() {
+		c.DuplicateHeaders = map[string]string{"X-Tenant-Id": config.DuplicateReject}
+
+		changes, err := dryrun.Replay(c, exchange)
+		Ω(err).NotTo(HaveOccurred())
+		Ω(changes).To(Equal([]dryrun.Change{
+			{
+				Method: "GET", Host: "app.example.com", URI: "/", Kind: dryrun.Rejected,
+				Before: dryrun.Outcome{Status: 200},
+				After:  dryrun.Outcome{Status: 400, RouterError: "duplicate_header"},
+			},
+			{
+				Method: "POST", Host: "app.example.com", URI: "/upload", Kind: dryrun.Admitted,
+				Before: dryrun.Outcome{Status: 413, RouterError: "request_body_too_large"},
+				After:  dryrun.Outcome{Status: 200},
+			},
+		}))
+	})
+
+	It("reports the requests that fare the same as no change", func() {
+		c.Limits.RequestBodyBytes = config.LimitConfig{Max: 1024, Mode: config.LimitModeEnforce}
+
+		changes, err := dryrun.Replay(c, exchange)
+		Ω(err).NotTo(HaveOccurred())
+		Ω(changes).To(BeEmpty())
+	})
+})
@@ -0,0 +1,60 @@
+package dryrun
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+)
+
+// An Exchange is a request of a capture file and the response the client
+// got for it.
+type Exchange struct {
+	Request  Message `json:"request"`
+	Response Message `json:"response"`
+	Hijacked bool    `json:"hijacked,omitempty"`
+}
+
+// A Message is one side of an exchange, as the capture recorded it.
+type Message struct {
+	Method        string      `json:"method,omitempty"`
+	URI           string      `json:"uri,omitempty"`
+	Host          string      `json:"host,omitempty"`
+	StatusCode    int         `json:"status_code,omitempty"`
+	Header        http.Header `json:"header"`
+	Body          []byte      `json:"body,omitempty"`
+	BodyBytes     int64       `json:"body_bytes"`
+	BodyTruncated bool        `json:"body_truncated,omitempty"`
+}
+
+// ReadSample reads the exchanges of a capture file.
+func ReadSample(r io.Reader) ([]Exchange, error) {
+	var sample []Exchange
+
+	decoder := json.NewDecoder(r)
+	for {
+		var x Exchange
+		err := decoder.Decode(&x)
+		if err == io.EOF {
+			return sample, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		sample = append(sample, x)
+	}
+}
+
+// routerError is the reason the router gave for answering a request itself,
+// or "" when a backend answered it.
+func (m Message) routerError() string {
+	return m.Header.Get("X-Cf-RouterError")
+}
+
+// body is the recorded body, or as many bytes as the body had when it was
+// not recorded in full, so that body limits still apply.
+func (m Message) body() []byte {
+	if m.Body != nil && !m.BodyTruncated {
+		return m.Body
+	}
+	return make([]byte, m.BodyBytes)
+}
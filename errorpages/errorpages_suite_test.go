@@ -0,0 +1,13 @@
+package errorpages_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestErrorPages(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "ErrorPages Suite")
+}
@@ -0,0 +1,237 @@
+package errorpages
+
+import (
+	"bytes"
+	"encoding/json"
+	htmltemplate "html/template"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	texttemplate "text/template"
+
+	steno "github.com/cloudfoundry/gosteno"
+
+	"github.com/cloudfoundry/gorouter/config"
+)
+
+const (
+	htmlContentType = "text/html; charset=utf-8"
+	jsonContentType = "application/json"
+)
+
+// Pages render the bodies of the responses the router generates itself
+// from the templates operators configured, as HTML or JSON depending on
+// what the client accepts. With jsonErrors, responses without a page are
+// rendered as a typed JSON error for clients that prefer JSON. A nil Pages
+// renders nothing.
+type Pages struct {
+	pages      map[int]*page
+	jsonErrors bool
+	logger     *steno.Logger
+}
+
+type page struct {
+	html *htmltemplate.Template
+	json *texttemplate.Template
+}
+
+// Data is what the templates are executed with. Error is the value of the
+// X-Cf-RouterError header of the response.
+type Data struct {
+	Status     int
+	StatusText string
+	Message    string
+	Error      string
+	Host       string
+	RequestId  string
+}
+
+// typedError is the JSON body of router errors without a page. Error is
+// the value of the X-Cf-RouterError header, or router_error when there is
+// none, so clients can tell them from errors of apps.
+type typedError struct {
+	Error     string `json:"error"`
+	Message   string `json:"message"`
+	Status    int    `json:"status"`
+	RequestId string `json:"request_id,omitempty"`
+}
+
+// jsonFuncs lets JSON templates quote values with {{json .Message}}.
+var jsonFuncs = texttemplate.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+}
+
+// New reads the templates of configs, returning nil when there are none
+// and jsonErrors is not set.
+func New(configs []config.ErrorPageConfig, jsonErrors bool) (*Pages, error) {
+	if len(configs) == 0 && !jsonErrors {
+		return nil, nil
+	}
+
+	p := &Pages{
+		pages:      make(map[int]*page),
+		jsonErrors: jsonErrors,
+		logger:     steno.NewLogger("router.error-pages"),
+	}
+	for _, c := range configs {
+		pg := &page{}
+
+		if c.HtmlFile != "" {
+			b, err := ioutil.ReadFile(c.HtmlFile)
+			if err != nil {
+				return nil, err
+			}
+			pg.html, err = htmltemplate.New(filepath.Base(c.HtmlFile)).Parse(string(b))
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		if c.JsonFile != "" {
+			b, err := ioutil.ReadFile(c.JsonFile)
+			if err != nil {
+				return nil, err
+			}
+			pg.json, err = texttemplate.New(filepath.Base(c.JsonFile)).Funcs(jsonFuncs).Parse(string(b))
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		p.pages[c.Status] = pg
+	}
+
+	return p, nil
+}
+
+// Render returns the body of a response with data.Status to request, and
+// its content type. It returns false when there is no page for the status,
+// or none the client accepts, leaving the response to the plain text.
+func (p *Pages) Render(request *http.Request, data Data) ([]byte, string, bool) {
+	if p == nil {
+		return nil, "", false
+	}
+
+	pg := p.pages[data.Status]
+	if pg != nil {
+		body, contentType, ok := p.renderPage(pg, request, data)
+		if ok {
+			return body, contentType, true
+		}
+	}
+
+	if p.jsonErrors && prefersJSON(request.Header.Get("Accept")) {
+		return renderTypedError(data)
+	}
+
+	return nil, "", false
+}
+
+func (p *Pages) renderPage(pg *page, request *http.Request, data Data) ([]byte, string, bool) {
+	var htmlQ, jsonQ float64
+	if pg.html != nil {
+		htmlQ = acceptable(request.Header.Get("Accept"), "text", "html")
+	}
+	if pg.json != nil {
+		jsonQ = acceptable(request.Header.Get("Accept"), "application", "json")
+	}
+
+	var body bytes.Buffer
+	var contentType string
+	var err error
+	switch {
+	case htmlQ > 0 && htmlQ >= jsonQ:
+		contentType = htmlContentType
+		err = pg.html.Execute(&body, data)
+	case jsonQ > 0:
+		contentType = jsonContentType
+		err = pg.json.Execute(&body, data)
+	default:
+		return nil, "", false
+	}
+
+	if err != nil {
+		p.logger.Warnd(map[string]interface{}{
+			"status": data.Status,
+			"error":  err.Error(),
+		}, "error-pages.render.failed")
+		return nil, "", false
+	}
+
+	return body.Bytes(), contentType, true
+}
+
+func renderTypedError(data Data) ([]byte, string, bool) {
+	e := typedError{
+		Error:     data.Error,
+		Message:   data.Message,
+		Status:    data.Status,
+		RequestId: data.RequestId,
+	}
+	if e.Error == "" {
+		e.Error = "router_error"
+	}
+
+	body, err := json.Marshal(e)
+	if err != nil {
+		return nil, "", false
+	}
+	return append(body, '\n'), jsonContentType, true
+}
+
+// prefersJSON tells whether the Accept header gives JSON a higher quality
+// than the plain text and HTML, so that clients accepting anything keep
+// getting the plain text.
+func prefersJSON(accept string) bool {
+	q := acceptable(accept, "application", "json")
+	return q > 0 && q > acceptable(accept, "text", "plain") && q > acceptable(accept, "text", "html")
+}
+
+// acceptable returns the quality the Accept header gives the media type,
+// from its most specific range that covers it. Requests without an Accept
+// header accept anything.
+func acceptable(accept, typ, subtype string) float64 {
+	if strings.TrimSpace(accept) == "" {
+		return 1
+	}
+
+	q := 0.0
+	specificity := -1
+	for _, r := range strings.Split(accept, ",") {
+		params := strings.Split(r, ";")
+		mediaRange := strings.ToLower(strings.TrimSpace(params[0]))
+
+		s := -1
+		switch mediaRange {
+		case typ + "/" + subtype:
+			s = 2
+		case typ + "/*":
+			s = 1
+		case "*/*":
+			s = 0
+		}
+		if s <= specificity {
+			continue
+		}
+
+		specificity = s
+		q = 1
+		for _, param := range params[1:] {
+			kv := strings.SplitN(strings.TrimSpace(param), "=", 2)
+			if len(kv) == 2 && strings.TrimSpace(kv[0]) == "q" {
+				v, err := strconv.ParseFloat(strings.TrimSpace(kv[1]), 64)
+				if err != nil {
+					v = 0
+				}
+				q = v
+			}
+		}
+	}
+
+	return q
+}
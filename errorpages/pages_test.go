@@ -0,0 +1,190 @@
+package errorpages_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/cloudfoundry/gorouter/config"
+	"github.com/cloudfoundry/gorouter/errorpages"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Pages", func() {
+	var dir string
+	var pages *errorpages.Pages
+	var request *http.Request
+	var data errorpages.Data
+
+	writeTemplate := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		Ω(ioutil.WriteFile(path, []byte(content), 0644)).To(Succeed())
+		return path
+	}
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "error-pages")
+		Ω(err).ToNot(HaveOccurred())
+
+		pages, err = errorpages.New([]config.ErrorPageConfig{
+			{
+				Status:   http.StatusNotFound,
+				HtmlFile: writeTemplate("404.html", `<p>{{.Message}}</p>`),
+				JsonFile: writeTemplate("404.json", `{"message":{{json .Message}},"request_id":{{json .RequestId}}}`),
+			},
+			{
+				Status:   http.StatusServiceUnavailable,
+				JsonFile: writeTemplate("503.json", `{"status":{{.Status}},"text":{{json .StatusText}}}`),
+			},
+		}, false)
+		Ω(err).ToNot(HaveOccurred())
+
+		request, err = http.NewRequest("GET", "http://app.example.com/", nil)
+		Ω(err).ToNot(HaveOccurred())
+
+		data = errorpages.Data{
+			Status:     http.StatusNotFound,
+			StatusText: "Not Found",
+			Message:    `Requested route ('<b>"app"</b>') does not exist.`,
+			Error:      "unknown_route",
+			Host:       "app.example.com",
+			RequestId:  "request-id",
+		}
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	It("renders nothing without pages", func() {
+		pages, err := errorpages.New(nil, false)
+		Ω(err).ToNot(HaveOccurred())
+		Ω(pages).To(BeNil())
+
+		_, _, ok := pages.Render(request, data)
+		Ω(ok).To(BeFalse())
+	})
+
+	It("renders HTML with its values escaped", func() {
+		request.Header.Set("Accept", "text/html")
+
+		body, contentType, ok := pages.Render(request, data)
+		Ω(ok).To(BeTrue())
+		Ω(contentType).To(Equal("text/html; charset=utf-8"))
+		Ω(string(body)).To(Equal(`<p>Requested route (&#39;&lt;b&gt;&#34;app&#34;&lt;/b&gt;&#39;) does not exist.</p>`))
+	})
+
+	It("renders JSON with its values quoted", func() {
+		request.Header.Set("Accept", "application/json")
+
+		body, contentType, ok := pages.Render(request, data)
+		Ω(ok).To(BeTrue())
+		Ω(contentType).To(Equal("application/json"))
+		Ω(string(body)).To(MatchJSON(`{"message":"Requested route ('<b>\"app\"</b>') does not exist.","request_id":"request-id"}`))
+	})
+
+	It("renders what the Accept header prefers", func() {
+		request.Header.Set("Accept", "text/html;q=0.5, application/json")
+		_, contentType, _ := pages.Render(request, data)
+		Ω(contentType).To(Equal("application/json"))
+
+		request.Header.Set("Accept", "application/*;q=0.9, text/*")
+		_, contentType, _ = pages.Render(request, data)
+		Ω(contentType).To(Equal("text/html; charset=utf-8"))
+
+		request.Header.Set("Accept", "*/*, text/html;q=0")
+		_, contentType, _ = pages.Render(request, data)
+		Ω(contentType).To(Equal("application/json"))
+	})
+
+	It("renders HTML for clients that accept anything", func() {
+		_, contentType, ok := pages.Render(request, data)
+		Ω(ok).To(BeTrue())
+		Ω(contentType).To(Equal("text/html; charset=utf-8"))
+	})
+
+	It("renders nothing when the client accepts none of the pages", func() {
+		request.Header.Set("Accept", "text/plain")
+		_, _, ok := pages.Render(request, data)
+		Ω(ok).To(BeFalse())
+
+		data.Status = http.StatusServiceUnavailable
+		request.Header.Set("Accept", "text/html")
+		_, _, ok = pages.Render(request, data)
+		Ω(ok).To(BeFalse())
+	})
+
+	It("renders nothing for statuses without a page", func() {
+		data.Status = http.StatusBadGateway
+		_, _, ok := pages.Render(request, data)
+		Ω(ok).To(BeFalse())
+	})
+
+	Context("with JSON errors", func() {
+		BeforeEach(func() {
+			var err error
+			pages, err = errorpages.New(nil, true)
+			Ω(err).ToNot(HaveOccurred())
+		})
+
+		It("renders a typed error for clients that prefer JSON", func() {
+			request.Header.Set("Accept", "application/json, */*;q=0.1")
+
+			body, contentType, ok := pages.Render(request, data)
+			Ω(ok).To(BeTrue())
+			Ω(contentType).To(Equal("application/json"))
+			Ω(string(body)).To(MatchJSON(`{
+				"error": "unknown_route",
+				"message": "Requested route ('<b>\"app\"</b>') does not exist.",
+				"status": 404,
+				"request_id": "request-id"
+			}`))
+		})
+
+		It("names errors without a router error code router_error", func() {
+			request.Header.Set("Accept", "application/json")
+			data.Error = ""
+
+			body, _, _ := pages.Render(request, data)
+			Ω(string(body)).To(ContainSubstring(`"error":"router_error"`))
+		})
+
+		It("renders nothing for clients that accept anything", func() {
+			_, _, ok := pages.Render(request, data)
+			Ω(ok).To(BeFalse())
+
+			request.Header.Set("Accept", "*/*")
+			_, _, ok = pages.Render(request, data)
+			Ω(ok).To(BeFalse())
+
+			request.Header.Set("Accept", "text/html, application/json")
+			_, _, ok = pages.Render(request, data)
+			Ω(ok).To(BeFalse())
+		})
+
+		It("prefers the configured page", func() {
+			var err error
+			pages, err = errorpages.New([]config.ErrorPageConfig{{
+				Status:   http.StatusNotFound,
+				JsonFile: writeTemplate("404.json", `{"custom":true}`),
+			}}, true)
+			Ω(err).ToNot(HaveOccurred())
+			request.Header.Set("Accept", "application/json")
+
+			body, _, _ := pages.Render(request, data)
+			Ω(string(body)).To(Equal(`{"custom":true}`))
+		})
+	})
+
+	It("fails on templates that cannot be read or parsed", func() {
+		_, err := errorpages.New([]config.ErrorPageConfig{{Status: 404, HtmlFile: filepath.Join(dir, "missing.html")}}, false)
+		Ω(err).To(HaveOccurred())
+
+		_, err = errorpages.New([]config.ErrorPageConfig{{Status: 404, JsonFile: writeTemplate("bad.json", `{{.Status`)}}, false)
+		Ω(err).To(HaveOccurred())
+	})
+})
@@ -0,0 +1,251 @@
+// Package handoff lets a new router binary take over the listening sockets
+// of the running one, so that an upgrade refuses no connections.
+//
+// The running router starts the new binary with its sockets as extra file
+// descriptors, named by the address they listen on in the environment. The
+// new router listens on the sockets it inherits instead of binding the
+// addresses again, and tells the old one over a pipe once it serves, after
+// which the old router stops accepting connections and drains. Until then
+// the sockets are shared, and connections are accepted by either router.
+//
+// Sockets that systemd activates the router with are listened on the same
+// way, by the address they are bound to.
+package handoff
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cloudfoundry/gorouter/systemd"
+	steno "github.com/cloudfoundry/gosteno"
+)
+
+const (
+	// ListenersEnv names the inherited sockets as a list of addr=fd.
+	ListenersEnv = "GOROUTER_LISTENERS"
+	// ReadyEnv is the descriptor of the pipe to tell the old router on.
+	ReadyEnv = "GOROUTER_HANDOFF_READY"
+)
+
+var (
+	lock      sync.Mutex
+	once      sync.Once
+	inherited map[string]*os.File
+	activated []net.Listener
+	ready     *os.File
+	listeners = make(map[string]net.Listener)
+
+	logger = steno.NewLogger("handoff")
+)
+
+// inherit takes the sockets and the pipe that the old router passed down,
+// and the sockets that systemd activated the router with, if any, and clears
+// them from the environment so that they are not passed down again. lock
+// must be locked.
+func inherit() {
+	once.Do(func() {
+		inherited = make(map[string]*os.File)
+
+		for _, l := range strings.Split(os.Getenv(ListenersEnv), ",") {
+			i := strings.LastIndex(l, "=")
+			if i < 0 {
+				continue
+			}
+
+			fd, err := strconv.Atoi(l[i+1:])
+			if err != nil {
+				continue
+			}
+			inherited[l[:i]] = os.NewFile(uintptr(fd), l[:i])
+		}
+
+		if fd, err := strconv.Atoi(os.Getenv(ReadyEnv)); err == nil {
+			ready = os.NewFile(uintptr(fd), "ready")
+		}
+
+		os.Unsetenv(ListenersEnv)
+		os.Unsetenv(ReadyEnv)
+
+		for _, f := range systemd.Listeners() {
+			l, err := net.FileListener(f)
+			f.Close()
+			if err != nil {
+				logger.Warnf("Error listening on a socket activated by systemd: %s", err)
+				continue
+			}
+			activated = append(activated, l)
+		}
+	})
+}
+
+// activatedFor returns the socket activated by systemd that is bound to
+// addr, if any. lock must be locked.
+func activatedFor(addr string) net.Listener {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil
+	}
+
+	for i, l := range activated {
+		a, ok := l.Addr().(*net.TCPAddr)
+		if !ok || strconv.Itoa(a.Port) != port {
+			continue
+		}
+
+		if host == "" || a.IP.Equal(net.ParseIP(host)) {
+			activated = append(activated[:i], activated[i+1:]...)
+			return l
+		}
+	}
+	return nil
+}
+
+// Listen listens for TCP connections on addr, on the socket inherited or
+// activated for addr when there is one, and keeps the listener to hand off.
+func Listen(addr string) (net.Listener, error) {
+	lock.Lock()
+	defer lock.Unlock()
+
+	inherit()
+
+	var l net.Listener
+	var err error
+	if f, found := inherited[addr]; found {
+		delete(inherited, addr)
+		l, err = net.FileListener(f)
+		f.Close()
+		if err == nil {
+			logger.Infof("Listening on inherited socket %s", addr)
+		}
+	} else if l = activatedFor(addr); l != nil {
+		logger.Infof("Listening on socket %s activated by systemd", addr)
+	} else {
+		l, err = net.Listen("tcp", addr)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	listeners[addr] = l
+	return l, nil
+}
+
+// Ready tells the old router, if any, that the listeners are served, and
+// closes the inherited and activated sockets that no listener was asked for.
+func Ready() {
+	lock.Lock()
+	defer lock.Unlock()
+
+	inherit()
+
+	for addr, f := range inherited {
+		logger.Infof("Closing inherited socket %s that is not listened on", addr)
+		f.Close()
+		delete(inherited, addr)
+	}
+
+	for _, l := range activated {
+		logger.Infof("Closing socket %s activated by systemd that is not listened on", l.Addr())
+		l.Close()
+	}
+	activated = nil
+
+	if ready != nil {
+		ready.Write([]byte{1})
+		ready.Close()
+		ready = nil
+	}
+}
+
+// Upgrade starts the binary at the path that the router was started with,
+// with the same arguments and the listeners, and waits up to timeout for it
+// to serve them. A new router that does not serve in time is killed.
+func Upgrade(timeout time.Duration) error {
+	lock.Lock()
+	defer lock.Unlock()
+
+	var names []string
+	var files []*os.File
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+
+	for addr, l := range listeners {
+		fl, ok := l.(interface {
+			File() (*os.File, error)
+		})
+		if !ok {
+			continue
+		}
+
+		f, err := fl.File()
+		if err != nil {
+			// the listener was closed
+			continue
+		}
+
+		names = append(names, fmt.Sprintf("%s=%d", addr, 3+len(files)))
+		files = append(files, f)
+	}
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	cmd := exec.Command(os.Args[0], os.Args[1:]...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.ExtraFiles = append(files, w)
+	cmd.Env = append(environ(),
+		ListenersEnv+"="+strings.Join(names, ","),
+		fmt.Sprintf("%s=%d", ReadyEnv, 3+len(files)),
+	)
+
+	err = cmd.Start()
+	w.Close()
+	if err != nil {
+		return err
+	}
+
+	// the new router is not waited for by anyone else once it serves
+	go cmd.Wait()
+
+	logger.Infof("Started router %d with %d listeners", cmd.Process.Pid, len(files))
+
+	r.SetReadDeadline(time.Now().Add(timeout))
+	_, err = r.Read(make([]byte, 1))
+	if err == nil {
+		return nil
+	}
+
+	cmd.Process.Kill()
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		return fmt.Errorf("router %d did not serve within %s", cmd.Process.Pid, timeout)
+	}
+	return fmt.Errorf("router %d exited before serving", cmd.Process.Pid)
+}
+
+// environ is the environment of the router, without what an old router
+// passed down to it.
+func environ() []string {
+	var env []string
+	for _, e := range os.Environ() {
+		if strings.HasPrefix(e, ListenersEnv+"=") || strings.HasPrefix(e, ReadyEnv+"=") {
+			continue
+		}
+		env = append(env, e)
+	}
+	return env
+}
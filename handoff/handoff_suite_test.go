@@ -0,0 +1,62 @@
+package handoff_test
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/cloudfoundry/gorouter/handoff"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+// the routers that the tests upgrade to run this test binary again
+func TestMain(m *testing.M) {
+	if mode := os.Getenv("HANDOFF_TEST_ROUTER"); mode != "" {
+		runRouter(mode, os.Getenv("HANDOFF_TEST_ADDR"))
+	}
+
+	os.Exit(m.Run())
+}
+
+func TestHandoff(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Handoff Suite")
+}
+
+// runRouter plays the new router, or one activated by systemd: it serves a
+// request on the listener of addr, unless mode is exit or hang, and exits.
+func runRouter(mode, addr string) {
+	switch mode {
+	case "exit":
+		os.Exit(0)
+	case "hang":
+		time.Sleep(10 * time.Second)
+		os.Exit(0)
+	case "activated":
+		// systemd sets the pid of the process that it starts
+		os.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+	}
+
+	l, err := handoff.Listen(addr)
+	if err != nil {
+		os.Exit(1)
+	}
+
+	go http.Serve(l, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "new router")
+		go func() {
+			time.Sleep(100 * time.Millisecond)
+			os.Exit(0)
+		}()
+	}))
+
+	handoff.Ready()
+
+	time.Sleep(10 * time.Second)
+	os.Exit(0)
+}
@@ -0,0 +1,85 @@
+package handoff_test
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"strconv"
+	"time"
+
+	"github.com/cloudfoundry/gorouter/handoff"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Handoff", func() {
+	var listener net.Listener
+
+	BeforeEach(func() {
+		var err error
+		listener, err = handoff.Listen("127.0.0.1:0")
+		Ω(err).NotTo(HaveOccurred())
+
+		os.Setenv("HANDOFF_TEST_ADDR", "127.0.0.1:0")
+	})
+
+	AfterEach(func() {
+		listener.Close()
+		os.Unsetenv("HANDOFF_TEST_ROUTER")
+		os.Unsetenv("HANDOFF_TEST_ADDR")
+	})
+
+	It("has the new router serve the listeners once the old one stops", func() {
+		os.Setenv("HANDOFF_TEST_ROUTER", "serve")
+
+		Ω(handoff.Upgrade(5 * time.Second)).To(Succeed())
+		listener.Close()
+
+		res, err := http.Get("http://" + listener.Addr().String())
+		Ω(err).NotTo(HaveOccurred())
+		defer res.Body.Close()
+
+		body, err := ioutil.ReadAll(res.Body)
+		Ω(err).NotTo(HaveOccurred())
+		Ω(string(body)).To(Equal("new router"))
+	})
+
+	It("fails when the new router exits before it serves", func() {
+		os.Setenv("HANDOFF_TEST_ROUTER", "exit")
+
+		Ω(handoff.Upgrade(5 * time.Second)).To(MatchError(ContainSubstring("exited before serving")))
+	})
+
+	It("fails when the new router does not serve in time", func() {
+		os.Setenv("HANDOFF_TEST_ROUTER", "hang")
+
+		Ω(handoff.Upgrade(100 * time.Millisecond)).To(MatchError(ContainSubstring("did not serve within 100ms")))
+	})
+
+	It("listens on the sockets systemd activates the router with by their address", func() {
+		f, err := listener.(*net.TCPListener).File()
+		Ω(err).NotTo(HaveOccurred())
+		defer f.Close()
+		listener.Close()
+
+		cmd := exec.Command(os.Args[0], os.Args[1:]...)
+		cmd.ExtraFiles = []*os.File{f}
+		cmd.Env = append(os.Environ(),
+			"HANDOFF_TEST_ROUTER=activated",
+			"HANDOFF_TEST_ADDR=:"+strconv.Itoa(listener.Addr().(*net.TCPAddr).Port),
+			"LISTEN_FDS=1",
+		)
+		Ω(cmd.Start()).To(Succeed())
+		defer cmd.Wait()
+
+		res, err := http.Get("http://" + listener.Addr().String())
+		Ω(err).NotTo(HaveOccurred())
+		defer res.Body.Close()
+
+		body, err := ioutil.ReadAll(res.Body)
+		Ω(err).NotTo(HaveOccurred())
+		Ω(string(body)).To(Equal("new router"))
+	})
+})
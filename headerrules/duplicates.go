@@ -0,0 +1,58 @@
+package headerrules
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/cloudfoundry/gorouter/config"
+)
+
+// Duplicates handles the request headers that appear more than once by the
+// policies operators configured for them. A nil Duplicates changes nothing.
+type Duplicates struct {
+	policies map[string]string
+}
+
+// NewDuplicates returns the policies of c, or nil when there are none.
+func NewDuplicates(c map[string]string) *Duplicates {
+	if len(c) == 0 {
+		return nil
+	}
+
+	d := &Duplicates{policies: make(map[string]string, len(c))}
+	for name, policy := range c {
+		d.policies[http.CanonicalHeaderKey(name)] = policy
+	}
+	return d
+}
+
+// Normalize merges the values of the headers whose policy is merge, and
+// drops all but the first value of those whose policy is first. It returns
+// the name of a header whose policy is reject and that appears more than
+// once, leaving the header as it is, or "" when there is none.
+func (d *Duplicates) Normalize(header http.Header) string {
+	if d == nil {
+		return ""
+	}
+
+	for name, policy := range d.policies {
+		values := header[name]
+		if len(values) < 2 {
+			continue
+		}
+
+		switch policy {
+		case config.DuplicateReject:
+			return name
+		case config.DuplicateFirst:
+			header[name] = values[:1]
+		case config.DuplicateMerge:
+			separator := ", "
+			if name == "Cookie" {
+				separator = "; "
+			}
+			header[name] = []string{strings.Join(values, separator)}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,53 @@
+package headerrules_test
+
+import (
+	"net/http"
+
+	"github.com/cloudfoundry/gorouter/config"
+	"github.com/cloudfoundry/gorouter/headerrules"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Duplicates", func() {
+	var header http.Header
+
+	BeforeEach(func() {
+		header = http.Header{
+			"X-Tenant-Id":      {"a", "b"},
+			"X-Forwarded-Host": {"one.example.com", "two.example.com"},
+			"Cookie":           {"a=1", "b=2"},
+			"Accept":           {"text/html", "application/json"},
+		}
+	})
+
+	It("changes nothing without policies", func() {
+		d := headerrules.NewDuplicates(nil)
+		Ω(d).To(BeNil())
+		Ω(d.Normalize(header)).To(BeEmpty())
+		Ω(header["X-Tenant-Id"]).To(Equal([]string{"a", "b"}))
+	})
+
+	It("merges and keeps the first value by header name", func() {
+		d := headerrules.NewDuplicates(map[string]string{
+			"x-forwarded-host": config.DuplicateFirst,
+			"cookie":           config.DuplicateMerge,
+			"Accept":           config.DuplicateMerge,
+		})
+
+		Ω(d.Normalize(header)).To(BeEmpty())
+		Ω(header["X-Forwarded-Host"]).To(Equal([]string{"one.example.com"}))
+		Ω(header["Cookie"]).To(Equal([]string{"a=1; b=2"}))
+		Ω(header["Accept"]).To(Equal([]string{"text/html, application/json"}))
+		Ω(header["X-Tenant-Id"]).To(Equal([]string{"a", "b"}))
+	})
+
+	It("names a header it rejects", func() {
+		d := headerrules.NewDuplicates(map[string]string{"X-Tenant-Id": config.DuplicateReject})
+		Ω(d.Normalize(header)).To(Equal("X-Tenant-Id"))
+
+		header.Set("X-Tenant-Id", "a")
+		Ω(d.Normalize(header)).To(BeEmpty())
+	})
+})
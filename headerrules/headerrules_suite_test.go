@@ -0,0 +1,13 @@
+package headerrules_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestHeaderRules(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "HeaderRules Suite")
+}
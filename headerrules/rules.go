@@ -0,0 +1,185 @@
+package headerrules
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	router_http "github.com/cloudfoundry/gorouter/common/http"
+	"github.com/cloudfoundry/gorouter/config"
+	"github.com/cloudfoundry/gorouter/requestfilter"
+)
+
+// Rules change the headers of requests on their way to backends and of
+// responses on their way back, as operators configured them. A nil Rules
+// changes nothing.
+type Rules struct {
+	rules []*rule
+}
+
+type rule struct {
+	filter   *requestfilter.Rule
+	request  actions
+	response actions
+}
+
+type actions struct {
+	remove []string
+	set    []header
+	add    []header
+}
+
+type header struct {
+	name  string
+	value template
+}
+
+// New returns the rules of configs, or nil when there are none.
+func New(configs []config.HeaderRuleConfig) *Rules {
+	if len(configs) == 0 {
+		return nil
+	}
+
+	r := &Rules{}
+	for _, c := range configs {
+		r.rules = append(r.rules, &rule{
+			filter:   requestfilter.NewRule(config.RequestFilterConfig{Routes: c.Routes}),
+			request:  newActions(c.Request),
+			response: newActions(c.Response),
+		})
+	}
+
+	return r
+}
+
+func newActions(c config.HeaderActionsConfig) actions {
+	a := actions{}
+	for _, name := range c.Remove {
+		a.remove = append(a.remove, http.CanonicalHeaderKey(name))
+	}
+	for name, value := range c.Set {
+		a.set = append(a.set, header{name: http.CanonicalHeaderKey(name), value: parseTemplate(value)})
+	}
+	for name, value := range c.Add {
+		a.add = append(a.add, header{name: http.CanonicalHeaderKey(name), value: parseTemplate(value)})
+	}
+	return a
+}
+
+// Match returns the rules that apply to request, or nil when none do.
+// It is called once the request has its request id and forwarded headers.
+func (r *Rules) Match(request *http.Request) *Match {
+	if r == nil {
+		return nil
+	}
+
+	host := requestfilter.Hostname(request.Host)
+
+	var m *Match
+	for _, rule := range r.rules {
+		if rule.filter.MatchesHost(host) {
+			if m == nil {
+				m = &Match{vars: variables(request, host)}
+			}
+			m.rules = append(m.rules, rule)
+		}
+	}
+	return m
+}
+
+// A Match is the rules that apply to one request.
+type Match struct {
+	rules []*rule
+	vars  map[string]string
+}
+
+// Request changes the headers of the request to the backend.
+func (m *Match) Request(h http.Header) {
+	if m == nil {
+		return
+	}
+	for _, r := range m.rules {
+		r.request.apply(h, m.vars)
+	}
+}
+
+// Response changes the headers of the response to the client.
+func (m *Match) Response(h http.Header) {
+	if m == nil {
+		return
+	}
+	for _, r := range m.rules {
+		r.response.apply(h, m.vars)
+	}
+}
+
+func (a *actions) apply(h http.Header, vars map[string]string) {
+	for _, name := range a.remove {
+		delete(h, name)
+	}
+	for _, s := range a.set {
+		h[s.name] = []string{s.value.expand(vars)}
+	}
+	for _, s := range a.add {
+		h[s.name] = append(h[s.name], s.value.expand(vars))
+	}
+}
+
+func variables(request *http.Request, host string) map[string]string {
+	clientIP, _, err := net.SplitHostPort(request.RemoteAddr)
+	if err != nil {
+		clientIP = request.RemoteAddr
+	}
+
+	scheme := request.Header.Get("X-Forwarded-Proto")
+	if scheme == "" {
+		scheme = "http"
+		if request.TLS != nil {
+			scheme = "https"
+		}
+	}
+
+	return map[string]string{
+		"client_ip":  clientIP,
+		"host":       host,
+		"scheme":     scheme,
+		"path":       request.URL.Path,
+		"request_id": request.Header.Get(router_http.VcapRequestIdHeader),
+	}
+}
+
+// A template is a header value with ${name} references to variables, split
+// into literal text and variable names in turn.
+type template []string
+
+func parseTemplate(value string) template {
+	var t template
+	for {
+		i := strings.Index(value, "${")
+		if i == -1 {
+			return append(t, value)
+		}
+		j := strings.Index(value[i:], "}")
+		if j == -1 {
+			return append(t, value)
+		}
+		t = append(t, value[:i], value[i+2:i+j])
+		value = value[i+j+1:]
+	}
+}
+
+func (t template) expand(vars map[string]string) string {
+	if len(t) == 1 {
+		return t[0]
+	}
+
+	var b strings.Builder
+	for i, part := range t {
+		if i%2 == 0 {
+			b.WriteString(part)
+		} else {
+			b.WriteString(vars[part])
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,107 @@
+package headerrules_test
+
+import (
+	"net/http"
+
+	"github.com/cloudfoundry/gorouter/config"
+	"github.com/cloudfoundry/gorouter/headerrules"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Rules", func() {
+	var request *http.Request
+
+	BeforeEach(func() {
+		var err error
+		request, err = http.NewRequest("GET", "http://api.example.com/v1/items", nil)
+		Ω(err).NotTo(HaveOccurred())
+		request.Host = "API.example.com:8080"
+		request.RemoteAddr = "10.1.2.3:45678"
+		request.Header.Set("X-Vcap-Request-Id", "request-id")
+		request.Header.Set("X-Forwarded-Proto", "https")
+	})
+
+	It("changes nothing without rules", func() {
+		rules := headerrules.New(nil)
+		Ω(rules).To(BeNil())
+		Ω(rules.Match(request)).To(BeNil())
+
+		h := http.Header{"A": {"b"}}
+		rules.Match(request).Request(h)
+		Ω(h).To(Equal(http.Header{"A": {"b"}}))
+	})
+
+	It("removes, sets and adds headers in turn", func() {
+		rules := headerrules.New([]config.HeaderRuleConfig{{
+			Request: config.HeaderActionsConfig{
+				Remove: []string{"x-internal"},
+				Set:    map[string]string{"x-team": "payments"},
+				Add:    map[string]string{"via": "gorouter"},
+			},
+			Response: config.HeaderActionsConfig{
+				Remove: []string{"Server"},
+				Set:    map[string]string{"Strict-Transport-Security": "max-age=31536000"},
+			},
+		}})
+
+		h := http.Header{
+			"X-Internal": {"secret"},
+			"X-Team":     {"someone else"},
+			"Via":        {"1.1 lb"},
+		}
+		m := rules.Match(request)
+		m.Request(h)
+		Ω(h).To(Equal(http.Header{
+			"X-Team": {"payments"},
+			"Via":    {"1.1 lb", "gorouter"},
+		}))
+
+		res := http.Header{"Server": {"nginx"}}
+		m.Response(res)
+		Ω(res).To(Equal(http.Header{"Strict-Transport-Security": {"max-age=31536000"}}))
+	})
+
+	It("substitutes variables of the request", func() {
+		rules := headerrules.New([]config.HeaderRuleConfig{{
+			Request: config.HeaderActionsConfig{
+				Set: map[string]string{
+					"X-Client-Ip": "${client_ip}",
+					"X-Origin":    "${scheme}://${host}${path}",
+					"X-Trace":     "id=${request_id};",
+				},
+			},
+		}})
+
+		h := http.Header{}
+		rules.Match(request).Request(h)
+		Ω(h.Get("X-Client-Ip")).To(Equal("10.1.2.3"))
+		Ω(h.Get("X-Origin")).To(Equal("https://api.example.com/v1/items"))
+		Ω(h.Get("X-Trace")).To(Equal("id=request-id;"))
+	})
+
+	It("applies rules only to the routes they name", func() {
+		rules := headerrules.New([]config.HeaderRuleConfig{
+			{
+				Routes:  []string{"www.example.com"},
+				Request: config.HeaderActionsConfig{Set: map[string]string{"X-Www": "1"}},
+			},
+			{
+				Routes:  []string{"*.Example.com"},
+				Request: config.HeaderActionsConfig{Set: map[string]string{"X-Wildcard": "1"}},
+			},
+			{
+				Routes:  []string{"api.example.com"},
+				Request: config.HeaderActionsConfig{Set: map[string]string{"X-Api": "1"}},
+			},
+		})
+
+		h := http.Header{}
+		rules.Match(request).Request(h)
+		Ω(h).To(Equal(http.Header{"X-Wildcard": {"1"}, "X-Api": {"1"}}))
+
+		request.Host = "example.org"
+		Ω(rules.Match(request)).To(BeNil())
+	})
+})
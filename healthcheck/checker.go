@@ -0,0 +1,191 @@
+package healthcheck
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	steno "github.com/cloudfoundry/gosteno"
+
+	"github.com/cloudfoundry/gorouter/config"
+	"github.com/cloudfoundry/gorouter/route"
+)
+
+const maxConcurrentChecks = 16
+
+// Pools gives the checker the pools whose endpoints it checks.
+type Pools interface {
+	EachPool(f func(pool *route.Pool))
+}
+
+// Checker probes the health check path of every endpoint registered with
+// one, and takes endpoints that fail their checks out of their pools until
+// they pass again. Registrations keep coming in for an endpoint that is up
+// but broken, so this does not wait for its route to go stale.
+type Checker struct {
+	pools  Pools
+	client *http.Client
+	logger *steno.Logger
+
+	healthyThreshold   int
+	unhealthyThreshold int
+	interval           time.Duration
+
+	targets map[string]*target
+	stopCh  chan struct{}
+}
+
+type target struct {
+	endpoint *route.Endpoint
+	pools    []*route.Pool
+
+	healthy   bool
+	successes int
+	failures  int
+}
+
+func NewChecker(pools Pools, c config.HealthCheckConfig) *Checker {
+	return &Checker{
+		pools: pools,
+		client: &http.Client{
+			Timeout: c.Timeout,
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		logger: steno.NewLogger("router.healthcheck"),
+
+		healthyThreshold:   c.HealthyThreshold,
+		unhealthyThreshold: c.UnhealthyThreshold,
+		interval:           c.Interval,
+
+		targets: make(map[string]*target),
+		stopCh:  make(chan struct{}),
+	}
+}
+
+// Run checks the endpoints every interval until Stop is called.
+func (c *Checker) Run() {
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			c.Check()
+		case <-c.stopCh:
+			return
+		}
+	}
+}
+
+func (c *Checker) Stop() {
+	close(c.stopCh)
+}
+
+// Check probes every endpoint once and updates its pools. It is not safe to
+// call concurrently.
+func (c *Checker) Check() {
+	targets := c.collect()
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, maxConcurrentChecks)
+	for _, t := range targets {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(t *target) {
+			defer wg.Done()
+			c.record(t, c.probe(t.endpoint))
+			<-sem
+		}(t)
+	}
+	wg.Wait()
+
+	c.targets = targets
+}
+
+// collect finds the endpoints to check, keeping the state of those that were
+// checked before. An endpoint that is registered for several routes is
+// checked once for all of them.
+func (c *Checker) collect() map[string]*target {
+	targets := make(map[string]*target)
+
+	c.pools.EachPool(func(pool *route.Pool) {
+		pool.Each(func(endpoint *route.Endpoint) {
+			if endpoint.HealthCheckPath == "" {
+				return
+			}
+
+			key := endpoint.CanonicalAddr() + " " + endpoint.HealthCheckPath
+			t, ok := targets[key]
+			if !ok {
+				t, ok = c.targets[key]
+				if !ok {
+					t = &target{healthy: true}
+				}
+				t.pools = nil
+				targets[key] = t
+			}
+
+			t.endpoint = endpoint
+			t.pools = append(t.pools, pool)
+		})
+	})
+
+	return targets
+}
+
+func (c *Checker) probe(endpoint *route.Endpoint) bool {
+	path := endpoint.HealthCheckPath
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+
+	request, err := http.NewRequest("GET", "http://"+endpoint.CanonicalAddr()+path, nil)
+	if err != nil {
+		return false
+	}
+	request.Header.Set("User-Agent", "gorouter-health-check")
+
+	res, err := c.client.Do(request)
+	if err != nil {
+		return false
+	}
+	io.Copy(ioutil.Discard, io.LimitReader(res.Body, 4096))
+	res.Body.Close()
+
+	return res.StatusCode >= 200 && res.StatusCode < 400
+}
+
+func (c *Checker) record(t *target, ok bool) {
+	if ok {
+		t.successes++
+		t.failures = 0
+	} else {
+		t.failures++
+		t.successes = 0
+	}
+
+	if t.healthy && t.failures >= c.unhealthyThreshold {
+		t.healthy = false
+		c.logger.Warnd(map[string]interface{}{
+			"endpoint": t.endpoint.CanonicalAddr(),
+			"path":     t.endpoint.HealthCheckPath,
+		}, "healthcheck.endpoint.unhealthy")
+	} else if !t.healthy && t.successes >= c.healthyThreshold {
+		t.healthy = true
+		c.logger.Infod(map[string]interface{}{
+			"endpoint": t.endpoint.CanonicalAddr(),
+			"path":     t.endpoint.HealthCheckPath,
+		}, "healthcheck.endpoint.healthy")
+	}
+
+	// pools are set every time, since a pool created after the endpoint
+	// was found unhealthy starts out with it in use
+	for _, pool := range t.pools {
+		pool.SetEndpointHealthy(t.endpoint, t.healthy)
+	}
+}
@@ -0,0 +1,135 @@
+package healthcheck_test
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/cloudfoundry/gorouter/config"
+	"github.com/cloudfoundry/gorouter/healthcheck"
+	"github.com/cloudfoundry/gorouter/route"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type fakePools []*route.Pool
+
+func (f fakePools) EachPool(fn func(pool *route.Pool)) {
+	for _, pool := range f {
+		fn(pool)
+	}
+}
+
+var _ = Describe("Checker", func() {
+	var pool *route.Pool
+	var checker *healthcheck.Checker
+	var status int32
+	var paths chan string
+	var server *httptest.Server
+	var checked, unchecked *route.Endpoint
+
+	newEndpoint := func(addr, path string) *route.Endpoint {
+		host, port, _ := net.SplitHostPort(addr)
+		p, _ := strconv.Atoi(port)
+
+		endpoint := route.NewEndpoint("", host, uint16(p), "", nil, -1)
+		endpoint.HealthCheckPath = path
+		return endpoint
+	}
+
+	// next returns the endpoints the pool hands out for n requests.
+	next := func(n int) []*route.Endpoint {
+		iter := pool.Endpoints("")
+		var endpoints []*route.Endpoint
+		for i := 0; i < n; i++ {
+			endpoints = append(endpoints, iter.Next())
+		}
+		return endpoints
+	}
+
+	BeforeEach(func() {
+		status = http.StatusOK
+		paths = make(chan string, 100)
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			paths <- r.URL.Path
+			w.WriteHeader(int(atomic.LoadInt32(&status)))
+		}))
+
+		checked = newEndpoint(server.Listener.Addr().String(), "health")
+		unchecked = newEndpoint("127.0.0.1:1", "")
+
+		pool = route.NewPool(time.Minute)
+		pool.Put(checked)
+		pool.Put(unchecked)
+
+		checker = healthcheck.NewChecker(fakePools{pool}, config.HealthCheckConfig{
+			Interval:           10 * time.Millisecond,
+			Timeout:            time.Second,
+			UnhealthyThreshold: 2,
+			HealthyThreshold:   2,
+		})
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("probes the health check path of endpoints that have one", func() {
+		checker.Check()
+
+		Ω(paths).To(Receive(Equal("/health")))
+		Ω(paths).ToNot(Receive())
+	})
+
+	It("takes an endpoint out of its pool after failing checks in a row", func() {
+		atomic.StoreInt32(&status, http.StatusServiceUnavailable)
+
+		checker.Check()
+		Ω(next(4)).To(ContainElement(checked))
+
+		checker.Check()
+		Ω(next(4)).To(ConsistOf(unchecked, unchecked, unchecked, unchecked))
+	})
+
+	It("keeps an endpoint out when it is registered again", func() {
+		atomic.StoreInt32(&status, http.StatusServiceUnavailable)
+		checker.Check()
+		checker.Check()
+
+		pool.Put(newEndpoint(server.Listener.Addr().String(), "health"))
+		Ω(next(4)).ToNot(ContainElement(checked))
+	})
+
+	It("puts an endpoint back after passing checks in a row", func() {
+		atomic.StoreInt32(&status, http.StatusServiceUnavailable)
+		checker.Check()
+		checker.Check()
+
+		atomic.StoreInt32(&status, http.StatusOK)
+		checker.Check()
+		Ω(next(4)).ToNot(ContainElement(checked))
+
+		checker.Check()
+		Ω(next(4)).To(ContainElement(checked))
+	})
+
+	It("counts endpoints that cannot be reached as failing", func() {
+		server.Close()
+		checker.Check()
+		checker.Check()
+
+		Ω(next(4)).ToNot(ContainElement(checked))
+	})
+
+	It("checks every interval until stopped", func() {
+		go checker.Run()
+
+		Eventually(paths).Should(Receive())
+		Eventually(paths).Should(Receive())
+
+		checker.Stop()
+	})
+})
@@ -0,0 +1,158 @@
+package healthdetail
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cloudfoundry/gorouter/clock"
+	"github.com/cloudfoundry/gorouter/telemetry"
+)
+
+// NATS checks that the NATS connection answers ping.
+func NATS(ping func() bool) Check {
+	return func() Result {
+		if !ping() {
+			return Result{Status: StatusFailing, Message: "nats does not answer pings"}
+		}
+		return Result{Status: StatusOK}
+	}
+}
+
+// RoutingApi checks that routes were fetched from the routing API within
+// staleAfter. It is degraded when the last fetch failed but the routes are
+// not stale yet.
+func RoutingApi(lastFetch func() (time.Time, error), staleAfter time.Duration, c clock.Clock) Check {
+	return func() Result {
+		fetched, err := lastFetch()
+
+		details := map[string]interface{}{}
+		if !fetched.IsZero() {
+			details["last_fetch"] = fetched
+		}
+		if err != nil {
+			details["last_error"] = err.Error()
+		}
+
+		switch {
+		case fetched.IsZero() && err == nil:
+			return Result{Status: StatusOK, Message: "waiting for the first fetch", Details: details}
+		case fetched.IsZero() || staleAfter > 0 && c.Since(fetched) > staleAfter:
+			return Result{Status: StatusFailing, Message: "routes are stale", Details: details}
+		case err != nil:
+			return Result{Status: StatusDegraded, Message: "the last fetch failed", Details: details}
+		}
+		return Result{Status: StatusOK, Details: details}
+	}
+}
+
+// Registry checks that routes were registered within staleAfter, as they
+// are every few seconds while the route emitters are connected, or since
+// the check was created, before the first of them.
+func Registry(lastUpdate func() time.Time, endpoints func() int, staleAfter time.Duration, c clock.Clock) Check {
+	created := c.Now()
+	return func() Result {
+		details := map[string]interface{}{
+			"endpoints": endpoints(),
+		}
+
+		since := lastUpdate()
+		if since.IsZero() {
+			since = created
+		} else {
+			details["last_update"] = since
+		}
+
+		if staleAfter > 0 && c.Since(since) > staleAfter {
+			return Result{Status: StatusFailing, Message: "no routes were registered lately", Details: details}
+		}
+		return Result{Status: StatusOK, Details: details}
+	}
+}
+
+type certificateExpiry struct {
+	NotAfter time.Time `json:"not_after"`
+	DaysLeft int       `json:"days_left"`
+}
+
+// Certificates checks that no certificate has expired, and is degraded
+// while one expires within warningDays.
+func Certificates(expiries func() map[string]time.Time, warningDays int, c clock.Clock) Check {
+	return func() Result {
+		details := make(map[string]certificateExpiry)
+		var expired, expiring []string
+		for name, notAfter := range expiries() {
+			left := notAfter.Sub(c.Now())
+			days := int(left / (24 * time.Hour))
+			details[name] = certificateExpiry{NotAfter: notAfter, DaysLeft: days}
+
+			switch {
+			case left <= 0:
+				expired = append(expired, name)
+			case days < warningDays:
+				expiring = append(expiring, name)
+			}
+		}
+
+		switch {
+		case len(expired) > 0:
+			return Result{Status: StatusFailing, Message: "expired: " + join(expired), Details: details}
+		case len(expiring) > 0:
+			return Result{Status: StatusDegraded, Message: fmt.Sprintf("expiring within %d days: %s", warningDays, join(expiring)), Details: details}
+		}
+		return Result{Status: StatusOK, Details: details}
+	}
+}
+
+// Telemetry checks that no telemetry sink is failing or stale.
+func Telemetry(h *telemetry.Health) Check {
+	return func() Result {
+		report := h.Report()
+
+		details := make(map[string]string, len(report.Sinks))
+		var unhealthy []string
+		for _, s := range report.Sinks {
+			details[s.Name] = s.Status
+			if s.Status == telemetry.StatusFailing || s.Status == telemetry.StatusStale {
+				unhealthy = append(unhealthy, s.Name)
+			}
+		}
+
+		if len(unhealthy) > 0 {
+			return Result{Status: StatusFailing, Message: "unhealthy sinks: " + join(unhealthy), Details: details}
+		}
+		return Result{Status: StatusOK, Details: details}
+	}
+}
+
+// Listeners checks that every listener of the router serves, but while the
+// router drains, when they are meant to stop.
+func Listeners(serving func() map[string]bool, draining func() bool) Check {
+	return func() Result {
+		states := serving()
+
+		details := make(map[string]string, len(states))
+		var stopped []string
+		for name, ok := range states {
+			details[name] = "serving"
+			if !ok {
+				details[name] = "stopped"
+				stopped = append(stopped, name)
+			}
+		}
+
+		switch {
+		case draining():
+			return Result{Status: StatusDegraded, Message: "draining", Details: details}
+		case len(stopped) > 0:
+			return Result{Status: StatusFailing, Message: "stopped: " + join(stopped), Details: details}
+		}
+		return Result{Status: StatusOK, Details: details}
+	}
+}
+
+func join(names []string) string {
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,160 @@
+// Package healthdetail reports on the state of each subsystem the router
+// depends on, and weighs them into an overall status, so that operators
+// and orchestrators can tell a router that cannot serve from one that
+// serves with some of its dependencies in trouble.
+package healthdetail
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/cloudfoundry/gorouter/clock"
+	"github.com/cloudfoundry/gorouter/config"
+)
+
+// The statuses of subsystems.
+const (
+	StatusOK       = "ok"
+	StatusDegraded = "degraded"
+	StatusFailing  = "failing"
+)
+
+// The overall statuses of the router.
+const (
+	Healthy   = "healthy"
+	Degraded  = "degraded"
+	Unhealthy = "unhealthy"
+)
+
+const checkTimeout = 5 * time.Second
+
+var errCheckTimedOut = errors.New("check timed out")
+
+// defaultSeverities are the severities of the subsystems the router cannot
+// serve without.
+var defaultSeverities = map[string]string{
+	"nats":      config.SeverityCritical,
+	"listeners": config.SeverityCritical,
+}
+
+// A Result is the state of a subsystem.
+type Result struct {
+	Status   string      `json:"status"`
+	Severity string      `json:"severity"`
+	Message  string      `json:"message,omitempty"`
+	Details  interface{} `json:"details,omitempty"`
+}
+
+// A Check finds out the state of a subsystem. The severity of its result
+// is set by the Detail.
+type Check func() Result
+
+type Report struct {
+	Status     string            `json:"status"`
+	Subsystems map[string]Result `json:"subsystems"`
+}
+
+// Detail is the list of the subsystems of the router. It serves a report
+// of them as JSON, running their checks first. The router is unhealthy
+// when a critical subsystem is failing, and degraded when any other
+// subsystem that is not ignored is failing or degraded.
+type Detail struct {
+	clock      clock.Clock
+	severities map[string]string
+
+	lock   sync.Mutex
+	names  []string
+	checks []Check
+}
+
+func New(c config.HealthDetailConfig, clk clock.Clock) *Detail {
+	return &Detail{
+		clock:      clk,
+		severities: c.Severities,
+	}
+}
+
+// Add has the subsystem called name checked by check.
+func (d *Detail) Add(name string, check Check) {
+	d.lock.Lock()
+	d.names = append(d.names, name)
+	d.checks = append(d.checks, check)
+	d.lock.Unlock()
+}
+
+func (d *Detail) severity(name string) string {
+	if severity, ok := d.severities[name]; ok {
+		return severity
+	}
+	if severity, ok := defaultSeverities[name]; ok {
+		return severity
+	}
+	return config.SeverityWarning
+}
+
+// Report runs the checks of the subsystems concurrently and reports on
+// them.
+func (d *Detail) Report() Report {
+	d.lock.Lock()
+	names := append([]string(nil), d.names...)
+	checks := append([]Check(nil), d.checks...)
+	d.lock.Unlock()
+
+	results := make([]Result, len(checks))
+	var wg sync.WaitGroup
+	for i, check := range checks {
+		wg.Add(1)
+		go func(i int, check Check) {
+			defer wg.Done()
+			results[i] = d.run(check)
+		}(i, check)
+	}
+	wg.Wait()
+
+	report := Report{Status: Healthy, Subsystems: make(map[string]Result, len(names))}
+	for i, name := range names {
+		r := results[i]
+		r.Severity = d.severity(name)
+		report.Subsystems[name] = r
+
+		if r.Severity == config.SeverityIgnore || r.Status == StatusOK {
+			continue
+		}
+		if r.Status == StatusFailing && r.Severity == config.SeverityCritical {
+			report.Status = Unhealthy
+		} else if report.Status == Healthy {
+			report.Status = Degraded
+		}
+	}
+
+	return report
+}
+
+func (d *Detail) run(check Check) Result {
+	done := make(chan Result, 1)
+	go func() {
+		done <- check()
+	}()
+
+	select {
+	case r := <-done:
+		return r
+	case <-d.clock.After(checkTimeout):
+		return Result{Status: StatusFailing, Message: errCheckTimedOut.Error()}
+	}
+}
+
+func (d *Detail) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	report := d.Report()
+
+	w.Header().Set("Content-Type", "application/json")
+	if report.Status == Unhealthy {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	} else {
+		w.WriteHeader(http.StatusOK)
+	}
+	json.NewEncoder(w).Encode(report)
+}
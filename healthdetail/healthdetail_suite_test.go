@@ -0,0 +1,13 @@
+package healthdetail_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestHealthDetail(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Health Detail Suite")
+}
@@ -0,0 +1,191 @@
+package healthdetail_test
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/cloudfoundry/gorouter/clock/fakeclock"
+	"github.com/cloudfoundry/gorouter/config"
+	"github.com/cloudfoundry/gorouter/healthdetail"
+	"github.com/cloudfoundry/gorouter/telemetry"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Detail", func() {
+	var fakeClock *fakeclock.FakeClock
+	var c config.HealthDetailConfig
+
+	BeforeEach(func() {
+		fakeClock = fakeclock.New(time.Unix(1500000000, 0))
+		c = config.HealthDetailConfig{}
+	})
+
+	status := func(s string) healthdetail.Check {
+		return func() healthdetail.Result {
+			return healthdetail.Result{Status: s}
+		}
+	}
+
+	It("is healthy when every subsystem is ok", func() {
+		d := healthdetail.New(c, fakeClock)
+		d.Add("nats", status(healthdetail.StatusOK))
+		d.Add("registry", status(healthdetail.StatusOK))
+
+		report := d.Report()
+		Ω(report.Status).To(Equal(healthdetail.Healthy))
+		Ω(report.Subsystems["nats"].Severity).To(Equal(config.SeverityCritical))
+		Ω(report.Subsystems["registry"].Severity).To(Equal(config.SeverityWarning))
+	})
+
+	It("is degraded by failing warnings and degraded subsystems, and unhealthy by failing critical ones", func() {
+		d := healthdetail.New(c, fakeClock)
+		d.Add("registry", status(healthdetail.StatusFailing))
+		d.Add("nats", status(healthdetail.StatusDegraded))
+		Ω(d.Report().Status).To(Equal(healthdetail.Degraded))
+
+		d.Add("listeners", status(healthdetail.StatusFailing))
+		Ω(d.Report().Status).To(Equal(healthdetail.Unhealthy))
+	})
+
+	It("weighs subsystems by the configured severities", func() {
+		c.Severities = map[string]string{
+			"nats":     config.SeverityIgnore,
+			"registry": config.SeverityCritical,
+		}
+		d := healthdetail.New(c, fakeClock)
+		d.Add("nats", status(healthdetail.StatusFailing))
+		Ω(d.Report().Status).To(Equal(healthdetail.Healthy))
+
+		d.Add("registry", status(healthdetail.StatusFailing))
+		Ω(d.Report().Status).To(Equal(healthdetail.Unhealthy))
+	})
+
+	It("fails subsystems whose checks time out", func() {
+		block := make(chan struct{})
+		defer close(block)
+
+		d := healthdetail.New(c, fakeClock)
+		d.Add("nats", func() healthdetail.Result {
+			<-block
+			return healthdetail.Result{Status: healthdetail.StatusOK}
+		})
+
+		reports := make(chan healthdetail.Report)
+		go func() { reports <- d.Report() }()
+		Eventually(fakeClock.WatcherCount).Should(Equal(1))
+		fakeClock.Increment(5 * time.Second)
+
+		var report healthdetail.Report
+		Eventually(reports).Should(Receive(&report))
+		Ω(report.Status).To(Equal(healthdetail.Unhealthy))
+		Ω(report.Subsystems["nats"].Message).To(Equal("check timed out"))
+	})
+
+	It("serves its report, with 503 when unhealthy", func() {
+		d := healthdetail.New(c, fakeClock)
+		d.Add("registry", status(healthdetail.StatusFailing))
+
+		w := httptest.NewRecorder()
+		d.ServeHTTP(w, &http.Request{})
+		Ω(w.Code).To(Equal(http.StatusOK))
+
+		var report healthdetail.Report
+		Ω(json.Unmarshal(w.Body.Bytes(), &report)).To(Succeed())
+		Ω(report.Status).To(Equal(healthdetail.Degraded))
+		Ω(report.Subsystems["registry"].Status).To(Equal(healthdetail.StatusFailing))
+
+		d.Add("nats", status(healthdetail.StatusFailing))
+		w = httptest.NewRecorder()
+		d.ServeHTTP(w, &http.Request{})
+		Ω(w.Code).To(Equal(http.StatusServiceUnavailable))
+	})
+
+	Describe("checks", func() {
+		It("checks nats by its pings", func() {
+			Ω(healthdetail.NATS(func() bool { return true })().Status).To(Equal(healthdetail.StatusOK))
+			Ω(healthdetail.NATS(func() bool { return false })().Status).To(Equal(healthdetail.StatusFailing))
+		})
+
+		It("checks the routing api by its last fetch", func() {
+			var fetched time.Time
+			var err error
+			check := healthdetail.RoutingApi(func() (time.Time, error) { return fetched, err }, time.Minute, fakeClock)
+			Ω(check().Status).To(Equal(healthdetail.StatusOK))
+
+			err = errors.New("unauthorized")
+			Ω(check().Status).To(Equal(healthdetail.StatusFailing))
+
+			fetched = fakeClock.Now()
+			Ω(check().Status).To(Equal(healthdetail.StatusDegraded))
+
+			err = nil
+			Ω(check().Status).To(Equal(healthdetail.StatusOK))
+
+			fakeClock.Increment(2 * time.Minute)
+			Ω(check().Status).To(Equal(healthdetail.StatusFailing))
+		})
+
+		It("checks the registry for stale routes", func() {
+			var updated time.Time
+			check := healthdetail.Registry(func() time.Time { return updated }, func() int { return 3 }, time.Minute, fakeClock)
+			Ω(check().Status).To(Equal(healthdetail.StatusOK))
+
+			fakeClock.Increment(2 * time.Minute)
+			Ω(check().Status).To(Equal(healthdetail.StatusFailing))
+
+			updated = fakeClock.Now()
+			r := check()
+			Ω(r.Status).To(Equal(healthdetail.StatusOK))
+			Ω(r.Details).To(HaveKeyWithValue("endpoints", 3))
+		})
+
+		It("counts down to the expiry of certificates", func() {
+			expiries := map[string]time.Time{"default": fakeClock.Now().Add(90 * 24 * time.Hour)}
+			check := healthdetail.Certificates(func() map[string]time.Time { return expiries }, 30, fakeClock)
+			Ω(check().Status).To(Equal(healthdetail.StatusOK))
+
+			expiries["app.example.com"] = fakeClock.Now().Add(10 * 24 * time.Hour)
+			r := check()
+			Ω(r.Status).To(Equal(healthdetail.StatusDegraded))
+			Ω(r.Message).To(ContainSubstring("app.example.com"))
+
+			b, err := json.Marshal(r.Details)
+			Ω(err).NotTo(HaveOccurred())
+			Ω(string(b)).To(ContainSubstring(`"app.example.com":{"not_after":`))
+			Ω(string(b)).To(ContainSubstring(`"days_left":10`))
+
+			expiries["api.example.com"] = fakeClock.Now().Add(-time.Hour)
+			Ω(check().Status).To(Equal(healthdetail.StatusFailing))
+		})
+
+		It("checks the telemetry sinks", func() {
+			h := telemetry.NewHealth(fakeClock)
+			sink := h.AddSink("kafka", 0, nil)
+			check := healthdetail.Telemetry(h)
+			Ω(check().Status).To(Equal(healthdetail.StatusOK))
+
+			sink.Failed(errors.New("connection refused"))
+			r := check()
+			Ω(r.Status).To(Equal(healthdetail.StatusFailing))
+			Ω(r.Details).To(Equal(map[string]string{"kafka": telemetry.StatusFailing}))
+		})
+
+		It("checks that the listeners serve, unless the router drains", func() {
+			states := map[string]bool{"http": true, "https": true}
+			draining := false
+			check := healthdetail.Listeners(func() map[string]bool { return states }, func() bool { return draining })
+			Ω(check().Status).To(Equal(healthdetail.StatusOK))
+
+			states["https"] = false
+			Ω(check().Status).To(Equal(healthdetail.StatusFailing))
+
+			draining = true
+			Ω(check().Status).To(Equal(healthdetail.StatusDegraded))
+		})
+	})
+})
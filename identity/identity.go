@@ -0,0 +1,115 @@
+// Package identity passes on who the client of a request is, as the load
+// balancers in front of the router established it, to backends in a header
+// of its own, whatever the load balancer that established it.
+package identity
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/cloudfoundry/gorouter/config"
+)
+
+// The headers an AWS Application Load Balancer sets on requests of users it
+// authenticated with OIDC.
+const (
+	AlbIdentityHeader    = "X-Amzn-Oidc-Identity"
+	AlbDataHeader        = "X-Amzn-Oidc-Data"
+	AlbAccessTokenHeader = "X-Amzn-Oidc-Accesstoken"
+)
+
+// A Policy trusts the identity headers of each of its sources on requests
+// from the load balancers of the source only. A nil Policy changes nothing.
+type Policy struct {
+	header       string
+	sourceHeader string
+	sources      []config.ClientIdentitySourceConfig
+}
+
+// New returns the policy of c, or nil when it has no sources.
+func New(c config.ClientIdentityConfig) *Policy {
+	if len(c.Sources) == 0 {
+		return nil
+	}
+
+	return &Policy{
+		header:       http.CanonicalHeaderKey(c.Header),
+		sourceHeader: http.CanonicalHeaderKey(c.SourceHeader),
+		sources:      c.Sources,
+	}
+}
+
+// Apply drops the headers of the sources that request did not come through,
+// and sets the identity header to the identity of the first source it did
+// come through that has one, and the source header to the type of that
+// source. The identity headers request arrived with are dropped first.
+func (p *Policy) Apply(request *http.Request) {
+	if p == nil {
+		return
+	}
+
+	request.Header.Del(p.header)
+	request.Header.Del(p.sourceHeader)
+
+	ip := remoteIP(request.RemoteAddr)
+
+	identity, source := "", ""
+	for _, s := range p.sources {
+		if !trusts(s, ip) {
+			for _, name := range headers(s) {
+				request.Header.Del(name)
+			}
+			continue
+		}
+
+		if identity == "" {
+			identity, source = identify(s, request.Header), s.Type
+		}
+	}
+
+	if identity != "" {
+		request.Header.Set(p.header, identity)
+		request.Header.Set(p.sourceHeader, source)
+	}
+}
+
+// headers are the request headers that s sets.
+func headers(s config.ClientIdentitySourceConfig) []string {
+	if s.Type == config.ClientIdentityAwsAlbOidc {
+		return []string{AlbIdentityHeader, AlbDataHeader, AlbAccessTokenHeader}
+	}
+	return []string{s.Header}
+}
+
+// identify returns the identity of the client of a request that s set
+// header on, or "" when it did not.
+func identify(s config.ClientIdentitySourceConfig, header http.Header) string {
+	switch s.Type {
+	case config.ClientIdentityAwsAlbOidc:
+		return strings.TrimSpace(header.Get(AlbIdentityHeader))
+	case config.ClientIdentityXfcc:
+		return xfccIdentity(header.Get(s.Header))
+	}
+	return strings.TrimSpace(header.Get(s.Header))
+}
+
+func trusts(s config.ClientIdentitySourceConfig, ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	for _, network := range s.TrustedNetworks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func remoteIP(remoteAddr string) net.IP {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	return net.ParseIP(host)
+}
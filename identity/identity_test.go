@@ -0,0 +1,112 @@
+package identity_test
+
+import (
+	"net/http"
+
+	"github.com/cloudfoundry/gorouter/config"
+	"github.com/cloudfoundry/gorouter/identity"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Policy", func() {
+	var c *config.Config
+
+	BeforeEach(func() {
+		c = config.DefaultConfig()
+		c.ClientIdentity.Sources = []config.ClientIdentitySourceConfig{
+			{Type: config.ClientIdentityAwsAlbOidc, TrustedProxies: []string{"10.0.0.0/8"}},
+			{Type: config.ClientIdentityXfcc, TrustedProxies: []string{"192.168.1.10"}},
+		}
+		c.Process()
+	})
+
+	request := func(remoteAddr string, header http.Header) *http.Request {
+		req, _ := http.NewRequest("GET", "http://app.example.com/", nil)
+		req.RemoteAddr = remoteAddr
+		for name, values := range header {
+			req.Header[name] = values
+		}
+		return req
+	}
+
+	It("passes on the identity a trusted load balancer established", func() {
+		req := request("10.0.1.2:5000", http.Header{
+			"X-Amzn-Oidc-Identity": {"user-123"},
+			"X-Amzn-Oidc-Data":     {"eyJ..."},
+		})
+		identity.New(c.ClientIdentity).Apply(req)
+
+		Ω(req.Header.Get("X-Client-Identity")).To(Equal("user-123"))
+		Ω(req.Header.Get("X-Client-Identity-Source")).To(Equal("aws_alb_oidc"))
+		Ω(req.Header.Get("X-Amzn-Oidc-Data")).To(Equal("eyJ..."))
+	})
+
+	It("drops the headers of a source the request did not come through", func() {
+		req := request("172.16.0.1:5000", http.Header{
+			"X-Amzn-Oidc-Identity":    {"admin"},
+			"X-Amzn-Oidc-Accesstoken": {"token"},
+			"X-Forwarded-Client-Cert": {`Subject="CN=admin"`},
+		})
+		identity.New(c.ClientIdentity).Apply(req)
+
+		Ω(req.Header).NotTo(HaveKey("X-Amzn-Oidc-Identity"))
+		Ω(req.Header).NotTo(HaveKey("X-Amzn-Oidc-Accesstoken"))
+		Ω(req.Header).NotTo(HaveKey("X-Forwarded-Client-Cert"))
+		Ω(req.Header).NotTo(HaveKey("X-Client-Identity"))
+	})
+
+	It("drops the identity headers the request arrived with", func() {
+		req := request("172.16.0.1:5000", http.Header{
+			"X-Client-Identity":        {"admin"},
+			"X-Client-Identity-Source": {"aws_alb_oidc"},
+		})
+		identity.New(c.ClientIdentity).Apply(req)
+
+		Ω(req.Header).NotTo(HaveKey("X-Client-Identity"))
+		Ω(req.Header).NotTo(HaveKey("X-Client-Identity-Source"))
+	})
+
+	It("takes the URI of the client certificate of the first XFCC element", func() {
+		req := request("192.168.1.10:5000", http.Header{
+			"X-Forwarded-Client-Cert": {`By=spiffe://mesh/router;Hash=abc;Subject="CN=client, O=Acme";URI=spiffe://mesh/client,By=spiffe://mesh/other;URI=spiffe://mesh/proxy`},
+		})
+		identity.New(c.ClientIdentity).Apply(req)
+
+		Ω(req.Header.Get("X-Client-Identity")).To(Equal("spiffe://mesh/client"))
+		Ω(req.Header.Get("X-Client-Identity-Source")).To(Equal("xfcc"))
+	})
+
+	It("takes the subject of a client certificate without a URI", func() {
+		req := request("192.168.1.10:5000", http.Header{
+			"X-Forwarded-Client-Cert": {`Hash=abc;Subject="CN=client, O=\"Acme, Inc\""`},
+		})
+		identity.New(c.ClientIdentity).Apply(req)
+
+		Ω(req.Header.Get("X-Client-Identity")).To(Equal(`CN=client, O="Acme, Inc"`))
+	})
+
+	It("takes a header that carries the identity as it is", func() {
+		c.ClientIdentity.Sources = []config.ClientIdentitySourceConfig{
+			{Type: config.ClientIdentityHeader, Header: "X-Ssl-Client-S-Dn", TrustedProxies: []string{"10.0.0.0/8"}},
+		}
+		c.Process()
+
+		req := request("10.0.1.2:5000", http.Header{"X-Ssl-Client-S-Dn": {"CN=client"}})
+		identity.New(c.ClientIdentity).Apply(req)
+
+		Ω(req.Header.Get("X-Client-Identity")).To(Equal("CN=client"))
+		Ω(req.Header.Get("X-Client-Identity-Source")).To(Equal("header"))
+	})
+
+	It("changes nothing without sources", func() {
+		c.ClientIdentity.Sources = nil
+		p := identity.New(c.ClientIdentity)
+		Ω(p).To(BeNil())
+
+		req := request("172.16.0.1:5000", http.Header{"X-Client-Identity": {"admin"}})
+		p.Apply(req)
+		Ω(req.Header.Get("X-Client-Identity")).To(Equal("admin"))
+	})
+})
@@ -0,0 +1,66 @@
+package identity
+
+import "strings"
+
+// xfccIdentity returns the identity of the client certificate of an
+// X-Forwarded-Client-Cert header: the URI of the certificate, such as a
+// SPIFFE ID, or else its subject, or else its first DNS name. The header
+// has an element for each proxy the request went through, and the first is
+// that of the proxy the client connected to.
+func xfccIdentity(value string) string {
+	elements := splitQuoted(value, ',')
+	if len(elements) == 0 {
+		return ""
+	}
+
+	fields := make(map[string]string)
+	for _, pair := range splitQuoted(elements[0], ';') {
+		i := strings.IndexByte(pair, '=')
+		if i < 0 {
+			continue
+		}
+		key := strings.ToLower(strings.TrimSpace(pair[:i]))
+		if _, ok := fields[key]; !ok {
+			fields[key] = unquote(strings.TrimSpace(pair[i+1:]))
+		}
+	}
+
+	for _, key := range []string{"uri", "subject", "dns"} {
+		if fields[key] != "" {
+			return fields[key]
+		}
+	}
+	return ""
+}
+
+// splitQuoted splits s at every separator outside double quotes.
+func splitQuoted(s string, separator byte) []string {
+	var parts []string
+	quoted, escaped := false, false
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case escaped:
+			escaped = false
+		case c == '\\':
+			escaped = true
+		case c == '"':
+			quoted = !quoted
+		case c == separator && !quoted:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	if strings.TrimSpace(s[start:]) != "" || len(parts) > 0 {
+		parts = append(parts, s[start:])
+	}
+	return parts
+}
+
+func unquote(s string) string {
+	if len(s) < 2 || s[0] != '"' || s[len(s)-1] != '"' {
+		return s
+	}
+	s = s[1 : len(s)-1]
+	return strings.NewReplacer(`\"`, `"`, `\\`, `\`).Replace(s)
+}
@@ -0,0 +1,101 @@
+package inspection
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+)
+
+var (
+	ErrBodyTooLarge        = errors.New("body is too large to inspect")
+	ErrDecompressionBomb   = errors.New("body decompresses beyond its limits")
+	ErrUnsupportedEncoding = errors.New("body has an unsupported content encoding")
+)
+
+// Limits bound the work of reading a body for inspection. MaxBodyBytes caps
+// the body as received. Decoding stops at MaxDecodedBytes, or at MaxRatio
+// times the size of the encoded body, whichever is smaller, so that a small
+// body cannot expand into a large one.
+type Limits struct {
+	MaxBodyBytes    int64
+	MaxDecodedBytes int64
+	MaxRatio        int64
+}
+
+var DefaultLimits = Limits{
+	MaxBodyBytes:    1024 * 1024,
+	MaxDecodedBytes: 8 * 1024 * 1024,
+	MaxRatio:        100,
+}
+
+// Decode undoes the content codings listed in contentEncoding, which were
+// applied in the order listed. Only gzip and deflate are supported; there
+// is no brotli decoder, so brotli bodies cannot be inspected.
+func Decode(contentEncoding string, body []byte, limits Limits) ([]byte, error) {
+	codings := strings.Split(contentEncoding, ",")
+	for i := len(codings) - 1; i >= 0; i-- {
+		coding := strings.ToLower(strings.TrimSpace(codings[i]))
+
+		var err error
+		switch coding {
+		case "", "identity":
+			continue
+		case "gzip", "x-gzip":
+			body, err = decode(body, limits, func(r io.Reader) (io.Reader, error) {
+				return gzip.NewReader(r)
+			})
+		case "deflate":
+			body, err = decode(body, limits, newDeflateReader)
+		default:
+			return nil, ErrUnsupportedEncoding
+		}
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return body, nil
+}
+
+// newDeflateReader reads deflate bodies in the zlib format the standard
+// asks for, and in the raw format that some clients send instead.
+func newDeflateReader(r io.Reader) (io.Reader, error) {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	z, err := zlib.NewReader(bytes.NewReader(b))
+	if err == nil {
+		return z, nil
+	}
+	return flate.NewReader(bytes.NewReader(b)), nil
+}
+
+func decode(body []byte, limits Limits, newReader func(io.Reader) (io.Reader, error)) ([]byte, error) {
+	r, err := newReader(bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	max := limits.MaxDecodedBytes
+	if ratioMax := int64(len(body)) * limits.MaxRatio; ratioMax < max {
+		max = ratioMax
+	}
+
+	decoded, err := ioutil.ReadAll(io.LimitReader(r, max+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(decoded)) > max {
+		return nil, ErrDecompressionBomb
+	}
+
+	return decoded, nil
+}
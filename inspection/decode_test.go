@@ -0,0 +1,87 @@
+package inspection_test
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+
+	"github.com/cloudfoundry/gorouter/inspection"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func compress(b []byte, newWriter func(io.Writer) io.WriteCloser) []byte {
+	var buf bytes.Buffer
+	w := newWriter(&buf)
+	w.Write(b)
+	w.Close()
+	return buf.Bytes()
+}
+
+func gzipped(b []byte) []byte {
+	return compress(b, func(w io.Writer) io.WriteCloser { return gzip.NewWriter(w) })
+}
+
+var _ = Describe("Decode", func() {
+	var limits inspection.Limits
+
+	BeforeEach(func() {
+		limits = inspection.DefaultLimits
+	})
+
+	It("returns bodies without a content coding as they are", func() {
+		Ω(inspection.Decode("", []byte("plain"), limits)).To(Equal([]byte("plain")))
+		Ω(inspection.Decode("identity", []byte("plain"), limits)).To(Equal([]byte("plain")))
+	})
+
+	It("decodes gzip", func() {
+		Ω(inspection.Decode("gzip", gzipped([]byte("hello")), limits)).To(Equal([]byte("hello")))
+		Ω(inspection.Decode("X-Gzip", gzipped([]byte("hello")), limits)).To(Equal([]byte("hello")))
+	})
+
+	It("decodes deflate in the zlib format", func() {
+		body := compress([]byte("hello"), func(w io.Writer) io.WriteCloser { return zlib.NewWriter(w) })
+		Ω(inspection.Decode("deflate", body, limits)).To(Equal([]byte("hello")))
+	})
+
+	It("decodes raw deflate", func() {
+		body := compress([]byte("hello"), func(w io.Writer) io.WriteCloser {
+			fw, _ := flate.NewWriter(w, flate.DefaultCompression)
+			return fw
+		})
+		Ω(inspection.Decode("deflate", body, limits)).To(Equal([]byte("hello")))
+	})
+
+	It("undoes several codings in reverse order", func() {
+		body := compress(gzipped([]byte("hello")), func(w io.Writer) io.WriteCloser { return zlib.NewWriter(w) })
+		Ω(inspection.Decode("gzip, deflate", body, limits)).To(Equal([]byte("hello")))
+	})
+
+	It("does not support brotli", func() {
+		_, err := inspection.Decode("br", []byte("compressed"), limits)
+		Ω(err).To(Equal(inspection.ErrUnsupportedEncoding))
+	})
+
+	It("fails on corrupt bodies", func() {
+		_, err := inspection.Decode("gzip", []byte("not gzip"), limits)
+		Ω(err).To(HaveOccurred())
+	})
+
+	It("stops at the compression ratio limit", func() {
+		body := gzipped(make([]byte, 64*1024))
+		limits.MaxRatio = 10
+
+		_, err := inspection.Decode("gzip", body, limits)
+		Ω(err).To(Equal(inspection.ErrDecompressionBomb))
+	})
+
+	It("stops at the decoded size limit", func() {
+		body := gzipped(bytes.Repeat([]byte("abcdefgh"), 1024))
+		limits.MaxDecodedBytes = 1024
+
+		_, err := inspection.Decode("gzip", body, limits)
+		Ω(err).To(Equal(inspection.ErrDecompressionBomb))
+	})
+})
@@ -0,0 +1,112 @@
+package inspection
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+
+	"github.com/cloudfoundry/gorouter/config"
+)
+
+var ErrDeniedPattern = errors.New("body matches a denied pattern")
+
+// An Inspector looks at a request along with its decoded body, and returns
+// an error to have the request rejected.
+type Inspector interface {
+	Inspect(request *http.Request, body []byte) error
+}
+
+// Stage runs inspectors on the requests the router proxies. A nil stage
+// inspects nothing.
+type Stage struct {
+	inspectors []Inspector
+	limits     Limits
+}
+
+func NewStage(limits Limits, inspectors ...Inspector) *Stage {
+	return &Stage{
+		inspectors: inspectors,
+		limits:     limits,
+	}
+}
+
+// New returns the stage configured by c, which refuses the requests whose
+// decoded body matches any of its deny patterns, or nil when inspection is
+// not enabled.
+func New(c config.InspectionConfig) *Stage {
+	if !c.Enabled {
+		return nil
+	}
+
+	limits := Limits{
+		MaxBodyBytes:    c.MaxBodyBytes,
+		MaxDecodedBytes: c.MaxDecodedBytes,
+		MaxRatio:        c.MaxRatio,
+	}
+	return NewStage(limits, DenyPatterns(c.DenyRegexps))
+}
+
+// DenyPatterns is an inspector that refuses the requests whose body
+// matches any of its regular expressions.
+type DenyPatterns []*regexp.Regexp
+
+func (d DenyPatterns) Inspect(request *http.Request, body []byte) error {
+	for _, re := range d {
+		if re.Match(body) {
+			return ErrDeniedPattern
+		}
+	}
+	return nil
+}
+
+// Inspect hands request and its decoded body to each inspector in turn and
+// returns the first error. The body is read in full and then replaced by a
+// reader of the same bytes, so the backend receives them as the client sent
+// them, still encoded.
+func (s *Stage) Inspect(request *http.Request) error {
+	if s == nil || len(s.inspectors) == 0 {
+		return nil
+	}
+
+	body, err := ReadBody(request, s.limits)
+	if err != nil {
+		return err
+	}
+
+	for _, inspector := range s.inspectors {
+		err = inspector.Inspect(request, body)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ReadBody returns the decoded body of request and leaves the original
+// bytes for the request to send on.
+func ReadBody(request *http.Request, limits Limits) ([]byte, error) {
+	if request.Body == nil || request.ContentLength == 0 {
+		return nil, nil
+	}
+	if request.ContentLength > limits.MaxBodyBytes {
+		return nil, ErrBodyTooLarge
+	}
+
+	raw, err := ioutil.ReadAll(io.LimitReader(request.Body, limits.MaxBodyBytes+1))
+	if err != nil {
+		return nil, err
+	}
+
+	request.Body.Close()
+	request.Body = ioutil.NopCloser(bytes.NewReader(raw))
+
+	if int64(len(raw)) > limits.MaxBodyBytes {
+		return nil, ErrBodyTooLarge
+	}
+
+	return Decode(request.Header.Get("Content-Encoding"), raw, limits)
+}
@@ -0,0 +1,13 @@
+package inspection_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestInspection(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Inspection Suite")
+}
@@ -0,0 +1,104 @@
+package inspection_test
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+
+	"github.com/cloudfoundry/gorouter/config"
+	"github.com/cloudfoundry/gorouter/inspection"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type recordingInspector struct {
+	bodies []string
+	err    error
+}
+
+func (r *recordingInspector) Inspect(request *http.Request, body []byte) error {
+	r.bodies = append(r.bodies, string(body))
+	return r.err
+}
+
+var _ = Describe("Stage", func() {
+	var first, second *recordingInspector
+	var stage *inspection.Stage
+	var request *http.Request
+
+	BeforeEach(func() {
+		first = &recordingInspector{}
+		second = &recordingInspector{}
+		stage = inspection.NewStage(inspection.DefaultLimits, first, second)
+
+		body := gzipped([]byte("hello"))
+		request, _ = http.NewRequest("POST", "http://app.example.com/", bytes.NewReader(body))
+		request.Header.Set("Content-Encoding", "gzip")
+	})
+
+	It("hands the decoded body to every inspector", func() {
+		Ω(stage.Inspect(request)).To(Succeed())
+
+		Ω(first.bodies).To(Equal([]string{"hello"}))
+		Ω(second.bodies).To(Equal([]string{"hello"}))
+	})
+
+	It("leaves the original bytes in the request", func() {
+		stage.Inspect(request)
+
+		body, _ := ioutil.ReadAll(request.Body)
+		Ω(body).To(Equal(gzipped([]byte("hello"))))
+	})
+
+	It("stops at the first inspector that refuses the request", func() {
+		first.err = errors.New("refused")
+
+		Ω(stage.Inspect(request)).To(MatchError("refused"))
+		Ω(second.bodies).To(BeEmpty())
+	})
+
+	It("refuses bodies over the size limit", func() {
+		stage = inspection.NewStage(inspection.Limits{MaxBodyBytes: 4, MaxDecodedBytes: 1024, MaxRatio: 100}, first)
+
+		Ω(stage.Inspect(request)).To(Equal(inspection.ErrBodyTooLarge))
+		Ω(first.bodies).To(BeEmpty())
+	})
+
+	It("refuses bodies over the size limit without a content length", func() {
+		stage = inspection.NewStage(inspection.Limits{MaxBodyBytes: 4, MaxDecodedBytes: 1024, MaxRatio: 100}, first)
+		request.ContentLength = -1
+
+		Ω(stage.Inspect(request)).To(Equal(inspection.ErrBodyTooLarge))
+	})
+
+	It("inspects nothing when nil", func() {
+		stage = nil
+		Ω(stage.Inspect(request)).To(Succeed())
+	})
+})
+
+var _ = Describe("New", func() {
+	It("is nil unless inspection is enabled", func() {
+		Ω(inspection.New(config.InspectionConfig{})).To(BeNil())
+	})
+
+	It("refuses requests whose decoded body matches a deny pattern", func() {
+		stage := inspection.New(config.InspectionConfig{
+			Enabled:         true,
+			MaxBodyBytes:    1024,
+			MaxDecodedBytes: 4096,
+			MaxRatio:        100,
+			DenyRegexps:     []*regexp.Regexp{regexp.MustCompile("(?i)<script")},
+		})
+
+		request, _ := http.NewRequest("POST", "http://app.example.com/", bytes.NewReader(gzipped([]byte("name=<SCRIPT>"))))
+		request.Header.Set("Content-Encoding", "gzip")
+		Ω(stage.Inspect(request)).To(Equal(inspection.ErrDeniedPattern))
+
+		request, _ = http.NewRequest("POST", "http://app.example.com/", bytes.NewReader(gzipped([]byte("name=value"))))
+		request.Header.Set("Content-Encoding", "gzip")
+		Ω(stage.Inspect(request)).To(Succeed())
+	})
+})
@@ -0,0 +1,138 @@
+package integration
+
+import (
+	"bufio"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// BackendSpec scripts how a fake backend behaves. It answers requests with
+// Status and Body after LatencyInMs, closes connections without answering
+// when Drop is set, and when WebSocket is set it accepts upgrades and then
+// echoes back whatever it receives.
+type BackendSpec struct {
+	Name        string `yaml:"name"`
+	Status      int    `yaml:"status"`
+	Body        string `yaml:"body"`
+	LatencyInMs int    `yaml:"latency_ms"`
+	Drop        bool   `yaml:"drop"`
+	WebSocket   bool   `yaml:"websocket"`
+}
+
+// Backend is a fake backend listening on the loopback interface.
+type Backend struct {
+	spec     BackendSpec
+	listener net.Listener
+	requests int64
+	wg       sync.WaitGroup
+}
+
+func StartBackend(spec BackendSpec) (*Backend, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+
+	if spec.Status == 0 {
+		spec.Status = http.StatusOK
+	}
+
+	b := &Backend{spec: spec, listener: l}
+
+	b.wg.Add(1)
+	go b.accept()
+
+	return b, nil
+}
+
+func (b *Backend) Name() string {
+	return b.spec.Name
+}
+
+func (b *Backend) Host() string {
+	return "127.0.0.1"
+}
+
+func (b *Backend) Port() uint16 {
+	return uint16(b.listener.Addr().(*net.TCPAddr).Port)
+}
+
+// Requests returns how many requests reached the backend.
+func (b *Backend) Requests() int {
+	return int(atomic.LoadInt64(&b.requests))
+}
+
+func (b *Backend) Stop() {
+	b.listener.Close()
+	b.wg.Wait()
+}
+
+func (b *Backend) accept() {
+	defer b.wg.Done()
+
+	for {
+		conn, err := b.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		go b.serve(conn)
+	}
+}
+
+func (b *Backend) serve(conn net.Conn) {
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+	for {
+		req, err := http.ReadRequest(r)
+		if err != nil {
+			return
+		}
+		io.Copy(ioutil.Discard, req.Body)
+		atomic.AddInt64(&b.requests, 1)
+
+		if b.spec.Drop {
+			return
+		}
+
+		time.Sleep(time.Duration(b.spec.LatencyInMs) * time.Millisecond)
+
+		if b.spec.WebSocket && req.Header.Get("Upgrade") != "" {
+			res := &http.Response{
+				StatusCode: http.StatusSwitchingProtocols,
+				ProtoMajor: 1,
+				ProtoMinor: 1,
+				Header: http.Header{
+					"Upgrade":    {req.Header.Get("Upgrade")},
+					"Connection": {"Upgrade"},
+				},
+			}
+			res.Write(conn)
+
+			// frames are relayed untouched, so echoing bytes echoes frames
+			io.Copy(conn, r)
+			return
+		}
+
+		res := &http.Response{
+			StatusCode:    b.spec.Status,
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        http.Header{"Content-Length": {strconv.Itoa(len(b.spec.Body))}},
+			ContentLength: int64(len(b.spec.Body)),
+			Body:          ioutil.NopCloser(strings.NewReader(b.spec.Body)),
+		}
+		err = res.Write(conn)
+		if err != nil || req.Close {
+			return
+		}
+	}
+}
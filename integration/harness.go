@@ -0,0 +1,288 @@
+// Package integration runs the router end to end against an in-process NATS
+// server and scripted fake backends, so that changes to its behaviour can be
+// checked without a Cloud Foundry deployment. Scenarios are described in
+// YAML files; see Scenario.
+package integration
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/cloudfoundry/yagnats"
+
+	"github.com/cloudfoundry/gorouter/access_log"
+	vcap "github.com/cloudfoundry/gorouter/common"
+	"github.com/cloudfoundry/gorouter/config"
+	"github.com/cloudfoundry/gorouter/proxy"
+	"github.com/cloudfoundry/gorouter/registry"
+	"github.com/cloudfoundry/gorouter/route"
+	"github.com/cloudfoundry/gorouter/router"
+	"github.com/cloudfoundry/gorouter/test_util"
+	"github.com/cloudfoundry/gorouter/varz"
+)
+
+// How long the harness waits for registrations to reach the router.
+const settleTimeout = 5 * time.Second
+
+var errNotSettled = errors.New("router did not pick up the registration in time")
+
+// Harness is a running router with its own NATS server and backends.
+type Harness struct {
+	Config *config.Config
+
+	nats     *NatsServer
+	mbus     yagnats.NATSConn
+	registry *registry.RouteRegistry
+	router   *router.Router
+	client   *http.Client
+
+	backends map[string]*Backend
+}
+
+// StartHarness starts a NATS server and a router that uses it. configure,
+// when it is not nil, adjusts the router's config before it starts.
+func StartHarness(configure func(*config.Config)) (*Harness, error) {
+	nats, err := StartNatsServer()
+	if err != nil {
+		return nil, err
+	}
+
+	h := &Harness{
+		nats:     nats,
+		backends: make(map[string]*Backend),
+		client: &http.Client{
+			Transport: &http.Transport{DisableKeepAlives: true},
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}
+
+	h.Config = test_util.SpecConfig(nats.Port(), test_util.NextAvailPort(), test_util.NextAvailPort())
+	h.Config.StartResponseDelayInterval = 0
+	if configure != nil {
+		configure(h.Config)
+	}
+
+	h.mbus, err = yagnats.Connect(h.Config.NatsServers())
+	if err != nil {
+		h.Stop()
+		return nil, err
+	}
+
+	h.registry = registry.NewRouteRegistry(h.Config, h.mbus)
+	v := varz.NewVarz(h.registry)
+	p := proxy.NewProxy(proxy.ProxyArgs{
+		EndpointTimeout: h.Config.EndpointTimeout,
+		Ip:              h.Config.Ip,
+		TraceKey:        h.Config.TraceKey,
+		Registry:        h.registry,
+		Reporter:        v,
+		AccessLogger:    &access_log.NullAccessLogger{},
+
+		BackendKeepAlive:       h.Config.BackendConnections.KeepAlive,
+		MaxIdleConnsPerBackend: h.Config.BackendConnections.MaxIdlePerBackend,
+		MaxConnsPerBackend:     h.Config.BackendConnections.MaxPerBackend,
+		BackendIdleTimeout:     h.Config.BackendConnections.IdleTimeout,
+
+		TrustForwardedHeaders: h.Config.ForwardedHeaders.Policy == config.ForwardedHeadersAppend,
+		TrustedProxies:        h.Config.ForwardedHeaders.TrustedNetworks,
+	})
+
+	h.router, err = router.NewRouter(h.Config, p, h.mbus, h.registry, v, vcap.NewLogCounter())
+	if err != nil {
+		h.Stop()
+		return nil, err
+	}
+
+	errChan := h.router.Run()
+	select {
+	case err = <-errChan:
+		h.Stop()
+		return nil, err
+	default:
+	}
+
+	err = h.waitForRouter()
+	if err != nil {
+		h.Stop()
+		return nil, err
+	}
+
+	return h, nil
+}
+
+// Stop stops the router, its backends and the NATS server.
+func (h *Harness) Stop() {
+	if h.router != nil {
+		h.router.Stop()
+	}
+	if h.mbus != nil {
+		h.mbus.Close()
+	}
+	for _, b := range h.backends {
+		b.Stop()
+	}
+	h.nats.Stop()
+}
+
+// AddBackend starts a backend scripted by spec. It can be found by its name
+// afterwards.
+func (h *Harness) AddBackend(spec BackendSpec) (*Backend, error) {
+	if _, found := h.backends[spec.Name]; found {
+		return nil, fmt.Errorf("backend %q exists already", spec.Name)
+	}
+
+	b, err := StartBackend(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	h.backends[spec.Name] = b
+	return b, nil
+}
+
+func (h *Harness) Backend(name string) *Backend {
+	return h.backends[name]
+}
+
+// Register registers the backends for uri over NATS and waits until the
+// router routes to all of them.
+func (h *Harness) Register(uri string, backends ...*Backend) error {
+	for _, b := range backends {
+		err := h.publish("router.register", uri, b)
+		if err != nil {
+			return err
+		}
+	}
+
+	return h.waitFor(func() bool {
+		return h.routesTo(uri, backends) == len(backends)
+	})
+}
+
+// Unregister unregisters the backends from uri over NATS and waits until the
+// router no longer routes to them.
+func (h *Harness) Unregister(uri string, backends ...*Backend) error {
+	for _, b := range backends {
+		err := h.publish("router.unregister", uri, b)
+		if err != nil {
+			return err
+		}
+	}
+
+	return h.waitFor(func() bool {
+		return h.routesTo(uri, backends) == 0
+	})
+}
+
+// Do sends request to the router.
+func (h *Harness) Do(request *http.Request) (*http.Response, error) {
+	request.URL.Scheme = "http"
+	request.URL.Host = h.address()
+	return h.client.Do(request)
+}
+
+// WebSocket opens a WebSocket connection to host through the router, sends
+// message on it as a line and returns the line that comes back.
+func (h *Harness) WebSocket(host, message string) (string, error) {
+	conn, err := net.DialTimeout("tcp", h.address(), time.Second)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(settleTimeout))
+
+	request, err := http.NewRequest("GET", "http://"+host+"/", nil)
+	if err != nil {
+		return "", err
+	}
+	request.Header.Set("Connection", "Upgrade")
+	request.Header.Set("Upgrade", "websocket")
+
+	err = request.Write(conn)
+	if err != nil {
+		return "", err
+	}
+
+	r := bufio.NewReader(conn)
+	res, err := http.ReadResponse(r, request)
+	if err != nil {
+		return "", err
+	}
+	if res.StatusCode != http.StatusSwitchingProtocols {
+		return "", fmt.Errorf("upgrade was answered with %d", res.StatusCode)
+	}
+
+	_, err = conn.Write([]byte(message + "\n"))
+	if err != nil {
+		return "", err
+	}
+
+	line, err := r.ReadString('\n')
+	return strings.TrimSuffix(line, "\n"), err
+}
+
+func (h *Harness) address() string {
+	return fmt.Sprintf("127.0.0.1:%d", h.Config.Port)
+}
+
+func (h *Harness) publish(subject, uri string, b *Backend) error {
+	msg, err := json.Marshal(map[string]interface{}{
+		"host": b.Host(),
+		"port": b.Port(),
+		"uris": []string{uri},
+		"app":  b.Name(),
+	})
+	if err != nil {
+		return err
+	}
+
+	return h.mbus.Publish(subject, msg)
+}
+
+// routesTo counts how many of backends the router has registered for uri.
+func (h *Harness) routesTo(uri string, backends []*Backend) int {
+	pool := h.registry.Lookup(route.Uri(uri))
+	if pool == nil {
+		return 0
+	}
+
+	n := 0
+	pool.Each(func(e *route.Endpoint) {
+		for _, b := range backends {
+			if e.CanonicalAddr() == fmt.Sprintf("%s:%d", b.Host(), b.Port()) {
+				n++
+			}
+		}
+	})
+	return n
+}
+
+func (h *Harness) waitForRouter() error {
+	return h.waitFor(func() bool {
+		conn, err := net.Dial("tcp", h.address())
+		if err != nil {
+			return false
+		}
+		conn.Close()
+		return true
+	})
+}
+
+func (h *Harness) waitFor(done func() bool) error {
+	deadline := time.Now().Add(settleTimeout)
+	for !done() {
+		if time.Now().After(deadline) {
+			return errNotSettled
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return nil
+}
@@ -0,0 +1,285 @@
+package integration
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// NatsServer is an in-process NATS server, enough of one for the router and
+// the harness to talk over: it accepts any credentials and relays published
+// messages to the subscriptions, including wildcard and queue subscriptions,
+// of every client.
+type NatsServer struct {
+	sync.Mutex
+
+	listener net.Listener
+	clients  map[*natsClient]struct{}
+	wg       sync.WaitGroup
+}
+
+type natsClient struct {
+	conn net.Conn
+
+	writeLock sync.Mutex
+	writer    *bufio.Writer
+
+	subs map[string]*natsSubscription
+}
+
+type natsSubscription struct {
+	client  *natsClient
+	subject string
+	queue   string
+	sid     string
+	max     int
+	count   int
+}
+
+// StartNatsServer starts a server listening on a free port of the loopback
+// interface.
+func StartNatsServer() (*NatsServer, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+
+	s := &NatsServer{
+		listener: l,
+		clients:  make(map[*natsClient]struct{}),
+	}
+
+	s.wg.Add(1)
+	go s.accept()
+
+	return s, nil
+}
+
+func (s *NatsServer) Port() uint16 {
+	return uint16(s.listener.Addr().(*net.TCPAddr).Port)
+}
+
+// Stop closes the server and the connections of its clients.
+func (s *NatsServer) Stop() {
+	s.listener.Close()
+
+	s.Lock()
+	for c := range s.clients {
+		c.conn.Close()
+	}
+	s.Unlock()
+
+	s.wg.Wait()
+}
+
+func (s *NatsServer) accept() {
+	defer s.wg.Done()
+
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		c := &natsClient{
+			conn:   conn,
+			writer: bufio.NewWriter(conn),
+			subs:   make(map[string]*natsSubscription),
+		}
+
+		s.Lock()
+		s.clients[c] = struct{}{}
+		s.Unlock()
+
+		s.wg.Add(1)
+		go s.serve(c)
+	}
+}
+
+func (s *NatsServer) serve(c *natsClient) {
+	defer s.wg.Done()
+	defer func() {
+		s.Lock()
+		delete(s.clients, c)
+		s.Unlock()
+		c.conn.Close()
+	}()
+
+	info, _ := json.Marshal(map[string]interface{}{
+		"server_id":     "integration",
+		"version":       "0.0.0",
+		"auth_required": false,
+		"ssl_required":  false,
+		"max_payload":   1024 * 1024,
+	})
+	c.write("INFO " + string(info) + "\r\n")
+
+	r := bufio.NewReader(c.conn)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		switch strings.ToUpper(fields[0]) {
+		case "CONNECT":
+		case "PING":
+			c.write("PONG\r\n")
+		case "PONG":
+		case "SUB":
+			s.subscribe(c, fields[1:])
+		case "UNSUB":
+			s.unsubscribe(c, fields[1:])
+		case "PUB":
+			err = s.publish(r, fields[1:])
+			if err != nil {
+				c.write("-ERR '" + err.Error() + "'\r\n")
+				return
+			}
+		default:
+			c.write("-ERR 'Unknown Protocol Operation'\r\n")
+		}
+	}
+}
+
+// SUB <subject> [queue group] <sid>
+func (s *NatsServer) subscribe(c *natsClient, args []string) {
+	sub := &natsSubscription{client: c, subject: args[0]}
+	switch len(args) {
+	case 2:
+		sub.sid = args[1]
+	case 3:
+		sub.queue = args[1]
+		sub.sid = args[2]
+	default:
+		return
+	}
+
+	s.Lock()
+	c.subs[sub.sid] = sub
+	s.Unlock()
+}
+
+// UNSUB <sid> [max messages]
+func (s *NatsServer) unsubscribe(c *natsClient, args []string) {
+	if len(args) == 0 {
+		return
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	sub := c.subs[args[0]]
+	if sub == nil {
+		return
+	}
+
+	if len(args) > 1 {
+		max, _ := strconv.Atoi(args[1])
+		if max > sub.count {
+			sub.max = max
+			return
+		}
+	}
+	delete(c.subs, sub.sid)
+}
+
+// PUB <subject> [reply to] <size>, followed by the payload.
+func (s *NatsServer) publish(r *bufio.Reader, args []string) error {
+	if len(args) < 2 {
+		return fmt.Errorf("Invalid Publish Arguments")
+	}
+
+	subject, reply := args[0], ""
+	if len(args) == 3 {
+		reply = args[1]
+	}
+	size, err := strconv.Atoi(args[len(args)-1])
+	if err != nil {
+		return err
+	}
+
+	payload := make([]byte, size+2)
+	_, err = io.ReadFull(r, payload)
+	if err != nil {
+		return err
+	}
+	payload = payload[:size]
+
+	for _, sub := range s.match(subject) {
+		msg := "MSG " + subject + " " + sub.sid + " "
+		if reply != "" {
+			msg += reply + " "
+		}
+		msg += strconv.Itoa(size) + "\r\n" + string(payload) + "\r\n"
+		sub.client.write(msg)
+	}
+
+	return nil
+}
+
+// match returns the subscriptions a message to subject goes to: every
+// matching subscription outside a queue group, and one of each group.
+func (s *NatsServer) match(subject string) []*natsSubscription {
+	s.Lock()
+	defer s.Unlock()
+
+	var matched []*natsSubscription
+	queues := make(map[string]bool)
+	for c := range s.clients {
+		for _, sub := range c.subs {
+			if !subjectMatches(sub.subject, subject) {
+				continue
+			}
+
+			if sub.queue != "" {
+				if queues[sub.queue] {
+					continue
+				}
+				queues[sub.queue] = true
+			}
+
+			sub.count++
+			if sub.max > 0 && sub.count >= sub.max {
+				delete(c.subs, sub.sid)
+			}
+			matched = append(matched, sub)
+		}
+	}
+
+	return matched
+}
+
+func subjectMatches(pattern, subject string) bool {
+	p := strings.Split(pattern, ".")
+	t := strings.Split(subject, ".")
+
+	for i, token := range p {
+		if token == ">" {
+			return len(t) > i
+		}
+		if i >= len(t) || (token != "*" && token != t[i]) {
+			return false
+		}
+	}
+
+	return len(p) == len(t)
+}
+
+func (c *natsClient) write(s string) {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+
+	c.writer.WriteString(s)
+	c.writer.Flush()
+}
@@ -0,0 +1,256 @@
+package integration
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/cloudfoundry-incubator/candiedyaml"
+
+	"github.com/cloudfoundry/gorouter/config"
+)
+
+// Scenario is a script for the harness: the backends to start, the routes to
+// register for them, and the steps to take against the router afterwards. A
+// scenario file looks like
+//
+//	name: fails over to the healthy backend
+//	config:
+//	  endpoint_timeout_ms: 200
+//	backends:
+//	- name: healthy
+//	  body: ok
+//	- name: broken
+//	  drop: true
+//	routes:
+//	- uri: app.vcap.me
+//	  backends: [healthy, broken]
+//	steps:
+//	- request:
+//	    host: app.vcap.me
+//	    repeat: 4
+//	    expect:
+//	      status: 200
+//	      body: ok
+type Scenario struct {
+	Name     string         `yaml:"name"`
+	Config   ScenarioConfig `yaml:"config"`
+	Backends []BackendSpec  `yaml:"backends"`
+	Routes   []RouteSpec    `yaml:"routes"`
+	Steps    []Step         `yaml:"steps"`
+}
+
+type ScenarioConfig struct {
+	EndpointTimeoutInMs int `yaml:"endpoint_timeout_ms"`
+}
+
+type RouteSpec struct {
+	Uri      string   `yaml:"uri"`
+	Backends []string `yaml:"backends"`
+}
+
+// A Step does one of its actions.
+type Step struct {
+	Request     *RequestStep   `yaml:"request"`
+	WebSocket   *WebSocketStep `yaml:"websocket"`
+	Register    *RouteSpec     `yaml:"register"`
+	Unregister  *RouteSpec     `yaml:"unregister"`
+	StopBackend string         `yaml:"stop_backend"`
+	SleepInMs   int            `yaml:"sleep_ms"`
+}
+
+// RequestStep sends a request Repeat times, once by default, and checks the
+// responses against Expect.
+type RequestStep struct {
+	Method string      `yaml:"method"`
+	Host   string      `yaml:"host"`
+	Path   string      `yaml:"path"`
+	Body   string      `yaml:"body"`
+	Repeat int         `yaml:"repeat"`
+	Expect Expectation `yaml:"expect"`
+}
+
+// Expectation checks a response. Fields left empty are not checked.
+// MaxLatencyInMs bounds the time until the response headers arrive.
+type Expectation struct {
+	Status         int               `yaml:"status"`
+	Body           string            `yaml:"body"`
+	Header         map[string]string `yaml:"header"`
+	MaxLatencyInMs int               `yaml:"max_latency_ms"`
+}
+
+// WebSocketStep expects Message to be echoed back over a WebSocket
+// connection to Host.
+type WebSocketStep struct {
+	Host    string `yaml:"host"`
+	Message string `yaml:"message"`
+}
+
+func LoadScenario(path string) (*Scenario, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var s Scenario
+	err = candiedyaml.Unmarshal(b, &s)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+
+	return &s, nil
+}
+
+// Run plays the scenario against a new harness and returns the first way
+// in which the router did not behave as expected.
+func (s *Scenario) Run() error {
+	h, err := StartHarness(func(c *config.Config) {
+		if s.Config.EndpointTimeoutInMs > 0 {
+			c.EndpointTimeout = time.Duration(s.Config.EndpointTimeoutInMs) * time.Millisecond
+		}
+	})
+	if err != nil {
+		return err
+	}
+	defer h.Stop()
+
+	for _, spec := range s.Backends {
+		_, err = h.AddBackend(spec)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, r := range s.Routes {
+		err = s.register(h, r)
+		if err != nil {
+			return err
+		}
+	}
+
+	for i, step := range s.Steps {
+		err = s.run(h, step)
+		if err != nil {
+			return fmt.Errorf("step %d: %s", i+1, err)
+		}
+	}
+
+	return nil
+}
+
+func (s *Scenario) run(h *Harness, step Step) error {
+	switch {
+	case step.Request != nil:
+		return s.request(h, step.Request)
+	case step.WebSocket != nil:
+		echo, err := h.WebSocket(step.WebSocket.Host, step.WebSocket.Message)
+		if err != nil {
+			return err
+		}
+		if echo != step.WebSocket.Message {
+			return fmt.Errorf("expected %q to be echoed, got %q", step.WebSocket.Message, echo)
+		}
+	case step.Register != nil:
+		return s.register(h, *step.Register)
+	case step.Unregister != nil:
+		backends, err := s.backends(h, *step.Unregister)
+		if err != nil {
+			return err
+		}
+		return h.Unregister(step.Unregister.Uri, backends...)
+	case step.StopBackend != "":
+		b := h.Backend(step.StopBackend)
+		if b == nil {
+			return fmt.Errorf("unknown backend %q", step.StopBackend)
+		}
+		b.Stop()
+	case step.SleepInMs > 0:
+		time.Sleep(time.Duration(step.SleepInMs) * time.Millisecond)
+	default:
+		return fmt.Errorf("step does nothing")
+	}
+
+	return nil
+}
+
+func (s *Scenario) register(h *Harness, r RouteSpec) error {
+	backends, err := s.backends(h, r)
+	if err != nil {
+		return err
+	}
+	return h.Register(r.Uri, backends...)
+}
+
+func (s *Scenario) backends(h *Harness, r RouteSpec) ([]*Backend, error) {
+	var backends []*Backend
+	for _, name := range r.Backends {
+		b := h.Backend(name)
+		if b == nil {
+			return nil, fmt.Errorf("unknown backend %q", name)
+		}
+		backends = append(backends, b)
+	}
+	return backends, nil
+}
+
+func (s *Scenario) request(h *Harness, step *RequestStep) error {
+	method := step.Method
+	if method == "" {
+		method = "GET"
+	}
+	path := step.Path
+	if path == "" {
+		path = "/"
+	}
+	repeat := step.Repeat
+	if repeat == 0 {
+		repeat = 1
+	}
+
+	for i := 0; i < repeat; i++ {
+		request, err := http.NewRequest(method, "http://"+step.Host+path, strings.NewReader(step.Body))
+		if err != nil {
+			return err
+		}
+
+		start := time.Now()
+		res, err := h.Do(request)
+		if err != nil {
+			return err
+		}
+		latency := time.Since(start)
+
+		body, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			return err
+		}
+
+		err = step.Expect.check(res, string(body), latency)
+		if err != nil {
+			return fmt.Errorf("%s %s%s: %s", method, step.Host, path, err)
+		}
+	}
+
+	return nil
+}
+
+func (e Expectation) check(res *http.Response, body string, latency time.Duration) error {
+	if e.Status != 0 && res.StatusCode != e.Status {
+		return fmt.Errorf("expected status %d, got %d", e.Status, res.StatusCode)
+	}
+	if e.Body != "" && body != e.Body {
+		return fmt.Errorf("expected body %q, got %q", e.Body, body)
+	}
+	for k, v := range e.Header {
+		if res.Header.Get(k) != v {
+			return fmt.Errorf("expected header %s to be %q, got %q", k, v, res.Header.Get(k))
+		}
+	}
+	if e.MaxLatencyInMs > 0 && latency > time.Duration(e.MaxLatencyInMs)*time.Millisecond {
+		return fmt.Errorf("expected a response within %dms, took %s", e.MaxLatencyInMs, latency)
+	}
+	return nil
+}
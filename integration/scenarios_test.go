@@ -0,0 +1,28 @@
+package integration_test
+
+import (
+	"path/filepath"
+
+	"github.com/cloudfoundry/gorouter/integration"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Scenarios", func() {
+	files, err := filepath.Glob("scenarios/*.yml")
+	if err != nil {
+		panic(err)
+	}
+
+	for _, file := range files {
+		file := file
+
+		It(file, func() {
+			s, err := integration.LoadScenario(file)
+			Ω(err).NotTo(HaveOccurred())
+
+			Ω(s.Run()).To(Succeed())
+		})
+	}
+})
@@ -0,0 +1,95 @@
+// Package ipfilter allows or denies clients by the IP address they connect
+// from, with lists of IP addresses and CIDR ranges.
+package ipfilter
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// Networks are IP networks. In JSON they are a list of IP addresses and
+// CIDR ranges, and a list with an entry that is neither cannot be read.
+type Networks []*net.IPNet
+
+// ParseNetworks parses IP addresses, which stand for networks of their
+// own, and CIDR ranges.
+func ParseNetworks(entries []string) (Networks, error) {
+	var networks Networks
+	for _, entry := range entries {
+		a := entry
+		if !strings.Contains(a, "/") {
+			if ip := net.ParseIP(a); ip != nil && ip.To4() != nil {
+				a += "/32"
+			} else {
+				a += "/128"
+			}
+		}
+
+		_, network, err := net.ParseCIDR(a)
+		if err != nil {
+			return nil, fmt.Errorf("invalid IP address or CIDR range: %q", entry)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+func (n Networks) Contains(ip net.IP) bool {
+	for _, network := range n {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func (n Networks) MarshalJSON() ([]byte, error) {
+	entries := make([]string, 0, len(n))
+	for _, network := range n {
+		entries = append(entries, network.String())
+	}
+	return json.Marshal(entries)
+}
+
+func (n *Networks) UnmarshalJSON(b []byte) error {
+	var entries []string
+	if err := json.Unmarshal(b, &entries); err != nil {
+		return err
+	}
+
+	networks, err := ParseNetworks(entries)
+	if err != nil {
+		return err
+	}
+	*n = networks
+	return nil
+}
+
+// A Filter denies the clients in its Deny networks, and, when it has Allow
+// networks, the clients in none of them. A nil Filter allows every client.
+type Filter struct {
+	Allow Networks
+	Deny  Networks
+}
+
+// New returns a filter of allow and deny, or nil when both are empty.
+func New(allow, deny Networks) *Filter {
+	if len(allow) == 0 && len(deny) == 0 {
+		return nil
+	}
+	return &Filter{Allow: allow, Deny: deny}
+}
+
+// Allows tells whether the client at ip may send requests. Clients whose
+// address is not known are only allowed by a nil filter.
+func (f *Filter) Allows(ip net.IP) bool {
+	if f == nil {
+		return true
+	}
+	if ip == nil || f.Deny.Contains(ip) {
+		return false
+	}
+	return len(f.Allow) == 0 || f.Allow.Contains(ip)
+}
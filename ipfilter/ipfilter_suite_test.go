@@ -0,0 +1,13 @@
+package ipfilter_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestIpfilter(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Ipfilter Suite")
+}
@@ -0,0 +1,62 @@
+package ipfilter_test
+
+import (
+	. "github.com/cloudfoundry/gorouter/ipfilter"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"encoding/json"
+	"net"
+)
+
+var _ = Describe("Filter", func() {
+	networks := func(entries ...string) Networks {
+		n, err := ParseNetworks(entries)
+		Ω(err).ShouldNot(HaveOccurred())
+		return n
+	}
+
+	It("allows every client without networks", func() {
+		var f *Filter = New(nil, nil)
+
+		Ω(f).To(BeNil())
+		Ω(f.Allows(net.ParseIP("10.0.0.1"))).To(BeTrue())
+		Ω(f.Allows(nil)).To(BeTrue())
+	})
+
+	It("allows only the clients in its allow networks", func() {
+		f := New(networks("10.0.0.0/8", "192.168.1.1"), nil)
+
+		Ω(f.Allows(net.ParseIP("10.1.2.3"))).To(BeTrue())
+		Ω(f.Allows(net.ParseIP("192.168.1.1"))).To(BeTrue())
+		Ω(f.Allows(net.ParseIP("192.168.1.2"))).To(BeFalse())
+		Ω(f.Allows(nil)).To(BeFalse())
+	})
+
+	It("denies the clients in its deny networks, even when they are allowed", func() {
+		f := New(networks("10.0.0.0/8"), networks("10.0.0.0/16", "2001:db8::/32"))
+
+		Ω(f.Allows(net.ParseIP("10.0.1.1"))).To(BeFalse())
+		Ω(f.Allows(net.ParseIP("10.1.1.1"))).To(BeTrue())
+
+		f = New(nil, networks("2001:db8::/32"))
+		Ω(f.Allows(net.ParseIP("2001:db8::1"))).To(BeFalse())
+		Ω(f.Allows(net.ParseIP("2001:db9::1"))).To(BeTrue())
+	})
+
+	It("reads and writes networks as JSON", func() {
+		var n Networks
+		Ω(json.Unmarshal([]byte(`["10.0.0.0/8", "192.168.1.1", "::1"]`), &n)).To(Succeed())
+		Ω(n).To(HaveLen(3))
+
+		b, err := json.Marshal(n)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(string(b)).To(Equal(`["10.0.0.0/8","192.168.1.1/32","::1/128"]`))
+	})
+
+	It("does not read networks with an invalid entry", func() {
+		var n Networks
+		err := json.Unmarshal([]byte(`["10.0.0.0/8", "10.0.0.300"]`), &n)
+		Ω(err).To(MatchError(ContainSubstring(`"10.0.0.300"`)))
+	})
+})
@@ -0,0 +1,132 @@
+package jwtauth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+)
+
+// A key is a public key of a JSON Web Key Set.
+type key struct {
+	id     string
+	alg    string
+	kty    string
+	curve  string
+	public crypto.PublicKey
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+var curves = map[string]elliptic.Curve{
+	"P-256": elliptic.P256(),
+	"P-384": elliptic.P384(),
+	"P-521": elliptic.P521(),
+}
+
+// fetchKeys fetches the JSON Web Key Set at url, and returns its RSA and EC
+// signing keys. Keys of other types or uses are skipped.
+func fetchKeys(client *http.Client, url string) ([]key, error) {
+	request, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Accept", "application/json")
+
+	res, err := client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	body, err := ioutil.ReadAll(io.LimitReader(res.Body, 1024*1024))
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s responded with %d", request.URL.Host, res.StatusCode)
+	}
+
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	err = json.Unmarshal(body, &set)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []key
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+
+		public, ok := k.publicKey()
+		if !ok {
+			continue
+		}
+		keys = append(keys, key{
+			id:     k.Kid,
+			alg:    k.Alg,
+			kty:    k.Kty,
+			curve:  k.Crv,
+			public: public,
+		})
+	}
+	return keys, nil
+}
+
+func (k jwk) publicKey() (crypto.PublicKey, bool) {
+	switch k.Kty {
+	case "RSA":
+		n, ok := decodeInt(k.N)
+		if !ok {
+			return nil, false
+		}
+		e, ok := decodeInt(k.E)
+		if !ok || !e.IsInt64() || e.Int64() < 2 || e.Int64() > 1<<31-1 {
+			return nil, false
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, true
+	case "EC":
+		curve, ok := curves[k.Crv]
+		if !ok {
+			return nil, false
+		}
+		x, ok := decodeInt(k.X)
+		if !ok {
+			return nil, false
+		}
+		y, ok := decodeInt(k.Y)
+		if !ok || !curve.IsOnCurve(x, y) {
+			return nil, false
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, true
+	}
+	return nil, false
+}
+
+func decodeInt(s string) (*big.Int, bool) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(b) == 0 {
+		return nil, false
+	}
+	return new(big.Int).SetBytes(b), true
+}
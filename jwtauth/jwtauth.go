@@ -0,0 +1,366 @@
+package jwtauth
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	steno "github.com/cloudfoundry/gosteno"
+
+	"github.com/cloudfoundry/gorouter/clock"
+	"github.com/cloudfoundry/gorouter/config"
+)
+
+var (
+	ErrMissing       = errors.New("request has no bearer token")
+	ErrMalformed     = errors.New("token is malformed")
+	ErrAlgorithm     = errors.New("token is signed with an unsupported algorithm")
+	ErrUnknownKey    = errors.New("token is signed with an unknown key")
+	ErrSignature     = errors.New("signature of token is invalid")
+	ErrExpired       = errors.New("token has expired")
+	ErrNotYetValid   = errors.New("token is not valid yet")
+	ErrIssuer        = errors.New("token is from another issuer")
+	ErrAudience      = errors.New("token is for another audience")
+	ErrNotConfigured = errors.New("no keys to validate tokens with")
+)
+
+const (
+	// leeway is how far the clocks of the router and the issuer may be
+	// apart when the token's times are checked.
+	leeway = time.Minute
+
+	// retryInterval is how long the router waits after fetching the keys
+	// before fetching them again for a token signed with a key it does
+	// not know, so that such tokens cannot have it hammer the issuer.
+	retryInterval = 10 * time.Second
+
+	// maxNumericDate bounds the times of tokens, in seconds, to keep them
+	// within those that time.Time can hold.
+	maxNumericDate = 1 << 40
+)
+
+// A Validator checks the bearer tokens of requests: JSON Web Tokens signed
+// with RSA or ECDSA by one of the keys of the issuer's JSON Web Key Set,
+// that have not expired, and that were issued by the configured issuer to
+// the configured audience. A nil validator has no keys, and accepts no
+// token.
+type Validator struct {
+	config config.JwtConfig
+	client *http.Client
+	clock  clock.Clock
+	logger *steno.Logger
+
+	lock      sync.Mutex
+	keys      []key
+	fetchedAt time.Time
+	triedAt   time.Time
+	fetching  chan struct{}
+}
+
+type algorithm struct {
+	hash  crypto.Hash
+	kty   string
+	pss   bool
+	curve string
+}
+
+var algorithms = map[string]algorithm{
+	"RS256": {hash: crypto.SHA256, kty: "RSA"},
+	"RS384": {hash: crypto.SHA384, kty: "RSA"},
+	"RS512": {hash: crypto.SHA512, kty: "RSA"},
+	"PS256": {hash: crypto.SHA256, kty: "RSA", pss: true},
+	"PS384": {hash: crypto.SHA384, kty: "RSA", pss: true},
+	"PS512": {hash: crypto.SHA512, kty: "RSA", pss: true},
+	"ES256": {hash: crypto.SHA256, kty: "EC", curve: "P-256"},
+	"ES384": {hash: crypto.SHA384, kty: "EC", curve: "P-384"},
+	"ES512": {hash: crypto.SHA512, kty: "EC", curve: "P-521"},
+}
+
+type header struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// NewValidator returns the validator configured by c, or nil when no JWKS
+// URL is configured. The keys are fetched with the first token.
+func NewValidator(c config.JwtConfig, clk clock.Clock) *Validator {
+	if c.JwksUrl == "" {
+		return nil
+	}
+
+	return &Validator{
+		config: c,
+		client: &http.Client{Timeout: 10 * time.Second},
+		clock:  clk,
+		logger: steno.NewLogger("router.jwt"),
+	}
+}
+
+// Validate returns nil when the request carries a valid bearer token in
+// its Authorization header, and passes the claims of the token on in the
+// configured claim headers. The claim headers a client sent are dropped
+// whether the token is valid or not.
+func (v *Validator) Validate(request *http.Request) error {
+	if v == nil {
+		return ErrNotConfigured
+	}
+
+	// the claim headers are the router's to set
+	for _, name := range v.config.ClaimHeaders {
+		request.Header.Del(name)
+	}
+
+	auth := request.Header.Get("Authorization")
+	if len(auth) < 7 || !strings.EqualFold(auth[:7], "Bearer ") {
+		return ErrMissing
+	}
+
+	claims, err := v.verify(strings.TrimSpace(auth[7:]))
+	if err != nil {
+		return err
+	}
+	err = v.check(claims)
+	if err != nil {
+		return err
+	}
+
+	for claim, name := range v.config.ClaimHeaders {
+		if value, ok := claimValue(claims[claim]); ok {
+			request.Header.Set(name, value)
+		}
+	}
+	return nil
+}
+
+// verify checks the signature of a token, and returns its claims.
+func (v *Validator) verify(token string) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrMalformed
+	}
+
+	var h header
+	if decodeSegment(parts[0], &h) != nil {
+		return nil, ErrMalformed
+	}
+	alg, ok := algorithms[h.Alg]
+	if !ok {
+		return nil, ErrAlgorithm
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrMalformed
+	}
+
+	digest := alg.hash.New()
+	digest.Write([]byte(parts[0] + "." + parts[1]))
+	hashed := digest.Sum(nil)
+
+	keys := v.lookup(h)
+	if len(keys) == 0 {
+		return nil, ErrUnknownKey
+	}
+
+	verified := false
+	for _, k := range keys {
+		if alg.verify(k.public, hashed, signature) {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return nil, ErrSignature
+	}
+
+	var claims map[string]interface{}
+	if decodeSegment(parts[1], &claims) != nil {
+		return nil, ErrMalformed
+	}
+	return claims, nil
+}
+
+// check checks the times, issuer and audience of the claims of a token.
+// Tokens must have an expiry.
+func (v *Validator) check(claims map[string]interface{}) error {
+	now := v.clock.Now()
+
+	exp, ok := numericDate(claims["exp"])
+	if !ok {
+		return ErrMalformed
+	}
+	if now.After(exp.Add(leeway)) {
+		return ErrExpired
+	}
+
+	if _, present := claims["nbf"]; present {
+		nbf, ok := numericDate(claims["nbf"])
+		if !ok {
+			return ErrMalformed
+		}
+		if now.Before(nbf.Add(-leeway)) {
+			return ErrNotYetValid
+		}
+	}
+
+	if iss, _ := claims["iss"].(string); iss != v.config.Issuer {
+		return ErrIssuer
+	}
+
+	switch aud := claims["aud"].(type) {
+	case string:
+		if aud == v.config.Audience {
+			return nil
+		}
+	case []interface{}:
+		for _, a := range aud {
+			if a == v.config.Audience {
+				return nil
+			}
+		}
+	}
+	return ErrAudience
+}
+
+// lookup returns the keys a token with header h may be signed with. The
+// keys are fetched again in the background when they are due, while the
+// ones fetched before are served, and when none of them match, at most
+// every retryInterval. Only tokens that no key matches wait for a fetch in
+// flight, and no lock is held while they do.
+func (v *Validator) lookup(h header) []key {
+	v.lock.Lock()
+	now := v.clock.Now()
+	keys := v.match(h)
+
+	due := v.fetchedAt.IsZero() || now.Sub(v.fetchedAt) >= v.config.JwksRefreshInterval
+	if (due || len(keys) == 0) && v.fetching == nil && (v.triedAt.IsZero() || now.Sub(v.triedAt) >= retryInterval) {
+		v.triedAt = now
+		v.fetching = make(chan struct{})
+		go v.fetch(v.fetching)
+	}
+	fetching := v.fetching
+	v.lock.Unlock()
+
+	if len(keys) > 0 || fetching == nil {
+		return keys
+	}
+
+	<-fetching
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	return v.match(h)
+}
+
+// fetch fetches the keys, and closes done once they are in place.
+func (v *Validator) fetch(done chan struct{}) {
+	fetched, err := fetchKeys(v.client, v.config.JwksUrl)
+
+	v.lock.Lock()
+	if err != nil {
+		// the keys fetched before are kept until the issuer answers
+		v.logger.Warnd(map[string]interface{}{
+			"jwks_url": v.config.JwksUrl,
+			"error":    err.Error(),
+		}, "jwt.fetch-keys.failed")
+	} else {
+		v.keys = fetched
+		v.fetchedAt = v.clock.Now()
+	}
+	v.fetching = nil
+	v.lock.Unlock()
+
+	close(done)
+}
+
+func (v *Validator) match(h header) []key {
+	alg := algorithms[h.Alg]
+
+	var keys []key
+	for _, k := range v.keys {
+		if h.Kid != "" && k.id != h.Kid {
+			continue
+		}
+		if k.alg != "" && k.alg != h.Alg {
+			continue
+		}
+		if k.kty != alg.kty || k.curve != alg.curve {
+			continue
+		}
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+func (a algorithm) verify(public crypto.PublicKey, hashed, signature []byte) bool {
+	switch public := public.(type) {
+	case *rsa.PublicKey:
+		if a.pss {
+			return rsa.VerifyPSS(public, a.hash, hashed, signature, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash}) == nil
+		}
+		return rsa.VerifyPKCS1v15(public, a.hash, hashed, signature) == nil
+	case *ecdsa.PublicKey:
+		size := (public.Curve.Params().BitSize + 7) / 8
+		if len(signature) != 2*size {
+			return false
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		return ecdsa.Verify(public, hashed, r, s)
+	}
+	return false
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+	return d.Decode(v)
+}
+
+func numericDate(v interface{}) (time.Time, bool) {
+	n, ok := v.(json.Number)
+	if !ok {
+		return time.Time{}, false
+	}
+	f, err := n.Float64()
+	if err != nil || math.Abs(f) > maxNumericDate {
+		return time.Time{}, false
+	}
+	sec := math.Floor(f)
+	return time.Unix(int64(sec), int64((f-sec)*float64(time.Second))), true
+}
+
+// claimValue renders a claim as a header value: strings and numbers as
+// they are, and other claims as JSON.
+func claimValue(v interface{}) (string, bool) {
+	switch v := v.(type) {
+	case nil:
+		return "", false
+	case string:
+		return v, true
+	case json.Number:
+		return v.String(), true
+	}
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", false
+	}
+	return string(b), true
+}
@@ -0,0 +1,13 @@
+package jwtauth_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestJwtauth(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Jwtauth Suite")
+}
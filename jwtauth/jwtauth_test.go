@@ -0,0 +1,309 @@
+package jwtauth_test
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cloudfoundry/gorouter/clock/fakeclock"
+	"github.com/cloudfoundry/gorouter/config"
+	. "github.com/cloudfoundry/gorouter/jwtauth"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+const (
+	issuer   = "https://uaa.example.com/oauth/token"
+	audience = "orders-api"
+)
+
+var (
+	rsaKey *rsa.PrivateKey
+	ecKey  *ecdsa.PrivateKey
+)
+
+func init() {
+	var err error
+	rsaKey, err = rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic(err)
+	}
+	ecKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+}
+
+func encode(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func segment(v interface{}) string {
+	b, err := json.Marshal(v)
+	Ω(err).NotTo(HaveOccurred())
+	return encode(b)
+}
+
+// sign makes a token of claims signed with the RS256 or ES256 key of kid.
+func sign(alg, kid string, claims map[string]interface{}) string {
+	input := segment(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"}) + "." + segment(claims)
+	hashed := sha256.Sum256([]byte(input))
+
+	var signature []byte
+	switch alg {
+	case "RS256":
+		var err error
+		signature, err = rsa.SignPKCS1v15(rand.Reader, rsaKey, crypto.SHA256, hashed[:])
+		Ω(err).NotTo(HaveOccurred())
+	case "ES256":
+		r, s, err := ecdsa.Sign(rand.Reader, ecKey, hashed[:])
+		Ω(err).NotTo(HaveOccurred())
+		signature = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	}
+	return input + "." + encode(signature)
+}
+
+func rsaJwk(kid string) map[string]string {
+	return map[string]string{
+		"kty": "RSA",
+		"kid": kid,
+		"use": "sig",
+		"n":   encode(rsaKey.N.Bytes()),
+		"e":   encode(big.NewInt(int64(rsaKey.E)).Bytes()),
+	}
+}
+
+func ecJwk(kid string) map[string]string {
+	return map[string]string{
+		"kty": "EC",
+		"kid": kid,
+		"crv": "P-256",
+		"x":   encode(ecKey.X.FillBytes(make([]byte, 32))),
+		"y":   encode(ecKey.Y.FillBytes(make([]byte, 32))),
+	}
+}
+
+var _ = Describe("Validator", func() {
+	var (
+		clock     *fakeclock.FakeClock
+		validator *Validator
+		jwks      *httptest.Server
+		fetches   int32
+
+		lock sync.Mutex
+		keys []map[string]string
+		hang chan struct{}
+	)
+
+	setKeys := func(k ...map[string]string) {
+		lock.Lock()
+		keys = k
+		lock.Unlock()
+	}
+
+	BeforeEach(func() {
+		clock = fakeclock.New(time.Unix(1500000000, 0))
+		atomic.StoreInt32(&fetches, 0)
+		setKeys(rsaJwk("rsa-1"), ecJwk("ec-1"))
+
+		jwks = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&fetches, 1)
+			lock.Lock()
+			wait := hang
+			lock.Unlock()
+			if wait != nil {
+				<-wait
+				return
+			}
+
+			lock.Lock()
+			defer lock.Unlock()
+			json.NewEncoder(w).Encode(map[string]interface{}{"keys": keys})
+		}))
+
+		validator = NewValidator(config.JwtConfig{
+			JwksUrl:             jwks.URL,
+			Issuer:              issuer,
+			Audience:            audience,
+			JwksRefreshInterval: 5 * time.Minute,
+			ClaimHeaders: map[string]string{
+				"sub":    "X-Jwt-Subject",
+				"scope":  "X-Jwt-Scope",
+				"tenant": "X-Jwt-Tenant",
+			},
+		}, clock)
+	})
+
+	AfterEach(func() {
+		jwks.Close()
+	})
+
+	claims := func() map[string]interface{} {
+		return map[string]interface{}{
+			"iss":   issuer,
+			"aud":   []string{"other-api", audience},
+			"sub":   "user-guid",
+			"scope": []string{"orders.read", "orders.write"},
+			"exp":   clock.Now().Add(time.Hour).Unix(),
+		}
+	}
+
+	request := func(token string) *http.Request {
+		req, err := http.NewRequest("GET", "http://orders.example.com/orders", nil)
+		Ω(err).NotTo(HaveOccurred())
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		return req
+	}
+
+	It("accepts a valid token and passes its claims on", func() {
+		req := request(sign("RS256", "rsa-1", claims()))
+		req.Header.Set("X-Jwt-Tenant", "forged")
+
+		Ω(validator.Validate(req)).To(Succeed())
+		Ω(req.Header.Get("X-Jwt-Subject")).To(Equal("user-guid"))
+		Ω(req.Header.Get("X-Jwt-Scope")).To(Equal(`["orders.read","orders.write"]`))
+		Ω(req.Header).NotTo(HaveKey("X-Jwt-Tenant"))
+	})
+
+	It("accepts tokens signed with ECDSA keys", func() {
+		Ω(validator.Validate(request(sign("ES256", "ec-1", claims())))).To(Succeed())
+	})
+
+	It("requires a bearer token", func() {
+		req := request("")
+		req.Header.Set("X-Jwt-Subject", "forged")
+
+		Ω(validator.Validate(req)).To(Equal(ErrMissing))
+		Ω(req.Header).NotTo(HaveKey("X-Jwt-Subject"))
+
+		req = request("")
+		req.SetBasicAuth("user", "password")
+		Ω(validator.Validate(req)).To(Equal(ErrMissing))
+	})
+
+	It("rejects tokens that are not signed by a key of the issuer", func() {
+		token := sign("RS256", "rsa-1", claims())
+		Ω(validator.Validate(request(token[:len(token)-4] + "AAAA"))).To(Equal(ErrSignature))
+
+		unsigned := segment(map[string]string{"alg": "none"}) + "." + segment(claims()) + "."
+		Ω(validator.Validate(request(unsigned))).To(Equal(ErrAlgorithm))
+
+		Ω(validator.Validate(request("not-a-token"))).To(Equal(ErrMalformed))
+	})
+
+	It("rejects expired tokens, allowing for clock skew", func() {
+		c := claims()
+		c["exp"] = clock.Now().Add(-30 * time.Second).Unix()
+		Ω(validator.Validate(request(sign("RS256", "rsa-1", c)))).To(Succeed())
+
+		c["exp"] = clock.Now().Add(-2 * time.Minute).Unix()
+		Ω(validator.Validate(request(sign("RS256", "rsa-1", c)))).To(Equal(ErrExpired))
+
+		delete(c, "exp")
+		Ω(validator.Validate(request(sign("RS256", "rsa-1", c)))).To(Equal(ErrMalformed))
+	})
+
+	It("rejects tokens that are not valid yet", func() {
+		c := claims()
+		c["nbf"] = clock.Now().Add(10 * time.Minute).Unix()
+		Ω(validator.Validate(request(sign("RS256", "rsa-1", c)))).To(Equal(ErrNotYetValid))
+	})
+
+	It("rejects tokens of other issuers and audiences", func() {
+		c := claims()
+		c["iss"] = "https://evil.example.com"
+		Ω(validator.Validate(request(sign("RS256", "rsa-1", c)))).To(Equal(ErrIssuer))
+
+		c = claims()
+		c["aud"] = "other-api"
+		Ω(validator.Validate(request(sign("RS256", "rsa-1", c)))).To(Equal(ErrAudience))
+	})
+
+	It("fetches the keys again when they are due, or for a new key", func() {
+		Ω(validator.Validate(request(sign("RS256", "rsa-1", claims())))).To(Succeed())
+		Ω(validator.Validate(request(sign("ES256", "ec-1", claims())))).To(Succeed())
+		Ω(atomic.LoadInt32(&fetches)).To(Equal(int32(1)))
+
+		setKeys(rsaJwk("rsa-2"))
+		Ω(validator.Validate(request(sign("RS256", "rsa-2", claims())))).To(Equal(ErrUnknownKey))
+		Ω(atomic.LoadInt32(&fetches)).To(Equal(int32(1)))
+
+		clock.Increment(10 * time.Second)
+		Ω(validator.Validate(request(sign("RS256", "rsa-2", claims())))).To(Succeed())
+		Ω(atomic.LoadInt32(&fetches)).To(Equal(int32(2)))
+
+		clock.Increment(5 * time.Minute)
+		Ω(validator.Validate(request(sign("RS256", "rsa-2", claims())))).To(Succeed())
+		Eventually(func() int32 { return atomic.LoadInt32(&fetches) }).Should(Equal(int32(3)))
+	})
+
+	Context("when the issuer hangs", func() {
+		AfterEach(func() {
+			lock.Lock()
+			close(hang)
+			hang = nil
+			lock.Unlock()
+		})
+
+		It("serves the keys it has while it fetches them again", func() {
+			Ω(validator.Validate(request(sign("RS256", "rsa-1", claims())))).To(Succeed())
+
+			lock.Lock()
+			hang = make(chan struct{})
+			lock.Unlock()
+			clock.Increment(5 * time.Minute)
+
+			var wg sync.WaitGroup
+			results := make(chan error, 20)
+			for i := 0; i < 20; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					results <- validator.Validate(request(sign("RS256", "rsa-1", claims())))
+				}()
+			}
+
+			done := make(chan struct{})
+			go func() {
+				wg.Wait()
+				close(done)
+			}()
+			Eventually(done, time.Second).Should(BeClosed())
+
+			close(results)
+			for err := range results {
+				Ω(err).NotTo(HaveOccurred())
+			}
+			Eventually(func() int32 { return atomic.LoadInt32(&fetches) }).Should(Equal(int32(2)))
+			Consistently(func() int32 { return atomic.LoadInt32(&fetches) }, 100*time.Millisecond).Should(Equal(int32(2)))
+		})
+	})
+
+	It("keeps the keys it has while the issuer does not answer", func() {
+		Ω(validator.Validate(request(sign("RS256", "rsa-1", claims())))).To(Succeed())
+
+		jwks.Close()
+		clock.Increment(5 * time.Minute)
+		Ω(validator.Validate(request(sign("RS256", "rsa-1", claims())))).To(Succeed())
+	})
+
+	It("accepts no token without keys", func() {
+		var nilValidator *Validator
+		Ω(nilValidator.Validate(request(sign("RS256", "rsa-1", claims())))).To(Equal(ErrNotConfigured))
+		Ω(NewValidator(config.JwtConfig{}, clock)).To(BeNil())
+	})
+})
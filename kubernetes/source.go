@@ -0,0 +1,310 @@
+package kubernetes
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	steno "github.com/cloudfoundry/gosteno"
+
+	"github.com/cloudfoundry/gorouter/config"
+	"github.com/cloudfoundry/gorouter/route"
+)
+
+const serviceNameLabel = "kubernetes.io/service-name"
+
+// Registry is where the source registers the routes of the cluster.
+type Registry interface {
+	Register(uri route.Uri, endpoint *route.Endpoint)
+	Unregister(uri route.Uri, endpoint *route.Endpoint)
+}
+
+// Source routes to the services of a Kubernetes cluster that are annotated
+// with hostnames. The ready pod IPs of the EndpointSlices of every such
+// service are registered for its hostnames, next to whatever NATS registers
+// for the same hostnames, and are unregistered once they are no longer
+// ready, their slice is gone or the service loses its annotation.
+type Source struct {
+	registry Registry
+	client   *http.Client
+	logger   *steno.Logger
+
+	apiServer      string
+	tokenPath      string
+	namespaces     []string
+	annotation     string
+	portAnnotation string
+	interval       time.Duration
+
+	registered map[string]registration
+	stopCh     chan struct{}
+}
+
+type registration struct {
+	uri      route.Uri
+	endpoint *route.Endpoint
+}
+
+type objectMeta struct {
+	Name        string            `json:"name"`
+	Namespace   string            `json:"namespace"`
+	Labels      map[string]string `json:"labels"`
+	Annotations map[string]string `json:"annotations"`
+}
+
+type serviceList struct {
+	Items []struct {
+		Metadata objectMeta `json:"metadata"`
+	} `json:"items"`
+}
+
+type endpointSliceList struct {
+	Items []endpointSlice `json:"items"`
+}
+
+type endpointSlice struct {
+	Metadata    objectMeta `json:"metadata"`
+	AddressType string     `json:"addressType"`
+	Endpoints   []struct {
+		Addresses  []string `json:"addresses"`
+		Conditions struct {
+			Ready *bool `json:"ready"`
+		} `json:"conditions"`
+		TargetRef *struct {
+			Name string `json:"name"`
+		} `json:"targetRef"`
+	} `json:"endpoints"`
+	Ports []struct {
+		Name *string `json:"name"`
+		Port *int    `json:"port"`
+	} `json:"ports"`
+}
+
+// NewSource returns a source that talks to the API server of c, and fails
+// when the CA to authenticate it with cannot be read.
+func NewSource(registry Registry, c config.KubernetesConfig) (*Source, error) {
+	transport := &http.Transport{Proxy: http.ProxyFromEnvironment}
+	if c.CAPath != "" {
+		b, err := ioutil.ReadFile(c.CAPath)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(b) {
+			return nil, errors.New("no certificates found in " + c.CAPath)
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+
+	return &Source{
+		registry: registry,
+		client:   &http.Client{Transport: transport, Timeout: c.Timeout},
+		logger:   steno.NewLogger("router.kubernetes"),
+
+		apiServer:      strings.TrimSuffix(c.ApiServer, "/"),
+		tokenPath:      c.TokenPath,
+		namespaces:     c.Namespaces,
+		annotation:     c.Annotation,
+		portAnnotation: c.PortAnnotation,
+		interval:       c.Interval,
+
+		registered: make(map[string]registration),
+		stopCh:     make(chan struct{}),
+	}, nil
+}
+
+// Run syncs the routes right away and then every interval until Stop is
+// called.
+func (s *Source) Run() {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		err := s.Sync()
+		if err != nil {
+			s.logger.Warnf("kubernetes.sync.failed: %s", err)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-s.stopCh:
+			return
+		}
+	}
+}
+
+func (s *Source) Stop() {
+	close(s.stopCh)
+}
+
+// Sync reads the services and their EndpointSlices once, registers the
+// routes they have and unregisters those they no longer have. The routes
+// that were registered before are left alone when the cluster cannot be
+// read, and go stale if it stays that way. It is not safe to call
+// concurrently.
+func (s *Source) Sync() error {
+	namespaces := s.namespaces
+	if len(namespaces) == 0 {
+		namespaces = []string{""}
+	}
+
+	desired := make(map[string]registration)
+	for _, namespace := range namespaces {
+		err := s.sync(namespace, desired)
+		if err != nil {
+			return err
+		}
+	}
+
+	for key, r := range s.registered {
+		if _, ok := desired[key]; !ok {
+			s.registry.Unregister(r.uri, r.endpoint)
+		}
+	}
+
+	// registering again what is still there keeps it from going stale
+	for _, r := range desired {
+		s.registry.Register(r.uri, r.endpoint)
+	}
+
+	s.registered = desired
+
+	return nil
+}
+
+// sync adds the routes of the services of namespace, or of all namespaces
+// when it is empty, to desired.
+func (s *Source) sync(namespace string, desired map[string]registration) error {
+	prefix := ""
+	if namespace != "" {
+		prefix = "/namespaces/" + url.PathEscape(namespace)
+	}
+
+	var services serviceList
+	err := s.get("/api/v1"+prefix+"/services", &services)
+	if err != nil {
+		return err
+	}
+
+	routed := make(map[string]objectMeta)
+	for _, service := range services.Items {
+		if hostnames(service.Metadata.Annotations[s.annotation]) != nil {
+			routed[service.Metadata.Namespace+"/"+service.Metadata.Name] = service.Metadata
+		}
+	}
+	if len(routed) == 0 {
+		return nil
+	}
+
+	var slices endpointSliceList
+	err = s.get("/apis/discovery.k8s.io/v1"+prefix+"/endpointslices", &slices)
+	if err != nil {
+		return err
+	}
+
+	for _, slice := range slices.Items {
+		name := slice.Metadata.Namespace + "/" + slice.Metadata.Labels[serviceNameLabel]
+		service, ok := routed[name]
+		if !ok || slice.AddressType != "IPv4" {
+			continue
+		}
+
+		port, ok := s.port(slice, service.Annotations[s.portAnnotation])
+		if !ok {
+			continue
+		}
+
+		for _, e := range slice.Endpoints {
+			// endpoints whose readiness is unknown are taken to be ready
+			if e.Conditions.Ready != nil && !*e.Conditions.Ready {
+				continue
+			}
+
+			instance := ""
+			if e.TargetRef != nil {
+				instance = e.TargetRef.Name
+			}
+
+			for _, address := range e.Addresses {
+				if net.ParseIP(address) == nil {
+					continue
+				}
+
+				endpoint := route.NewEndpoint(name, address, port, instance, nil, 0)
+				for _, hostname := range hostnames(service.Annotations[s.annotation]) {
+					uri := route.Uri(hostname)
+					desired[string(uri.ToLower())+" "+endpoint.CanonicalAddr()] = registration{uri: uri, endpoint: endpoint}
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// port finds the port of a slice that annotation names, by its name or its
+// number, or its first port when annotation is empty.
+func (s *Source) port(slice endpointSlice, annotation string) (uint16, bool) {
+	for _, p := range slice.Ports {
+		if p.Port == nil {
+			continue
+		}
+		if annotation == "" ||
+			p.Name != nil && *p.Name == annotation ||
+			strconv.Itoa(*p.Port) == annotation {
+			return uint16(*p.Port), true
+		}
+	}
+	return 0, false
+}
+
+func hostnames(annotation string) []string {
+	var hostnames []string
+	for _, hostname := range strings.Split(annotation, ",") {
+		hostname = strings.TrimSpace(hostname)
+		if hostname != "" {
+			hostnames = append(hostnames, hostname)
+		}
+	}
+	return hostnames
+}
+
+func (s *Source) get(path string, v interface{}) error {
+	request, err := http.NewRequest("GET", s.apiServer+path, nil)
+	if err != nil {
+		return err
+	}
+
+	// the token is read every time, since projected tokens are rotated
+	if s.tokenPath != "" {
+		token, err := ioutil.ReadFile(s.tokenPath)
+		if err != nil {
+			return err
+		}
+		request.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	}
+	request.Header.Set("Accept", "application/json")
+
+	res, err := s.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		io.Copy(ioutil.Discard, res.Body)
+		return fmt.Errorf("kubernetes answered %s with %d", path, res.StatusCode)
+	}
+
+	return json.NewDecoder(res.Body).Decode(v)
+}
@@ -0,0 +1,185 @@
+package kubernetes_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/cloudfoundry/gorouter/config"
+	"github.com/cloudfoundry/gorouter/kubernetes"
+	"github.com/cloudfoundry/gorouter/route"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type fakeRegistry struct {
+	lock   sync.Mutex
+	routes map[string]string
+}
+
+func (f *fakeRegistry) Register(uri route.Uri, endpoint *route.Endpoint) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.routes[string(uri)+" "+endpoint.CanonicalAddr()] = endpoint.ApplicationId + " " + endpoint.PrivateInstanceId
+}
+
+func (f *fakeRegistry) Unregister(uri route.Uri, endpoint *route.Endpoint) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	delete(f.routes, string(uri)+" "+endpoint.CanonicalAddr())
+}
+
+func (f *fakeRegistry) Routes() []string {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	var routes []string
+	for r := range f.routes {
+		routes = append(routes, r)
+	}
+	sort.Strings(routes)
+	return routes
+}
+
+const services = `{"items": [
+  {"metadata": {"name": "billing", "namespace": "web", "annotations": {"gorouter.cloudfoundry.org/routes": "billing.example.com, pay.example.com", "gorouter.cloudfoundry.org/port": "http"}}},
+  {"metadata": {"name": "db", "namespace": "web"}}
+]}`
+
+const endpointSlices = `{"items": [
+  {
+    "metadata": {"name": "billing-abc", "namespace": "web", "labels": {"kubernetes.io/service-name": "billing"}},
+    "addressType": "IPv4",
+    "endpoints": [
+      {"addresses": ["10.1.0.4"], "conditions": {"ready": true}, "targetRef": {"name": "billing-7d9f-x2"}},
+      {"addresses": ["10.1.0.5"], "conditions": {"ready": false}, "targetRef": {"name": "billing-7d9f-y7"}},
+      {"addresses": ["10.1.0.6"], "conditions": {}}
+    ],
+    "ports": [{"name": "metrics", "port": 9090}, {"name": "http", "port": 8080}]
+  },
+  {
+    "metadata": {"name": "db-abc", "namespace": "web", "labels": {"kubernetes.io/service-name": "db"}},
+    "addressType": "IPv4",
+    "endpoints": [{"addresses": ["10.1.0.9"], "conditions": {"ready": true}}],
+    "ports": [{"name": "postgres", "port": 5432}]
+  }
+]}`
+
+var _ = Describe("Source", func() {
+	var registry *fakeRegistry
+	var source *kubernetes.Source
+	var server *httptest.Server
+	var c config.KubernetesConfig
+	var dir string
+	var lock sync.Mutex
+	var slices string
+	var requests []*http.Request
+
+	BeforeEach(func() {
+		registry = &fakeRegistry{routes: make(map[string]string)}
+		slices = endpointSlices
+		requests = nil
+
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			lock.Lock()
+			defer lock.Unlock()
+			requests = append(requests, r)
+
+			switch r.URL.Path {
+			case "/api/v1/services", "/api/v1/namespaces/web/services":
+				w.Write([]byte(services))
+			case "/apis/discovery.k8s.io/v1/endpointslices", "/apis/discovery.k8s.io/v1/namespaces/web/endpointslices":
+				w.Write([]byte(slices))
+			default:
+				http.NotFound(w, r)
+			}
+		}))
+
+		var err error
+		dir, err = ioutil.TempDir("", "kubernetes")
+		Ω(err).NotTo(HaveOccurred())
+		Ω(ioutil.WriteFile(filepath.Join(dir, "token"), []byte("service-account-token\n"), 0600)).To(Succeed())
+
+		c = config.DefaultConfig().Kubernetes
+		c.ApiServer = server.URL
+		c.TokenPath = filepath.Join(dir, "token")
+		c.CAPath = ""
+	})
+
+	JustBeforeEach(func() {
+		var err error
+		source, err = kubernetes.NewSource(registry, c)
+		Ω(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		server.Close()
+		os.RemoveAll(dir)
+	})
+
+	It("registers the ready endpoints of annotated services on their port", func() {
+		Ω(source.Sync()).To(Succeed())
+
+		Ω(registry.Routes()).To(Equal([]string{
+			"billing.example.com 10.1.0.4:8080",
+			"billing.example.com 10.1.0.6:8080",
+			"pay.example.com 10.1.0.4:8080",
+			"pay.example.com 10.1.0.6:8080",
+		}))
+		Ω(registry.routes["billing.example.com 10.1.0.4:8080"]).To(Equal("web/billing billing-7d9f-x2"))
+	})
+
+	It("authenticates with the token of the service account", func() {
+		Ω(source.Sync()).To(Succeed())
+
+		Ω(requests).NotTo(BeEmpty())
+		for _, r := range requests {
+			Ω(r.Header.Get("Authorization")).To(Equal("Bearer service-account-token"))
+		}
+	})
+
+	It("unregisters the endpoints that are gone", func() {
+		Ω(source.Sync()).To(Succeed())
+
+		lock.Lock()
+		slices = `{"items": []}`
+		lock.Unlock()
+
+		Ω(source.Sync()).To(Succeed())
+		Ω(registry.Routes()).To(BeEmpty())
+	})
+
+	It("keeps the routes it has when the cluster cannot be read", func() {
+		Ω(source.Sync()).To(Succeed())
+
+		server.Close()
+
+		Ω(source.Sync()).NotTo(Succeed())
+		Ω(registry.Routes()).To(HaveLen(4))
+	})
+
+	Context("with namespaces", func() {
+		BeforeEach(func() {
+			c.Namespaces = []string{"web"}
+		})
+
+		It("reads only those namespaces", func() {
+			Ω(source.Sync()).To(Succeed())
+
+			Ω(registry.Routes()).To(HaveLen(4))
+			for _, r := range requests {
+				Ω(r.URL.Path).To(ContainSubstring("/namespaces/web/"))
+			}
+		})
+	})
+
+	It("fails without the CA of the API server", func() {
+		c.CAPath = filepath.Join(dir, "missing.crt")
+
+		_, err := kubernetes.NewSource(registry, c)
+		Ω(err).To(HaveOccurred())
+	})
+})
@@ -0,0 +1,213 @@
+package leader
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/apcera/nats"
+	steno "github.com/cloudfoundry/gosteno"
+	"github.com/cloudfoundry/yagnats"
+)
+
+const HeartbeatSubject = "router.leader.heartbeat"
+
+// Task runs for as long as this router is the leader. stop is closed when
+// leadership is lost or the elector is stopped.
+type Task func(stop <-chan struct{})
+
+type heartbeat struct {
+	Id string `json:"id"`
+}
+
+// Elector picks a single leader among the routers sharing a NATS cluster.
+// Every candidate publishes heartbeats; the live candidate with the lowest
+// id is the leader. A candidate does not claim leadership until it has
+// listened for one full TTL, so it learns about its peers first.
+type Elector struct {
+	sync.Mutex
+
+	id       string
+	mbus     yagnats.NATSConn
+	interval time.Duration
+	ttl      time.Duration
+	logger   *steno.Logger
+
+	peers     map[string]time.Time
+	startedAt time.Time
+	leader    string
+	tasks     []Task
+	taskStop  chan struct{}
+	ticker    *time.Ticker
+	done      chan struct{}
+	sub       *nats.Subscription
+}
+
+func NewElector(id string, mbus yagnats.NATSConn, interval, ttl time.Duration) *Elector {
+	return &Elector{
+		id:       id,
+		mbus:     mbus,
+		interval: interval,
+		ttl:      ttl,
+		logger:   steno.NewLogger("router.leader"),
+		peers:    make(map[string]time.Time),
+	}
+}
+
+// AddTask registers a singleton task. It must be called before Start.
+func (e *Elector) AddTask(t Task) {
+	e.Lock()
+	e.tasks = append(e.tasks, t)
+	e.Unlock()
+}
+
+func (e *Elector) Start() error {
+	sub, err := e.mbus.Subscribe(HeartbeatSubject, func(msg *nats.Msg) {
+		var hb heartbeat
+		err := json.Unmarshal(msg.Data, &hb)
+		if err != nil || hb.Id == "" {
+			e.logger.Warnd(map[string]interface{}{"payload": string(msg.Data)}, "leader.heartbeat.invalid")
+			return
+		}
+
+		e.Lock()
+		e.peers[hb.Id] = time.Now()
+		e.Unlock()
+	})
+	if err != nil {
+		return err
+	}
+
+	e.Lock()
+	e.sub = sub
+	e.startedAt = time.Now()
+	e.ticker = time.NewTicker(e.interval)
+	e.done = make(chan struct{})
+	ticker, done := e.ticker, e.done
+	e.Unlock()
+
+	e.publish()
+
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				e.publish()
+				e.Elect(time.Now())
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return nil
+}
+
+func (e *Elector) Stop() {
+	e.Lock()
+	defer e.Unlock()
+
+	if e.ticker != nil {
+		e.ticker.Stop()
+	}
+	if e.done != nil {
+		// stopping the ticker does not close its channel
+		close(e.done)
+		e.done = nil
+	}
+	if e.sub != nil {
+		e.mbus.Unsubscribe(e.sub)
+	}
+	e.stopTasks()
+	e.leader = ""
+}
+
+// Elect re-evaluates the leader as of now. It is called on every heartbeat
+// interval and is exported so tests can drive the election directly.
+func (e *Elector) Elect(now time.Time) {
+	e.Lock()
+	defer e.Unlock()
+
+	if now.Sub(e.startedAt) < e.ttl {
+		return
+	}
+
+	leader := e.id
+	for id, seen := range e.peers {
+		if now.Sub(seen) > e.ttl {
+			delete(e.peers, id)
+			continue
+		}
+		if id < leader {
+			leader = id
+		}
+	}
+
+	if leader == e.leader {
+		return
+	}
+
+	wasLeader := e.leader == e.id
+	e.leader = leader
+
+	e.logger.Infod(map[string]interface{}{"leader": leader, "self": e.id}, "leader.elected")
+
+	if leader == e.id {
+		e.startTasks()
+	} else if wasLeader {
+		e.stopTasks()
+	}
+}
+
+func (e *Elector) IsLeader() bool {
+	e.Lock()
+	defer e.Unlock()
+
+	return e.leader != "" && e.leader == e.id
+}
+
+func (e *Elector) Leader() string {
+	e.Lock()
+	defer e.Unlock()
+
+	return e.leader
+}
+
+func (e *Elector) MarshalJSON() ([]byte, error) {
+	e.Lock()
+	defer e.Unlock()
+
+	return json.Marshal(struct {
+		Id       string `json:"id"`
+		Leader   string `json:"leader"`
+		IsLeader bool   `json:"is_leader"`
+	}{
+		Id:       e.id,
+		Leader:   e.leader,
+		IsLeader: e.leader != "" && e.leader == e.id,
+	})
+}
+
+func (e *Elector) publish() {
+	b, _ := json.Marshal(heartbeat{Id: e.id})
+	err := e.mbus.Publish(HeartbeatSubject, b)
+	if err != nil {
+		e.logger.Warnf("leader.heartbeat.publish-failed: %s", err)
+	}
+}
+
+// lock must be held
+func (e *Elector) startTasks() {
+	e.taskStop = make(chan struct{})
+	for _, t := range e.tasks {
+		go t(e.taskStop)
+	}
+}
+
+// lock must be held
+func (e *Elector) stopTasks() {
+	if e.taskStop != nil {
+		close(e.taskStop)
+		e.taskStop = nil
+	}
+}
@@ -0,0 +1,110 @@
+package leader_test
+
+import (
+	. "github.com/cloudfoundry/gorouter/leader"
+	"github.com/cloudfoundry/yagnats/fakeyagnats"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"encoding/json"
+	"time"
+)
+
+var _ = Describe("Elector", func() {
+	var mbus *fakeyagnats.FakeNATSConn
+	var a, b *Elector
+	var ttl time.Duration
+
+	BeforeEach(func() {
+		ttl = 100 * time.Millisecond
+		mbus = fakeyagnats.Connect()
+
+		// a long interval keeps the background loop out of the way; the
+		// tests drive the election through Elect
+		a = NewElector("router-a", mbus, time.Hour, ttl)
+		b = NewElector("router-b", mbus, time.Hour, ttl)
+	})
+
+	AfterEach(func() {
+		a.Stop()
+		b.Stop()
+	})
+
+	// beat stands in for the heartbeats the live candidates send every interval
+	beat := func(ids ...string) {
+		for _, id := range ids {
+			mbus.Publish(HeartbeatSubject, []byte(`{"id":"`+id+`"}`))
+		}
+	}
+
+	It("publishes heartbeats", func() {
+		Ω(a.Start()).To(Succeed())
+		Ω(mbus.PublishedMessages(HeartbeatSubject)).To(HaveLen(1))
+	})
+
+	It("does not claim leadership before one ttl has passed", func() {
+		Ω(a.Start()).To(Succeed())
+
+		a.Elect(time.Now())
+		Ω(a.IsLeader()).To(BeFalse())
+		Ω(a.Leader()).To(BeEmpty())
+	})
+
+	It("elects the live candidate with the lowest id", func() {
+		Ω(b.Start()).To(Succeed())
+		Ω(a.Start()).To(Succeed())
+
+		later := time.Now().Add(ttl)
+		beat("router-a", "router-b")
+		a.Elect(later)
+		b.Elect(later)
+
+		Ω(a.IsLeader()).To(BeTrue())
+		Ω(b.IsLeader()).To(BeFalse())
+		Ω(b.Leader()).To(Equal("router-a"))
+	})
+
+	It("takes over when the leader stops sending heartbeats", func() {
+		Ω(a.Start()).To(Succeed())
+		Ω(b.Start()).To(Succeed())
+
+		later := time.Now().Add(ttl)
+		beat("router-a", "router-b")
+		b.Elect(later)
+		Ω(b.IsLeader()).To(BeFalse())
+
+		// router-a goes quiet
+		b.Elect(later.Add(2 * ttl))
+		Ω(b.IsLeader()).To(BeTrue())
+	})
+
+	It("runs tasks only while leading", func() {
+		started := make(chan struct{})
+		stopped := make(chan struct{})
+		b.AddTask(func(stop <-chan struct{}) {
+			close(started)
+			<-stop
+			close(stopped)
+		})
+
+		Ω(b.Start()).To(Succeed())
+		b.Elect(time.Now().Add(ttl))
+		Eventually(started).Should(BeClosed())
+
+		Ω(a.Start()).To(Succeed())
+		later := time.Now().Add(ttl)
+		beat("router-a", "router-b")
+		b.Elect(later)
+		Eventually(stopped).Should(BeClosed())
+		Ω(b.IsLeader()).To(BeFalse())
+	})
+
+	It("marshals its state", func() {
+		Ω(a.Start()).To(Succeed())
+		a.Elect(time.Now().Add(ttl))
+
+		bytes, err := json.Marshal(a)
+		Ω(err).NotTo(HaveOccurred())
+		Ω(bytes).To(MatchJSON(`{"id":"router-a","leader":"router-a","is_leader":true}`))
+	})
+})
@@ -0,0 +1,130 @@
+package limits
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/cloudfoundry/gorouter/config"
+	steno "github.com/cloudfoundry/gosteno"
+)
+
+// Warnings about a limit are logged at most this often so that a flood of
+// violations does not flood the log as well.
+const warnInterval = 10 * time.Second
+
+// Limit caps a value such as a number of connections. In warn mode a
+// violation is only counted and logged, so that a limit can be observed in
+// production before it is enforced.
+type Limit struct {
+	sync.Mutex
+
+	name string
+	max  int64
+	mode string
+
+	violations int64
+	lastWarned time.Time
+	logger     *steno.Logger
+}
+
+var (
+	registeredLock sync.Mutex
+	registered     = make(map[string]*Limit)
+)
+
+// New returns the limit configured by c, or nil when c sets no maximum.
+// A nil limit is never exceeded. The limit replaces any other limit of the
+// same name in the list returned by All.
+func New(name string, c config.LimitConfig) *Limit {
+	if c.Max <= 0 {
+		return nil
+	}
+
+	mode := c.Mode
+	if mode == "" {
+		mode = config.LimitModeEnforce
+	}
+
+	l := &Limit{
+		name:   name,
+		max:    c.Max,
+		mode:   mode,
+		logger: steno.NewLogger("router.limits"),
+	}
+
+	registeredLock.Lock()
+	registered[name] = l
+	registeredLock.Unlock()
+
+	return l
+}
+
+// All returns the configured limits ordered by name.
+func All() []*Limit {
+	registeredLock.Lock()
+	defer registeredLock.Unlock()
+
+	all := make([]*Limit, 0, len(registered))
+	for _, l := range registered {
+		all = append(all, l)
+	}
+	sort.Sort(byName(all))
+
+	return all
+}
+
+type byName []*Limit
+
+func (l byName) Len() int           { return len(l) }
+func (l byName) Less(i, j int) bool { return l[i].name < l[j].name }
+func (l byName) Swap(i, j int)      { l[i], l[j] = l[j], l[i] }
+
+// Exceeded records a violation when value is over the maximum and reports
+// whether the caller should refuse it, which is only the case when the limit
+// is enforced.
+func (l *Limit) Exceeded(value int64) bool {
+	if l == nil || value <= l.max {
+		return false
+	}
+
+	l.Lock()
+	l.violations++
+	warn := time.Since(l.lastWarned) >= warnInterval
+	if warn {
+		l.lastWarned = time.Now()
+	}
+	violations := l.violations
+	l.Unlock()
+
+	if warn {
+		l.logger.Warnd(map[string]interface{}{
+			"limit":      l.name,
+			"mode":       l.mode,
+			"max":        l.max,
+			"value":      value,
+			"violations": violations,
+		}, "limits.exceeded")
+	}
+
+	return l.mode == config.LimitModeEnforce
+}
+
+func (l *Limit) Name() string {
+	return l.name
+}
+
+func (l *Limit) Max() int64 {
+	return l.max
+}
+
+func (l *Limit) Mode() string {
+	return l.mode
+}
+
+func (l *Limit) Violations() int64 {
+	l.Lock()
+	defer l.Unlock()
+
+	return l.violations
+}
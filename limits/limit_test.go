@@ -0,0 +1,50 @@
+package limits_test
+
+import (
+	"github.com/cloudfoundry/gorouter/config"
+	. "github.com/cloudfoundry/gorouter/limits"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Limit", func() {
+	It("is disabled without a maximum", func() {
+		l := New("disabled", config.LimitConfig{Mode: config.LimitModeEnforce})
+		Ω(l).To(BeNil())
+		Ω(l.Exceeded(1000000)).To(BeFalse())
+	})
+
+	It("refuses values over the maximum when enforced", func() {
+		l := New("enforced", config.LimitConfig{Max: 10})
+
+		Ω(l.Mode()).To(Equal(config.LimitModeEnforce))
+		Ω(l.Exceeded(10)).To(BeFalse())
+		Ω(l.Exceeded(11)).To(BeTrue())
+		Ω(l.Violations()).To(BeNumerically("==", 1))
+	})
+
+	It("only counts violations in warn mode", func() {
+		l := New("warned", config.LimitConfig{Max: 10, Mode: config.LimitModeWarn})
+
+		Ω(l.Exceeded(11)).To(BeFalse())
+		Ω(l.Exceeded(12)).To(BeFalse())
+		Ω(l.Violations()).To(BeNumerically("==", 2))
+	})
+
+	It("lists the configured limits by name", func() {
+		b := New("b", config.LimitConfig{Max: 1})
+		a := New("a", config.LimitConfig{Max: 1})
+		replaced := New("a", config.LimitConfig{Max: 2})
+
+		var names []string
+		for _, l := range All() {
+			if l == a {
+				Fail("replaced limit is still listed")
+			}
+			if l == b || l == replaced {
+				names = append(names, l.Name())
+			}
+		}
+		Ω(names).To(Equal([]string{"a", "b"}))
+	})
+})
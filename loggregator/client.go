@@ -0,0 +1,243 @@
+package loggregator
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/cloudfoundry/gorouter/config"
+	"github.com/cloudfoundry/gorouter/telemetry"
+	steno "github.com/cloudfoundry/gosteno"
+)
+
+const sendMethod = "/loggregator.v2.Ingress/Send"
+
+// NewTLSConfig returns the mutual TLS configuration a client needs to talk
+// to a loggregator agent.
+func NewTLSConfig(c config.LoggregatorV2Config) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	caCert, err := ioutil.ReadFile(c.CAFile)
+	if err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caCert) {
+		return nil, fmt.Errorf("no certificates found in %s", c.CAFile)
+	}
+
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		RootCAs:      pool,
+		ServerName:   c.ServerName,
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+// Client sends envelopes in batches to the Ingress service of a loggregator
+// agent over gRPC. Envelopes wait in a bounded buffer while a batch is
+// being sent. When the buffer is full, or a batch cannot be delivered,
+// envelopes are dropped and counted rather than slowing down the proxy.
+type Client struct {
+	url           string
+	httpClient    *http.Client
+	batchSize     int
+	flushInterval time.Duration
+
+	envelopes chan *Envelope
+	stopCh    chan struct{}
+	doneCh    chan struct{}
+	logger    *steno.Logger
+	health    *telemetry.Sink
+
+	sent          uint64
+	dropped       uint64
+	reportedDrops uint64
+}
+
+func NewClient(c config.LoggregatorV2Config, tlsConfig *tls.Config) *Client {
+	transport := &http.Transport{
+		TLSClientConfig:   tlsConfig,
+		ForceAttemptHTTP2: true,
+	}
+
+	return &Client{
+		url: "https://" + c.Address + sendMethod,
+		httpClient: &http.Client{
+			Transport: transport,
+			Timeout:   c.Timeout,
+		},
+		batchSize:     c.BatchSize,
+		flushInterval: c.FlushInterval,
+
+		envelopes: make(chan *Envelope, c.BufferSize),
+		stopCh:    make(chan struct{}),
+		doneCh:    make(chan struct{}),
+		logger:    steno.NewLogger("loggregator"),
+	}
+}
+
+// SetHealth has the outcome of every batch recorded in s.
+func (c *Client) SetHealth(s *telemetry.Sink) {
+	c.health = s
+}
+
+// Emit queues e without blocking.
+func (c *Client) Emit(e *Envelope) {
+	if e.Timestamp == 0 {
+		e.Timestamp = time.Now().UnixNano()
+	}
+
+	select {
+	case c.envelopes <- e:
+	default:
+		atomic.AddUint64(&c.dropped, 1)
+	}
+}
+
+func (c *Client) Sent() uint64 {
+	return atomic.LoadUint64(&c.sent)
+}
+
+func (c *Client) Dropped() uint64 {
+	return atomic.LoadUint64(&c.dropped)
+}
+
+// Run sends a batch when it is full or when the flush interval passes,
+// whichever comes first. It returns after Stop, once the envelopes queued
+// so far have been sent.
+func (c *Client) Run() {
+	defer close(c.doneCh)
+
+	ticker := time.NewTicker(c.flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]*Envelope, 0, c.batchSize)
+	for {
+		select {
+		case e := <-c.envelopes:
+			batch = append(batch, e)
+			if len(batch) >= c.batchSize {
+				batch = c.flush(batch)
+			}
+		case <-ticker.C:
+			batch = c.flush(batch)
+			c.reportDrops()
+		case <-c.stopCh:
+			for {
+				select {
+				case e := <-c.envelopes:
+					batch = append(batch, e)
+					if len(batch) >= c.batchSize {
+						batch = c.flush(batch)
+					}
+				default:
+					c.flush(batch)
+					return
+				}
+			}
+		}
+	}
+}
+
+func (c *Client) Stop() {
+	close(c.stopCh)
+	<-c.doneCh
+}
+
+func (c *Client) flush(batch []*Envelope) []*Envelope {
+	if len(batch) == 0 {
+		return batch
+	}
+
+	err := c.send(batch)
+	if err != nil {
+		atomic.AddUint64(&c.dropped, uint64(len(batch)))
+		c.logger.Warnf("Error sending envelopes to loggregator: %s", err.Error())
+		c.health.Failed(err)
+	} else {
+		atomic.AddUint64(&c.sent, uint64(len(batch)))
+		c.health.Succeeded()
+	}
+
+	for i := range batch {
+		batch[i] = nil
+	}
+	return batch[:0]
+}
+
+// send makes a unary gRPC call: the request and response are each a single
+// length-prefixed message, and the call's outcome is in the grpc-status
+// trailer.
+func (c *Client) send(batch []*Envelope) error {
+	msg := marshalEnvelopeBatch(batch)
+
+	body := make([]byte, 5, 5+len(msg))
+	binary.BigEndian.PutUint32(body[1:], uint32(len(msg)))
+	body = append(body, msg...)
+
+	req, err := http.NewRequest("POST", c.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("Te", "trailers")
+
+	res, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	_, err = io.Copy(ioutil.Discard, res.Body)
+	if err != nil {
+		return err
+	}
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d", res.StatusCode)
+	}
+
+	return grpcError(res)
+}
+
+// grpcError returns the error in the grpc-status of res. A server that
+// fails a call before sending a message puts it in the headers instead of
+// the trailers.
+func grpcError(res *http.Response) error {
+	status := res.Trailer.Get("Grpc-Status")
+	message := res.Trailer.Get("Grpc-Message")
+	if status == "" {
+		status = res.Header.Get("Grpc-Status")
+		message = res.Header.Get("Grpc-Message")
+	}
+
+	switch status {
+	case "0":
+		return nil
+	case "":
+		return errors.New("response has no grpc-status")
+	default:
+		return fmt.Errorf("grpc status %s: %s", status, message)
+	}
+}
+
+func (c *Client) reportDrops() {
+	dropped := c.Dropped()
+	if dropped != c.reportedDrops {
+		c.logger.Warnf("Dropped %d envelopes for loggregator", dropped-c.reportedDrops)
+		c.reportedDrops = dropped
+	}
+}
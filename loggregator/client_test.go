@@ -0,0 +1,219 @@
+package loggregator_test
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/cloudfoundry/gorouter/config"
+	"github.com/cloudfoundry/gorouter/loggregator"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Client", func() {
+	var server *httptest.Server
+	var received chan []*loggregator.Envelope
+	var grpcStatus string
+
+	var c config.LoggregatorV2Config
+	var tlsConfig *tls.Config
+	var client *loggregator.Client
+
+	BeforeEach(func() {
+		received = make(chan []*loggregator.Envelope, 10)
+		grpcStatus = "0"
+
+		server = httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			defer GinkgoRecover()
+
+			Ω(r.ProtoMajor).To(Equal(2))
+			Ω(r.Method).To(Equal("POST"))
+			Ω(r.URL.Path).To(Equal("/loggregator.v2.Ingress/Send"))
+			Ω(r.Header.Get("Content-Type")).To(Equal("application/grpc"))
+			Ω(r.TLS.PeerCertificates).ToNot(BeEmpty())
+
+			body, err := ioutil.ReadAll(r.Body)
+			Ω(err).ToNot(HaveOccurred())
+			Ω(len(body)).To(BeNumerically(">=", 5))
+			Ω(body[0]).To(Equal(byte(0)))
+			Ω(body[5:]).To(HaveLen(int(binary.BigEndian.Uint32(body[1:5]))))
+
+			received <- decodeEnvelopeBatch(body[5:])
+
+			w.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+			w.Header().Set("Content-Type", "application/grpc")
+			w.Write([]byte{0, 0, 0, 0, 0})
+			w.Header().Set("Grpc-Status", grpcStatus)
+			w.Header().Set("Grpc-Message", "unavailable")
+		}))
+		server.EnableHTTP2 = true
+		server.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+		server.StartTLS()
+
+		pool := x509.NewCertPool()
+		pool.AddCert(server.Certificate())
+		tlsConfig = &tls.Config{
+			RootCAs:      pool,
+			Certificates: server.TLS.Certificates,
+		}
+
+		c = config.LoggregatorV2Config{
+			Address:       server.Listener.Addr().String(),
+			BatchSize:     2,
+			BufferSize:    10,
+			FlushInterval: time.Hour,
+			Timeout:       5 * time.Second,
+		}
+	})
+
+	JustBeforeEach(func() {
+		client = loggregator.NewClient(c, tlsConfig)
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("sends a batch over gRPC once it is full", func() {
+		go client.Run()
+		defer client.Stop()
+
+		client.Emit(&loggregator.Envelope{SourceId: "app-1", Counter: &loggregator.Counter{Name: "a", Delta: 1, Total: 1}})
+		client.Emit(&loggregator.Envelope{SourceId: "app-2", Counter: &loggregator.Counter{Name: "b", Delta: 2, Total: 3}})
+
+		var batch []*loggregator.Envelope
+		Eventually(received).Should(Receive(&batch))
+		Ω(batch).To(HaveLen(2))
+		Ω(batch[0].SourceId).To(Equal("app-1"))
+		Ω(batch[0].Timestamp).ToNot(BeZero())
+		Ω(batch[1].Counter).To(Equal(&loggregator.Counter{Name: "b", Delta: 2, Total: 3}))
+
+		Eventually(client.Sent).Should(Equal(uint64(2)))
+		Ω(client.Dropped()).To(BeZero())
+	})
+
+	It("encodes every kind of envelope", func() {
+		envelopes := []*loggregator.Envelope{
+			{
+				Timestamp:  1,
+				SourceId:   "app",
+				InstanceId: "0",
+				Tags:       map[string]string{"source_type": "RTR"},
+				Log:        &loggregator.Log{Payload: []byte("line"), Type: loggregator.LogTypeErr},
+			},
+			{
+				Timestamp: 2,
+				SourceId:  "gorouter",
+				Gauge: &loggregator.Gauge{Metrics: map[string]loggregator.GaugeValue{
+					"total_routes": {Unit: "routes", Value: 12.5},
+				}},
+			},
+			{
+				Timestamp: 3,
+				Tags:      map[string]string{"method": "GET", "status_code": "200"},
+				Timer:     &loggregator.Timer{Name: "http", Start: 100, Stop: 200},
+			},
+		}
+
+		c.BatchSize = len(envelopes)
+		client = loggregator.NewClient(c, tlsConfig)
+		go client.Run()
+		defer client.Stop()
+
+		for _, e := range envelopes {
+			client.Emit(e)
+		}
+
+		Eventually(received).Should(Receive(Equal(envelopes)))
+	})
+
+	Context("when the flush interval passes", func() {
+		BeforeEach(func() {
+			c.BatchSize = 100
+			c.FlushInterval = 50 * time.Millisecond
+		})
+
+		It("sends a partial batch", func() {
+			go client.Run()
+			defer client.Stop()
+
+			client.Emit(&loggregator.Envelope{SourceId: "app"})
+
+			var batch []*loggregator.Envelope
+			Eventually(received).Should(Receive(&batch))
+			Ω(batch).To(HaveLen(1))
+		})
+	})
+
+	It("sends what is queued when stopped", func() {
+		c.BatchSize = 100
+		client = loggregator.NewClient(c, tlsConfig)
+		go client.Run()
+
+		client.Emit(&loggregator.Envelope{SourceId: "app"})
+		client.Stop()
+
+		Ω(received).To(Receive())
+		Ω(client.Sent()).To(Equal(uint64(1)))
+	})
+
+	It("drops envelopes when the buffer is full", func() {
+		c.BufferSize = 1
+		client = loggregator.NewClient(c, tlsConfig)
+
+		client.Emit(&loggregator.Envelope{SourceId: "app"})
+		client.Emit(&loggregator.Envelope{SourceId: "app"})
+
+		Ω(client.Dropped()).To(Equal(uint64(1)))
+	})
+
+	Context("when the agent fails the call", func() {
+		BeforeEach(func() {
+			grpcStatus = "14"
+		})
+
+		It("drops the batch", func() {
+			go client.Run()
+			defer client.Stop()
+
+			client.Emit(&loggregator.Envelope{SourceId: "app"})
+			client.Emit(&loggregator.Envelope{SourceId: "app"})
+
+			Eventually(client.Dropped).Should(Equal(uint64(2)))
+			Ω(client.Sent()).To(BeZero())
+		})
+	})
+
+	Context("without a client certificate", func() {
+		BeforeEach(func() {
+			tlsConfig.Certificates = nil
+		})
+
+		It("cannot deliver", func() {
+			go client.Run()
+			defer client.Stop()
+
+			client.Emit(&loggregator.Envelope{SourceId: "app"})
+			client.Emit(&loggregator.Envelope{SourceId: "app"})
+
+			Eventually(client.Dropped).Should(Equal(uint64(2)))
+			Ω(received).ToNot(Receive())
+		})
+	})
+
+	Describe("NewTLSConfig", func() {
+		It("fails when the certificate cannot be loaded", func() {
+			_, err := loggregator.NewTLSConfig(config.LoggregatorV2Config{
+				CAFile:   "/does/not/exist/ca.crt",
+				CertFile: "/does/not/exist/client.crt",
+				KeyFile:  "/does/not/exist/client.key",
+			})
+			Ω(err).To(HaveOccurred())
+		})
+	})
+})
@@ -0,0 +1,122 @@
+package loggregator
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/cloudfoundry/gorouter/access_log"
+	"github.com/cloudfoundry/gorouter/route"
+)
+
+// EnvelopeEmitter takes envelopes to send. Client is the one the router
+// uses.
+type EnvelopeEmitter interface {
+	Emit(e *Envelope)
+}
+
+// AccessLogEmitter sends an "http" timer, the v2 counterpart of an
+// HttpStartStop event, for every request that passes through the access
+// log. Records of requests routed to an application are also sent as a log
+// line of that application. Every record is then handed on to the next
+// logger.
+type AccessLogEmitter struct {
+	next       access_log.AccessLogger
+	emitter    EnvelopeEmitter
+	instanceId string
+}
+
+func NewAccessLogEmitter(next access_log.AccessLogger, emitter EnvelopeEmitter, instanceId string) *AccessLogEmitter {
+	return &AccessLogEmitter{
+		next:       next,
+		emitter:    emitter,
+		instanceId: instanceId,
+	}
+}
+
+func (e *AccessLogEmitter) Run() {
+	e.next.Run()
+}
+
+func (e *AccessLogEmitter) Stop() {
+	e.next.Stop()
+}
+
+func (e *AccessLogEmitter) Log(record access_log.AccessLogRecord) {
+	if timer := NewHttpTimer(&record); timer != nil {
+		e.emitter.Emit(timer)
+	}
+
+	if record.ApplicationId() != "" {
+		e.emitter.Emit(&Envelope{
+			SourceId:   record.ApplicationId(),
+			InstanceId: e.instanceId,
+			Tags:       withEndpointTags(map[string]string{"source_type": "RTR"}, record.RouteEndpoint),
+			Log: &Log{
+				Payload: []byte(record.LogMessage()),
+				Type:    LogTypeOut,
+			},
+		})
+	}
+
+	e.next.Log(record)
+}
+
+// NewHttpTimer describes the request of r the way loggregator converts an
+// HttpStartStop event to v2. The source is the application that served the
+// request. It returns nil when r carries no request.
+func NewHttpTimer(r *access_log.AccessLogRecord) *Envelope {
+	if r.Request == nil {
+		return nil
+	}
+
+	stoppedAt := r.FinishedAt
+	if stoppedAt.IsZero() {
+		stoppedAt = time.Now()
+	}
+
+	tags := map[string]string{
+		"request_id":     r.RequestId(),
+		"peer_type":      "Server",
+		"method":         r.Request.Method,
+		"uri":            fmt.Sprintf("%s%s", r.Request.Host, r.Request.URL.Path),
+		"remote_address": r.Request.RemoteAddr,
+		"user_agent":     r.Request.UserAgent(),
+		"status_code":    strconv.Itoa(r.StatusCode),
+		"content_length": strconv.FormatInt(r.BodyBytesSent, 10),
+	}
+
+	envelope := &Envelope{
+		Tags: tags,
+		Timer: &Timer{
+			Name:  "http",
+			Start: r.StartedAt.UnixNano(),
+			Stop:  stoppedAt.UnixNano(),
+		},
+	}
+
+	if endpoint := r.RouteEndpoint; endpoint != nil {
+		envelope.SourceId = endpoint.ApplicationId
+		envelope.InstanceId = endpoint.PrivateInstanceIndex
+		tags["instance_index"] = endpoint.PrivateInstanceIndex
+		tags["instance_id"] = endpoint.PrivateInstanceId
+		withEndpointTags(tags, endpoint)
+	}
+
+	return envelope
+}
+
+// withEndpointTags adds the tags endpoint was registered with to tags,
+// short of those that would replace one of the envelope's own, and returns
+// tags.
+func withEndpointTags(tags map[string]string, endpoint *route.Endpoint) map[string]string {
+	if endpoint == nil {
+		return tags
+	}
+	for name, value := range endpoint.Tags {
+		if _, ok := tags[name]; !ok {
+			tags[name] = value
+		}
+	}
+	return tags
+}
@@ -0,0 +1,120 @@
+package loggregator_test
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/cloudfoundry/gorouter/access_log"
+	"github.com/cloudfoundry/gorouter/common/correlation"
+	"github.com/cloudfoundry/gorouter/loggregator"
+	"github.com/cloudfoundry/gorouter/route"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("AccessLogEmitter", func() {
+	var next *fakeAccessLogger
+	var emitter *fakeEmitter
+	var accessLogEmitter *loggregator.AccessLogEmitter
+	var record access_log.AccessLogRecord
+
+	BeforeEach(func() {
+		next = &fakeAccessLogger{}
+		emitter = &fakeEmitter{}
+		accessLogEmitter = loggregator.NewAccessLogEmitter(next, emitter, "2")
+
+		request, _ := http.NewRequest("GET", "http://app.example.com/path?q=1", nil)
+		request.RemoteAddr = "10.0.0.1:5000"
+		request.Header.Set("User-Agent", "curl")
+		request = correlation.WithID(request, correlation.ID{RequestId: "0f5a4d1e-5b8c-4b9a-9e1f-3c2d1a0b9e8f"})
+
+		endpoint := route.NewEndpoint("app-guid", "192.168.1.1", 1234, "instance-guid", nil, -1)
+		endpoint.PrivateInstanceIndex = "3"
+
+		record = access_log.AccessLogRecord{
+			Request:       request,
+			StatusCode:    201,
+			RouteEndpoint: endpoint,
+			StartedAt:     time.Unix(100, 0),
+			FinishedAt:    time.Unix(101, 0),
+			BodyBytesSent: 42,
+		}
+	})
+
+	It("sends an http timer for the application instance that served the request", func() {
+		accessLogEmitter.Log(record)
+
+		Ω(emitter.Envelopes()).To(HaveLen(2))
+		timer := emitter.Envelopes()[0]
+		Ω(timer.SourceId).To(Equal("app-guid"))
+		Ω(timer.InstanceId).To(Equal("3"))
+		Ω(timer.Timer).To(Equal(&loggregator.Timer{Name: "http", Start: 100e9, Stop: 101e9}))
+		Ω(timer.Tags).To(Equal(map[string]string{
+			"request_id":     "0f5a4d1e-5b8c-4b9a-9e1f-3c2d1a0b9e8f",
+			"peer_type":      "Server",
+			"method":         "GET",
+			"uri":            "app.example.com/path",
+			"remote_address": "10.0.0.1:5000",
+			"user_agent":     "curl",
+			"status_code":    "201",
+			"content_length": "42",
+			"instance_index": "3",
+			"instance_id":    "instance-guid",
+		}))
+	})
+
+	It("sends the access log line as a log of the application", func() {
+		accessLogEmitter.Log(record)
+
+		Ω(emitter.Envelopes()).To(HaveLen(2))
+		log := emitter.Envelopes()[1]
+		Ω(log.SourceId).To(Equal("app-guid"))
+		Ω(log.InstanceId).To(Equal("2"))
+		Ω(log.Tags).To(Equal(map[string]string{"source_type": "RTR"}))
+		Ω(string(log.Log.Payload)).To(Equal(record.LogMessage()))
+	})
+
+	It("tags both with the tags of the endpoint, short of the envelope's own", func() {
+		record.RouteEndpoint.Tags = map[string]string{"space": "prod", "method": "POST", "source_type": "APP"}
+		accessLogEmitter.Log(record)
+
+		timer, log := emitter.Envelopes()[0], emitter.Envelopes()[1]
+		Ω(timer.Tags).To(HaveKeyWithValue("space", "prod"))
+		Ω(timer.Tags).To(HaveKeyWithValue("method", "GET"))
+		Ω(log.Tags).To(Equal(map[string]string{"source_type": "RTR", "space": "prod", "method": "POST"}))
+	})
+
+	It("hands the record on to the next logger", func() {
+		accessLogEmitter.Log(record)
+
+		Ω(next.records).To(HaveLen(1))
+	})
+
+	Context("when the request was not routed to an application", func() {
+		BeforeEach(func() {
+			record.RouteEndpoint = nil
+		})
+
+		It("only sends the timer", func() {
+			accessLogEmitter.Log(record)
+
+			Ω(emitter.Envelopes()).To(HaveLen(1))
+			Ω(emitter.Envelopes()[0].Timer).ToNot(BeNil())
+			Ω(emitter.Envelopes()[0].SourceId).To(BeEmpty())
+		})
+	})
+
+	Context("when the record has no request", func() {
+		BeforeEach(func() {
+			record.Request = nil
+			record.RouteEndpoint = nil
+		})
+
+		It("sends nothing", func() {
+			accessLogEmitter.Log(record)
+
+			Ω(emitter.Envelopes()).To(BeEmpty())
+			Ω(next.records).To(HaveLen(1))
+		})
+	})
+})
@@ -0,0 +1,196 @@
+package loggregator
+
+import (
+	"encoding/binary"
+	"math"
+	"sort"
+)
+
+type LogType int
+
+const (
+	LogTypeOut LogType = 0
+	LogTypeErr LogType = 1
+)
+
+// Envelope is a loggregator v2 envelope. Exactly one of Log, Counter,
+// Gauge and Timer is set.
+type Envelope struct {
+	Timestamp  int64
+	SourceId   string
+	InstanceId string
+	Tags       map[string]string
+
+	Log     *Log
+	Counter *Counter
+	Gauge   *Gauge
+	Timer   *Timer
+}
+
+type Log struct {
+	Payload []byte
+	Type    LogType
+}
+
+type Counter struct {
+	Name  string
+	Delta uint64
+	Total uint64
+}
+
+type GaugeValue struct {
+	Unit  string
+	Value float64
+}
+
+type Gauge struct {
+	Metrics map[string]GaugeValue
+}
+
+type Timer struct {
+	Name  string
+	Start int64
+	Stop  int64
+}
+
+// marshalEnvelopeBatch encodes envelopes as a loggregator.v2.EnvelopeBatch
+// protocol buffer message.
+func marshalEnvelopeBatch(envelopes []*Envelope) []byte {
+	e := &protoEncoder{}
+	for _, envelope := range envelopes {
+		e.message(1, envelope.marshal)
+	}
+	return e.b
+}
+
+func (x *Envelope) marshal(e *protoEncoder) {
+	e.varint(1, uint64(x.Timestamp))
+	e.string(2, x.SourceId)
+
+	switch {
+	case x.Log != nil:
+		e.message(4, func(e *protoEncoder) {
+			e.bytes(1, x.Log.Payload)
+			e.varint(2, uint64(x.Log.Type))
+		})
+	case x.Counter != nil:
+		e.message(5, func(e *protoEncoder) {
+			e.string(1, x.Counter.Name)
+			e.varint(2, x.Counter.Delta)
+			e.varint(3, x.Counter.Total)
+		})
+	case x.Gauge != nil:
+		e.message(6, func(e *protoEncoder) {
+			for _, name := range sortedKeys(x.Gauge.Metrics) {
+				value := x.Gauge.Metrics[name]
+				e.message(1, func(e *protoEncoder) {
+					e.string(1, name)
+					e.message(2, func(e *protoEncoder) {
+						e.string(1, value.Unit)
+						e.double(2, value.Value)
+					})
+				})
+			}
+		})
+	case x.Timer != nil:
+		e.message(7, func(e *protoEncoder) {
+			e.string(1, x.Timer.Name)
+			e.varint(2, uint64(x.Timer.Start))
+			e.varint(3, uint64(x.Timer.Stop))
+		})
+	}
+
+	e.string(8, x.InstanceId)
+
+	for _, key := range sortedStringKeys(x.Tags) {
+		value := x.Tags[key]
+		e.message(9, func(e *protoEncoder) {
+			e.string(1, key)
+			e.string(2, value)
+		})
+	}
+}
+
+func sortedKeys(m map[string]GaugeValue) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func sortedStringKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// protoEncoder writes protocol buffer fields. Like proto3 it leaves out
+// fields with zero values.
+type protoEncoder struct {
+	b []byte
+}
+
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+)
+
+func (e *protoEncoder) key(field int, wireType int) {
+	e.b = appendVarint(e.b, uint64(field<<3|wireType))
+}
+
+func (e *protoEncoder) varint(field int, v uint64) {
+	if v == 0 {
+		return
+	}
+	e.key(field, wireVarint)
+	e.b = appendVarint(e.b, v)
+}
+
+func (e *protoEncoder) double(field int, v float64) {
+	if v == 0 {
+		return
+	}
+	e.key(field, wireFixed64)
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], math.Float64bits(v))
+	e.b = append(e.b, buf[:]...)
+}
+
+func (e *protoEncoder) bytes(field int, v []byte) {
+	if len(v) == 0 {
+		return
+	}
+	e.key(field, wireBytes)
+	e.b = appendVarint(e.b, uint64(len(v)))
+	e.b = append(e.b, v...)
+}
+
+func (e *protoEncoder) string(field int, v string) {
+	e.bytes(field, []byte(v))
+}
+
+// message writes the embedded message that f encodes, even when it is
+// empty, since its presence alone can be meaningful.
+func (e *protoEncoder) message(field int, f func(*protoEncoder)) {
+	inner := &protoEncoder{}
+	f(inner)
+
+	e.key(field, wireBytes)
+	e.b = appendVarint(e.b, uint64(len(inner.b)))
+	e.b = append(e.b, inner.b...)
+}
+
+func appendVarint(b []byte, v uint64) []byte {
+	for v >= 0x80 {
+		b = append(b, byte(v)|0x80)
+		v >>= 7
+	}
+	return append(b, byte(v))
+}
@@ -0,0 +1,180 @@
+package loggregator_test
+
+import (
+	"encoding/binary"
+	"math"
+	"sync"
+
+	"github.com/cloudfoundry/gorouter/access_log"
+	"github.com/cloudfoundry/gorouter/loggregator"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestLoggregator(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Loggregator Suite")
+}
+
+type fakeAccessLogger struct {
+	records []access_log.AccessLogRecord
+}
+
+func (f *fakeAccessLogger) Run()  {}
+func (f *fakeAccessLogger) Stop() {}
+func (f *fakeAccessLogger) Log(record access_log.AccessLogRecord) {
+	f.records = append(f.records, record)
+}
+
+type fakeEmitter struct {
+	sync.Mutex
+	envelopes []*loggregator.Envelope
+}
+
+func (f *fakeEmitter) Emit(e *loggregator.Envelope) {
+	f.Lock()
+	defer f.Unlock()
+	f.envelopes = append(f.envelopes, e)
+}
+
+func (f *fakeEmitter) Envelopes() []*loggregator.Envelope {
+	f.Lock()
+	defer f.Unlock()
+	return f.envelopes
+}
+
+type protoField struct {
+	num   int
+	value uint64
+	bytes []byte
+}
+
+func protoFields(b []byte) []protoField {
+	var fields []protoField
+	for len(b) > 0 {
+		key, n := binary.Uvarint(b)
+		Ω(n).Should(BeNumerically(">", 0))
+		b = b[n:]
+
+		f := protoField{num: int(key >> 3)}
+		switch key & 7 {
+		case 0:
+			f.value, n = binary.Uvarint(b)
+			Ω(n).Should(BeNumerically(">", 0))
+			b = b[n:]
+		case 1:
+			Ω(len(b)).Should(BeNumerically(">=", 8))
+			f.value = binary.LittleEndian.Uint64(b)
+			b = b[8:]
+		case 2:
+			length, n := binary.Uvarint(b)
+			Ω(n).Should(BeNumerically(">", 0))
+			b = b[n:]
+			Ω(uint64(len(b))).Should(BeNumerically(">=", length))
+			f.bytes = b[:length]
+			b = b[length:]
+		default:
+			Fail("unexpected wire type")
+		}
+		fields = append(fields, f)
+	}
+	return fields
+}
+
+func decodeEnvelopeBatch(b []byte) []*loggregator.Envelope {
+	var envelopes []*loggregator.Envelope
+	for _, f := range protoFields(b) {
+		Ω(f.num).Should(Equal(1))
+		envelopes = append(envelopes, decodeEnvelope(f.bytes))
+	}
+	return envelopes
+}
+
+func decodeEnvelope(b []byte) *loggregator.Envelope {
+	e := &loggregator.Envelope{}
+	for _, f := range protoFields(b) {
+		switch f.num {
+		case 1:
+			e.Timestamp = int64(f.value)
+		case 2:
+			e.SourceId = string(f.bytes)
+		case 4:
+			e.Log = &loggregator.Log{}
+			for _, g := range protoFields(f.bytes) {
+				switch g.num {
+				case 1:
+					e.Log.Payload = g.bytes
+				case 2:
+					e.Log.Type = loggregator.LogType(g.value)
+				}
+			}
+		case 5:
+			e.Counter = &loggregator.Counter{}
+			for _, g := range protoFields(f.bytes) {
+				switch g.num {
+				case 1:
+					e.Counter.Name = string(g.bytes)
+				case 2:
+					e.Counter.Delta = g.value
+				case 3:
+					e.Counter.Total = g.value
+				}
+			}
+		case 6:
+			e.Gauge = &loggregator.Gauge{Metrics: make(map[string]loggregator.GaugeValue)}
+			for _, entry := range protoFields(f.bytes) {
+				var name string
+				var value loggregator.GaugeValue
+				for _, g := range protoFields(entry.bytes) {
+					switch g.num {
+					case 1:
+						name = string(g.bytes)
+					case 2:
+						for _, h := range protoFields(g.bytes) {
+							switch h.num {
+							case 1:
+								value.Unit = string(h.bytes)
+							case 2:
+								value.Value = math.Float64frombits(h.value)
+							}
+						}
+					}
+				}
+				e.Gauge.Metrics[name] = value
+			}
+		case 7:
+			e.Timer = &loggregator.Timer{}
+			for _, g := range protoFields(f.bytes) {
+				switch g.num {
+				case 1:
+					e.Timer.Name = string(g.bytes)
+				case 2:
+					e.Timer.Start = int64(g.value)
+				case 3:
+					e.Timer.Stop = int64(g.value)
+				}
+			}
+		case 8:
+			e.InstanceId = string(f.bytes)
+		case 9:
+			if e.Tags == nil {
+				e.Tags = make(map[string]string)
+			}
+			var key, value string
+			for _, g := range protoFields(f.bytes) {
+				switch g.num {
+				case 1:
+					key = string(g.bytes)
+				case 2:
+					value = string(g.bytes)
+				}
+			}
+			e.Tags[key] = value
+		default:
+			Fail("unexpected envelope field")
+		}
+	}
+	return e
+}
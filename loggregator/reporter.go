@@ -0,0 +1,147 @@
+package loggregator
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/cloudfoundry/gorouter/route"
+)
+
+type RouteTable interface {
+	NumUris() int
+	NumEndpoints() int
+}
+
+var counterNames = []string{
+	"total_requests",
+	"bad_requests",
+	"bad_gateways",
+	"rate_limited_requests",
+	"client_denied_requests",
+	"backend_closed_connections",
+	"responses.2xx",
+	"responses.3xx",
+	"responses.4xx",
+	"responses.5xx",
+	"responses.xxx",
+}
+
+// MetricsReporter counts what the proxy reports and periodically sends
+// the counts as counter envelopes, along with the size of the route table
+// as gauges, all with the router as their source.
+type MetricsReporter struct {
+	sync.Mutex
+
+	emitter    EnvelopeEmitter
+	routeTable RouteTable
+	sourceId   string
+	instanceId string
+
+	totals   map[string]uint64
+	reported map[string]uint64
+}
+
+func NewMetricsReporter(emitter EnvelopeEmitter, routeTable RouteTable, sourceId, instanceId string) *MetricsReporter {
+	return &MetricsReporter{
+		emitter:    emitter,
+		routeTable: routeTable,
+		sourceId:   sourceId,
+		instanceId: instanceId,
+		totals:     make(map[string]uint64),
+		reported:   make(map[string]uint64),
+	}
+}
+
+func (r *MetricsReporter) CaptureBadRequest(req *http.Request) {
+	r.increment("bad_requests")
+}
+
+func (r *MetricsReporter) CaptureBadGateway(req *http.Request) {
+	r.increment("bad_gateways")
+}
+
+func (r *MetricsReporter) CaptureRateLimited(b *route.Endpoint, req *http.Request) {
+	r.increment("rate_limited_requests")
+}
+
+func (r *MetricsReporter) CaptureClientDenied(req *http.Request) {
+	r.increment("client_denied_requests")
+}
+
+func (r *MetricsReporter) CaptureBackendClosedConnection(b *route.Endpoint, req *http.Request) {
+	r.increment("backend_closed_connections")
+}
+
+func (r *MetricsReporter) CaptureRoutingRequest(b *route.Endpoint, req *http.Request) {
+	r.increment("total_requests")
+}
+
+func (r *MetricsReporter) CaptureRoutingResponse(b *route.Endpoint, res *http.Response, t time.Time, d time.Duration) {
+	name := "responses.xxx"
+	if res != nil {
+		switch res.StatusCode / 100 {
+		case 2:
+			name = "responses.2xx"
+		case 3:
+			name = "responses.3xx"
+		case 4:
+			name = "responses.4xx"
+		case 5:
+			name = "responses.5xx"
+		}
+	}
+	r.increment(name)
+}
+
+func (r *MetricsReporter) increment(name string) {
+	r.Lock()
+	r.totals[name]++
+	r.Unlock()
+}
+
+// Report sends every counter with what it gained since the last report,
+// and the route table gauges.
+func (r *MetricsReporter) Report() {
+	r.Lock()
+	for _, name := range counterNames {
+		total := r.totals[name]
+		r.emitter.Emit(&Envelope{
+			SourceId:   r.sourceId,
+			InstanceId: r.instanceId,
+			Counter: &Counter{
+				Name:  name,
+				Delta: total - r.reported[name],
+				Total: total,
+			},
+		})
+		r.reported[name] = total
+	}
+	r.Unlock()
+
+	r.emitter.Emit(&Envelope{
+		SourceId:   r.sourceId,
+		InstanceId: r.instanceId,
+		Gauge: &Gauge{
+			Metrics: map[string]GaugeValue{
+				"total_routes":    {Unit: "routes", Value: float64(r.routeTable.NumUris())},
+				"total_endpoints": {Unit: "endpoints", Value: float64(r.routeTable.NumEndpoints())},
+			},
+		},
+	})
+}
+
+// Run reports every interval until stopCh is closed.
+func (r *MetricsReporter) Run(interval time.Duration, stopCh <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			r.Report()
+		case <-stopCh:
+			return
+		}
+	}
+}
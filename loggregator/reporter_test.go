@@ -0,0 +1,99 @@
+package loggregator_test
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/cloudfoundry/gorouter/loggregator"
+	"github.com/cloudfoundry/gorouter/route"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type fakeRouteTable struct{}
+
+func (fakeRouteTable) NumUris() int      { return 4 }
+func (fakeRouteTable) NumEndpoints() int { return 7 }
+
+var _ = Describe("MetricsReporter", func() {
+	var emitter *fakeEmitter
+	var reporter *loggregator.MetricsReporter
+
+	BeforeEach(func() {
+		emitter = &fakeEmitter{}
+		reporter = loggregator.NewMetricsReporter(emitter, fakeRouteTable{}, "gorouter", "router_z1_0")
+	})
+
+	counters := func() map[string]loggregator.Counter {
+		y := make(map[string]loggregator.Counter)
+		for _, e := range emitter.Envelopes() {
+			if e.Counter != nil {
+				y[e.Counter.Name] = *e.Counter
+			}
+		}
+		return y
+	}
+
+	It("reports counters with the router as their source", func() {
+		req, _ := http.NewRequest("GET", "http://app.example.com", nil)
+		endpoint := route.NewEndpoint("app-guid", "192.168.1.1", 1234, "instance-guid", nil, -1)
+
+		reporter.CaptureRoutingRequest(endpoint, req)
+		reporter.CaptureRoutingRequest(endpoint, req)
+		reporter.CaptureRoutingResponse(endpoint, &http.Response{StatusCode: 200}, time.Now(), time.Millisecond)
+		reporter.CaptureRoutingResponse(endpoint, nil, time.Now(), time.Millisecond)
+		reporter.CaptureBadGateway(req)
+		reporter.CaptureBadRequest(req)
+		reporter.CaptureRateLimited(endpoint, req)
+		reporter.CaptureClientDenied(req)
+		reporter.CaptureBackendClosedConnection(endpoint, req)
+
+		reporter.Report()
+
+		for _, e := range emitter.Envelopes() {
+			Ω(e.SourceId).To(Equal("gorouter"))
+			Ω(e.InstanceId).To(Equal("router_z1_0"))
+		}
+
+		y := counters()
+		Ω(y["total_requests"]).To(Equal(loggregator.Counter{Name: "total_requests", Delta: 2, Total: 2}))
+		Ω(y["responses.2xx"].Total).To(Equal(uint64(1)))
+		Ω(y["responses.xxx"].Total).To(Equal(uint64(1)))
+		Ω(y["bad_gateways"].Total).To(Equal(uint64(1)))
+		Ω(y["bad_requests"].Total).To(Equal(uint64(1)))
+		Ω(y["rate_limited_requests"].Total).To(Equal(uint64(1)))
+		Ω(y["client_denied_requests"].Total).To(Equal(uint64(1)))
+		Ω(y["backend_closed_connections"].Total).To(Equal(uint64(1)))
+		Ω(y["responses.5xx"].Total).To(BeZero())
+	})
+
+	It("reports the change since the last report as the delta", func() {
+		req, _ := http.NewRequest("GET", "http://app.example.com", nil)
+
+		reporter.CaptureBadRequest(req)
+		reporter.Report()
+		reporter.CaptureBadRequest(req)
+		reporter.CaptureBadRequest(req)
+		emitter.envelopes = nil
+		reporter.Report()
+
+		Ω(counters()["bad_requests"]).To(Equal(loggregator.Counter{Name: "bad_requests", Delta: 2, Total: 3}))
+	})
+
+	It("reports the size of the route table as gauges", func() {
+		reporter.Report()
+
+		var gauge *loggregator.Gauge
+		for _, e := range emitter.Envelopes() {
+			if e.Gauge != nil {
+				gauge = e.Gauge
+			}
+		}
+
+		Ω(gauge).ToNot(BeNil())
+		Ω(gauge.Metrics).To(Equal(map[string]loggregator.GaugeValue{
+			"total_routes":    {Unit: "routes", Value: 4},
+			"total_endpoints": {Unit: "endpoints", Value: 7},
+		}))
+	})
+})
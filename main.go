@@ -6,30 +6,73 @@ import (
 	"github.com/cloudfoundry-incubator/routing-api"
 	token_fetcher "github.com/cloudfoundry-incubator/uaa-token-fetcher"
 	"github.com/cloudfoundry/dropsonde"
+	"github.com/cloudfoundry/dropsonde/emitter"
+	dropsonde_metrics "github.com/cloudfoundry/dropsonde/metrics"
 	"github.com/cloudfoundry/gorouter/access_log"
+	"github.com/cloudfoundry/gorouter/analytics"
+	"github.com/cloudfoundry/gorouter/banner"
+	"github.com/cloudfoundry/gorouter/cache"
+	"github.com/cloudfoundry/gorouter/capture"
+	"github.com/cloudfoundry/gorouter/certexpiry"
+	"github.com/cloudfoundry/gorouter/clock"
 	vcap "github.com/cloudfoundry/gorouter/common"
+	"github.com/cloudfoundry/gorouter/compression"
 	"github.com/cloudfoundry/gorouter/config"
+	"github.com/cloudfoundry/gorouter/consul"
+	"github.com/cloudfoundry/gorouter/decisionlog"
+	"github.com/cloudfoundry/gorouter/dryrun"
+	"github.com/cloudfoundry/gorouter/errorpages"
+	"github.com/cloudfoundry/gorouter/handoff"
+	"github.com/cloudfoundry/gorouter/headerrules"
+	"github.com/cloudfoundry/gorouter/healthcheck"
+	"github.com/cloudfoundry/gorouter/healthdetail"
+	"github.com/cloudfoundry/gorouter/identity"
+	"github.com/cloudfoundry/gorouter/inspection"
+	"github.com/cloudfoundry/gorouter/ipfilter"
+	"github.com/cloudfoundry/gorouter/jwtauth"
+	"github.com/cloudfoundry/gorouter/kubernetes"
+	"github.com/cloudfoundry/gorouter/limits"
+	"github.com/cloudfoundry/gorouter/loggregator"
+	"github.com/cloudfoundry/gorouter/maintenance"
+	"github.com/cloudfoundry/gorouter/mbus"
+	"github.com/cloudfoundry/gorouter/metrics"
+	"github.com/cloudfoundry/gorouter/mirror"
+	"github.com/cloudfoundry/gorouter/oauth2proxy"
+	"github.com/cloudfoundry/gorouter/peer"
 	"github.com/cloudfoundry/gorouter/proxy"
+	"github.com/cloudfoundry/gorouter/ratelimit"
 	rregistry "github.com/cloudfoundry/gorouter/registry"
+	"github.com/cloudfoundry/gorouter/requestfilter"
+	"github.com/cloudfoundry/gorouter/requestqueue"
 	"github.com/cloudfoundry/gorouter/route_fetcher"
 	"github.com/cloudfoundry/gorouter/router"
+	"github.com/cloudfoundry/gorouter/signedurl"
+	"github.com/cloudfoundry/gorouter/stickysession"
+	"github.com/cloudfoundry/gorouter/telemetry"
+	"github.com/cloudfoundry/gorouter/tracing"
+	"github.com/cloudfoundry/gorouter/usage"
 	rvarz "github.com/cloudfoundry/gorouter/varz"
 	steno "github.com/cloudfoundry/gosteno"
-	"github.com/cloudfoundry/yagnats"
 
+	"encoding/json"
 	"flag"
 	"fmt"
+	"net/http"
 	"os"
 	"os/signal"
+	"reflect"
 	"runtime"
+	"strconv"
 	"syscall"
 	"time"
 )
 
 var configFile string
+var simulateFile string
 
 func init() {
 	flag.StringVar(&configFile, "c", "", "Configuration File")
+	flag.StringVar(&simulateFile, "simulate", "", "Capture file to replay against the configuration, reporting the requests it would answer differently")
 
 	flag.Parse()
 }
@@ -45,6 +88,10 @@ func main() {
 	InitLoggerFromConfig(c, logCounter)
 	logger := steno.NewLogger("router.main")
 
+	if simulateFile != "" {
+		os.Exit(simulate(c, simulateFile))
+	}
+
 	err := dropsonde.Initialize(c.Logging.MetronAddress, c.Logging.JobName)
 	if err != nil {
 		logger.Errorf("Dropsonde failed to initialize: %s", err.Error())
@@ -60,11 +107,11 @@ func main() {
 		cf_debug_server.Run(c.DebugAddr)
 	}
 
-	natsServers := c.NatsServers()
-	var natsClient yagnats.NATSConn
+	natsClusters := c.ConfiguredNatsClusters()
+	var natsClient *mbus.Clusters
 	attempts := 3
 	for attempts > 0 {
-		natsClient, err = yagnats.Connect(natsServers)
+		natsClient, err = mbus.Connect(natsClusters, mbus.Dial)
 		if err == nil {
 			break
 		} else {
@@ -85,43 +132,411 @@ func main() {
 
 	registry := rregistry.NewRouteRegistry(c, natsClient)
 
+	// NATS and the admin API check with the registrars of the registry, and
+	// the other sources register through them.
+	registrars := rregistry.NewRegistrars(c.RegistrationLimits, clock.New())
+	registry.SetRegistrars(registrars)
+
+	var snapshotter *rregistry.Snapshotter
+	if c.RouteSnapshot.Enabled {
+		snapshotter = rregistry.NewSnapshotter(registry, c.RouteSnapshot)
+		restored, err := snapshotter.Restore()
+		if err != nil {
+			logger.Errorf("Error restoring the route snapshot: %s", err.Error())
+		}
+		if restored > 0 {
+			logger.Infod(map[string]interface{}{"endpoints": restored}, "gorouter.routes-restored")
+			// the restored routes are served at once instead of after the
+			// delay that lets the first registrations arrive
+			c.StartResponseDelayInterval = 0
+		}
+		snapshotter.Start()
+	}
+
+	var routeFetcher *route_fetcher.RouteFetcher
 	if c.RoutingApiEnabled() {
 		logger.Info("Setting up routing_api route fetcher")
 		tokenFetcher := token_fetcher.NewTokenFetcher(&c.OAuth)
 		routingApiUri := fmt.Sprintf("%s:%d", c.RoutingApi.Uri, c.RoutingApi.Port)
 		routingApiClient := routing_api.NewClient(routingApiUri)
-		routeFetcher := route_fetcher.NewRouteFetcher(steno.NewLogger("router.route_fetcher"), tokenFetcher, registry, c, routingApiClient, 1)
+
+		// Routes for cutover domains are kept apart from the NATS registered
+		// ones so traffic can be ramped between the two sources.
+		var fetcherRegistry rregistry.RegistryInterface = registry
+		if len(c.CutoverDomains) > 0 {
+			cutoverRegistry := rregistry.NewRouteRegistry(c, natsClient)
+			cutoverRegistry.StartPruningCycle()
+			if _, err := registry.EnableCutover(cutoverRegistry, c.CutoverDomains); err != nil {
+				logger.Fatalf("Error enabling cutover: %s\n", err)
+			}
+			fetcherRegistry = cutoverRegistry
+		}
+		fetcherRegistry = registrars.Wrap("routing_api", fetcherRegistry)
+
+		routeFetcher = route_fetcher.NewRouteFetcher(steno.NewLogger("router.route_fetcher"), tokenFetcher, fetcherRegistry, c, routingApiClient, 1)
 		routeFetcher.StartFetchCycle()
 		routeFetcher.StartEventCycle()
 	}
 
+	var checker *healthcheck.Checker
+	if c.HealthCheck.Enabled {
+		checker = healthcheck.NewChecker(registry, c.HealthCheck)
+		go checker.Run()
+	}
+
+	var consulSource *consul.Source
+	if c.Consul.Enabled {
+		logger.Info("Setting up consul route source")
+		consulSource = consul.NewSource(registrars.Wrap("consul", registry), c.Consul)
+		go consulSource.Run()
+	}
+
+	var kubernetesSource *kubernetes.Source
+	if c.Kubernetes.Enabled {
+		logger.Info("Setting up kubernetes route source")
+		kubernetesSource, err = kubernetes.NewSource(registrars.Wrap("kubernetes", registry), c.Kubernetes)
+		if err != nil {
+			logger.Errorf("Error setting up the kubernetes route source: %s", err.Error())
+			os.Exit(1)
+		}
+		go kubernetesSource.Run()
+	}
+
 	varz := rvarz.NewVarz(registry)
 
+	// UDP sends to metron fail only when the address cannot be resolved or
+	// nothing listens locally, which is as far as its check can tell.
+	health := telemetry.NewHealth(clock.New())
+	metron := health.AddSink("metron", 0, func() error {
+		return dropsonde_metrics.SendValue("metrics_health.self_test", 1, "Check")
+	})
+
+	var reporter proxy.ProxyReporter = varz
+	var prometheus *metrics.PrometheusReporter
+	if c.Prometheus.Port != 0 {
+		prometheus = metrics.NewPrometheusReporter(registry)
+		reporter = metrics.CompositeReporter{varz, prometheus}
+		registry.SetReporter(prometheus)
+		prometheus.SetHealth(health.AddSink("prometheus", 5*time.Minute, nil))
+
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", prometheus)
+		mux.HandleFunc("/metrics/digests", prometheus.ServeDigests)
+
+		addr := fmt.Sprintf(":%d", c.Prometheus.Port)
+		l, err := handoff.Listen(addr)
+		if err != nil {
+			logger.Errorf("Error serving Prometheus metrics: %s", err)
+		} else {
+			go func() {
+				logger.Infof("Serving Prometheus metrics on %s", addr)
+				err := http.Serve(l, mux)
+				if err != nil {
+					logger.Errorf("Error serving Prometheus metrics: %s", err)
+				}
+			}()
+		}
+	}
+
 	accessLogger, err := access_log.CreateRunningAccessLogger(c)
 	if err != nil {
 		logger.Fatalf("Error creating access logger: %s\n", err)
 	}
 
+	if l, ok := accessLogger.(*access_log.FileAndLoggregatorAccessLogger); ok {
+		if prometheus != nil {
+			prometheus.AddAccessLogSink("buffer", l)
+		}
+
+		if w := l.SyslogWriter(); w != nil {
+			var check func() error
+			if c.AccessLogSyslog.Network != config.SyslogNetworkUDP {
+				check = telemetry.DialCheck("tcp", c.AccessLogSyslog.Address)
+			}
+			w.SetHealth(health.AddSink("syslog", 0, check))
+		}
+
+		if l.KafkaSink() != nil {
+			l.KafkaSink().SetHealth(health.AddSink("kafka", 0, telemetry.DialCheck("tcp", c.AccessLogKafka.Brokers...)))
+			if prometheus != nil {
+				prometheus.AddAccessLogSink("kafka", l.KafkaSink())
+			}
+		}
+	}
+
+	if c.Logging.EmitHttpStartStop {
+		udpEmitter, err := emitter.NewUdpEmitter(c.Logging.MetronAddress)
+		if err != nil {
+			logger.Fatalf("Error creating HttpStartStop emitter: %s\n", err)
+		}
+		httpStartStop := metrics.NewHttpStartStopEmitter(accessLogger, udpEmitter, c.Logging.JobName)
+		httpStartStop.SetHealth(metron)
+		accessLogger = httpStartStop
+	}
+
+	var loggregatorClient *loggregator.Client
+	loggregatorStop := make(chan struct{})
+	if c.Logging.LoggregatorV2.Enabled {
+		tlsConfig, err := loggregator.NewTLSConfig(c.Logging.LoggregatorV2)
+		if err != nil {
+			logger.Fatalf("Error loading loggregator v2 TLS configuration: %s\n", err)
+		}
+
+		loggregatorClient = loggregator.NewClient(c.Logging.LoggregatorV2, tlsConfig)
+		loggregatorClient.SetHealth(health.AddSink("loggregator_v2", 3*c.Logging.LoggregatorV2.MetricsInterval, telemetry.DialCheck("tcp", c.Logging.LoggregatorV2.Address)))
+		go loggregatorClient.Run()
+
+		accessLogger = loggregator.NewAccessLogEmitter(accessLogger, loggregatorClient, strconv.FormatUint(uint64(c.Index), 10))
+
+		loggregatorReporter := loggregator.NewMetricsReporter(loggregatorClient, registry, "gorouter", c.Logging.JobName)
+		reporter = metrics.CompositeReporter{reporter, loggregatorReporter}
+		go loggregatorReporter.Run(c.Logging.LoggregatorV2.MetricsInterval, loggregatorStop)
+
+		if prometheus != nil {
+			prometheus.AddAccessLogSink("loggregator_v2", loggregatorClient)
+		}
+	}
+
+	var accountant *usage.Accountant
+	if c.Usage.Enabled {
+		accountant = usage.NewAccountant(accessLogger, c.Usage.RetentionHours)
+		accessLogger = accountant
+	}
+
+	var tracer *tracing.Tracer
+	if c.Tracing.OtlpEndpoint != "" {
+		exporter := tracing.NewOTLPExporter(c.Tracing.OtlpEndpoint, c.Tracing.ServiceName, map[string]interface{}{
+			"service.instance.id": c.Logging.JobName,
+		})
+		tracer = tracing.NewTracer(exporter, c.Tracing.SampleRatio, 5*time.Second)
+		go tracer.Run()
+	}
+
+	var responseCache *cache.Cache
+	if c.Cache.Enabled {
+		responseCache = cache.New(c.Cache.MaxBytes, c.Cache.MaxEntryBytes, clock.New())
+	}
+
+	var recorder *capture.Recorder
+	if c.Capture.Enabled {
+		recorder = capture.NewRecorder(c.Capture)
+	}
+
+	decisions, err := decisionlog.New(c.DecisionLog)
+	if err != nil {
+		logger.Fatalf("Error opening decision log: %s\n", err)
+	}
+
+	maintenanceRoutes := maintenance.NewRoutes(clock.New())
+
+	events := analytics.New(c.Analytics, clock.New())
+	if events != nil {
+		events.SetHealth(health.AddSink("analytics", 0, nil))
+		go events.Run()
+	}
+
 	args := proxy.ProxyArgs{
 		EndpointTimeout: c.EndpointTimeout,
 		Ip:              c.Ip,
 		TraceKey:        c.TraceKey,
 		Registry:        registry,
-		Reporter:        varz,
+		Reporter:        reporter,
 		AccessLogger:    accessLogger,
 		SecureCookies:   c.SecureCookies,
+		Tracer:          tracer,
+		EnableZipkin:    c.Tracing.EnableZipkin,
+
+		BackendKeepAlive:       c.BackendConnections.KeepAlive,
+		MaxIdleConnsPerBackend: c.BackendConnections.MaxIdlePerBackend,
+		MaxConnsPerBackend:     c.BackendConnections.MaxPerBackend,
+		BackendIdleTimeout:     c.BackendConnections.IdleTimeout,
+
+		TrustForwardedHeaders: c.ForwardedHeaders.Policy == config.ForwardedHeadersAppend,
+		TrustedProxies:        c.ForwardedHeaders.TrustedNetworks,
+		Identity:              identity.New(c.ClientIdentity),
+
+		AffinityHeader: c.SessionAffinity.Header,
+		AffinityTTL:    c.SessionAffinity.TTL,
+
+		RequestHeaderLimit:       limits.New("request_header_bytes", c.Limits.RequestHeaderBytes),
+		RequestBodyLimit:         limits.New("request_body_bytes", c.Limits.RequestBodyBytes),
+		ResponseHeaderBytesLimit: limits.New("response_header_bytes", c.Limits.ResponseHeaderBytes),
+		ResponseHeaderCountLimit: limits.New("response_header_count", c.Limits.ResponseHeaderCount),
+		StripResponseHeaders:     c.StripResponseHeaders,
+
+		Cache:      responseCache,
+		Inspection: inspection.New(c.Inspection),
+		Capture:    recorder,
+		RateLimit:  ratelimit.New(c.RateLimit, clock.New()),
+
+		ClientRateLimit: ratelimit.NewClientLimiter(c.ClientLimits, clock.New()),
+		ClientAccess:    ipfilter.New(c.ClientAccess.AllowedNetworks, c.ClientAccess.DeniedNetworks),
+		SignedUrls:      signedurl.NewVerifier(c.SignedUrls, clock.New()),
+		Jwt:             jwtauth.NewValidator(c.Jwt, clock.New()),
+		Peer:            peer.NewForwarder(c.PeerFailover, c.EndpointTimeout),
+		Compression:     compression.New(c.Compression),
+		HeaderRules:     headerrules.New(c.HeaderRules),
+		Duplicates:      headerrules.NewDuplicates(c.DuplicateHeaders),
+		Maintenance:     maintenanceRoutes,
+		Mirror:          mirror.New(c.Mirroring, registry),
+		RequestQueue:    requestqueue.New(c.RequestQueue, clock.New()),
+		Banners:         banner.New(c.Banners, clock.New()),
+		Analytics:       events,
+		Decisions:       decisions,
+
+		MonitoringExclusions: requestfilter.New(c.MonitoringExclusions),
+		StickySessions:       stickysession.NewCodec(c.StickySessions),
+		StickyForwarder:      peer.NewStickyForwarder(c.StickySessions, c.EndpointTimeout),
+	}
+
+	args.ErrorPages, err = errorpages.New(c.ErrorPages, c.JsonErrors)
+	if err != nil {
+		logger.Fatalf("Error loading error pages: %s\n", err)
+	}
+
+	if len(c.OAuth2Proxies) > 0 {
+		args.OAuth2 = oauth2proxy.NewAuthenticator(c.OAuth2Proxies, c.ForwardedHeaders.TrustedNetworks, clock.New())
 	}
 	p := proxy.NewProxy(args)
 
+	if c.AdminApi.Port != 0 {
+		adminApi := router.NewAdminApi(c.AdminApi, registry)
+		l, err := handoff.Listen(fmt.Sprintf(":%d", c.AdminApi.Port))
+		if err != nil {
+			logger.Errorf("Error serving the admin API: %s", err)
+		} else {
+			go func() {
+				logger.Infof("Serving the admin API on :%d", c.AdminApi.Port)
+				err := adminApi.Serve(l)
+				if err != nil {
+					logger.Errorf("Error serving the admin API: %s", err)
+				}
+			}()
+		}
+	}
+
+	var etcdSource *router.EtcdSource
+	if len(c.Etcd.Endpoints) > 0 {
+		logger.Info("Setting up etcd route source")
+		etcdSource = router.NewEtcdSource(c.Etcd, registrars.Wrap("etcd", registry))
+		go etcdSource.Run()
+	}
+
 	router, err := router.NewRouter(c, p, natsClient, registry, varz, logCounter)
 	if err != nil {
 		logger.Errorf("An error occurred: %s", err.Error())
 		os.Exit(1)
 	}
 
+	router.HandleStatus("/metrics-health", health)
+	router.HandleStatus("/maintenance", maintenanceRoutes)
+	router.HandleStatus("/versions", http.HandlerFunc(registry.ServeVersions))
+	router.HandleStatus("/registrars", registrars)
+
+	logLevel := vcap.NewLogLevel(c.Logging.Level)
+	router.HandleStatus("/log-level", logLevel)
+
+	certMonitor := certexpiry.NewMonitor(c.CertificateExpiry, clock.New(), router)
+	certMonitor.Add(certexpiry.KindFrontend, router.CertificateExpiries)
+	err = certMonitor.AddConfigured(c)
+	if err != nil {
+		logger.Errorf("Error reading certificates to watch for expiry: %s", err.Error())
+	}
+	go certMonitor.Run()
+
+	healthDetail := healthdetail.New(c.HealthDetail, clock.New())
+	healthDetail.Add("nats", healthdetail.NATS(natsClient.Ping))
+	if len(natsClusters) > 1 {
+		for _, cluster := range natsClusters {
+			name := cluster.Name
+			healthDetail.Add("nats/"+name, healthdetail.NATS(func() bool { return natsClient.PingCluster(name) }))
+		}
+	}
+	if routeFetcher != nil {
+		healthDetail.Add("routing_api", healthdetail.RoutingApi(routeFetcher.LastFetch, c.HealthDetail.RoutingApiStaleAfter, clock.New()))
+	}
+	healthDetail.Add("registry", healthdetail.Registry(registry.TimeOfLastUpdate, registry.NumEndpoints, c.HealthDetail.RegistryStaleAfter, clock.New()))
+	healthDetail.Add("certificates", healthdetail.Certificates(certMonitor.NotAfter, c.HealthDetail.CertificateWarningDays, clock.New()))
+	healthDetail.Add("telemetry", healthdetail.Telemetry(health))
+	healthDetail.Add("listeners", healthdetail.Listeners(router.ListenerStates, router.Draining))
+	router.HandleStatus("/health/detail", healthDetail)
+
+	if accountant != nil {
+		router.HandleStatus("/usage", accountant)
+	}
+
+	if recorder != nil {
+		router.HandleStatus("/capture", recorder)
+	}
+
+	if prometheus != nil {
+		prometheus.SetDrainer(router)
+		prometheus.SetCertificates(certMonitor)
+		prometheus.SetRegistrars(registrars)
+		if routeFetcher != nil {
+			prometheus.SetEventStream(routeFetcher)
+		}
+	}
+
 	signals := make(chan os.Signal, 1)
 	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT, syscall.SIGUSR1)
 
+	// a drain asked for on the status port goes the way of SIGUSR1
+	go func() {
+		<-router.DrainRequests()
+		signals <- syscall.SIGUSR1
+	}()
+
+	// on SIGUSR2 a new router is started on the listeners of this one, and
+	// once it serves this one hands off to it
+	upgrades := make(chan os.Signal, 1)
+	signal.Notify(upgrades, syscall.SIGUSR2)
+
+	go func() {
+		for range upgrades {
+			logger.Info("gorouter.upgrading")
+
+			err := handoff.Upgrade(c.UpgradeTimeout)
+			if err != nil {
+				logger.Errord(map[string]interface{}{"error": err.Error()}, "gorouter.upgrade.failed")
+				continue
+			}
+
+			signals <- syscall.SIGUSR2
+			return
+		}
+	}()
+
+	reloads := make(chan os.Signal, 1)
+	signal.Notify(reloads, syscall.SIGHUP)
+
+	go func() {
+		current := c
+		settings := proxy.ReloadArgs{
+			EndpointTimeout: args.EndpointTimeout,
+			RateLimit:       args.RateLimit,
+			ClientRateLimit: args.ClientRateLimit,
+			ClientAccess:    args.ClientAccess,
+			HeaderRules:     args.HeaderRules,
+			Duplicates:      args.Duplicates,
+		}
+
+		for range reloads {
+			if configFile == "" {
+				logger.Info("gorouter.reload.no-config-file")
+				continue
+			}
+
+			next, nextSettings, err := reload(configFile, current, settings, p, router, logLevel)
+			if err != nil {
+				logger.Errord(map[string]interface{}{"error": err.Error()}, "gorouter.reload.failed")
+				continue
+			}
+			current, settings = next, nextSettings
+			logger.Info("gorouter.reloaded")
+		}
+	}()
+
 	errChan := router.Run()
 
 	logger.Info("gorouter.started")
@@ -147,6 +562,7 @@ func main() {
 		if sig == syscall.SIGUSR1 {
 			logger.Infod(
 				map[string]interface{}{
+					"wait":    (c.DrainWait).String(),
 					"timeout": (c.DrainTimeout).String(),
 				},
 				"gorouter.draining",
@@ -155,12 +571,63 @@ func main() {
 			router.Drain(c.DrainTimeout)
 		}
 
+		if sig == syscall.SIGUSR2 {
+			logger.Infod(
+				map[string]interface{}{
+					"timeout": (c.DrainTimeout).String(),
+				},
+				"gorouter.handing-off",
+			)
+
+			router.HandOff(c.DrainTimeout)
+		}
+
 		stoppingAt := time.Now()
 
 		logger.Info("gorouter.stopping")
 
 		router.Stop()
 
+		if snapshotter != nil {
+			err := snapshotter.Stop()
+			if err != nil {
+				logger.Errorf("Error writing the route snapshot: %s", err.Error())
+			}
+		}
+
+		if tracer != nil {
+			tracer.Stop()
+		}
+
+		if events != nil {
+			events.Stop()
+		}
+
+		decisions.Close()
+
+		if checker != nil {
+			checker.Stop()
+		}
+
+		if consulSource != nil {
+			consulSource.Stop()
+		}
+
+		if etcdSource != nil {
+			etcdSource.Stop()
+		}
+
+		if kubernetesSource != nil {
+			kubernetesSource.Stop()
+		}
+
+		certMonitor.Stop()
+
+		if loggregatorClient != nil {
+			close(loggregatorStop)
+			loggregatorClient.Stop()
+		}
+
 		logger.Infod(
 			map[string]interface{}{
 				"took": time.Since(stoppingAt).String(),
@@ -172,6 +639,78 @@ func main() {
 	os.Exit(0)
 }
 
+// reload applies the settings of the configuration file that can change
+// while the router serves: the log level, the endpoint timeout, rate limits,
+// header rules and per domain TLS certificates. The rest of the file takes
+// effect at the next restart. Nothing is applied unless all of it can be,
+// rate limiters whose settings are unchanged keep the requests they have
+// counted, and a log level set on the status port is kept unless the file
+// changes it.
+func reload(file string, current *config.Config, settings proxy.ReloadArgs, p proxy.Proxy, r *router.Router, logLevel *vcap.LogLevel) (*config.Config, proxy.ReloadArgs, error) {
+	c, err := config.LoadConfigFromFile(file)
+	if err != nil {
+		return nil, settings, err
+	}
+
+	_, err = steno.GetLogLevel(c.Logging.Level)
+	if err != nil {
+		return nil, settings, err
+	}
+
+	err = r.ReloadCertificates(c.TlsCertificates)
+	if err != nil {
+		return nil, settings, err
+	}
+
+	if c.Logging.Level != current.Logging.Level {
+		logLevel.Set(c.Logging.Level)
+	}
+
+	settings.EndpointTimeout = c.EndpointTimeout
+	if !reflect.DeepEqual(c.RateLimit, current.RateLimit) {
+		settings.RateLimit = ratelimit.New(c.RateLimit, clock.New())
+	}
+	if !reflect.DeepEqual(c.ClientLimits, current.ClientLimits) {
+		settings.ClientRateLimit = ratelimit.NewClientLimiter(c.ClientLimits, clock.New())
+	}
+	settings.ClientAccess = ipfilter.New(c.ClientAccess.AllowedNetworks, c.ClientAccess.DeniedNetworks)
+	settings.HeaderRules = headerrules.New(c.HeaderRules)
+	settings.Duplicates = headerrules.NewDuplicates(c.DuplicateHeaders)
+	p.Reload(settings)
+
+	return c, settings, nil
+}
+
+// simulate replays the capture file against c, writing the requests whose
+// outcome would change to stdout, one JSON object per line.
+func simulate(c *config.Config, file string) int {
+	f, err := os.Open(file)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error opening capture file: %s\n", err)
+		return 1
+	}
+	defer f.Close()
+
+	sample, err := dryrun.ReadSample(f)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading capture file: %s\n", err)
+		return 1
+	}
+
+	changes, err := dryrun.Replay(c, sample)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error replaying capture file: %s\n", err)
+		return 1
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	for _, change := range changes {
+		encoder.Encode(change)
+	}
+	fmt.Fprintf(os.Stderr, "%d of %d requests would change outcome\n", len(changes), len(sample))
+	return 0
+}
+
 func InitLoggerFromConfig(c *config.Config, logCounter *vcap.LogCounter) {
 	l, err := steno.GetLogLevel(c.Logging.Level)
 	if err != nil {
@@ -0,0 +1,147 @@
+package maintenance
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cloudfoundry/gorouter/clock"
+)
+
+// Routes are the routes operators put under maintenance. The router
+// answers requests for them with 503 while their endpoints stay
+// registered, so they come back as soon as maintenance ends. A nil Routes
+// has no route under maintenance.
+type Routes struct {
+	clock clock.Clock
+
+	mu     sync.RWMutex
+	routes map[string]Route
+}
+
+// A Route is under maintenance since Since. Message and the number of
+// seconds to retry after, when set, are what requests for it are told.
+type Route struct {
+	Host              string    `json:"host"`
+	Message           string    `json:"message,omitempty"`
+	RetryAfterSeconds int       `json:"retry_after,omitempty"`
+	Since             time.Time `json:"since"`
+}
+
+func NewRoutes(c clock.Clock) *Routes {
+	return &Routes{
+		clock:  c,
+		routes: make(map[string]Route),
+	}
+}
+
+// Start puts host under maintenance, replacing its message and retry
+// interval when it already is.
+func (r *Routes) Start(host, message string, retryAfterSeconds int) {
+	host = normalize(host)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	since := r.clock.Now()
+	if existing, ok := r.routes[host]; ok {
+		since = existing.Since
+	}
+	r.routes[host] = Route{
+		Host:              host,
+		Message:           message,
+		RetryAfterSeconds: retryAfterSeconds,
+		Since:             since,
+	}
+}
+
+// Stop ends the maintenance of host.
+func (r *Routes) Stop(host string) {
+	r.mu.Lock()
+	delete(r.routes, normalize(host))
+	r.mu.Unlock()
+}
+
+// Lookup returns the maintenance of the host of a request, which may
+// carry a port.
+func (r *Routes) Lookup(host string) (Route, bool) {
+	if r == nil {
+		return Route{}, false
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if len(r.routes) == 0 {
+		return Route{}, false
+	}
+
+	route, ok := r.routes[normalize(host)]
+	return route, ok
+}
+
+// List returns the routes under maintenance by host.
+func (r *Routes) List() []Route {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	routes := make([]Route, 0, len(r.routes))
+	for _, route := range r.routes {
+		routes = append(routes, route)
+	}
+	sort.Slice(routes, func(i, j int) bool { return routes[i].Host < routes[j].Host })
+	return routes
+}
+
+// ServeHTTP lists the routes under maintenance on GET, puts the route of
+// the `host` query parameter under maintenance on POST, with an optional
+// `message` and `retry_after` in seconds, and ends its maintenance on
+// DELETE.
+func (r *Routes) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	q := req.URL.Query()
+
+	switch req.Method {
+	case "GET":
+	case "POST", "DELETE":
+		host := q.Get("host")
+		if host == "" {
+			http.Error(w, "host is required", http.StatusBadRequest)
+			return
+		}
+
+		if req.Method == "DELETE" {
+			r.Stop(host)
+			break
+		}
+
+		var retryAfter int
+		if v := q.Get("retry_after"); v != "" {
+			var err error
+			retryAfter, err = strconv.Atoi(v)
+			if err != nil || retryAfter < 0 {
+				http.Error(w, "retry_after must be a number of seconds", http.StatusBadRequest)
+				return
+			}
+		}
+		r.Start(host, q.Get("message"), retryAfter)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(r.List())
+}
+
+func normalize(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.ToLower(host)
+}
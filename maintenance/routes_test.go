@@ -0,0 +1,96 @@
+package maintenance_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/cloudfoundry/gorouter/clock/fakeclock"
+	"github.com/cloudfoundry/gorouter/maintenance"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Routes", func() {
+	var fakeClock *fakeclock.FakeClock
+	var routes *maintenance.Routes
+
+	BeforeEach(func() {
+		fakeClock = fakeclock.New(time.Unix(1500000000, 0))
+		routes = maintenance.NewRoutes(fakeClock)
+	})
+
+	It("has no route under maintenance when nil", func() {
+		var nilRoutes *maintenance.Routes
+		_, ok := nilRoutes.Lookup("app.example.com")
+		Ω(ok).To(BeFalse())
+	})
+
+	It("looks up the hosts of requests regardless of case and port", func() {
+		routes.Start("App.Example.com", "Back soon.", 60)
+
+		route, ok := routes.Lookup("app.example.COM:8080")
+		Ω(ok).To(BeTrue())
+		Ω(route).To(Equal(maintenance.Route{
+			Host:              "app.example.com",
+			Message:           "Back soon.",
+			RetryAfterSeconds: 60,
+			Since:             fakeClock.Now(),
+		}))
+
+		_, ok = routes.Lookup("other.example.com")
+		Ω(ok).To(BeFalse())
+	})
+
+	It("keeps when maintenance started when it is started again", func() {
+		started := fakeClock.Now()
+		routes.Start("app.example.com", "", 0)
+		fakeClock.Increment(time.Minute)
+		routes.Start("app.example.com", "Almost done.", 0)
+
+		route, _ := routes.Lookup("app.example.com")
+		Ω(route.Since).To(Equal(started))
+		Ω(route.Message).To(Equal("Almost done."))
+	})
+
+	It("ends maintenance", func() {
+		routes.Start("app.example.com", "", 0)
+		routes.Stop("APP.example.com")
+
+		_, ok := routes.Lookup("app.example.com")
+		Ω(ok).To(BeFalse())
+	})
+
+	Describe("ServeHTTP", func() {
+		serve := func(method, target string) *httptest.ResponseRecorder {
+			w := httptest.NewRecorder()
+			routes.ServeHTTP(w, httptest.NewRequest(method, target, nil))
+			return w
+		}
+
+		It("starts, lists and ends maintenance", func() {
+			w := serve("POST", "/maintenance?host=app.example.com&message=Back+soon.&retry_after=120")
+			Ω(w.Code).To(Equal(http.StatusOK))
+
+			var listed []map[string]interface{}
+			Ω(json.Unmarshal(serve("GET", "/maintenance").Body.Bytes(), &listed)).To(Succeed())
+			Ω(listed).To(HaveLen(1))
+			Ω(listed[0]["host"]).To(Equal("app.example.com"))
+			Ω(listed[0]["message"]).To(Equal("Back soon."))
+			Ω(listed[0]["retry_after"]).To(BeEquivalentTo(120))
+
+			w = serve("DELETE", "/maintenance?host=app.example.com")
+			Ω(w.Code).To(Equal(http.StatusOK))
+			Ω(w.Body.String()).To(Equal("[]\n"))
+		})
+
+		It("refuses requests without a host or with an invalid retry_after", func() {
+			Ω(serve("POST", "/maintenance").Code).To(Equal(http.StatusBadRequest))
+			Ω(serve("DELETE", "/maintenance").Code).To(Equal(http.StatusBadRequest))
+			Ω(serve("POST", "/maintenance?host=app.example.com&retry_after=soon").Code).To(Equal(http.StatusBadRequest))
+			Ω(serve("PUT", "/maintenance").Code).To(Equal(http.StatusMethodNotAllowed))
+		})
+	})
+})
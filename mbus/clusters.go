@@ -0,0 +1,349 @@
+package mbus
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/apcera/nats"
+	steno "github.com/cloudfoundry/gosteno"
+	"github.com/cloudfoundry/yagnats"
+
+	"github.com/cloudfoundry/gorouter/config"
+)
+
+const connectRetryInterval = 5 * time.Second
+
+var errNotConnected = errors.New("mbus: not connected to any nats cluster")
+
+// Connector connects to a cluster, as Dial does.
+type Connector func(cluster config.NatsClusterConfig) (yagnats.NATSConn, error)
+
+// Clusters is a connection to several independent NATS clusters that looks
+// like one: what is subscribed to is subscribed to on all of them, so that
+// the routes registered on any of them are merged, and what is published is
+// published to all of them. It keeps working while any of the clusters can
+// be reached.
+type Clusters struct {
+	logger *steno.Logger
+
+	lock          sync.Mutex
+	names         []string
+	conns         map[string]yagnats.NATSConn
+	subscriptions map[*nats.Subscription]*subscription
+	reconnectCbs  []func(*nats.Conn)
+	closedCbs     []func(*nats.Conn)
+	disconnectCbs []func(*nats.Conn)
+	closed        map[string]bool
+	stopped       bool
+
+	stopCh chan struct{}
+}
+
+type subscription struct {
+	subject string
+	queue   string
+	handler nats.MsgHandler
+	subs    map[string]*nats.Subscription
+}
+
+func NewClusters() *Clusters {
+	return &Clusters{
+		logger: steno.NewLogger("router.mbus"),
+
+		conns:         make(map[string]yagnats.NATSConn),
+		subscriptions: make(map[*nats.Subscription]*subscription),
+		closed:        make(map[string]bool),
+
+		stopCh: make(chan struct{}),
+	}
+}
+
+// Connect connects to every cluster with connect, and fails only when none
+// of them can be reached. The clusters that cannot be are connected to in
+// the background, and subscribed to as soon as they are.
+func Connect(clusters []config.NatsClusterConfig, connect Connector) (*Clusters, error) {
+	c := NewClusters()
+
+	var firstErr error
+	var pending []config.NatsClusterConfig
+	for _, cluster := range clusters {
+		conn, err := connect(cluster)
+		if err != nil {
+			c.logger.Warnd(map[string]interface{}{"cluster": cluster.Name, "error": err.Error()}, "mbus.connect.failed")
+			if firstErr == nil {
+				firstErr = err
+			}
+			pending = append(pending, cluster)
+			continue
+		}
+		c.Add(cluster.Name, conn)
+	}
+
+	if len(pending) == len(clusters) {
+		return nil, firstErr
+	}
+
+	for _, cluster := range pending {
+		go c.retry(cluster, connect)
+	}
+
+	return c, nil
+}
+
+func (c *Clusters) retry(cluster config.NatsClusterConfig, connect Connector) {
+	for {
+		select {
+		case <-time.After(connectRetryInterval):
+		case <-c.stopCh:
+			return
+		}
+
+		conn, err := connect(cluster)
+		if err == nil {
+			c.logger.Infod(map[string]interface{}{"cluster": cluster.Name}, "mbus.connect.succeeded")
+			c.Add(cluster.Name, conn)
+			return
+		}
+	}
+}
+
+// Add has conn, the connection to the cluster called name, subscribed to
+// what the clusters are subscribed to, and published to from now on. The
+// reconnected callbacks are called for it, since it may hold routes that
+// need to be asked for.
+func (c *Clusters) Add(name string, conn yagnats.NATSConn) {
+	c.lock.Lock()
+	if c.stopped {
+		c.lock.Unlock()
+		conn.Close()
+		return
+	}
+
+	c.names = append(c.names, name)
+	c.conns[name] = conn
+
+	conn.AddReconnectedCB(c.reconnected)
+	conn.AddDisconnectedCB(c.disconnected)
+	conn.AddClosedCB(func(nc *nats.Conn) {
+		c.clusterClosed(name, nc)
+	})
+
+	for _, s := range c.subscriptions {
+		c.subscribe(name, conn, s)
+	}
+
+	late := len(c.names) > 1
+	cbs := append([]func(*nats.Conn){}, c.reconnectCbs...)
+	c.lock.Unlock()
+
+	if late {
+		for _, cb := range cbs {
+			cb(nil)
+		}
+	}
+}
+
+// Names returns the names of the clusters that are connected to.
+func (c *Clusters) Names() []string {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return append([]string(nil), c.names...)
+}
+
+// Cluster returns the connection to the cluster called name, or nil while
+// it is not connected to.
+func (c *Clusters) Cluster(name string) yagnats.NATSConn {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.conns[name]
+}
+
+func (c *Clusters) all() []yagnats.NATSConn {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	conns := make([]yagnats.NATSConn, 0, len(c.names))
+	for _, name := range c.names {
+		conns = append(conns, c.conns[name])
+	}
+	return conns
+}
+
+func (c *Clusters) Close() {
+	c.lock.Lock()
+	if !c.stopped {
+		c.stopped = true
+		close(c.stopCh)
+	}
+	c.lock.Unlock()
+
+	for _, conn := range c.all() {
+		conn.Close()
+	}
+}
+
+// Publish publishes to every cluster, and fails only when it could not
+// publish to any of them.
+func (c *Clusters) Publish(subject string, data []byte) error {
+	return c.PublishRequest(subject, "", data)
+}
+
+func (c *Clusters) PublishRequest(subject, reply string, data []byte) error {
+	err := errNotConnected
+	for _, conn := range c.all() {
+		e := conn.PublishRequest(subject, reply, data)
+		if e == nil || err == errNotConnected {
+			err = e
+		}
+	}
+	return err
+}
+
+func (c *Clusters) Subscribe(subject string, handler nats.MsgHandler) (*nats.Subscription, error) {
+	return c.QueueSubscribe(subject, "", handler)
+}
+
+// QueueSubscribe subscribes handler to subject on every cluster, and on
+// those that are connected to later once they are. It fails only when it
+// could not subscribe on any of them.
+func (c *Clusters) QueueSubscribe(subject, queue string, handler nats.MsgHandler) (*nats.Subscription, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	s := &subscription{
+		subject: subject,
+		queue:   queue,
+		handler: handler,
+		subs:    make(map[string]*nats.Subscription),
+	}
+
+	err := errNotConnected
+	for _, name := range c.names {
+		e := c.subscribe(name, c.conns[name], s)
+		if e == nil || err == errNotConnected {
+			err = e
+		}
+	}
+	if len(s.subs) == 0 {
+		return nil, err
+	}
+
+	handle := &nats.Subscription{Subject: subject, Queue: queue}
+	c.subscriptions[handle] = s
+	return handle, nil
+}
+
+// subscribe subscribes s on the cluster called name. c.lock must be locked.
+func (c *Clusters) subscribe(name string, conn yagnats.NATSConn, s *subscription) error {
+	var sub *nats.Subscription
+	var err error
+	if s.queue == "" {
+		sub, err = conn.Subscribe(s.subject, s.handler)
+	} else {
+		sub, err = conn.QueueSubscribe(s.subject, s.queue, s.handler)
+	}
+	if err != nil {
+		c.logger.Warnd(map[string]interface{}{"cluster": name, "subject": s.subject, "error": err.Error()}, "mbus.subscribe.failed")
+		return err
+	}
+	s.subs[name] = sub
+	return nil
+}
+
+func (c *Clusters) Unsubscribe(handle *nats.Subscription) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	s, ok := c.subscriptions[handle]
+	if !ok {
+		return errors.New("mbus: unknown subscription")
+	}
+	delete(c.subscriptions, handle)
+
+	var err error
+	for name, sub := range s.subs {
+		if e := c.conns[name].Unsubscribe(sub); e != nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// Ping tells whether any of the clusters answers.
+func (c *Clusters) Ping() bool {
+	for _, conn := range c.all() {
+		if conn.Ping() {
+			return true
+		}
+	}
+	return false
+}
+
+// PingCluster tells whether the cluster called name is connected to and
+// answers.
+func (c *Clusters) PingCluster(name string) bool {
+	conn := c.Cluster(name)
+	return conn != nil && conn.Ping()
+}
+
+// AddReconnectedCB has cb called whenever a cluster is reconnected to, or
+// connected to late, in which case it is passed nil.
+func (c *Clusters) AddReconnectedCB(cb func(*nats.Conn)) {
+	c.lock.Lock()
+	c.reconnectCbs = append(c.reconnectCbs, cb)
+	c.lock.Unlock()
+}
+
+func (c *Clusters) AddDisconnectedCB(cb func(*nats.Conn)) {
+	c.lock.Lock()
+	c.disconnectCbs = append(c.disconnectCbs, cb)
+	c.lock.Unlock()
+}
+
+// AddClosedCB has cb called once every cluster is closed for good, since
+// the router can do without any one of them.
+func (c *Clusters) AddClosedCB(cb func(*nats.Conn)) {
+	c.lock.Lock()
+	c.closedCbs = append(c.closedCbs, cb)
+	c.lock.Unlock()
+}
+
+func (c *Clusters) reconnected(nc *nats.Conn) {
+	c.lock.Lock()
+	cbs := append([]func(*nats.Conn){}, c.reconnectCbs...)
+	c.lock.Unlock()
+
+	for _, cb := range cbs {
+		cb(nc)
+	}
+}
+
+func (c *Clusters) disconnected(nc *nats.Conn) {
+	c.lock.Lock()
+	cbs := append([]func(*nats.Conn){}, c.disconnectCbs...)
+	c.lock.Unlock()
+
+	for _, cb := range cbs {
+		cb(nc)
+	}
+}
+
+func (c *Clusters) clusterClosed(name string, nc *nats.Conn) {
+	c.lock.Lock()
+	c.closed[name] = true
+	all := len(c.closed) == len(c.names)
+	stopped := c.stopped
+	cbs := append([]func(*nats.Conn){}, c.closedCbs...)
+	c.lock.Unlock()
+
+	if stopped {
+		return
+	}
+	c.logger.Warnd(map[string]interface{}{"cluster": name}, "mbus.cluster.closed")
+	if all {
+		for _, cb := range cbs {
+			cb(nc)
+		}
+	}
+}
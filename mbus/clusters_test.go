@@ -0,0 +1,123 @@
+package mbus_test
+
+import (
+	"errors"
+
+	"github.com/apcera/nats"
+	"github.com/cloudfoundry/yagnats"
+	"github.com/cloudfoundry/yagnats/fakeyagnats"
+
+	"github.com/cloudfoundry/gorouter/config"
+	. "github.com/cloudfoundry/gorouter/mbus"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Clusters", func() {
+	var (
+		dc1, dc2 *fakeyagnats.FakeNATSConn
+		clusters *Clusters
+	)
+
+	BeforeEach(func() {
+		dc1 = fakeyagnats.Connect()
+		dc2 = fakeyagnats.Connect()
+
+		clusters = NewClusters()
+		clusters.Add("dc1", dc1)
+		clusters.Add("dc2", dc2)
+	})
+
+	It("merges what is published on any of the clusters", func() {
+		var received []string
+		_, err := clusters.Subscribe("router.register", func(msg *nats.Msg) {
+			received = append(received, string(msg.Data))
+		})
+		Ω(err).NotTo(HaveOccurred())
+
+		dc1.Publish("router.register", []byte("a"))
+		dc2.Publish("router.register", []byte("b"))
+		Ω(received).To(Equal([]string{"a", "b"}))
+	})
+
+	It("publishes to all of the clusters", func() {
+		Ω(clusters.Publish("router.start", []byte("{}"))).To(Succeed())
+
+		Ω(dc1.PublishedMessages("router.start")).To(HaveLen(1))
+		Ω(dc2.PublishedMessages("router.start")).To(HaveLen(1))
+	})
+
+	It("publishes as long as any cluster takes it", func() {
+		dc1.WhenPublishing("router.start", func(*nats.Msg) error { return errors.New("disconnected") })
+
+		Ω(clusters.Publish("router.start", []byte("{}"))).To(Succeed())
+		Ω(dc2.PublishedMessages("router.start")).To(HaveLen(1))
+	})
+
+	It("unsubscribes from all of the clusters", func() {
+		sub, err := clusters.Subscribe("router.register", func(*nats.Msg) {})
+		Ω(err).NotTo(HaveOccurred())
+
+		Ω(clusters.Unsubscribe(sub)).To(Succeed())
+		Ω(clusters.Unsubscribe(sub)).NotTo(Succeed())
+	})
+
+	It("subscribes a cluster added later to what was subscribed to", func() {
+		clusters.Subscribe("router.register", func(*nats.Msg) {})
+
+		dc3 := fakeyagnats.Connect()
+		clusters.Add("dc3", dc3)
+		Ω(dc3.SubscriptionCount()).To(Equal(1))
+		Ω(clusters.Names()).To(Equal([]string{"dc1", "dc2", "dc3"}))
+	})
+
+	It("answers pings while any cluster does", func() {
+		dc1.OnPing(func() bool { return false })
+		Ω(clusters.Ping()).To(BeTrue())
+
+		dc2.OnPing(func() bool { return false })
+		Ω(clusters.Ping()).To(BeFalse())
+
+		Ω(clusters.PingCluster("dc1")).To(BeFalse())
+		Ω(clusters.PingCluster("dc3")).To(BeFalse())
+	})
+
+	Describe("Connect", func() {
+		var (
+			conns map[string]*fakeyagnats.FakeNATSConn
+			c     []config.NatsClusterConfig
+		)
+
+		connect := func(cluster config.NatsClusterConfig) (yagnats.NATSConn, error) {
+			if conn, ok := conns[cluster.ServerURLs()[0]]; ok {
+				return conn, nil
+			}
+			return nil, errors.New("connection refused")
+		}
+
+		BeforeEach(func() {
+			conns = map[string]*fakeyagnats.FakeNATSConn{}
+			c = []config.NatsClusterConfig{
+				{Name: "dc1", Servers: []config.NatsConfig{{Host: "nats-dc1", Port: 4222}}},
+				{Name: "dc2", Servers: []config.NatsConfig{{Host: "nats-dc2", Port: 4222}}},
+			}
+		})
+
+		It("connects to the clusters that can be reached", func() {
+			conns["nats://:@nats-dc2:4222"] = fakeyagnats.Connect()
+
+			clusters, err := Connect(c, connect)
+			Ω(err).NotTo(HaveOccurred())
+			defer clusters.Close()
+
+			Ω(clusters.Names()).To(Equal([]string{"dc2"}))
+			Ω(clusters.Cluster("dc1")).To(BeNil())
+		})
+
+		It("fails when none of them can be", func() {
+			_, err := Connect(c, connect)
+			Ω(err).To(MatchError("connection refused"))
+		})
+	})
+})
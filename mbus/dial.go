@@ -0,0 +1,155 @@
+package mbus
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"sync"
+	"time"
+
+	"github.com/apcera/nats"
+	"github.com/cloudfoundry/yagnats"
+
+	"github.com/cloudfoundry/gorouter/config"
+)
+
+// Dial connects to the servers of cluster, over TLS and with the NATS 2.x
+// credentials or the NKey of the cluster when it has them. It reconnects
+// for as long as it takes whenever the connection is lost.
+func Dial(cluster config.NatsClusterConfig) (yagnats.NATSConn, error) {
+	options := nats.DefaultOptions
+	options.Servers = cluster.ServerURLs()
+	options.ReconnectWait = 500 * time.Millisecond
+	options.MaxReconnect = -1
+
+	if cluster.TLS.Enabled {
+		tlsConfig, err := clientTLSConfig(cluster.TLS)
+		if err != nil {
+			return nil, err
+		}
+		options.Secure = true
+		options.TLSConfig = tlsConfig
+	}
+
+	switch {
+	case cluster.CredentialsPath != "":
+		// the file is read at every connect, so that credentials that are
+		// rotated are picked up on the next reconnect
+		path := cluster.CredentialsPath
+		_, _, err := readCredentials(path)
+		if err != nil {
+			return nil, err
+		}
+		options.UserJWT = func() (string, error) {
+			jwt, _, err := readCredentials(path)
+			return jwt, err
+		}
+		options.SignatureCB = func(nonce []byte) ([]byte, error) {
+			_, key, err := readCredentials(path)
+			if err != nil {
+				return nil, err
+			}
+			return key.sign(nonce)
+		}
+	case cluster.NkeySeedPath != "":
+		key, err := readNkeySeed(cluster.NkeySeedPath)
+		if err != nil {
+			return nil, err
+		}
+		options.Nkey = key.public
+		options.SignatureCB = key.sign
+	}
+
+	c := &natsConn{}
+	options.ReconnectedCB = c.reconnected
+	options.DisconnectedCB = c.disconnected
+	options.ClosedCB = c.closed
+
+	conn, err := options.Connect()
+	if err != nil {
+		return nil, err
+	}
+	c.Conn = conn
+	return c, nil
+}
+
+func clientTLSConfig(c config.NatsTLSConfig) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		ServerName:         c.ServerName,
+		InsecureSkipVerify: c.InsecureSkipVerify,
+	}
+
+	if c.CAPath != "" {
+		b, err := ioutil.ReadFile(c.CAPath)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(b) {
+			return nil, errors.New("no certificates found in " + c.CAPath)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if c.CertPath != "" {
+		cert, err := tls.LoadX509KeyPair(c.CertPath, c.KeyPath)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
+
+// natsConn is a connection to the servers of a cluster, with callbacks that
+// can be added after it is made.
+type natsConn struct {
+	*nats.Conn
+
+	lock          sync.Mutex
+	reconnectCbs  []func(*nats.Conn)
+	disconnectCbs []func(*nats.Conn)
+	closedCbs     []func(*nats.Conn)
+}
+
+func (c *natsConn) Unsubscribe(sub *nats.Subscription) error {
+	return sub.Unsubscribe()
+}
+
+func (c *natsConn) Ping() bool {
+	return c.FlushTimeout(500*time.Millisecond) == nil
+}
+
+func (c *natsConn) AddReconnectedCB(cb func(*nats.Conn)) {
+	c.lock.Lock()
+	c.reconnectCbs = append(c.reconnectCbs, cb)
+	c.lock.Unlock()
+}
+
+func (c *natsConn) AddDisconnectedCB(cb func(*nats.Conn)) {
+	c.lock.Lock()
+	c.disconnectCbs = append(c.disconnectCbs, cb)
+	c.lock.Unlock()
+}
+
+func (c *natsConn) AddClosedCB(cb func(*nats.Conn)) {
+	c.lock.Lock()
+	c.closedCbs = append(c.closedCbs, cb)
+	c.lock.Unlock()
+}
+
+func (c *natsConn) reconnected(conn *nats.Conn)  { c.call(&c.reconnectCbs, conn) }
+func (c *natsConn) disconnected(conn *nats.Conn) { c.call(&c.disconnectCbs, conn) }
+func (c *natsConn) closed(conn *nats.Conn)       { c.call(&c.closedCbs, conn) }
+
+func (c *natsConn) call(cbs *[]func(*nats.Conn), conn *nats.Conn) {
+	c.lock.Lock()
+	called := append([]func(*nats.Conn){}, *cbs...)
+	c.lock.Unlock()
+
+	for _, cb := range called {
+		cb(conn)
+	}
+}
@@ -0,0 +1,323 @@
+package mbus_test
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/cloudfoundry/gorouter/config"
+	. "github.com/cloudfoundry/gorouter/mbus"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+const nonce = "c2VydmVyLW5vbmNl"
+
+var _ = Describe("Dial", func() {
+	var (
+		dir      string
+		server   *fakeNatsServer
+		cluster  config.NatsClusterConfig
+		ca       *x509.Certificate
+		caKey    *ecdsa.PrivateKey
+		seed     []byte
+		userSeed string
+	)
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "mbus")
+		Ω(err).NotTo(HaveOccurred())
+
+		ca, caKey = newCertificate(nil, nil, "nats-ca", true)
+		writePEM(filepath.Join(dir, "ca.pem"), ca, caKey)
+
+		seed = make([]byte, ed25519.SeedSize)
+		rand.Read(seed)
+		userSeed = encodeUserSeed(seed)
+
+		server = &fakeNatsServer{connects: make(chan map[string]interface{}, 1)}
+	})
+
+	JustBeforeEach(func() {
+		server.Start()
+		host, port, _ := net.SplitHostPort(server.listener.Addr().String())
+		p := 0
+		fmt.Sscan(port, &p)
+		cluster.Name = "dc1"
+		cluster.Servers = []config.NatsConfig{{Host: host, Port: uint16(p)}}
+	})
+
+	AfterEach(func() {
+		server.Stop()
+		os.RemoveAll(dir)
+		cluster = config.NatsClusterConfig{}
+	})
+
+	connect := func() map[string]interface{} {
+		conn, err := Dial(cluster)
+		Ω(err).NotTo(HaveOccurred())
+		defer conn.Close()
+
+		Ω(conn.Ping()).To(BeTrue())
+		var info map[string]interface{}
+		Eventually(server.connects).Should(Receive(&info))
+		return info
+	}
+
+	Context("over TLS", func() {
+		BeforeEach(func() {
+			cert, key := newCertificate(ca, caKey, "nats.example.com", false)
+			server.tls = &tls.Config{
+				Certificates: []tls.Certificate{{Certificate: [][]byte{cert.Raw}, PrivateKey: key}},
+			}
+
+			cluster.TLS = config.NatsTLSConfig{
+				Enabled:    true,
+				CAPath:     filepath.Join(dir, "ca.pem"),
+				ServerName: "nats.example.com",
+			}
+		})
+
+		It("verifies the server with the CA", func() {
+			info := connect()
+			Ω(info["ssl_required"]).To(BeTrue())
+		})
+
+		It("fails when the server is not signed by the CA", func() {
+			other, otherKey := newCertificate(nil, nil, "other-ca", true)
+			writePEM(filepath.Join(dir, "ca.pem"), other, otherKey)
+
+			_, err := Dial(cluster)
+			Ω(err).To(HaveOccurred())
+		})
+
+		Context("when the server asks for a client certificate", func() {
+			BeforeEach(func() {
+				pool := x509.NewCertPool()
+				pool.AddCert(ca)
+				server.tls.ClientCAs = pool
+				server.tls.ClientAuth = tls.RequireAndVerifyClientCert
+
+				cert, key := newCertificate(ca, caKey, "gorouter", false)
+				writePEM(filepath.Join(dir, "client.pem"), cert, key)
+				cluster.TLS.CertPath = filepath.Join(dir, "client.pem")
+				cluster.TLS.KeyPath = filepath.Join(dir, "client-key.pem")
+			})
+
+			It("presents the one it is configured with", func() {
+				connect()
+			})
+		})
+	})
+
+	Context("with an NKey seed", func() {
+		BeforeEach(func() {
+			path := filepath.Join(dir, "user.nk")
+			Ω(ioutil.WriteFile(path, []byte(userSeed+"\n"), 0600)).To(Succeed())
+			cluster.NkeySeedPath = path
+		})
+
+		It("signs the nonce of the server with the key", func() {
+			info := connect()
+
+			public := ed25519.NewKeyFromSeed(seed).Public().(ed25519.PublicKey)
+			Ω(info["nkey"]).To(Equal(encodeUserKey(public)))
+			sig, err := base64.RawURLEncoding.DecodeString(info["sig"].(string))
+			Ω(err).NotTo(HaveOccurred())
+			Ω(ed25519.Verify(public, []byte(nonce), sig)).To(BeTrue())
+		})
+
+		It("fails when the seed is not valid", func() {
+			ioutil.WriteFile(cluster.NkeySeedPath, []byte("SUAINVALID\n"), 0600)
+
+			_, err := Dial(cluster)
+			Ω(err).To(MatchError("mbus: invalid nkey seed"))
+		})
+	})
+
+	Context("with a credentials file", func() {
+		BeforeEach(func() {
+			creds := fmt.Sprintf(`-----BEGIN NATS USER JWT-----
+eyJ0eXAiOiJqd3QiLCJhbGciOiJlZDI1NTE5In0.eyJzdWIiOiJVQUJDIn0.c2ln
+------END NATS USER JWT------
+
+************************* IMPORTANT *************************
+NKEY Seed printed below can be used to sign and prove identity.
+
+-----BEGIN USER NKEY SEED-----
+%s
+------END USER NKEY SEED------
+`, userSeed)
+			path := filepath.Join(dir, "user.creds")
+			Ω(ioutil.WriteFile(path, []byte(creds), 0600)).To(Succeed())
+			cluster.CredentialsPath = path
+		})
+
+		It("sends the JWT and signs the nonce with the seed", func() {
+			info := connect()
+
+			Ω(info["jwt"]).To(Equal("eyJ0eXAiOiJqd3QiLCJhbGciOiJlZDI1NTE5In0.eyJzdWIiOiJVQUJDIn0.c2ln"))
+			Ω(info).NotTo(HaveKey("nkey"))
+			sig, err := base64.RawURLEncoding.DecodeString(info["sig"].(string))
+			Ω(err).NotTo(HaveOccurred())
+			public := ed25519.NewKeyFromSeed(seed).Public().(ed25519.PublicKey)
+			Ω(ed25519.Verify(public, []byte(nonce), sig)).To(BeTrue())
+		})
+
+		It("fails when the file has no seed", func() {
+			ioutil.WriteFile(cluster.CredentialsPath, []byte("not credentials"), 0600)
+
+			_, err := Dial(cluster)
+			Ω(err).To(MatchError("mbus: invalid nats credentials file"))
+		})
+	})
+})
+
+// fakeNatsServer speaks just enough of the NATS protocol for a client to
+// connect: it sends its INFO with a nonce, reports the CONNECT it gets and
+// answers pings.
+type fakeNatsServer struct {
+	tls      *tls.Config
+	listener net.Listener
+	connects chan map[string]interface{}
+}
+
+func (s *fakeNatsServer) Start() {
+	var err error
+	s.listener, err = net.Listen("tcp", "127.0.0.1:0")
+	Ω(err).NotTo(HaveOccurred())
+
+	go func() {
+		for {
+			conn, err := s.listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+}
+
+func (s *fakeNatsServer) Stop() {
+	s.listener.Close()
+}
+
+func (s *fakeNatsServer) serve(conn net.Conn) {
+	defer conn.Close()
+
+	fmt.Fprintf(conn, "INFO {\"server_id\":\"fake\",\"ssl_required\":%t,\"nonce\":%q}\r\n", s.tls != nil, nonce)
+	if s.tls != nil {
+		conn = tls.Server(conn, s.tls)
+	}
+
+	r := bufio.NewReader(conn)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(line, "CONNECT "):
+			var info map[string]interface{}
+			json.Unmarshal([]byte(strings.TrimPrefix(line, "CONNECT ")), &info)
+			select {
+			case s.connects <- info:
+			default:
+			}
+		case line == "PING":
+			conn.Write([]byte("PONG\r\n"))
+		}
+	}
+}
+
+func newCertificate(parent *x509.Certificate, parentKey *ecdsa.PrivateKey, name string, isCA bool) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	Ω(err).NotTo(HaveOccurred())
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		DNSNames:              []string{name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  isCA,
+	}
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	Ω(err).NotTo(HaveOccurred())
+	cert, err := x509.ParseCertificate(der)
+	Ω(err).NotTo(HaveOccurred())
+	return cert, key
+}
+
+// writePEM writes cert to path, and its key next to it with -key added to
+// its name.
+func writePEM(path string, cert *x509.Certificate, key *ecdsa.PrivateKey) {
+	b := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+	Ω(ioutil.WriteFile(path, b, 0600)).To(Succeed())
+
+	der, err := x509.MarshalECPrivateKey(key)
+	Ω(err).NotTo(HaveOccurred())
+	b = pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+	keyPath := strings.TrimSuffix(path, ".pem") + "-key.pem"
+	Ω(ioutil.WriteFile(keyPath, b, 0600)).To(Succeed())
+}
+
+// The NKey encodings, as the nk tool of NATS makes them.
+const (
+	prefixByteSeed = 18 << 3
+	prefixByteUser = 20 << 3
+)
+
+func encodeUserSeed(seed []byte) string {
+	raw := []byte{prefixByteSeed | prefixByteUser>>5, (prefixByteUser & 31) << 3}
+	return encodeNkey(append(raw, seed...))
+}
+
+func encodeUserKey(public ed25519.PublicKey) string {
+	return encodeNkey(append([]byte{prefixByteUser}, public...))
+}
+
+func encodeNkey(raw []byte) string {
+	var crc uint16
+	for _, b := range raw {
+		crc ^= uint16(b) << 8
+		for i := 0; i < 8; i++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	sum := make([]byte, 2)
+	binary.LittleEndian.PutUint16(sum, crc)
+	return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(append(raw, sum...))
+}
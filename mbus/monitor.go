@@ -0,0 +1,96 @@
+package mbus
+
+import (
+	"sync"
+	"time"
+
+	"github.com/cloudfoundry/gorouter/clock"
+)
+
+// A Monitor pings NATS every interval, so that how long it has not
+// answered is known without waiting for a ping.
+type Monitor struct {
+	ping     func() bool
+	interval time.Duration
+	clock    clock.Clock
+
+	lock        sync.Mutex
+	lastAnswer  time.Time
+	unreachable time.Time
+
+	stopCh   chan struct{}
+	stopOnce sync.Once
+}
+
+func NewMonitor(ping func() bool, interval time.Duration, c clock.Clock) *Monitor {
+	return &Monitor{
+		ping:     ping,
+		interval: interval,
+		clock:    c,
+		stopCh:   make(chan struct{}),
+	}
+}
+
+// Start pings NATS right away, and every interval from then on.
+func (m *Monitor) Start() {
+	ticker := m.clock.NewTicker(m.interval)
+
+	go func() {
+		defer ticker.Stop()
+
+		m.check()
+		for {
+			select {
+			case <-ticker.C():
+				m.check()
+			case <-m.stopCh:
+				return
+			}
+		}
+	}()
+}
+
+func (m *Monitor) Stop() {
+	m.stopOnce.Do(func() {
+		close(m.stopCh)
+	})
+}
+
+func (m *Monitor) check() {
+	sent := m.clock.Now()
+	answered := m.ping()
+
+	m.lock.Lock()
+	if answered {
+		m.lastAnswer = sent
+		m.unreachable = time.Time{}
+	} else if m.unreachable.IsZero() {
+		m.unreachable = sent
+	}
+	m.lock.Unlock()
+}
+
+// LastAnswer is when the last ping that NATS answered was sent, and is zero
+// until it answers one.
+func (m *Monitor) LastAnswer() time.Time {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.lastAnswer
+}
+
+// UnreachableSince is when the first of the pings that NATS has not
+// answered since its last answer was sent, and is zero while it answers.
+func (m *Monitor) UnreachableSince() time.Time {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.unreachable
+}
+
+// UnreachableFor is how long NATS has not answered pings.
+func (m *Monitor) UnreachableFor() time.Duration {
+	since := m.UnreachableSince()
+	if since.IsZero() {
+		return 0
+	}
+	return m.clock.Since(since)
+}
@@ -0,0 +1,70 @@
+package mbus_test
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/cloudfoundry/gorouter/clock/fakeclock"
+	. "github.com/cloudfoundry/gorouter/mbus"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Monitor", func() {
+	var (
+		clock    *fakeclock.FakeClock
+		answers  int32
+		pings    int32
+		monitor  *Monitor
+		start    time.Time
+		interval = 5 * time.Second
+	)
+
+	BeforeEach(func() {
+		start = time.Unix(1500000000, 0)
+		clock = fakeclock.New(start)
+		atomic.StoreInt32(&answers, 1)
+		atomic.StoreInt32(&pings, 0)
+
+		monitor = NewMonitor(func() bool {
+			atomic.AddInt32(&pings, 1)
+			return atomic.LoadInt32(&answers) == 1
+		}, interval, clock)
+		monitor.Start()
+		Eventually(monitor.LastAnswer).Should(Equal(start))
+	})
+
+	AfterEach(func() {
+		monitor.Stop()
+	})
+
+	tick := func() {
+		n := atomic.LoadInt32(&pings)
+		clock.Increment(interval)
+		Eventually(func() int32 { return atomic.LoadInt32(&pings) }).Should(Equal(n + 1))
+	}
+
+	It("pings right away and every interval", func() {
+		Ω(monitor.UnreachableSince().IsZero()).To(BeTrue())
+
+		tick()
+		Eventually(monitor.LastAnswer).Should(Equal(start.Add(interval)))
+	})
+
+	It("tells since when NATS has not answered, until it answers again", func() {
+		atomic.StoreInt32(&answers, 0)
+		tick()
+		Eventually(monitor.UnreachableSince).Should(Equal(start.Add(interval)))
+		tick()
+
+		Ω(monitor.LastAnswer()).To(Equal(start))
+		Ω(monitor.UnreachableSince()).To(Equal(start.Add(interval)))
+		Ω(monitor.UnreachableFor()).To(Equal(interval))
+
+		atomic.StoreInt32(&answers, 1)
+		tick()
+
+		Eventually(monitor.UnreachableFor).Should(BeZero())
+	})
+})
@@ -0,0 +1,126 @@
+package mbus
+
+import (
+	"crypto/ed25519"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+	"regexp"
+	"strings"
+)
+
+// The prefix bytes of NKeys, which make the first letters of their
+// encodings: S for seeds, U for users.
+const (
+	prefixByteSeed = 18 << 3
+	prefixByteUser = 20 << 3
+)
+
+var (
+	errInvalidSeed        = errors.New("mbus: invalid nkey seed")
+	errInvalidCredentials = errors.New("mbus: invalid nats credentials file")
+)
+
+var nkeyEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// credentialsBlock matches the blocks of a NATS 2.x credentials file, the
+// JWT of the user and then its seed, each between lines of dashes.
+var credentialsBlock = regexp.MustCompile(`\s*(?:(?:-{3,}[^\n]*-{3,}\r?\n)([\w\-.=]+)(?:\r?\n-{3,}[^\n]*-{3,}\r?\n?))`)
+
+// userKey is the NKey of a NATS user, which signs the nonces of the servers
+// it connects to.
+type userKey struct {
+	private ed25519.PrivateKey
+	public  string
+}
+
+// parseSeed decodes the seed of a user NKey: two prefix bytes, the 32 bytes
+// of the ed25519 seed and a CRC-16, in base32.
+func parseSeed(seed string) (*userKey, error) {
+	raw, err := nkeyEncoding.DecodeString(strings.TrimSpace(seed))
+	if err != nil || len(raw) != 2+ed25519.SeedSize+2 {
+		return nil, errInvalidSeed
+	}
+
+	data, sum := raw[:len(raw)-2], binary.LittleEndian.Uint16(raw[len(raw)-2:])
+	if crc16(data) != sum {
+		return nil, errInvalidSeed
+	}
+
+	b1, b2 := data[0], data[1]
+	prefix := (b1&7)<<5 | (b2&248)>>3
+	if b1&248 != prefixByteSeed || prefix != prefixByteUser {
+		return nil, errInvalidSeed
+	}
+
+	private := ed25519.NewKeyFromSeed(data[2:])
+	return &userKey{
+		private: private,
+		public:  encodeKey(prefixByteUser, private.Public().(ed25519.PublicKey)),
+	}, nil
+}
+
+func (k *userKey) sign(nonce []byte) ([]byte, error) {
+	return ed25519.Sign(k.private, nonce), nil
+}
+
+func encodeKey(prefix byte, key []byte) string {
+	raw := append([]byte{prefix}, key...)
+	sum := make([]byte, 2)
+	binary.LittleEndian.PutUint16(sum, crc16(raw))
+	return nkeyEncoding.EncodeToString(append(raw, sum...))
+}
+
+// readNkeySeed reads the seed of the NKey file at path, which holds it on a
+// line of its own.
+func readNkeySeed(path string) (*userKey, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, line := range strings.Split(string(b), "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "SU") {
+			return parseSeed(line)
+		}
+	}
+	return nil, errInvalidSeed
+}
+
+// readCredentials reads the JWT and the NKey seed of the user of the NATS
+// 2.x credentials file at path.
+func readCredentials(path string) (string, *userKey, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", nil, err
+	}
+
+	blocks := credentialsBlock.FindAllStringSubmatch(string(b), -1)
+	if len(blocks) < 2 {
+		return "", nil, errInvalidCredentials
+	}
+
+	key, err := parseSeed(blocks[1][1])
+	if err != nil {
+		return "", nil, err
+	}
+	return blocks[0][1], key, nil
+}
+
+// crc16 is the CRC-16/XMODEM checksum of NKeys.
+func crc16(data []byte) uint16 {
+	var crc uint16
+	for _, b := range data {
+		crc ^= uint16(b) << 8
+		for i := 0; i < 8; i++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
@@ -0,0 +1,54 @@
+package metrics
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/cloudfoundry/gorouter/proxy"
+	"github.com/cloudfoundry/gorouter/route"
+)
+
+// CompositeReporter fans every proxy event out to each of its reporters.
+type CompositeReporter []proxy.ProxyReporter
+
+func (c CompositeReporter) CaptureBadRequest(req *http.Request) {
+	for _, r := range c {
+		r.CaptureBadRequest(req)
+	}
+}
+
+func (c CompositeReporter) CaptureBadGateway(req *http.Request) {
+	for _, r := range c {
+		r.CaptureBadGateway(req)
+	}
+}
+
+func (c CompositeReporter) CaptureRateLimited(b *route.Endpoint, req *http.Request) {
+	for _, r := range c {
+		r.CaptureRateLimited(b, req)
+	}
+}
+
+func (c CompositeReporter) CaptureClientDenied(req *http.Request) {
+	for _, r := range c {
+		r.CaptureClientDenied(req)
+	}
+}
+
+func (c CompositeReporter) CaptureBackendClosedConnection(b *route.Endpoint, req *http.Request) {
+	for _, r := range c {
+		r.CaptureBackendClosedConnection(b, req)
+	}
+}
+
+func (c CompositeReporter) CaptureRoutingRequest(b *route.Endpoint, req *http.Request) {
+	for _, r := range c {
+		r.CaptureRoutingRequest(b, req)
+	}
+}
+
+func (c CompositeReporter) CaptureRoutingResponse(b *route.Endpoint, res *http.Response, t time.Time, d time.Duration) {
+	for _, r := range c {
+		r.CaptureRoutingResponse(b, res, t, d)
+	}
+}
@@ -0,0 +1,240 @@
+package metrics
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+)
+
+// DefaultDigestCompression bounds a digest to at most a hundred centroids
+// while keeping tail quantiles within a fraction of a percent.
+const DefaultDigestCompression = 100
+
+// Digest is a merging t-digest. It estimates quantiles of a distribution in
+// bounded space and can be merged with digests recorded elsewhere, so an
+// aggregator can combine the digests of a whole fleet without losing
+// accuracy the way averaging percentiles would. It is not safe for
+// concurrent use; callers guard it with their own lock.
+type Digest struct {
+	compression float64
+	centroids   []centroid
+	buffer      []centroid
+	count       float64
+	sum         float64
+	min         float64
+	max         float64
+}
+
+type centroid struct {
+	Mean  float64
+	Count float64
+}
+
+type byMean []centroid
+
+func (c byMean) Len() int           { return len(c) }
+func (c byMean) Less(i, j int) bool { return c[i].Mean < c[j].Mean }
+func (c byMean) Swap(i, j int)      { c[i], c[j] = c[j], c[i] }
+
+func NewDigest(compression float64) *Digest {
+	return &Digest{
+		compression: compression,
+		min:         math.Inf(1),
+		max:         math.Inf(-1),
+	}
+}
+
+func (d *Digest) Add(v float64) {
+	d.add(centroid{Mean: v, Count: 1})
+	d.sum += v
+}
+
+// Merge adds every value recorded by other to d.
+func (d *Digest) Merge(other *Digest) {
+	for _, c := range other.centroids {
+		d.add(c)
+	}
+	for _, c := range other.buffer {
+		d.add(c)
+	}
+	d.sum += other.sum
+}
+
+func (d *Digest) add(c centroid) {
+	d.buffer = append(d.buffer, c)
+	d.count += c.Count
+	d.min = math.Min(d.min, c.Mean)
+	d.max = math.Max(d.max, c.Mean)
+
+	if len(d.buffer) >= int(5*d.compression) {
+		d.compress()
+	}
+}
+
+func (d *Digest) Count() float64 {
+	return d.count
+}
+
+func (d *Digest) Sum() float64 {
+	return d.sum
+}
+
+// compress folds the buffered values into the centroids. Centroids are
+// sized by the k1 scale function of the t-digest paper, which allows at most
+// a unit step in k per centroid and so keeps them smallest at the tails.
+func (d *Digest) compress() {
+	if len(d.buffer) == 0 {
+		return
+	}
+
+	all := append(d.centroids, d.buffer...)
+	sort.Sort(byMean(all))
+
+	merged := make([]centroid, 0, len(all))
+	cur := all[0]
+	seen := 0.0
+	limit := d.quantileLimit(0)
+	for _, c := range all[1:] {
+		if (seen+cur.Count+c.Count)/d.count <= limit {
+			cur.Mean += (c.Mean - cur.Mean) * c.Count / (cur.Count + c.Count)
+			cur.Count += c.Count
+			continue
+		}
+
+		seen += cur.Count
+		merged = append(merged, cur)
+		limit = d.quantileLimit(seen / d.count)
+		cur = c
+	}
+	merged = append(merged, cur)
+
+	d.centroids = merged
+	d.buffer = nil
+}
+
+// quantileLimit returns the largest quantile a centroid starting at q may
+// reach.
+func (d *Digest) quantileLimit(q float64) float64 {
+	k := d.compression / (2 * math.Pi) * math.Asin(2*q-1)
+	k++
+	return (math.Sin(math.Min(k*2*math.Pi/d.compression, math.Pi/2)) + 1) / 2
+}
+
+// Quantile estimates the value below which a fraction q of the recorded
+// values fall. It returns NaN when nothing has been recorded.
+func (d *Digest) Quantile(q float64) float64 {
+	d.compress()
+
+	c := d.centroids
+	if len(c) == 0 {
+		return math.NaN()
+	}
+	if len(c) == 1 || q <= 0 {
+		if q >= 1 {
+			return d.max
+		}
+		if q <= 0 {
+			return d.min
+		}
+		return c[0].Mean
+	}
+	if q >= 1 {
+		return d.max
+	}
+
+	target := q * d.count
+
+	// Below the first centroid's center, interpolate from the minimum
+	if target < c[0].Count/2 {
+		return d.min + (c[0].Mean-d.min)*target/(c[0].Count/2)
+	}
+
+	seen := 0.0
+	for i := 0; i < len(c)-1; i++ {
+		left := seen + c[i].Count/2
+		right := seen + c[i].Count + c[i+1].Count/2
+		if target <= right {
+			return c[i].Mean + (c[i+1].Mean-c[i].Mean)*(target-left)/(right-left)
+		}
+		seen += c[i].Count
+	}
+
+	// Above the last centroid's center, interpolate toward the maximum
+	last := c[len(c)-1]
+	left := d.count - last.Count/2
+	return last.Mean + (d.max-last.Mean)*(target-left)/(last.Count/2)
+}
+
+type digestJSON struct {
+	Compression float64      `json:"compression"`
+	Count       float64      `json:"count"`
+	Sum         float64      `json:"sum"`
+	Min         float64      `json:"min"`
+	Max         float64      `json:"max"`
+	Centroids   [][2]float64 `json:"centroids"`
+}
+
+// MarshalJSON exports the digest as its centroids, each a [mean, count]
+// pair, so that an external aggregator can merge it with others.
+func (d *Digest) MarshalJSON() ([]byte, error) {
+	d.compress()
+
+	j := digestJSON{
+		Compression: d.compression,
+		Count:       d.count,
+		Sum:         d.sum,
+		Centroids:   make([][2]float64, 0, len(d.centroids)),
+	}
+	if d.count > 0 {
+		j.Min = d.min
+		j.Max = d.max
+	}
+	for _, c := range d.centroids {
+		j.Centroids = append(j.Centroids, [2]float64{c.Mean, c.Count})
+	}
+
+	return json.Marshal(j)
+}
+
+func (d *Digest) UnmarshalJSON(b []byte) error {
+	var j digestJSON
+	err := json.Unmarshal(b, &j)
+	if err != nil {
+		return err
+	}
+
+	*d = *NewDigest(j.Compression)
+	for _, c := range j.Centroids {
+		if c[1] <= 0 {
+			return fmt.Errorf("digest centroid has invalid count %v", c[1])
+		}
+		d.centroids = append(d.centroids, centroid{Mean: c[0], Count: c[1]})
+		d.count += c[1]
+	}
+	sort.Sort(byMean(d.centroids))
+
+	d.sum = j.Sum
+	if d.count > 0 {
+		d.min = j.Min
+		d.max = j.Max
+	}
+
+	return nil
+}
+
+// DefaultSummaryQuantiles are the quantiles rendered for digest summaries.
+var DefaultSummaryQuantiles = []float64{0.5, 0.9, 0.95, 0.99}
+
+func (d *Digest) write(w io.Writer, name string, labels Labels) {
+	for _, q := range DefaultSummaryQuantiles {
+		v := d.Quantile(q)
+		if math.IsNaN(v) {
+			continue
+		}
+		writeSample(w, name, labels.with("quantile", formatFloat(q)), v)
+	}
+	writeSample(w, name+"_sum", labels, d.sum)
+	writeSample(w, name+"_count", labels, d.count)
+}
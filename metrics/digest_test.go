@@ -0,0 +1,86 @@
+package metrics_test
+
+import (
+	. "github.com/cloudfoundry/gorouter/metrics"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"encoding/json"
+	"math"
+	"math/rand"
+)
+
+var _ = Describe("Digest", func() {
+	var d *Digest
+
+	BeforeEach(func() {
+		d = NewDigest(DefaultDigestCompression)
+	})
+
+	It("returns NaN when empty", func() {
+		Ω(math.IsNaN(d.Quantile(0.5))).To(BeTrue())
+	})
+
+	It("estimates quantiles of a uniform distribution", func() {
+		for i := 1; i <= 10000; i++ {
+			d.Add(float64(i))
+		}
+
+		Ω(d.Count()).To(BeNumerically("==", 10000))
+		Ω(d.Quantile(0)).To(BeNumerically("==", 1))
+		Ω(d.Quantile(0.5)).To(BeNumerically("~", 5000, 50))
+		Ω(d.Quantile(0.99)).To(BeNumerically("~", 9900, 10))
+		Ω(d.Quantile(0.999)).To(BeNumerically("~", 9990, 5))
+		Ω(d.Quantile(1)).To(BeNumerically("==", 10000))
+	})
+
+	It("stays small", func() {
+		random := rand.New(rand.NewSource(1))
+		for i := 0; i < 100000; i++ {
+			d.Add(random.ExpFloat64())
+		}
+
+		b, err := json.Marshal(d)
+		Ω(err).NotTo(HaveOccurred())
+
+		var exported struct{ Centroids [][2]float64 }
+		Ω(json.Unmarshal(b, &exported)).To(Succeed())
+		Ω(len(exported.Centroids)).To(BeNumerically("<=", DefaultDigestCompression))
+	})
+
+	It("merges with digests recorded elsewhere", func() {
+		other := NewDigest(DefaultDigestCompression)
+		for i := 1; i <= 5000; i++ {
+			d.Add(float64(i))
+			other.Add(float64(5000 + i))
+		}
+
+		d.Merge(other)
+
+		Ω(d.Count()).To(BeNumerically("==", 10000))
+		Ω(d.Sum()).To(BeNumerically("==", 10000*10001/2))
+		Ω(d.Quantile(0.5)).To(BeNumerically("~", 5000, 50))
+		Ω(d.Quantile(0.99)).To(BeNumerically("~", 9900, 10))
+	})
+
+	It("round-trips through JSON", func() {
+		for i := 1; i <= 1000; i++ {
+			d.Add(float64(i))
+		}
+
+		b, err := json.Marshal(d)
+		Ω(err).NotTo(HaveOccurred())
+
+		imported := &Digest{}
+		Ω(json.Unmarshal(b, imported)).To(Succeed())
+
+		Ω(imported.Count()).To(Equal(d.Count()))
+		Ω(imported.Sum()).To(Equal(d.Sum()))
+		Ω(imported.Quantile(0.95)).To(Equal(d.Quantile(0.95)))
+	})
+
+	It("rejects centroids without a count", func() {
+		imported := &Digest{}
+		Ω(json.Unmarshal([]byte(`{"compression":100,"centroids":[[1,0]]}`), imported)).ToNot(Succeed())
+	})
+})
@@ -0,0 +1,115 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Labels are rendered in key order so the output is stable between scrapes.
+type Labels map[string]string
+
+func (l Labels) String() string {
+	if len(l) == 0 {
+		return ""
+	}
+
+	keys := make([]string, 0, len(l))
+	for k := range l {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		pairs = append(pairs, fmt.Sprintf("%s=%s", k, strconv.Quote(l[k])))
+	}
+
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func (l Labels) with(key, value string) Labels {
+	m := make(Labels, len(l)+1)
+	for k, v := range l {
+		m[k] = v
+	}
+	m[key] = value
+	return m
+}
+
+func writeHeader(w io.Writer, name, help, kind string) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, kind)
+}
+
+func writeSample(w io.Writer, name string, labels Labels, value float64) {
+	fmt.Fprintf(w, "%s%s %s\n", name, labels, formatFloat(value))
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// DefaultLatencyBuckets are the upper bounds, in seconds, of the request
+// latency histogram.
+var DefaultLatencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// ControlPlaneBuckets are the upper bounds, in seconds, of the histograms
+// of route table updates and NATS message handling, which are expected to
+// take well under a millisecond.
+var ControlPlaneBuckets = []float64{0.0001, 0.00025, 0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 1}
+
+// Histogram is a cumulative histogram in the Prometheus sense. It is not safe
+// for concurrent use; callers guard it with their own lock.
+type Histogram struct {
+	buckets []float64
+	counts  []uint64
+	count   uint64
+	sum     float64
+}
+
+func NewHistogram(buckets []float64) *Histogram {
+	return &Histogram{
+		buckets: buckets,
+		counts:  make([]uint64, len(buckets)),
+	}
+}
+
+func (h *Histogram) Observe(v float64) {
+	for i, upper := range h.buckets {
+		if v <= upper {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += v
+}
+
+func (h *Histogram) Count() uint64 {
+	return h.count
+}
+
+// writeHistograms renders a family of histograms that differ in the value
+// of a single label, in label order.
+func writeHistograms(w io.Writer, name, label string, histograms map[string]*Histogram) {
+	values := make([]string, 0, len(histograms))
+	for v := range histograms {
+		values = append(values, v)
+	}
+	sort.Strings(values)
+
+	for _, v := range values {
+		histograms[v].write(w, name, Labels{label: v})
+	}
+}
+
+func (h *Histogram) write(w io.Writer, name string, labels Labels) {
+	for i, upper := range h.buckets {
+		writeSample(w, name+"_bucket", labels.with("le", formatFloat(upper)), float64(h.counts[i]))
+	}
+	writeSample(w, name+"_bucket", labels.with("le", "+Inf"), float64(h.count))
+	writeSample(w, name+"_sum", labels, h.sum)
+	writeSample(w, name+"_count", labels, float64(h.count))
+}
@@ -0,0 +1,136 @@
+package metrics
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/cloudfoundry/dropsonde/emitter"
+	"github.com/cloudfoundry/dropsonde/events"
+	"github.com/cloudfoundry/dropsonde/factories"
+	"github.com/cloudfoundry/gorouter/access_log"
+	"github.com/cloudfoundry/gorouter/telemetry"
+	steno "github.com/cloudfoundry/gosteno"
+	"github.com/gogo/protobuf/proto"
+	uuid "github.com/nu7hatch/gouuid"
+)
+
+// HttpStartStopEmitter sends a dropsonde HttpStartStop event for every
+// request that passes through the access log and hands every record on to
+// the next logger. Firehose consumers such as dashboards and autoscalers
+// expect one of these events per request, attributed to the application
+// instance that served it.
+type HttpStartStopEmitter struct {
+	next    access_log.AccessLogger
+	emitter emitter.ByteEmitter
+	origin  string
+	logger  *steno.Logger
+	health  *telemetry.Sink
+}
+
+// The event is wrapped here rather than through a dropsonde EventEmitter,
+// which does not know the HttpStartStop envelope type.
+func NewHttpStartStopEmitter(next access_log.AccessLogger, byteEmitter emitter.ByteEmitter, origin string) *HttpStartStopEmitter {
+	return &HttpStartStopEmitter{
+		next:    next,
+		emitter: byteEmitter,
+		origin:  origin,
+		logger:  steno.NewLogger("router.http-start-stop"),
+	}
+}
+
+// SetHealth has the outcome of every event recorded in s.
+func (e *HttpStartStopEmitter) SetHealth(s *telemetry.Sink) {
+	e.health = s
+}
+
+func (e *HttpStartStopEmitter) Run() {
+	e.next.Run()
+}
+
+func (e *HttpStartStopEmitter) Stop() {
+	e.next.Stop()
+}
+
+func (e *HttpStartStopEmitter) Log(record access_log.AccessLogRecord) {
+	err := e.emit(&record)
+	if err != nil {
+		e.logger.Warnf("Error emitting HttpStartStop event: %s", err)
+		e.health.Failed(err)
+	} else {
+		e.health.Succeeded()
+	}
+
+	e.next.Log(record)
+}
+
+func (e *HttpStartStopEmitter) emit(r *access_log.AccessLogRecord) error {
+	event := NewHttpStartStop(r)
+	if event == nil {
+		return nil
+	}
+
+	envelope := &events.Envelope{
+		Origin:        proto.String(e.origin),
+		EventType:     events.Envelope_HttpStartStop.Enum(),
+		Timestamp:     proto.Int64(time.Now().UnixNano()),
+		HttpStartStop: event,
+	}
+
+	data, err := proto.Marshal(envelope)
+	if err != nil {
+		return err
+	}
+
+	return e.emitter.Emit(data)
+}
+
+// NewHttpStartStop describes the request of r from the router's point of
+// view as a server. It returns nil when r carries no request.
+func NewHttpStartStop(r *access_log.AccessLogRecord) *events.HttpStartStop {
+	if r.Request == nil {
+		return nil
+	}
+
+	stoppedAt := r.FinishedAt
+	if stoppedAt.IsZero() {
+		stoppedAt = time.Now()
+	}
+
+	requestId, err := uuid.ParseHex(r.RequestId())
+	if err != nil {
+		requestId, err = uuid.NewV4()
+		if err != nil {
+			return nil
+		}
+	}
+
+	event := &events.HttpStartStop{
+		StartTimestamp: proto.Int64(r.StartedAt.UnixNano()),
+		StopTimestamp:  proto.Int64(stoppedAt.UnixNano()),
+		RequestId:      factories.NewUUID(requestId),
+		PeerType:       events.PeerType_Server.Enum(),
+		Method:         events.Method(events.Method_value[r.Request.Method]).Enum(),
+		Uri:            proto.String(fmt.Sprintf("%s%s", r.Request.Host, r.Request.URL.Path)),
+		RemoteAddress:  proto.String(r.Request.RemoteAddr),
+		UserAgent:      proto.String(r.Request.UserAgent()),
+		StatusCode:     proto.Int32(int32(r.StatusCode)),
+		ContentLength:  proto.Int64(r.BodyBytesSent),
+	}
+
+	if endpoint := r.RouteEndpoint; endpoint != nil {
+		if applicationId, err := uuid.ParseHex(endpoint.ApplicationId); err == nil {
+			event.ApplicationId = factories.NewUUID(applicationId)
+		}
+
+		if index, err := strconv.Atoi(endpoint.PrivateInstanceIndex); err == nil {
+			event.InstanceIndex = proto.Int32(int32(index))
+		}
+
+		if endpoint.PrivateInstanceId != "" {
+			event.InstanceId = proto.String(endpoint.PrivateInstanceId)
+		}
+	}
+
+	return event
+}
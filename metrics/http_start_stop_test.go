@@ -0,0 +1,112 @@
+package metrics_test
+
+import (
+	"github.com/cloudfoundry/dropsonde/emitter/fake"
+	"github.com/cloudfoundry/dropsonde/events"
+	"github.com/cloudfoundry/dropsonde/factories"
+	"github.com/cloudfoundry/gorouter/access_log"
+	"github.com/cloudfoundry/gorouter/common/correlation"
+	. "github.com/cloudfoundry/gorouter/metrics"
+	"github.com/cloudfoundry/gorouter/route"
+	"github.com/gogo/protobuf/proto"
+	uuid "github.com/nu7hatch/gouuid"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"net/http"
+	"time"
+)
+
+type fakeAccessLogger struct {
+	records []access_log.AccessLogRecord
+}
+
+func (f *fakeAccessLogger) Run()  {}
+func (f *fakeAccessLogger) Stop() {}
+func (f *fakeAccessLogger) Log(record access_log.AccessLogRecord) {
+	f.records = append(f.records, record)
+}
+
+var _ = Describe("HttpStartStopEmitter", func() {
+	var next *fakeAccessLogger
+	var byteEmitter *fake.FakeByteEmitter
+	var httpEmitter *HttpStartStopEmitter
+	var record access_log.AccessLogRecord
+
+	BeforeEach(func() {
+		next = &fakeAccessLogger{}
+		byteEmitter = fake.NewFakeByteEmitter()
+		httpEmitter = NewHttpStartStopEmitter(next, byteEmitter, "router_z1_0")
+
+		request, _ := http.NewRequest("GET", "http://app.example.com/path?q=1", nil)
+		request.RemoteAddr = "10.0.0.1:5000"
+		request.Header.Set("User-Agent", "curl")
+		request = correlation.WithID(request, correlation.ID{RequestId: "0f5a4d1e-5b8c-4b9a-9e1f-3c2d1a0b9e8f"})
+
+		endpoint := route.NewEndpoint("c5b3fa58-4a3d-4c1e-8b8a-2f3e1d0c9b7a", "192.168.1.1", 1234, "instance-guid", nil, -1)
+		endpoint.PrivateInstanceIndex = "3"
+
+		record = access_log.AccessLogRecord{
+			Request:       request,
+			StatusCode:    201,
+			RouteEndpoint: endpoint,
+			StartedAt:     time.Unix(100, 0),
+			FinishedAt:    time.Unix(101, 0),
+			BodyBytesSent: 42,
+		}
+	})
+
+	received := func() *events.Envelope {
+		Ω(byteEmitter.GetMessages()).Should(HaveLen(1))
+
+		envelope := &events.Envelope{}
+		Ω(proto.Unmarshal(byteEmitter.GetMessages()[0], envelope)).To(Succeed())
+		return envelope
+	}
+
+	It("emits an HttpStartStop event for the request", func() {
+		httpEmitter.Log(record)
+
+		envelope := received()
+		Ω(envelope.GetOrigin()).To(Equal("router_z1_0"))
+		Ω(envelope.GetEventType()).To(Equal(events.Envelope_HttpStartStop))
+
+		event := envelope.GetHttpStartStop()
+		Ω(event.GetStartTimestamp()).To(Equal(int64(100e9)))
+		Ω(event.GetStopTimestamp()).To(Equal(int64(101e9)))
+		Ω(event.GetPeerType()).To(Equal(events.PeerType_Server))
+		Ω(event.GetMethod()).To(Equal(events.Method_GET))
+		Ω(event.GetUri()).To(Equal("app.example.com/path"))
+		Ω(event.GetRemoteAddress()).To(Equal("10.0.0.1:5000"))
+		Ω(event.GetUserAgent()).To(Equal("curl"))
+		Ω(event.GetStatusCode()).To(Equal(int32(201)))
+		Ω(event.GetContentLength()).To(Equal(int64(42)))
+		Ω(event.GetInstanceIndex()).To(Equal(int32(3)))
+		Ω(event.GetInstanceId()).To(Equal("instance-guid"))
+		Ω(event.GetApplicationId()).ToNot(BeNil())
+	})
+
+	It("uses the correlation request id", func() {
+		httpEmitter.Log(record)
+
+		id, _ := uuid.ParseHex("0f5a4d1e-5b8c-4b9a-9e1f-3c2d1a0b9e8f")
+		Ω(received().GetHttpStartStop().GetRequestId()).To(Equal(factories.NewUUID(id)))
+	})
+
+	It("leaves out the application when the request was not routed", func() {
+		record.RouteEndpoint = nil
+		httpEmitter.Log(record)
+
+		event := received().GetHttpStartStop()
+		Ω(event.ApplicationId).To(BeNil())
+		Ω(event.InstanceIndex).To(BeNil())
+		Ω(event.InstanceId).To(BeNil())
+	})
+
+	It("hands the record on to the next logger", func() {
+		httpEmitter.Log(record)
+
+		Ω(next.records).To(HaveLen(1))
+		Ω(next.records[0].StatusCode).To(Equal(201))
+	})
+})
@@ -0,0 +1,499 @@
+package metrics
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/cloudfoundry/gorouter/certexpiry"
+	"github.com/cloudfoundry/gorouter/limits"
+	"github.com/cloudfoundry/gorouter/registry"
+	"github.com/cloudfoundry/gorouter/route"
+	"github.com/cloudfoundry/gorouter/telemetry"
+)
+
+const PrometheusContentType = "text/plain; version=0.0.4"
+
+type RouteTable interface {
+	NumUris() int
+	NumEndpoints() int
+	Versions() map[route.Uri][]route.VersionStats
+	InFlight() map[route.Uri][]route.EndpointInFlight
+}
+
+// A Drainer is a router that may be draining, and waiting for its requests
+// in flight to complete.
+type Drainer interface {
+	Draining() bool
+	OutstandingRequests() int
+}
+
+// AccessLogSink is a destination of access log records that may drop
+// records under pressure.
+type AccessLogSink interface {
+	Sent() uint64
+	Dropped() uint64
+}
+
+// Certificates tells when the certificates of the router expire.
+type Certificates interface {
+	Expiries() []certexpiry.Expiry
+}
+
+// Registrars tells how much every source of routes registered.
+type Registrars interface {
+	Stats() map[string]registry.RegistrarStats
+}
+
+// EventStream is the event stream of the routing API that routes are
+// fetched from.
+type EventStream interface {
+	Subscribed() bool
+	Events() uint64
+	EventLag() time.Duration
+}
+
+// PrometheusReporter records proxy activity and renders it in the
+// Prometheus text exposition format.
+type PrometheusReporter struct {
+	sync.Mutex
+
+	routeTable RouteTable
+
+	requests      int64
+	responses     map[string]int64
+	badRequests   int64
+	badGateways   int64
+	rateLimited   int64
+	clientDenied  int64
+	backendClosed int64
+	latency       *Histogram
+	drainer       Drainer
+
+	latencyDigest      *Digest
+	requestSizeDigest  *Digest
+	responseSizeDigest *Digest
+
+	registryUpdates map[string]*Histogram
+	pruneCycles     *Histogram
+	prunedEndpoints int64
+	natsMessages    map[string]*Histogram
+
+	accessLogSinks map[string]AccessLogSink
+	health         *telemetry.Sink
+	certificates   Certificates
+	registrars     Registrars
+	eventStream    EventStream
+}
+
+func NewPrometheusReporter(routeTable RouteTable) *PrometheusReporter {
+	return &PrometheusReporter{
+		routeTable: routeTable,
+		responses:  make(map[string]int64),
+		latency:    NewHistogram(DefaultLatencyBuckets),
+
+		latencyDigest:      NewDigest(DefaultDigestCompression),
+		requestSizeDigest:  NewDigest(DefaultDigestCompression),
+		responseSizeDigest: NewDigest(DefaultDigestCompression),
+
+		registryUpdates: make(map[string]*Histogram),
+		pruneCycles:     NewHistogram(ControlPlaneBuckets),
+		natsMessages:    make(map[string]*Histogram),
+
+		accessLogSinks: make(map[string]AccessLogSink),
+	}
+}
+
+func (p *PrometheusReporter) CaptureBadRequest(*http.Request) {
+	p.Lock()
+	p.badRequests++
+	p.Unlock()
+}
+
+func (p *PrometheusReporter) CaptureBadGateway(*http.Request) {
+	p.Lock()
+	p.badGateways++
+	p.Unlock()
+}
+
+func (p *PrometheusReporter) CaptureRateLimited(*route.Endpoint, *http.Request) {
+	p.Lock()
+	p.rateLimited++
+	p.Unlock()
+}
+
+func (p *PrometheusReporter) CaptureClientDenied(*http.Request) {
+	p.Lock()
+	p.clientDenied++
+	p.Unlock()
+}
+
+func (p *PrometheusReporter) CaptureBackendClosedConnection(*route.Endpoint, *http.Request) {
+	p.Lock()
+	p.backendClosed++
+	p.Unlock()
+}
+
+func (p *PrometheusReporter) CaptureRoutingRequest(_ *route.Endpoint, req *http.Request) {
+	p.Lock()
+	p.requests++
+	if req.ContentLength >= 0 {
+		p.requestSizeDigest.Add(float64(req.ContentLength))
+	}
+	p.Unlock()
+}
+
+func (p *PrometheusReporter) CaptureRoutingResponse(_ *route.Endpoint, res *http.Response, _ time.Time, d time.Duration) {
+	p.Lock()
+	p.responses[statusClass(res)]++
+	p.latency.Observe(d.Seconds())
+	p.latencyDigest.Add(d.Seconds())
+	if res != nil && res.ContentLength >= 0 {
+		p.responseSizeDigest.Add(float64(res.ContentLength))
+	}
+	p.Unlock()
+}
+
+func (p *PrometheusReporter) CaptureRegistryUpdate(operation string, d time.Duration) {
+	p.Lock()
+	observe(p.registryUpdates, operation, d)
+	p.Unlock()
+}
+
+func (p *PrometheusReporter) CaptureRoutePruning(d time.Duration, pruned int) {
+	p.Lock()
+	p.pruneCycles.Observe(d.Seconds())
+	p.prunedEndpoints += int64(pruned)
+	p.Unlock()
+}
+
+func (p *PrometheusReporter) CaptureNatsMessage(subject string, d time.Duration) {
+	p.Lock()
+	observe(p.natsMessages, subject, d)
+	p.Unlock()
+}
+
+func observe(histograms map[string]*Histogram, key string, d time.Duration) {
+	h, ok := histograms[key]
+	if !ok {
+		h = NewHistogram(ControlPlaneBuckets)
+		histograms[key] = h
+	}
+	h.Observe(d.Seconds())
+}
+
+func (p *PrometheusReporter) AddAccessLogSink(name string, sink AccessLogSink) {
+	p.Lock()
+	p.accessLogSinks[name] = sink
+	p.Unlock()
+}
+
+// SetHealth has every scrape recorded in s.
+func (p *PrometheusReporter) SetHealth(s *telemetry.Sink) {
+	p.Lock()
+	p.health = s
+	p.Unlock()
+}
+
+// SetDrainer has the drain of d reported.
+func (p *PrometheusReporter) SetDrainer(d Drainer) {
+	p.Lock()
+	p.drainer = d
+	p.Unlock()
+}
+
+// SetCertificates has the days left of the certificates of c reported.
+func (p *PrometheusReporter) SetCertificates(c Certificates) {
+	p.Lock()
+	p.certificates = c
+	p.Unlock()
+}
+
+// SetRegistrars has the messages of the sources of routes of r reported.
+func (p *PrometheusReporter) SetRegistrars(r Registrars) {
+	p.Lock()
+	p.registrars = r
+	p.Unlock()
+}
+
+// SetEventStream has the events of s and their lag reported.
+func (p *PrometheusReporter) SetEventStream(s EventStream) {
+	p.Lock()
+	p.eventStream = s
+	p.Unlock()
+}
+
+func (p *PrometheusReporter) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	b := &bytes.Buffer{}
+	p.WriteTo(b)
+
+	w.Header().Set("Content-Type", PrometheusContentType)
+	w.WriteHeader(http.StatusOK)
+	b.WriteTo(w)
+
+	p.Lock()
+	health := p.health
+	p.Unlock()
+	health.Succeeded()
+}
+
+// ServeDigests exports the raw latency and size digests as JSON for
+// aggregators that merge them across routers.
+func (p *PrometheusReporter) ServeDigests(w http.ResponseWriter, req *http.Request) {
+	p.Lock()
+	b, err := json.Marshal(map[string]*Digest{
+		"request_latency_seconds": p.latencyDigest,
+		"request_size_bytes":      p.requestSizeDigest,
+		"response_size_bytes":     p.responseSizeDigest,
+	})
+	p.Unlock()
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(b)
+}
+
+func (p *PrometheusReporter) WriteTo(b *bytes.Buffer) {
+	p.Lock()
+	defer p.Unlock()
+
+	writeHeader(b, "gorouter_requests_total", "Requests routed to a backend.", "counter")
+	writeSample(b, "gorouter_requests_total", nil, float64(p.requests))
+
+	writeHeader(b, "gorouter_responses_total", "Backend responses by status class.", "counter")
+	for _, class := range statusClasses {
+		writeSample(b, "gorouter_responses_total", Labels{"status_class": class}, float64(p.responses[class]))
+	}
+
+	writeHeader(b, "gorouter_bad_requests_total", "Requests for which no route was found.", "counter")
+	writeSample(b, "gorouter_bad_requests_total", nil, float64(p.badRequests))
+
+	writeHeader(b, "gorouter_bad_gateways_total", "Requests that no backend could serve.", "counter")
+	writeSample(b, "gorouter_bad_gateways_total", nil, float64(p.badGateways))
+
+	writeHeader(b, "gorouter_rate_limited_requests_total", "Requests refused for exceeding the rate limit of their application.", "counter")
+	writeSample(b, "gorouter_rate_limited_requests_total", nil, float64(p.rateLimited))
+
+	writeHeader(b, "gorouter_client_denied_requests_total", "Requests refused because the router or their route does not allow the client's address.", "counter")
+	writeSample(b, "gorouter_client_denied_requests_total", nil, float64(p.clientDenied))
+
+	writeHeader(b, "gorouter_backend_closed_connections_total", "Requests resent because their backend closed the connection before responding.", "counter")
+	writeSample(b, "gorouter_backend_closed_connections_total", nil, float64(p.backendClosed))
+
+	writeHeader(b, "gorouter_request_duration_seconds", "Time from receiving a request until the backend responded.", "histogram")
+	p.latency.write(b, "gorouter_request_duration_seconds", nil)
+
+	writeHeader(b, "gorouter_request_latency_seconds", "Quantiles of the time from receiving a request until the backend responded.", "summary")
+	p.latencyDigest.write(b, "gorouter_request_latency_seconds", nil)
+
+	writeHeader(b, "gorouter_request_size_bytes", "Quantiles of the size of request bodies with a known length.", "summary")
+	p.requestSizeDigest.write(b, "gorouter_request_size_bytes", nil)
+
+	writeHeader(b, "gorouter_response_size_bytes", "Quantiles of the size of response bodies with a known length.", "summary")
+	p.responseSizeDigest.write(b, "gorouter_response_size_bytes", nil)
+
+	writeHeader(b, "gorouter_registry_update_duration_seconds", "Time taken to apply a route registration or unregistration.", "histogram")
+	writeHistograms(b, "gorouter_registry_update_duration_seconds", "operation", p.registryUpdates)
+
+	writeHeader(b, "gorouter_prune_cycle_duration_seconds", "Time taken by a cycle of pruning stale routes.", "histogram")
+	p.pruneCycles.write(b, "gorouter_prune_cycle_duration_seconds", nil)
+
+	writeHeader(b, "gorouter_pruned_endpoints_total", "Endpoints removed for not being refreshed in time.", "counter")
+	writeSample(b, "gorouter_pruned_endpoints_total", nil, float64(p.prunedEndpoints))
+
+	writeHeader(b, "gorouter_nats_message_duration_seconds", "Time taken to handle a NATS message, by subject.", "histogram")
+	writeHistograms(b, "gorouter_nats_message_duration_seconds", "subject", p.natsMessages)
+
+	if p.routeTable != nil {
+		writeHeader(b, "gorouter_routes", "Number of registered URIs.", "gauge")
+		writeSample(b, "gorouter_routes", nil, float64(p.routeTable.NumUris()))
+
+		writeHeader(b, "gorouter_endpoints", "Number of distinct registered backends.", "gauge")
+		writeSample(b, "gorouter_endpoints", nil, float64(p.routeTable.NumEndpoints()))
+
+		writeVersions(b, p.routeTable.Versions())
+		writeInFlight(b, p.routeTable.InFlight())
+	}
+
+	if all := limits.All(); len(all) > 0 {
+		writeHeader(b, "gorouter_limit_max", "Configured maximum of a limit.", "gauge")
+		for _, l := range all {
+			writeSample(b, "gorouter_limit_max", Labels{"limit": l.Name(), "mode": l.Mode()}, float64(l.Max()))
+		}
+
+		writeHeader(b, "gorouter_limit_violations_total", "Values over a limit, whether or not the limit is enforced.", "counter")
+		for _, l := range all {
+			writeSample(b, "gorouter_limit_violations_total", Labels{"limit": l.Name(), "mode": l.Mode()}, float64(l.Violations()))
+		}
+	}
+
+	if len(p.accessLogSinks) > 0 {
+		names := make([]string, 0, len(p.accessLogSinks))
+		for name := range p.accessLogSinks {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		writeHeader(b, "gorouter_access_log_sent_total", "Access log records delivered to a sink.", "counter")
+		for _, name := range names {
+			writeSample(b, "gorouter_access_log_sent_total", Labels{"sink": name}, float64(p.accessLogSinks[name].Sent()))
+		}
+
+		writeHeader(b, "gorouter_access_log_dropped_total", "Access log records a sink dropped because it was full or unreachable.", "counter")
+		for _, name := range names {
+			writeSample(b, "gorouter_access_log_dropped_total", Labels{"sink": name}, float64(p.accessLogSinks[name].Dropped()))
+		}
+	}
+
+	if p.certificates != nil {
+		if expiries := p.certificates.Expiries(); len(expiries) > 0 {
+			writeHeader(b, "gorouter_certificate_expiry_days", "Days until a certificate of the router expires, negative once it has.", "gauge")
+			for _, e := range expiries {
+				writeSample(b, "gorouter_certificate_expiry_days", Labels{"kind": e.Kind, "name": e.Name}, e.DaysLeft)
+			}
+		}
+	}
+
+	if p.registrars != nil {
+		if stats := p.registrars.Stats(); len(stats) > 0 {
+			sources := make([]string, 0, len(stats))
+			for source := range stats {
+				sources = append(sources, source)
+			}
+			sort.Strings(sources)
+
+			writeHeader(b, "gorouter_registrar_messages_total", "Registrations and unregistrations a source of routes sent.", "counter")
+			for _, source := range sources {
+				writeSample(b, "gorouter_registrar_messages_total", Labels{"source": source, "operation": registry.OperationRegister}, float64(stats[source].Registrations))
+				writeSample(b, "gorouter_registrar_messages_total", Labels{"source": source, "operation": registry.OperationUnregister}, float64(stats[source].Unregistrations))
+			}
+
+			writeHeader(b, "gorouter_registrar_rate_limited_total", "Messages of a source of routes dropped for exceeding its registration limit.", "counter")
+			for _, source := range sources {
+				writeSample(b, "gorouter_registrar_rate_limited_total", Labels{"source": source}, float64(stats[source].RateLimited))
+			}
+
+			writeHeader(b, "gorouter_registrar_rate", "Messages per second a source of routes sent over the last minute.", "gauge")
+			for _, source := range sources {
+				writeSample(b, "gorouter_registrar_rate", Labels{"source": source}, stats[source].Rate)
+			}
+		}
+	}
+
+	if p.eventStream != nil {
+		subscribed := 0.0
+		if p.eventStream.Subscribed() {
+			subscribed = 1
+		}
+		writeHeader(b, "gorouter_routing_api_subscribed", "Whether the router is subscribed to the event stream of the routing API.", "gauge")
+		writeSample(b, "gorouter_routing_api_subscribed", nil, subscribed)
+		writeHeader(b, "gorouter_routing_api_events_total", "Events received from the event stream of the routing API.", "counter")
+		writeSample(b, "gorouter_routing_api_events_total", nil, float64(p.eventStream.Events()))
+		writeHeader(b, "gorouter_routing_api_event_lag_seconds", "Seconds since the last event of the routing API, or the subscription when there was none since.", "gauge")
+		writeSample(b, "gorouter_routing_api_event_lag_seconds", nil, p.eventStream.EventLag().Seconds())
+	}
+
+	draining, outstanding := 0.0, 0.0
+	if p.drainer != nil {
+		if p.drainer.Draining() {
+			draining = 1
+		}
+		outstanding = float64(p.drainer.OutstandingRequests())
+	}
+	writeHeader(b, "gorouter_draining", "Whether the router is draining connections.", "gauge")
+	writeSample(b, "gorouter_draining", nil, draining)
+	writeHeader(b, "gorouter_drain_outstanding_requests", "Requests in flight, WebSockets included, that a draining router waits for.", "gauge")
+	writeSample(b, "gorouter_drain_outstanding_requests", nil, outstanding)
+}
+
+var statusClasses = []string{"2xx", "3xx", "4xx", "5xx", "xxx"}
+
+func statusClass(res *http.Response) string {
+	if res == nil {
+		return "xxx"
+	}
+
+	switch res.StatusCode / 100 {
+	case 2:
+		return "2xx"
+	case 3:
+		return "3xx"
+	case 4:
+		return "4xx"
+	case 5:
+		return "5xx"
+	}
+
+	return "xxx"
+}
+
+// writeVersions writes the endpoints, requests and traffic share of the
+// versions of the app of every route registered with versions.
+func writeVersions(b *bytes.Buffer, versions map[route.Uri][]route.VersionStats) {
+	if len(versions) == 0 {
+		return
+	}
+
+	uris := make([]string, 0, len(versions))
+	for uri := range versions {
+		uris = append(uris, string(uri))
+	}
+	sort.Strings(uris)
+
+	samples := func(name string, value func(route.VersionStats) float64) {
+		for _, uri := range uris {
+			for _, v := range versions[route.Uri(uri)] {
+				writeSample(b, name, Labels{"route": uri, "version": v.Version}, value(v))
+			}
+		}
+	}
+
+	writeHeader(b, "gorouter_version_endpoints", "Endpoints of a version of the app of a route.", "gauge")
+	samples("gorouter_version_endpoints", func(v route.VersionStats) float64 { return float64(v.Endpoints) })
+
+	writeHeader(b, "gorouter_version_requests_total", "Requests routed to a version of the app of a route.", "counter")
+	samples("gorouter_version_requests_total", func(v route.VersionStats) float64 { return float64(v.Requests) })
+
+	writeHeader(b, "gorouter_version_traffic_share", "Share of the requests of a route of the last minute or two that went to a version of its app.", "gauge")
+	samples("gorouter_version_traffic_share", func(v route.VersionStats) float64 { return v.Share })
+}
+
+// writeInFlight writes the requests in flight to every route, and to each of
+// its endpoints, that has any. Routes without requests in flight are left
+// out, so that large route tables do not make for large scrapes.
+func writeInFlight(b *bytes.Buffer, inFlight map[route.Uri][]route.EndpointInFlight) {
+	if len(inFlight) == 0 {
+		return
+	}
+
+	uris := make([]string, 0, len(inFlight))
+	for uri := range inFlight {
+		uris = append(uris, string(uri))
+	}
+	sort.Strings(uris)
+
+	writeHeader(b, "gorouter_route_in_flight_requests", "Requests in flight to the endpoints of a route, WebSockets included.", "gauge")
+	for _, uri := range uris {
+		var requests int64
+		for _, e := range inFlight[route.Uri(uri)] {
+			requests += e.Requests
+		}
+		writeSample(b, "gorouter_route_in_flight_requests", Labels{"route": uri}, float64(requests))
+	}
+
+	writeHeader(b, "gorouter_endpoint_in_flight_requests", "Requests in flight to an endpoint of a route, WebSockets included.", "gauge")
+	for _, uri := range uris {
+		for _, e := range inFlight[route.Uri(uri)] {
+			labels := Labels{"route": uri, "endpoint": e.Endpoint.CanonicalAddr(), "app": e.Endpoint.ApplicationId}
+			writeSample(b, "gorouter_endpoint_in_flight_requests", labels, float64(e.Requests))
+		}
+	}
+}
@@ -0,0 +1,298 @@
+package metrics_test
+
+import (
+	"github.com/cloudfoundry/gorouter/certexpiry"
+	"github.com/cloudfoundry/gorouter/config"
+	"github.com/cloudfoundry/gorouter/limits"
+	. "github.com/cloudfoundry/gorouter/metrics"
+	"github.com/cloudfoundry/gorouter/registry"
+	"github.com/cloudfoundry/gorouter/route"
+	"github.com/cloudfoundry/yagnats/fakeyagnats"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"time"
+)
+
+var _ = Describe("PrometheusReporter", func() {
+	var r *registry.RouteRegistry
+	var reporter *PrometheusReporter
+	var endpoint *route.Endpoint
+
+	BeforeEach(func() {
+		r = registry.NewRouteRegistry(config.DefaultConfig(), fakeyagnats.Connect())
+		reporter = NewPrometheusReporter(r)
+		endpoint = route.NewEndpoint("app", "192.168.1.1", 1234, "", nil, -1)
+	})
+
+	scrape := func() string {
+		b := &bytes.Buffer{}
+		reporter.WriteTo(b)
+		return b.String()
+	}
+
+	It("counts responses by status class", func() {
+		reporter.CaptureRoutingRequest(endpoint, &http.Request{})
+		reporter.CaptureRoutingResponse(endpoint, &http.Response{StatusCode: 200}, time.Now(), time.Millisecond)
+		reporter.CaptureRoutingResponse(endpoint, &http.Response{StatusCode: 503}, time.Now(), time.Millisecond)
+		reporter.CaptureRoutingResponse(endpoint, nil, time.Now(), time.Millisecond)
+
+		out := scrape()
+		Ω(out).To(ContainSubstring("gorouter_requests_total 1\n"))
+		Ω(out).To(ContainSubstring(`gorouter_responses_total{status_class="2xx"} 1` + "\n"))
+		Ω(out).To(ContainSubstring(`gorouter_responses_total{status_class="5xx"} 1` + "\n"))
+		Ω(out).To(ContainSubstring(`gorouter_responses_total{status_class="xxx"} 1` + "\n"))
+		Ω(out).To(ContainSubstring(`gorouter_responses_total{status_class="4xx"} 0` + "\n"))
+	})
+
+	It("records latency in cumulative buckets", func() {
+		reporter.CaptureRoutingResponse(endpoint, &http.Response{StatusCode: 200}, time.Now(), 20*time.Millisecond)
+		reporter.CaptureRoutingResponse(endpoint, &http.Response{StatusCode: 200}, time.Now(), 3*time.Second)
+
+		out := scrape()
+		Ω(out).To(ContainSubstring("# TYPE gorouter_request_duration_seconds histogram\n"))
+		Ω(out).To(ContainSubstring(`gorouter_request_duration_seconds_bucket{le="0.01"} 0` + "\n"))
+		Ω(out).To(ContainSubstring(`gorouter_request_duration_seconds_bucket{le="0.025"} 1` + "\n"))
+		Ω(out).To(ContainSubstring(`gorouter_request_duration_seconds_bucket{le="5"} 2` + "\n"))
+		Ω(out).To(ContainSubstring(`gorouter_request_duration_seconds_bucket{le="+Inf"} 2` + "\n"))
+		Ω(out).To(ContainSubstring("gorouter_request_duration_seconds_count 2\n"))
+	})
+
+	It("reports latency and size quantiles", func() {
+		for i := 1; i <= 100; i++ {
+			reporter.CaptureRoutingRequest(endpoint, &http.Request{ContentLength: int64(i)})
+			reporter.CaptureRoutingResponse(endpoint, &http.Response{StatusCode: 200, ContentLength: 1000}, time.Now(), time.Duration(i)*time.Millisecond)
+		}
+		reporter.CaptureRoutingResponse(endpoint, &http.Response{StatusCode: 200, ContentLength: -1}, time.Now(), time.Millisecond)
+
+		out := scrape()
+		Ω(out).To(ContainSubstring("# TYPE gorouter_request_latency_seconds summary\n"))
+		Ω(out).To(MatchRegexp(`gorouter_request_latency_seconds\{quantile="0.5"\} 0\.05\d*\n`))
+		Ω(out).To(ContainSubstring("gorouter_request_latency_seconds_count 101\n"))
+		Ω(out).To(ContainSubstring(`gorouter_request_size_bytes{quantile="0.99"} 99.5` + "\n"))
+		Ω(out).To(ContainSubstring(`gorouter_response_size_bytes{quantile="0.5"} 1000` + "\n"))
+		Ω(out).To(ContainSubstring("gorouter_response_size_bytes_count 100\n"))
+	})
+
+	It("exports mergeable digests", func() {
+		reporter.CaptureRoutingResponse(endpoint, &http.Response{StatusCode: 200}, time.Now(), time.Second)
+
+		req, _ := http.NewRequest("GET", "/metrics/digests", nil)
+		w := httptest.NewRecorder()
+		reporter.ServeDigests(w, req)
+
+		Ω(w.Code).To(Equal(http.StatusOK))
+		Ω(w.Header().Get("Content-Type")).To(Equal("application/json"))
+
+		var digests map[string]*Digest
+		Ω(json.Unmarshal(w.Body.Bytes(), &digests)).To(Succeed())
+		Ω(digests).To(HaveKey("request_size_bytes"))
+		Ω(digests).To(HaveKey("response_size_bytes"))
+		Ω(digests["request_latency_seconds"].Count()).To(BeNumerically("==", 1))
+		Ω(digests["request_latency_seconds"].Quantile(0.5)).To(BeNumerically("==", 1))
+	})
+
+	It("reports control plane timings", func() {
+		reporter.CaptureRegistryUpdate("register", 200*time.Microsecond)
+		reporter.CaptureRegistryUpdate("unregister", 2*time.Millisecond)
+		reporter.CaptureRoutePruning(30*time.Millisecond, 3)
+		reporter.CaptureRoutePruning(time.Millisecond, 2)
+		reporter.CaptureNatsMessage("router.register", 400*time.Microsecond)
+
+		out := scrape()
+		Ω(out).To(ContainSubstring(`gorouter_registry_update_duration_seconds_bucket{le="0.00025",operation="register"} 1` + "\n"))
+		Ω(out).To(ContainSubstring(`gorouter_registry_update_duration_seconds_bucket{le="0.00025",operation="unregister"} 0` + "\n"))
+		Ω(out).To(ContainSubstring(`gorouter_registry_update_duration_seconds_count{operation="unregister"} 1` + "\n"))
+		Ω(out).To(ContainSubstring("gorouter_prune_cycle_duration_seconds_count 2\n"))
+		Ω(out).To(ContainSubstring("gorouter_pruned_endpoints_total 5\n"))
+		Ω(out).To(ContainSubstring(`gorouter_nats_message_duration_seconds_bucket{le="0.0005",subject="router.register"} 1` + "\n"))
+	})
+
+	It("reports limit violations", func() {
+		l := limits.New("metrics_test", config.LimitConfig{Max: 1, Mode: config.LimitModeWarn})
+		l.Exceeded(2)
+
+		out := scrape()
+		Ω(out).To(ContainSubstring(`gorouter_limit_max{limit="metrics_test",mode="warn"} 1` + "\n"))
+		Ω(out).To(ContainSubstring(`gorouter_limit_violations_total{limit="metrics_test",mode="warn"} 1` + "\n"))
+	})
+
+	It("reports bad requests and bad gateways", func() {
+		reporter.CaptureBadRequest(&http.Request{})
+		reporter.CaptureBadGateway(&http.Request{})
+		reporter.CaptureBadGateway(&http.Request{})
+
+		out := scrape()
+		Ω(out).To(ContainSubstring("gorouter_bad_requests_total 1\n"))
+		Ω(out).To(ContainSubstring("gorouter_bad_gateways_total 2\n"))
+	})
+
+	It("reports rate limited requests", func() {
+		reporter.CaptureRateLimited(endpoint, &http.Request{})
+
+		Ω(scrape()).To(ContainSubstring("gorouter_rate_limited_requests_total 1\n"))
+	})
+
+	It("reports requests of clients that are not allowed", func() {
+		reporter.CaptureClientDenied(&http.Request{})
+
+		Ω(scrape()).To(ContainSubstring("gorouter_client_denied_requests_total 1\n"))
+	})
+
+	It("reports connections backends closed under requests", func() {
+		reporter.CaptureBackendClosedConnection(endpoint, &http.Request{})
+
+		Ω(scrape()).To(ContainSubstring("gorouter_backend_closed_connections_total 1\n"))
+	})
+
+	It("reports the size of the route table", func() {
+		r.Register("foo", endpoint)
+		r.Register("bar", endpoint)
+
+		out := scrape()
+		Ω(out).To(ContainSubstring("gorouter_routes 2\n"))
+		Ω(out).To(ContainSubstring("gorouter_endpoints 1\n"))
+	})
+
+	It("reports the versions of the app of each route", func() {
+		Ω(scrape()).ToNot(ContainSubstring("gorouter_version_endpoints"))
+
+		v1 := route.NewEndpoint("app", "192.168.1.1", 1234, "", nil, -1)
+		v1.AppVersion = "v1"
+		v2 := route.NewEndpoint("app", "192.168.1.2", 1234, "", nil, -1)
+		v2.AppVersion = "v2"
+		r.Register("foo", v1)
+		r.Register("foo", v2)
+		r.SetVersionPolicy("foo", route.VersionPolicy{Pinned: "v2"})
+		r.Lookup("foo").Endpoints("").Next()
+
+		out := scrape()
+		Ω(out).To(ContainSubstring(`gorouter_version_endpoints{route="foo",version="v1"} 1` + "\n"))
+		Ω(out).To(ContainSubstring(`gorouter_version_requests_total{route="foo",version="v2"} 1` + "\n"))
+		Ω(out).To(ContainSubstring(`gorouter_version_traffic_share{route="foo",version="v1"} 0` + "\n"))
+		Ω(out).To(ContainSubstring(`gorouter_version_traffic_share{route="foo",version="v2"} 1` + "\n"))
+	})
+
+	It("reports the requests in flight to each route and endpoint", func() {
+		Ω(scrape()).ToNot(ContainSubstring("gorouter_route_in_flight_requests"))
+
+		r.Register("foo", endpoint)
+		r.Lookup("foo").Endpoints("").Next()
+		r.Lookup("foo").Endpoints("").Next()
+
+		out := scrape()
+		Ω(out).To(ContainSubstring(`gorouter_route_in_flight_requests{route="foo"} 2` + "\n"))
+		Ω(out).To(ContainSubstring(`gorouter_endpoint_in_flight_requests{app="app",endpoint="192.168.1.1:1234",route="foo"} 2` + "\n"))
+	})
+
+	It("reports access log sinks", func() {
+		Ω(scrape()).ToNot(ContainSubstring("gorouter_access_log_dropped_total"))
+
+		reporter.AddAccessLogSink("kafka", fakeAccessLogSink{sent: 10, dropped: 2})
+
+		Ω(scrape()).To(ContainSubstring(`gorouter_access_log_sent_total{sink="kafka"} 10` + "\n"))
+		Ω(scrape()).To(ContainSubstring(`gorouter_access_log_dropped_total{sink="kafka"} 2` + "\n"))
+	})
+
+	It("reports the drain state", func() {
+		Ω(scrape()).To(ContainSubstring("gorouter_draining 0\n"))
+		Ω(scrape()).To(ContainSubstring("gorouter_drain_outstanding_requests 0\n"))
+
+		reporter.SetDrainer(fakeDrainer{draining: true, outstanding: 3})
+		Ω(scrape()).To(ContainSubstring("gorouter_draining 1\n"))
+		Ω(scrape()).To(ContainSubstring("gorouter_drain_outstanding_requests 3\n"))
+	})
+
+	It("reports the days left of the certificates", func() {
+		Ω(scrape()).NotTo(ContainSubstring("gorouter_certificate_expiry_days"))
+
+		reporter.SetCertificates(fakeCertificates{
+			{Kind: certexpiry.KindFrontend, Name: "default", DaysLeft: 12.5},
+			{Kind: certexpiry.KindCA, Name: "loggregator_v2", DaysLeft: -1},
+		})
+		Ω(scrape()).To(ContainSubstring(`gorouter_certificate_expiry_days{kind="frontend",name="default"} 12.5` + "\n"))
+		Ω(scrape()).To(ContainSubstring(`gorouter_certificate_expiry_days{kind="ca",name="loggregator_v2"} -1` + "\n"))
+	})
+
+	It("reports the messages of the sources of routes", func() {
+		Ω(scrape()).NotTo(ContainSubstring("gorouter_registrar_"))
+
+		reporter.SetRegistrars(fakeRegistrars{
+			"nats/cloud_controller": {Registrations: 7, Unregistrations: 2, RateLimited: 3, Rate: 0.15},
+		})
+		Ω(scrape()).To(ContainSubstring(`gorouter_registrar_messages_total{operation="register",source="nats/cloud_controller"} 7` + "\n"))
+		Ω(scrape()).To(ContainSubstring(`gorouter_registrar_messages_total{operation="unregister",source="nats/cloud_controller"} 2` + "\n"))
+		Ω(scrape()).To(ContainSubstring(`gorouter_registrar_rate_limited_total{source="nats/cloud_controller"} 3` + "\n"))
+		Ω(scrape()).To(ContainSubstring(`gorouter_registrar_rate{source="nats/cloud_controller"} 0.15` + "\n"))
+	})
+
+	It("reports the event stream of the routing API", func() {
+		Ω(scrape()).NotTo(ContainSubstring("gorouter_routing_api_"))
+
+		reporter.SetEventStream(fakeEventStream{subscribed: true, events: 42, lag: 1500 * time.Millisecond})
+		Ω(scrape()).To(ContainSubstring("gorouter_routing_api_subscribed 1\n"))
+		Ω(scrape()).To(ContainSubstring("gorouter_routing_api_events_total 42\n"))
+		Ω(scrape()).To(ContainSubstring("gorouter_routing_api_event_lag_seconds 1.5\n"))
+	})
+
+	It("serves the text exposition format", func() {
+		w := httptest.NewRecorder()
+		reporter.ServeHTTP(w, &http.Request{})
+
+		Ω(w.Code).To(Equal(http.StatusOK))
+		Ω(w.Header().Get("Content-Type")).To(Equal(PrometheusContentType))
+		Ω(w.Body.String()).To(ContainSubstring("# HELP gorouter_requests_total"))
+	})
+})
+
+var _ = Describe("CompositeReporter", func() {
+	It("forwards events to every reporter", func() {
+		a := NewPrometheusReporter(nil)
+		b := NewPrometheusReporter(nil)
+
+		CompositeReporter{a, b}.CaptureBadGateway(&http.Request{})
+
+		for _, p := range []*PrometheusReporter{a, b} {
+			out := &bytes.Buffer{}
+			p.WriteTo(out)
+			Ω(out.String()).To(ContainSubstring("gorouter_bad_gateways_total 1\n"))
+		}
+	})
+})
+
+type fakeAccessLogSink struct {
+	sent, dropped uint64
+}
+
+func (f fakeAccessLogSink) Sent() uint64    { return f.sent }
+func (f fakeAccessLogSink) Dropped() uint64 { return f.dropped }
+
+type fakeDrainer struct {
+	draining    bool
+	outstanding int
+}
+
+func (f fakeDrainer) Draining() bool           { return f.draining }
+func (f fakeDrainer) OutstandingRequests() int { return f.outstanding }
+
+type fakeCertificates []certexpiry.Expiry
+
+func (f fakeCertificates) Expiries() []certexpiry.Expiry { return f }
+
+type fakeEventStream struct {
+	subscribed bool
+	events     uint64
+	lag        time.Duration
+}
+
+func (f fakeEventStream) Subscribed() bool        { return f.subscribed }
+func (f fakeEventStream) Events() uint64          { return f.events }
+func (f fakeEventStream) EventLag() time.Duration { return f.lag }
+
+type fakeRegistrars map[string]registry.RegistrarStats
+
+func (f fakeRegistrars) Stats() map[string]registry.RegistrarStats { return f }
@@ -0,0 +1,268 @@
+package mirror
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	steno "github.com/cloudfoundry/gosteno"
+
+	"github.com/cloudfoundry/gorouter/clock"
+	"github.com/cloudfoundry/gorouter/config"
+	"github.com/cloudfoundry/gorouter/route"
+)
+
+// MirroredHostHeader tells the shadow route which route a copy was taken
+// from, since copies carry the shadow's host.
+const MirroredHostHeader = "X-Cf-Mirrored-Host"
+
+var (
+	errShadowNotFound = errors.New("shadow route is not registered")
+	errNoEndpoints    = errors.New("shadow route has no available endpoints")
+)
+
+type Registry interface {
+	Lookup(uri route.Uri) *route.Pool
+}
+
+// Mirror copies requests for the routes operators configured to their
+// shadow routes, without waiting for the shadow or telling the client
+// about it. A nil Mirror copies nothing.
+type Mirror struct {
+	shadows      map[string]shadow
+	registry     Registry
+	transport    http.RoundTripper
+	maxBodyBytes int64
+	timeout      time.Duration
+	inFlight     chan struct{}
+	random       clock.Random
+	logger       *steno.Logger
+}
+
+type shadow struct {
+	host    string
+	percent int
+}
+
+// New returns a mirror for the routes of c, or nil when there are none.
+func New(c config.MirroringConfig, registry Registry) *Mirror {
+	if len(c.Routes) == 0 {
+		return nil
+	}
+
+	m := &Mirror{
+		shadows:  make(map[string]shadow),
+		registry: registry,
+		transport: &http.Transport{
+			DialContext: (&net.Dialer{
+				Timeout: 5 * time.Second,
+			}).DialContext,
+			MaxIdleConnsPerHost: 4,
+		},
+		maxBodyBytes: c.MaxBodyBytes,
+		timeout:      c.Timeout,
+		inFlight:     make(chan struct{}, c.MaxInFlight),
+		random:       clock.NewRandom(),
+		logger:       steno.NewLogger("router.mirror"),
+	}
+	for _, r := range c.Routes {
+		m.shadows[strings.ToLower(r.Route)] = shadow{
+			host:    strings.ToLower(r.Shadow),
+			percent: r.Percent,
+		}
+	}
+
+	return m
+}
+
+// SetRandom has the mirror pick the requests to copy with r.
+func (m *Mirror) SetRandom(r clock.Random) {
+	m.random = r
+}
+
+// Begin takes a copy of request for its route's shadow, or returns nil when
+// the route is not mirrored or the request is not picked. The copy takes
+// the headers the request has now, and its body as the backend reads it.
+func (m *Mirror) Begin(request *http.Request) *Copy {
+	if m == nil {
+		return nil
+	}
+
+	host := hostname(request.Host)
+	s, ok := m.shadows[host]
+	if !ok {
+		return nil
+	}
+	if s.percent < 100 && m.random.Intn(100) >= s.percent {
+		return nil
+	}
+	if request.ContentLength > m.maxBodyBytes {
+		return nil
+	}
+
+	c := &Copy{
+		mirror: m,
+		shadow: s.host,
+		host:   host,
+		method: request.Method,
+		uri:    request.URL.RequestURI(),
+		header: request.Header.Clone(),
+	}
+
+	if request.Body != nil && request.Body != http.NoBody {
+		c.body = &teeBody{ReadCloser: request.Body, max: m.maxBodyBytes, length: request.ContentLength}
+		request.Body = c.body
+	}
+
+	return c
+}
+
+// A Copy is a request on its way to a shadow route.
+type Copy struct {
+	mirror *Mirror
+	shadow string
+	host   string
+	method string
+	uri    string
+	header http.Header
+	body   *teeBody
+}
+
+// Send sends the copy to the shadow in the background. It is called once
+// the request has been answered. Copies of requests whose bodies were not
+// read to the end, or were too large, are dropped, as are copies that would
+// have more than the configured number in flight.
+func (c *Copy) Send() {
+	if c == nil {
+		return
+	}
+
+	var body []byte
+	if c.body != nil {
+		var ok bool
+		body, ok = c.body.bytes()
+		if !ok {
+			return
+		}
+	}
+
+	select {
+	case c.mirror.inFlight <- struct{}{}:
+	default:
+		c.mirror.logger.Debugd(map[string]interface{}{
+			"host":   c.host,
+			"shadow": c.shadow,
+		}, "mirror.dropped")
+		return
+	}
+
+	go func() {
+		defer func() { <-c.mirror.inFlight }()
+
+		err := c.mirror.send(c, body)
+		if err != nil {
+			c.mirror.logger.Infod(map[string]interface{}{
+				"host":   c.host,
+				"shadow": c.shadow,
+				"error":  err.Error(),
+			}, "mirror.failed")
+		}
+	}()
+}
+
+func (m *Mirror) send(c *Copy, body []byte) error {
+	pool := m.registry.Lookup(route.Uri(c.shadow))
+	if pool == nil {
+		return errShadowNotFound
+	}
+
+	iter := pool.Endpoints("")
+	endpoint := iter.Next()
+	if endpoint == nil {
+		return errNoEndpoints
+	}
+	defer iter.Done()
+
+	ctx, cancel := context.WithTimeout(context.Background(), m.timeout)
+	defer cancel()
+
+	request, err := http.NewRequest(c.method, "http://"+endpoint.CanonicalAddr()+c.uri, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request = request.WithContext(ctx)
+	request.Header = c.header
+	request.Header.Set(MirroredHostHeader, c.host)
+	request.Host = c.shadow
+
+	res, err := m.transport.RoundTrip(request)
+	if err != nil {
+		return err
+	}
+
+	io.Copy(ioutil.Discard, res.Body)
+	res.Body.Close()
+	return nil
+}
+
+// teeBody keeps what the backend reads of a request body, up to max bytes.
+// The transport may still be reading it when the request is answered.
+type teeBody struct {
+	io.ReadCloser
+	max    int64
+	length int64
+
+	mu        sync.Mutex
+	buf       bytes.Buffer
+	complete  bool
+	truncated bool
+}
+
+func (t *teeBody) Read(p []byte) (int, error) {
+	n, err := t.ReadCloser.Read(p)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if !t.truncated {
+		if int64(t.buf.Len()+n) > t.max {
+			t.truncated = true
+			t.buf.Reset()
+		} else {
+			t.buf.Write(p[:n])
+		}
+	}
+	if err == io.EOF {
+		t.complete = true
+	}
+	return n, err
+}
+
+// bytes returns the body, and false when it was not read to the end or was
+// too large to keep.
+func (t *teeBody) bytes() ([]byte, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.length >= 0 && int64(t.buf.Len()) == t.length {
+		t.complete = true
+	}
+	if !t.complete || t.truncated {
+		return nil, false
+	}
+	return append([]byte(nil), t.buf.Bytes()...), true
+}
+
+func hostname(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.ToLower(host)
+}
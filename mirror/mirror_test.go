@@ -0,0 +1,155 @@
+package mirror_test
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cloudfoundry/gorouter/config"
+	"github.com/cloudfoundry/gorouter/mirror"
+	"github.com/cloudfoundry/gorouter/route"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type fakeRegistry map[route.Uri]*route.Pool
+
+func (r fakeRegistry) Lookup(uri route.Uri) *route.Pool {
+	return r[uri]
+}
+
+type fixedRandom int
+
+func (r fixedRandom) Intn(n int) int {
+	return int(r)
+}
+
+type mirrored struct {
+	request *http.Request
+	body    string
+}
+
+var _ = Describe("Mirror", func() {
+	var (
+		shadow   *httptest.Server
+		received chan mirrored
+		registry fakeRegistry
+		c        config.MirroringConfig
+		m        *mirror.Mirror
+	)
+
+	BeforeEach(func() {
+		received = make(chan mirrored, 10)
+		shadow = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := ioutil.ReadAll(r.Body)
+			received <- mirrored{request: r, body: string(body)}
+			w.WriteHeader(http.StatusInternalServerError)
+		}))
+
+		host, port, err := net.SplitHostPort(shadow.Listener.Addr().String())
+		Ω(err).NotTo(HaveOccurred())
+		p, err := strconv.Atoi(port)
+		Ω(err).NotTo(HaveOccurred())
+
+		pool := route.NewPool(time.Minute)
+		pool.Put(route.NewEndpoint("", host, uint16(p), "", nil, -1))
+		registry = fakeRegistry{"v2.example.com": pool}
+
+		c = config.MirroringConfig{
+			Routes: []config.MirrorRouteConfig{
+				{Route: "api.example.com", Shadow: "v2.example.com", Percent: 100},
+			},
+			MaxBodyBytes: 16,
+			MaxInFlight:  10,
+			Timeout:      time.Second,
+		}
+	})
+
+	JustBeforeEach(func() {
+		m = mirror.New(c, registry)
+	})
+
+	AfterEach(func() {
+		shadow.Close()
+	})
+
+	newRequest := func(method, host, body string) *http.Request {
+		request := httptest.NewRequest(method, "http://"+host+"/items?page=2", strings.NewReader(body))
+		request.Header.Set("X-Vcap-Request-Id", "request-id")
+		return request
+	}
+
+	It("copies nothing without routes", func() {
+		m := mirror.New(config.MirroringConfig{}, registry)
+		Ω(m).To(BeNil())
+
+		c := m.Begin(newRequest("GET", "api.example.com", ""))
+		Ω(c).To(BeNil())
+		c.Send()
+	})
+
+	It("sends a copy of the request to the shadow", func() {
+		request := newRequest("POST", "API.example.com:80", "hello")
+		c := m.Begin(request)
+		Ω(c).NotTo(BeNil())
+
+		body, err := ioutil.ReadAll(request.Body)
+		Ω(err).NotTo(HaveOccurred())
+		Ω(string(body)).To(Equal("hello"))
+		c.Send()
+
+		var got mirrored
+		Eventually(received).Should(Receive(&got))
+		Ω(got.request.Method).To(Equal("POST"))
+		Ω(got.request.Host).To(Equal("v2.example.com"))
+		Ω(got.request.RequestURI).To(Equal("/items?page=2"))
+		Ω(got.request.Header.Get("X-Vcap-Request-Id")).To(Equal("request-id"))
+		Ω(got.request.Header.Get(mirror.MirroredHostHeader)).To(Equal("api.example.com"))
+		Ω(got.body).To(Equal("hello"))
+	})
+
+	It("leaves other routes alone", func() {
+		Ω(m.Begin(newRequest("GET", "www.example.com", ""))).To(BeNil())
+	})
+
+	It("drops copies of requests whose body was not read to the end", func() {
+		request := newRequest("POST", "api.example.com", "hello")
+		c := m.Begin(request)
+		request.Body.Read(make([]byte, 2))
+		c.Send()
+
+		Consistently(received, 100*time.Millisecond).ShouldNot(Receive())
+	})
+
+	It("drops copies of requests with bodies over the limit", func() {
+		request := newRequest("POST", "api.example.com", strings.Repeat("x", 17))
+		Ω(m.Begin(request)).To(BeNil())
+
+		request = newRequest("POST", "api.example.com", strings.Repeat("x", 17))
+		request.ContentLength = -1
+		c := m.Begin(request)
+		ioutil.ReadAll(request.Body)
+		c.Send()
+
+		Consistently(received, 100*time.Millisecond).ShouldNot(Receive())
+	})
+
+	Context("with a percentage", func() {
+		BeforeEach(func() {
+			c.Routes[0].Percent = 25
+		})
+
+		It("copies that share of the requests", func() {
+			m.SetRandom(fixedRandom(24))
+			Ω(m.Begin(newRequest("GET", "api.example.com", ""))).NotTo(BeNil())
+
+			m.SetRandom(fixedRandom(25))
+			Ω(m.Begin(newRequest("GET", "api.example.com", ""))).To(BeNil())
+		})
+	})
+})
@@ -0,0 +1,368 @@
+package oauth2proxy
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	steno "github.com/cloudfoundry/gosteno"
+
+	"github.com/cloudfoundry/gorouter/clock"
+	"github.com/cloudfoundry/gorouter/config"
+	"github.com/cloudfoundry/gorouter/ipfilter"
+)
+
+const (
+	CallbackPath = "/oauth2/callback"
+	SignOutPath  = "/oauth2/sign_out"
+
+	UserHeader        = "X-Forwarded-User"
+	EmailHeader       = "X-Forwarded-Email"
+	AccessTokenHeader = "X-Forwarded-Access-Token"
+
+	stateLifetime = 10 * time.Minute
+)
+
+// Authenticator logs users in with the OAuth2 provider of the domain they
+// ask for, before the router passes their requests on. Requests to other
+// domains pass untouched. A nil authenticator protects nothing.
+type Authenticator struct {
+	domains []*domain
+	logger  *steno.Logger
+}
+
+type domain struct {
+	config config.OAuth2ProxyConfig
+	sealer *sealer
+	client *http.Client
+	clock  clock.Clock
+	logger *steno.Logger
+
+	stateCookie    string
+	trustedProxies ipfilter.Networks
+}
+
+// NewAuthenticator returns the authenticator of the domains configured by
+// configs, which expires sessions and tokens by the time of clk. The
+// X-Forwarded-Proto header is taken from trustedProxies only.
+func NewAuthenticator(configs []config.OAuth2ProxyConfig, trustedProxies []*net.IPNet, clk clock.Clock) *Authenticator {
+	logger := steno.NewLogger("router.oauth2")
+
+	a := &Authenticator{logger: logger}
+	for _, c := range configs {
+		a.domains = append(a.domains, &domain{
+			config:         c,
+			sealer:         newSealer(c.CookieSecret),
+			client:         &http.Client{Timeout: 10 * time.Second},
+			clock:          clk,
+			logger:         logger,
+			stateCookie:    c.CookieName + "_state",
+			trustedProxies: trustedProxies,
+		})
+	}
+
+	return a
+}
+
+// Authenticate makes sure the user of a request to a protected domain is
+// logged in, and passes on who they are to the backend in the
+// X-Forwarded-User and X-Forwarded-Email headers. When it answers the
+// request itself, to send the user to log in or to finish logging in, it
+// returns the status it answered with and true.
+func (a *Authenticator) Authenticate(w http.ResponseWriter, request *http.Request) (int, bool) {
+	if a == nil {
+		return 0, false
+	}
+
+	d := a.match(request.Host)
+	if d == nil {
+		return 0, false
+	}
+
+	// the identity headers are the router's to set
+	request.Header.Del(UserHeader)
+	request.Header.Del(EmailHeader)
+	request.Header.Del(AccessTokenHeader)
+
+	switch request.URL.Path {
+	case CallbackPath:
+		return d.callback(w, request), true
+	case SignOutPath:
+		d.clearCookie(w, request, d.config.CookieName)
+		return writeStatus(w, http.StatusOK, "Signed out."), true
+	}
+
+	s, ok := d.session(w, request)
+	if !ok {
+		return d.login(w, request), true
+	}
+
+	request.Header.Set(UserHeader, s.User)
+	if s.Email != "" {
+		request.Header.Set(EmailHeader, s.Email)
+	}
+	if d.config.PassAccessToken {
+		request.Header.Set(AccessTokenHeader, s.AccessToken)
+	}
+
+	return 0, false
+}
+
+func (a *Authenticator) match(host string) *domain {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.ToLower(host)
+
+	for _, d := range a.domains {
+		pattern := strings.ToLower(d.config.Domain)
+		if strings.HasPrefix(pattern, "*.") {
+			if strings.HasSuffix(host, pattern[1:]) {
+				return d
+			}
+		} else if host == pattern {
+			return d
+		}
+	}
+
+	return nil
+}
+
+// session returns the session of the request's cookie, refreshing its
+// tokens when they have expired.
+func (d *domain) session(w http.ResponseWriter, request *http.Request) (*session, bool) {
+	cookie, err := request.Cookie(d.config.CookieName)
+	if err != nil {
+		return nil, false
+	}
+
+	var s session
+	err = d.sealer.open(d.config.CookieName, cookie.Value, &s)
+	if err != nil {
+		return nil, false
+	}
+
+	now := d.clock.Now()
+	if now.Sub(time.Unix(s.CreatedAt, 0)) > d.config.SessionLifetime {
+		return nil, false
+	}
+
+	if s.ExpiresAt != 0 && now.Unix() >= s.ExpiresAt {
+		if s.RefreshToken == "" {
+			return nil, false
+		}
+
+		t, err := d.refresh(s.RefreshToken)
+		if err != nil {
+			d.logger.Infod(map[string]interface{}{
+				"domain": d.config.Domain,
+				"error":  err.Error(),
+			}, "oauth2.refresh.failed")
+			return nil, false
+		}
+
+		s.AccessToken = t.AccessToken
+		s.ExpiresAt = expiresAt(t, now)
+		if t.RefreshToken != "" {
+			s.RefreshToken = t.RefreshToken
+		}
+
+		err = d.setCookie(w, request, d.config.CookieName, &s, d.config.SessionLifetime-now.Sub(time.Unix(s.CreatedAt, 0)))
+		if err != nil {
+			return nil, false
+		}
+	}
+
+	return &s, true
+}
+
+// login sends the user to the provider, remembering where they were going.
+// Only GET and HEAD requests can be sent back there afterwards, so other
+// requests are refused.
+func (d *domain) login(w http.ResponseWriter, request *http.Request) int {
+	if request.Method != "GET" && request.Method != "HEAD" {
+		return writeStatus(w, http.StatusUnauthorized, "Log in required.")
+	}
+
+	st := state{
+		Nonce:     randomString(16),
+		Redirect:  request.URL.RequestURI(),
+		ExpiresAt: d.clock.Now().Add(stateLifetime).Unix(),
+	}
+	err := d.setCookie(w, request, d.stateCookie, &st, stateLifetime)
+	if err != nil {
+		return writeStatus(w, http.StatusInternalServerError, "Could not start logging in.")
+	}
+
+	u, err := url.Parse(d.config.AuthorizeUrl)
+	if err != nil {
+		return writeStatus(w, http.StatusInternalServerError, "Could not start logging in.")
+	}
+	q := u.Query()
+	q.Set("response_type", "code")
+	q.Set("client_id", d.config.ClientId)
+	q.Set("redirect_uri", d.redirectURI(request))
+	q.Set("scope", strings.Join(d.config.Scopes, " "))
+	q.Set("state", st.Nonce)
+	u.RawQuery = q.Encode()
+
+	http.Redirect(w, request, u.String(), http.StatusFound)
+	return http.StatusFound
+}
+
+// callback finishes logging in when the provider sends the user back with
+// an authorization code.
+func (d *domain) callback(w http.ResponseWriter, request *http.Request) int {
+	q := request.URL.Query()
+	if e := q.Get("error"); e != "" {
+		return writeStatus(w, http.StatusForbidden, "Log in failed: "+e)
+	}
+
+	cookie, err := request.Cookie(d.stateCookie)
+	if err != nil {
+		return writeStatus(w, http.StatusBadRequest, "Log in expired, try again.")
+	}
+	var st state
+	err = d.sealer.open(d.stateCookie, cookie.Value, &st)
+	if err != nil || st.Nonce != q.Get("state") || d.clock.Now().Unix() > st.ExpiresAt {
+		return writeStatus(w, http.StatusBadRequest, "Log in expired, try again.")
+	}
+	d.clearCookie(w, request, d.stateCookie)
+
+	t, err := d.exchange(q.Get("code"), d.redirectURI(request))
+	if err != nil {
+		d.logger.Warnd(map[string]interface{}{
+			"domain": d.config.Domain,
+			"error":  err.Error(),
+		}, "oauth2.exchange.failed")
+		return writeStatus(w, http.StatusBadGateway, "Log in failed.")
+	}
+
+	id, err := d.identify(t)
+	if err != nil {
+		d.logger.Warnd(map[string]interface{}{
+			"domain": d.config.Domain,
+			"error":  err.Error(),
+		}, "oauth2.identify.failed")
+		return writeStatus(w, http.StatusBadGateway, "Log in failed.")
+	}
+
+	if !d.allowed(id.Email) {
+		return writeStatus(w, http.StatusForbidden, "User is not allowed.")
+	}
+
+	now := d.clock.Now()
+	s := session{
+		User:         id.user(),
+		Email:        id.Email,
+		AccessToken:  t.AccessToken,
+		RefreshToken: t.RefreshToken,
+		ExpiresAt:    expiresAt(t, now),
+		CreatedAt:    now.Unix(),
+	}
+	err = d.setCookie(w, request, d.config.CookieName, &s, d.config.SessionLifetime)
+	if err != nil {
+		return writeStatus(w, http.StatusInternalServerError, "Log in failed.")
+	}
+
+	http.Redirect(w, request, localRedirect(st.Redirect), http.StatusFound)
+	return http.StatusFound
+}
+
+// localRedirect returns redirect when it is a path on the same domain, and
+// "/" otherwise, so that users are not sent on to another site after
+// logging in. Browsers take a backslash for a slash, so "/\evil.com" is
+// not a path on the same domain either.
+func localRedirect(redirect string) string {
+	if !strings.HasPrefix(redirect, "/") || strings.HasPrefix(redirect, "//") {
+		return "/"
+	}
+
+	u, err := url.Parse(redirect)
+	if err != nil || u.Scheme != "" || u.Host != "" || u.Opaque != "" || strings.Contains(u.Path, `\`) {
+		return "/"
+	}
+	return redirect
+}
+
+func (d *domain) allowed(email string) bool {
+	if len(d.config.AllowedEmailDomains) == 0 {
+		return true
+	}
+
+	i := strings.LastIndex(email, "@")
+	if i == -1 {
+		return false
+	}
+	for _, allowed := range d.config.AllowedEmailDomains {
+		if strings.EqualFold(email[i+1:], allowed) {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *domain) setCookie(w http.ResponseWriter, request *http.Request, name string, v interface{}, maxAge time.Duration) error {
+	value, err := d.sealer.seal(name, v)
+	if err != nil {
+		return err
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/",
+		MaxAge:   int(maxAge / time.Second),
+		HttpOnly: true,
+		Secure:   d.scheme(request) == "https",
+		SameSite: http.SameSiteLaxMode,
+	})
+	return nil
+}
+
+func (d *domain) clearCookie(w http.ResponseWriter, request *http.Request, name string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     name,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   d.scheme(request) == "https",
+	})
+}
+
+// scheme is the scheme the client used, which is https when a trusted load
+// balancer in front of the router terminated TLS for it. Other clients
+// could claim https on a plain connection.
+func (d *domain) scheme(request *http.Request) string {
+	if request.TLS != nil {
+		return "https"
+	}
+
+	host, _, err := net.SplitHostPort(request.RemoteAddr)
+	if err != nil {
+		host = request.RemoteAddr
+	}
+	if d.trustedProxies.Contains(net.ParseIP(host)) && strings.EqualFold(request.Header.Get("X-Forwarded-Proto"), "https") {
+		return "https"
+	}
+	return "http"
+}
+
+func (d *domain) redirectURI(request *http.Request) string {
+	return d.scheme(request) + "://" + request.Host + CallbackPath
+}
+
+func writeStatus(w http.ResponseWriter, code int, message string) int {
+	w.Header().Set("Cache-Control", "no-store")
+	switch {
+	case code == http.StatusUnauthorized:
+		w.Header().Set("X-Cf-RouterError", "login_required")
+	case code >= 400:
+		w.Header().Set("X-Cf-RouterError", "login_failed")
+	}
+	http.Error(w, message, code)
+	return code
+}
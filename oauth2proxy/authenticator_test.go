@@ -0,0 +1,371 @@
+package oauth2proxy_test
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/cloudfoundry/gorouter/clock/fakeclock"
+	"github.com/cloudfoundry/gorouter/config"
+	"github.com/cloudfoundry/gorouter/oauth2proxy"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type fakeProvider struct {
+	sync.Mutex
+	*httptest.Server
+
+	forms       []url.Values
+	expiresIn   int64
+	email       string
+	refreshFail bool
+}
+
+func newFakeProvider() *fakeProvider {
+	p := &fakeProvider{expiresIn: 3600, email: "jane@example.com"}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		p.Lock()
+		defer p.Unlock()
+
+		user, password, _ := r.BasicAuth()
+		if user != "client" || password != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		r.ParseForm()
+		p.forms = append(p.forms, r.PostForm)
+		if r.PostForm.Get("grant_type") == "refresh_token" && p.refreshFail {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		claims, _ := json.Marshal(map[string]string{"sub": "jane-id", "email": p.email})
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token":  "access-" + r.PostForm.Get("grant_type"),
+			"refresh_token": "refresh",
+			"id_token":      "e30." + base64.RawURLEncoding.EncodeToString(claims) + ".sig",
+			"expires_in":    p.expiresIn,
+		})
+	})
+	mux.HandleFunc("/userinfo", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer access-authorization_code" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{
+			"sub":                "jane-id",
+			"preferred_username": "jane",
+			"email":              "jane@example.com",
+		})
+	})
+
+	p.Server = httptest.NewServer(mux)
+	return p
+}
+
+var _ = Describe("Authenticator", func() {
+	var provider *fakeProvider
+	var c config.OAuth2ProxyConfig
+	var authenticator *oauth2proxy.Authenticator
+	var trusted []*net.IPNet
+	var clock *fakeclock.FakeClock
+
+	BeforeEach(func() {
+		provider = newFakeProvider()
+		trusted = nil
+		clock = fakeclock.New(time.Now())
+
+		c = config.OAuth2ProxyConfig{
+			Domain:       "dashboard.example.com",
+			ClientId:     "client",
+			ClientSecret: "secret",
+			AuthorizeUrl: provider.URL + "/authorize?prompt=login",
+			TokenUrl:     provider.URL + "/token",
+			Scopes:       []string{"openid", "email"},
+			CookieName:   "_session",
+			CookieSecret: "0123456789abcdef",
+
+			SessionLifetime: time.Hour,
+		}
+	})
+
+	JustBeforeEach(func() {
+		authenticator = oauth2proxy.NewAuthenticator([]config.OAuth2ProxyConfig{c}, trusted, clock)
+	})
+
+	AfterEach(func() {
+		provider.Close()
+	})
+
+	authenticate := func(request *http.Request) (*httptest.ResponseRecorder, bool) {
+		w := httptest.NewRecorder()
+		status, answered := authenticator.Authenticate(w, request)
+		if answered {
+			Ω(status).To(Equal(w.Code))
+		}
+		return w, answered
+	}
+
+	withCookies := func(request *http.Request, w *httptest.ResponseRecorder) *http.Request {
+		for _, cookie := range w.Result().Cookies() {
+			if cookie.MaxAge >= 0 {
+				request.AddCookie(cookie)
+			}
+		}
+		return request
+	}
+
+	// login goes through the log in flow and returns the response that
+	// finished it.
+	login := func() *httptest.ResponseRecorder {
+		w, _ := authenticate(httptest.NewRequest("GET", "http://dashboard.example.com/graphs?range=1h", nil))
+		location, _ := url.Parse(w.Header().Get("Location"))
+
+		callback := httptest.NewRequest("GET", "http://dashboard.example.com/oauth2/callback?code=the-code&state="+location.Query().Get("state"), nil)
+		w, answered := authenticate(withCookies(callback, w))
+		Ω(answered).To(BeTrue())
+		return w
+	}
+
+	It("passes requests to other domains untouched", func() {
+		request := httptest.NewRequest("GET", "http://other.example.com/", nil)
+		request.Header.Set(oauth2proxy.UserHeader, "mallory")
+
+		_, answered := authenticate(request)
+		Ω(answered).To(BeFalse())
+		Ω(request.Header.Get(oauth2proxy.UserHeader)).To(Equal("mallory"))
+	})
+
+	It("protects nothing when nil", func() {
+		var nilAuthenticator *oauth2proxy.Authenticator
+		_, answered := nilAuthenticator.Authenticate(httptest.NewRecorder(), httptest.NewRequest("GET", "http://dashboard.example.com/", nil))
+		Ω(answered).To(BeFalse())
+	})
+
+	It("sends users without a session to the provider", func() {
+		w, answered := authenticate(httptest.NewRequest("GET", "http://dashboard.example.com/graphs", nil))
+		Ω(answered).To(BeTrue())
+		Ω(w.Code).To(Equal(http.StatusFound))
+
+		location, err := url.Parse(w.Header().Get("Location"))
+		Ω(err).ToNot(HaveOccurred())
+		Ω(location.Path).To(Equal("/authorize"))
+
+		q := location.Query()
+		Ω(q.Get("prompt")).To(Equal("login"))
+		Ω(q.Get("response_type")).To(Equal("code"))
+		Ω(q.Get("client_id")).To(Equal("client"))
+		Ω(q.Get("redirect_uri")).To(Equal("http://dashboard.example.com/oauth2/callback"))
+		Ω(q.Get("scope")).To(Equal("openid email"))
+		Ω(q.Get("state")).ToNot(BeEmpty())
+	})
+
+	Context("behind a trusted load balancer that terminated TLS", func() {
+		BeforeEach(func() {
+			_, network, err := net.ParseCIDR("192.0.2.0/24")
+			Ω(err).ToNot(HaveOccurred())
+			trusted = []*net.IPNet{network}
+		})
+
+		It("asks for https callbacks", func() {
+			request := httptest.NewRequest("GET", "http://dashboard.example.com/", nil)
+			request.Header.Set("X-Forwarded-Proto", "https")
+
+			w, _ := authenticate(request)
+			location, _ := url.Parse(w.Header().Get("Location"))
+			Ω(location.Query().Get("redirect_uri")).To(Equal("https://dashboard.example.com/oauth2/callback"))
+			Ω(w.Result().Cookies()[0].Secure).To(BeTrue())
+		})
+
+		It("ignores the scheme other clients claim", func() {
+			request := httptest.NewRequest("GET", "http://dashboard.example.com/", nil)
+			request.RemoteAddr = "198.51.100.7:1234"
+			request.Header.Set("X-Forwarded-Proto", "https")
+
+			w, _ := authenticate(request)
+			location, _ := url.Parse(w.Header().Get("Location"))
+			Ω(location.Query().Get("redirect_uri")).To(Equal("http://dashboard.example.com/oauth2/callback"))
+			Ω(w.Result().Cookies()[0].Secure).To(BeFalse())
+		})
+	})
+
+	It("refuses requests that cannot be sent back after logging in", func() {
+		w, answered := authenticate(httptest.NewRequest("POST", "http://dashboard.example.com/graphs", nil))
+		Ω(answered).To(BeTrue())
+		Ω(w.Code).To(Equal(http.StatusUnauthorized))
+		Ω(w.Header().Get("X-Cf-RouterError")).To(Equal("login_required"))
+	})
+
+	It("finishes logging in and sends the user back where they were going", func() {
+		w := login()
+		Ω(w.Code).To(Equal(http.StatusFound))
+		Ω(w.Header().Get("Location")).To(Equal("/graphs?range=1h"))
+
+		Ω(provider.forms).To(HaveLen(1))
+		Ω(provider.forms[0].Get("code")).To(Equal("the-code"))
+		Ω(provider.forms[0].Get("redirect_uri")).To(Equal("http://dashboard.example.com/oauth2/callback"))
+	})
+
+	It("sends the user back only to paths on the same domain", func() {
+		for _, path := range []string{`/\evil.com`, `/\/evil.com`, "/%5Cevil.com"} {
+			request := httptest.NewRequest("GET", "http://dashboard.example.com/", nil)
+			request.URL.Opaque = path
+			w, _ := authenticate(request)
+			location, _ := url.Parse(w.Header().Get("Location"))
+
+			callback := httptest.NewRequest("GET", "http://dashboard.example.com/oauth2/callback?code=the-code&state="+location.Query().Get("state"), nil)
+			w, _ = authenticate(withCookies(callback, w))
+			Ω(w.Code).To(Equal(http.StatusFound))
+			Ω(w.Header().Get("Location")).To(Equal("/"), path)
+		}
+	})
+
+	It("refuses callbacks with a state it did not hand out", func() {
+		w, _ := authenticate(httptest.NewRequest("GET", "http://dashboard.example.com/", nil))
+
+		callback := httptest.NewRequest("GET", "http://dashboard.example.com/oauth2/callback?code=the-code&state=forged", nil)
+		w, _ = authenticate(withCookies(callback, w))
+		Ω(w.Code).To(Equal(http.StatusBadRequest))
+		Ω(provider.forms).To(BeEmpty())
+	})
+
+	It("passes the identity of logged in users to the backend", func() {
+		w := login()
+
+		request := withCookies(httptest.NewRequest("GET", "http://dashboard.example.com/graphs", nil), w)
+		request.Header.Set(oauth2proxy.UserHeader, "mallory")
+
+		_, answered := authenticate(request)
+		Ω(answered).To(BeFalse())
+		Ω(request.Header.Get(oauth2proxy.UserHeader)).To(Equal("jane-id"))
+		Ω(request.Header.Get(oauth2proxy.EmailHeader)).To(Equal("jane@example.com"))
+		Ω(request.Header.Get(oauth2proxy.AccessTokenHeader)).To(BeEmpty())
+	})
+
+	Context("with a user info endpoint", func() {
+		BeforeEach(func() {
+			c.UserInfoUrl = provider.URL + "/userinfo"
+			c.PassAccessToken = true
+		})
+
+		It("identifies users with it", func() {
+			w := login()
+
+			request := withCookies(httptest.NewRequest("GET", "http://dashboard.example.com/", nil), w)
+			authenticate(request)
+			Ω(request.Header.Get(oauth2proxy.UserHeader)).To(Equal("jane"))
+			Ω(request.Header.Get(oauth2proxy.AccessTokenHeader)).To(Equal("access-authorization_code"))
+		})
+	})
+
+	Context("with allowed email domains", func() {
+		BeforeEach(func() {
+			c.AllowedEmailDomains = []string{"corp.example.com"}
+		})
+
+		It("refuses users from other domains", func() {
+			w := login()
+			Ω(w.Code).To(Equal(http.StatusForbidden))
+			Ω(w.Header().Get("X-Cf-RouterError")).To(Equal("login_failed"))
+		})
+
+		It("lets users from the allowed domains in", func() {
+			provider.email = "jane@corp.example.com"
+			Ω(login().Code).To(Equal(http.StatusFound))
+		})
+	})
+
+	Context("when the access token has expired", func() {
+		It("refreshes it", func() {
+			provider.expiresIn = 60
+			w := login()
+			clock.Increment(time.Minute)
+
+			request := withCookies(httptest.NewRequest("GET", "http://dashboard.example.com/", nil), w)
+			w, answered := authenticate(request)
+			Ω(answered).To(BeFalse())
+			Ω(provider.forms[len(provider.forms)-1].Get("grant_type")).To(Equal("refresh_token"))
+			Ω(w.Result().Cookies()).To(HaveLen(1))
+		})
+
+		It("sends the user to log in again when it cannot be refreshed", func() {
+			provider.expiresIn = 60
+			provider.refreshFail = true
+			w := login()
+			clock.Increment(time.Minute)
+
+			request := withCookies(httptest.NewRequest("GET", "http://dashboard.example.com/", nil), w)
+			w, _ = authenticate(request)
+			Ω(w.Code).To(Equal(http.StatusFound))
+		})
+	})
+
+	It("keeps the access token until it expires", func() {
+		provider.expiresIn = 60
+		w := login()
+		clock.Increment(59 * time.Second)
+
+		request := withCookies(httptest.NewRequest("GET", "http://dashboard.example.com/", nil), w)
+		_, answered := authenticate(request)
+		Ω(answered).To(BeFalse())
+		Ω(provider.forms).To(HaveLen(1))
+	})
+
+	It("sends the user to log in again once the session has expired", func() {
+		w := login()
+		clock.Increment(time.Hour + time.Second)
+
+		request := withCookies(httptest.NewRequest("GET", "http://dashboard.example.com/", nil), w)
+		w, answered := authenticate(request)
+		Ω(answered).To(BeTrue())
+		Ω(w.Code).To(Equal(http.StatusFound))
+	})
+
+	It("refuses callbacks once the log in has expired", func() {
+		w, _ := authenticate(httptest.NewRequest("GET", "http://dashboard.example.com/", nil))
+		location, _ := url.Parse(w.Header().Get("Location"))
+		clock.Increment(11 * time.Minute)
+
+		callback := httptest.NewRequest("GET", "http://dashboard.example.com/oauth2/callback?code=the-code&state="+location.Query().Get("state"), nil)
+		w, _ = authenticate(withCookies(callback, w))
+		Ω(w.Code).To(Equal(http.StatusBadRequest))
+		Ω(provider.forms).To(BeEmpty())
+	})
+
+	It("does not take a tampered session cookie", func() {
+		request := httptest.NewRequest("GET", "http://dashboard.example.com/", nil)
+		request.AddCookie(&http.Cookie{Name: "_session", Value: "dGFtcGVyZWQ"})
+
+		w, _ := authenticate(request)
+		Ω(w.Code).To(Equal(http.StatusFound))
+	})
+
+	It("matches the subdomains of a wildcard domain", func() {
+		c.Domain = "*.example.com"
+		authenticator = oauth2proxy.NewAuthenticator([]config.OAuth2ProxyConfig{c}, trusted, clock)
+
+		_, answered := authenticate(httptest.NewRequest("GET", "http://grafana.example.com:8080/", nil))
+		Ω(answered).To(BeTrue())
+
+		_, answered = authenticate(httptest.NewRequest("GET", "http://example.org/", nil))
+		Ω(answered).To(BeFalse())
+	})
+
+	It("signs users out", func() {
+		w := login()
+
+		request := withCookies(httptest.NewRequest("GET", "http://dashboard.example.com/oauth2/sign_out", nil), w)
+		w, _ = authenticate(request)
+		Ω(w.Code).To(Equal(http.StatusOK))
+		Ω(w.Result().Cookies()[0].MaxAge).To(BeNumerically("<", 0))
+	})
+})
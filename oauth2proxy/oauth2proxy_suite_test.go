@@ -0,0 +1,13 @@
+package oauth2proxy_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestOauth2proxy(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "OAuth2 Proxy Suite")
+}
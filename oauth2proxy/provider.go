@@ -0,0 +1,139 @@
+package oauth2proxy
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+var errNoIdentity = errors.New("provider did not identify the user")
+
+type tokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	IdToken      string `json:"id_token"`
+	ExpiresIn    int64  `json:"expires_in"`
+}
+
+type identity struct {
+	Sub               string `json:"sub"`
+	PreferredUsername string `json:"preferred_username"`
+	Email             string `json:"email"`
+}
+
+func (i identity) user() string {
+	if i.PreferredUsername != "" {
+		return i.PreferredUsername
+	}
+	return i.Sub
+}
+
+// exchange trades an authorization code for tokens.
+func (d *domain) exchange(code, redirectURI string) (*tokenResponse, error) {
+	return d.requestToken(url.Values{
+		"grant_type":   {"authorization_code"},
+		"code":         {code},
+		"redirect_uri": {redirectURI},
+	})
+}
+
+// refresh renews the tokens of a session.
+func (d *domain) refresh(refreshToken string) (*tokenResponse, error) {
+	return d.requestToken(url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {refreshToken},
+	})
+}
+
+func (d *domain) requestToken(form url.Values) (*tokenResponse, error) {
+	request, err := http.NewRequest("POST", d.config.TokenUrl, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Accept", "application/json")
+	request.SetBasicAuth(url.QueryEscape(d.config.ClientId), url.QueryEscape(d.config.ClientSecret))
+
+	var t tokenResponse
+	err = d.do(request, &t)
+	if err != nil {
+		return nil, err
+	}
+	if t.AccessToken == "" {
+		return nil, errors.New("token response has no access token")
+	}
+
+	return &t, nil
+}
+
+// identify finds out who the tokens belong to, from the user info endpoint
+// when there is one, and otherwise from the claims of the ID token. The ID
+// token's signature is not checked; it came straight from the token
+// endpoint over TLS, which OpenID Connect allows to stand in for it.
+func (d *domain) identify(t *tokenResponse) (identity, error) {
+	var id identity
+
+	if d.config.UserInfoUrl != "" {
+		request, err := http.NewRequest("GET", d.config.UserInfoUrl, nil)
+		if err != nil {
+			return id, err
+		}
+		request.Header.Set("Authorization", "Bearer "+t.AccessToken)
+		request.Header.Set("Accept", "application/json")
+
+		err = d.do(request, &id)
+		if err != nil {
+			return id, err
+		}
+	} else {
+		parts := strings.Split(t.IdToken, ".")
+		if len(parts) != 3 {
+			return id, errNoIdentity
+		}
+		claims, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+		if err != nil {
+			return id, err
+		}
+		err = json.Unmarshal(claims, &id)
+		if err != nil {
+			return id, err
+		}
+	}
+
+	if id.user() == "" {
+		return id, errNoIdentity
+	}
+	return id, nil
+}
+
+func (d *domain) do(request *http.Request, v interface{}) error {
+	res, err := d.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	body, err := ioutil.ReadAll(io.LimitReader(res.Body, 1024*1024))
+	if err != nil {
+		return err
+	}
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s responded with %d", request.URL.Host, res.StatusCode)
+	}
+
+	return json.Unmarshal(body, v)
+}
+
+func expiresAt(t *tokenResponse, now time.Time) int64 {
+	if t.ExpiresIn <= 0 {
+		return 0
+	}
+	return now.Add(time.Duration(t.ExpiresIn) * time.Second).Unix()
+}
@@ -0,0 +1,95 @@
+package oauth2proxy
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+)
+
+var errInvalidCookie = errors.New("invalid cookie")
+
+// session is what the session cookie holds. The access token expires at
+// ExpiresAt, and is renewed with the refresh token when there is one.
+type session struct {
+	User         string `json:"u"`
+	Email        string `json:"e,omitempty"`
+	AccessToken  string `json:"a,omitempty"`
+	RefreshToken string `json:"r,omitempty"`
+	ExpiresAt    int64  `json:"x,omitempty"`
+	CreatedAt    int64  `json:"c"`
+}
+
+// state is what the state cookie holds between sending the user to the
+// provider and the provider sending them back.
+type state struct {
+	Nonce     string `json:"n"`
+	Redirect  string `json:"r"`
+	ExpiresAt int64  `json:"x"`
+}
+
+// sealer encrypts and authenticates cookie values. The name of the cookie
+// is authenticated along with the value, so one cookie cannot stand in for
+// another.
+type sealer struct {
+	aead cipher.AEAD
+}
+
+func newSealer(secret string) *sealer {
+	key := sha256.Sum256([]byte(secret))
+
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		panic(err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		panic(err)
+	}
+
+	return &sealer{aead: aead}
+}
+
+func (s *sealer) seal(name string, v interface{}) (string, error) {
+	plaintext, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, s.aead.NonceSize())
+	_, err = io.ReadFull(rand.Reader, nonce)
+	if err != nil {
+		return "", err
+	}
+
+	sealed := s.aead.Seal(nonce, nonce, plaintext, []byte(name))
+	return base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+func (s *sealer) open(name, value string, v interface{}) error {
+	sealed, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil || len(sealed) < s.aead.NonceSize() {
+		return errInvalidCookie
+	}
+
+	nonce, ciphertext := sealed[:s.aead.NonceSize()], sealed[s.aead.NonceSize():]
+	plaintext, err := s.aead.Open(nil, nonce, ciphertext, []byte(name))
+	if err != nil {
+		return errInvalidCookie
+	}
+
+	return json.Unmarshal(plaintext, v)
+}
+
+func randomString(n int) string {
+	b := make([]byte, n)
+	_, err := io.ReadFull(rand.Reader, b)
+	if err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
@@ -0,0 +1,248 @@
+package parsing
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// maxHeadBytes is as much of a request head as a conn looks at, which is
+// as much as the server reads by default. Heads that are larger are passed
+// on as they are, for the server to refuse.
+const maxHeadBytes = 1<<20 + 4096
+
+// rejectedHead takes the place of the head of a request the policy
+// rejects. The server cannot parse it, and answers it with a 400 and closes
+// the connection, once it has answered the requests before it.
+const rejectedHead = "REJECTED\r\n\r\n"
+
+var errHeadTooLarge = errors.New("request head too large")
+
+type listener struct {
+	net.Listener
+	policy *Policy
+}
+
+func (l *listener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &conn{Conn: c, policy: l.policy, r: bufio.NewReader(c)}, nil
+}
+
+// What a conn reads next: a request head, body bytes, the size line of a
+// chunk or the trailer of a chunked body. Once it cannot tell where the
+// next request starts, as after a protocol upgrade, it passes everything
+// on as it is.
+const (
+	readingHead = iota
+	readingBody
+	readingChunkSize
+	readingTrailer
+	passingThrough
+)
+
+// A conn reads requests one after another, following the framing of their
+// bodies to find the start of each head, and applies the policy to each
+// head before it passes it on.
+type conn struct {
+	net.Conn
+	policy *Policy
+	r      *bufio.Reader
+
+	state     int
+	remaining int64
+	afterBody int
+	pending   []byte
+}
+
+func (c *conn) Read(b []byte) (int, error) {
+	for len(c.pending) == 0 {
+		switch c.state {
+		case passingThrough:
+			return c.r.Read(b)
+
+		case readingBody:
+			if int64(len(b)) > c.remaining {
+				b = b[:c.remaining]
+			}
+			n, err := c.r.Read(b)
+			c.remaining -= int64(n)
+			if c.remaining == 0 {
+				c.state = c.afterBody
+			}
+			return n, err
+
+		default:
+			err := c.readFrame()
+			if err != nil && len(c.pending) == 0 {
+				return 0, err
+			}
+		}
+	}
+
+	n := copy(b, c.pending)
+	c.pending = c.pending[n:]
+	return n, nil
+}
+
+// readFrame reads what comes before the next body bytes, and sets it
+// pending.
+func (c *conn) readFrame() error {
+	switch c.state {
+	case readingHead:
+		return c.readHead()
+
+	case readingChunkSize:
+		line, err := c.readLine(nil)
+		c.pending = line
+		if err != nil {
+			c.state = passingThrough
+			return err
+		}
+
+		size, err := chunkSize(line)
+		switch {
+		case err != nil || size < 0:
+			c.state = passingThrough
+		case size == 0:
+			c.state = readingTrailer
+		default:
+			c.body(size+2, readingChunkSize)
+		}
+		return nil
+
+	case readingTrailer:
+		var trailer []byte
+		for {
+			line, err := c.readLine(trailer)
+			trailer = append(trailer, line...)
+			if err != nil {
+				c.pending = trailer
+				c.state = passingThrough
+				return err
+			}
+			if isBlank(line) {
+				c.pending = trailer
+				c.state = readingHead
+				return nil
+			}
+		}
+	}
+	return nil
+}
+
+// readHead reads a request head, up to the empty line that ends it, and
+// sets it pending once the policy has been applied to it, or rejects the
+// request.
+func (c *conn) readHead() error {
+	var head [][]byte
+	size := 0
+	for {
+		line, err := c.readLine(nil)
+		size += len(line)
+		if err != nil || size > maxHeadBytes {
+			c.pending = bytes.Join(append(head, line), nil)
+			c.state = passingThrough
+			return err
+		}
+
+		// the server skips empty lines before the request line
+		if len(head) == 0 && isBlank(line) {
+			c.pending = line
+			return nil
+		}
+
+		head = append(head, line)
+		if isBlank(line) {
+			break
+		}
+	}
+
+	head, rejected := c.policy.apply(head)
+	if rejected {
+		c.pending = []byte(rejectedHead)
+		c.state = passingThrough
+		return nil
+	}
+
+	c.pending = bytes.Join(head, nil)
+	c.frame(head)
+	return nil
+}
+
+// frame sets what comes after head: its body, as the server would find it,
+// or the next head when it has none.
+func (c *conn) frame(head [][]byte) {
+	fields := bytes.Fields(head[0])
+	_, upgrade := headerValue(head, "upgrade")
+	if upgrade || len(fields) > 0 && string(fields[0]) == "CONNECT" {
+		c.state = passingThrough
+		return
+	}
+
+	te, chunked := headerValue(head, "transfer-encoding")
+	cl, sized := headerValue(head, "content-length")
+	switch {
+	case chunked && sized:
+		c.state = passingThrough
+	case chunked:
+		if strings.ToLower(te) == "chunked" {
+			c.state = readingChunkSize
+		} else {
+			c.state = passingThrough
+		}
+	case sized:
+		n, err := strconv.ParseInt(cl, 10, 64)
+		if err != nil || n < 0 {
+			c.state = passingThrough
+			return
+		}
+		c.body(n, readingHead)
+	default:
+		c.state = readingHead
+	}
+}
+
+// body has the next n bytes passed on as they are, and then next read.
+func (c *conn) body(n int64, next int) {
+	if n == 0 {
+		c.state = next
+		return
+	}
+	c.state = readingBody
+	c.remaining = n
+	c.afterBody = next
+}
+
+// readLine reads a line, line ending included, of at most maxHeadBytes
+// counting those of read.
+func (c *conn) readLine(read []byte) ([]byte, error) {
+	var line []byte
+	for {
+		fragment, err := c.r.ReadSlice('\n')
+		line = append(line, fragment...)
+		if len(read)+len(line) > maxHeadBytes {
+			return line, errHeadTooLarge
+		}
+		if err != bufio.ErrBufferFull {
+			return line, err
+		}
+	}
+}
+
+func isBlank(line []byte) bool {
+	return len(bytes.TrimRight(line, "\r\n")) == 0 && len(line) > 0
+}
+
+func chunkSize(line []byte) (int64, error) {
+	s := string(bytes.TrimRight(line, "\r\n"))
+	if i := strings.IndexByte(s, ';'); i >= 0 {
+		s = s[:i]
+	}
+	return strconv.ParseInt(strings.TrimSpace(s), 16, 64)
+}
@@ -0,0 +1,170 @@
+// Package parsing relaxes or tightens how the heads of requests are parsed,
+// for the routes that need it. The HTTP server of the router unfolds header
+// values folded over several lines, and rejects header values with control
+// characters, before the request reaches the router; a Policy looks at each
+// request head as it comes off the connection, before the server does, and
+// rejects the folding or replaces the characters when the route of the
+// request asks for it.
+package parsing
+
+import (
+	"bytes"
+	"net"
+	"net/url"
+
+	"github.com/cloudfoundry/gorouter/config"
+	"github.com/cloudfoundry/gorouter/requestfilter"
+	steno "github.com/cloudfoundry/gosteno"
+)
+
+// A Policy has the modes of parsing of each route. A nil Policy leaves
+// parsing to the server.
+type Policy struct {
+	modes  modes
+	routes []route
+	logger *steno.Logger
+}
+
+type modes struct {
+	folding string
+	invalid string
+}
+
+type route struct {
+	rule  *requestfilter.Rule
+	modes modes
+}
+
+// New returns the policy of c, or nil when it parses like the server does.
+func New(c config.HttpParsingConfig) *Policy {
+	p := &Policy{
+		modes:  modes{folding: c.ObsoleteLineFolding, invalid: c.InvalidHeaderCharacters},
+		logger: steno.NewLogger("router.parsing"),
+	}
+
+	changes := p.modes.rejectsFolding() || p.modes.replacesInvalid()
+	for _, r := range c.Routes {
+		m := modes{folding: r.ObsoleteLineFolding, invalid: r.InvalidHeaderCharacters}
+		p.routes = append(p.routes, route{
+			rule:  requestfilter.NewRule(config.RequestFilterConfig{Routes: r.Routes}),
+			modes: m,
+		})
+		changes = changes || m.rejectsFolding() || m.replacesInvalid()
+	}
+
+	if !changes {
+		return nil
+	}
+	return p
+}
+
+func (m modes) rejectsFolding() bool {
+	return m.folding == config.HttpParsingReject
+}
+
+func (m modes) replacesInvalid() bool {
+	return m.invalid == config.HttpParsingReplace
+}
+
+// Listener returns l, with the requests of the connections it accepts
+// parsed by the policy.
+func (p *Policy) Listener(l net.Listener) net.Listener {
+	if p == nil {
+		return l
+	}
+	return &listener{Listener: l, policy: p}
+}
+
+// modesOf returns the modes of the route of host: those of the first route
+// that matches host and sets a mode, or else those of the policy.
+func (p *Policy) modesOf(host string) modes {
+	var m modes
+	for _, r := range p.routes {
+		if !r.rule.MatchesHost(host) {
+			continue
+		}
+		if m.folding == "" {
+			m.folding = r.modes.folding
+		}
+		if m.invalid == "" {
+			m.invalid = r.modes.invalid
+		}
+	}
+
+	if m.folding == "" {
+		m.folding = p.modes.folding
+	}
+	if m.invalid == "" {
+		m.invalid = p.modes.invalid
+	}
+	return m
+}
+
+// apply applies the modes of the route of a request head to it, which is
+// its request line and header lines, each with its line ending, and the
+// empty line that ends it. It returns the head to pass on, or true when the
+// request is rejected.
+func (p *Policy) apply(head [][]byte) ([][]byte, bool) {
+	host := requestfilter.Hostname(hostOf(head))
+	m := p.modesOf(host)
+
+	for i, line := range head[1:] {
+		folded := len(line) > 0 && (line[0] == ' ' || line[0] == '\t')
+		if folded && m.rejectsFolding() {
+			p.logger.Debugd(map[string]interface{}{"host": host}, "parsing.obsolete-line-folding.rejected")
+			return nil, true
+		}
+
+		if m.replacesInvalid() {
+			start := 0
+			if !folded {
+				start = bytes.IndexByte(line, ':') + 1
+			}
+			head[i+1] = replaceInvalid(line, start)
+		}
+	}
+	return head, false
+}
+
+// replaceInvalid replaces the control characters of line, from start up to
+// its line ending, with spaces.
+func replaceInvalid(line []byte, start int) []byte {
+	end := len(bytes.TrimRight(line, "\r\n"))
+	for i := start; i < end; i++ {
+		if c := line[i]; c < ' ' && c != '\t' || c == 0x7f {
+			line[i] = ' '
+		}
+	}
+	return line
+}
+
+// hostOf returns the host of a request head: the host of its request target
+// when it is in absolute form, or else the value of its Host header.
+func hostOf(head [][]byte) string {
+	fields := bytes.Fields(head[0])
+	if len(fields) > 1 && !bytes.HasPrefix(fields[1], []byte("/")) {
+		if u, err := url.Parse(string(fields[1])); err == nil && u.Host != "" {
+			return u.Host
+		}
+	}
+
+	if v, ok := headerValue(head, "host"); ok {
+		return v
+	}
+	return ""
+}
+
+// headerValue returns the first value of the header of name, which is in
+// lower case, in a request head.
+func headerValue(head [][]byte, name string) (string, bool) {
+	for _, line := range head[1:] {
+		i := bytes.IndexByte(line, ':')
+		if i < 0 || line[0] == ' ' || line[0] == '\t' {
+			continue
+		}
+		if string(bytes.ToLower(bytes.TrimSpace(line[:i]))) == name {
+			return string(bytes.TrimSpace(line[i+1:])), true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,158 @@
+package parsing_test
+
+import (
+	"bufio"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+
+	"github.com/cloudfoundry/gorouter/config"
+	"github.com/cloudfoundry/gorouter/parsing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Policy", func() {
+	var c config.HttpParsingConfig
+	var server *http.Server
+	var addr string
+
+	BeforeEach(func() {
+		c = config.HttpParsingConfig{
+			ObsoleteLineFolding:     config.HttpParsingReject,
+			InvalidHeaderCharacters: config.HttpParsingReject,
+			Routes: []config.HttpParsingRouteConfig{
+				{
+					Routes:                  []string{"legacy.example.com", "*.old.example.com"},
+					ObsoleteLineFolding:     config.HttpParsingAccept,
+					InvalidHeaderCharacters: config.HttpParsingReplace,
+				},
+			},
+		}
+	})
+
+	JustBeforeEach(func() {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		Ω(err).NotTo(HaveOccurred())
+		addr = l.Addr().String()
+
+		server = &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := ioutil.ReadAll(r.Body)
+			w.Header().Set("X-Legacy", r.Header.Get("X-Legacy"))
+			w.Header().Set("X-Body-Length", strconv.Itoa(len(body)))
+			w.WriteHeader(http.StatusOK)
+		})}
+		go server.Serve(parsing.New(c).Listener(l))
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	// send writes requests on one connection, and reads a response for each
+	send := func(requests ...string) []*http.Response {
+		conn, err := net.Dial("tcp", addr)
+		Ω(err).NotTo(HaveOccurred())
+		defer conn.Close()
+
+		for _, r := range requests {
+			_, err = conn.Write([]byte(r))
+			Ω(err).NotTo(HaveOccurred())
+		}
+
+		var responses []*http.Response
+		br := bufio.NewReader(conn)
+		for range requests {
+			resp, err := http.ReadResponse(br, nil)
+			if err != nil {
+				break
+			}
+			ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			responses = append(responses, resp)
+		}
+		return responses
+	}
+
+	It("is nil when it parses like the server does", func() {
+		Ω(parsing.New(config.HttpParsingConfig{
+			ObsoleteLineFolding:     config.HttpParsingAccept,
+			InvalidHeaderCharacters: config.HttpParsingReject,
+		})).To(BeNil())
+
+		var p *parsing.Policy
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		Ω(err).NotTo(HaveOccurred())
+		defer l.Close()
+		Ω(p.Listener(l)).To(Equal(l))
+	})
+
+	It("rejects obsolete line folding, except for the routes that accept it", func() {
+		responses := send("GET / HTTP/1.1\r\nHost: app.example.com\r\nX-Legacy: a\r\n b\r\n\r\n")
+		Ω(responses).To(HaveLen(1))
+		Ω(responses[0].StatusCode).To(Equal(http.StatusBadRequest))
+
+		responses = send("GET / HTTP/1.1\r\nHost: legacy.example.com\r\nX-Legacy: a\r\n b\r\n\r\n")
+		Ω(responses).To(HaveLen(1))
+		Ω(responses[0].StatusCode).To(Equal(http.StatusOK))
+		Ω(responses[0].Header.Get("X-Legacy")).To(Equal("a b"))
+
+		responses = send("GET http://x.old.example.com/ HTTP/1.1\r\nHost: x.old.example.com\r\nX-Legacy: a\r\n\tb\r\n\r\n")
+		Ω(responses).To(HaveLen(1))
+		Ω(responses[0].StatusCode).To(Equal(http.StatusOK))
+	})
+
+	It("replaces invalid header characters for the routes that ask for it", func() {
+		responses := send("GET / HTTP/1.1\r\nHost: app.example.com\r\nX-Legacy: a\x01b\r\n\r\n")
+		Ω(responses).To(HaveLen(1))
+		Ω(responses[0].StatusCode).To(Equal(http.StatusBadRequest))
+
+		responses = send("GET / HTTP/1.1\r\nHost: Legacy.Example.com:80\r\nX-Legacy: a\x01b\x7fc\r\n\r\n")
+		Ω(responses).To(HaveLen(1))
+		Ω(responses[0].StatusCode).To(Equal(http.StatusOK))
+		Ω(responses[0].Header.Get("X-Legacy")).To(Equal("a b c"))
+	})
+
+	It("follows request bodies to the next request", func() {
+		body := "x\r\n folded?\r\n"
+		responses := send(
+			"POST / HTTP/1.1\r\nHost: app.example.com\r\nContent-Length: 13\r\n\r\n"+body,
+			"POST / HTTP/1.1\r\nHost: app.example.com\r\nTransfer-Encoding: chunked\r\n\r\n3\r\n\r\n \r\n0\r\nX-Trailer: t\r\n\r\n",
+			"GET / HTTP/1.1\r\nHost: legacy.example.com\r\nX-Legacy: a\r\n b\r\n\r\n",
+			"GET / HTTP/1.1\r\nHost: app.example.com\r\nX-Legacy: a\r\n b\r\n\r\n",
+		)
+
+		Ω(responses).To(HaveLen(4))
+		Ω(responses[0].StatusCode).To(Equal(http.StatusOK))
+		Ω(responses[0].Header.Get("X-Body-Length")).To(Equal("13"))
+		Ω(responses[1].StatusCode).To(Equal(http.StatusOK))
+		Ω(responses[1].Header.Get("X-Body-Length")).To(Equal("3"))
+		Ω(responses[2].StatusCode).To(Equal(http.StatusOK))
+		Ω(responses[2].Header.Get("X-Legacy")).To(Equal("a b"))
+		Ω(responses[3].StatusCode).To(Equal(http.StatusBadRequest))
+	})
+
+	Context("when the routes only tighten parsing", func() {
+		BeforeEach(func() {
+			c = config.HttpParsingConfig{
+				ObsoleteLineFolding:     config.HttpParsingAccept,
+				InvalidHeaderCharacters: config.HttpParsingReject,
+				Routes: []config.HttpParsingRouteConfig{
+					{Routes: []string{"strict.example.com"}, ObsoleteLineFolding: config.HttpParsingReject},
+				},
+			}
+		})
+
+		It("parses other routes like the server does", func() {
+			responses := send("GET / HTTP/1.1\r\nHost: app.example.com\r\nX-Legacy: a\r\n b\r\n\r\n")
+			Ω(responses).To(HaveLen(1))
+			Ω(responses[0].StatusCode).To(Equal(http.StatusOK))
+
+			responses = send("GET / HTTP/1.1\r\nHost: strict.example.com\r\nX-Legacy: a\r\n b\r\n\r\n")
+			Ω(responses).To(HaveLen(1))
+			Ω(responses[0].StatusCode).To(Equal(http.StatusBadRequest))
+		})
+	})
+})
@@ -0,0 +1,133 @@
+package peer
+
+import (
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cloudfoundry/gorouter/config"
+	steno "github.com/cloudfoundry/gosteno"
+)
+
+const (
+	// HopsHeader counts how often a request was forwarded between peers.
+	HopsHeader = "X-Cf-Router-Hops"
+	// ViaHeader lists the names of the routers that forwarded a request.
+	ViaHeader = "X-Cf-Router-Via"
+)
+
+// Forwarder forwards requests for routes the router does not know to a
+// peer router, such as the routers of another region. A nil forwarder
+// forwards nothing.
+type Forwarder struct {
+	name    string
+	maxHops int
+	peer    *url.URL
+	proxy   *httputil.ReverseProxy
+	logger  *steno.Logger
+}
+
+// NewForwarder returns the forwarder configured by c, or nil when no peer
+// is configured. The peer must send the response headers within timeout.
+func NewForwarder(c config.PeerFailoverConfig, timeout time.Duration) *Forwarder {
+	if c.PeerUrl == "" {
+		return nil
+	}
+
+	peer, err := url.Parse(c.PeerUrl)
+	if err != nil {
+		panic(err)
+	}
+
+	f := &Forwarder{
+		name:    c.Name,
+		maxHops: c.MaxHops,
+		peer:    peer,
+		logger:  steno.NewLogger("router.peer"),
+	}
+
+	f.proxy = &httputil.ReverseProxy{
+		Director:      f.direct,
+		Transport:     newTransport(timeout),
+		FlushInterval: 50 * time.Millisecond,
+		ErrorHandler:  f.fail,
+	}
+
+	return f
+}
+
+func newTransport(timeout time.Duration) *http.Transport {
+	return &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout: 5 * time.Second,
+		}).DialContext,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ResponseHeaderTimeout: timeout,
+	}
+}
+
+// CanForward reports whether request may be forwarded: it has not been
+// through this router's region before, and has not used up its hops.
+func (f *Forwarder) CanForward(request *http.Request) bool {
+	if f == nil {
+		return false
+	}
+
+	for _, name := range via(request) {
+		if name == f.name {
+			return false
+		}
+	}
+
+	return hops(request) < f.maxHops
+}
+
+// ServeHTTP forwards request to the peer, keeping its Host so that the
+// peer routes it the same way.
+func (f *Forwarder) ServeHTTP(w http.ResponseWriter, request *http.Request) {
+	f.proxy.ServeHTTP(w, request)
+}
+
+func (f *Forwarder) direct(request *http.Request) {
+	request.URL.Scheme = f.peer.Scheme
+	request.URL.Host = f.peer.Host
+
+	request.Header.Set(HopsHeader, strconv.Itoa(hops(request)+1))
+	request.Header.Set(ViaHeader, strings.Join(append(via(request), f.name), ", "))
+}
+
+func (f *Forwarder) fail(w http.ResponseWriter, request *http.Request, err error) {
+	f.logger.Warnd(map[string]interface{}{
+		"host":  request.Host,
+		"peer":  f.peer.Host,
+		"error": err.Error(),
+	}, "peer.forward.failed")
+
+	w.Header().Set("X-Cf-RouterError", "peer_failure")
+	http.Error(w, "502 Bad Gateway: Peer router failed to handle the request.", http.StatusBadGateway)
+}
+
+func hops(request *http.Request) int {
+	n, err := strconv.Atoi(request.Header.Get(HopsHeader))
+	if err != nil || n < 0 {
+		return 0
+	}
+	return n
+}
+
+func via(request *http.Request) []string {
+	var names []string
+	for _, v := range request.Header[http.CanonicalHeaderKey(ViaHeader)] {
+		for _, name := range strings.Split(v, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				names = append(names, name)
+			}
+		}
+	}
+	return names
+}
@@ -0,0 +1,98 @@
+package peer_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/cloudfoundry/gorouter/config"
+	. "github.com/cloudfoundry/gorouter/peer"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Forwarder", func() {
+	var peerServer *httptest.Server
+	var received *http.Request
+	var forwarder *Forwarder
+
+	BeforeEach(func() {
+		received = nil
+		peerServer = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			received = r
+			w.WriteHeader(http.StatusTeapot)
+		}))
+
+		forwarder = NewForwarder(config.PeerFailoverConfig{
+			Name:    "us-east",
+			PeerUrl: peerServer.URL,
+			MaxHops: 2,
+		}, time.Second)
+	})
+
+	AfterEach(func() {
+		peerServer.Close()
+	})
+
+	request := func() *http.Request {
+		req, err := http.NewRequest("GET", "/some/path?q=1", nil)
+		Ω(err).NotTo(HaveOccurred())
+		req.Host = "app.example.com"
+		return req
+	}
+
+	It("forwards requests to the peer with their host", func() {
+		w := httptest.NewRecorder()
+		forwarder.ServeHTTP(w, request())
+
+		Ω(w.Code).To(Equal(http.StatusTeapot))
+		Ω(received.Host).To(Equal("app.example.com"))
+		Ω(received.URL.RequestURI()).To(Equal("/some/path?q=1"))
+		Ω(received.Header.Get(HopsHeader)).To(Equal("1"))
+		Ω(received.Header.Get(ViaHeader)).To(Equal("us-east"))
+	})
+
+	It("adds itself to the routers a request went through", func() {
+		req := request()
+		req.Header.Set(HopsHeader, "1")
+		req.Header.Set(ViaHeader, "eu-west")
+
+		forwarder.ServeHTTP(httptest.NewRecorder(), req)
+
+		Ω(received.Header.Get(HopsHeader)).To(Equal("2"))
+		Ω(received.Header.Get(ViaHeader)).To(Equal("eu-west, us-east"))
+	})
+
+	It("does not forward requests that went through its region", func() {
+		req := request()
+		req.Header.Set(ViaHeader, "eu-west, us-east")
+
+		Ω(forwarder.CanForward(req)).To(BeFalse())
+	})
+
+	It("does not forward requests that used up their hops", func() {
+		req := request()
+		Ω(forwarder.CanForward(req)).To(BeTrue())
+
+		req.Header.Set(HopsHeader, "2")
+		Ω(forwarder.CanForward(req)).To(BeFalse())
+	})
+
+	It("answers with a bad gateway when the peer cannot be reached", func() {
+		peerServer.Close()
+
+		w := httptest.NewRecorder()
+		forwarder.ServeHTTP(w, request())
+
+		Ω(w.Code).To(Equal(http.StatusBadGateway))
+		Ω(w.Header().Get("X-Cf-RouterError")).To(Equal("peer_failure"))
+	})
+
+	It("forwards nothing without a peer", func() {
+		forwarder = NewForwarder(config.PeerFailoverConfig{MaxHops: 1}, time.Second)
+
+		Ω(forwarder).To(BeNil())
+		Ω(forwarder.CanForward(request())).To(BeFalse())
+	})
+})
@@ -0,0 +1,79 @@
+package peer
+
+import (
+	"net/http"
+	"net/http/httputil"
+	"strconv"
+	"time"
+
+	"github.com/cloudfoundry/gorouter/config"
+	steno "github.com/cloudfoundry/gosteno"
+)
+
+// StickyForwarder forwards requests pinned to an instance the router does
+// not know to the router that pinned them, whose address their sticky
+// session cookie carries as a signed hint, so that sessions survive routes
+// moving between routers. A nil forwarder forwards nothing.
+type StickyForwarder struct {
+	self   string
+	proxy  *httputil.ReverseProxy
+	logger *steno.Logger
+}
+
+// NewStickyForwarder returns the forwarder configured by c, or nil when the
+// router has no address for its peers to reach it by. The peer must send
+// the response headers within timeout.
+func NewStickyForwarder(c config.StickySessionsConfig, timeout time.Duration) *StickyForwarder {
+	if c.RouterAddress == "" {
+		return nil
+	}
+
+	f := &StickyForwarder{
+		self:   c.RouterAddress,
+		logger: steno.NewLogger("router.peer.sticky"),
+	}
+
+	f.proxy = &httputil.ReverseProxy{
+		Director:      f.direct,
+		Transport:     newTransport(timeout),
+		FlushInterval: 50 * time.Millisecond,
+		ErrorHandler:  f.fail,
+	}
+
+	return f
+}
+
+// CanForward reports whether request, pinned by a cookie with hint, may be
+// forwarded: hint is another router, and the request was not forwarded
+// between routers before, so that it goes at most one hop.
+func (f *StickyForwarder) CanForward(request *http.Request, hint string) bool {
+	if f == nil || hint == "" || hint == f.self {
+		return false
+	}
+
+	return hops(request) == 0
+}
+
+// Forward forwards request to the router at hint, keeping its Host so that
+// the router routes it the same way.
+func (f *StickyForwarder) Forward(w http.ResponseWriter, request *http.Request, hint string) {
+	request.URL.Host = hint
+	f.proxy.ServeHTTP(w, request)
+}
+
+func (f *StickyForwarder) direct(request *http.Request) {
+	request.URL.Scheme = "http"
+
+	request.Header.Set(HopsHeader, strconv.Itoa(hops(request)+1))
+}
+
+func (f *StickyForwarder) fail(w http.ResponseWriter, request *http.Request, err error) {
+	f.logger.Warnd(map[string]interface{}{
+		"host":   request.Host,
+		"router": request.URL.Host,
+		"error":  err.Error(),
+	}, "peer.sticky.forward.failed")
+
+	w.Header().Set("X-Cf-RouterError", "peer_failure")
+	http.Error(w, "502 Bad Gateway: Peer router failed to handle the request.", http.StatusBadGateway)
+}
@@ -0,0 +1,81 @@
+package peer_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	"github.com/cloudfoundry/gorouter/config"
+	. "github.com/cloudfoundry/gorouter/peer"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("StickyForwarder", func() {
+	var peerServer *httptest.Server
+	var peerAddr string
+	var received *http.Request
+	var forwarder *StickyForwarder
+
+	BeforeEach(func() {
+		received = nil
+		peerServer = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			received = r
+			w.WriteHeader(http.StatusTeapot)
+		}))
+		peerAddr = strings.TrimPrefix(peerServer.URL, "http://")
+
+		forwarder = NewStickyForwarder(config.StickySessionsConfig{
+			RouterAddress: "10.0.16.5:80",
+		}, time.Second)
+	})
+
+	AfterEach(func() {
+		peerServer.Close()
+	})
+
+	request := func() *http.Request {
+		req, err := http.NewRequest("GET", "/some/path?q=1", nil)
+		Ω(err).NotTo(HaveOccurred())
+		req.Host = "app.example.com"
+		return req
+	}
+
+	It("forwards requests to the router of the hint with their host", func() {
+		w := httptest.NewRecorder()
+		forwarder.Forward(w, request(), peerAddr)
+
+		Ω(w.Code).To(Equal(http.StatusTeapot))
+		Ω(received.Host).To(Equal("app.example.com"))
+		Ω(received.URL.RequestURI()).To(Equal("/some/path?q=1"))
+		Ω(received.Header.Get(HopsHeader)).To(Equal("1"))
+	})
+
+	It("forwards only to other routers, and only once", func() {
+		Ω(forwarder.CanForward(request(), peerAddr)).To(BeTrue())
+		Ω(forwarder.CanForward(request(), "")).To(BeFalse())
+		Ω(forwarder.CanForward(request(), "10.0.16.5:80")).To(BeFalse())
+
+		req := request()
+		req.Header.Set(HopsHeader, "1")
+		Ω(forwarder.CanForward(req, peerAddr)).To(BeFalse())
+	})
+
+	It("answers 502 when the router of the hint fails", func() {
+		peerServer.Close()
+
+		w := httptest.NewRecorder()
+		forwarder.Forward(w, request(), peerAddr)
+
+		Ω(w.Code).To(Equal(http.StatusBadGateway))
+		Ω(w.Header().Get("X-Cf-RouterError")).To(Equal("peer_failure"))
+	})
+
+	It("is nil without a router address", func() {
+		forwarder = NewStickyForwarder(config.StickySessionsConfig{}, time.Second)
+		Ω(forwarder).To(BeNil())
+		Ω(forwarder.CanForward(request(), peerAddr)).To(BeFalse())
+	})
+})
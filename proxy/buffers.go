@@ -0,0 +1,33 @@
+package proxy
+
+import "sync"
+
+// copyBufferSize is the size of the buffers that responses and the traffic
+// of upgraded connections are copied through, that of io.Copy.
+const copyBufferSize = 32 * 1024
+
+// copyBuffers are shared by all requests, so that streaming a response does
+// not allocate a buffer of its own.
+var copyBuffers = &bufferPool{
+	pool: sync.Pool{New: func() interface{} {
+		b := make([]byte, copyBufferSize)
+		return &b
+	}},
+}
+
+// bufferPool is an httputil.BufferPool of buffers of copyBufferSize bytes.
+type bufferPool struct {
+	pool sync.Pool
+}
+
+func (p *bufferPool) Get() []byte {
+	return *p.pool.Get().(*[]byte)
+}
+
+func (p *bufferPool) Put(b []byte) {
+	if cap(b) < copyBufferSize {
+		return
+	}
+	b = b[:copyBufferSize]
+	p.pool.Put(&b)
+}
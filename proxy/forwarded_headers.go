@@ -0,0 +1,82 @@
+package proxy
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/cloudfoundry/gorouter/ipfilter"
+)
+
+// applyForwardedHeaders drops the X-Forwarded-For and X-Forwarded-Proto
+// headers of a request when the proxy does not trust the client to send
+// them, so that clients cannot pass off another address or scheme as
+// theirs to apps and access logs. X-Forwarded-Proto is then set from the
+// client's connection when the request does not have one.
+func (p *proxy) applyForwardedHeaders(request *http.Request) {
+	if !p.trustsForwardedHeaders(request.RemoteAddr) {
+		request.Header.Del("X-Forwarded-For")
+		request.Header.Del("X-Forwarded-Proto")
+	}
+
+	if strings.TrimSpace(request.Header.Get("X-Forwarded-Proto")) == "" {
+		scheme := "http"
+		if request.TLS != nil {
+			scheme = "https"
+		}
+		request.Header.Set("X-Forwarded-Proto", scheme)
+	}
+}
+
+// clientIP returns the address of the client of a request: that of its
+// connection or, when the connection is from one of the trusted proxies,
+// the nearest address of X-Forwarded-For that is not. It returns nil when
+// the address cannot be told.
+func (p *proxy) clientIP(request *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(request.RemoteAddr)
+	if err != nil {
+		host = request.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+
+	trusted := ipfilter.Networks(p.trustedProxies)
+	if ip == nil || !trusted.Contains(ip) {
+		return ip
+	}
+
+	forwarded := strings.Join(request.Header["X-Forwarded-For"], ",")
+	if forwarded == "" {
+		return ip
+	}
+
+	addrs := strings.Split(forwarded, ",")
+	for i := len(addrs) - 1; i >= 0; i-- {
+		ip = net.ParseIP(strings.TrimSpace(addrs[i]))
+		if ip == nil || !trusted.Contains(ip) {
+			return ip
+		}
+	}
+	return ip
+}
+
+func (p *proxy) trustsForwardedHeaders(remoteAddr string) bool {
+	if p.trustForwardedHeaders {
+		return true
+	}
+
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+
+	for _, network := range p.trustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,103 @@
+package proxy_test
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cloudfoundry/dropsonde"
+	"github.com/cloudfoundry/dropsonde/emitter/fake"
+	"github.com/cloudfoundry/gorouter/access_log"
+	"github.com/cloudfoundry/gorouter/config"
+	"github.com/cloudfoundry/gorouter/registry"
+	"github.com/cloudfoundry/gorouter/route"
+	"github.com/cloudfoundry/yagnats/fakeyagnats"
+
+	. "github.com/cloudfoundry/gorouter/proxy"
+)
+
+// FuzzForwardedHeaders sends requests with whatever X-Forwarded-For,
+// X-Forwarded-Proto and Connection headers clients come up with. Clients
+// that are not trusted must never get their headers through to the
+// backend, and trusted proxies must never lose the client's address.
+func FuzzForwardedHeaders(f *testing.F) {
+	f.Add("1.2.3.4", "https", "")
+	f.Add("1.2.3.4, 5.6.7.8", "http", "X-Forwarded-For")
+	f.Add("", "", "keep-alive, X-Forwarded-Proto")
+	f.Add("unknown", "ftp", "close")
+
+	dropsonde.InitializeWithEmitter(fake.NewFakeEventEmitter("fake"))
+
+	received := make(chan http.Header, 1)
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- r.Header
+	}))
+	defer backend.Close()
+
+	r := registry.NewRouteRegistry(config.DefaultConfig(), fakeyagnats.Connect())
+	host, port, _ := net.SplitHostPort(backend.Listener.Addr().String())
+	p, _ := strconv.Atoi(port)
+	r.Register("app", route.NewEndpoint("", host, uint16(p), "", nil, -1))
+
+	_, trusted, _ := net.ParseCIDR("10.0.0.0/8")
+	newProxy := func(trustedProxies ...*net.IPNet) http.Handler {
+		return NewProxy(ProxyArgs{
+			EndpointTimeout:  time.Second,
+			Registry:         r,
+			Reporter:         nullVarz{},
+			AccessLogger:     &access_log.NullAccessLogger{},
+			BackendKeepAlive: true,
+			TrustedProxies:   trustedProxies,
+		})
+	}
+	proxy := newProxy(trusted)
+
+	send := func(t *testing.T, remoteAddr, xff, xfp, connection string) (http.Header, bool) {
+		req := httptest.NewRequest("GET", "http://app/", nil)
+		req.RemoteAddr = remoteAddr
+		req.Header.Set("X-Forwarded-For", xff)
+		req.Header.Set("X-Forwarded-Proto", xfp)
+		req.Header.Set("Connection", connection)
+
+		w := httptest.NewRecorder()
+		proxy.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			// the backend was not reached, as with headers that cannot
+			// be sent
+			return nil, false
+		}
+		select {
+		case header := <-received:
+			return header, true
+		case <-time.After(time.Second):
+			t.Fatalf("backend was not reached, though the proxy answered %d", w.Code)
+			return nil, false
+		}
+	}
+
+	f.Fuzz(func(t *testing.T, xff, xfp, connection string) {
+		header, ok := send(t, "192.0.2.1:1234", xff, xfp, connection)
+		if ok {
+			if got := header.Get("X-Forwarded-For"); got != "192.0.2.1" {
+				t.Fatalf("untrusted client got X-Forwarded-For %q through", got)
+			}
+			if got := header.Get("X-Forwarded-Proto"); got != "http" {
+				t.Fatalf("untrusted client got X-Forwarded-Proto %q through", got)
+			}
+		}
+
+		header, ok = send(t, "10.0.0.1:1234", xff, xfp, connection)
+		if ok {
+			if got := header.Get("X-Forwarded-For"); !strings.HasSuffix(got, "10.0.0.1") {
+				t.Fatalf("trusted proxy's address is missing from X-Forwarded-For %q", got)
+			}
+			if header.Get("X-Forwarded-Proto") == "" {
+				t.Fatal("X-Forwarded-Proto is missing")
+			}
+		}
+	})
+}
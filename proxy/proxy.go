@@ -1,17 +1,46 @@
 package proxy
 
 import (
+	"context"
 	"errors"
+	"io"
 	"net"
 	"net/http"
 	"net/http/httputil"
 	"strings"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/cloudfoundry/dropsonde"
 	"github.com/cloudfoundry/gorouter/access_log"
+	"github.com/cloudfoundry/gorouter/analytics"
+	"github.com/cloudfoundry/gorouter/banner"
+	"github.com/cloudfoundry/gorouter/cache"
+	"github.com/cloudfoundry/gorouter/capture"
+	"github.com/cloudfoundry/gorouter/clock"
+	"github.com/cloudfoundry/gorouter/common/correlation"
 	router_http "github.com/cloudfoundry/gorouter/common/http"
+	"github.com/cloudfoundry/gorouter/compression"
+	"github.com/cloudfoundry/gorouter/decisionlog"
+	"github.com/cloudfoundry/gorouter/errorpages"
+	"github.com/cloudfoundry/gorouter/headerrules"
+	"github.com/cloudfoundry/gorouter/identity"
+	"github.com/cloudfoundry/gorouter/inspection"
+	"github.com/cloudfoundry/gorouter/ipfilter"
+	"github.com/cloudfoundry/gorouter/jwtauth"
+	"github.com/cloudfoundry/gorouter/limits"
+	"github.com/cloudfoundry/gorouter/maintenance"
+	"github.com/cloudfoundry/gorouter/mirror"
+	"github.com/cloudfoundry/gorouter/oauth2proxy"
+	"github.com/cloudfoundry/gorouter/peer"
+	"github.com/cloudfoundry/gorouter/ratelimit"
+	"github.com/cloudfoundry/gorouter/requestfilter"
+	"github.com/cloudfoundry/gorouter/requestqueue"
 	"github.com/cloudfoundry/gorouter/route"
+	"github.com/cloudfoundry/gorouter/signedurl"
+	"github.com/cloudfoundry/gorouter/stickysession"
+	"github.com/cloudfoundry/gorouter/tracing"
 	steno "github.com/cloudfoundry/gosteno"
 )
 
@@ -22,9 +51,13 @@ const (
 )
 
 var noEndpointsAvailable = errors.New("No endpoints available")
+var responseHeaderTooLarge = errors.New("Response header is too large")
+var rateLimited = errors.New("Rate limit exceeded")
+var endpointsAtCapacity = errors.New("All endpoints are at capacity")
 
 type LookupRegistry interface {
 	Lookup(uri route.Uri) *route.Pool
+	LookupRequest(uri route.Uri, request *http.Request) *route.Pool
 }
 
 type AfterRoundTrip func(rsp *http.Response, endpoint *route.Endpoint, err error)
@@ -32,12 +65,16 @@ type AfterRoundTrip func(rsp *http.Response, endpoint *route.Endpoint, err error
 type ProxyReporter interface {
 	CaptureBadRequest(req *http.Request)
 	CaptureBadGateway(req *http.Request)
+	CaptureRateLimited(b *route.Endpoint, req *http.Request)
+	CaptureClientDenied(req *http.Request)
+	CaptureBackendClosedConnection(b *route.Endpoint, req *http.Request)
 	CaptureRoutingRequest(b *route.Endpoint, req *http.Request)
 	CaptureRoutingResponse(b *route.Endpoint, res *http.Response, t time.Time, d time.Duration)
 }
 
 type Proxy interface {
 	ServeHTTP(responseWriter http.ResponseWriter, request *http.Request)
+	Reload(args ReloadArgs)
 }
 
 type ProxyArgs struct {
@@ -48,6 +85,71 @@ type ProxyArgs struct {
 	Reporter        ProxyReporter
 	AccessLogger    access_log.AccessLogger
 	SecureCookies   bool
+	Tracer          *tracing.Tracer
+	EnableZipkin    bool
+
+	// Clock is where the proxy takes the time of requests from; the clock
+	// of the system when it is nil.
+	Clock clock.Clock
+
+	// Connections to backends are kept open between requests when
+	// BackendKeepAlive is set, up to MaxIdleConnsPerBackend of them for
+	// BackendIdleTimeout. MaxConnsPerBackend caps the connections open to a
+	// backend, and requests over the cap wait for one; zero means no cap.
+	BackendKeepAlive       bool
+	MaxIdleConnsPerBackend int
+	MaxConnsPerBackend     int
+	BackendIdleTimeout     time.Duration
+
+	// X-Forwarded-For and X-Forwarded-Proto headers that requests arrive
+	// with are kept when TrustForwardedHeaders is set or the request comes
+	// from one of the TrustedProxies, and dropped otherwise.
+	TrustForwardedHeaders bool
+	TrustedProxies        []*net.IPNet
+
+	// Identity passes on who the client is, as the load balancers in front
+	// of the router established it.
+	Identity *identity.Policy
+
+	// Requests without a sticky session cookie that carry AffinityHeader
+	// are pinned by its value to an endpoint of their route, until no
+	// request carried the value for AffinityTTL.
+	AffinityHeader string
+	AffinityTTL    time.Duration
+
+	RequestHeaderLimit       *limits.Limit
+	RequestBodyLimit         *limits.Limit
+	ResponseHeaderBytesLimit *limits.Limit
+	ResponseHeaderCountLimit *limits.Limit
+	StripResponseHeaders     []string
+
+	Cache      *cache.Cache
+	Inspection *inspection.Stage
+	Capture    *capture.Recorder
+	OAuth2     *oauth2proxy.Authenticator
+	RateLimit  *ratelimit.Limiter
+
+	ClientRateLimit *ratelimit.ClientLimiter
+	ClientAccess    *ipfilter.Filter
+	SignedUrls      *signedurl.Verifier
+	Jwt             *jwtauth.Validator
+	Peer            *peer.Forwarder
+	Compression     *compression.Compressor
+	HeaderRules     *headerrules.Rules
+	Duplicates      *headerrules.Duplicates
+	ErrorPages      *errorpages.Pages
+	Maintenance     *maintenance.Routes
+	Mirror          *mirror.Mirror
+	RequestQueue    *requestqueue.Queue
+	Banners         *banner.Injector
+	Analytics       *analytics.Emitter
+	Decisions       *decisionlog.Log
+
+	// Requests that MonitoringExclusions match are not access logged, nor
+	// reported with their responses.
+	MonitoringExclusions *requestfilter.Filter
+	StickySessions       *stickysession.Codec
+	StickyForwarder      *peer.StickyForwarder
 }
 
 type proxy struct {
@@ -58,7 +160,45 @@ type proxy struct {
 	reporter      ProxyReporter
 	accessLogger  access_log.AccessLogger
 	transport     *http.Transport
+	clock         clock.Clock
 	secureCookies bool
+	tracer        *tracing.Tracer
+	enableZipkin  bool
+
+	trustForwardedHeaders bool
+	trustedProxies        []*net.IPNet
+	identity              *identity.Policy
+
+	affinityHeader string
+	affinityTTL    time.Duration
+
+	requestHeaderLimit       *limits.Limit
+	requestBodyLimit         *limits.Limit
+	responseHeaderBytesLimit *limits.Limit
+	responseHeaderCountLimit *limits.Limit
+	stripResponseHeaders     []string
+
+	cache      *cache.Cache
+	inspection *inspection.Stage
+	capture    *capture.Recorder
+	oauth2     *oauth2proxy.Authenticator
+	reloadable atomic.Value
+
+	signedUrls   *signedurl.Verifier
+	jwt          *jwtauth.Validator
+	peer         *peer.Forwarder
+	compression  *compression.Compressor
+	errorPages   *errorpages.Pages
+	maintenance  *maintenance.Routes
+	mirror       *mirror.Mirror
+	requestQueue *requestqueue.Queue
+	banners      *banner.Injector
+	analytics    *analytics.Emitter
+	decisions    *decisionlog.Log
+
+	monitoringExclusions *requestfilter.Filter
+	stickySessions       *stickysession.Codec
+	stickyForwarder      *peer.StickyForwarder
 }
 
 func NewProxy(args ProxyArgs) Proxy {
@@ -70,20 +210,65 @@ func NewProxy(args ProxyArgs) Proxy {
 		registry:     args.Registry,
 		reporter:     args.Reporter,
 		transport: &http.Transport{
-			Dial: func(network, addr string) (net.Conn, error) {
-				conn, err := net.DialTimeout(network, addr, 5*time.Second)
-				if err != nil {
-					return conn, err
-				}
-				if args.EndpointTimeout > 0 {
-					err = conn.SetDeadline(time.Now().Add(args.EndpointTimeout))
-				}
-				return conn, err
-			},
-			DisableKeepAlives: true,
+			DialContext:         (&net.Dialer{Timeout: 5 * time.Second}).DialContext,
+			DisableKeepAlives:   !args.BackendKeepAlive,
+			MaxIdleConnsPerHost: args.MaxIdleConnsPerBackend,
+			MaxConnsPerHost:     args.MaxConnsPerBackend,
+			IdleConnTimeout:     args.BackendIdleTimeout,
 		},
+		clock:         args.Clock,
 		secureCookies: args.SecureCookies,
+		tracer:        args.Tracer,
+		enableZipkin:  args.EnableZipkin,
+
+		trustForwardedHeaders: args.TrustForwardedHeaders,
+		trustedProxies:        args.TrustedProxies,
+		identity:              args.Identity,
+
+		affinityHeader: args.AffinityHeader,
+		affinityTTL:    args.AffinityTTL,
+
+		requestHeaderLimit:       args.RequestHeaderLimit,
+		requestBodyLimit:         args.RequestBodyLimit,
+		responseHeaderBytesLimit: args.ResponseHeaderBytesLimit,
+		responseHeaderCountLimit: args.ResponseHeaderCountLimit,
+		stripResponseHeaders:     args.StripResponseHeaders,
+
+		cache:      args.Cache,
+		inspection: args.Inspection,
+		capture:    args.Capture,
+		oauth2:     args.OAuth2,
+
+		signedUrls:   args.SignedUrls,
+		jwt:          args.Jwt,
+		peer:         args.Peer,
+		compression:  args.Compression,
+		errorPages:   args.ErrorPages,
+		maintenance:  args.Maintenance,
+		mirror:       args.Mirror,
+		requestQueue: args.RequestQueue,
+		banners:      args.Banners,
+		analytics:    args.Analytics,
+		decisions:    args.Decisions,
+
+		monitoringExclusions: args.MonitoringExclusions,
+		stickySessions:       args.StickySessions,
+		stickyForwarder:      args.StickyForwarder,
+	}
+
+	if p.clock == nil {
+		p.clock = clock.New()
 	}
+
+	p.Reload(ReloadArgs{
+		EndpointTimeout: args.EndpointTimeout,
+		RateLimit:       args.RateLimit,
+		ClientRateLimit: args.ClientRateLimit,
+		ClientAccess:    args.ClientAccess,
+		HeaderRules:     args.HeaderRules,
+		Duplicates:      args.Duplicates,
+	})
+
 	return p
 }
 
@@ -99,34 +284,66 @@ func hostWithoutPort(req *http.Request) string {
 	return host
 }
 
-func (p *proxy) getStickySession(request *http.Request) string {
+// getStickySession returns the instance id the sticky session cookie of
+// request pins it to, and the address of the router that pinned it.
+func (p *proxy) getStickySession(request *http.Request) (string, string) {
 	// Try choosing a backend using sticky session
 	if _, err := request.Cookie(StickyCookieKey); err == nil {
 		if sticky, err := request.Cookie(VcapCookieId); err == nil {
-			return sticky.Value
+			// forged or outdated cookies are balanced like no cookie
+			if id, hint, ok := p.stickySessions.DecodeHint(sticky.Value); ok {
+				return id, hint
+			}
 		}
 	}
-	return ""
+	return "", ""
 }
 
 func (p *proxy) lookup(request *http.Request) *route.Pool {
 	uri := route.Uri(hostWithoutPort(request))
-	// Choose backend using host alone
-	return p.registry.Lookup(uri)
+	// Choose backend using host, then the headers and cookies endpoints
+	// asked for
+	return p.registry.LookupRequest(uri, request)
 }
 
 func (p *proxy) ServeHTTP(responseWriter http.ResponseWriter, request *http.Request) {
-	startedAt := time.Now()
+	startedAt := p.clock.Now()
+	settings := p.settings()
+
+	removeRequestHopByHopHeaders(request)
+	p.applyForwardedHeaders(request)
+	p.identity.Apply(request)
 
-	accessLog := access_log.AccessLogRecord{
-		Request:   request,
-		StartedAt: startedAt,
+	span := p.startSpan(request)
+	request = p.correlate(request, responseWriter, span)
+
+	if exchange := p.capture.Begin(request); exchange != nil {
+		responseWriter = exchange.ResponseWriter(responseWriter)
+		defer exchange.Finish()
 	}
 
-	handler := NewRequestHandler(request, responseWriter, p.reporter, &accessLog)
+	accessLog := access_log.NewAccessLogRecord(request, startedAt)
+
+	decision := p.decisions.Begin(request, startedAt)
+
+	handler := NewRequestHandler(request, responseWriter, p.reporter, accessLog)
+	handler.span = span
+	handler.clock = p.clock
+	handler.errorPages = p.errorPages
+
+	// health checks and the like would drown out the traffic that matters
+	excluded := p.monitoringExclusions.Matches(request)
 
 	defer func() {
-		p.accessLogger.Log(accessLog)
+		handler.span.SetAttribute("http.status_code", accessLog.StatusCode)
+		handler.span.End()
+
+		if !excluded {
+			p.accessLogger.Log(*accessLog)
+		}
+		p.analytics.Record(request, accessLog.StatusCode)
+		p.decisions.Finish(decision, accessLog.StatusCode, p.clock.Now())
+		accessLog.Release()
 	}()
 
 	if !isProtocolSupported(request) {
@@ -134,19 +351,170 @@ func (p *proxy) ServeHTTP(responseWriter http.ResponseWriter, request *http.Requ
 		return
 	}
 
-	if isLoadBalancerHeartbeat(request) {
+	if IsLoadBalancerHeartbeat(request) {
 		handler.HandleHeartbeat()
 		return
 	}
 
+	if settings.ClientAccess != nil {
+		allowed := settings.ClientAccess.Allows(p.clientIP(request))
+		decision.Check("client_access", allowed)
+		if !allowed {
+			p.reporter.CaptureClientDenied(request)
+			handler.HandleClientDenied()
+			return
+		}
+	}
+
+	if settings.ClientRateLimit != nil {
+		allowed, retryAfter := settings.ClientRateLimit.Allow(p.clientIP(request))
+		decision.Check("client_rate_limit", allowed)
+		if !allowed {
+			handler.HandleClientRateLimited(retryAfter)
+			return
+		}
+	}
+
+	if p.requestHeaderLimit.Exceeded(requestHeaderSize(request)) {
+		decision.Check("request_header_limit", false)
+		handler.HandleRequestHeaderTooLarge()
+		return
+	}
+
+	if name := settings.Duplicates.Normalize(request.Header); name != "" {
+		decision.Check("duplicate_headers", false)
+		handler.HandleDuplicateHeader(name)
+		return
+	}
+
+	// routes under maintenance are answered even when their endpoints are
+	// gone, which they may well be during planned downtime
+	if route, ok := p.maintenance.Lookup(request.Host); ok {
+		decision.Policy("maintenance", decisionlog.Answered)
+		handler.HandleMaintenance(route)
+		return
+	}
+
+	if status, answered := p.oauth2.Authenticate(responseWriter, request); answered {
+		decision.Authorization("oauth2", decisionlog.Answered)
+		accessLog.StatusCode = status
+		return
+	} else if request.Header.Get(oauth2proxy.UserHeader) != "" {
+		decision.Authorization("oauth2", decisionlog.Allowed)
+	}
+
 	routePool := p.lookup(request)
+	if routePool != nil {
+		decision.Route(string(route.Uri(hostWithoutPort(request))), routePool.Match())
+	}
+	stickyEndpointId, stickyHint := p.getStickySession(request)
+
+	// a session pinned to an instance this router does not know, as while
+	// routes move between routers, goes on at the router that pinned it
+	if stickyEndpointId != "" && (routePool == nil || !routePool.Has(stickyEndpointId)) &&
+		p.stickyForwarder.CanForward(request, stickyHint) {
+		decision.Policy("sticky_forwarding", decisionlog.Forwarded)
+		proxyWriter := newProxyResponseWriter(responseWriter)
+		p.stickyForwarder.Forward(proxyWriter, request, stickyHint)
+
+		accessLog.StatusCode = proxyWriter.Status()
+		accessLog.FinishedAt = p.clock.Now()
+		accessLog.BodyBytesSent = int64(proxyWriter.Size())
+		return
+	}
+
+	if routePool == nil && p.peer.CanForward(request) {
+		decision.Policy("peer_failover", decisionlog.Forwarded)
+		proxyWriter := newProxyResponseWriter(responseWriter)
+		p.peer.ServeHTTP(proxyWriter, request)
+
+		accessLog.StatusCode = proxyWriter.Status()
+		accessLog.FinishedAt = p.clock.Now()
+		accessLog.BodyBytesSent = int64(proxyWriter.Size())
+		return
+	}
+
 	if routePool == nil {
 		p.reporter.CaptureBadRequest(request)
 		handler.HandleMissingRoute()
 		return
 	}
 
-	stickyEndpointId := p.getStickySession(request)
+	if filter := routePool.ClientFilter(); filter != nil {
+		allowed := filter.Allows(p.clientIP(request))
+		decision.Authorize("route_client_access", allowed)
+		if !allowed {
+			p.reporter.CaptureClientDenied(request)
+			handler.HandleClientDenied()
+			return
+		}
+	}
+
+	if routePool.RequiresAuthorizationHeader() {
+		authorized := request.Header.Get("Authorization") != ""
+		decision.Authorize("authorization_header", authorized)
+		if !authorized {
+			handler.HandleMissingAuthorization()
+			return
+		}
+	}
+
+	if routePool.RequiresSignedUrls() {
+		err := p.signedUrls.Verify(request)
+		decision.Authorize("signed_url", err == nil)
+		if err != nil {
+			handler.HandleInvalidSignedUrl(err)
+			return
+		}
+	}
+
+	if routePool.RequiresJwt() {
+		err := p.jwt.Validate(request)
+		decision.Authorize("jwt", err == nil)
+		if err != nil {
+			handler.HandleInvalidJwt(err)
+			return
+		}
+	}
+
+	if p.inspection != nil {
+		err := p.inspection.Inspect(request)
+		decision.Check("inspection", err == nil)
+		if err != nil {
+			handler.HandleInspectionFailure(err)
+			return
+		}
+	}
+
+	var body *limitedBody
+	if request.Body != nil {
+		body = newLimitedBody(request.Body, routePool.MaxRequestBodyBytes(), p.requestBodyLimit)
+	}
+	if body != nil {
+		if request.ContentLength > 0 && body.Exceeds(request.ContentLength) {
+			decision.Check("request_body_limit", false)
+			handler.HandleRequestBodyTooLarge()
+			return
+		}
+		request.Body = body
+	}
+
+	headerRules := settings.HeaderRules.Match(request)
+	if headerRules != nil {
+		decision.Policy("header_rules", decisionlog.Applied)
+	}
+	headerRules.Request(request.Header)
+	handler.headerRules = headerRules
+
+	var affinityKey string
+	if p.affinityHeader != "" {
+		affinityKey = request.Header.Get(p.affinityHeader)
+	}
+	pinnedBy := decisionlog.ReasonStickySession
+	if stickyEndpointId == "" && affinityKey != "" {
+		stickyEndpointId = routePool.Affinity(affinityKey)
+		pinnedBy = decisionlog.ReasonAffinity
+	}
 	iter := &wrappedIterator{
 		nested: routePool.Endpoints(stickyEndpointId),
 
@@ -155,9 +523,11 @@ func (p *proxy) ServeHTTP(responseWriter http.ResponseWriter, request *http.Requ
 				handler.logger.Set("RouteEndpoint", endpoint.ToLogData())
 				accessLog.RouteEndpoint = endpoint
 				p.reporter.CaptureRoutingRequest(endpoint, request)
+				decision.Endpoint(endpoint, stickyEndpointId, pinnedBy)
 			}
 		},
 	}
+	defer iter.Done()
 
 	if isTcpUpgrade(request) {
 		handler.HandleTcpRequest(iter)
@@ -169,14 +539,69 @@ func (p *proxy) ServeHTTP(responseWriter http.ResponseWriter, request *http.Requ
 		return
 	}
 
+	if !routePool.CompressionDisabled() {
+		if cw := p.compression.ResponseWriter(responseWriter, request); cw != nil {
+			responseWriter = cw
+			handler.response = cw
+			defer cw.Close()
+		}
+	}
+
+	// banners go into the body before it is compressed
+	if !routePool.BannersDisabled() {
+		if bw := p.banners.ResponseWriter(responseWriter, request); bw != nil {
+			responseWriter = bw
+			handler.response = bw
+			defer bw.Close()
+		}
+	}
+
+	transport := dropsonde.InstrumentedRoundTripper(p.transport)
+	if p.cache != nil {
+		key := cache.Key(request)
+		// endpoints matched by headers or cookies answer the same URIs differently
+		if m := routePool.Match().Key(); m != "" {
+			key += "\n" + m
+		}
+		if entry := p.cache.Fresh(request, key); entry != nil {
+			decision.Policy("cache", decisionlog.Answered)
+			handler.HandleCacheHit(entry)
+			return
+		}
+
+		transport = &cache.Transport{Next: transport, Cache: p.cache, Key: key}
+	}
+
+	// cache hits are answered without waiting for a turn
+	release, err := p.requestQueue.Acquire(request.Context(), request.Host)
+	if p.requestQueue != nil {
+		decision.Check("request_queue", err == nil)
+	}
+	if err != nil {
+		handler.HandleQueueRejected(err)
+		return
+	}
+	defer release()
+
+	// the shadow gets the request as the backend does, once it is answered
+	if c := p.mirror.Begin(request); c != nil {
+		defer c.Send()
+	}
+
 	proxyWriter := newProxyResponseWriter(responseWriter)
 	roundTripper := &proxyRoundTripper{
-		transport: dropsonde.InstrumentedRoundTripper(p.transport),
+		transport: transport,
 		iter:      iter,
 		handler:   &handler,
+		sanitize: func(res *http.Response) error {
+			return p.sanitizeResponse(res, headerRules)
+		},
+		timeout: settings.EndpointTimeout,
+		limiter: settings.RateLimit,
+		body:    body,
 
 		after: func(rsp *http.Response, endpoint *route.Endpoint, err error) {
-			accessLog.FirstByteAt = time.Now()
+			accessLog.FirstByteAt = p.clock.Now()
 			if rsp != nil {
 				accessLog.StatusCode = rsp.StatusCode
 			}
@@ -185,29 +610,106 @@ func (p *proxy) ServeHTTP(responseWriter http.ResponseWriter, request *http.Requ
 				setTraceHeaders(responseWriter, p.ip, endpoint.CanonicalAddr())
 			}
 
-			latency := time.Since(startedAt)
+			latency := p.clock.Since(startedAt)
 
-			p.reporter.CaptureRoutingResponse(endpoint, rsp, startedAt, latency)
+			if !excluded {
+				p.reporter.CaptureRoutingResponse(endpoint, rsp, startedAt, latency)
+			}
 
 			if err != nil {
-				p.reporter.CaptureBadGateway(request)
-				handler.HandleBadGateway(err)
+				if body.TooLarge() {
+					handler.HandleRequestBodyTooLarge()
+				} else if err == responseHeaderTooLarge {
+					p.reporter.CaptureBadGateway(request)
+					handler.HandleResponseHeaderTooLarge()
+				} else {
+					p.reporter.CaptureBadGateway(request)
+					handler.HandleBadGateway(err)
+				}
 				proxyWriter.Done()
 				return
 			}
 
+			// every response renews the pin, so that it lasts while the
+			// key is in use
+			if affinityKey != "" {
+				routePool.SetAffinity(affinityKey, endpoint, p.affinityTTL)
+			}
+
 			if endpoint.PrivateInstanceId != "" {
-				setupStickySession(responseWriter, rsp, endpoint, p.secureCookies)
+				setupStickySession(responseWriter, rsp, endpoint, p.secureCookies, p.stickySessions)
 			}
 		},
 	}
 
 	p.newReverseProxy(roundTripper, request).ServeHTTP(proxyWriter, request)
 
-	accessLog.FinishedAt = time.Now()
+	accessLog.FinishedAt = p.clock.Now()
 	accessLog.BodyBytesSent = int64(proxyWriter.Size())
 }
 
+// sanitizeResponse strips the headers clients must not see from a backend
+// response, applies the header rules of the request to it and checks the
+// response header limits.
+func (p *proxy) sanitizeResponse(res *http.Response, headerRules *headerrules.Match) error {
+	removeHopByHopHeaders(res.Header)
+	for _, h := range p.stripResponseHeaders {
+		res.Header.Del(h)
+	}
+	headerRules.Response(res.Header)
+
+	countExceeded := p.responseHeaderCountLimit.Exceeded(responseHeaderCount(res))
+	bytesExceeded := p.responseHeaderBytesLimit.Exceeded(responseHeaderSize(res))
+	if countExceeded || bytesExceeded {
+		return responseHeaderTooLarge
+	}
+
+	return nil
+}
+
+// correlate assigns the request its correlation id and returns the request
+// carrying it. The id is sent to the backend and back to the client, and is
+// what the access log and the request handler log refer to.
+func (p *proxy) correlate(request *http.Request, responseWriter http.ResponseWriter, span *tracing.Span) *http.Request {
+	id := correlation.ID{
+		RequestId: correlation.NewRequestId(request),
+	}
+
+	if p.enableZipkin {
+		setRequestB3Headers(request)
+		id.TraceId = request.Header.Get(router_http.B3TraceIdHeader)
+	} else if span != nil {
+		id.TraceId = span.Context.TraceId.String()
+	}
+
+	if id.RequestId != "" {
+		request.Header.Set(router_http.VcapRequestIdHeader, id.RequestId)
+		responseWriter.Header().Set(router_http.VcapRequestIdHeader, id.RequestId)
+	}
+
+	return correlation.WithID(request, id)
+}
+
+// startSpan starts the server span of a proxied request, continuing the
+// trace of the incoming traceparent header if there is one.
+func (p *proxy) startSpan(request *http.Request) *tracing.Span {
+	if p.tracer == nil {
+		return nil
+	}
+
+	var parent *tracing.TraceContext
+	if c, ok := tracing.ParseTraceparent(request.Header.Get(tracing.TraceparentHeader)); ok {
+		parent = &c
+	}
+
+	span := p.tracer.StartSpan("proxy "+request.Method, tracing.SpanKindServer, parent)
+	span.SetAttribute("http.method", request.Method)
+	span.SetAttribute("http.host", request.Host)
+	span.SetAttribute("http.target", request.URL.RequestURI())
+
+	return span
+}
+
 func (p *proxy) newReverseProxy(proxyTransport http.RoundTripper, req *http.Request) http.Handler {
 	rproxy := &httputil.ReverseProxy{
 		Director: func(request *http.Request) {
@@ -216,11 +718,11 @@ func (p *proxy) newReverseProxy(proxyTransport http.RoundTripper, req *http.Requ
 			request.URL.Opaque = req.RequestURI
 			request.URL.RawQuery = ""
 
-			setRequestXRequestStart(req)
-			setRequestXVcapRequestId(req, nil)
+			setRequestXRequestStart(request, p.clock.Now())
 		},
 		Transport:     proxyTransport,
 		FlushInterval: 50 * time.Millisecond,
+		BufferPool:    copyBuffers,
 	}
 
 	return rproxy
@@ -231,6 +733,10 @@ type proxyRoundTripper struct {
 	after     AfterRoundTrip
 	iter      route.EndpointIterator
 	handler   *RequestHandler
+	sanitize  func(*http.Response) error
+	timeout   time.Duration
+	limiter   *ratelimit.Limiter
+	body      *limitedBody
 
 	response *http.Response
 	err      error
@@ -241,8 +747,19 @@ func (p *proxyRoundTripper) RoundTrip(request *http.Request) (*http.Response, er
 	var res *http.Response
 	var endpoint *route.Endpoint
 	retry := 0
+	resent, resend := false, false
 	for {
-		endpoint = p.iter.Next()
+		if resend {
+			resend = false
+		} else {
+			endpoint = p.iter.Next()
+		}
+
+		if endpoint == nil && p.iter.AtCapacity() {
+			err = endpointsAtCapacity
+			p.handler.HandleEndpointsAtCapacity()
+			return nil, err
+		}
 
 		if endpoint == nil {
 			p.handler.reporter.CaptureBadGateway(request)
@@ -251,15 +768,71 @@ func (p *proxyRoundTripper) RoundTrip(request *http.Request) (*http.Response, er
 			return nil, err
 		}
 
+		// retries and resends are the same request, which was counted
+		// already
+		if retry == 0 && !resent && endpoint.ApplicationId != "" {
+			if allowed, retryAfter := p.limiter.Allow(endpoint.ApplicationId); !allowed {
+				p.handler.reporter.CaptureRateLimited(endpoint, request)
+				err = rateLimited
+				p.handler.HandleRateLimited(retryAfter)
+				return nil, err
+			}
+		}
+
 		request.URL.Host = endpoint.CanonicalAddr()
 		request.Header.Set("X-CF-ApplicationID", endpoint.ApplicationId)
 		setRequestXCfInstanceId(request, endpoint)
 
-		res, err = p.transport.RoundTrip(request)
+		attempt := p.handler.span.StartChild("backend "+request.Method, tracing.SpanKindClient)
+		if attempt != nil {
+			attempt.SetAttribute("net.peer.name", endpoint.CanonicalAddr())
+			attempt.SetAttribute("retry", retry)
+			request.Header.Set(tracing.TraceparentHeader, attempt.Context.Traceparent())
+		}
+
+		timeout := p.timeout
+		if endpoint.Timeout > 0 {
+			timeout = endpoint.Timeout
+		}
+
+		outreq := request
+		cancel := context.CancelFunc(func() {})
+		if timeout > 0 {
+			// the timeout covers the whole exchange, down to the last byte
+			// of the response body, whether or not the connection is new
+			var ctx context.Context
+			ctx, cancel = context.WithTimeout(request.Context(), timeout)
+			outreq = request.WithContext(ctx)
+		}
+
+		res, err = p.transport.RoundTrip(outreq)
+		if err == nil {
+			res.Body = &cancelBody{ReadCloser: res.Body, cancel: cancel}
+		} else {
+			cancel()
+		}
+
+		attempt.SetError(err)
+		if res != nil {
+			attempt.SetAttribute("http.status_code", res.StatusCode)
+		}
+		attempt.End()
+
 		if err == nil {
 			break
 		}
 
+		// a backend may close a kept-alive connection, or go away, just as
+		// a request is sent on it; the request is sent once more, on a new
+		// connection to the same endpoint, when that is safe
+		if !resent && connectionClosed(err) && request.Context().Err() == nil && canResend(request) {
+			resent, resend = true, true
+			p.handler.reporter.CaptureBackendClosedConnection(endpoint, request)
+			p.handler.Logger().Set("Error", err.Error())
+			p.handler.Logger().Warnf("proxy.endpoint.connection-closed")
+			continue
+		}
+
 		if ne, netErr := err.(*net.OpError); !netErr || ne.Op != "dial" {
 			break
 		}
@@ -275,6 +848,22 @@ func (p *proxyRoundTripper) RoundTrip(request *http.Request) (*http.Response, er
 		}
 	}
 
+	if err == nil {
+		p.iter.EndpointResponded(res.StatusCode < http.StatusInternalServerError)
+	} else if ne, netErr := err.(*net.OpError); (!netErr || ne.Op != "dial") && request.Context().Err() == nil && !p.body.TooLarge() {
+		// dial errors were reported as they happened, and a client going away
+		// or sending too much says nothing about the backend
+		p.iter.EndpointResponded(false)
+	}
+
+	if err == nil && p.sanitize != nil {
+		err = p.sanitize(res)
+		if err != nil {
+			res.Body.Close()
+			res = nil
+		}
+	}
+
 	if p.after != nil {
 		p.after(res, endpoint, err)
 	}
@@ -285,6 +874,19 @@ func (p *proxyRoundTripper) RoundTrip(request *http.Request) (*http.Response, er
 	return res, err
 }
 
+// cancelBody releases the timeout of a backend request once its response
+// body is closed.
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
 type wrappedIterator struct {
 	nested    route.EndpointIterator
 	afterNext func(*route.Endpoint)
@@ -302,12 +904,58 @@ func (i *wrappedIterator) EndpointFailed() {
 	i.nested.EndpointFailed()
 }
 
-func setupStickySession(responseWriter http.ResponseWriter, response *http.Response, endpoint *route.Endpoint, secureCookies bool) {
+func (i *wrappedIterator) EndpointResponded(healthy bool) {
+	i.nested.EndpointResponded(healthy)
+}
+
+func (i *wrappedIterator) Done() {
+	i.nested.Done()
+}
+
+func (i *wrappedIterator) Upgrade() func() {
+	return i.nested.Upgrade()
+}
+
+func (i *wrappedIterator) AtCapacity() bool {
+	return i.nested.AtCapacity()
+}
+
+// connectionClosed tells whether err is the backend closing the connection
+// a request was sent on, or going away, before it responded.
+func connectionClosed(err error) bool {
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE) {
+		return true
+	}
+
+	// net/http does not export these
+	msg := err.Error()
+	return strings.Contains(msg, "server closed idle connection") ||
+		strings.Contains(msg, "server sent GOAWAY")
+}
+
+// canResend tells whether a request may be sent to a backend once more:
+// it has no body, which was consumed by the first attempt, and repeating
+// it does no harm, because its method is idempotent or it carries an
+// idempotency key.
+func canResend(request *http.Request) bool {
+	if request.Body != nil && request.Body != http.NoBody && request.ContentLength != 0 {
+		return false
+	}
+
+	switch request.Method {
+	case "GET", "HEAD", "OPTIONS", "TRACE", "PUT", "DELETE":
+		return true
+	}
+	return request.Header.Get("Idempotency-Key") != "" || request.Header.Get("X-Idempotency-Key") != ""
+}
+
+func setupStickySession(responseWriter http.ResponseWriter, response *http.Response, endpoint *route.Endpoint, secureCookies bool, codec *stickysession.Codec) {
 	for _, v := range response.Cookies() {
 		if v.Name == StickyCookieKey {
 			cookie := &http.Cookie{
 				Name:  VcapCookieId,
-				Value: endpoint.PrivateInstanceId,
+				Value: codec.Encode(endpoint.PrivateInstanceId),
 				Path:  "/",
 
 				HttpOnly: true,
@@ -324,7 +972,9 @@ func isProtocolSupported(request *http.Request) bool {
 	return request.ProtoMajor == 1 && (request.ProtoMinor == 0 || request.ProtoMinor == 1)
 }
 
-func isLoadBalancerHeartbeat(request *http.Request) bool {
+// IsLoadBalancerHeartbeat tells whether request is the health check of a
+// load balancer rather than a request for a route.
+func IsLoadBalancerHeartbeat(request *http.Request) bool {
 	return request.UserAgent() == "HTTP-Monitor/1.1"
 }
 
@@ -1,26 +1,68 @@
 package proxy_test
 
 import (
+	"bytes"
+	"compress/gzip"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"math/big"
 	"net"
 	"net/http"
+	"net/http/httptest"
 	"net/url"
+	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/cloudfoundry/dropsonde"
 	"github.com/cloudfoundry/dropsonde/emitter/fake"
 	"github.com/cloudfoundry/dropsonde/events"
 	"github.com/cloudfoundry/gorouter/access_log"
+	"github.com/cloudfoundry/gorouter/analytics"
+	"github.com/cloudfoundry/gorouter/banner"
+	"github.com/cloudfoundry/gorouter/cache"
+	"github.com/cloudfoundry/gorouter/capture"
+	"github.com/cloudfoundry/gorouter/clock"
+	"github.com/cloudfoundry/gorouter/clock/fakeclock"
+	"github.com/cloudfoundry/gorouter/common/correlation"
 	router_http "github.com/cloudfoundry/gorouter/common/http"
+	"github.com/cloudfoundry/gorouter/compression"
 	"github.com/cloudfoundry/gorouter/config"
+	"github.com/cloudfoundry/gorouter/decisionlog"
+	"github.com/cloudfoundry/gorouter/errorpages"
+	"github.com/cloudfoundry/gorouter/headerrules"
+	"github.com/cloudfoundry/gorouter/identity"
+	"github.com/cloudfoundry/gorouter/inspection"
+	"github.com/cloudfoundry/gorouter/ipfilter"
+	"github.com/cloudfoundry/gorouter/jwtauth"
+	"github.com/cloudfoundry/gorouter/limits"
+	"github.com/cloudfoundry/gorouter/maintenance"
+	"github.com/cloudfoundry/gorouter/mirror"
+	"github.com/cloudfoundry/gorouter/oauth2proxy"
+	"github.com/cloudfoundry/gorouter/peer"
+	"github.com/cloudfoundry/gorouter/ratelimit"
 	"github.com/cloudfoundry/gorouter/registry"
+	"github.com/cloudfoundry/gorouter/requestfilter"
+	"github.com/cloudfoundry/gorouter/requestqueue"
 	"github.com/cloudfoundry/gorouter/route"
+	"github.com/cloudfoundry/gorouter/signedurl"
 	"github.com/cloudfoundry/gorouter/stats"
+	"github.com/cloudfoundry/gorouter/stickysession"
 	"github.com/cloudfoundry/gorouter/test_util"
+	"github.com/cloudfoundry/gorouter/tracing"
+	"github.com/cloudfoundry/gorouter/varz"
 	"github.com/cloudfoundry/yagnats/fakeyagnats"
 
 	. "github.com/cloudfoundry/gorouter/proxy"
@@ -34,14 +76,57 @@ type connHandler func(*test_util.HttpConn)
 
 type nullVarz struct{}
 
-func (_ nullVarz) MarshalJSON() ([]byte, error)                               { return json.Marshal(nil) }
-func (_ nullVarz) ActiveApps() *stats.ActiveApps                              { return stats.NewActiveApps() }
-func (_ nullVarz) CaptureBadRequest(*http.Request)                            {}
-func (_ nullVarz) CaptureBadGateway(*http.Request)                            {}
-func (_ nullVarz) CaptureRoutingRequest(b *route.Endpoint, req *http.Request) {}
+func (_ nullVarz) MarshalJSON() ([]byte, error)                                  { return json.Marshal(nil) }
+func (_ nullVarz) ActiveApps() *stats.ActiveApps                                 { return stats.NewActiveApps() }
+func (_ nullVarz) CaptureBadRequest(*http.Request)                               {}
+func (_ nullVarz) CaptureBadGateway(*http.Request)                               {}
+func (_ nullVarz) CaptureRateLimited(*route.Endpoint, *http.Request)             {}
+func (_ nullVarz) CaptureClientDenied(*http.Request)                             {}
+func (_ nullVarz) CaptureBackendClosedConnection(*route.Endpoint, *http.Request) {}
+func (_ nullVarz) CaptureRoutingRequest(b *route.Endpoint, req *http.Request)    {}
 func (_ nullVarz) CaptureRoutingResponse(b *route.Endpoint, res *http.Response, t time.Time, d time.Duration) {
 }
 
+type recordingExporter struct {
+	sync.Mutex
+	spans []*tracing.Span
+}
+
+func (e *recordingExporter) Export(spans []*tracing.Span) error {
+	e.Lock()
+	defer e.Unlock()
+	e.spans = append(e.spans, spans...)
+	return nil
+}
+
+func (e *recordingExporter) Spans() []*tracing.Span {
+	e.Lock()
+	defer e.Unlock()
+	return e.spans
+}
+
+type fakeInspector struct {
+	sync.Mutex
+	bodies []string
+}
+
+func (f *fakeInspector) Inspect(request *http.Request, body []byte) error {
+	f.Lock()
+	defer f.Unlock()
+
+	if string(body) == "attack" {
+		return errors.New("attack")
+	}
+	f.bodies = append(f.bodies, string(body))
+	return nil
+}
+
+func (f *fakeInspector) Bodies() []string {
+	f.Lock()
+	defer f.Unlock()
+	return f.bodies
+}
+
 var _ = Describe("Proxy", func() {
 	var r *registry.RouteRegistry
 	var p Proxy
@@ -50,8 +135,42 @@ var _ = Describe("Proxy", func() {
 	var accessLog access_log.AccessLogger
 	var accessLogFile *test_util.FakeFile
 	var shouldEcho func(input string, expected string)
+	var tracer *tracing.Tracer
+	var responseCache *cache.Cache
+	var inspectionStage *inspection.Stage
+	var recorder *capture.Recorder
+	var authenticator *oauth2proxy.Authenticator
+	var limiter *ratelimit.Limiter
+	var clientLimiter *ratelimit.ClientLimiter
+	var clientAccess *ipfilter.Filter
+	var jwtValidator *jwtauth.Validator
+	var verifier *signedurl.Verifier
+	var forwarder *peer.Forwarder
+	var proxyClock clock.Clock
+	var compressor *compression.Compressor
+	var headerRules *headerrules.Rules
+	var errorPages *errorpages.Pages
+	var maintenanceRoutes *maintenance.Routes
+	var emitter *analytics.Emitter
+	var decisions *decisionlog.Log
 
 	BeforeEach(func() {
+		tracer = nil
+		responseCache = nil
+		inspectionStage = nil
+		recorder = nil
+		authenticator = nil
+		limiter = nil
+		clientLimiter = nil
+		clientAccess = nil
+		jwtValidator = nil
+		verifier = nil
+		forwarder = nil
+		proxyClock = nil
+		compressor = nil
+		headerRules = nil
+		errorPages = nil
+		maintenanceRoutes = nil
 		conf = config.DefaultConfig()
 		conf.TraceKey = "my_trace_key"
 		conf.EndpointTimeout = 500 * time.Millisecond
@@ -68,6 +187,12 @@ var _ = Describe("Proxy", func() {
 		accessLog = access_log.NewFileAndLoggregatorAccessLogger(accessLogFile, "")
 		go accessLog.Run()
 
+		emitter = analytics.New(conf.Analytics, clock.New())
+
+		var err error
+		decisions, err = decisionlog.New(conf.DecisionLog)
+		Ω(err).NotTo(HaveOccurred())
+
 		p = NewProxy(ProxyArgs{
 			EndpointTimeout: conf.EndpointTimeout,
 			Ip:              conf.Ip,
@@ -76,6 +201,53 @@ var _ = Describe("Proxy", func() {
 			Reporter:        nullVarz{},
 			AccessLogger:    accessLog,
 			SecureCookies:   conf.SecureCookies,
+			Tracer:          tracer,
+			EnableZipkin:    conf.Tracing.EnableZipkin,
+			Clock:           proxyClock,
+
+			BackendKeepAlive:       conf.BackendConnections.KeepAlive,
+			MaxIdleConnsPerBackend: conf.BackendConnections.MaxIdlePerBackend,
+			MaxConnsPerBackend:     conf.BackendConnections.MaxPerBackend,
+			BackendIdleTimeout:     conf.BackendConnections.IdleTimeout,
+
+			TrustForwardedHeaders: conf.ForwardedHeaders.Policy == config.ForwardedHeadersAppend,
+			TrustedProxies:        conf.ForwardedHeaders.TrustedNetworks,
+			Identity:              identity.New(conf.ClientIdentity),
+
+			AffinityHeader: conf.SessionAffinity.Header,
+			AffinityTTL:    conf.SessionAffinity.TTL,
+
+			RequestHeaderLimit:       limits.New("request_header_bytes", conf.Limits.RequestHeaderBytes),
+			RequestBodyLimit:         limits.New("request_body_bytes", conf.Limits.RequestBodyBytes),
+			ResponseHeaderBytesLimit: limits.New("response_header_bytes", conf.Limits.ResponseHeaderBytes),
+			ResponseHeaderCountLimit: limits.New("response_header_count", conf.Limits.ResponseHeaderCount),
+			StripResponseHeaders:     conf.StripResponseHeaders,
+
+			Cache:      responseCache,
+			Inspection: inspectionStage,
+			Capture:    recorder,
+			OAuth2:     authenticator,
+			RateLimit:  limiter,
+
+			ClientRateLimit: clientLimiter,
+			ClientAccess:    clientAccess,
+			SignedUrls:      verifier,
+			Jwt:             jwtValidator,
+			Peer:            forwarder,
+			Compression:     compressor,
+			HeaderRules:     headerRules,
+			Duplicates:      headerrules.NewDuplicates(conf.DuplicateHeaders),
+			ErrorPages:      errorPages,
+			Maintenance:     maintenanceRoutes,
+			Mirror:          mirror.New(conf.Mirroring, r),
+			RequestQueue:    requestqueue.New(conf.RequestQueue, clock.New()),
+			Banners:         banner.New(conf.Banners, clock.New()),
+			Analytics:       emitter,
+			Decisions:       decisions,
+
+			MonitoringExclusions: requestfilter.New(conf.MonitoringExclusions),
+			StickySessions:       stickysession.NewCodec(conf.StickySessions),
+			StickyForwarder:      peer.NewStickyForwarder(conf.StickySessions, time.Second),
 		})
 
 		shouldEcho = func(input string, expected string) {
@@ -164,6 +336,44 @@ var _ = Describe("Proxy", func() {
 		Ω(string(payload)).To(MatchRegexp(".*200.*\n"))
 	})
 
+	Context("with monitoring exclusions", func() {
+		BeforeEach(func() {
+			conf.MonitoringExclusions = []config.RequestFilterConfig{
+				{Paths: []string{"/healthz"}},
+			}
+		})
+
+		It("does not log the requests they match", func() {
+			ln := registerHandler(r, "test", func(x *test_util.HttpConn) {
+				for i := 0; i < 2; i++ {
+					x.ReadRequest()
+					resp := test_util.NewResponse(http.StatusOK)
+					x.WriteResponse(resp)
+				}
+				x.Close()
+			})
+			defer ln.Close()
+
+			x := dialProxy(proxyServer)
+			for _, path := range []string{"/healthz", "/"} {
+				req := x.NewRequest("GET", path, nil)
+				req.Host = "test"
+				x.WriteRequest(req)
+
+				resp, _ := x.ReadResponse()
+				Ω(resp.StatusCode).To(Equal(http.StatusOK))
+			}
+
+			var payload []byte
+			Eventually(func() int {
+				accessLogFile.Read(&payload)
+				return len(payload)
+			}).ShouldNot(BeZero())
+			Ω(string(payload)).To(ContainSubstring("GET / HTTP/1.1"))
+			Ω(string(payload)).NotTo(ContainSubstring("/healthz"))
+		})
+	})
+
 	It("Logs a request when it exits early", func() {
 		x := dialProxy(proxyServer)
 
@@ -258,6 +468,52 @@ var _ = Describe("Proxy", func() {
 		Ω(body).To(Equal("502 Bad Gateway: Registered endpoint failed to handle the request.\n"))
 	})
 
+	It("resends an idempotent request once when the backend closes the connection under it", func() {
+		var conns int32
+		ln := registerHandler(r, "closing", func(x *test_util.HttpConn) {
+			x.CheckLine("GET / HTTP/1.1")
+			if atomic.AddInt32(&conns, 1) == 1 {
+				x.Close()
+				return
+			}
+
+			resp := test_util.NewResponse(http.StatusOK)
+			x.WriteResponse(resp)
+			x.Close()
+		})
+		defer ln.Close()
+
+		x := dialProxy(proxyServer)
+
+		req := x.NewRequest("GET", "/", nil)
+		req.Host = "closing"
+		x.WriteRequest(req)
+
+		resp, _ := x.ReadResponse()
+		Ω(resp.StatusCode).To(Equal(http.StatusOK))
+		Ω(atomic.LoadInt32(&conns)).To(Equal(int32(2)))
+	})
+
+	It("does not resend a request that is not idempotent", func() {
+		var conns int32
+		ln := registerHandler(r, "closing", func(x *test_util.HttpConn) {
+			atomic.AddInt32(&conns, 1)
+			x.CheckLine("POST / HTTP/1.1")
+			x.Close()
+		})
+		defer ln.Close()
+
+		x := dialProxy(proxyServer)
+
+		req := x.NewRequest("POST", "/", nil)
+		req.Host = "closing"
+		x.WriteRequest(req)
+
+		resp, _ := x.ReadResponse()
+		Ω(resp.StatusCode).To(Equal(http.StatusBadGateway))
+		Ω(atomic.LoadInt32(&conns)).To(Equal(int32(1)))
+	})
+
 	It("trace headers added on correct TraceKey", func() {
 		ln := registerHandler(r, "trace-test", func(x *test_util.HttpConn) {
 			_, err := http.ReadRequest(x.Reader)
@@ -376,107 +632,2723 @@ var _ = Describe("Proxy", func() {
 
 			done <- req.Header.Get("X-Request-Start")
 		})
-		defer ln.Close()
+		defer ln.Close()
+
+		x := dialProxy(proxyServer)
+
+		req := x.NewRequest("GET", "/", nil)
+		req.Host = "app"
+		x.WriteRequest(req)
+
+		var answer string
+		Eventually(done).Should(Receive(&answer))
+		Ω(answer).To(MatchRegexp("^\\d{10}\\d{3}$")) // unix timestamp millis
+
+		x.ReadResponse()
+	})
+
+	Context("with a fake clock", func() {
+		BeforeEach(func() {
+			proxyClock = fakeclock.New(time.Unix(1500000000, 0))
+		})
+
+		It("takes X-Request-Start from the clock", func() {
+			done := make(chan string)
+
+			ln := registerHandler(r, "app", func(x *test_util.HttpConn) {
+				req, err := http.ReadRequest(x.Reader)
+				Ω(err).NotTo(HaveOccurred())
+
+				resp := test_util.NewResponse(http.StatusOK)
+				x.WriteResponse(resp)
+				x.Close()
+
+				done <- req.Header.Get("X-Request-Start")
+			})
+			defer ln.Close()
+
+			x := dialProxy(proxyServer)
+
+			req := x.NewRequest("GET", "/", nil)
+			req.Host = "app"
+			x.WriteRequest(req)
+
+			var answer string
+			Eventually(done).Should(Receive(&answer))
+			Ω(answer).To(Equal("1500000000000"))
+
+			x.ReadResponse()
+		})
+	})
+
+	It("X-Request-Start is not overwritten", func() {
+		done := make(chan []string)
+
+		ln := registerHandler(r, "app", func(x *test_util.HttpConn) {
+			req, err := http.ReadRequest(x.Reader)
+			Ω(err).NotTo(HaveOccurred())
+
+			resp := test_util.NewResponse(http.StatusOK)
+			x.WriteResponse(resp)
+			x.Close()
+
+			done <- req.Header[http.CanonicalHeaderKey("X-Request-Start")]
+		})
+		defer ln.Close()
+
+		x := dialProxy(proxyServer)
+
+		req := x.NewRequest("GET", "/", nil)
+		req.Host = "app"
+		req.Header.Add("X-Request-Start", "") // impl cannot just check for empty string
+		req.Header.Add("X-Request-Start", "user-set2")
+		x.WriteRequest(req)
+
+		var answer []string
+		Eventually(done).Should(Receive(&answer))
+		Ω(answer).To(Equal([]string{"", "user-set2"}))
+
+		x.ReadResponse()
+	})
+
+	It("X-VcapRequest-Id header is added", func() {
+		done := make(chan string)
+
+		ln := registerHandler(r, "app", func(x *test_util.HttpConn) {
+			req, err := http.ReadRequest(x.Reader)
+			Ω(err).NotTo(HaveOccurred())
+
+			resp := test_util.NewResponse(http.StatusOK)
+			x.WriteResponse(resp)
+			x.Close()
+
+			done <- req.Header.Get(router_http.VcapRequestIdHeader)
+		})
+		defer ln.Close()
+
+		x := dialProxy(proxyServer)
+
+		req := x.NewRequest("GET", "/", nil)
+		req.Host = "app"
+		x.WriteRequest(req)
+
+		var answer string
+		Eventually(done).Should(Receive(&answer))
+		Ω(answer).To(MatchRegexp(uuid_regex))
+
+		x.ReadResponse()
+	})
+
+	It("X-Vcap-Request-Id header is overwritten", func() {
+		done := make(chan string)
+
+		ln := registerHandler(r, "app", func(x *test_util.HttpConn) {
+			req, err := http.ReadRequest(x.Reader)
+			Ω(err).NotTo(HaveOccurred())
+
+			resp := test_util.NewResponse(http.StatusOK)
+			x.WriteResponse(resp)
+			x.Close()
+
+			done <- req.Header.Get(router_http.VcapRequestIdHeader)
+		})
+		defer ln.Close()
+
+		x := dialProxy(proxyServer)
+
+		req := x.NewRequest("GET", "/", nil)
+		req.Host = "app"
+		req.Header.Add(router_http.VcapRequestIdHeader, "A-BOGUS-REQUEST-ID")
+		x.WriteRequest(req)
+
+		var answer string
+		Eventually(done).Should(Receive(&answer))
+		Ω(answer).ToNot(Equal("A-BOGUS-REQUEST-ID"))
+		Ω(answer).To(MatchRegexp(uuid_regex))
+
+		x.ReadResponse()
+	})
+
+	It("uses one request id for the backend, the response and the access log", func() {
+		done := make(chan string)
+
+		ln := registerHandler(r, "app", func(x *test_util.HttpConn) {
+			req, err := http.ReadRequest(x.Reader)
+			Ω(err).NotTo(HaveOccurred())
+
+			resp := test_util.NewResponse(http.StatusOK)
+			x.WriteResponse(resp)
+			x.Close()
+
+			done <- req.Header.Get(router_http.VcapRequestIdHeader)
+		})
+		defer ln.Close()
+
+		x := dialProxy(proxyServer)
+
+		req := x.NewRequest("GET", "/", nil)
+		req.Host = "app"
+		x.WriteRequest(req)
+
+		var requestId string
+		Eventually(done).Should(Receive(&requestId))
+		Ω(requestId).To(MatchRegexp(uuid_regex))
+
+		resp, _ := x.ReadResponse()
+		Ω(resp.Header.Get(router_http.VcapRequestIdHeader)).To(Equal(requestId))
+
+		var payload []byte
+		Eventually(func() int {
+			accessLogFile.Read(&payload)
+			return len(payload)
+		}).ShouldNot(BeZero())
+		Ω(string(payload)).To(ContainSubstring("vcap_request_id:" + requestId))
+	})
+
+	It("reuses the request id of the dropsonde HttpStartStop events", func() {
+		done := make(chan string)
+
+		ln := registerHandler(r, "app", func(x *test_util.HttpConn) {
+			req, err := http.ReadRequest(x.Reader)
+			Ω(err).NotTo(HaveOccurred())
+
+			resp := test_util.NewResponse(http.StatusOK)
+			x.WriteResponse(resp)
+			x.Close()
+
+			done <- req.Header.Get(router_http.VcapRequestIdHeader)
+		})
+		defer ln.Close()
+
+		x := dialProxy(proxyServer)
+
+		req := x.NewRequest("GET", "/", nil)
+		req.Host = "app"
+		req.Header.Set(correlation.DropsondeRequestIdHeader, "0f5a4d1e-5b8c-4b9a-9e1f-3c2d1a0b9e8f")
+		x.WriteRequest(req)
+
+		var answer string
+		Eventually(done).Should(Receive(&answer))
+		Ω(answer).To(Equal("0f5a4d1e-5b8c-4b9a-9e1f-3c2d1a0b9e8f"))
+
+		x.ReadResponse()
+	})
+
+	Context("with hop-by-hop request headers", func() {
+		var received chan http.Header
+		var ln net.Listener
+
+		JustBeforeEach(func() {
+			received = make(chan http.Header, 1)
+			ln = registerHandler(r, "app", func(x *test_util.HttpConn) {
+				req, err := http.ReadRequest(x.Reader)
+				Ω(err).NotTo(HaveOccurred())
+
+				received <- req.Header
+
+				resp := test_util.NewResponse(http.StatusOK)
+				x.WriteResponse(resp)
+				x.Close()
+			})
+		})
+
+		AfterEach(func() {
+			ln.Close()
+		})
+
+		sendRequest := func(header http.Header) http.Header {
+			x := dialProxy(proxyServer)
+
+			req := x.NewRequest("GET", "/", nil)
+			req.Host = "app"
+			for k, v := range header {
+				req.Header[k] = v
+			}
+			x.WriteRequest(req)
+
+			var backendHeader http.Header
+			Eventually(received).Should(Receive(&backendHeader))
+
+			x.ReadResponse()
+			return backendHeader
+		}
+
+		It("strips the headers named in the Connection header", func() {
+			backendHeader := sendRequest(http.Header{
+				"Connection": []string{"keep-alive, X-Internal", "x-other"},
+				"Keep-Alive": []string{"timeout=5"},
+				"X-Internal": []string{"secret"},
+				"X-Other":    []string{"other"},
+				"X-App":      []string{"kept"},
+			})
+
+			Ω(backendHeader).ToNot(HaveKey("Keep-Alive"))
+			Ω(backendHeader).ToNot(HaveKey("X-Internal"))
+			Ω(backendHeader).ToNot(HaveKey("X-Other"))
+			Ω(backendHeader.Get("X-App")).To(Equal("kept"))
+		})
+
+		It("strips hop-by-hop headers", func() {
+			backendHeader := sendRequest(http.Header{
+				"Proxy-Authorization": []string{"Basic Zm9vOmJhcg=="},
+				"Proxy-Connection":    []string{"keep-alive"},
+				"Upgrade":             []string{"h2c"},
+			})
+
+			Ω(backendHeader).ToNot(HaveKey("Proxy-Authorization"))
+			Ω(backendHeader).ToNot(HaveKey("Proxy-Connection"))
+			Ω(backendHeader).ToNot(HaveKey("Upgrade"))
+		})
+
+		It("does not let the Connection header strip the headers the router adds", func() {
+			backendHeader := sendRequest(http.Header{
+				"Connection": []string{"X-Vcap-Request-Id, X-Request-Start, X-Forwarded-For"},
+			})
+
+			Ω(backendHeader.Get("X-Vcap-Request-Id")).ToNot(BeEmpty())
+			Ω(backendHeader.Get("X-Request-Start")).ToNot(BeEmpty())
+			Ω(backendHeader.Get("X-Forwarded-For")).To(Equal("127.0.0.1"))
+		})
+	})
+
+	Context("with a request header limit", func() {
+		var ln net.Listener
+
+		JustBeforeEach(func() {
+			ln = registerHandler(r, "app", func(x *test_util.HttpConn) {
+				x.CheckLine("GET / HTTP/1.1")
+				resp := test_util.NewResponse(http.StatusOK)
+				x.WriteResponse(resp)
+				x.Close()
+			})
+		})
+
+		AfterEach(func() {
+			ln.Close()
+		})
+
+		sendRequest := func(headerSize int) *http.Response {
+			x := dialProxy(proxyServer)
+
+			req := x.NewRequest("GET", "/", nil)
+			req.Host = "app"
+			req.Header.Set("X-Padding", strings.Repeat("x", headerSize))
+			x.WriteRequest(req)
+
+			resp, _ := x.ReadResponse()
+			return resp
+		}
+
+		Context("when enforced", func() {
+			BeforeEach(func() {
+				conf.Limits.RequestHeaderBytes = config.LimitConfig{Max: 1024}
+			})
+
+			It("rejects requests with headers over the limit", func() {
+				resp := sendRequest(2048)
+				Ω(resp.StatusCode).To(Equal(http.StatusRequestHeaderFieldsTooLarge))
+				Ω(resp.Header.Get("X-Cf-RouterError")).To(Equal("request_header_too_large"))
+			})
+
+			It("proxies requests with headers under the limit", func() {
+				resp := sendRequest(10)
+				Ω(resp.StatusCode).To(Equal(http.StatusOK))
+			})
+		})
+
+		Context("in warn mode", func() {
+			BeforeEach(func() {
+				conf.Limits.RequestHeaderBytes = config.LimitConfig{Max: 1024, Mode: config.LimitModeWarn}
+			})
+
+			It("proxies requests with headers over the limit", func() {
+				resp := sendRequest(2048)
+				Ω(resp.StatusCode).To(Equal(http.StatusOK))
+			})
+		})
+	})
+
+	Context("with duplicate header policies", func() {
+		BeforeEach(func() {
+			conf.DuplicateHeaders = map[string]string{
+				"X-Forwarded-Host": config.DuplicateFirst,
+				"X-Tenant-Id":      config.DuplicateReject,
+			}
+		})
+
+		sendRequest := func(header http.Header) *http.Response {
+			x := dialProxy(proxyServer)
+
+			req := x.NewRequest("GET", "/", nil)
+			req.Host = "app"
+			for name, values := range header {
+				req.Header[name] = values
+			}
+			x.WriteRequest(req)
+
+			resp, _ := x.ReadResponse()
+			return resp
+		}
+
+		It("normalizes the headers sent to the backend", func() {
+			done := make(chan http.Header, 1)
+			ln := registerHandler(r, "app", func(x *test_util.HttpConn) {
+				req, _ := x.ReadRequest()
+				done <- req.Header
+
+				x.WriteResponse(test_util.NewResponse(http.StatusOK))
+				x.Close()
+			})
+			defer ln.Close()
+
+			resp := sendRequest(http.Header{"X-Forwarded-Host": {"one.example.com", "two.example.com"}})
+			Ω(resp.StatusCode).To(Equal(http.StatusOK))
+
+			var header http.Header
+			Eventually(done).Should(Receive(&header))
+			Ω(header["X-Forwarded-Host"]).To(Equal([]string{"one.example.com"}))
+		})
+
+		It("rejects requests that repeat a rejected header", func() {
+			ln := registerHandler(r, "app", func(x *test_util.HttpConn) {
+				Fail("the request should not reach the backend")
+			})
+			defer ln.Close()
+
+			resp := sendRequest(http.Header{"X-Tenant-Id": {"a", "b"}})
+			Ω(resp.StatusCode).To(Equal(http.StatusBadRequest))
+			Ω(resp.Header.Get("X-Cf-RouterError")).To(Equal("duplicate_header"))
+		})
+	})
+
+	Context("with backend response headers", func() {
+		var responseHeader http.Header
+		var ln net.Listener
+
+		BeforeEach(func() {
+			responseHeader = make(http.Header)
+		})
+
+		JustBeforeEach(func() {
+			ln = registerHandler(r, "app", func(x *test_util.HttpConn) {
+				_, err := http.ReadRequest(x.Reader)
+				Ω(err).NotTo(HaveOccurred())
+
+				resp := test_util.NewResponse(http.StatusOK)
+				resp.Header = responseHeader
+				x.WriteResponse(resp)
+				x.Close()
+			})
+		})
+
+		AfterEach(func() {
+			ln.Close()
+		})
+
+		sendRequest := func() *http.Response {
+			x := dialProxy(proxyServer)
+
+			req := x.NewRequest("GET", "/", nil)
+			req.Host = "app"
+			x.WriteRequest(req)
+
+			resp, _ := x.ReadResponse()
+			return resp
+		}
+
+		It("strips hop-by-hop headers", func() {
+			responseHeader.Set("Keep-Alive", "timeout=5")
+			responseHeader.Set("Proxy-Authenticate", "Basic")
+
+			resp := sendRequest()
+			Ω(resp.StatusCode).To(Equal(http.StatusOK))
+			Ω(resp.Header).ToNot(HaveKey("Keep-Alive"))
+			Ω(resp.Header).ToNot(HaveKey("Proxy-Authenticate"))
+		})
+
+		It("strips the headers named in the Connection header", func() {
+			responseHeader.Add("Connection", "X-Backend-Secret")
+			responseHeader.Set("X-Backend-Secret", "secret")
+			responseHeader.Set("X-App", "kept")
+
+			resp := sendRequest()
+			Ω(resp.StatusCode).To(Equal(http.StatusOK))
+			Ω(resp.Header).ToNot(HaveKey("X-Backend-Secret"))
+			Ω(resp.Header.Get("X-App")).To(Equal("kept"))
+		})
+
+		Context("with headers to strip", func() {
+			BeforeEach(func() {
+				conf.StripResponseHeaders = []string{"x-powered-by"}
+			})
+
+			It("strips them case-insensitively", func() {
+				responseHeader.Set("X-Powered-By", "PHP/5.3")
+				responseHeader.Set("X-App", "kept")
+
+				resp := sendRequest()
+				Ω(resp.Header).ToNot(HaveKey("X-Powered-By"))
+				Ω(resp.Header.Get("X-App")).To(Equal("kept"))
+			})
+		})
+
+		Context("with a response header size limit", func() {
+			BeforeEach(func() {
+				conf.Limits.ResponseHeaderBytes = config.LimitConfig{Max: 1024}
+			})
+
+			It("rejects responses with headers over the limit", func() {
+				responseHeader.Set("X-Padding", strings.Repeat("x", 2048))
+
+				resp := sendRequest()
+				Ω(resp.StatusCode).To(Equal(http.StatusBadGateway))
+				Ω(resp.Header.Get("X-Cf-RouterError")).To(Equal("response_header_too_large"))
+				Ω(resp.Header).ToNot(HaveKey("X-Padding"))
+			})
+
+			It("relays responses with headers under the limit", func() {
+				responseHeader.Set("X-Padding", "x")
+
+				resp := sendRequest()
+				Ω(resp.StatusCode).To(Equal(http.StatusOK))
+			})
+		})
+
+		Context("with a response header count limit", func() {
+			BeforeEach(func() {
+				conf.Limits.ResponseHeaderCount = config.LimitConfig{Max: 5}
+			})
+
+			It("rejects responses with too many headers", func() {
+				for i := 0; i < 10; i++ {
+					responseHeader.Add("X-Many", "x")
+				}
+
+				resp := sendRequest()
+				Ω(resp.StatusCode).To(Equal(http.StatusBadGateway))
+				Ω(resp.Header.Get("X-Cf-RouterError")).To(Equal("response_header_too_large"))
+			})
+		})
+	})
+
+	Context("with a response cache", func() {
+		var backendRequests chan *http.Request
+		var backendHeader http.Header
+		var backendStatus int
+		var ln net.Listener
+
+		BeforeEach(func() {
+			responseCache = cache.New(1024*1024, 1024*1024, clock.New())
+
+			backendRequests = make(chan *http.Request, 10)
+			backendStatus = http.StatusOK
+			backendHeader = http.Header{
+				"Etag":          []string{`"v1"`},
+				"Cache-Control": []string{"max-age=60"},
+				"Last-Modified": []string{"Mon, 12 Oct 2026 10:00:00 GMT"},
+			}
+		})
+
+		JustBeforeEach(func() {
+			ln = registerHandler(r, "cached", func(x *test_util.HttpConn) {
+				req, _ := x.ReadRequest()
+				backendRequests <- req
+
+				resp := test_util.NewResponse(backendStatus)
+				resp.Header = backendHeader
+				if backendStatus == http.StatusOK {
+					resp.ContentLength = 5
+					resp.Body = ioutil.NopCloser(strings.NewReader("hello"))
+				}
+				x.WriteResponse(resp)
+				x.Close()
+			})
+		})
+
+		AfterEach(func() {
+			ln.Close()
+		})
+
+		sendRequest := func(header http.Header) (*http.Response, string) {
+			x := dialProxy(proxyServer)
+
+			req := x.NewRequest("GET", "/page", nil)
+			req.Host = "cached"
+			for k, v := range header {
+				req.Header[k] = v
+			}
+			x.WriteRequest(req)
+
+			return x.ReadResponse()
+		}
+
+		It("serves fresh responses from the cache", func() {
+			resp, body := sendRequest(nil)
+			Ω(resp.StatusCode).To(Equal(http.StatusOK))
+			Ω(body).To(Equal("hello"))
+			Ω(backendRequests).To(Receive())
+
+			resp, body = sendRequest(nil)
+			Ω(resp.StatusCode).To(Equal(http.StatusOK))
+			Ω(body).To(Equal("hello"))
+			Ω(resp.Header.Get("Etag")).To(Equal(`"v1"`))
+			Ω(resp.Header.Get("Age")).ToNot(BeEmpty())
+			Ω(backendRequests).ToNot(Receive())
+		})
+
+		Context("with a fake clock", func() {
+			var clk *fakeclock.FakeClock
+
+			BeforeEach(func() {
+				clk = fakeclock.New(time.Now())
+				proxyClock = clk
+				responseCache = cache.New(1024*1024, 1024*1024, clk)
+			})
+
+			It("serves responses from the cache while they are fresh by the clock", func() {
+				sendRequest(nil)
+				Ω(backendRequests).To(Receive())
+
+				clk.Increment(30 * time.Second)
+				resp, _ := sendRequest(nil)
+				Ω(resp.Header.Get("Age")).To(Equal("30"))
+				Ω(backendRequests).ToNot(Receive())
+
+				clk.Increment(time.Minute)
+				sendRequest(nil)
+				Ω(backendRequests).To(Receive())
+			})
+		})
+
+		It("answers a matching If-None-Match with 304 from the cache", func() {
+			sendRequest(nil)
+			Ω(backendRequests).To(Receive())
+
+			resp, body := sendRequest(http.Header{"If-None-Match": []string{`"v0", "v1"`}})
+			Ω(resp.StatusCode).To(Equal(http.StatusNotModified))
+			Ω(body).To(BeEmpty())
+			Ω(resp.Header.Get("Etag")).To(Equal(`"v1"`))
+			Ω(backendRequests).ToNot(Receive())
+		})
+
+		It("compares a weak If-None-Match with a strong ETag weakly", func() {
+			sendRequest(nil)
+
+			resp, _ := sendRequest(http.Header{"If-None-Match": []string{`W/"v1"`}})
+			Ω(resp.StatusCode).To(Equal(http.StatusNotModified))
+		})
+
+		Context("when the backend sends a weak ETag", func() {
+			BeforeEach(func() {
+				backendHeader.Set("Etag", `W/"v1"`)
+			})
+
+			It("matches it with a strong If-None-Match", func() {
+				sendRequest(nil)
+
+				resp, _ := sendRequest(http.Header{"If-None-Match": []string{`"v1"`}})
+				Ω(resp.StatusCode).To(Equal(http.StatusNotModified))
+				Ω(resp.Header.Get("Etag")).To(Equal(`W/"v1"`))
+			})
+		})
+
+		It("serves the whole response when If-None-Match does not match", func() {
+			sendRequest(nil)
+			Ω(backendRequests).To(Receive())
+
+			resp, body := sendRequest(http.Header{"If-None-Match": []string{`"v2"`}})
+			Ω(resp.StatusCode).To(Equal(http.StatusOK))
+			Ω(body).To(Equal("hello"))
+			Ω(backendRequests).ToNot(Receive())
+		})
+
+		It("answers If-Modified-Since with 304 from the cache", func() {
+			sendRequest(nil)
+
+			resp, _ := sendRequest(http.Header{"If-Modified-Since": []string{"Tue, 13 Oct 2026 10:00:00 GMT"}})
+			Ω(resp.StatusCode).To(Equal(http.StatusNotModified))
+
+			resp, _ = sendRequest(http.Header{"If-Modified-Since": []string{"Sun, 11 Oct 2026 10:00:00 GMT"}})
+			Ω(resp.StatusCode).To(Equal(http.StatusOK))
+		})
+
+		Context("when the cached response is stale", func() {
+			BeforeEach(func() {
+				backendHeader.Set("Cache-Control", "max-age=0")
+			})
+
+			It("revalidates it with the backend", func() {
+				sendRequest(nil)
+				Ω(backendRequests).To(Receive())
+
+				backendStatus = http.StatusNotModified
+
+				resp, body := sendRequest(nil)
+				Ω(resp.StatusCode).To(Equal(http.StatusOK))
+				Ω(body).To(Equal("hello"))
+
+				var req *http.Request
+				Ω(backendRequests).To(Receive(&req))
+				Ω(req.Header.Get("If-None-Match")).To(Equal(`"v1"`))
+				Ω(req.Header.Get("If-Modified-Since")).To(Equal("Mon, 12 Oct 2026 10:00:00 GMT"))
+			})
+
+			It("passes the conditional request of a client through", func() {
+				sendRequest(nil)
+				Ω(backendRequests).To(Receive())
+
+				backendStatus = http.StatusNotModified
+
+				resp, _ := sendRequest(http.Header{"If-None-Match": []string{`"v0"`}})
+				Ω(resp.StatusCode).To(Equal(http.StatusNotModified))
+
+				var req *http.Request
+				Ω(backendRequests).To(Receive(&req))
+				Ω(req.Header.Get("If-None-Match")).To(Equal(`"v0"`))
+				Ω(req.Header).ToNot(HaveKey("If-Modified-Since"))
+			})
+		})
+
+		It("does not serve requests with credentials from the cache", func() {
+			sendRequest(nil)
+			Ω(backendRequests).To(Receive())
+
+			sendRequest(http.Header{"Authorization": []string{"Bearer token"}})
+			Ω(backendRequests).To(Receive())
+		})
+	})
+
+	Context("with request inspection", func() {
+		var inspector *fakeInspector
+		var backendBodies chan []byte
+		var ln net.Listener
+
+		gzipped := func(b []byte) []byte {
+			var buf bytes.Buffer
+			w := gzip.NewWriter(&buf)
+			w.Write(b)
+			w.Close()
+			return buf.Bytes()
+		}
+
+		BeforeEach(func() {
+			inspector = &fakeInspector{}
+			inspectionStage = inspection.NewStage(inspection.DefaultLimits, inspector)
+			backendBodies = make(chan []byte, 1)
+		})
+
+		JustBeforeEach(func() {
+			ln = registerHandler(r, "inspected", func(x *test_util.HttpConn) {
+				_, body := x.ReadRequest()
+				backendBodies <- []byte(body)
+
+				x.WriteResponse(test_util.NewResponse(http.StatusOK))
+				x.Close()
+			})
+		})
+
+		AfterEach(func() {
+			ln.Close()
+		})
+
+		sendRequest := func(body []byte, contentEncoding string) *http.Response {
+			x := dialProxy(proxyServer)
+
+			req := x.NewRequest("POST", "/", bytes.NewReader(body))
+			req.Host = "inspected"
+			req.Header.Set("Content-Encoding", contentEncoding)
+			x.WriteRequest(req)
+
+			resp, _ := x.ReadResponse()
+			return resp
+		}
+
+		It("inspects the decoded body and sends the original bytes on", func() {
+			body := gzipped([]byte("name=value"))
+
+			resp := sendRequest(body, "gzip")
+			Ω(resp.StatusCode).To(Equal(http.StatusOK))
+
+			Ω(inspector.Bodies()).To(Equal([]string{"name=value"}))
+			Ω(backendBodies).To(Receive(Equal(body)))
+		})
+
+		It("rejects requests an inspector refuses", func() {
+			resp := sendRequest([]byte("attack"), "")
+			Ω(resp.StatusCode).To(Equal(http.StatusForbidden))
+			Ω(resp.Header.Get("X-Cf-RouterError")).To(Equal("request_rejected"))
+			Ω(backendBodies).ToNot(Receive())
+		})
+
+		It("rejects bodies it cannot decode", func() {
+			resp := sendRequest([]byte("compressed"), "br")
+			Ω(resp.StatusCode).To(Equal(http.StatusUnsupportedMediaType))
+			Ω(resp.Header.Get("X-Cf-RouterError")).To(Equal("unsupported_content_encoding"))
+		})
+
+		Context("when it is configured", func() {
+			BeforeEach(func() {
+				conf.Inspection = config.InspectionConfig{
+					Enabled:      true,
+					DenyPatterns: []string{"(?i)<script"},
+				}
+				conf.Process()
+				inspectionStage = inspection.New(conf.Inspection)
+			})
+
+			It("refuses compressed bodies that match a deny pattern", func() {
+				resp := sendRequest(gzipped([]byte("comment=<SCRIPT>alert(1)</SCRIPT>")), "gzip")
+				Ω(resp.StatusCode).To(Equal(http.StatusForbidden))
+				Ω(resp.Header.Get("X-Cf-RouterError")).To(Equal("request_rejected"))
+				Ω(backendBodies).ToNot(Receive())
+
+				body := gzipped([]byte("comment=hello"))
+				resp = sendRequest(body, "gzip")
+				Ω(resp.StatusCode).To(Equal(http.StatusOK))
+				Ω(backendBodies).To(Receive(Equal(body)))
+			})
+		})
+
+		It("rejects bodies that decompress too far", func() {
+			body := gzipped(make([]byte, 4*1024*1024))
+
+			resp := sendRequest(body, "gzip")
+			Ω(resp.StatusCode).To(Equal(http.StatusRequestEntityTooLarge))
+			Ω(resp.Header.Get("X-Cf-RouterError")).To(Equal("request_body_too_large"))
+			Ω(inspector.Bodies()).To(BeEmpty())
+		})
+	})
+
+	Context("with a circuit breaker", func() {
+		var healthy, failing net.Listener
+		var failed int32
+
+		BeforeEach(func() {
+			conf.CircuitBreaker.Enabled = true
+			conf.CircuitBreaker.ConsecutiveFailures = 2
+			failed = 0
+		})
+
+		JustBeforeEach(func() {
+			failing = registerHandler(r, "breaker", func(x *test_util.HttpConn) {
+				x.CheckLine("GET / HTTP/1.1")
+				atomic.AddInt32(&failed, 1)
+
+				x.WriteResponse(test_util.NewResponse(http.StatusInternalServerError))
+				x.Close()
+			})
+			healthy = registerHandler(r, "breaker", func(x *test_util.HttpConn) {
+				x.CheckLine("GET / HTTP/1.1")
+
+				x.WriteResponse(test_util.NewResponse(http.StatusOK))
+				x.Close()
+			})
+		})
+
+		AfterEach(func() {
+			failing.Close()
+			healthy.Close()
+		})
+
+		It("stops sending requests to a backend that keeps failing", func() {
+			for i := 0; i < 10; i++ {
+				x := dialProxy(proxyServer)
+
+				req := x.NewRequest("GET", "/", nil)
+				req.Host = "breaker"
+				x.WriteRequest(req)
+				x.ReadResponse()
+			}
+
+			Ω(atomic.LoadInt32(&failed)).To(Equal(int32(2)))
+		})
+	})
+
+	Context("with an endpoint that has its own timeout", func() {
+		var ln net.Listener
+
+		BeforeEach(func() {
+			conf.EndpointTimeout = 200 * time.Millisecond
+		})
+
+		registerSlowHandler := func(delay, timeout time.Duration) {
+			ln = registerHandler(r, "slow", func(x *test_util.HttpConn) {
+				x.CheckLine("GET / HTTP/1.1")
+				time.Sleep(delay)
+
+				x.WriteResponse(test_util.NewResponse(http.StatusOK))
+				x.Close()
+			})
+
+			host, port, err := net.SplitHostPort(ln.Addr().String())
+			Ω(err).NotTo(HaveOccurred())
+			p, err := strconv.Atoi(port)
+			Ω(err).NotTo(HaveOccurred())
+
+			endpoint := route.NewEndpoint("", host, uint16(p), "", nil, -1)
+			endpoint.Timeout = timeout
+			r.Register(route.Uri("slow"), endpoint)
+		}
+
+		sendRequest := func() *http.Response {
+			x := dialProxy(proxyServer)
+
+			req := x.NewRequest("GET", "/", nil)
+			req.Host = "slow"
+			x.WriteRequest(req)
+
+			resp, _ := x.ReadResponse()
+			return resp
+		}
+
+		AfterEach(func() {
+			ln.Close()
+		})
+
+		It("waits longer than the endpoint timeout when the endpoint asks for it", func() {
+			registerSlowHandler(300*time.Millisecond, time.Second)
+
+			Ω(sendRequest().StatusCode).To(Equal(http.StatusOK))
+		})
+
+		It("gives up sooner than the endpoint timeout when the endpoint asks for it", func() {
+			registerSlowHandler(100*time.Millisecond, 20*time.Millisecond)
+
+			resp := sendRequest()
+			Ω(resp.StatusCode).To(Equal(http.StatusBadGateway))
+			Ω(resp.Header.Get("X-Cf-RouterError")).To(Equal("backend_timeout"))
+		})
+	})
+
+	Context("with a route that requires an Authorization header", func() {
+		var ln net.Listener
+
+		JustBeforeEach(func() {
+			ln = registerHandler(r, "api", func(x *test_util.HttpConn) {
+				x.CheckLine("GET / HTTP/1.1")
+
+				x.WriteResponse(test_util.NewResponse(http.StatusOK))
+				x.Close()
+			})
+
+			host, port, err := net.SplitHostPort(ln.Addr().String())
+			Ω(err).NotTo(HaveOccurred())
+			p, err := strconv.Atoi(port)
+			Ω(err).NotTo(HaveOccurred())
+
+			endpoint := route.NewEndpoint("", host, uint16(p), "", nil, -1)
+			endpoint.RequiresAuthorizationHeader = true
+			r.Register(route.Uri("api"), endpoint)
+		})
+
+		AfterEach(func() {
+			ln.Close()
+		})
+
+		sendRequest := func(authorization string) *http.Response {
+			x := dialProxy(proxyServer)
+
+			req := x.NewRequest("GET", "/", nil)
+			req.Host = "api"
+			if authorization != "" {
+				req.Header.Set("Authorization", authorization)
+			}
+			x.WriteRequest(req)
+
+			resp, _ := x.ReadResponse()
+			return resp
+		}
+
+		It("rejects requests without one", func() {
+			resp := sendRequest("")
+			Ω(resp.StatusCode).To(Equal(http.StatusUnauthorized))
+			Ω(resp.Header.Get("X-Cf-RouterError")).To(Equal("authorization_required"))
+		})
+
+		It("passes requests with one on", func() {
+			resp := sendRequest("Bearer token")
+			Ω(resp.StatusCode).To(Equal(http.StatusOK))
+		})
+	})
+
+	Context("with an oauth2 proxy", func() {
+		var ln net.Listener
+		var reached int32
+
+		BeforeEach(func() {
+			reached = 0
+			authenticator = oauth2proxy.NewAuthenticator([]config.OAuth2ProxyConfig{{
+				Domain:          "dashboard",
+				ClientId:        "client",
+				AuthorizeUrl:    "https://login.example.com/authorize",
+				TokenUrl:        "https://login.example.com/token",
+				CookieName:      "_session",
+				CookieSecret:    "0123456789abcdef",
+				SessionLifetime: time.Hour,
+			}}, nil, clock.New())
+		})
+
+		JustBeforeEach(func() {
+			ln = registerHandler(r, "dashboard", func(x *test_util.HttpConn) {
+				atomic.AddInt32(&reached, 1)
+				x.ReadRequest()
+
+				x.WriteResponse(test_util.NewResponse(http.StatusOK))
+				x.Close()
+			})
+		})
+
+		AfterEach(func() {
+			ln.Close()
+		})
+
+		It("sends users to log in before the request reaches the backend", func() {
+			x := dialProxy(proxyServer)
+
+			req := x.NewRequest("GET", "/", nil)
+			req.Host = "dashboard"
+			x.WriteRequest(req)
+
+			resp, _ := x.ReadResponse()
+			Ω(resp.StatusCode).To(Equal(http.StatusFound))
+			Ω(resp.Header.Get("Location")).To(HavePrefix("https://login.example.com/authorize?"))
+			Ω(atomic.LoadInt32(&reached)).To(BeZero())
+		})
+	})
+
+	Context("with rate limiting", func() {
+		var ln net.Listener
+
+		BeforeEach(func() {
+			limiter = ratelimit.New(config.RateLimitConfig{
+				Enabled:           true,
+				RequestsPerSecond: 0.5,
+				Burst:             1,
+			}, fakeclock.New(time.Now()))
+		})
+
+		JustBeforeEach(func() {
+			ln = registerHandler(r, "limited", func(x *test_util.HttpConn) {
+				x.ReadRequest()
+
+				x.WriteResponse(test_util.NewResponse(http.StatusOK))
+				x.Close()
+			})
+
+			host, port, err := net.SplitHostPort(ln.Addr().String())
+			Ω(err).NotTo(HaveOccurred())
+			p, err := strconv.Atoi(port)
+			Ω(err).NotTo(HaveOccurred())
+
+			r.Register(route.Uri("limited"), route.NewEndpoint("app-guid", host, uint16(p), "", nil, -1))
+		})
+
+		AfterEach(func() {
+			ln.Close()
+		})
+
+		sendRequest := func() *http.Response {
+			x := dialProxy(proxyServer)
+
+			req := x.NewRequest("GET", "/", nil)
+			req.Host = "limited"
+			x.WriteRequest(req)
+
+			resp, _ := x.ReadResponse()
+			return resp
+		}
+
+		It("refuses requests over the rate limit of the application", func() {
+			resp := sendRequest()
+			Ω(resp.StatusCode).To(Equal(http.StatusOK))
+
+			resp = sendRequest()
+			Ω(resp.StatusCode).To(Equal(http.StatusTooManyRequests))
+			Ω(resp.Header.Get("Retry-After")).To(Equal("2"))
+			Ω(resp.Header.Get("X-Cf-RouterError")).To(Equal("rate_limited"))
+		})
+
+		It("counts a request it resends once", func() {
+			var conns int32
+			closing := registerHandler(r, "closing-limited", func(x *test_util.HttpConn) {
+				x.CheckLine("GET / HTTP/1.1")
+				if atomic.AddInt32(&conns, 1) == 1 {
+					x.Close()
+					return
+				}
+
+				x.WriteResponse(test_util.NewResponse(http.StatusOK))
+				x.Close()
+			})
+			defer closing.Close()
+
+			host, port, err := net.SplitHostPort(closing.Addr().String())
+			Ω(err).NotTo(HaveOccurred())
+			p, err := strconv.Atoi(port)
+			Ω(err).NotTo(HaveOccurred())
+			// registered again, now with an app to count the requests of
+			r.Register(route.Uri("closing-limited"), route.NewEndpoint("resent-app-guid", host, uint16(p), "", nil, -1))
+
+			x := dialProxy(proxyServer)
+			req := x.NewRequest("GET", "/", nil)
+			req.Host = "closing-limited"
+			x.WriteRequest(req)
+
+			resp, _ := x.ReadResponse()
+			Ω(resp.StatusCode).To(Equal(http.StatusOK))
+			Ω(atomic.LoadInt32(&conns)).To(Equal(int32(2)))
+		})
+	})
+
+	Context("with client rate limiting", func() {
+		var ln net.Listener
+
+		BeforeEach(func() {
+			clientLimiter = ratelimit.NewClientLimiter(config.ClientLimitsConfig{
+				RateLimit: config.RateLimitConfig{
+					Enabled:           true,
+					RequestsPerSecond: 0.5,
+					Burst:             1,
+				},
+			}, fakeclock.New(time.Now()))
+		})
+
+		JustBeforeEach(func() {
+			ln = registerHandler(r, "app", func(x *test_util.HttpConn) {
+				x.ReadRequest()
+
+				x.WriteResponse(test_util.NewResponse(http.StatusOK))
+				x.Close()
+			})
+		})
+
+		AfterEach(func() {
+			ln.Close()
+		})
+
+		It("refuses requests of a client over its rate limit", func() {
+			x := dialProxy(proxyServer)
+			req := x.NewRequest("GET", "/", nil)
+			req.Host = "app"
+			x.WriteRequest(req)
+			resp, _ := x.ReadResponse()
+			Ω(resp.StatusCode).To(Equal(http.StatusOK))
+
+			x = dialProxy(proxyServer)
+			req = x.NewRequest("GET", "/", nil)
+			req.Host = "app"
+			x.WriteRequest(req)
+			resp, _ = x.ReadResponse()
+			Ω(resp.StatusCode).To(Equal(http.StatusTooManyRequests))
+			Ω(resp.Header.Get("Retry-After")).To(Equal("2"))
+			Ω(resp.Header.Get("X-Cf-RouterError")).To(Equal("client_rate_limited"))
+		})
+
+		Context("behind a trusted proxy", func() {
+			BeforeEach(func() {
+				trusted, err := ipfilter.ParseNetworks([]string{"127.0.0.0/8"})
+				Ω(err).NotTo(HaveOccurred())
+				conf.ForwardedHeaders.TrustedNetworks = trusted
+			})
+
+			sendRequest := func(forwardedFor string) *http.Response {
+				x := dialProxy(proxyServer)
+				req := x.NewRequest("GET", "/", nil)
+				req.Host = "app"
+				req.Header.Set("X-Forwarded-For", forwardedFor)
+				x.WriteRequest(req)
+				resp, _ := x.ReadResponse()
+				return resp
+			}
+
+			It("limits each client that the proxy forwarded on its own", func() {
+				Ω(sendRequest("10.1.2.3").StatusCode).To(Equal(http.StatusOK))
+				Ω(sendRequest("10.1.2.4").StatusCode).To(Equal(http.StatusOK))
+
+				resp := sendRequest("10.1.2.3")
+				Ω(resp.StatusCode).To(Equal(http.StatusTooManyRequests))
+				Ω(resp.Header.Get("X-Cf-RouterError")).To(Equal("client_rate_limited"))
+			})
+		})
+	})
+
+	Context("with client access lists", func() {
+		var ln net.Listener
+
+		networks := func(entries ...string) ipfilter.Networks {
+			n, err := ipfilter.ParseNetworks(entries)
+			Ω(err).NotTo(HaveOccurred())
+			return n
+		}
+
+		sendRequest := func(forwardedFor string) *http.Response {
+			x := dialProxy(proxyServer)
+			req := x.NewRequest("GET", "/", nil)
+			req.Host = "app"
+			if forwardedFor != "" {
+				req.Header.Set("X-Forwarded-For", forwardedFor)
+			}
+			x.WriteRequest(req)
+			resp, _ := x.ReadResponse()
+			return resp
+		}
+
+		JustBeforeEach(func() {
+			ln = registerHandler(r, "app", func(x *test_util.HttpConn) {
+				x.ReadRequest()
+
+				x.WriteResponse(test_util.NewResponse(http.StatusOK))
+				x.Close()
+			})
+		})
+
+		AfterEach(func() {
+			ln.Close()
+		})
+
+		Context("when the router denies some clients", func() {
+			BeforeEach(func() {
+				clientAccess = ipfilter.New(nil, networks("127.0.0.0/8"))
+			})
+
+			It("refuses their requests", func() {
+				resp := sendRequest("")
+				Ω(resp.StatusCode).To(Equal(http.StatusForbidden))
+				Ω(resp.Header.Get("X-Cf-RouterError")).To(Equal("client_denied"))
+			})
+		})
+
+		Context("when the route allows only some clients", func() {
+			JustBeforeEach(func() {
+				host, port, err := net.SplitHostPort(ln.Addr().String())
+				Ω(err).NotTo(HaveOccurred())
+				p, err := strconv.Atoi(port)
+				Ω(err).NotTo(HaveOccurred())
+
+				endpoint := route.NewEndpoint("", host, uint16(p), "", nil, -1)
+				endpoint.AllowedNetworks = networks("10.0.0.0/8")
+				r.Register(route.Uri("app"), endpoint)
+			})
+
+			It("refuses requests of the other clients", func() {
+				resp := sendRequest("")
+				Ω(resp.StatusCode).To(Equal(http.StatusForbidden))
+				Ω(resp.Header.Get("X-Cf-RouterError")).To(Equal("client_denied"))
+			})
+
+			It("ignores the forwarded client address of untrusted peers", func() {
+				resp := sendRequest("10.1.2.3")
+				Ω(resp.StatusCode).To(Equal(http.StatusForbidden))
+			})
+
+			Context("behind a trusted proxy", func() {
+				BeforeEach(func() {
+					conf.ForwardedHeaders.TrustedNetworks = networks("127.0.0.0/8")
+				})
+
+				It("checks the client address that the proxy forwarded", func() {
+					resp := sendRequest("192.168.0.1, 10.1.2.3")
+					Ω(resp.StatusCode).To(Equal(http.StatusOK))
+
+					resp = sendRequest("10.1.2.3, 192.168.0.1")
+					Ω(resp.StatusCode).To(Equal(http.StatusForbidden))
+				})
+			})
+		})
+	})
+
+	Context("with a route that requires signed URLs", func() {
+		const key = "0123456789abcdef"
+
+		var ln net.Listener
+
+		BeforeEach(func() {
+			verifier = signedurl.NewVerifier(config.SignedUrlsConfig{Key: key}, clock.New())
+		})
+
+		JustBeforeEach(func() {
+			ln = registerHandler(r, "files", func(x *test_util.HttpConn) {
+				x.ReadRequest()
+
+				x.WriteResponse(test_util.NewResponse(http.StatusOK))
+				x.Close()
+			})
+
+			host, port, err := net.SplitHostPort(ln.Addr().String())
+			Ω(err).NotTo(HaveOccurred())
+			p, err := strconv.Atoi(port)
+			Ω(err).NotTo(HaveOccurred())
+
+			endpoint := route.NewEndpoint("", host, uint16(p), "", nil, -1)
+			endpoint.RequiresSignedUrls = true
+			r.Register(route.Uri("files"), endpoint)
+		})
+
+		AfterEach(func() {
+			ln.Close()
+		})
+
+		sendRequest := func(uri string) *http.Response {
+			x := dialProxy(proxyServer)
+
+			req := x.NewRequest("GET", uri, nil)
+			req.Host = "files"
+			x.WriteRequest(req)
+
+			resp, _ := x.ReadResponse()
+			return resp
+		}
+
+		It("passes on requests with a signed URL", func() {
+			resp := sendRequest(signedurl.Sign(key, "files", "/report.pdf", time.Now().Add(time.Minute)))
+			Ω(resp.StatusCode).To(Equal(http.StatusOK))
+		})
+
+		It("rejects requests with an unsigned URL", func() {
+			resp := sendRequest("/report.pdf")
+			Ω(resp.StatusCode).To(Equal(http.StatusForbidden))
+			Ω(resp.Header.Get("X-Cf-RouterError")).To(Equal("signed_url_invalid"))
+		})
+
+		It("rejects requests with an expired URL", func() {
+			resp := sendRequest(signedurl.Sign(key, "files", "/report.pdf", time.Now().Add(-time.Minute)))
+			Ω(resp.StatusCode).To(Equal(http.StatusForbidden))
+			Ω(resp.Header.Get("X-Cf-RouterError")).To(Equal("signed_url_expired"))
+		})
+	})
+
+	Context("with a route that requires a bearer token", func() {
+		var (
+			ln        net.Listener
+			jwks      *httptest.Server
+			signer    *rsa.PrivateKey
+			forwarded chan string
+		)
+
+		BeforeEach(func() {
+			var err error
+			signer, err = rsa.GenerateKey(rand.Reader, 2048)
+			Ω(err).NotTo(HaveOccurred())
+
+			jwks = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				json.NewEncoder(w).Encode(map[string]interface{}{
+					"keys": []map[string]string{{
+						"kty": "RSA",
+						"kid": "key-1",
+						"n":   base64.RawURLEncoding.EncodeToString(signer.N.Bytes()),
+						"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(signer.E)).Bytes()),
+					}},
+				})
+			}))
+
+			jwtValidator = jwtauth.NewValidator(config.JwtConfig{
+				JwksUrl:             jwks.URL,
+				Issuer:              "issuer",
+				Audience:            "api",
+				JwksRefreshInterval: time.Minute,
+				ClaimHeaders:        map[string]string{"sub": "X-Jwt-Subject"},
+			}, clock.New())
+			forwarded = make(chan string, 1)
+		})
+
+		JustBeforeEach(func() {
+			ln = registerHandler(r, "api", func(x *test_util.HttpConn) {
+				req, _ := x.ReadRequest()
+				forwarded <- req.Header.Get("X-Jwt-Subject")
+
+				x.WriteResponse(test_util.NewResponse(http.StatusOK))
+				x.Close()
+			})
+
+			host, port, err := net.SplitHostPort(ln.Addr().String())
+			Ω(err).NotTo(HaveOccurred())
+			p, err := strconv.Atoi(port)
+			Ω(err).NotTo(HaveOccurred())
+
+			endpoint := route.NewEndpoint("", host, uint16(p), "", nil, -1)
+			endpoint.RequiresJwt = true
+			r.Register(route.Uri("api"), endpoint)
+		})
+
+		AfterEach(func() {
+			ln.Close()
+			jwks.Close()
+		})
+
+		token := func(exp time.Time) string {
+			segment := func(v interface{}) string {
+				b, err := json.Marshal(v)
+				Ω(err).NotTo(HaveOccurred())
+				return base64.RawURLEncoding.EncodeToString(b)
+			}
+
+			input := segment(map[string]string{"alg": "RS256", "kid": "key-1"}) + "." +
+				segment(map[string]interface{}{"iss": "issuer", "aud": "api", "sub": "user-guid", "exp": exp.Unix()})
+			hashed := sha256.Sum256([]byte(input))
+			signature, err := rsa.SignPKCS1v15(rand.Reader, signer, crypto.SHA256, hashed[:])
+			Ω(err).NotTo(HaveOccurred())
+			return input + "." + base64.RawURLEncoding.EncodeToString(signature)
+		}
+
+		sendRequest := func(authorization string) *http.Response {
+			x := dialProxy(proxyServer)
+
+			req := x.NewRequest("GET", "/orders", nil)
+			req.Host = "api"
+			if authorization != "" {
+				req.Header.Set("Authorization", authorization)
+			}
+			req.Header.Set("X-Jwt-Subject", "forged")
+			x.WriteRequest(req)
+
+			resp, _ := x.ReadResponse()
+			return resp
+		}
+
+		It("passes on requests with a valid token, with its claims", func() {
+			resp := sendRequest("Bearer " + token(time.Now().Add(time.Hour)))
+			Ω(resp.StatusCode).To(Equal(http.StatusOK))
+			Ω(forwarded).To(Receive(Equal("user-guid")))
+		})
+
+		It("rejects requests without a token", func() {
+			resp := sendRequest("")
+			Ω(resp.StatusCode).To(Equal(http.StatusUnauthorized))
+			Ω(resp.Header.Get("X-Cf-RouterError")).To(Equal("jwt_required"))
+			Ω(resp.Header.Get("WWW-Authenticate")).To(Equal("Bearer"))
+		})
+
+		It("rejects requests with an expired or invalid token", func() {
+			resp := sendRequest("Bearer " + token(time.Now().Add(-time.Hour)))
+			Ω(resp.StatusCode).To(Equal(http.StatusUnauthorized))
+			Ω(resp.Header.Get("X-Cf-RouterError")).To(Equal("jwt_expired"))
+
+			resp = sendRequest("Bearer not-a-token")
+			Ω(resp.StatusCode).To(Equal(http.StatusUnauthorized))
+			Ω(resp.Header.Get("X-Cf-RouterError")).To(Equal("jwt_invalid"))
+		})
+
+		Context("when the router has no keys", func() {
+			BeforeEach(func() {
+				jwtValidator = nil
+			})
+
+			It("rejects every request", func() {
+				resp := sendRequest("Bearer " + token(time.Now().Add(time.Hour)))
+				Ω(resp.StatusCode).To(Equal(http.StatusUnauthorized))
+				Ω(resp.Header.Get("X-Cf-RouterError")).To(Equal("jwt_invalid"))
+			})
+		})
+	})
+
+	Context("with an in-flight limit per endpoint", func() {
+		var ln net.Listener
+		var release chan struct{}
+
+		BeforeEach(func() {
+			conf.Limits.EndpointInFlight = config.LimitConfig{Max: 1}
+			release = make(chan struct{})
+		})
+
+		JustBeforeEach(func() {
+			ln = registerHandler(r, "busy", func(x *test_util.HttpConn) {
+				x.ReadRequest()
+				<-release
+
+				x.WriteResponse(test_util.NewResponse(http.StatusOK))
+				x.Close()
+			})
+		})
+
+		AfterEach(func() {
+			ln.Close()
+		})
+
+		It("refuses requests while every endpoint is at the limit", func() {
+			first := dialProxy(proxyServer)
+			req := first.NewRequest("GET", "/", nil)
+			req.Host = "busy"
+			first.WriteRequest(req)
+
+			// wait for the first request to reach the backend
+			time.Sleep(100 * time.Millisecond)
+
+			x := dialProxy(proxyServer)
+			req = x.NewRequest("GET", "/", nil)
+			req.Host = "busy"
+			x.WriteRequest(req)
+
+			resp, _ := x.ReadResponse()
+			Ω(resp.StatusCode).To(Equal(http.StatusServiceUnavailable))
+			Ω(resp.Header.Get("X-Cf-RouterError")).To(Equal("endpoints_at_capacity"))
+
+			close(release)
+			resp, _ = first.ReadResponse()
+			Ω(resp.StatusCode).To(Equal(http.StatusOK))
+		})
+	})
+
+	Context("with a request queue", func() {
+		var ln net.Listener
+		var reached, release chan struct{}
+
+		BeforeEach(func() {
+			conf.RequestQueue.MaxInFlight = 1
+			conf.RequestQueue.MaxQueued = 0
+			reached = make(chan struct{}, 1)
+			release = make(chan struct{})
+		})
+
+		JustBeforeEach(func() {
+			ln = registerHandler(r, "busy", func(x *test_util.HttpConn) {
+				x.ReadRequest()
+				reached <- struct{}{}
+				<-release
+
+				x.WriteResponse(test_util.NewResponse(http.StatusOK))
+				x.Close()
+			})
+		})
+
+		AfterEach(func() {
+			ln.Close()
+		})
+
+		It("refuses requests over the cap when the queue is full", func() {
+			first := dialProxy(proxyServer)
+			req := first.NewRequest("GET", "/", nil)
+			req.Host = "busy"
+			first.WriteRequest(req)
+			Eventually(reached).Should(Receive())
+
+			x := dialProxy(proxyServer)
+			req = x.NewRequest("GET", "/", nil)
+			req.Host = "busy"
+			x.WriteRequest(req)
+
+			resp, _ := x.ReadResponse()
+			Ω(resp.StatusCode).To(Equal(http.StatusServiceUnavailable))
+			Ω(resp.Header.Get("X-Cf-RouterError")).To(Equal("queue_full"))
+			Ω(resp.Header.Get("Retry-After")).To(Equal("1"))
+
+			close(release)
+			resp, _ = first.ReadResponse()
+			Ω(resp.StatusCode).To(Equal(http.StatusOK))
+		})
+	})
+
+	Context("with backend keep-alive", func() {
+		var ln net.Listener
+		var conns int32
+
+		JustBeforeEach(func() {
+			atomic.StoreInt32(&conns, 0)
+			ln = registerHandler(r, "pooled", func(x *test_util.HttpConn) {
+				atomic.AddInt32(&conns, 1)
+				for {
+					_, err := http.ReadRequest(x.Reader)
+					if err != nil {
+						x.Close()
+						return
+					}
+
+					resp := test_util.NewResponse(http.StatusOK)
+					resp.ContentLength = 0
+					x.WriteResponse(resp)
+				}
+			})
+		})
+
+		AfterEach(func() {
+			ln.Close()
+		})
+
+		sendRequest := func() {
+			x := dialProxy(proxyServer)
+
+			req := x.NewRequest("GET", "/", nil)
+			req.Host = "pooled"
+			x.WriteRequest(req)
+
+			resp, _ := x.ReadResponse()
+			Ω(resp.StatusCode).To(Equal(http.StatusOK))
+			x.Close()
+		}
+
+		It("reuses connections to a backend", func() {
+			sendRequest()
+			sendRequest()
+			sendRequest()
+
+			Ω(atomic.LoadInt32(&conns)).To(Equal(int32(1)))
+		})
+
+		Context("when it is off", func() {
+			BeforeEach(func() {
+				conf.BackendConnections.KeepAlive = false
+			})
+
+			It("dials a connection for each request", func() {
+				sendRequest()
+				sendRequest()
+
+				Eventually(func() int32 { return atomic.LoadInt32(&conns) }).Should(Equal(int32(2)))
+			})
+		})
+	})
+
+	Context("with a request body limit", func() {
+		BeforeEach(func() {
+			conf.Limits.RequestBodyBytes = config.LimitConfig{Max: 10}
+		})
+
+		It("refuses requests whose Content-Length is over the limit", func() {
+			ln := registerHandler(r, "upload", func(x *test_util.HttpConn) {
+				defer GinkgoRecover()
+				Fail("request was sent to the backend")
+			})
+			defer ln.Close()
+
+			x := dialProxy(proxyServer)
+
+			req := x.NewRequest("POST", "/", strings.NewReader("more than ten bytes"))
+			req.Host = "upload"
+			x.WriteRequest(req)
+
+			resp, _ := x.ReadResponse()
+			Ω(resp.StatusCode).To(Equal(http.StatusRequestEntityTooLarge))
+			Ω(resp.Header.Get("X-Cf-RouterError")).To(Equal("request_body_too_large"))
+		})
+
+		It("refuses chunked requests once their body grows over the limit", func() {
+			ln := registerHandler(r, "upload", func(x *test_util.HttpConn) {
+				req, err := http.ReadRequest(x.Reader)
+				if err == nil {
+					ioutil.ReadAll(req.Body)
+				}
+				x.Close()
+			})
+			defer ln.Close()
+
+			x := dialProxy(proxyServer)
+
+			req := x.NewRequest("POST", "/", strings.NewReader("more than ten bytes"))
+			req.Host = "upload"
+			req.ContentLength = -1
+			req.TransferEncoding = []string{"chunked"}
+			x.WriteRequest(req)
+
+			resp, _ := x.ReadResponse()
+			Ω(resp.StatusCode).To(Equal(http.StatusRequestEntityTooLarge))
+			Ω(resp.Header.Get("X-Cf-RouterError")).To(Equal("request_body_too_large"))
+		})
+
+		It("lets routes registered with their own limit take larger bodies", func() {
+			ln := registerHandler(r, "upload", func(x *test_util.HttpConn) {
+				_, body := x.ReadRequest()
+				Ω(body).To(Equal("more than ten bytes"))
+
+				x.WriteResponse(test_util.NewResponse(http.StatusOK))
+				x.Close()
+			})
+			defer ln.Close()
+
+			host, port, err := net.SplitHostPort(ln.Addr().String())
+			Ω(err).NotTo(HaveOccurred())
+			p, err := strconv.Atoi(port)
+			Ω(err).NotTo(HaveOccurred())
+
+			endpoint := route.NewEndpoint("", host, uint16(p), "", nil, -1)
+			endpoint.MaxRequestBodyBytes = 1024
+			r.Register(route.Uri("upload"), endpoint)
+
+			x := dialProxy(proxyServer)
+
+			req := x.NewRequest("POST", "/", strings.NewReader("more than ten bytes"))
+			req.Host = "upload"
+			x.WriteRequest(req)
+
+			resp, _ := x.ReadResponse()
+			Ω(resp.StatusCode).To(Equal(http.StatusOK))
+		})
+	})
+
+	Context("with a decision log", func() {
+		var dir string
+
+		BeforeEach(func() {
+			var err error
+			dir, err = ioutil.TempDir("", "decisions")
+			Ω(err).NotTo(HaveOccurred())
+
+			conf.DecisionLog = config.DecisionLogConfig{
+				Enabled:     true,
+				File:        filepath.Join(dir, "decisions.log"),
+				SampleRatio: 1,
+			}
+		})
+
+		AfterEach(func() {
+			decisions.Close()
+			os.RemoveAll(dir)
+		})
+
+		records := func() []decisionlog.Record {
+			var rs []decisionlog.Record
+			b, _ := ioutil.ReadFile(conf.DecisionLog.File)
+			for _, line := range strings.Split(strings.TrimSpace(string(b)), "\n") {
+				var r decisionlog.Record
+				if json.Unmarshal([]byte(line), &r) == nil {
+					rs = append(rs, r)
+				}
+			}
+			return rs
+		}
+
+		It("records the route and endpoint of proxied requests, and requests without a route", func() {
+			ln := registerHandler(r, "app", func(x *test_util.HttpConn) {
+				x.ReadRequest()
+				x.WriteResponse(test_util.NewResponse(http.StatusOK))
+				x.Close()
+			})
+			defer ln.Close()
+
+			x := dialProxy(proxyServer)
+			req := x.NewRequest("GET", "/orders", nil)
+			req.Host = "app"
+			x.WriteRequest(req)
+			resp, _ := x.ReadResponse()
+			Ω(resp.StatusCode).To(Equal(http.StatusOK))
+
+			x = dialProxy(proxyServer)
+			req = x.NewRequest("GET", "/", nil)
+			req.Host = "unknown"
+			x.WriteRequest(req)
+			resp, _ = x.ReadResponse()
+			Ω(resp.StatusCode).To(Equal(http.StatusNotFound))
+
+			Eventually(records).Should(HaveLen(2))
+			rs := records()
+
+			Ω(rs[0].Host).To(Equal("app"))
+			Ω(rs[0].Path).To(Equal("/orders"))
+			Ω(rs[0].RequestId).NotTo(BeEmpty())
+			Ω(rs[0].Route.Uri).To(Equal("app"))
+			Ω(rs[0].Endpoints).To(HaveLen(1))
+			Ω(rs[0].Endpoints[0].Address).To(Equal(ln.Addr().String()))
+			Ω(rs[0].Endpoints[0].Reason).To(Equal(decisionlog.ReasonLoadBalancing))
+			Ω(rs[0].StatusCode).To(Equal(http.StatusOK))
+
+			Ω(rs[1].Route).To(BeNil())
+			Ω(rs[1].Endpoints).To(BeEmpty())
+			Ω(rs[1].StatusCode).To(Equal(http.StatusNotFound))
+		})
+	})
+
+	Context("with header rules", func() {
+		BeforeEach(func() {
+			headerRules = headerrules.New([]config.HeaderRuleConfig{{
+				Routes: []string{"app"},
+				Request: config.HeaderActionsConfig{
+					Remove: []string{"X-Internal"},
+					Set:    map[string]string{"X-Client-Ip": "${client_ip}"},
+				},
+				Response: config.HeaderActionsConfig{
+					Remove: []string{"Server"},
+					Add:    map[string]string{"X-Served-By": "gorouter for ${host}"},
+				},
+			}})
+		})
+
+		It("changes the headers of requests and responses of matching routes", func() {
+			received := make(chan http.Header, 1)
+			ln := registerHandler(r, "app", func(x *test_util.HttpConn) {
+				req, _ := x.ReadRequest()
+				received <- req.Header
+
+				resp := test_util.NewResponse(http.StatusOK)
+				resp.Header.Set("Server", "backend/1.0")
+				x.WriteResponse(resp)
+				x.Close()
+			})
+			defer ln.Close()
+
+			x := dialProxy(proxyServer)
+
+			req := x.NewRequest("GET", "/", nil)
+			req.Host = "app"
+			req.Header.Set("X-Internal", "true")
+			x.WriteRequest(req)
+
+			resp, _ := x.ReadResponse()
+			Ω(resp.StatusCode).To(Equal(http.StatusOK))
+			Ω(resp.Header.Get("Server")).To(BeEmpty())
+			Ω(resp.Header.Get("X-Served-By")).To(Equal("gorouter for app"))
+
+			var header http.Header
+			Eventually(received).Should(Receive(&header))
+			Ω(header.Get("X-Internal")).To(BeEmpty())
+			Ω(header.Get("X-Client-Ip")).To(Equal("127.0.0.1"))
+		})
+
+		It("applies the header rules it is reloaded with to the requests that follow", func() {
+			ln := registerHandler(r, "app", func(x *test_util.HttpConn) {
+				x.ReadRequest()
+				resp := test_util.NewResponse(http.StatusOK)
+				resp.Header.Set("Server", "backend/1.0")
+				x.WriteResponse(resp)
+				x.Close()
+			})
+			defer ln.Close()
+
+			p.Reload(ReloadArgs{
+				EndpointTimeout: time.Second,
+				HeaderRules: headerrules.New([]config.HeaderRuleConfig{{
+					Routes:   []string{"app"},
+					Response: config.HeaderActionsConfig{Set: map[string]string{"X-Reloaded": "true"}},
+				}}),
+			})
+
+			x := dialProxy(proxyServer)
+
+			req := x.NewRequest("GET", "/", nil)
+			req.Host = "app"
+			x.WriteRequest(req)
+
+			resp, _ := x.ReadResponse()
+			Ω(resp.StatusCode).To(Equal(http.StatusOK))
+			Ω(resp.Header.Get("Server")).To(Equal("backend/1.0"))
+			Ω(resp.Header.Get("X-Served-By")).To(BeEmpty())
+			Ω(resp.Header.Get("X-Reloaded")).To(Equal("true"))
+		})
+
+		It("leaves other routes alone", func() {
+			ln := registerHandler(r, "other", func(x *test_util.HttpConn) {
+				x.ReadRequest()
+
+				resp := test_util.NewResponse(http.StatusOK)
+				resp.Header.Set("Server", "backend/1.0")
+				x.WriteResponse(resp)
+				x.Close()
+			})
+			defer ln.Close()
+
+			x := dialProxy(proxyServer)
+
+			req := x.NewRequest("GET", "/", nil)
+			req.Host = "other"
+			x.WriteRequest(req)
+
+			resp, _ := x.ReadResponse()
+			Ω(resp.Header.Get("Server")).To(Equal("backend/1.0"))
+			Ω(resp.Header.Get("X-Served-By")).To(BeEmpty())
+		})
+	})
+
+	Context("with error pages", func() {
+		var dir string
+
+		BeforeEach(func() {
+			var err error
+			dir, err = ioutil.TempDir("", "error-pages")
+			Ω(err).ToNot(HaveOccurred())
+
+			htmlFile := filepath.Join(dir, "404.html")
+			jsonFile := filepath.Join(dir, "404.json")
+			Ω(ioutil.WriteFile(htmlFile, []byte(`<h1>{{.Host}} was not found</h1>`), 0644)).To(Succeed())
+			Ω(ioutil.WriteFile(jsonFile, []byte(`{"error":{{json .Error}},"status":{{.Status}}}`), 0644)).To(Succeed())
+
+			errorPages, err = errorpages.New([]config.ErrorPageConfig{{
+				Status:   http.StatusNotFound,
+				HtmlFile: htmlFile,
+				JsonFile: jsonFile,
+			}}, false)
+			Ω(err).ToNot(HaveOccurred())
+		})
+
+		AfterEach(func() {
+			os.RemoveAll(dir)
+		})
+
+		sendRequest := func(accept string) (*http.Response, string) {
+			x := dialProxy(proxyServer)
+
+			req := x.NewRequest("GET", "/", nil)
+			req.Host = "unknown"
+			req.Header.Set("Accept", accept)
+			x.WriteRequest(req)
+
+			return x.ReadResponse()
+		}
+
+		It("answers browsers with the HTML page", func() {
+			resp, body := sendRequest("text/html,application/xhtml+xml,*/*;q=0.8")
+			Ω(resp.StatusCode).To(Equal(http.StatusNotFound))
+			Ω(resp.Header.Get("Content-Type")).To(Equal("text/html; charset=utf-8"))
+			Ω(resp.Header.Get("Vary")).To(Equal("Accept"))
+			Ω(body).To(Equal("<h1>unknown was not found</h1>"))
+		})
+
+		It("answers API clients with the JSON page", func() {
+			resp, body := sendRequest("application/json")
+			Ω(resp.StatusCode).To(Equal(http.StatusNotFound))
+			Ω(resp.Header.Get("Content-Type")).To(Equal("application/json"))
+			Ω(resp.Header.Get("X-Cf-RouterError")).To(Equal("unknown_route"))
+			Ω(body).To(Equal(`{"error":"unknown_route","status":404}`))
+		})
+
+		It("falls back to plain text for clients that accept neither", func() {
+			resp, body := sendRequest("text/plain")
+			Ω(resp.StatusCode).To(Equal(http.StatusNotFound))
+			Ω(body).To(Equal("404 Not Found: Requested route ('unknown') does not exist.\n"))
+		})
+
+		Context("and JSON errors", func() {
+			BeforeEach(func() {
+				var err error
+				errorPages, err = errorpages.New(nil, true)
+				Ω(err).ToNot(HaveOccurred())
+			})
+
+			It("answers API clients with a typed error", func() {
+				ln := registerHandler(r, "app", func(x *test_util.HttpConn) {
+					x.ReadRequest()
+					x.Close()
+				})
+				defer ln.Close()
+
+				x := dialProxy(proxyServer)
+
+				req := x.NewRequest("GET", "/", nil)
+				req.Host = "app"
+				req.Header.Set("Accept", "application/json")
+				x.WriteRequest(req)
+
+				resp, body := x.ReadResponse()
+				Ω(resp.StatusCode).To(Equal(http.StatusBadGateway))
+				Ω(resp.Header.Get("Content-Type")).To(Equal("application/json"))
+
+				var typed map[string]interface{}
+				Ω(json.Unmarshal([]byte(body), &typed)).To(Succeed())
+				Ω(typed["error"]).To(Equal("endpoint_failure"))
+				Ω(typed["status"]).To(BeEquivalentTo(502))
+				Ω(typed["request_id"]).ToNot(BeEmpty())
+			})
+		})
+
+		It("leaves responses with other statuses alone", func() {
+			ln := registerHandler(r, "app", func(x *test_util.HttpConn) {
+				x.ReadRequest()
+				x.Close()
+			})
+			defer ln.Close()
+
+			x := dialProxy(proxyServer)
+
+			req := x.NewRequest("GET", "/", nil)
+			req.Host = "app"
+			req.Header.Set("Accept", "application/json")
+			x.WriteRequest(req)
+
+			resp, body := x.ReadResponse()
+			Ω(resp.StatusCode).To(Equal(http.StatusBadGateway))
+			Ω(body).To(Equal("502 Bad Gateway: Registered endpoint failed to handle the request.\n"))
+		})
+	})
+
+	Context("with routes under maintenance", func() {
+		BeforeEach(func() {
+			maintenanceRoutes = maintenance.NewRoutes(clock.New())
+			maintenanceRoutes.Start("app", "Back at noon.", 600)
+		})
+
+		It("answers requests for them with 503 without asking their endpoints", func() {
+			requested := make(chan struct{}, 1)
+			ln := registerHandler(r, "app", func(x *test_util.HttpConn) {
+				requested <- struct{}{}
+				x.ReadRequest()
+				x.WriteResponse(test_util.NewResponse(http.StatusOK))
+				x.Close()
+			})
+			defer ln.Close()
+
+			x := dialProxy(proxyServer)
+
+			req := x.NewRequest("GET", "/", nil)
+			req.Host = "app:80"
+			x.WriteRequest(req)
+
+			resp, body := x.ReadResponse()
+			Ω(resp.StatusCode).To(Equal(http.StatusServiceUnavailable))
+			Ω(resp.Header.Get("X-Cf-RouterError")).To(Equal("maintenance"))
+			Ω(resp.Header.Get("Retry-After")).To(Equal("600"))
+			Ω(body).To(Equal("503 Service Unavailable: Back at noon.\n"))
+			Consistently(requested).ShouldNot(Receive())
+		})
+
+		It("answers requests for them when they have no endpoints", func() {
+			x := dialProxy(proxyServer)
+
+			req := x.NewRequest("GET", "/", nil)
+			req.Host = "app"
+			x.WriteRequest(req)
+
+			resp, _ := x.ReadResponse()
+			Ω(resp.StatusCode).To(Equal(http.StatusServiceUnavailable))
+			Ω(resp.Header.Get("X-Cf-RouterError")).To(Equal("maintenance"))
+		})
+
+		It("routes requests again once maintenance ends", func() {
+			ln := registerHandler(r, "app", func(x *test_util.HttpConn) {
+				x.ReadRequest()
+				x.WriteResponse(test_util.NewResponse(http.StatusOK))
+				x.Close()
+			})
+			defer ln.Close()
+
+			maintenanceRoutes.Stop("app")
+
+			x := dialProxy(proxyServer)
+
+			req := x.NewRequest("GET", "/", nil)
+			req.Host = "app"
+			x.WriteRequest(req)
+
+			resp, _ := x.ReadResponse()
+			Ω(resp.StatusCode).To(Equal(http.StatusOK))
+		})
+	})
+
+	It("routes requests by the headers and cookies endpoints registered with", func() {
+		respond := func(name string) connHandler {
+			return func(x *test_util.HttpConn) {
+				x.ReadRequest()
+				resp := test_util.NewResponse(http.StatusOK)
+				resp.Header.Set("X-Pool", name)
+				x.WriteResponse(resp)
+				x.Close()
+			}
+		}
+
+		stableLn := registerHandler(r, "app", respond("stable"))
+		defer stableLn.Close()
+
+		betaLn := registerHandler(r, "beta-only", respond("beta"))
+		defer betaLn.Close()
+
+		host, port, err := net.SplitHostPort(betaLn.Addr().String())
+		Ω(err).NotTo(HaveOccurred())
+		p, err := strconv.Atoi(port)
+		Ω(err).NotTo(HaveOccurred())
+		beta := route.NewEndpoint("", host, uint16(p), "", nil, -1)
+		beta.Match = route.Match{Headers: map[string]string{"X-Beta": "true"}}
+		r.Register("app", beta)
+
+		pool := func(header string) string {
+			x := dialProxy(proxyServer)
+
+			req := x.NewRequest("GET", "/", nil)
+			req.Host = "app"
+			if header != "" {
+				req.Header.Set("X-Beta", header)
+			}
+			x.WriteRequest(req)
+
+			resp, _ := x.ReadResponse()
+			Ω(resp.StatusCode).To(Equal(http.StatusOK))
+			return resp.Header.Get("X-Pool")
+		}
+
+		Ω(pool("true")).To(Equal("beta"))
+		Ω(pool("false")).To(Equal("stable"))
+		Ω(pool("")).To(Equal("stable"))
+	})
+
+	Context("with a mirrored route", func() {
+		BeforeEach(func() {
+			conf.Mirroring.Routes = []config.MirrorRouteConfig{
+				{Route: "app", Shadow: "app-v2", Percent: 100},
+			}
+		})
+
+		It("sends a copy of requests to the shadow and answers with the route's response", func() {
+			ln := registerHandler(r, "app", func(x *test_util.HttpConn) {
+				x.ReadRequest()
+				resp := test_util.NewResponse(http.StatusOK)
+				resp.Body = ioutil.NopCloser(strings.NewReader("app"))
+				resp.ContentLength = 3
+				x.WriteResponse(resp)
+				x.Close()
+			})
+			defer ln.Close()
+
+			type mirrored struct {
+				request *http.Request
+				body    string
+			}
+			received := make(chan mirrored, 1)
+			shadowLn := registerHandler(r, "app-v2", func(x *test_util.HttpConn) {
+				req, body := x.ReadRequest()
+				received <- mirrored{request: req, body: body}
+				x.WriteResponse(test_util.NewResponse(http.StatusInternalServerError))
+				x.Close()
+			})
+			defer shadowLn.Close()
+
+			x := dialProxy(proxyServer)
+
+			req := x.NewRequest("POST", "/items", strings.NewReader("item"))
+			req.Host = "app"
+			x.WriteRequest(req)
+
+			resp, body := x.ReadResponse()
+			Ω(resp.StatusCode).To(Equal(http.StatusOK))
+			Ω(body).To(Equal("app"))
+
+			var got mirrored
+			Eventually(received).Should(Receive(&got))
+			Ω(got.request.Method).To(Equal("POST"))
+			Ω(got.request.URL.Path).To(Equal("/items"))
+			Ω(got.request.Host).To(Equal("app-v2"))
+			Ω(got.request.Header.Get(mirror.MirroredHostHeader)).To(Equal("app"))
+			Ω(got.request.Header.Get(router_http.VcapRequestIdHeader)).To(Equal(resp.Header.Get(router_http.VcapRequestIdHeader)))
+			Ω(got.body).To(Equal("item"))
+		})
+	})
+
+	Context("with a forwarded headers policy", func() {
+		var received chan http.Header
+
+		sendRequest := func() http.Header {
+			ln := registerHandler(r, "app", func(x *test_util.HttpConn) {
+				req, _ := x.ReadRequest()
+				x.WriteResponse(test_util.NewResponse(http.StatusOK))
+				x.Close()
+				received <- req.Header
+			})
+			defer ln.Close()
+
+			x := dialProxy(proxyServer)
+
+			req := x.NewRequest("GET", "/", nil)
+			req.Host = "app"
+			req.Header.Set("X-Forwarded-For", "1.2.3.4")
+			req.Header.Set("X-Forwarded-Proto", "https")
+			x.WriteRequest(req)
+			x.ReadResponse()
+
+			var header http.Header
+			Eventually(received).Should(Receive(&header))
+			return header
+		}
+
+		BeforeEach(func() {
+			received = make(chan http.Header, 1)
+		})
+
+		It("keeps the headers clients send by default", func() {
+			header := sendRequest()
+			Ω(header.Get("X-Forwarded-For")).To(Equal("1.2.3.4, 127.0.0.1"))
+			Ω(header.Get("X-Forwarded-Proto")).To(Equal("https"))
+		})
+
+		Context("when it replaces them", func() {
+			BeforeEach(func() {
+				conf.ForwardedHeaders.Policy = config.ForwardedHeadersReplace
+			})
+
+			It("sets them from the client's connection", func() {
+				header := sendRequest()
+				Ω(header.Get("X-Forwarded-For")).To(Equal("127.0.0.1"))
+				Ω(header.Get("X-Forwarded-Proto")).To(Equal("http"))
+			})
+		})
+
+		Context("when it trusts only known proxies", func() {
+			BeforeEach(func() {
+				conf.ForwardedHeaders.Policy = config.ForwardedHeadersTrusted
+			})
+
+			Context("and the request comes from one", func() {
+				BeforeEach(func() {
+					_, network, _ := net.ParseCIDR("127.0.0.0/8")
+					conf.ForwardedHeaders.TrustedNetworks = []*net.IPNet{network}
+				})
+
+				It("keeps the headers", func() {
+					header := sendRequest()
+					Ω(header.Get("X-Forwarded-For")).To(Equal("1.2.3.4, 127.0.0.1"))
+					Ω(header.Get("X-Forwarded-Proto")).To(Equal("https"))
+				})
+			})
+
+			Context("and the request comes from anyone else", func() {
+				BeforeEach(func() {
+					_, network, _ := net.ParseCIDR("10.0.0.0/8")
+					conf.ForwardedHeaders.TrustedNetworks = []*net.IPNet{network}
+				})
+
+				It("replaces the headers", func() {
+					header := sendRequest()
+					Ω(header.Get("X-Forwarded-For")).To(Equal("127.0.0.1"))
+					Ω(header.Get("X-Forwarded-Proto")).To(Equal("http"))
+				})
+			})
+		})
+	})
+
+	Context("with client identity sources", func() {
+		trust := func(trusted string) {
+			_, network, _ := net.ParseCIDR(trusted)
+			conf.ClientIdentity.Sources = []config.ClientIdentitySourceConfig{
+				{Type: config.ClientIdentityAwsAlbOidc, TrustedNetworks: []*net.IPNet{network}},
+			}
+		}
+
+		sendRequest := func() http.Header {
+			received := make(chan http.Header, 1)
+
+			ln := registerHandler(r, "app", func(x *test_util.HttpConn) {
+				req, _ := x.ReadRequest()
+				x.WriteResponse(test_util.NewResponse(http.StatusOK))
+				x.Close()
+				received <- req.Header
+			})
+			defer ln.Close()
+
+			x := dialProxy(proxyServer)
+
+			req := x.NewRequest("GET", "/", nil)
+			req.Host = "app"
+			req.Header.Set("X-Amzn-Oidc-Identity", "user-123")
+			x.WriteRequest(req)
+			x.ReadResponse()
+
+			var header http.Header
+			Eventually(received).Should(Receive(&header))
+			return header
+		}
+
+		Context("and the request comes from a trusted load balancer", func() {
+			BeforeEach(func() {
+				trust("127.0.0.0/8")
+			})
+
+			It("passes on the identity it established", func() {
+				header := sendRequest()
+				Ω(header.Get("X-Client-Identity")).To(Equal("user-123"))
+				Ω(header.Get("X-Client-Identity-Source")).To(Equal("aws_alb_oidc"))
+			})
+		})
+
+		Context("and the request comes from anyone else", func() {
+			BeforeEach(func() {
+				trust("10.0.0.0/8")
+			})
+
+			It("drops the identity headers", func() {
+				header := sendRequest()
+				Ω(header).NotTo(HaveKey("X-Amzn-Oidc-Identity"))
+				Ω(header).NotTo(HaveKey("X-Client-Identity"))
+			})
+		})
+	})
+
+	Context("with response compression", func() {
+		var body string
+
+		BeforeEach(func() {
+			body = strings.Repeat("compress me ", 200)
+			compressor = compression.New(config.CompressionConfig{
+				Enabled:      true,
+				MinBytes:     1024,
+				ContentTypes: []string{"text/plain"},
+			})
+		})
+
+		respond := func(x *test_util.HttpConn) {
+			x.ReadRequest()
+
+			resp := test_util.NewResponse(http.StatusOK)
+			resp.Header.Set("Content-Type", "text/plain; charset=utf-8")
+			resp.Body = ioutil.NopCloser(strings.NewReader(body))
+			resp.ContentLength = int64(len(body))
+			x.WriteResponse(resp)
+			x.Close()
+		}
+
+		sendRequest := func() (*http.Response, string) {
+			x := dialProxy(proxyServer)
+
+			req := x.NewRequest("GET", "/", nil)
+			req.Host = "compressed"
+			req.Header.Set("Accept-Encoding", "gzip")
+			x.WriteRequest(req)
+
+			return x.ReadResponse()
+		}
+
+		It("compresses responses for clients that accept gzip", func() {
+			ln := registerHandler(r, "compressed", respond)
+			defer ln.Close()
+
+			resp, compressed := sendRequest()
+			Ω(resp.StatusCode).To(Equal(http.StatusOK))
+			Ω(resp.Header.Get("Content-Encoding")).To(Equal("gzip"))
+			Ω(resp.Header.Get("Vary")).To(Equal("Accept-Encoding"))
+			Ω(len(compressed)).To(BeNumerically("<", len(body)))
+
+			gz, err := gzip.NewReader(strings.NewReader(compressed))
+			Ω(err).NotTo(HaveOccurred())
+			uncompressed, err := ioutil.ReadAll(gz)
+			Ω(err).NotTo(HaveOccurred())
+			Ω(string(uncompressed)).To(Equal(body))
+		})
+
+		It("does not compress responses smaller than the minimum", func() {
+			body = "small"
+			ln := registerHandler(r, "compressed", respond)
+			defer ln.Close()
+
+			resp, uncompressed := sendRequest()
+			Ω(resp.Header.Get("Content-Encoding")).To(BeEmpty())
+			Ω(uncompressed).To(Equal("small"))
+		})
+
+		It("does not compress responses of routes that opt out", func() {
+			ln, err := net.Listen("tcp", "127.0.0.1:0")
+			Ω(err).NotTo(HaveOccurred())
+			defer ln.Close()
+			go func() {
+				conn, err := ln.Accept()
+				if err == nil {
+					respond(test_util.NewHttpConn(conn))
+				}
+			}()
+
+			host, port, err := net.SplitHostPort(ln.Addr().String())
+			Ω(err).NotTo(HaveOccurred())
+			p, err := strconv.Atoi(port)
+			Ω(err).NotTo(HaveOccurred())
+
+			endpoint := route.NewEndpoint("", host, uint16(p), "", nil, -1)
+			endpoint.DisableCompression = true
+			r.Register(route.Uri("compressed"), endpoint)
+
+			resp, uncompressed := sendRequest()
+			Ω(resp.Header.Get("Content-Encoding")).To(BeEmpty())
+			Ω(uncompressed).To(Equal(body))
+		})
+	})
+
+	Context("with a maintenance banner", func() {
+		BeforeEach(func() {
+			conf.Banners = []config.BannerConfig{{
+				Domains: []string{"bannered"},
+				Html:    "<p>Maintenance</p>",
+			}}
+		})
+
+		respond := func(x *test_util.HttpConn) {
+			x.ReadRequest()
+			resp := test_util.NewResponse(http.StatusOK)
+			resp.Header.Set("Content-Type", "text/html")
+			resp.Body = ioutil.NopCloser(strings.NewReader("<html><body><p>App</p></body></html>"))
+			resp.ContentLength = 36
+			x.WriteResponse(resp)
+			x.Close()
+		}
+
+		sendRequest := func() (*http.Response, string) {
+			x := dialProxy(proxyServer)
+
+			req := x.NewRequest("GET", "/", nil)
+			req.Host = "bannered"
+			x.WriteRequest(req)
+
+			return x.ReadResponse()
+		}
+
+		It("injects the banner into HTML responses", func() {
+			ln := registerHandler(r, "bannered", respond)
+			defer ln.Close()
+
+			resp, body := sendRequest()
+			Ω(body).To(Equal("<html><body><p>Maintenance</p><p>App</p></body></html>"))
+			Ω(resp.ContentLength).To(Equal(int64(len(body))))
+		})
+
+		It("does not inject the banner into responses of routes that opt out", func() {
+			ln, err := net.Listen("tcp", "127.0.0.1:0")
+			Ω(err).NotTo(HaveOccurred())
+			defer ln.Close()
+			go func() {
+				conn, err := ln.Accept()
+				if err == nil {
+					respond(test_util.NewHttpConn(conn))
+				}
+			}()
+
+			host, port, err := net.SplitHostPort(ln.Addr().String())
+			Ω(err).NotTo(HaveOccurred())
+			p, err := strconv.Atoi(port)
+			Ω(err).NotTo(HaveOccurred())
+
+			endpoint := route.NewEndpoint("", host, uint16(p), "", nil, -1)
+			endpoint.DisableBanners = true
+			r.Register(route.Uri("bannered"), endpoint)
+
+			_, body := sendRequest()
+			Ω(body).To(Equal("<html><body><p>App</p></body></html>"))
+		})
+	})
+
+	Context("with analytics rules", func() {
+		var received chan string
+		var sink *httptest.Server
+
+		BeforeEach(func() {
+			received = make(chan string, 1)
+			sink = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				b, _ := ioutil.ReadAll(r.Body)
+				received <- string(b)
+			}))
+
+			conf.Analytics.Url = sink.URL
+			conf.Analytics.FlushInterval = 10 * time.Millisecond
+			conf.Analytics.Rules = []config.AnalyticsRuleConfig{{
+				Event:   "item_viewed",
+				Path:    "/items/{item}",
+				Headers: []string{"X-Tenant-Id"},
+			}}
+		})
+
+		JustBeforeEach(func() {
+			go emitter.Run()
+		})
+
+		AfterEach(func() {
+			emitter.Stop()
+			sink.Close()
+		})
+
+		It("emits events for the requests the rules match", func() {
+			ln := registerHandler(r, "app", func(x *test_util.HttpConn) {
+				x.ReadRequest()
+				x.WriteResponse(test_util.NewResponse(http.StatusCreated))
+				x.Close()
+			})
+			defer ln.Close()
+
+			x := dialProxy(proxyServer)
+			req := x.NewRequest("GET", "/items/42", nil)
+			req.Host = "app"
+			req.Header.Set("X-Tenant-Id", "acme")
+			x.WriteRequest(req)
+			resp, _ := x.ReadResponse()
+			Ω(resp.StatusCode).To(Equal(http.StatusCreated))
+
+			var event string
+			Eventually(received).Should(Receive(&event))
+			Ω(event).To(ContainSubstring(`"event":"item_viewed"`))
+			Ω(event).To(ContainSubstring(`"status":201`))
+			Ω(event).To(ContainSubstring(`"params":{"item":"42"}`))
+			Ω(event).To(ContainSubstring(`"headers":{"X-Tenant-Id":"acme"}`))
+		})
+	})
+
+	Context("with a peer router", func() {
+		var peerServer *httptest.Server
+		var received chan *http.Request
+
+		BeforeEach(func() {
+			received = make(chan *http.Request, 1)
+			peerServer = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				received <- r
+				w.WriteHeader(http.StatusOK)
+			}))
+
+			forwarder = peer.NewForwarder(config.PeerFailoverConfig{
+				Name:    "local",
+				PeerUrl: peerServer.URL,
+				MaxHops: 1,
+			}, time.Second)
+		})
+
+		AfterEach(func() {
+			peerServer.Close()
+		})
+
+		It("forwards requests for unknown routes to the peer", func() {
+			x := dialProxy(proxyServer)
+
+			req := x.NewRequest("GET", "/", nil)
+			req.Host = "elsewhere"
+			x.WriteRequest(req)
+
+			resp, _ := x.ReadResponse()
+			Ω(resp.StatusCode).To(Equal(http.StatusOK))
+
+			var forwarded *http.Request
+			Eventually(received).Should(Receive(&forwarded))
+			Ω(forwarded.Host).To(Equal("elsewhere"))
+			Ω(forwarded.Header.Get(peer.ViaHeader)).To(Equal("local"))
+		})
+
+		It("answers requests that were forwarded before with a 404", func() {
+			x := dialProxy(proxyServer)
+
+			req := x.NewRequest("GET", "/", nil)
+			req.Host = "elsewhere"
+			req.Header.Set(peer.HopsHeader, "1")
+			x.WriteRequest(req)
+
+			resp, _ := x.ReadResponse()
+			Ω(resp.StatusCode).To(Equal(http.StatusNotFound))
+			Ω(received).ShouldNot(Receive())
+		})
+	})
+
+	Context("with request capture", func() {
+		var dir string
+		var ln net.Listener
+
+		BeforeEach(func() {
+			var err error
+			dir, err = ioutil.TempDir("", "capture")
+			Ω(err).ToNot(HaveOccurred())
+
+			recorder = capture.NewRecorder(config.CaptureConfig{
+				File:         filepath.Join(dir, "capture.log"),
+				MaxRequests:  10,
+				MaxDuration:  time.Minute,
+				MaxBodyBytes: 1024,
+			})
+			Ω(recorder.Start(capture.Options{
+				Filter:   capture.Filter{Host: "captured"},
+				Count:    1,
+				Duration: time.Minute,
+				Bodies:   true,
+			})).To(Succeed())
+		})
+
+		JustBeforeEach(func() {
+			ln = registerHandler(r, "captured", func(x *test_util.HttpConn) {
+				x.ReadRequest()
+
+				resp := test_util.NewResponse(http.StatusOK)
+				resp.Body = ioutil.NopCloser(strings.NewReader("captured body"))
+				resp.ContentLength = int64(len("captured body"))
+				x.WriteResponse(resp)
+				x.Close()
+			})
+		})
+
+		AfterEach(func() {
+			ln.Close()
+			recorder.Stop()
+			os.RemoveAll(dir)
+		})
+
+		It("records the request and the response the client got", func() {
+			x := dialProxy(proxyServer)
+
+			req := x.NewRequest("GET", "/", nil)
+			req.Host = "captured"
+			x.WriteRequest(req)
+
+			resp, body := x.ReadResponse()
+			Ω(resp.StatusCode).To(Equal(http.StatusOK))
+			Ω(body).To(Equal("captured body"))
+
+			var content []byte
+			Eventually(func() []byte {
+				content, _ = ioutil.ReadFile(filepath.Join(dir, "capture.log"))
+				return content
+			}).ShouldNot(BeEmpty())
+
+			var exchange struct {
+				Request struct {
+					Host string `json:"host"`
+				} `json:"request"`
+				Response struct {
+					StatusCode int    `json:"status_code"`
+					Body       []byte `json:"body"`
+				} `json:"response"`
+			}
+			Ω(json.Unmarshal(content, &exchange)).To(Succeed())
+			Ω(exchange.Request.Host).To(Equal("captured"))
+			Ω(exchange.Response.StatusCode).To(Equal(http.StatusOK))
+			Ω(string(exchange.Response.Body)).To(Equal("captured body"))
+		})
+	})
+
+	Context("with Zipkin enabled", func() {
+		BeforeEach(func() {
+			conf.Tracing.EnableZipkin = true
+		})
 
-		x := dialProxy(proxyServer)
+		sendRequest := func(header http.Header) http.Header {
+			done := make(chan http.Header)
 
-		req := x.NewRequest("GET", "/", nil)
-		req.Host = "app"
-		x.WriteRequest(req)
+			ln := registerHandler(r, "app", func(x *test_util.HttpConn) {
+				req, err := http.ReadRequest(x.Reader)
+				Ω(err).NotTo(HaveOccurred())
 
-		var answer string
-		Eventually(done).Should(Receive(&answer))
-		Ω(answer).To(MatchRegexp("^\\d{10}\\d{3}$")) // unix timestamp millis
+				resp := test_util.NewResponse(http.StatusOK)
+				x.WriteResponse(resp)
+				x.Close()
 
-		x.ReadResponse()
-	})
+				done <- req.Header
+			})
+			defer ln.Close()
 
-	It("X-Request-Start is not overwritten", func() {
-		done := make(chan []string)
+			x := dialProxy(proxyServer)
 
-		ln := registerHandler(r, "app", func(x *test_util.HttpConn) {
-			req, err := http.ReadRequest(x.Reader)
-			Ω(err).NotTo(HaveOccurred())
+			req := x.NewRequest("GET", "/", nil)
+			req.Host = "app"
+			for k, v := range header {
+				req.Header[k] = v
+			}
+			x.WriteRequest(req)
 
-			resp := test_util.NewResponse(http.StatusOK)
-			x.WriteResponse(resp)
-			x.Close()
+			var received http.Header
+			Eventually(done).Should(Receive(&received))
+			x.ReadResponse()
 
-			done <- req.Header[http.CanonicalHeaderKey("X-Request-Start")]
+			return received
+		}
+
+		It("starts a trace when the request has none", func() {
+			received := sendRequest(http.Header{})
+
+			Ω(received.Get(router_http.B3TraceIdHeader)).To(MatchRegexp("^[0-9a-f]{32}$"))
+			Ω(received.Get(router_http.B3SpanIdHeader)).To(MatchRegexp("^[0-9a-f]{16}$"))
+			Ω(received.Get(router_http.B3ParentSpanIdHeader)).To(BeEmpty())
 		})
-		defer ln.Close()
 
-		x := dialProxy(proxyServer)
+		It("continues the trace of the request", func() {
+			received := sendRequest(http.Header{
+				router_http.B3TraceIdHeader: []string{"463ac35c9f6413ad"},
+				router_http.B3SpanIdHeader:  []string{"a2fb4a1d1a96d312"},
+			})
 
-		req := x.NewRequest("GET", "/", nil)
-		req.Host = "app"
-		req.Header.Add("X-Request-Start", "") // impl cannot just check for empty string
-		req.Header.Add("X-Request-Start", "user-set2")
-		x.WriteRequest(req)
+			Ω(received.Get(router_http.B3TraceIdHeader)).To(Equal("463ac35c9f6413ad"))
+			Ω(received.Get(router_http.B3ParentSpanIdHeader)).To(Equal("a2fb4a1d1a96d312"))
+			Ω(received.Get(router_http.B3SpanIdHeader)).To(MatchRegexp("^[0-9a-f]{16}$"))
+			Ω(received.Get(router_http.B3SpanIdHeader)).ToNot(Equal("a2fb4a1d1a96d312"))
+		})
 
-		var answer []string
-		Eventually(done).Should(Receive(&answer))
-		Ω(answer).To(Equal([]string{"", "user-set2"}))
+		It("logs the trace id", func() {
+			sendRequest(http.Header{
+				router_http.B3TraceIdHeader: []string{"463ac35c9f6413ad"},
+			})
 
-		x.ReadResponse()
+			var payload []byte
+			Eventually(func() int {
+				accessLogFile.Read(&payload)
+				return len(payload)
+			}).ShouldNot(BeZero())
+			Ω(string(payload)).To(ContainSubstring("x_b3_traceid:463ac35c9f6413ad\n"))
+		})
 	})
 
-	It("X-VcapRequest-Id header is added", func() {
-		done := make(chan string)
-
-		ln := registerHandler(r, "app", func(x *test_util.HttpConn) {
-			req, err := http.ReadRequest(x.Reader)
-			Ω(err).NotTo(HaveOccurred())
+	Context("with tracing enabled", func() {
+		var exporter *recordingExporter
 
-			resp := test_util.NewResponse(http.StatusOK)
-			x.WriteResponse(resp)
-			x.Close()
+		BeforeEach(func() {
+			exporter = &recordingExporter{}
+			tracer = tracing.NewTracer(exporter, 1, 10*time.Millisecond)
+			go tracer.Run()
+		})
 
-			done <- req.Header.Get(router_http.VcapRequestIdHeader)
+		AfterEach(func() {
+			tracer.Stop()
 		})
-		defer ln.Close()
 
-		x := dialProxy(proxyServer)
+		It("propagates the incoming trace context to the backend", func() {
+			done := make(chan string)
 
-		req := x.NewRequest("GET", "/", nil)
-		req.Host = "app"
-		x.WriteRequest(req)
+			ln := registerHandler(r, "app", func(x *test_util.HttpConn) {
+				req, err := http.ReadRequest(x.Reader)
+				Ω(err).NotTo(HaveOccurred())
 
-		var answer string
-		Eventually(done).Should(Receive(&answer))
-		Ω(answer).To(MatchRegexp(uuid_regex))
+				resp := test_util.NewResponse(http.StatusOK)
+				x.WriteResponse(resp)
+				x.Close()
 
-		x.ReadResponse()
-	})
+				done <- req.Header.Get(tracing.TraceparentHeader)
+			})
+			defer ln.Close()
 
-	It("X-Vcap-Request-Id header is overwritten", func() {
-		done := make(chan string)
+			x := dialProxy(proxyServer)
 
-		ln := registerHandler(r, "app", func(x *test_util.HttpConn) {
-			req, err := http.ReadRequest(x.Reader)
-			Ω(err).NotTo(HaveOccurred())
+			req := x.NewRequest("GET", "/", nil)
+			req.Host = "app"
+			req.Header.Set(tracing.TraceparentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+			x.WriteRequest(req)
 
-			resp := test_util.NewResponse(http.StatusOK)
-			x.WriteResponse(resp)
-			x.Close()
+			var traceparent string
+			Eventually(done).Should(Receive(&traceparent))
+			x.ReadResponse()
 
-			done <- req.Header.Get(router_http.VcapRequestIdHeader)
+			c, ok := tracing.ParseTraceparent(traceparent)
+			Ω(ok).To(BeTrue())
+			Ω(c.TraceId.String()).To(Equal("4bf92f3577b34da6a3ce929d0e0e4736"))
+			Ω(c.SpanId.String()).ToNot(Equal("00f067aa0ba902b7"))
+
+			Eventually(exporter.Spans).Should(HaveLen(2))
+
+			var server, client *tracing.Span
+			for _, s := range exporter.Spans() {
+				if s.Kind == tracing.SpanKindServer {
+					server = s
+				} else {
+					client = s
+				}
+			}
+			Ω(server).ToNot(BeNil())
+			Ω(client).ToNot(BeNil())
+
+			Ω(server.ParentSpanId.String()).To(Equal("00f067aa0ba902b7"))
+			Ω(server.Attributes).To(HaveKeyWithValue("http.status_code", http.StatusOK))
+			Ω(client.ParentSpanId).To(Equal(server.Context.SpanId))
+			Ω(client.Context.SpanId).To(Equal(c.SpanId))
 		})
-		defer ln.Close()
 
-		x := dialProxy(proxyServer)
+		It("records a span per backend attempt", func() {
+			ln := registerHandler(r, "retries", func(x *test_util.HttpConn) {
+				x.CheckLine("GET / HTTP/1.1")
+				resp := test_util.NewResponse(http.StatusOK)
+				x.WriteResponse(resp)
+				x.Close()
+			})
+			defer ln.Close()
 
-		req := x.NewRequest("GET", "/", nil)
-		req.Host = "app"
-		req.Header.Add(router_http.VcapRequestIdHeader, "A-BOGUS-REQUEST-ID")
-		x.WriteRequest(req)
+			ip, err := net.ResolveTCPAddr("tcp", "localhost:81")
+			Ω(err).Should(BeNil())
+			registerAddr(r, "retries", ip, "instanceId")
 
-		var answer string
-		Eventually(done).Should(Receive(&answer))
-		Ω(answer).ToNot(Equal("A-BOGUS-REQUEST-ID"))
-		Ω(answer).To(MatchRegexp(uuid_regex))
+			for i := 0; i < 5; i++ {
+				x := dialProxy(proxyServer)
 
-		x.ReadResponse()
+				req := x.NewRequest("GET", "/", nil)
+				req.Host = "retries"
+				x.WriteRequest(req)
+				resp, _ := x.ReadResponse()
+				Ω(resp.StatusCode).To(Equal(http.StatusOK))
+			}
+
+			Eventually(func() int {
+				failed := 0
+				for _, s := range exporter.Spans() {
+					if s.Kind == tracing.SpanKindClient && s.Error != "" {
+						failed++
+					}
+				}
+				return failed
+			}).ShouldNot(BeZero())
+		})
 	})
 
 	It("X-CF-InstanceID header is added literally if present in the routing endpoint", func() {
@@ -612,6 +3484,56 @@ var _ = Describe("Proxy", func() {
 		x.Close()
 	})
 
+	It("keeps an open WebSocket in flight in varz until it closes", func() {
+		closed := make(chan struct{})
+
+		ln := registerHandler(r, "ws-in-flight", func(x *test_util.HttpConn) {
+			_, err := http.ReadRequest(x.Reader)
+			Ω(err).NotTo(HaveOccurred())
+
+			resp := test_util.NewResponse(http.StatusSwitchingProtocols)
+			resp.Header.Set("Upgrade", "websocket")
+			resp.Header.Set("Connection", "upgrade")
+			x.WriteResponse(resp)
+
+			x.CheckLine("hello from client")
+			<-closed
+			x.Close()
+		})
+		defer ln.Close()
+
+		inFlight := func() interface{} {
+			b, err := json.Marshal(varz.NewVarz(r))
+			Ω(err).NotTo(HaveOccurred())
+
+			var v struct {
+				InFlight map[string]struct {
+					Requests int64 `json:"requests"`
+				} `json:"in_flight"`
+			}
+			Ω(json.Unmarshal(b, &v)).To(Succeed())
+			return v.InFlight["ws-in-flight"].Requests
+		}
+
+		x := dialProxy(proxyServer)
+
+		req := x.NewRequest("GET", "/chat", nil)
+		req.Host = "ws-in-flight"
+		req.Header.Set("Upgrade", "websocket")
+		req.Header.Set("Connection", "upgrade")
+		x.WriteRequest(req)
+
+		resp, _ := x.ReadResponse()
+		Ω(resp.StatusCode).To(Equal(http.StatusSwitchingProtocols))
+
+		x.WriteLine("hello from client")
+		Consistently(inFlight).Should(BeEquivalentTo(1))
+
+		close(closed)
+		Eventually(inFlight).Should(BeEquivalentTo(0))
+		x.Close()
+	})
+
 	It("upgrades for a WebSocket request with comma-separated Connection header", func() {
 		done := make(chan bool)
 
@@ -708,6 +3630,42 @@ var _ = Describe("Proxy", func() {
 		x.Close()
 	})
 
+	It("strips the headers the Connection header of a WebSocket request names", func() {
+		done := make(chan http.Header)
+
+		ln := registerHandler(r, "ws-hop", func(x *test_util.HttpConn) {
+			req, err := http.ReadRequest(x.Reader)
+			Ω(err).NotTo(HaveOccurred())
+
+			done <- req.Header
+
+			resp := test_util.NewResponse(http.StatusSwitchingProtocols)
+			resp.Header.Set("Upgrade", "websocket")
+			resp.Header.Set("Connection", "Upgrade")
+			x.WriteResponse(resp)
+			x.Close()
+		})
+		defer ln.Close()
+
+		x := dialProxy(proxyServer)
+
+		req := x.NewRequest("GET", "/chat", nil)
+		req.Host = "ws-hop"
+		req.Header.Set("Upgrade", "websocket")
+		req.Header.Set("Connection", "Upgrade, X-Internal")
+		req.Header.Set("X-Internal", "secret")
+		x.WriteRequest(req)
+
+		var backendHeader http.Header
+		Eventually(done).Should(Receive(&backendHeader))
+		Ω(backendHeader.Get("Upgrade")).To(Equal("websocket"))
+		Ω(backendHeader.Get("Connection")).To(Equal("Upgrade, X-Internal"))
+		Ω(backendHeader).ToNot(HaveKey("X-Internal"))
+
+		resp, _ := x.ReadResponse()
+		Ω(resp.StatusCode).To(Equal(http.StatusSwitchingProtocols))
+	})
+
 	It("upgrades a Tcp request", func() {
 		ln := registerHandler(r, "tcp-handler", func(x *test_util.HttpConn) {
 			x.WriteLine("hello")
@@ -1023,6 +3981,229 @@ var _ = Describe("Proxy", func() {
 			})
 		})
 
+		Context("when configured with sticky sessions keys", func() {
+			BeforeEach(func() {
+				conf.StickySessions = config.StickySessionsConfig{
+					Keys:    []string{"sticky-key-0123456789"},
+					Encrypt: true,
+				}
+			})
+
+			It("pins clients with cookies that do not reveal the instance", func() {
+				for _, id := range []string{"instance-a", "instance-b"} {
+					id := id
+					ln := registerHandlerWithInstanceId(r, "app", func(x *test_util.HttpConn) {
+						x.ReadRequest()
+
+						resp := test_util.NewResponse(http.StatusOK)
+						resp.Header.Add("Set-Cookie", (&http.Cookie{Name: StickyCookieKey, Value: "xxx"}).String())
+						resp.Header.Set("X-Instance", id)
+						x.WriteResponse(resp)
+						x.Close()
+					}, id)
+					defer ln.Close()
+				}
+
+				send := func(cookies ...*http.Cookie) *http.Response {
+					x := dialProxy(proxyServer)
+					req := x.NewRequest("GET", "/", nil)
+					req.Host = "app"
+					for _, c := range cookies {
+						req.AddCookie(c)
+					}
+					x.WriteRequest(req)
+
+					resp, _ := x.ReadResponse()
+					return resp
+				}
+
+				resp := send()
+				instance := resp.Header.Get("X-Instance")
+
+				var sticky *http.Cookie
+				for _, cookie := range resp.Cookies() {
+					if cookie.Name == VcapCookieId {
+						sticky = cookie
+					}
+				}
+				Ω(sticky).NotTo(BeNil())
+				Ω(sticky.Value).NotTo(ContainSubstring("instance"))
+
+				codec := stickysession.NewCodec(conf.StickySessions)
+				id, ok := codec.Decode(sticky.Value)
+				Ω(ok).To(BeTrue())
+				Ω(id).To(Equal(instance))
+
+				for i := 0; i < 4; i++ {
+					resp = send(&http.Cookie{Name: StickyCookieKey, Value: "xxx"}, sticky)
+					Ω(resp.Header.Get("X-Instance")).To(Equal(instance))
+				}
+			})
+		})
+
+		Context("when configured with the address of the router", func() {
+			var peerServer *httptest.Server
+			var received chan *http.Request
+
+			BeforeEach(func() {
+				conf.StickySessions = config.StickySessionsConfig{
+					Keys:          []string{"sticky-key-0123456789"},
+					RouterAddress: "10.0.16.5:80",
+				}
+
+				received = make(chan *http.Request, 1)
+				peerServer = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					received <- r
+					w.WriteHeader(http.StatusOK)
+				}))
+			})
+
+			AfterEach(func() {
+				peerServer.Close()
+			})
+
+			// pinned returns the cookies of a session pinned to id by the
+			// router at routerAddress
+			pinned := func(id, routerAddress string) []*http.Cookie {
+				codec := stickysession.NewCodec(config.StickySessionsConfig{
+					Keys:          conf.StickySessions.Keys,
+					RouterAddress: routerAddress,
+				})
+				return []*http.Cookie{
+					{Name: StickyCookieKey, Value: "xxx"},
+					{Name: VcapCookieId, Value: codec.Encode(id)},
+				}
+			}
+
+			send := func(cookies []*http.Cookie, hops string) *http.Response {
+				x := dialProxy(proxyServer)
+				req := x.NewRequest("GET", "/", nil)
+				req.Host = "app"
+				for _, c := range cookies {
+					req.AddCookie(c)
+				}
+				if hops != "" {
+					req.Header.Set(peer.HopsHeader, hops)
+				}
+				x.WriteRequest(req)
+
+				resp, _ := x.ReadResponse()
+				return resp
+			}
+
+			It("hints at itself in the cookies of the sessions it pins", func() {
+				ln := registerHandlerWithInstanceId(r, "app", func(x *test_util.HttpConn) {
+					x.ReadRequest()
+
+					resp := test_util.NewResponse(http.StatusOK)
+					resp.Header.Add("Set-Cookie", (&http.Cookie{Name: StickyCookieKey, Value: "xxx"}).String())
+					x.WriteResponse(resp)
+					x.Close()
+				}, "instance-a")
+				defer ln.Close()
+
+				resp := send(nil, "")
+
+				var sticky *http.Cookie
+				for _, cookie := range resp.Cookies() {
+					if cookie.Name == VcapCookieId {
+						sticky = cookie
+					}
+				}
+				Ω(sticky).NotTo(BeNil())
+
+				id, hint, ok := stickysession.NewCodec(conf.StickySessions).DecodeHint(sticky.Value)
+				Ω(ok).To(BeTrue())
+				Ω(id).To(Equal("instance-a"))
+				Ω(hint).To(Equal("10.0.16.5:80"))
+			})
+
+			It("forwards sessions pinned to an instance it does not know to the router of the hint", func() {
+				ln := registerHandlerWithInstanceId(r, "app", func(x *test_util.HttpConn) {
+					x.ReadRequest()
+					x.WriteResponse(test_util.NewResponse(http.StatusNoContent))
+					x.Close()
+				}, "instance-a")
+				defer ln.Close()
+
+				resp := send(pinned("instance-gone", strings.TrimPrefix(peerServer.URL, "http://")), "")
+				Ω(resp.StatusCode).To(Equal(http.StatusOK))
+
+				var forwarded *http.Request
+				Eventually(received).Should(Receive(&forwarded))
+				Ω(forwarded.Host).To(Equal("app"))
+				Ω(forwarded.Header.Get(peer.HopsHeader)).To(Equal("1"))
+			})
+
+			It("serves sessions pinned to an instance it knows, and forwarded sessions, itself", func() {
+				ln := registerHandlerWithInstanceId(r, "app", func(x *test_util.HttpConn) {
+					x.ReadRequest()
+					x.WriteResponse(test_util.NewResponse(http.StatusNoContent))
+					x.Close()
+				}, "instance-a")
+				defer ln.Close()
+
+				peerAddr := strings.TrimPrefix(peerServer.URL, "http://")
+
+				resp := send(pinned("instance-a", peerAddr), "")
+				Ω(resp.StatusCode).To(Equal(http.StatusNoContent))
+
+				resp = send(pinned("instance-gone", peerAddr), "1")
+				Ω(resp.StatusCode).To(Equal(http.StatusNoContent))
+
+				Ω(received).ShouldNot(Receive())
+			})
+		})
+
+		Context("when configured with a session affinity header", func() {
+			BeforeEach(func() {
+				conf.SessionAffinity = config.SessionAffinityConfig{
+					Header: "X-Tenant-Id",
+					TTL:    time.Minute,
+				}
+			})
+
+			It("pins requests with the same header value to one instance", func() {
+				for _, id := range []string{"instance-a", "instance-b"} {
+					id := id
+					ln := registerHandlerWithInstanceId(r, "app", func(x *test_util.HttpConn) {
+						x.ReadRequest()
+
+						resp := test_util.NewResponse(http.StatusOK)
+						resp.Header.Set("X-Instance", id)
+						x.WriteResponse(resp)
+						x.Close()
+					}, id)
+					defer ln.Close()
+				}
+
+				send := func(tenant string) string {
+					x := dialProxy(proxyServer)
+					req := x.NewRequest("GET", "/", nil)
+					req.Host = "app"
+					if tenant != "" {
+						req.Header.Set("X-Tenant-Id", tenant)
+					}
+					x.WriteRequest(req)
+
+					resp, _ := x.ReadResponse()
+					Ω(resp.Cookies()).To(BeEmpty())
+					return resp.Header.Get("X-Instance")
+				}
+
+				instance := send("tenant-a")
+				for i := 0; i < 4; i++ {
+					Ω(send("tenant-a")).To(Equal(instance))
+				}
+
+				seen := map[string]bool{}
+				for i := 0; i < 4; i++ {
+					seen[send("")] = true
+				}
+				Ω(seen).To(HaveLen(2))
+			})
+		})
+
 		Context("when configured without secure cookies", func() {
 			BeforeEach(func() {
 				conf.SecureCookies = false
@@ -0,0 +1,29 @@
+package proxy
+
+import (
+	"time"
+
+	"github.com/cloudfoundry/gorouter/headerrules"
+	"github.com/cloudfoundry/gorouter/ipfilter"
+	"github.com/cloudfoundry/gorouter/ratelimit"
+)
+
+// ReloadArgs are the settings of a proxy that can change while it serves.
+// Each request is served with the settings that were current when it came
+// in.
+type ReloadArgs struct {
+	EndpointTimeout time.Duration
+	RateLimit       *ratelimit.Limiter
+	ClientRateLimit *ratelimit.ClientLimiter
+	ClientAccess    *ipfilter.Filter
+	HeaderRules     *headerrules.Rules
+	Duplicates      *headerrules.Duplicates
+}
+
+func (p *proxy) Reload(args ReloadArgs) {
+	p.reloadable.Store(&args)
+}
+
+func (p *proxy) settings() *ReloadArgs {
+	return p.reloadable.Load().(*ReloadArgs)
+}
@@ -0,0 +1,67 @@
+package proxy
+
+import (
+	"errors"
+	"io"
+
+	"github.com/cloudfoundry/gorouter/limits"
+)
+
+var requestBodyTooLarge = errors.New("Request body is too large")
+
+// limitedBody stops a request body that grows beyond its limit on the way
+// to the backend, for bodies whose size is not known in advance.
+type limitedBody struct {
+	io.ReadCloser
+
+	max   int64
+	limit *limits.Limit
+
+	size     int64
+	checked  bool
+	tooLarge bool
+}
+
+// newLimitedBody limits the body of a request to a route to the size the
+// route registered, which is always enforced, or else to the router's limit.
+// It returns nil when neither applies.
+func newLimitedBody(body io.ReadCloser, routeMax int64, limit *limits.Limit) *limitedBody {
+	if routeMax > 0 {
+		return &limitedBody{ReadCloser: body, max: routeMax}
+	}
+	if limit != nil {
+		return &limitedBody{ReadCloser: body, max: limit.Max(), limit: limit}
+	}
+	return nil
+}
+
+// Exceeds reports whether a body of size bytes is to be refused.
+func (b *limitedBody) Exceeds(size int64) bool {
+	if size <= b.max {
+		return false
+	}
+
+	b.checked = true
+	if b.limit == nil {
+		return true
+	}
+	return b.limit.Exceeded(size)
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.size += int64(n)
+
+	if !b.checked && b.Exceeds(b.size) {
+		b.tooLarge = true
+		return n, requestBodyTooLarge
+	}
+
+	return n, err
+}
+
+// TooLarge reports whether the body was stopped for growing beyond its
+// limit.
+func (b *limitedBody) TooLarge() bool {
+	return b != nil && b.tooLarge
+}
@@ -2,9 +2,11 @@ package proxy
 
 import (
 	"bufio"
+	"context"
 	"errors"
 	"fmt"
 	"io"
+	"math"
 	"net"
 	"net/http"
 	"strconv"
@@ -12,9 +14,19 @@ import (
 	"time"
 
 	"github.com/cloudfoundry/gorouter/access_log"
-	"github.com/cloudfoundry/gorouter/common"
+	"github.com/cloudfoundry/gorouter/cache"
+	"github.com/cloudfoundry/gorouter/clock"
+	"github.com/cloudfoundry/gorouter/common/correlation"
 	router_http "github.com/cloudfoundry/gorouter/common/http"
+	"github.com/cloudfoundry/gorouter/errorpages"
+	"github.com/cloudfoundry/gorouter/headerrules"
+	"github.com/cloudfoundry/gorouter/inspection"
+	"github.com/cloudfoundry/gorouter/jwtauth"
+	"github.com/cloudfoundry/gorouter/maintenance"
+	"github.com/cloudfoundry/gorouter/requestqueue"
 	"github.com/cloudfoundry/gorouter/route"
+	"github.com/cloudfoundry/gorouter/signedurl"
+	"github.com/cloudfoundry/gorouter/tracing"
 	steno "github.com/cloudfoundry/gosteno"
 )
 
@@ -22,6 +34,11 @@ type RequestHandler struct {
 	logger    *steno.Logger
 	reporter  ProxyReporter
 	logrecord *access_log.AccessLogRecord
+	span      *tracing.Span
+	clock     clock.Clock
+
+	headerRules *headerrules.Match
+	errorPages  *errorpages.Pages
 
 	request  *http.Request
 	response http.ResponseWriter
@@ -48,6 +65,13 @@ func createLogger(request *http.Request) *steno.Logger {
 	logger.Set("X-Forwarded-For", request.Header["X-Forwarded-For"])
 	logger.Set("X-Forwarded-Proto", request.Header["X-Forwarded-Proto"])
 
+	if id, ok := correlation.FromRequest(request); ok {
+		logger.Set(router_http.VcapRequestIdHeader, id.RequestId)
+		if id.TraceId != "" {
+			logger.Set("TraceId", id.TraceId)
+		}
+	}
+
 	return logger
 }
 
@@ -62,16 +86,41 @@ func (h *RequestHandler) HandleHeartbeat() {
 	h.request.Close = true
 }
 
+// HandleCacheHit answers the request from entry without asking a backend.
+func (h *RequestHandler) HandleCacheHit(entry *cache.Entry) {
+	res := entry.Response(h.request, h.clock.Now())
+	defer cache.Release(res)
+	defer res.Body.Close()
+
+	h.logger.Set("Cache", "hit")
+	h.logger.Debug("proxy.cache.hit")
+
+	for k, v := range res.Header {
+		h.response.Header()[k] = v
+	}
+	h.headerRules.Response(h.response.Header())
+
+	h.logrecord.StatusCode = res.StatusCode
+	h.response.WriteHeader(res.StatusCode)
+
+	buf := copyBuffers.Get()
+	n, _ := io.CopyBuffer(h.response, res.Body, buf)
+	copyBuffers.Put(buf)
+	h.logrecord.BodyBytesSent = n
+	h.logrecord.FinishedAt = h.clock.Now()
+}
+
 func (h *RequestHandler) HandleUnsupportedProtocol() {
 	// must be hijacked, otherwise no response is sent back
 	conn, buf, err := h.hijack()
 	if err != nil {
+		h.response.Header().Set("X-Cf-RouterError", "unsupported_protocol")
 		h.writeStatus(http.StatusBadRequest, "Unsupported protocol")
 		return
 	}
 
 	h.logrecord.StatusCode = http.StatusBadRequest
-	fmt.Fprintf(buf, "HTTP/1.0 400 Bad Request\r\n\r\n")
+	fmt.Fprintf(buf, "HTTP/1.0 400 Bad Request\r\nX-Cf-RouterError: unsupported_protocol\r\n\r\n")
 	buf.Flush()
 	conn.Close()
 }
@@ -84,12 +133,215 @@ func (h *RequestHandler) HandleMissingRoute() {
 	h.writeStatus(http.StatusNotFound, message)
 }
 
+// HandleMaintenance refuses a request to a route that operators put under
+// maintenance, with their message when they left one.
+func (h *RequestHandler) HandleMaintenance(route maintenance.Route) {
+	h.logger.Warnf("proxy.request.maintenance")
+
+	if route.RetryAfterSeconds > 0 {
+		h.setRetryAfter(time.Duration(route.RetryAfterSeconds) * time.Second)
+	}
+
+	message := route.Message
+	if message == "" {
+		message = "Route is under maintenance."
+	}
+
+	h.response.Header().Set("X-Cf-RouterError", "maintenance")
+	h.writeStatus(http.StatusServiceUnavailable, message)
+}
+
+func (h *RequestHandler) HandleMissingAuthorization() {
+	h.logger.Warnf("proxy.request.authorization-missing")
+
+	h.response.Header().Set("X-Cf-RouterError", "authorization_required")
+	h.writeStatus(http.StatusUnauthorized, "Route requires an Authorization header.")
+}
+
+// HandleRateLimited refuses a request to an application that is over its
+// rate limit, telling the client when to try again.
+func (h *RequestHandler) HandleRateLimited(retryAfter time.Duration) {
+	h.logger.Warnf("proxy.request.rate-limited")
+
+	h.setRetryAfter(retryAfter)
+	h.response.Header().Set("X-Cf-RouterError", "rate_limited")
+	h.writeStatus(http.StatusTooManyRequests, "Application is over its rate limit.")
+}
+
+// HandleClientRateLimited refuses a request of a client that is over its
+// rate limit, telling it when to try again.
+func (h *RequestHandler) HandleClientRateLimited(retryAfter time.Duration) {
+	h.logger.Warnf("proxy.request.client-rate-limited")
+
+	h.setRetryAfter(retryAfter)
+	h.response.Header().Set("X-Cf-RouterError", "client_rate_limited")
+	h.writeStatus(http.StatusTooManyRequests, "Client is over its rate limit.")
+}
+
+func (h *RequestHandler) setRetryAfter(retryAfter time.Duration) {
+	seconds := int64(math.Ceil(retryAfter.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+
+	h.response.Header().Set("Retry-After", strconv.FormatInt(seconds, 10))
+}
+
+// HandleClientDenied refuses a request of a client that the router, or the
+// route, does not allow.
+func (h *RequestHandler) HandleClientDenied() {
+	h.logger.Warnf("proxy.request.client-denied")
+
+	h.response.Header().Set("X-Cf-RouterError", "client_denied")
+	h.writeStatus(http.StatusForbidden, "Client is not allowed.")
+}
+
+// HandleInvalidSignedUrl refuses a request to a route that requires signed
+// URLs, when its URL is not signed, is tampered with or has expired.
+func (h *RequestHandler) HandleInvalidSignedUrl(err error) {
+	h.logger.Set("Error", err.Error())
+	h.logger.Warnf("proxy.request.signed-url-invalid")
+
+	if err == signedurl.ErrExpired {
+		h.response.Header().Set("X-Cf-RouterError", "signed_url_expired")
+		h.writeStatus(http.StatusForbidden, "Signed URL has expired.")
+		return
+	}
+
+	h.response.Header().Set("X-Cf-RouterError", "signed_url_invalid")
+	h.writeStatus(http.StatusForbidden, "Route requires a valid signed URL.")
+}
+
+// HandleInvalidJwt refuses a request to a route that requires a bearer
+// token, when it has none or its token is not valid.
+func (h *RequestHandler) HandleInvalidJwt(err error) {
+	h.logger.Set("Error", err.Error())
+	h.logger.Warnf("proxy.request.jwt-invalid")
+
+	if err == jwtauth.ErrMissing {
+		h.response.Header().Set("WWW-Authenticate", "Bearer")
+		h.response.Header().Set("X-Cf-RouterError", "jwt_required")
+		h.writeStatus(http.StatusUnauthorized, "Route requires a bearer token.")
+		return
+	}
+
+	h.response.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+	if err == jwtauth.ErrExpired {
+		h.response.Header().Set("X-Cf-RouterError", "jwt_expired")
+		h.writeStatus(http.StatusUnauthorized, "Bearer token has expired.")
+		return
+	}
+
+	h.response.Header().Set("X-Cf-RouterError", "jwt_invalid")
+	h.writeStatus(http.StatusUnauthorized, "Bearer token is invalid.")
+}
+
+func (h *RequestHandler) HandleRequestHeaderTooLarge() {
+	h.logger.Warnf("proxy.request.header-too-large")
+
+	h.response.Header().Set("X-Cf-RouterError", "request_header_too_large")
+	h.writeStatus(http.StatusRequestHeaderFieldsTooLarge, "Request header is too large.")
+}
+
+// HandleDuplicateHeader refuses a request that carries a header more than
+// once whose duplicates operators have rejected.
+func (h *RequestHandler) HandleDuplicateHeader(name string) {
+	h.logger.Set("Header", name)
+	h.logger.Warnf("proxy.request.duplicate-header")
+
+	h.response.Header().Set("X-Cf-RouterError", "duplicate_header")
+	h.writeStatus(http.StatusBadRequest, "Request header "+name+" must not appear more than once.")
+}
+
+// HandleRequestBodyTooLarge refuses a request whose body is larger than its
+// route or the router allows, whether its Content-Length says so up front or
+// the body turns out so while it is sent to the backend.
+func (h *RequestHandler) HandleRequestBodyTooLarge() {
+	h.logger.Warnf("proxy.request.body-too-large")
+
+	h.response.Header().Set("X-Cf-RouterError", "request_body_too_large")
+	h.writeStatus(http.StatusRequestEntityTooLarge, "Request body is too large.")
+}
+
+func (h *RequestHandler) HandleResponseHeaderTooLarge() {
+	h.logger.Warnf("proxy.response.header-too-large")
+
+	h.response.Header().Set("X-Cf-RouterError", "response_header_too_large")
+	h.writeStatus(http.StatusBadGateway, "Registered endpoint sent a response header that is too large.")
+}
+
+// HandleInspectionFailure rejects a request whose body could not be
+// inspected, or that an inspector refused.
+func (h *RequestHandler) HandleInspectionFailure(err error) {
+	h.logger.Set("Error", err.Error())
+	h.logger.Warnf("proxy.request.inspection-failed")
+
+	switch err {
+	case inspection.ErrBodyTooLarge, inspection.ErrDecompressionBomb:
+		h.response.Header().Set("X-Cf-RouterError", "request_body_too_large")
+		h.writeStatus(http.StatusRequestEntityTooLarge, "Request body is too large to inspect.")
+	case inspection.ErrUnsupportedEncoding:
+		h.response.Header().Set("X-Cf-RouterError", "unsupported_content_encoding")
+		h.writeStatus(http.StatusUnsupportedMediaType, "Request body has an unsupported content encoding.")
+	default:
+		h.response.Header().Set("X-Cf-RouterError", "request_rejected")
+		h.writeStatus(http.StatusForbidden, "Request was rejected by inspection.")
+	}
+}
+
+// HandleEndpointsAtCapacity refuses a request when every endpoint of its
+// route has as many requests in flight as the limit allows, rather than
+// pile more load onto them.
+func (h *RequestHandler) HandleEndpointsAtCapacity() {
+	h.logger.Warnf("proxy.endpoint.at-capacity")
+
+	h.response.Header().Set("X-Cf-RouterError", "endpoints_at_capacity")
+	h.writeStatus(http.StatusServiceUnavailable, "All registered endpoints are at capacity.")
+}
+
+// HandleQueueRejected refuses a request that the router, at its cap of
+// requests in flight, could not queue or did not get to in time.
+func (h *RequestHandler) HandleQueueRejected(err error) {
+	h.logger.Set("Error", err.Error())
+	h.logger.Warnf("proxy.request.queue-rejected")
+
+	h.setRetryAfter(time.Second)
+	if err == requestqueue.ErrFull {
+		h.response.Header().Set("X-Cf-RouterError", "queue_full")
+		h.writeStatus(http.StatusServiceUnavailable, "Router is at capacity.")
+		return
+	}
+
+	h.response.Header().Set("X-Cf-RouterError", "queue_timeout")
+	h.writeStatus(http.StatusServiceUnavailable, "Router did not get to the request in time.")
+}
+
+// HandleBadGateway answers a request that no endpoint answered, telling
+// apart a route without endpoints to try, an endpoint that did not respond
+// within its timeout, and one that failed otherwise.
 func (h *RequestHandler) HandleBadGateway(err error) {
 	h.logger.Set("Error", err.Error())
 	h.logger.Warnf("proxy.endpoint.failed")
 
-	h.response.Header().Set("X-Cf-RouterError", "endpoint_failure")
-	h.writeStatus(http.StatusBadGateway, "Registered endpoint failed to handle the request.")
+	switch {
+	case err == noEndpointsAvailable:
+		h.response.Header().Set("X-Cf-RouterError", "no_endpoints")
+		h.writeStatus(http.StatusBadGateway, "Route has no available endpoints.")
+	case isTimeout(err):
+		h.response.Header().Set("X-Cf-RouterError", "backend_timeout")
+		h.writeStatus(http.StatusBadGateway, "Registered endpoint did not respond in time.")
+	default:
+		h.response.Header().Set("X-Cf-RouterError", "endpoint_failure")
+		h.writeStatus(http.StatusBadGateway, "Registered endpoint failed to handle the request.")
+	}
+}
+
+func isTimeout(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var ne net.Error
+	return errors.As(err, &ne) && ne.Timeout()
 }
 
 func (h *RequestHandler) HandleTcpRequest(iter route.EndpointIterator) {
@@ -99,6 +351,7 @@ func (h *RequestHandler) HandleTcpRequest(iter route.EndpointIterator) {
 
 	err := h.serveTcp(iter)
 	if err != nil {
+		h.response.Header().Set("X-Cf-RouterError", "endpoint_failure")
 		h.writeStatus(http.StatusBadRequest, "TCP forwarding to endpoint failed.")
 	}
 }
@@ -110,6 +363,7 @@ func (h *RequestHandler) HandleWebSocketRequest(iter route.EndpointIterator) {
 
 	err := h.serveWebSocket(iter)
 	if err != nil {
+		h.response.Header().Set("X-Cf-RouterError", "endpoint_failure")
 		h.writeStatus(http.StatusBadRequest, "WebSocket request to endpoint failed.")
 	}
 }
@@ -120,12 +374,37 @@ func (h *RequestHandler) writeStatus(code int, message string) {
 	h.logger.Warn(body)
 	h.logrecord.StatusCode = code
 
-	http.Error(h.response, body, code)
+	if !h.writeErrorPage(code, message) {
+		http.Error(h.response, body, code)
+	}
 	if code > 299 {
 		h.response.Header().Del("Connection")
 	}
 }
 
+// writeErrorPage answers with the page operators configured for code, when
+// there is one the client accepts.
+func (h *RequestHandler) writeErrorPage(code int, message string) bool {
+	page, contentType, ok := h.errorPages.Render(h.request, errorpages.Data{
+		Status:     code,
+		StatusText: http.StatusText(code),
+		Message:    message,
+		Error:      h.response.Header().Get("X-Cf-RouterError"),
+		Host:       h.request.Host,
+		RequestId:  h.request.Header.Get(router_http.VcapRequestIdHeader),
+	})
+	if !ok {
+		return false
+	}
+
+	h.response.Header().Set("Content-Type", contentType)
+	h.response.Header().Set("X-Content-Type-Options", "nosniff")
+	h.response.Header().Add("Vary", "Accept")
+	h.response.WriteHeader(code)
+	h.response.Write(page)
+	return true
+}
+
 func (h *RequestHandler) serveTcp(iter route.EndpointIterator) error {
 	var err error
 	var connection net.Conn
@@ -142,6 +421,9 @@ func (h *RequestHandler) serveTcp(iter route.EndpointIterator) error {
 		}
 	}()
 
+	closeUpgraded := func() {}
+	defer func() { closeUpgraded() }()
+
 	retry := 0
 	for {
 		endpoint := iter.Next()
@@ -154,6 +436,9 @@ func (h *RequestHandler) serveTcp(iter route.EndpointIterator) error {
 
 		connection, err = net.DialTimeout("tcp", endpoint.CanonicalAddr(), 5*time.Second)
 		if err == nil {
+			// upgraded connections are in flight until they close, but do
+			// not count against in-flight limits
+			closeUpgraded = iter.Upgrade()
 			break
 		}
 
@@ -191,6 +476,9 @@ func (h *RequestHandler) serveWebSocket(iter route.EndpointIterator) error {
 		}
 	}()
 
+	closeUpgraded := func() {}
+	defer func() { closeUpgraded() }()
+
 	retry := 0
 	for {
 		endpoint := iter.Next()
@@ -203,6 +491,9 @@ func (h *RequestHandler) serveWebSocket(iter route.EndpointIterator) error {
 
 		connection, err = net.DialTimeout("tcp", endpoint.CanonicalAddr(), 5*time.Second)
 		if err == nil {
+			// upgraded connections are in flight until they close, but do
+			// not count against in-flight limits
+			closeUpgraded = iter.Upgrade()
 			h.setupRequest(endpoint)
 			break
 		}
@@ -232,8 +523,7 @@ func (h *RequestHandler) serveWebSocket(iter route.EndpointIterator) error {
 func (h *RequestHandler) setupRequest(endpoint *route.Endpoint) {
 	h.setRequestURL(endpoint.CanonicalAddr())
 	h.setRequestXForwardedFor()
-	setRequestXRequestStart(h.request)
-	setRequestXVcapRequestId(h.request, h.logger)
+	setRequestXRequestStart(h.request, h.clock.Now())
 }
 
 func (h *RequestHandler) setRequestURL(addr string) {
@@ -253,20 +543,111 @@ func (h *RequestHandler) setRequestXForwardedFor() {
 	}
 }
 
-func setRequestXRequestStart(request *http.Request) {
-	if _, ok := request.Header[http.CanonicalHeaderKey("X-Request-Start")]; !ok {
-		request.Header.Set("X-Request-Start", strconv.FormatInt(time.Now().UnixNano()/1e6, 10))
+// requestHeaderSize approximates the size of the request line and headers
+// as they were sent.
+func requestHeaderSize(request *http.Request) int64 {
+	size := len(request.Method) + len(request.RequestURI) + len(request.Proto) + 4
+	size += len("Host: ") + len(request.Host) + 2
+	for k, values := range request.Header {
+		for _, v := range values {
+			size += len(k) + len(v) + 4
+		}
+	}
+	return int64(size)
+}
+
+// Hop-by-hop headers describe a single connection and are not relayed,
+// nor are the headers that the Connection header names (RFC 7230, section
+// 6.1).
+var hopByHopHeaders = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Proxy-Connection",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+func removeHopByHopHeaders(header http.Header) {
+	for _, v := range header["Connection"] {
+		for _, token := range strings.Split(v, ",") {
+			if token = strings.TrimSpace(token); token != "" {
+				header.Del(token)
+			}
+		}
+	}
+
+	for _, h := range hopByHopHeaders {
+		header.Del(h)
+	}
+}
+
+// removeRequestHopByHopHeaders removes the hop-by-hop headers of a request
+// before the router adds its own, so that a client cannot have them removed
+// by naming them in the Connection header. The Connection and Upgrade
+// headers of a WebSocket or TCP request are kept, since the backend needs
+// them to switch protocols.
+func removeRequestHopByHopHeaders(request *http.Request) {
+	upgrade := upgradeHeader(request)
+	connection := request.Header["Connection"]
+
+	removeHopByHopHeaders(request.Header)
+
+	if upgrade != "" {
+		request.Header["Connection"] = connection
+		request.Header.Set("Upgrade", upgrade)
 	}
 }
 
-func setRequestXVcapRequestId(request *http.Request, logger *steno.Logger) {
-	uuid, err := common.GenerateUUID()
-	if err == nil {
-		request.Header.Set(router_http.VcapRequestIdHeader, uuid)
-		if logger != nil {
-			logger.Set(router_http.VcapRequestIdHeader, uuid)
+// responseHeaderSize approximates the size of the status line and headers
+// of a response as they were received.
+func responseHeaderSize(res *http.Response) int64 {
+	size := len(res.Proto) + len(res.Status) + 3
+	for k, values := range res.Header {
+		for _, v := range values {
+			size += len(k) + len(v) + 4
 		}
 	}
+	return int64(size)
+}
+
+func responseHeaderCount(res *http.Response) int64 {
+	count := 0
+	for _, values := range res.Header {
+		count += len(values)
+	}
+	return int64(count)
+}
+
+func setRequestXRequestStart(request *http.Request, now time.Time) {
+	if _, ok := request.Header[http.CanonicalHeaderKey("X-Request-Start")]; !ok {
+		request.Header.Set("X-Request-Start", strconv.FormatInt(now.UnixNano()/1e6, 10))
+	}
+}
+
+// setRequestB3Headers makes the router a span of the request's Zipkin
+// trace, starting a new trace when the client did not send one.
+func setRequestB3Headers(request *http.Request) {
+	spanId := tracing.NewSpanId().String()
+
+	traceId := request.Header.Get(router_http.B3TraceIdHeader)
+	if traceId == "" {
+		request.Header.Set(router_http.B3TraceIdHeader, tracing.NewTraceId().String())
+		request.Header.Set(router_http.B3SpanIdHeader, spanId)
+		request.Header.Del(router_http.B3ParentSpanIdHeader)
+		return
+	}
+
+	parentSpanId := request.Header.Get(router_http.B3SpanIdHeader)
+	if parentSpanId == "" {
+		request.Header.Del(router_http.B3ParentSpanIdHeader)
+	} else {
+		request.Header.Set(router_http.B3ParentSpanIdHeader, parentSpanId)
+	}
+	request.Header.Set(router_http.B3SpanIdHeader, spanId)
 }
 
 func setRequestXCfInstanceId(request *http.Request, endpoint *route.Endpoint) {
@@ -291,8 +672,10 @@ func forwardIO(a, b net.Conn) {
 	done := make(chan bool, 2)
 
 	copy := func(dst io.Writer, src io.Reader) {
+		buf := copyBuffers.Get()
 		// don't care about errors here
-		io.Copy(dst, src)
+		io.CopyBuffer(dst, src, buf)
+		copyBuffers.Put(buf)
 		done <- true
 	}
 
@@ -0,0 +1,95 @@
+package proxy
+
+import (
+	"net"
+	"time"
+
+	"github.com/cloudfoundry/gorouter/route"
+	steno "github.com/cloudfoundry/gosteno"
+)
+
+type TcpLookupRegistry interface {
+	LookupTcp(port uint16) *route.Pool
+}
+
+type TcpProxy interface {
+	Serve(listener net.Listener, port uint16) error
+}
+
+type tcpProxy struct {
+	registry TcpLookupRegistry
+}
+
+func NewTcpProxy(registry TcpLookupRegistry) TcpProxy {
+	return &tcpProxy{
+		registry: registry,
+	}
+}
+
+// Serve accepts connections on listener and forwards each of them to an
+// endpoint registered for port. It returns when the listener is closed.
+func (p *tcpProxy) Serve(listener net.Listener, port uint16) error {
+	for {
+		client, err := listener.Accept()
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				time.Sleep(5 * time.Millisecond)
+				continue
+			}
+			return err
+		}
+
+		go p.handle(client, port)
+	}
+}
+
+func (p *tcpProxy) handle(client net.Conn, port uint16) {
+	defer client.Close()
+
+	logger := steno.NewLogger("router.tcp-proxy")
+	logger.Set("RemoteAddr", client.RemoteAddr().String())
+	logger.Set("Port", port)
+
+	pool := p.registry.LookupTcp(port)
+	if pool == nil {
+		logger.Warn("proxy.tcp.route.not-found")
+		return
+	}
+
+	var backend net.Conn
+	var err error
+
+	iter := pool.Endpoints("")
+	defer iter.Done()
+
+	retry := 0
+	for {
+		endpoint := iter.Next()
+		if endpoint == nil {
+			logger.Warn("proxy.tcp.endpoint.not-found")
+			return
+		}
+
+		backend, err = net.DialTimeout("tcp", endpoint.CanonicalAddr(), 5*time.Second)
+		if err == nil {
+			// connections do not count against in-flight limits
+			iter.Done()
+			logger.Set("RouteEndpoint", endpoint.ToLogData())
+			break
+		}
+
+		iter.EndpointFailed()
+
+		logger.Set("Error", err.Error())
+		logger.Warn("proxy.tcp.failed")
+
+		retry++
+		if retry == retries {
+			return
+		}
+	}
+
+	defer backend.Close()
+
+	forwardIO(client, backend)
+}
@@ -0,0 +1,71 @@
+package proxy_test
+
+import (
+	"net"
+	"strconv"
+
+	"github.com/cloudfoundry/gorouter/config"
+	"github.com/cloudfoundry/gorouter/registry"
+	"github.com/cloudfoundry/gorouter/route"
+	"github.com/cloudfoundry/gorouter/test_util"
+	"github.com/cloudfoundry/yagnats/fakeyagnats"
+
+	. "github.com/cloudfoundry/gorouter/proxy"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("TcpProxy", func() {
+	var r *registry.RouteRegistry
+	var listener net.Listener
+
+	BeforeEach(func() {
+		c := config.DefaultConfig()
+		c.TcpRouting.Ports = []uint16{61000}
+
+		r = registry.NewRouteRegistry(c, fakeyagnats.Connect())
+
+		var err error
+		listener, err = net.Listen("tcp", "127.0.0.1:0")
+		Ω(err).NotTo(HaveOccurred())
+
+		go NewTcpProxy(r).Serve(listener, 61000)
+	})
+
+	AfterEach(func() {
+		listener.Close()
+	})
+
+	It("forwards the stream to an endpoint registered for the port", func() {
+		backend, err := net.Listen("tcp", "127.0.0.1:0")
+		Ω(err).NotTo(HaveOccurred())
+		defer backend.Close()
+
+		go func() {
+			defer GinkgoRecover()
+			conn, err := backend.Accept()
+			Ω(err).NotTo(HaveOccurred())
+
+			x := test_util.NewHttpConn(conn)
+			x.CheckLine("hello from client")
+			x.WriteLine("hello from server")
+			x.Close()
+		}()
+
+		h, p, _ := net.SplitHostPort(backend.Addr().String())
+		port, _ := strconv.Atoi(p)
+		r.RegisterTcp(61000, route.NewEndpoint("", h, uint16(port), "", nil, -1))
+
+		x := dialProxy(listener)
+		x.WriteLine("hello from client")
+		x.CheckLine("hello from server")
+		x.Close()
+	})
+
+	It("closes the connection when no route is registered for the port", func() {
+		x := dialProxy(listener)
+
+		_, err := x.Reader.ReadByte()
+		Ω(err).To(HaveOccurred())
+	})
+})
@@ -0,0 +1,272 @@
+package proxy
+
+import (
+	"errors"
+	"io"
+	"net"
+	"time"
+
+	"github.com/cloudfoundry/gorouter/route"
+	steno "github.com/cloudfoundry/gosteno"
+)
+
+const (
+	tlsRecordHeaderLen      = 5
+	tlsRecordTypeHandshake  = 22
+	tlsHandshakeClientHello = 1
+	tlsExtensionServerName  = 0
+	tlsServerNameTypeHost   = 0
+	tlsMaxRecordLen         = 16384 + 2048
+	tlsMaxClientHelloLen    = 64 * 1024
+	clientHelloReadTimeout  = 10 * time.Second
+)
+
+var noServerName = errors.New("ClientHello carries no server name")
+var malformedClientHello = errors.New("malformed ClientHello")
+
+type TlsPassthroughLookupRegistry interface {
+	LookupTlsPassthrough(uri route.Uri) *route.Pool
+}
+
+type TlsPassthroughProxy interface {
+	Serve(listener net.Listener) error
+}
+
+type tlsPassthroughProxy struct {
+	registry TlsPassthroughLookupRegistry
+}
+
+func NewTlsPassthroughProxy(registry TlsPassthroughLookupRegistry) TlsPassthroughProxy {
+	return &tlsPassthroughProxy{
+		registry: registry,
+	}
+}
+
+// Serve accepts TLS connections on listener without terminating them and
+// forwards each raw stream to an endpoint registered for the server name
+// the client asked for. It returns when the listener is closed.
+func (p *tlsPassthroughProxy) Serve(listener net.Listener) error {
+	for {
+		client, err := listener.Accept()
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				time.Sleep(5 * time.Millisecond)
+				continue
+			}
+			return err
+		}
+
+		go p.handle(client)
+	}
+}
+
+func (p *tlsPassthroughProxy) handle(client net.Conn) {
+	defer client.Close()
+
+	logger := steno.NewLogger("router.tls-passthrough-proxy")
+	logger.Set("RemoteAddr", client.RemoteAddr().String())
+
+	client.SetReadDeadline(time.Now().Add(clientHelloReadTimeout))
+	hello, serverName, err := readClientHello(client)
+	client.SetReadDeadline(time.Time{})
+	if err != nil {
+		logger.Set("Error", err.Error())
+		logger.Warn("proxy.tls-passthrough.client-hello.failed")
+		return
+	}
+
+	logger.Set("ServerName", serverName)
+
+	pool := p.registry.LookupTlsPassthrough(route.Uri(serverName))
+	if pool == nil {
+		logger.Warn("proxy.tls-passthrough.route.not-found")
+		return
+	}
+
+	var backend net.Conn
+
+	iter := pool.Endpoints("")
+	defer iter.Done()
+
+	retry := 0
+	for {
+		endpoint := iter.Next()
+		if endpoint == nil {
+			logger.Warn("proxy.tls-passthrough.endpoint.not-found")
+			return
+		}
+
+		backend, err = net.DialTimeout("tcp", endpoint.CanonicalAddr(), 5*time.Second)
+		if err == nil {
+			// connections do not count against in-flight limits
+			iter.Done()
+			logger.Set("RouteEndpoint", endpoint.ToLogData())
+			break
+		}
+
+		iter.EndpointFailed()
+
+		logger.Set("Error", err.Error())
+		logger.Warn("proxy.tls-passthrough.failed")
+
+		retry++
+		if retry == retries {
+			return
+		}
+	}
+
+	defer backend.Close()
+
+	// Replay the ClientHello that was consumed to find the server name
+	_, err = backend.Write(hello)
+	if err != nil {
+		logger.Set("Error", err.Error())
+		logger.Warn("proxy.tls-passthrough.failed")
+		return
+	}
+
+	forwardIO(client, backend)
+}
+
+// readClientHello reads the TLS records from r that carry the ClientHello,
+// which clients with large key shares split across records, and returns
+// their raw bytes along with the SNI server name found in the ClientHello.
+func readClientHello(r io.Reader) ([]byte, string, error) {
+	var raw, hello []byte
+	for {
+		header := make([]byte, tlsRecordHeaderLen)
+		_, err := io.ReadFull(r, header)
+		if err != nil {
+			return nil, "", err
+		}
+
+		if header[0] != tlsRecordTypeHandshake {
+			return append(raw, header...), "", malformedClientHello
+		}
+
+		length := int(header[3])<<8 | int(header[4])
+		if length > tlsMaxRecordLen || len(raw)+tlsRecordHeaderLen+length > tlsMaxClientHelloLen {
+			return append(raw, header...), "", malformedClientHello
+		}
+
+		record := make([]byte, tlsRecordHeaderLen+length)
+		copy(record, header)
+		_, err = io.ReadFull(r, record[tlsRecordHeaderLen:])
+		if err != nil {
+			return nil, "", err
+		}
+
+		raw = append(raw, record...)
+		hello = append(hello, record[tlsRecordHeaderLen:]...)
+
+		// handshake type (1), length (3)
+		if len(hello) < 4 {
+			continue
+		}
+		helloLen := 4 + (int(hello[1])<<16 | int(hello[2])<<8 | int(hello[3]))
+		if helloLen > tlsMaxClientHelloLen {
+			return raw, "", malformedClientHello
+		}
+		if len(hello) >= helloLen {
+			serverName, err := parseServerName(hello[:helloLen])
+			return raw, serverName, err
+		}
+	}
+}
+
+func parseServerName(b []byte) (string, error) {
+	// handshake type (1), length (3), client version (2), random (32)
+	if len(b) < 38 || b[0] != tlsHandshakeClientHello {
+		return "", malformedClientHello
+	}
+	b = b[38:]
+
+	// session id
+	b, ok := skipVector(b, 1)
+	if !ok {
+		return "", malformedClientHello
+	}
+
+	// cipher suites
+	b, ok = skipVector(b, 2)
+	if !ok {
+		return "", malformedClientHello
+	}
+
+	// compression methods
+	b, ok = skipVector(b, 1)
+	if !ok {
+		return "", malformedClientHello
+	}
+
+	if len(b) < 2 {
+		return "", noServerName
+	}
+	extensionsLen := int(b[0])<<8 | int(b[1])
+	b = b[2:]
+	if len(b) < extensionsLen {
+		return "", malformedClientHello
+	}
+	b = b[:extensionsLen]
+
+	for len(b) >= 4 {
+		extType := int(b[0])<<8 | int(b[1])
+		extLen := int(b[2])<<8 | int(b[3])
+		b = b[4:]
+		if len(b) < extLen {
+			return "", malformedClientHello
+		}
+
+		if extType == tlsExtensionServerName {
+			return parseServerNameExtension(b[:extLen])
+		}
+
+		b = b[extLen:]
+	}
+
+	return "", noServerName
+}
+
+func parseServerNameExtension(b []byte) (string, error) {
+	if len(b) < 2 {
+		return "", malformedClientHello
+	}
+	b = b[2:]
+
+	for len(b) >= 3 {
+		nameType := b[0]
+		nameLen := int(b[1])<<8 | int(b[2])
+		b = b[3:]
+		if len(b) < nameLen {
+			return "", malformedClientHello
+		}
+
+		if nameType == tlsServerNameTypeHost {
+			return string(b[:nameLen]), nil
+		}
+
+		b = b[nameLen:]
+	}
+
+	return "", noServerName
+}
+
+// skipVector drops a length-prefixed vector whose length is encoded in
+// lenBytes bytes from the front of b.
+func skipVector(b []byte, lenBytes int) ([]byte, bool) {
+	if len(b) < lenBytes {
+		return nil, false
+	}
+
+	n := 0
+	for i := 0; i < lenBytes; i++ {
+		n = n<<8 | int(b[i])
+	}
+	b = b[lenBytes:]
+
+	if len(b) < n {
+		return nil, false
+	}
+
+	return b[n:], true
+}
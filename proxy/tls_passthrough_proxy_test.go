@@ -0,0 +1,140 @@
+package proxy_test
+
+import (
+	"bufio"
+	"crypto/tls"
+	"net"
+	"strconv"
+
+	"github.com/cloudfoundry/gorouter/config"
+	"github.com/cloudfoundry/gorouter/registry"
+	"github.com/cloudfoundry/gorouter/route"
+	"github.com/cloudfoundry/yagnats/fakeyagnats"
+
+	. "github.com/cloudfoundry/gorouter/proxy"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// fragmentingConn splits the first TLS record it writes, the ClientHello,
+// into records of at most size bytes, as clients with large key shares do.
+type fragmentingConn struct {
+	net.Conn
+	size    int
+	written bool
+}
+
+func (c *fragmentingConn) Write(b []byte) (int, error) {
+	if c.written {
+		return c.Conn.Write(b)
+	}
+	c.written = true
+
+	length := int(b[3])<<8 | int(b[4])
+	body, rest := b[5:5+length], b[5+length:]
+
+	var records []byte
+	for len(body) > 0 {
+		n := c.size
+		if n > len(body) {
+			n = len(body)
+		}
+		records = append(records, b[0], b[1], b[2], byte(n>>8), byte(n))
+		records = append(records, body[:n]...)
+		body = body[n:]
+	}
+
+	_, err := c.Conn.Write(append(records, rest...))
+	return len(b), err
+}
+
+var _ = Describe("TlsPassthroughProxy", func() {
+	var r *registry.RouteRegistry
+	var listener, backend net.Listener
+
+	BeforeEach(func() {
+		r = registry.NewRouteRegistry(config.DefaultConfig(), fakeyagnats.Connect())
+
+		var err error
+		listener, err = net.Listen("tcp", "127.0.0.1:0")
+		Ω(err).NotTo(HaveOccurred())
+
+		go NewTlsPassthroughProxy(r).Serve(listener)
+
+		cert, err := tls.LoadX509KeyPair("../test/assets/public.pem", "../test/assets/private.pem")
+		Ω(err).NotTo(HaveOccurred())
+
+		backend, err = tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}})
+		Ω(err).NotTo(HaveOccurred())
+
+		go func() {
+			for {
+				conn, err := backend.Accept()
+				if err != nil {
+					return
+				}
+
+				go func() {
+					defer conn.Close()
+					line, _ := bufio.NewReader(conn).ReadString('\n')
+					conn.Write([]byte("backend got " + line))
+				}()
+			}
+		}()
+
+		h, p, _ := net.SplitHostPort(backend.Addr().String())
+		port, _ := strconv.Atoi(p)
+		r.RegisterTlsPassthrough("*.example.com", route.NewEndpoint("", h, uint16(port), "", nil, -1))
+	})
+
+	AfterEach(func() {
+		listener.Close()
+		backend.Close()
+	})
+
+	It("forwards the TLS stream to the endpoint matching the server name", func() {
+		conn, err := tls.Dial("tcp", listener.Addr().String(), &tls.Config{
+			ServerName:         "secure.example.com",
+			InsecureSkipVerify: true,
+		})
+		Ω(err).NotTo(HaveOccurred())
+		defer conn.Close()
+
+		conn.Write([]byte("hello\n"))
+		line, err := bufio.NewReader(conn).ReadString('\n')
+		Ω(err).NotTo(HaveOccurred())
+		Ω(line).To(Equal("backend got hello\n"))
+	})
+
+	It("reads a ClientHello split across records", func() {
+		raw, err := net.Dial("tcp", listener.Addr().String())
+		Ω(err).NotTo(HaveOccurred())
+
+		conn := tls.Client(&fragmentingConn{Conn: raw, size: 16}, &tls.Config{
+			ServerName:         "secure.example.com",
+			InsecureSkipVerify: true,
+		})
+		defer conn.Close()
+
+		conn.Write([]byte("hello\n"))
+		line, err := bufio.NewReader(conn).ReadString('\n')
+		Ω(err).NotTo(HaveOccurred())
+		Ω(line).To(Equal("backend got hello\n"))
+	})
+
+	It("closes the connection when no route matches the server name", func() {
+		_, err := tls.Dial("tcp", listener.Addr().String(), &tls.Config{
+			ServerName:         "unknown.example.org",
+			InsecureSkipVerify: true,
+		})
+		Ω(err).To(HaveOccurred())
+	})
+
+	It("closes the connection when the client does not speak TLS", func() {
+		x := dialProxy(listener)
+		x.WriteLine("GET / HTTP/1.1")
+
+		_, err := x.Reader.ReadByte()
+		Ω(err).To(HaveOccurred())
+	})
+})
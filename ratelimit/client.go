@@ -0,0 +1,157 @@
+package ratelimit
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/cloudfoundry/gorouter/clock"
+	"github.com/cloudfoundry/gorouter/config"
+	steno "github.com/cloudfoundry/gosteno"
+)
+
+// ClientLimiter limits the requests of each client IP address, except for
+// the clients in its allowlist. A nil client limiter allows everything.
+type ClientLimiter struct {
+	limiter   *Limiter
+	allowlist []*net.IPNet
+}
+
+// NewClientLimiter returns the client limiter configured by c, with the
+// time of clk, or nil when client rate limiting is disabled.
+func NewClientLimiter(c config.ClientLimitsConfig, clk clock.Clock) *ClientLimiter {
+	limiter := New(c.RateLimit, clk)
+	if limiter == nil {
+		return nil
+	}
+
+	return &ClientLimiter{
+		limiter:   limiter,
+		allowlist: c.AllowedNetworks,
+	}
+}
+
+// Allow takes a request of the client at ip. When the client is over its
+// limit it returns false and how long it takes until its next request would
+// be allowed.
+func (c *ClientLimiter) Allow(ip net.IP) (bool, time.Duration) {
+	if c == nil {
+		return true, 0
+	}
+
+	if allowlisted(c.allowlist, ip) {
+		return true, 0
+	}
+
+	return c.limiter.Allow(ip.String())
+}
+
+// LimitListener caps the connections each client IP address may hold open
+// on l, as configured by c. Connections over the cap are closed as soon as
+// they are accepted. It returns l itself when no cap is configured.
+func LimitListener(l net.Listener, c config.ClientLimitsConfig) net.Listener {
+	if c.MaxConnectionsPerIp <= 0 {
+		return l
+	}
+
+	return &limitListener{
+		Listener:  l,
+		max:       c.MaxConnectionsPerIp,
+		allowlist: c.AllowedNetworks,
+		conns:     make(map[string]int),
+		logger:    steno.NewLogger("router.ratelimit"),
+	}
+}
+
+type limitListener struct {
+	net.Listener
+
+	max       int
+	allowlist []*net.IPNet
+	logger    *steno.Logger
+
+	lock  sync.Mutex
+	conns map[string]int
+}
+
+func (l *limitListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+
+		ip := clientIP(conn.RemoteAddr().String())
+		if allowlisted(l.allowlist, ip) {
+			return conn, nil
+		}
+
+		key := ip.String()
+		if l.acquire(key) {
+			return &limitedConn{Conn: conn, listener: l, key: key}, nil
+		}
+
+		l.logger.Debugd(map[string]interface{}{"client": key}, "ratelimit.connection.refused")
+		conn.Close()
+	}
+}
+
+func (l *limitListener) acquire(key string) bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if l.conns[key] >= l.max {
+		return false
+	}
+	l.conns[key]++
+	return true
+}
+
+func (l *limitListener) release(key string) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.conns[key]--
+	if l.conns[key] <= 0 {
+		delete(l.conns, key)
+	}
+}
+
+// limitedConn gives its place back to its client when it is closed, also
+// after it has been hijacked.
+type limitedConn struct {
+	net.Conn
+
+	listener *limitListener
+	key      string
+	once     sync.Once
+}
+
+func (c *limitedConn) Close() error {
+	err := c.Conn.Close()
+	c.once.Do(func() {
+		c.listener.release(c.key)
+	})
+	return err
+}
+
+func clientIP(remoteAddr string) net.IP {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	return net.ParseIP(host)
+}
+
+func allowlisted(allowlist []*net.IPNet, ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+
+	for _, network := range allowlist {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,145 @@
+package ratelimit_test
+
+import (
+	"net"
+	"time"
+
+	"github.com/cloudfoundry/gorouter/clock/fakeclock"
+	"github.com/cloudfoundry/gorouter/config"
+	. "github.com/cloudfoundry/gorouter/ratelimit"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ClientLimiter", func() {
+	var c config.ClientLimitsConfig
+
+	BeforeEach(func() {
+		_, network, err := net.ParseCIDR("10.0.0.0/8")
+		Ω(err).NotTo(HaveOccurred())
+
+		c = config.ClientLimitsConfig{
+			RateLimit: config.RateLimitConfig{
+				Enabled:           true,
+				RequestsPerSecond: 1,
+				Burst:             1,
+			},
+			AllowedNetworks: []*net.IPNet{network},
+		}
+	})
+
+	It("limits each client address on its own", func() {
+		limiter := NewClientLimiter(c, fakeclock.New(time.Now()))
+
+		allowed, _ := limiter.Allow(net.ParseIP("192.168.1.1"))
+		Ω(allowed).To(BeTrue())
+
+		allowed, retryAfter := limiter.Allow(net.ParseIP("192.168.1.1"))
+		Ω(allowed).To(BeFalse())
+		Ω(retryAfter).To(Equal(time.Second))
+
+		allowed, _ = limiter.Allow(net.ParseIP("192.168.1.2"))
+		Ω(allowed).To(BeTrue())
+	})
+
+	It("does not limit allowlisted clients", func() {
+		limiter := NewClientLimiter(c, fakeclock.New(time.Now()))
+
+		for i := 0; i < 5; i++ {
+			allowed, _ := limiter.Allow(net.ParseIP("10.1.2.3"))
+			Ω(allowed).To(BeTrue())
+		}
+	})
+
+	It("is nil when client rate limiting is disabled", func() {
+		c.RateLimit.Enabled = false
+		Ω(NewClientLimiter(c, fakeclock.New(time.Now()))).To(BeNil())
+	})
+})
+
+var _ = Describe("LimitListener", func() {
+	var ln net.Listener
+	var accepted chan net.Conn
+
+	listen := func(c config.ClientLimitsConfig) {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		Ω(err).NotTo(HaveOccurred())
+
+		ln = LimitListener(l, c)
+		accepted = make(chan net.Conn, 10)
+
+		go func() {
+			for {
+				conn, err := ln.Accept()
+				if err != nil {
+					return
+				}
+				accepted <- conn
+			}
+		}()
+	}
+
+	dial := func() net.Conn {
+		conn, err := net.Dial("tcp", ln.Addr().String())
+		Ω(err).NotTo(HaveOccurred())
+		return conn
+	}
+
+	// refused reports whether the listener closed conn instead of handing
+	// it over
+	refused := func(conn net.Conn) bool {
+		conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+		_, err := conn.Read(make([]byte, 1))
+		ne, ok := err.(net.Error)
+		return !ok || !ne.Timeout()
+	}
+
+	AfterEach(func() {
+		ln.Close()
+	})
+
+	It("closes connections over the cap of their client", func() {
+		listen(config.ClientLimitsConfig{MaxConnectionsPerIp: 1})
+
+		first := dial()
+		defer first.Close()
+		var server net.Conn
+		Eventually(accepted).Should(Receive(&server))
+
+		second := dial()
+		defer second.Close()
+		Ω(refused(second)).To(BeTrue())
+		Consistently(accepted).ShouldNot(Receive())
+
+		server.Close()
+
+		third := dial()
+		defer third.Close()
+		Eventually(accepted).Should(Receive())
+		Ω(refused(third)).To(BeFalse())
+	})
+
+	It("does not cap allowlisted clients", func() {
+		_, network, err := net.ParseCIDR("127.0.0.1/32")
+		Ω(err).NotTo(HaveOccurred())
+		listen(config.ClientLimitsConfig{
+			MaxConnectionsPerIp: 1,
+			AllowedNetworks:     []*net.IPNet{network},
+		})
+
+		for i := 0; i < 3; i++ {
+			conn := dial()
+			defer conn.Close()
+			Eventually(accepted).Should(Receive())
+		}
+	})
+
+	It("returns the listener itself without a cap", func() {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		Ω(err).NotTo(HaveOccurred())
+		ln = l
+
+		Ω(LimitListener(l, config.ClientLimitsConfig{})).To(Equal(l))
+	})
+})
@@ -0,0 +1,96 @@
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/cloudfoundry/gorouter/clock"
+	"github.com/cloudfoundry/gorouter/config"
+)
+
+// Buckets that have filled up again are forgotten this often, so that keys
+// which are no longer seen do not pile up.
+const pruneInterval = time.Minute
+
+// Limiter allows each key a steady rate of requests, with bursts of up to
+// a number of requests above it. A nil limiter allows everything.
+type Limiter struct {
+	sync.Mutex
+
+	rate  float64
+	burst float64
+	clock clock.Clock
+
+	buckets   map[string]*bucket
+	lastPrune time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// New returns the limiter configured by c, which refills its buckets by the
+// time of clk, or nil when rate limiting is disabled.
+func New(c config.RateLimitConfig, clk clock.Clock) *Limiter {
+	if !c.Enabled {
+		return nil
+	}
+
+	return &Limiter{
+		rate:      c.RequestsPerSecond,
+		burst:     float64(c.Burst),
+		clock:     clk,
+		buckets:   make(map[string]*bucket),
+		lastPrune: clk.Now(),
+	}
+}
+
+// Allow takes a request from the bucket of key. When the bucket is empty
+// it returns false and how long it takes until the next request would be
+// allowed.
+func (l *Limiter) Allow(key string) (bool, time.Duration) {
+	if l == nil {
+		return true, 0
+	}
+
+	now := l.clock.Now()
+
+	l.Lock()
+	defer l.Unlock()
+
+	if now.Sub(l.lastPrune) > pruneInterval {
+		l.prune(now)
+	}
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	} else {
+		b.tokens = l.fill(b, now)
+		b.last = now
+	}
+
+	if b.tokens < 1 {
+		wait := (1 - b.tokens) / l.rate
+		return false, time.Duration(math.Ceil(wait * float64(time.Second)))
+	}
+
+	b.tokens--
+	return true, 0
+}
+
+func (l *Limiter) fill(b *bucket, now time.Time) float64 {
+	return math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+}
+
+func (l *Limiter) prune(now time.Time) {
+	for key, b := range l.buckets {
+		if l.fill(b, now) >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+	l.lastPrune = now
+}
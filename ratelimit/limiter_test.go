@@ -0,0 +1,70 @@
+package ratelimit_test
+
+import (
+	"time"
+
+	"github.com/cloudfoundry/gorouter/clock/fakeclock"
+	"github.com/cloudfoundry/gorouter/config"
+	. "github.com/cloudfoundry/gorouter/ratelimit"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Limiter", func() {
+	var limiter *Limiter
+	var clock *fakeclock.FakeClock
+
+	allow := func(key string) bool {
+		allowed, _ := limiter.Allow(key)
+		return allowed
+	}
+
+	BeforeEach(func() {
+		clock = fakeclock.New(time.Now())
+		limiter = New(config.RateLimitConfig{
+			Enabled:           true,
+			RequestsPerSecond: 10,
+			Burst:             2,
+		}, clock)
+	})
+
+	It("allows a burst of requests", func() {
+		Ω(allow("app")).To(BeTrue())
+		Ω(allow("app")).To(BeTrue())
+
+		allowed, retryAfter := limiter.Allow("app")
+		Ω(allowed).To(BeFalse())
+		Ω(retryAfter).To(Equal(100 * time.Millisecond))
+	})
+
+	It("allows requests again at its rate", func() {
+		allow("app")
+		allow("app")
+		Ω(allow("app")).To(BeFalse())
+
+		clock.Increment(50 * time.Millisecond)
+		Ω(allow("app")).To(BeFalse())
+
+		clock.Increment(50 * time.Millisecond)
+		Ω(allow("app")).To(BeTrue())
+		Ω(allow("app")).To(BeFalse())
+	})
+
+	It("limits each key on its own", func() {
+		allow("app")
+		allow("app")
+		Ω(allow("app")).To(BeFalse())
+
+		Ω(allow("other-app")).To(BeTrue())
+	})
+
+	It("is nil when disabled", func() {
+		limiter = New(config.RateLimitConfig{RequestsPerSecond: 10, Burst: 2}, clock)
+		Ω(limiter).To(BeNil())
+
+		for i := 0; i < 10; i++ {
+			Ω(allow("app")).To(BeTrue())
+		}
+	})
+})
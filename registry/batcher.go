@@ -0,0 +1,118 @@
+package registry
+
+import (
+	"sync"
+
+	"github.com/cloudfoundry/gorouter/config"
+	"github.com/cloudfoundry/gorouter/route"
+)
+
+// A Batcher applies the updates it is given to a registry in batches. An
+// update that arrives while the registry is idle is applied right away,
+// and those that arrive while a batch is being applied are applied together
+// in the next one, so that a storm of registrations holds up lookups once
+// per batch rather than once per update.
+type Batcher struct {
+	registry *RouteRegistry
+	maxSize  int
+
+	updates  chan Update
+	stopCh   chan struct{}
+	stopOnce sync.Once
+}
+
+func NewBatcher(r *RouteRegistry, c config.RegistrationBatchConfig) *Batcher {
+	return &Batcher{
+		registry: r,
+		maxSize:  c.MaxSize,
+
+		updates: make(chan Update, c.QueueSize),
+		stopCh:  make(chan struct{}),
+	}
+}
+
+func (b *Batcher) Register(uri route.Uri, endpoint *route.Endpoint) {
+	b.Add(Update{Operation: OperationRegister, Uri: uri, Endpoint: endpoint})
+}
+
+func (b *Batcher) Unregister(uri route.Uri, endpoint *route.Endpoint) {
+	b.Add(Update{Operation: OperationUnregister, Uri: uri, Endpoint: endpoint})
+}
+
+func (b *Batcher) RegisterTlsPassthrough(uri route.Uri, endpoint *route.Endpoint) {
+	b.Add(Update{Operation: OperationRegister, Uri: uri, TlsPassthrough: true, Endpoint: endpoint})
+}
+
+func (b *Batcher) UnregisterTlsPassthrough(uri route.Uri, endpoint *route.Endpoint) {
+	b.Add(Update{Operation: OperationUnregister, Uri: uri, TlsPassthrough: true, Endpoint: endpoint})
+}
+
+func (b *Batcher) RegisterTcp(port uint16, endpoint *route.Endpoint) {
+	b.Add(Update{Operation: OperationRegister, Port: port, Tcp: true, Endpoint: endpoint})
+}
+
+func (b *Batcher) UnregisterTcp(port uint16, endpoint *route.Endpoint) {
+	b.Add(Update{Operation: OperationUnregister, Port: port, Tcp: true, Endpoint: endpoint})
+}
+
+// Add queues u for the next batch, waiting while the queue is full. Once
+// the batcher is stopped, u is applied right away.
+func (b *Batcher) Add(u Update) {
+	select {
+	case <-b.stopCh:
+		b.registry.Apply([]Update{u})
+		return
+	default:
+	}
+
+	select {
+	case b.updates <- u:
+	case <-b.stopCh:
+		b.registry.Apply([]Update{u})
+	}
+}
+
+func (b *Batcher) Start() {
+	go b.run()
+}
+
+// Stop applies the updates that are queued and stops batching.
+func (b *Batcher) Stop() {
+	b.stopOnce.Do(func() {
+		close(b.stopCh)
+	})
+}
+
+func (b *Batcher) run() {
+	batch := make([]Update, 0, b.maxSize)
+
+	for {
+		select {
+		case u := <-b.updates:
+			batch = b.fill(append(batch[:0], u))
+			b.registry.Apply(batch)
+		case <-b.stopCh:
+			for {
+				batch = b.fill(batch[:0])
+				if len(batch) == 0 {
+					return
+				}
+				b.registry.Apply(batch)
+			}
+		}
+	}
+}
+
+// fill adds the updates that are queued to batch, up to the maximum size of
+// a batch, without waiting for more.
+func (b *Batcher) fill(batch []Update) []Update {
+	for len(batch) < b.maxSize {
+		select {
+		case u := <-b.updates:
+			batch = append(batch, u)
+		default:
+			return batch
+		}
+	}
+	return batch
+}
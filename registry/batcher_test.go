@@ -0,0 +1,93 @@
+package registry_test
+
+import (
+	. "github.com/cloudfoundry/gorouter/registry"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/cloudfoundry/gorouter/config"
+	"github.com/cloudfoundry/gorouter/route"
+	"github.com/cloudfoundry/yagnats/fakeyagnats"
+)
+
+var _ = Describe("Batcher", func() {
+	var (
+		r        *RouteRegistry
+		reporter *fakeControlPlaneReporter
+		batcher  *Batcher
+
+		fooEndpoint *route.Endpoint
+	)
+
+	BeforeEach(func() {
+		c := config.DefaultConfig()
+		c.TcpRouting.Ports = []uint16{61000}
+
+		r = NewRouteRegistry(c, fakeyagnats.Connect())
+		reporter = &fakeControlPlaneReporter{}
+		r.SetReporter(reporter)
+
+		batcher = NewBatcher(r, config.RegistrationBatchConfig{MaxSize: 2, QueueSize: 10})
+		fooEndpoint = route.NewEndpoint("12345", "192.168.1.1", 1234, "id1", nil, -1)
+	})
+
+	AfterEach(func() {
+		batcher.Stop()
+	})
+
+	updates := func() []string {
+		reporter.Lock()
+		defer reporter.Unlock()
+		return append([]string(nil), reporter.updates...)
+	}
+
+	It("applies what arrives while the registry is busy in batches of up to the maximum size", func() {
+		batcher.Register("foo", fooEndpoint)
+		batcher.RegisterTcp(61000, fooEndpoint)
+		batcher.RegisterTlsPassthrough("secure.example.com", fooEndpoint)
+
+		batcher.Start()
+
+		Eventually(updates).Should(Equal([]string{"batch", "batch"}))
+		Ω(r.Lookup("foo")).NotTo(BeNil())
+		Ω(r.LookupTcp(61000)).NotTo(BeNil())
+		Ω(r.LookupTlsPassthrough("secure.example.com")).NotTo(BeNil())
+	})
+
+	It("applies updates in the order they arrived", func() {
+		batcher.Register("foo", fooEndpoint)
+		batcher.Unregister("foo", fooEndpoint)
+		batcher.Register("bar", fooEndpoint)
+
+		batcher.Start()
+
+		Eventually(func() *route.Pool { return r.Lookup("bar") }).ShouldNot(BeNil())
+		Ω(r.Lookup("foo")).To(BeNil())
+	})
+
+	It("applies what is queued when stopped, and what arrives after right away", func() {
+		batcher.Register("foo", fooEndpoint)
+		batcher.Start()
+		batcher.Stop()
+
+		Eventually(func() *route.Pool { return r.Lookup("foo") }).ShouldNot(BeNil())
+
+		batcher.Unregister("foo", fooEndpoint)
+		Ω(r.Lookup("foo")).To(BeNil())
+	})
+
+	Describe("RouteRegistry.Apply", func() {
+		It("applies every update under one lock", func() {
+			r.Apply([]Update{
+				{Operation: OperationRegister, Uri: "foo", Endpoint: fooEndpoint},
+				{Operation: OperationRegister, Uri: "*.bad*", Endpoint: fooEndpoint},
+				{Operation: OperationRegister, Port: 61000, Tcp: true, Endpoint: fooEndpoint},
+				{Operation: OperationUnregister, Port: 61000, Tcp: true, Endpoint: fooEndpoint},
+			})
+
+			Ω(r.NumUris()).To(Equal(1))
+			Ω(r.NumTcpPorts()).To(BeZero())
+			Ω(updates()).To(Equal([]string{"batch"}))
+		})
+	})
+})
@@ -0,0 +1,135 @@
+package registry
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/cloudfoundry/gorouter/clock"
+	"github.com/cloudfoundry/gorouter/config"
+	"github.com/cloudfoundry/gorouter/route"
+)
+
+var invalidCutoverPercent = errors.New("cutover percent must be between 0 and 100")
+
+// Cutover sends a configurable share of the requests for a domain to routes
+// from a secondary source, so a domain can be migrated gradually.
+type Cutover struct {
+	sync.RWMutex
+
+	source   *RouteRegistry
+	percents map[string]int
+	random   clock.Random
+}
+
+type cutoverRamp struct {
+	Domain  string `json:"domain"`
+	Percent int    `json:"percent"`
+}
+
+func NewCutover(source *RouteRegistry, domains []config.CutoverDomainConfig) (*Cutover, error) {
+	c := &Cutover{
+		source:   source,
+		percents: make(map[string]int),
+		random:   clock.NewRandom(),
+	}
+
+	for _, d := range domains {
+		if err := c.SetPercent(d.Domain, d.Percent); err != nil {
+			return nil, err
+		}
+	}
+
+	return c, nil
+}
+
+func (c *Cutover) SetPercent(domain string, percent int) error {
+	if percent < 0 || percent > 100 {
+		return invalidCutoverPercent
+	}
+
+	c.Lock()
+	c.percents[strings.ToLower(domain)] = percent
+	c.Unlock()
+
+	return nil
+}
+
+func (c *Cutover) Percent(uri route.Uri) (int, bool) {
+	host := string(uri.ToLower())
+
+	c.RLock()
+	defer c.RUnlock()
+
+	// The most specific matching domain wins
+	match := ""
+	for domain := range c.percents {
+		if len(domain) > len(match) && (host == domain || strings.HasSuffix(host, "."+domain)) {
+			match = domain
+		}
+	}
+
+	if match == "" {
+		return 0, false
+	}
+
+	return c.percents[match], true
+}
+
+// lookup returns the pool from the secondary source when uri belongs to a
+// cutover domain and the request falls within its ramp. Otherwise it
+// returns nil and the caller uses its own pool.
+func (c *Cutover) lookup(uri route.Uri) *route.Pool {
+	percent, found := c.Percent(uri)
+	if !found || percent == 0 {
+		return nil
+	}
+
+	if c.random.Intn(100) >= percent {
+		return nil
+	}
+
+	return c.source.Lookup(uri)
+}
+
+func (c *Cutover) MarshalJSON() ([]byte, error) {
+	c.RLock()
+	defer c.RUnlock()
+
+	ramps := make([]cutoverRamp, 0, len(c.percents))
+	for domain, percent := range c.percents {
+		ramps = append(ramps, cutoverRamp{Domain: domain, Percent: percent})
+	}
+
+	return json.Marshal(ramps)
+}
+
+// ServeHTTP lists the configured ramps on GET and updates the ramp of a
+// single domain on PUT with a body of {"domain": ..., "percent": ...}.
+func (c *Cutover) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case "GET":
+	case "PUT":
+		var ramp cutoverRamp
+		err := json.NewDecoder(req.Body).Decode(&ramp)
+		if err == nil && ramp.Domain == "" {
+			err = errors.New("cutover domain is required")
+		}
+		if err == nil {
+			err = c.SetPercent(ramp.Domain, ramp.Percent)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(c)
+}
@@ -0,0 +1,113 @@
+package registry_test
+
+import (
+	. "github.com/cloudfoundry/gorouter/registry"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/cloudfoundry/gorouter/config"
+	"github.com/cloudfoundry/gorouter/route"
+	"github.com/cloudfoundry/yagnats/fakeyagnats"
+
+	"net/http"
+	"net/http/httptest"
+	"strings"
+)
+
+var _ = Describe("Cutover", func() {
+	var legacy, source *RouteRegistry
+	var legacyEndpoint, sourceEndpoint *route.Endpoint
+	var cutover *Cutover
+
+	BeforeEach(func() {
+		configObj := config.DefaultConfig()
+		legacy = NewRouteRegistry(configObj, fakeyagnats.Connect())
+		source = NewRouteRegistry(configObj, fakeyagnats.Connect())
+
+		legacyEndpoint = route.NewEndpoint("", "192.168.1.1", 1234, "", nil, -1)
+		sourceEndpoint = route.NewEndpoint("", "192.168.1.2", 1234, "", nil, -1)
+
+		legacy.Register("app.example.com", legacyEndpoint)
+		source.Register("app.example.com", sourceEndpoint)
+
+		var err error
+		cutover, err = legacy.EnableCutover(source, []config.CutoverDomainConfig{
+			{Domain: "example.com", Percent: 0},
+		})
+		Ω(err).NotTo(HaveOccurred())
+	})
+
+	lookupAddr := func() string {
+		return legacy.Lookup("app.example.com").Endpoints("").Next().CanonicalAddr()
+	}
+
+	It("uses the legacy routes at 0 percent", func() {
+		for i := 0; i < 20; i++ {
+			Ω(lookupAddr()).To(Equal("192.168.1.1:1234"))
+		}
+	})
+
+	It("uses the new source at 100 percent", func() {
+		Ω(cutover.SetPercent("example.com", 100)).To(Succeed())
+
+		for i := 0; i < 20; i++ {
+			Ω(lookupAddr()).To(Equal("192.168.1.2:1234"))
+		}
+	})
+
+	It("falls back to the legacy routes when the new source has none", func() {
+		Ω(cutover.SetPercent("example.com", 100)).To(Succeed())
+		legacy.Register("other.example.com", legacyEndpoint)
+
+		p := legacy.Lookup("other.example.com")
+		Ω(p).ShouldNot(BeNil())
+		Ω(p.Endpoints("").Next()).To(Equal(legacyEndpoint))
+	})
+
+	It("prefers the most specific domain", func() {
+		cutover.SetPercent("app.example.com", 40)
+
+		percent, found := cutover.Percent("app.example.com")
+		Ω(found).To(BeTrue())
+		Ω(percent).To(Equal(40))
+
+		_, found = cutover.Percent("example.org")
+		Ω(found).To(BeFalse())
+	})
+
+	It("rejects invalid percentages", func() {
+		Ω(cutover.SetPercent("example.com", 101)).ToNot(Succeed())
+	})
+
+	It("is not enabled with an invalid percentage", func() {
+		_, err := legacy.EnableCutover(source, []config.CutoverDomainConfig{
+			{Domain: "example.com", Percent: 100},
+			{Domain: "example.org", Percent: -1},
+		})
+		Ω(err).To(HaveOccurred())
+		Ω(legacy.Cutover()).To(Equal(cutover))
+	})
+
+	Describe("ServeHTTP", func() {
+		It("updates a ramp", func() {
+			body := strings.NewReader(`{"domain":"example.com","percent":50}`)
+			req, _ := http.NewRequest("PUT", "/cutover", body)
+			w := httptest.NewRecorder()
+
+			cutover.ServeHTTP(w, req)
+
+			Ω(w.Code).To(Equal(http.StatusOK))
+			Ω(w.Body.String()).To(MatchJSON(`[{"domain":"example.com","percent":50}]`))
+		})
+
+		It("rejects an invalid ramp", func() {
+			body := strings.NewReader(`{"domain":"example.com","percent":500}`)
+			req, _ := http.NewRequest("PUT", "/cutover", body)
+			w := httptest.NewRecorder()
+
+			cutover.ServeHTTP(w, req)
+
+			Ω(w.Code).To(Equal(http.StatusBadRequest))
+		})
+	})
+})
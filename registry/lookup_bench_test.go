@@ -0,0 +1,56 @@
+package registry_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/cloudfoundry/gorouter/config"
+	"github.com/cloudfoundry/gorouter/registry"
+	"github.com/cloudfoundry/gorouter/route"
+	"github.com/cloudfoundry/yagnats/fakeyagnats"
+)
+
+// BenchmarkLookup looks up routes from every CPU among 200000 of them
+// while routes keep being registered and unregistered, as they are during
+// a storm of registrations:
+//
+//	go test -run='^$' -bench=Lookup -cpu=1,8 ./registry
+func BenchmarkLookup(b *testing.B) {
+	r := registry.NewRouteRegistry(config.DefaultConfig(), fakeyagnats.Connect())
+	endpoint := route.NewEndpoint("", "10.0.0.1", 8080, "", nil, -1)
+	var updates []registry.Update
+	for i := 0; i < 200000; i++ {
+		uri := route.Uri(fmt.Sprintf("app-%d.example.com", i))
+		updates = append(updates, registry.Update{Operation: registry.OperationRegister, Uri: uri, Endpoint: endpoint})
+	}
+	r.Apply(updates)
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+
+			uri := route.Uri(fmt.Sprintf("new-app-%d.example.com", i%1000))
+			r.Apply([]registry.Update{
+				{Operation: registry.OperationRegister, Uri: uri, Endpoint: endpoint},
+				{Operation: registry.OperationUnregister, Uri: uri, Endpoint: endpoint},
+			})
+		}
+	}()
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			if r.Lookup(route.Uri(fmt.Sprintf("app-%d.example.com", i%200000))) == nil {
+				b.Fatal("route not found")
+			}
+			i++
+		}
+	})
+}
@@ -0,0 +1,55 @@
+package registry_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/cloudfoundry/gorouter/config"
+	"github.com/cloudfoundry/gorouter/registry"
+	"github.com/cloudfoundry/gorouter/route"
+	"github.com/cloudfoundry/yagnats/fakeyagnats"
+)
+
+// FuzzLookup looks up the hosts of requests, which come straight from
+// clients, among exact and wildcard routes. Lookups must not panic, must
+// ignore case, and must find the wildcard route for any subdomain of it
+// that has no route of its own.
+func FuzzLookup(f *testing.F) {
+	f.Add("foo.example.com")
+	f.Add("FOO.Example.COM")
+	f.Add("a.b.c.wild.example.com")
+	f.Add("*.wild.example.com")
+	f.Add("..wild.example.com")
+	f.Add("")
+	f.Add(".")
+	f.Add("[::1]")
+
+	r := registry.NewRouteRegistry(config.DefaultConfig(), fakeyagnats.Connect())
+	exact := route.NewEndpoint("", "10.0.0.1", 8080, "", nil, -1)
+	wildcard := route.NewEndpoint("", "10.0.0.2", 8080, "", nil, -1)
+	r.Register("foo.example.com", exact)
+	r.Register("*.wild.example.com", wildcard)
+
+	f.Fuzz(func(t *testing.T, host string) {
+		pool := r.Lookup(route.Uri(host))
+
+		lower := strings.ToLower(host)
+		upper := strings.ToUpper(host)
+		if strings.ToLower(upper) == lower && r.Lookup(route.Uri(upper)) != pool {
+			t.Fatalf("lookup of %q depends on case", host)
+		}
+
+		switch {
+		case lower == "foo.example.com":
+			if pool != r.Lookup("foo.example.com") {
+				t.Fatalf("%q is not routed to foo.example.com", host)
+			}
+		case strings.HasSuffix(lower, ".wild.example.com"):
+			if pool != r.Lookup("*.wild.example.com") {
+				t.Fatalf("%q is not routed to *.wild.example.com", host)
+			}
+		case pool != nil:
+			t.Fatalf("%q is routed without a route", host)
+		}
+	})
+}
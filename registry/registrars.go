@@ -0,0 +1,200 @@
+package registry
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+
+	steno "github.com/cloudfoundry/gosteno"
+
+	"github.com/cloudfoundry/gorouter/clock"
+	"github.com/cloudfoundry/gorouter/config"
+	"github.com/cloudfoundry/gorouter/ratelimit"
+	"github.com/cloudfoundry/gorouter/route"
+)
+
+const (
+	OperationRegister   = "register"
+	OperationUnregister = "unregister"
+)
+
+// NATS messages name their origin themselves, so only this many sources are
+// told apart, and the messages of any further ones are counted as those of
+// OtherRegistrars.
+const (
+	maxRegistrars   = 256
+	OtherRegistrars = "other"
+)
+
+// rateWindow is the number of seconds that the rate of a source is
+// averaged over.
+const rateWindow = 60
+
+// RegistrarStats are the registrations and unregistrations a source of
+// routes sent, those that were dropped for exceeding its rate limit, and
+// the messages per second it sent over the last minute.
+type RegistrarStats struct {
+	Registrations   int64   `json:"registrations"`
+	Unregistrations int64   `json:"unregistrations"`
+	RateLimited     int64   `json:"rate_limited"`
+	Rate            float64 `json:"rate"`
+}
+
+// Registrars counts the messages of every source of routes and limits them
+// to the rate of the source, so that a misbehaving registrar cannot flood
+// the registry unnoticed. A nil Registrars counts and limits nothing.
+type Registrars struct {
+	clock  clock.Clock
+	logger *steno.Logger
+
+	defaultLimiter *ratelimit.Limiter
+	limiters       map[string]*ratelimit.Limiter
+
+	lock    sync.Mutex
+	sources map[string]*registrar
+}
+
+type registrar struct {
+	stats   RegistrarStats
+	limited bool
+
+	// messages of each of the last seconds, by the second modulo the window
+	seconds  [rateWindow]int64
+	messages [rateWindow]int64
+}
+
+func NewRegistrars(c config.RegistrationLimitsConfig, clk clock.Clock) *Registrars {
+	r := &Registrars{
+		clock:  clk,
+		logger: steno.NewLogger("router.registrars"),
+
+		defaultLimiter: ratelimit.New(c.Default, clk),
+		limiters:       make(map[string]*ratelimit.Limiter),
+
+		sources: make(map[string]*registrar),
+	}
+
+	// a source whose limit is not enabled gets a nil limiter, which allows
+	// everything
+	for source, l := range c.Sources {
+		r.limiters[source] = ratelimit.New(l, clk)
+	}
+
+	return r
+}
+
+// Allow counts a message of source, which registers or unregisters a route
+// as operation tells, and tells whether it is within the rate of source.
+func (r *Registrars) Allow(source, operation string) bool {
+	if r == nil {
+		return true
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	s, ok := r.sources[source]
+	if !ok {
+		if len(r.sources) >= maxRegistrars {
+			source = OtherRegistrars
+		}
+		s, ok = r.sources[source]
+		if !ok {
+			s = &registrar{}
+			r.sources[source] = s
+		}
+	}
+
+	limiter, ok := r.limiters[source]
+	if !ok {
+		limiter = r.defaultLimiter
+	}
+
+	allowed, _ := limiter.Allow(source)
+	if !allowed {
+		s.stats.RateLimited++
+		if !s.limited {
+			s.limited = true
+			r.logger.Warnd(map[string]interface{}{"source": source}, "registrar.rate_limited")
+		}
+		return false
+	}
+	s.limited = false
+
+	if operation == OperationUnregister {
+		s.stats.Unregistrations++
+	} else {
+		s.stats.Registrations++
+	}
+
+	now := r.clock.Now().Unix()
+	i := now % rateWindow
+	if s.seconds[i] != now {
+		s.seconds[i] = now
+		s.messages[i] = 0
+	}
+	s.messages[i]++
+
+	return true
+}
+
+// Stats returns the stats of every source that sent a message.
+func (r *Registrars) Stats() map[string]RegistrarStats {
+	if r == nil {
+		return nil
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	now := r.clock.Now().Unix()
+	stats := make(map[string]RegistrarStats, len(r.sources))
+	for source, s := range r.sources {
+		var messages int64
+		for i := range s.seconds {
+			if now-s.seconds[i] < rateWindow {
+				messages += s.messages[i]
+			}
+		}
+
+		st := s.stats
+		st.Rate = float64(messages) / rateWindow
+		stats[source] = st
+	}
+	return stats
+}
+
+// ServeHTTP serves the stats of the sources on the status port.
+func (r *Registrars) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(r.Stats())
+}
+
+// Wrap returns registry as source sees it: its registrations and
+// unregistrations are counted, and dropped when they exceed the rate of
+// source.
+func (r *Registrars) Wrap(source string, registry RegistryInterface) RegistryInterface {
+	if r == nil {
+		return registry
+	}
+	return &sourceRegistry{RegistryInterface: registry, source: source, registrars: r}
+}
+
+type sourceRegistry struct {
+	RegistryInterface
+
+	source     string
+	registrars *Registrars
+}
+
+func (s *sourceRegistry) Register(uri route.Uri, endpoint *route.Endpoint) {
+	if s.registrars.Allow(s.source, OperationRegister) {
+		s.RegistryInterface.Register(uri, endpoint)
+	}
+}
+
+func (s *sourceRegistry) Unregister(uri route.Uri, endpoint *route.Endpoint) {
+	if s.registrars.Allow(s.source, OperationUnregister) {
+		s.RegistryInterface.Unregister(uri, endpoint)
+	}
+}
@@ -0,0 +1,129 @@
+package registry_test
+
+import (
+	. "github.com/cloudfoundry/gorouter/registry"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/cloudfoundry/gorouter/clock/fakeclock"
+	"github.com/cloudfoundry/gorouter/config"
+	"github.com/cloudfoundry/gorouter/route"
+	"github.com/cloudfoundry/yagnats/fakeyagnats"
+
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"time"
+)
+
+var _ = Describe("Registrars", func() {
+	var (
+		c          config.RegistrationLimitsConfig
+		fakeTime   *fakeclock.FakeClock
+		registrars *Registrars
+	)
+
+	BeforeEach(func() {
+		c = config.DefaultConfig().RegistrationLimits
+		fakeTime = fakeclock.New(time.Now())
+	})
+
+	JustBeforeEach(func() {
+		registrars = NewRegistrars(c, fakeTime)
+	})
+
+	It("counts the messages of every source", func() {
+		Ω(registrars.Allow("nats/cloud_controller", OperationRegister)).To(BeTrue())
+		Ω(registrars.Allow("nats/cloud_controller", OperationRegister)).To(BeTrue())
+		Ω(registrars.Allow("nats/cloud_controller", OperationUnregister)).To(BeTrue())
+		Ω(registrars.Allow("admin_api", OperationRegister)).To(BeTrue())
+
+		stats := registrars.Stats()
+		Ω(stats).To(HaveLen(2))
+		Ω(stats["nats/cloud_controller"].Registrations).To(Equal(int64(2)))
+		Ω(stats["nats/cloud_controller"].Unregistrations).To(Equal(int64(1)))
+		Ω(stats["admin_api"].Registrations).To(Equal(int64(1)))
+	})
+
+	It("reports the rate of a source over the last minute", func() {
+		for i := 0; i < 30; i++ {
+			registrars.Allow("consul", OperationRegister)
+			fakeTime.Increment(time.Second)
+		}
+		Ω(registrars.Stats()["consul"].Rate).To(Equal(0.5))
+
+		fakeTime.Increment(time.Minute)
+		Ω(registrars.Stats()["consul"].Rate).To(Equal(0.0))
+		Ω(registrars.Stats()["consul"].Registrations).To(Equal(int64(30)))
+	})
+
+	It("counts the messages of too many sources as others", func() {
+		for i := 0; i < 300; i++ {
+			registrars.Allow(fmt.Sprintf("nats/%d", i), OperationRegister)
+		}
+
+		stats := registrars.Stats()
+		Ω(stats).To(HaveLen(257))
+		Ω(stats[OtherRegistrars].Registrations).To(Equal(int64(44)))
+	})
+
+	Context("when the default limit is enabled", func() {
+		BeforeEach(func() {
+			c.Default = config.RateLimitConfig{Enabled: true, RequestsPerSecond: 0.001, Burst: 2}
+			c.Sources = map[string]config.RateLimitConfig{
+				"etcd": {Enabled: false},
+			}
+		})
+
+		It("drops the messages of a source over its limit", func() {
+			Ω(registrars.Allow("nats/flood", OperationRegister)).To(BeTrue())
+			Ω(registrars.Allow("nats/flood", OperationRegister)).To(BeTrue())
+			Ω(registrars.Allow("nats/flood", OperationRegister)).To(BeFalse())
+
+			Ω(registrars.Allow("nats/calm", OperationRegister)).To(BeTrue())
+
+			stats := registrars.Stats()
+			Ω(stats["nats/flood"].Registrations).To(Equal(int64(2)))
+			Ω(stats["nats/flood"].RateLimited).To(Equal(int64(1)))
+			Ω(stats["nats/calm"].RateLimited).To(BeZero())
+		})
+
+		It("exempts the sources whose own limit is not enabled", func() {
+			for i := 0; i < 10; i++ {
+				Ω(registrars.Allow("etcd", OperationRegister)).To(BeTrue())
+			}
+		})
+
+		It("drops what a wrapped registry is sent over the limit", func() {
+			r := NewRouteRegistry(config.DefaultConfig(), fakeyagnats.Connect())
+			wrapped := registrars.Wrap("consul", r)
+
+			wrapped.Register("a.example.com", route.NewEndpoint("", "192.168.1.1", 1234, "", nil, -1))
+			wrapped.Register("b.example.com", route.NewEndpoint("", "192.168.1.1", 1234, "", nil, -1))
+			wrapped.Register("c.example.com", route.NewEndpoint("", "192.168.1.1", 1234, "", nil, -1))
+
+			Ω(r.Lookup("a.example.com")).NotTo(BeNil())
+			Ω(r.Lookup("b.example.com")).NotTo(BeNil())
+			Ω(r.Lookup("c.example.com")).To(BeNil())
+			Ω(registrars.Stats()["consul"].RateLimited).To(Equal(int64(1)))
+		})
+	})
+
+	It("serves the stats of the sources", func() {
+		registrars.Allow("kubernetes", OperationUnregister)
+
+		w := httptest.NewRecorder()
+		registrars.ServeHTTP(w, &http.Request{})
+
+		var stats map[string]RegistrarStats
+		Ω(json.Unmarshal(w.Body.Bytes(), &stats)).To(Succeed())
+		Ω(stats["kubernetes"].Unregistrations).To(Equal(int64(1)))
+	})
+
+	It("counts and limits nothing when nil", func() {
+		var nilRegistrars *Registrars
+		Ω(nilRegistrars.Allow("nats", OperationRegister)).To(BeTrue())
+		Ω(nilRegistrars.Stats()).To(BeNil())
+	})
+})
@@ -2,13 +2,17 @@ package registry
 
 import (
 	"encoding/json"
+	"net/http"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	steno "github.com/cloudfoundry/gosteno"
 	"github.com/cloudfoundry/yagnats"
 
+	"github.com/cloudfoundry/gorouter/clock"
 	"github.com/cloudfoundry/gorouter/config"
+	"github.com/cloudfoundry/gorouter/limits"
 	"github.com/cloudfoundry/gorouter/route"
 )
 
@@ -23,20 +27,56 @@ type RegistryInterface interface {
 	MarshalJSON() ([]byte, error)
 }
 
+// ControlPlaneReporter receives the timings of route table updates so that
+// operators can tell when the control plane is falling behind.
+type ControlPlaneReporter interface {
+	CaptureRegistryUpdate(operation string, d time.Duration)
+	CaptureRoutePruning(d time.Duration, pruned int)
+	CaptureNatsMessage(subject string, d time.Duration)
+}
+
 type RouteRegistry struct {
+	// numHttpRoutes is first so that it is aligned for atomic access.
+	numHttpRoutes int64
+
 	sync.RWMutex
 
 	logger *steno.Logger
 
-	byUri map[route.Uri]*route.Pool
+	shards [numShards]*shard
+	byPort map[uint16]*route.Pool
+	bySni  map[route.Uri]*route.Pool
+
+	tcpPorts map[uint16]bool
 
 	pruneStaleDropletsInterval time.Duration
 	dropletStaleThreshold      time.Duration
 
 	messageBus yagnats.NATSConn
 
-	ticker           *time.Ticker
+	clock            clock.Clock
+	random           clock.Random
+	ticker           clock.Ticker
+	updateLock       sync.Mutex
 	timeOfLastUpdate time.Time
+
+	cutover    atomic.Value // *Cutover
+	reporter   ControlPlaneReporter
+	registrars *Registrars
+
+	routeLimit    *limits.Limit
+	inFlightLimit *limits.Limit
+	healthPolicy  *route.HealthPolicy
+	failbackDelay time.Duration
+
+	versionPolicies map[route.Uri]route.VersionPolicy
+}
+
+// A matchedPool holds the endpoints of a route registered with one match.
+type matchedPool struct {
+	match route.Match
+	key   string
+	pool  *route.Pool
 }
 
 func NewRouteRegistry(c *config.Config, mbus yagnats.NATSConn) *RouteRegistry {
@@ -44,60 +84,370 @@ func NewRouteRegistry(c *config.Config, mbus yagnats.NATSConn) *RouteRegistry {
 
 	r.logger = steno.NewLogger("router.registry")
 
-	r.byUri = make(map[route.Uri]*route.Pool)
+	for i := range r.shards {
+		r.shards[i] = newShard()
+	}
+	r.byPort = make(map[uint16]*route.Pool)
+	r.bySni = make(map[route.Uri]*route.Pool)
+	r.versionPolicies = make(map[route.Uri]route.VersionPolicy)
+
+	r.tcpPorts = make(map[uint16]bool)
+	for _, port := range c.TcpRouting.Ports {
+		r.tcpPorts[port] = true
+	}
 
 	r.pruneStaleDropletsInterval = c.PruneStaleDropletsInterval
 	r.dropletStaleThreshold = c.DropletStaleThreshold
 
 	r.messageBus = mbus
 
+	r.clock = clock.New()
+	r.random = clock.NewRandom()
+
+	r.routeLimit = limits.New("routes", c.Limits.Routes)
+	r.inFlightLimit = limits.New("endpoint_in_flight", c.Limits.EndpointInFlight)
+	r.failbackDelay = c.FailbackDelay
+
+	if c.CircuitBreaker.Enabled {
+		r.healthPolicy = &route.HealthPolicy{
+			ConsecutiveFailures: c.CircuitBreaker.ConsecutiveFailures,
+			FailureRate:         float64(c.CircuitBreaker.FailureRatePercent) / 100,
+			RequestVolume:       c.CircuitBreaker.RequestVolume,
+			BaseEjection:        c.CircuitBreaker.BaseEjectionTime,
+			MaxEjection:         c.CircuitBreaker.MaxEjectionTime,
+		}
+	}
+
 	return r
 }
 
+// An Update registers or unregisters an endpoint: for the host Uri, for the
+// SNI server name Uri when TlsPassthrough is set, or for Port when Tcp is.
+type Update struct {
+	Operation      string
+	Uri            route.Uri
+	Port           uint16
+	Tcp            bool
+	TlsPassthrough bool
+	Endpoint       *route.Endpoint
+}
+
 func (r *RouteRegistry) Register(uri route.Uri, endpoint *route.Endpoint) {
-	t := time.Now()
-	r.Lock()
+	r.update(Update{Operation: OperationRegister, Uri: uri, Endpoint: endpoint})
+}
+
+func (r *RouteRegistry) Unregister(uri route.Uri, endpoint *route.Endpoint) {
+	r.update(Update{Operation: OperationUnregister, Uri: uri, Endpoint: endpoint})
+}
 
+// RegisterTlsPassthrough registers an endpoint that terminates TLS itself for
+// connections whose SNI server name matches uri.
+func (r *RouteRegistry) RegisterTlsPassthrough(uri route.Uri, endpoint *route.Endpoint) {
+	r.update(Update{Operation: OperationRegister, Uri: uri, TlsPassthrough: true, Endpoint: endpoint})
+}
+
+func (r *RouteRegistry) UnregisterTlsPassthrough(uri route.Uri, endpoint *route.Endpoint) {
+	r.update(Update{Operation: OperationUnregister, Uri: uri, TlsPassthrough: true, Endpoint: endpoint})
+}
+
+func (r *RouteRegistry) RegisterTcp(port uint16, endpoint *route.Endpoint) {
+	r.update(Update{Operation: OperationRegister, Port: port, Tcp: true, Endpoint: endpoint})
+}
+
+func (r *RouteRegistry) UnregisterTcp(port uint16, endpoint *route.Endpoint) {
+	r.update(Update{Operation: OperationUnregister, Port: port, Tcp: true, Endpoint: endpoint})
+}
+
+func (r *RouteRegistry) update(u Update) {
+	defer r.captureUpdate(u.Operation, time.Now())
+
+	r.apply([]Update{u})
+}
+
+// Apply applies updates in order, locking every shard of the HTTP routes
+// they update once for all of them rather than once for each, so that
+// lookups wait once per batch.
+func (r *RouteRegistry) Apply(updates []Update) {
+	if len(updates) == 0 {
+		return
+	}
+	defer r.captureUpdate("batch", time.Now())
+
+	r.apply(updates)
+}
+
+func (r *RouteRegistry) apply(updates []Update) {
+	t := r.clock.Now()
+
+	var byShard [numShards][]Update
+	var others []Update
+	for _, u := range updates {
+		if u.Tcp || u.TlsPassthrough {
+			others = append(others, u)
+			continue
+		}
+		u.Uri = u.Uri.ToLower()
+		i := shardIndex(u.Uri)
+		byShard[i] = append(byShard[i], u)
+	}
+
+	if len(others) > 0 {
+		r.Lock()
+		for _, u := range others {
+			r.applyOther(u, t)
+		}
+		r.Unlock()
+	}
+
+	r.RLock()
+	for i, updates := range byShard {
+		if len(updates) == 0 {
+			continue
+		}
+
+		s := r.shards[i]
+		s.Lock()
+		for _, u := range updates {
+			r.applyHttp(s, u, t)
+		}
+		s.publish()
+		s.Unlock()
+	}
+	r.RUnlock()
+}
+
+// applyOther applies u, an update of a TCP or a TLS passthrough route, at
+// time t. r must be locked.
+func (r *RouteRegistry) applyOther(u Update, t time.Time) {
+	register := u.Operation == OperationRegister
+
+	switch {
+	case u.Tcp && register && !r.validPort(u.Port):
+	case u.Tcp && register:
+		r.registerTcp(u.Port, u.Endpoint, t)
+	case u.Tcp:
+		r.unregisterTcp(u.Port, u.Endpoint)
+	case register && r.valid(u.Uri):
+		r.registerSni(u.Uri, u.Endpoint, t)
+	case !register:
+		r.unregisterSni(u.Uri, u.Endpoint)
+	}
+}
+
+// valid tells whether uri can be registered, logging the uris that cannot,
+// such as wildcards other than a leading "*.".
+func (r *RouteRegistry) valid(uri route.Uri) bool {
+	if uri.IsValid() {
+		return true
+	}
+
+	r.logger.Warnd(map[string]interface{}{"uri": uri}, "registry.register.invalid-uri")
+	return false
+}
+
+// validPort tells whether TCP routes can be registered on port, logging the
+// ports that cannot: those the router does not listen on for TCP routes.
+func (r *RouteRegistry) validPort(port uint16) bool {
+	if r.tcpPorts[port] {
+		return true
+	}
+
+	r.logger.Warnd(map[string]interface{}{"port": port}, "registry.register.invalid-port")
+	return false
+}
+
+func (r *RouteRegistry) registerSni(uri route.Uri, endpoint *route.Endpoint, t time.Time) {
 	uri = uri.ToLower()
 
-	pool, found := r.byUri[uri]
+	pool, found := r.bySni[uri]
 	if !found {
-		pool = route.NewPool(r.dropletStaleThreshold / 4)
-		r.byUri[uri] = pool
+		if r.routeLimit.Exceeded(int64(len(r.bySni) + 1)) {
+			r.logger.Warnd(map[string]interface{}{"uri": uri}, "registry.register.route-limit")
+			return
+		}
+
+		pool = r.newPool()
+		pool.SetVersionPolicy(r.versionPolicies[uri])
+		r.bySni[uri] = pool
 	}
 
 	pool.Put(endpoint)
 
-	r.timeOfLastUpdate = t
-	r.Unlock()
+	r.setTimeOfLastUpdate(t)
 }
 
-func (r *RouteRegistry) Unregister(uri route.Uri, endpoint *route.Endpoint) {
-	r.Lock()
-
+func (r *RouteRegistry) unregisterSni(uri route.Uri, endpoint *route.Endpoint) {
 	uri = uri.ToLower()
 
-	pool, found := r.byUri[uri]
+	pool, found := r.bySni[uri]
 	if found {
 		pool.Remove(endpoint)
 
 		if pool.IsEmpty() {
-			delete(r.byUri, uri)
+			delete(r.bySni, uri)
 		}
 	}
+}
+
+func (r *RouteRegistry) newPool() *route.Pool {
+	pool := route.NewPool(r.dropletStaleThreshold / 4)
+	pool.SetHealthPolicy(r.healthPolicy)
+	pool.SetFailbackDelay(r.failbackDelay)
+	pool.SetInFlightLimit(r.inFlightLimit)
+	pool.SetClock(r.clock)
+	pool.SetRandom(r.random)
+	return pool
+}
+
+func (r *RouteRegistry) registerTcp(port uint16, endpoint *route.Endpoint, t time.Time) {
+	pool, found := r.byPort[port]
+	if !found {
+		pool = r.newPool()
+		r.byPort[port] = pool
+	}
+
+	pool.Put(endpoint)
+
+	r.setTimeOfLastUpdate(t)
+}
+
+func (r *RouteRegistry) unregisterTcp(port uint16, endpoint *route.Endpoint) {
+	pool, found := r.byPort[port]
+	if found {
+		pool.Remove(endpoint)
+
+		if pool.IsEmpty() {
+			delete(r.byPort, port)
+		}
+	}
+}
+
+func (r *RouteRegistry) SetReporter(reporter ControlPlaneReporter) {
+	r.Lock()
+	r.reporter = reporter
+	r.Unlock()
+}
+
+// SetClock has the registry and the pools of the routes registered after
+// it take the time from c, and pick endpoints with random, so that tests can
+// drive pruning and balancing deterministically.
+func (r *RouteRegistry) SetClock(c clock.Clock, random clock.Random) {
+	r.Lock()
+	r.clock = c
+	r.random = random
+	r.Unlock()
+}
+
+func (r *RouteRegistry) Reporter() ControlPlaneReporter {
+	r.RLock()
+	reporter := r.reporter
+	r.RUnlock()
+
+	return reporter
+}
+
+// SetRegistrars has the messages of the sources of routes that check with
+// the registry counted and limited by registrars.
+func (r *RouteRegistry) SetRegistrars(registrars *Registrars) {
+	r.Lock()
+	r.registrars = registrars
+	r.Unlock()
+}
+
+func (r *RouteRegistry) Registrars() *Registrars {
+	r.RLock()
+	registrars := r.registrars
+	r.RUnlock()
+
+	return registrars
+}
+
+func (r *RouteRegistry) captureUpdate(operation string, start time.Time) {
+	if reporter := r.Reporter(); reporter != nil {
+		reporter.CaptureRegistryUpdate(operation, time.Since(start))
+	}
+}
+
+// EnableCutover makes lookups for the given domains consult source for the
+// configured share of requests. It fails, leaving lookups as they are, when
+// a share is not between 0 and 100 percent.
+func (r *RouteRegistry) EnableCutover(source *RouteRegistry, domains []config.CutoverDomainConfig) (*Cutover, error) {
+	c, err := NewCutover(source, domains)
+	if err != nil {
+		return nil, err
+	}
 
+	r.Lock()
+	c.random = r.random
+	r.cutover.Store(c)
 	r.Unlock()
+
+	return c, nil
+}
+
+// Cutover returns the cutover of r, or nil when it has none. Lookups call
+// it for every request, so it takes no lock.
+func (r *RouteRegistry) Cutover() *Cutover {
+	c, _ := r.cutover.Load().(*Cutover)
+	return c
 }
 
+// Lookup returns the pool of uri, or of the wildcard route that matches it.
+// Like LookupRequest, it reads the route tables the shards last published,
+// and takes no lock.
 func (r *RouteRegistry) Lookup(uri route.Uri) *route.Pool {
+	if c := r.Cutover(); c != nil {
+		if pool := c.lookup(uri); pool != nil {
+			return pool
+		}
+	}
+
+	uri = uri.ToLower()
+	var err error
+	for err == nil {
+		if pool := r.shard(uri).lookup(uri); pool != nil {
+			return pool
+		}
+
+		uri, err = uri.NextWildcard()
+	}
+
+	return nil
+}
+
+// LookupRequest returns the pool request goes to once its host matched
+// uri: the pool of the first match of the route that request meets, trying
+// those with the most conditions first, or else the pool of the endpoints
+// registered without a match.
+func (r *RouteRegistry) LookupRequest(uri route.Uri, request *http.Request) *route.Pool {
+	if c := r.Cutover(); c != nil {
+		if pool := c.lookup(uri); pool != nil {
+			return pool
+		}
+	}
+
+	uri = uri.ToLower()
+	var err error
+	for err == nil {
+		if pool, found := r.shard(uri).lookupRequest(uri, request); found {
+			return pool
+		}
+
+		uri, err = uri.NextWildcard()
+	}
+
+	return nil
+}
+
+func (r *RouteRegistry) LookupTlsPassthrough(uri route.Uri) *route.Pool {
 	r.RLock()
 
 	uri = uri.ToLower()
 	var err error
-	pool, found := r.byUri[uri]
+	pool, found := r.bySni[uri]
 	for !found && err == nil {
 		uri, err = uri.NextWildcard()
-		pool, found = r.byUri[uri]
+		pool, found = r.bySni[uri]
 	}
 
 	r.RUnlock()
@@ -105,16 +455,25 @@ func (r *RouteRegistry) Lookup(uri route.Uri) *route.Pool {
 	return pool
 }
 
+func (r *RouteRegistry) LookupTcp(port uint16) *route.Pool {
+	r.RLock()
+	pool := r.byPort[port]
+	r.RUnlock()
+
+	return pool
+}
+
 func (r *RouteRegistry) StartPruningCycle() {
 	if r.pruneStaleDropletsInterval > 0 {
 		r.Lock()
-		r.ticker = time.NewTicker(r.pruneStaleDropletsInterval)
+		r.ticker = r.clock.NewTicker(r.pruneStaleDropletsInterval)
+		ticker := r.ticker
 		r.Unlock()
 
 		go func() {
 			for {
 				select {
-				case <-r.ticker.C:
+				case <-ticker.C():
 					r.logger.Debug("Start to check and prune stale droplets")
 					r.pruneStaleDroplets()
 				}
@@ -131,59 +490,131 @@ func (r *RouteRegistry) StopPruningCycle() {
 	r.Unlock()
 }
 
+// NumUris is the number of HTTP routes, counting those that only have
+// endpoints registered with a match.
 func (registry *RouteRegistry) NumUris() int {
-	registry.RLock()
-	uriCount := len(registry.byUri)
-	registry.RUnlock()
+	return int(atomic.LoadInt64(&registry.numHttpRoutes))
+}
+
+func (r *RouteRegistry) NumTcpPorts() int {
+	r.RLock()
+	portCount := len(r.byPort)
+	r.RUnlock()
 
-	return uriCount
+	return portCount
 }
 
 func (r *RouteRegistry) TimeOfLastUpdate() time.Time {
-	r.RLock()
+	r.updateLock.Lock()
 	t := r.timeOfLastUpdate
-	r.RUnlock()
+	r.updateLock.Unlock()
 
 	return t
 }
 
+func (r *RouteRegistry) setTimeOfLastUpdate(t time.Time) {
+	r.updateLock.Lock()
+	r.timeOfLastUpdate = t
+	r.updateLock.Unlock()
+}
+
+// eachShard calls f with every shard of the HTTP routes.
+func (r *RouteRegistry) eachShard(f func(s *shard)) {
+	for _, s := range r.shards {
+		f(s)
+	}
+}
+
 func (r *RouteRegistry) NumEndpoints() int {
-	r.RLock()
 	uris := make(map[string]struct{})
 	f := func(endpoint *route.Endpoint) {
 		uris[endpoint.CanonicalAddr()] = struct{}{}
 	}
-	for _, pool := range r.byUri {
+	r.EachPool(func(pool *route.Pool) {
 		pool.Each(f)
-	}
-	r.RUnlock()
+	})
 
 	return len(uris)
 }
 
+// EachPool calls f with the pool of every HTTP route.
+func (r *RouteRegistry) EachPool(f func(pool *route.Pool)) {
+	r.eachShard(func(s *shard) {
+		s.each(func(uri route.Uri, pool *route.Pool) {
+			f(pool)
+		})
+	})
+}
+
+// InFlight returns the endpoints with requests in flight of every HTTP
+// route that has any.
+func (r *RouteRegistry) InFlight() map[route.Uri][]route.EndpointInFlight {
+	inFlight := make(map[route.Uri][]route.EndpointInFlight)
+	r.eachShard(func(s *shard) {
+		s.each(func(uri route.Uri, pool *route.Pool) {
+			if endpoints := pool.InFlight(); len(endpoints) > 0 {
+				inFlight[uri] = append(inFlight[uri], endpoints...)
+			}
+		})
+	})
+	return inFlight
+}
+
 func (r *RouteRegistry) MarshalJSON() ([]byte, error) {
-	r.RLock()
-	defer r.RUnlock()
+	byUri := make(map[route.Uri]*route.Pool)
+	r.eachShard(func(s *shard) {
+		for uri, pool := range s.published().byUri {
+			byUri[uri] = pool
+		}
+	})
 
-	return json.Marshal(r.byUri)
+	return json.Marshal(byUri)
 }
 
 func (r *RouteRegistry) pruneStaleDroplets() {
+	start := r.clock.Now()
+	pruned := 0
+
 	r.Lock()
-	for k, pool := range r.byUri {
-		pool.PruneEndpoints(r.dropletStaleThreshold)
+	for _, s := range r.shards {
+		s.Lock()
+		pruned += r.prune(s)
+		s.publish()
+		s.Unlock()
+	}
+	for port, pool := range r.byPort {
+		pruned += pool.PruneEndpoints(r.dropletStaleThreshold)
+		if pool.IsEmpty() {
+			delete(r.byPort, port)
+		}
+	}
+	for k, pool := range r.bySni {
+		pruned += pool.PruneEndpoints(r.dropletStaleThreshold)
 		if pool.IsEmpty() {
-			delete(r.byUri, k)
+			delete(r.bySni, k)
 		}
 	}
+	reporter := r.reporter
 	r.Unlock()
+
+	if reporter != nil {
+		reporter.CaptureRoutePruning(r.clock.Since(start), pruned)
+	}
 }
 
 func (r *RouteRegistry) pauseStaleTracker() {
 	r.Lock()
-	t := time.Now()
-
-	for _, pool := range r.byUri {
+	t := r.clock.Now()
+
+	r.eachShard(func(s *shard) {
+		s.each(func(uri route.Uri, pool *route.Pool) {
+			pool.MarkUpdated(t)
+		})
+	})
+	for _, pool := range r.byPort {
+		pool.MarkUpdated(t)
+	}
+	for _, pool := range r.bySni {
 		pool.MarkUpdated(t)
 	}
 
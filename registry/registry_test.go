@@ -5,14 +5,47 @@ import (
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 
+	"github.com/cloudfoundry/gorouter/clock"
+	"github.com/cloudfoundry/gorouter/clock/fakeclock"
 	"github.com/cloudfoundry/gorouter/config"
 	"github.com/cloudfoundry/gorouter/route"
 	"github.com/cloudfoundry/yagnats/fakeyagnats"
 
 	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
 	"time"
 )
 
+type fakeControlPlaneReporter struct {
+	sync.Mutex
+	updates []string
+	pruned  int
+	cycles  int
+}
+
+func (f *fakeControlPlaneReporter) CaptureRegistryUpdate(operation string, d time.Duration) {
+	f.Lock()
+	f.updates = append(f.updates, operation)
+	f.Unlock()
+}
+
+func (f *fakeControlPlaneReporter) CaptureRoutePruning(d time.Duration, pruned int) {
+	f.Lock()
+	f.cycles++
+	f.pruned += pruned
+	f.Unlock()
+}
+
+func (f *fakeControlPlaneReporter) CaptureNatsMessage(subject string, d time.Duration) {}
+
+func (f *fakeControlPlaneReporter) Pruned() int {
+	f.Lock()
+	defer f.Unlock()
+	return f.pruned
+}
+
 var _ = Describe("RouteRegistry", func() {
 	var r *RouteRegistry
 	var messageBus *fakeyagnats.FakeNATSConn
@@ -24,6 +57,7 @@ var _ = Describe("RouteRegistry", func() {
 		configObj = config.DefaultConfig()
 		configObj.PruneStaleDropletsInterval = 50 * time.Millisecond
 		configObj.DropletStaleThreshold = 10 * time.Millisecond
+		configObj.TcpRouting.Ports = []uint16{60000, 60001, 61000}
 
 		messageBus = fakeyagnats.Connect()
 		r = NewRouteRegistry(configObj, messageBus)
@@ -105,6 +139,16 @@ var _ = Describe("RouteRegistry", func() {
 				Expect(r.NumUris()).To(Equal(1))
 				Expect(r.NumEndpoints()).To(Equal(1))
 			})
+
+			It("ignores uris with a '*' other than a leading label", func() {
+				for _, uri := range []route.Uri{"*", "*.", "a.*.route", "*a.route", "*.*.route"} {
+					r.Register(uri, fooEndpoint)
+					r.RegisterTlsPassthrough(uri, fooEndpoint)
+				}
+
+				Expect(r.NumUris()).To(Equal(0))
+				Expect(r.LookupTlsPassthrough("a.b.route")).To(BeNil())
+			})
 		})
 	})
 
@@ -236,6 +280,18 @@ var _ = Describe("RouteRegistry", func() {
 			Ω(e.CanonicalAddr()).To(Equal("192.168.1.2:1234"))
 		})
 
+		It("routes any depth of subdomains to a wildcard route", func() {
+			app := route.NewEndpoint("", "192.168.1.1", 1234, "", nil, -1)
+			r.Register("*.example.com", app)
+
+			for _, host := range []route.Uri{"tenant.example.com", "a.b.tenant.EXAMPLE.com"} {
+				p := r.Lookup(host)
+				Expect(p).ToNot(BeNil())
+				Ω(p.Endpoints("").Next()).To(Equal(app))
+			}
+			Expect(r.Lookup("example.com")).To(BeNil())
+		})
+
 		It("prefers full URIs to wildcard routes", func() {
 			app1 := route.NewEndpoint("", "192.168.1.1", 1234, "", nil, -1)
 			app2 := route.NewEndpoint("", "192.168.1.2", 1234, "", nil, -1)
@@ -251,12 +307,201 @@ var _ = Describe("RouteRegistry", func() {
 		})
 	})
 
+	Context("LookupRequest", func() {
+		var stable, beta, betaCookie, betaBoth *route.Endpoint
+
+		newRequest := func(header map[string]string) *http.Request {
+			request, _ := http.NewRequest("GET", "http://app/", nil)
+			for k, v := range header {
+				request.Header.Set(k, v)
+			}
+			return request
+		}
+
+		addr := func(pool *route.Pool) string {
+			Ω(pool).ShouldNot(BeNil())
+			return pool.Endpoints("").Next().CanonicalAddr()
+		}
+
+		BeforeEach(func() {
+			stable = route.NewEndpoint("", "192.168.1.1", 1234, "", nil, -1)
+			beta = route.NewEndpoint("", "192.168.1.2", 1234, "", nil, -1)
+			beta.Match = route.Match{Headers: map[string]string{"x-beta": "true"}}
+			betaCookie = route.NewEndpoint("", "192.168.1.3", 1234, "", nil, -1)
+			betaCookie.Match = route.Match{Cookies: map[string]string{"beta": ""}}
+			betaBoth = route.NewEndpoint("", "192.168.1.4", 1234, "", nil, -1)
+			betaBoth.Match = route.Match{
+				Headers: map[string]string{"X-Beta": "true"},
+				Cookies: map[string]string{"beta": "1"},
+			}
+
+			r.Register("app", stable)
+			r.Register("app", beta)
+			r.Register("app", betaCookie)
+			r.Register("app", betaBoth)
+		})
+
+		It("routes requests that meet a match to its endpoints", func() {
+			Ω(addr(r.LookupRequest("app", newRequest(map[string]string{"X-Beta": "true"})))).To(Equal("192.168.1.2:1234"))
+			Ω(addr(r.LookupRequest("APP", newRequest(map[string]string{"Cookie": "beta=0"})))).To(Equal("192.168.1.3:1234"))
+		})
+
+		It("tries the matches with the most conditions first", func() {
+			request := newRequest(map[string]string{"X-Beta": "true", "Cookie": "beta=1"})
+			Ω(addr(r.LookupRequest("app", request))).To(Equal("192.168.1.4:1234"))
+		})
+
+		It("routes other requests to the endpoints without a match", func() {
+			Ω(addr(r.LookupRequest("app", newRequest(map[string]string{"X-Beta": "false"})))).To(Equal("192.168.1.1:1234"))
+			Ω(addr(r.Lookup("app"))).To(Equal("192.168.1.1:1234"))
+		})
+
+		It("finds no pool for other requests when every endpoint has a match", func() {
+			r.Unregister("app", stable)
+
+			Ω(r.LookupRequest("app", newRequest(nil))).To(BeNil())
+			Ω(r.LookupRequest("app", newRequest(map[string]string{"X-Beta": "true"}))).ToNot(BeNil())
+			Ω(r.NumUris()).To(Equal(1))
+			Ω(r.NumEndpoints()).To(Equal(3))
+		})
+
+		It("drops a match once its last endpoint is unregistered", func() {
+			r.Unregister("app", beta)
+
+			Ω(addr(r.LookupRequest("app", newRequest(map[string]string{"X-Beta": "true"})))).To(Equal("192.168.1.1:1234"))
+		})
+
+		It("matches requests for wildcard routes", func() {
+			wild := route.NewEndpoint("", "192.168.1.5", 1234, "", nil, -1)
+			wild.Match = beta.Match
+			r.Register("*.example.com", wild)
+
+			Ω(addr(r.LookupRequest("foo.example.com", newRequest(map[string]string{"X-Beta": "true"})))).To(Equal("192.168.1.5:1234"))
+			Ω(r.LookupRequest("foo.example.com", newRequest(nil))).To(BeNil())
+		})
+
+		It("tries exact paths, then path prefixes, then path regexes", func() {
+			exact := route.NewEndpoint("", "192.168.1.6", 1234, "", nil, -1)
+			exact.Match = route.Match{Path: "/api/v1/users"}
+			prefix := route.NewEndpoint("", "192.168.1.7", 1234, "", nil, -1)
+			prefix.Match = route.Match{PathPrefix: "/api/v1"}
+			regex := route.NewEndpoint("", "192.168.1.8", 1234, "", nil, -1)
+			Ω(json.Unmarshal([]byte(`{"path_regex": "^/api/v[0-9]+/"}`), &regex.Match)).To(Succeed())
+
+			r.Register("app", regex)
+			r.Register("app", prefix)
+			r.Register("app", exact)
+
+			lookup := func(path string) string {
+				request := newRequest(map[string]string{"X-Beta": "true"})
+				request.URL.Path = path
+				return addr(r.LookupRequest("app", request))
+			}
+
+			Ω(lookup("/api/v1/users")).To(Equal("192.168.1.6:1234"))
+			Ω(lookup("/api/v1/items")).To(Equal("192.168.1.7:1234"))
+			Ω(lookup("/api/v2/items")).To(Equal("192.168.1.8:1234"))
+			Ω(lookup("/other")).To(Equal("192.168.1.2:1234"))
+		})
+
+		It("tries the matches of a higher priority first", func() {
+			catchAll := route.NewEndpoint("", "192.168.1.6", 1234, "", nil, -1)
+			catchAll.Match = route.Match{PathPrefix: "/"}
+			override := route.NewEndpoint("", "192.168.1.7", 1234, "", nil, -1)
+			override.Match = route.Match{Headers: map[string]string{"X-Beta": "true"}, Priority: 10}
+
+			r.Register("app", catchAll)
+			Ω(addr(r.LookupRequest("app", newRequest(map[string]string{"X-Beta": "true"})))).To(Equal("192.168.1.6:1234"))
+
+			r.Register("app", override)
+			Ω(addr(r.LookupRequest("app", newRequest(map[string]string{"X-Beta": "true"})))).To(Equal("192.168.1.7:1234"))
+			Ω(addr(r.LookupRequest("app", newRequest(nil)))).To(Equal("192.168.1.6:1234"))
+		})
+
+		It("refuses a priority without conditions to match", func() {
+			plain := route.NewEndpoint("", "192.168.1.6", 1234, "", nil, -1)
+			prioritized := route.NewEndpoint("", "192.168.1.7", 1234, "", nil, -1)
+			prioritized.Match = route.Match{Priority: 10}
+
+			r.Register("app", plain)
+			r.Register("app", prioritized)
+			Ω(r.Lookup("app").Has("192.168.1.7:1234")).To(BeFalse())
+
+			// nor does it unregister the endpoint of the same address
+			// without a match
+			prioritized = route.NewEndpoint("", "192.168.1.6", 1234, "", nil, -1)
+			prioritized.Match = route.Match{Priority: 10}
+			r.Unregister("app", prioritized)
+			Ω(r.Lookup("app").Has("192.168.1.6:1234")).To(BeTrue())
+		})
+	})
+
+	Context("Tcp", func() {
+		It("registers and looks up endpoints by port", func() {
+			r.RegisterTcp(60000, fooEndpoint)
+			r.RegisterTcp(60000, barEndpoint)
+			r.RegisterTcp(60001, barEndpoint)
+
+			Ω(r.NumTcpPorts()).To(Equal(2))
+			Ω(r.NumUris()).To(Equal(0))
+
+			p := r.LookupTcp(60000)
+			Ω(p).ShouldNot(BeNil())
+			Ω(p.Endpoints("").Next().CanonicalAddr()).To(MatchRegexp("192.168.1.[12]:(1234|4321)"))
+
+			Ω(r.LookupTcp(60002)).Should(BeNil())
+		})
+
+		It("does not register endpoints on ports the router does not listen on", func() {
+			r.RegisterTcp(0, fooEndpoint)
+			r.RegisterTcp(60002, fooEndpoint)
+
+			Ω(r.NumTcpPorts()).To(Equal(0))
+			Ω(r.LookupTcp(0)).Should(BeNil())
+			Ω(r.LookupTcp(60002)).Should(BeNil())
+		})
+
+		It("removes the port when the last endpoint is unregistered", func() {
+			r.RegisterTcp(60000, fooEndpoint)
+			r.RegisterTcp(60000, barEndpoint)
+
+			r.UnregisterTcp(60000, fooEndpoint)
+			Ω(r.LookupTcp(60000)).ShouldNot(BeNil())
+
+			r.UnregisterTcp(60000, barEndpoint)
+			Ω(r.LookupTcp(60000)).Should(BeNil())
+			Ω(r.NumTcpPorts()).To(Equal(0))
+		})
+
+		It("prunes stale tcp endpoints", func() {
+			r.RegisterTcp(60000, fooEndpoint)
+
+			r.StartPruningCycle()
+			time.Sleep(configObj.PruneStaleDropletsInterval + 10*time.Millisecond)
+			r.StopPruningCycle()
+
+			Ω(r.NumTcpPorts()).To(Equal(0))
+		})
+	})
+
 	Context("Prunes Stale Droplets", func() {
 
 		AfterEach(func() {
 			r.StopPruningCycle()
 		})
 
+		It("prunes on the ticks of its clock", func() {
+			fakeClock := fakeclock.New(time.Now())
+			r.SetClock(fakeClock, clock.NewSeededRandom(1))
+
+			r.Register("foo", fooEndpoint)
+			r.StartPruningCycle()
+			Eventually(fakeClock.WatcherCount).Should(Equal(1))
+
+			fakeClock.Increment(configObj.PruneStaleDropletsInterval)
+			Eventually(r.NumUris).Should(BeZero())
+		})
+
 		It("removes stale droplets", func() {
 			r.Register("foo", fooEndpoint)
 			r.Register("fooo", fooEndpoint)
@@ -318,6 +563,88 @@ var _ = Describe("RouteRegistry", func() {
 		})
 	})
 
+	Context("Route limit", func() {
+		It("refuses new routes over the limit when enforced", func() {
+			configObj.Limits.Routes = config.LimitConfig{Max: 1}
+			r = NewRouteRegistry(configObj, messageBus)
+
+			r.Register("foo", fooEndpoint)
+			r.Register("bar", barEndpoint)
+			r.Register("foo", barEndpoint)
+
+			Ω(r.NumUris()).To(Equal(1))
+			Ω(r.Lookup("bar")).To(BeNil())
+			Ω(r.NumEndpoints()).To(Equal(2))
+		})
+
+		It("registers routes over the limit in warn mode", func() {
+			configObj.Limits.Routes = config.LimitConfig{Max: 1, Mode: config.LimitModeWarn}
+			r = NewRouteRegistry(configObj, messageBus)
+
+			r.Register("foo", fooEndpoint)
+			r.Register("bar", barEndpoint)
+
+			Ω(r.NumUris()).To(Equal(2))
+		})
+	})
+
+	Context("Concurrent updates", func() {
+		It("keeps count of the routes of hosts registered and unregistered at once", func() {
+			var wg sync.WaitGroup
+			for i := 0; i < 8; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer GinkgoRecover()
+					defer wg.Done()
+
+					for j := 0; j < 100; j++ {
+						uri := route.Uri(fmt.Sprintf("app-%d-%d.example.com", i, j))
+						r.Register(uri, fooEndpoint)
+						Ω(r.Lookup(uri)).NotTo(BeNil())
+						if j%2 == 1 {
+							r.Unregister(uri, fooEndpoint)
+						}
+					}
+				}(i)
+			}
+			wg.Wait()
+
+			Ω(r.NumUris()).To(Equal(400))
+			Ω(r.Lookup("app-3-42.example.com")).NotTo(BeNil())
+			Ω(r.Lookup("app-3-43.example.com")).To(BeNil())
+		})
+	})
+
+	Context("Control plane timings", func() {
+		var reporter *fakeControlPlaneReporter
+
+		BeforeEach(func() {
+			reporter = &fakeControlPlaneReporter{}
+			r.SetReporter(reporter)
+		})
+
+		AfterEach(func() {
+			r.StopPruningCycle()
+		})
+
+		It("reports registry updates", func() {
+			r.Register("foo", fooEndpoint)
+			r.RegisterTcp(61000, fooEndpoint)
+			r.Unregister("foo", fooEndpoint)
+
+			Ω(reporter.updates).To(Equal([]string{"register", "register", "unregister"}))
+		})
+
+		It("reports prune cycles and the number of endpoints pruned", func() {
+			r.Register("foo", fooEndpoint)
+			r.Register("bar", barEndpoint)
+
+			r.StartPruningCycle()
+
+			Eventually(reporter.Pruned).Should(Equal(2))
+		})
+	})
+
 	Context("Varz data", func() {
 		It("NumUris", func() {
 			r.Register("bar", barEndpoint)
@@ -341,6 +668,21 @@ var _ = Describe("RouteRegistry", func() {
 			Ω(r.NumEndpoints()).To(Equal(2))
 		})
 
+		It("InFlight", func() {
+			r.Register("bar", barEndpoint)
+			r.Register("foo", fooEndpoint)
+			Ω(r.InFlight()).To(BeEmpty())
+
+			iter := r.Lookup("bar").Endpoints("")
+			iter.Next()
+			Ω(r.InFlight()).To(Equal(map[route.Uri][]route.EndpointInFlight{
+				"bar": {{Endpoint: barEndpoint, Requests: 1}},
+			}))
+
+			iter.Done()
+			Ω(r.InFlight()).To(BeEmpty())
+		})
+
 		It("TimeOfLastUpdate", func() {
 			start := time.Now()
 			r.Register("bar", barEndpoint)
@@ -0,0 +1,212 @@
+package registry
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cloudfoundry/gorouter/route"
+)
+
+const (
+	defaultRoutesLimit = 100
+	maxRoutesLimit     = 1000
+)
+
+// A routesPage is a page of the HTTP routes that a routes query matches,
+// in the order of their URIs. NextOffset is the offset of the next page, or
+// 0 on the last page.
+type routesPage struct {
+	Total      int          `json:"total"`
+	Offset     int          `json:"offset"`
+	Limit      int          `json:"limit"`
+	NextOffset int          `json:"next_offset,omitempty"`
+	Routes     []routeEntry `json:"routes"`
+}
+
+type routeEntry struct {
+	Uri       route.Uri       `json:"uri"`
+	Endpoints []endpointEntry `json:"endpoints"`
+}
+
+// An endpointEntry is an endpoint of a route, with its details when they
+// were asked for. An endpoint is stale once it has not been registered
+// again for its stale threshold, and is pruned with the next prune cycle.
+type endpointEntry struct {
+	Addr string `json:"addr"`
+
+	App               string            `json:"app,omitempty"`
+	PrivateInstanceId string            `json:"private_instance_id,omitempty"`
+	Tags              map[string]string `json:"tags,omitempty"`
+	AppVersion        string            `json:"app_version,omitempty"`
+	Group             string            `json:"group,omitempty"`
+	Weight            int               `json:"weight,omitempty"`
+	Backup            bool              `json:"backup,omitempty"`
+	Match             *route.Match      `json:"match,omitempty"`
+	UpdatedAt         *time.Time        `json:"updated_at,omitempty"`
+	StaleAt           *time.Time        `json:"stale_at,omitempty"`
+	Stale             bool              `json:"stale,omitempty"`
+}
+
+// A routesQuery selects the routes whose URI matches Host, exactly or, when
+// it starts with "*.", by suffix, and keeps the endpoints of those that
+// carry every tag of Tags.
+type routesQuery struct {
+	host   route.Uri
+	tags   map[string]string
+	offset int
+	limit  int
+	detail bool
+}
+
+func parseRoutesQuery(req *http.Request) (routesQuery, string) {
+	values := req.URL.Query()
+	q := routesQuery{
+		host:   route.Uri(values.Get("host")).ToLower(),
+		tags:   make(map[string]string),
+		limit:  defaultRoutesLimit,
+		detail: values.Get("detail") == "true",
+	}
+
+	for _, tag := range values["tag"] {
+		i := strings.IndexByte(tag, ':')
+		if i < 1 {
+			return q, "tag must be key:value"
+		}
+		q.tags[tag[:i]] = tag[i+1:]
+	}
+
+	if v := values.Get("offset"); v != "" {
+		offset, err := strconv.Atoi(v)
+		if err != nil || offset < 0 {
+			return q, "offset must be a number of 0 or more"
+		}
+		q.offset = offset
+	}
+	if v := values.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > maxRoutesLimit {
+			return q, "limit must be a number from 1 to " + strconv.Itoa(maxRoutesLimit)
+		}
+		q.limit = limit
+	}
+	return q, ""
+}
+
+func (q routesQuery) matchesUri(uri route.Uri) bool {
+	switch {
+	case q.host == "":
+		return true
+	case strings.HasPrefix(string(q.host), "*."):
+		return strings.HasSuffix(string(uri), string(q.host[1:]))
+	}
+	return uri == q.host
+}
+
+func (q routesQuery) matchesTags(tags map[string]string) bool {
+	for key, value := range q.tags {
+		if tags[key] != value {
+			return false
+		}
+	}
+	return true
+}
+
+// ServeRoutes lists the HTTP routes and the addresses of their endpoints.
+// Without query parameters it lists them all, by URI; with any it lists a
+// page of the routes that match them, as a routesPage.
+func (r *RouteRegistry) ServeRoutes(w http.ResponseWriter, req *http.Request) {
+	if req.Method != "GET" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if req.URL.RawQuery == "" {
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(r)
+		return
+	}
+
+	q, problem := parseRoutesQuery(req)
+	if problem != "" {
+		http.Error(w, problem, http.StatusBadRequest)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(r.routes(q))
+}
+
+func (r *RouteRegistry) routes(q routesQuery) routesPage {
+	r.RLock()
+	defer r.RUnlock()
+
+	now := r.clock.Now()
+	byUri := make(map[route.Uri][]endpointEntry)
+	add := func(uri route.Uri, pool *route.Pool) {
+		pool.EachUpdated(func(e *route.Endpoint, updated time.Time) {
+			if q.matchesTags(e.Tags) {
+				byUri[uri] = append(byUri[uri], r.endpointEntry(e, updated, now, q.detail))
+			}
+		})
+	}
+
+	r.eachShard(func(s *shard) {
+		s.each(func(uri route.Uri, pool *route.Pool) {
+			if q.matchesUri(uri) {
+				add(uri, pool)
+			}
+		})
+	})
+
+	uris := make([]string, 0, len(byUri))
+	for uri := range byUri {
+		uris = append(uris, string(uri))
+	}
+	sort.Strings(uris)
+
+	page := routesPage{Total: len(uris), Offset: q.offset, Limit: q.limit, Routes: []routeEntry{}}
+	for i := q.offset; i < len(uris) && i < q.offset+q.limit; i++ {
+		endpoints := byUri[route.Uri(uris[i])]
+		sort.Slice(endpoints, func(i, j int) bool { return endpoints[i].Addr < endpoints[j].Addr })
+		page.Routes = append(page.Routes, routeEntry{Uri: route.Uri(uris[i]), Endpoints: endpoints})
+	}
+	if q.offset+q.limit < len(uris) {
+		page.NextOffset = q.offset + q.limit
+	}
+	return page
+}
+
+func (r *RouteRegistry) endpointEntry(e *route.Endpoint, updated, now time.Time, detail bool) endpointEntry {
+	entry := endpointEntry{Addr: e.CanonicalAddr()}
+	if !detail {
+		return entry
+	}
+
+	threshold := r.dropletStaleThreshold
+	if t := e.StaleThreshold(); t > 0 && t < threshold {
+		threshold = t
+	}
+	staleAt := updated.Add(threshold)
+
+	entry.App = e.ApplicationId
+	entry.PrivateInstanceId = e.PrivateInstanceId
+	entry.Tags = e.Tags
+	entry.AppVersion = e.AppVersion
+	entry.Group = e.Group
+	entry.Weight = e.Weight
+	entry.Backup = e.Backup
+	entry.UpdatedAt = &updated
+	entry.StaleAt = &staleAt
+	entry.Stale = now.After(staleAt)
+	if !e.Match.IsEmpty() {
+		match := e.Match
+		entry.Match = &match
+	}
+	return entry
+}
@@ -0,0 +1,132 @@
+package registry_test
+
+import (
+	. "github.com/cloudfoundry/gorouter/registry"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/cloudfoundry/gorouter/clock"
+	"github.com/cloudfoundry/gorouter/clock/fakeclock"
+	"github.com/cloudfoundry/gorouter/config"
+	"github.com/cloudfoundry/gorouter/route"
+	"github.com/cloudfoundry/yagnats/fakeyagnats"
+
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"time"
+)
+
+var _ = Describe("ServeRoutes", func() {
+	var r *RouteRegistry
+	var fakeTime *fakeclock.FakeClock
+
+	type endpoint struct {
+		Addr      string            `json:"addr"`
+		App       string            `json:"app"`
+		Tags      map[string]string `json:"tags"`
+		UpdatedAt *time.Time        `json:"updated_at"`
+		StaleAt   *time.Time        `json:"stale_at"`
+		Stale     bool              `json:"stale"`
+	}
+	type page struct {
+		Total      int `json:"total"`
+		Offset     int `json:"offset"`
+		Limit      int `json:"limit"`
+		NextOffset int `json:"next_offset"`
+		Routes     []struct {
+			Uri       string     `json:"uri"`
+			Endpoints []endpoint `json:"endpoints"`
+		} `json:"routes"`
+	}
+
+	BeforeEach(func() {
+		r = NewRouteRegistry(config.DefaultConfig(), fakeyagnats.Connect())
+		fakeTime = fakeclock.New(time.Now())
+		r.SetClock(fakeTime, clock.NewRandom())
+
+		r.Register("a.example.com", route.NewEndpoint("app-a", "192.168.1.1", 1234, "", map[string]string{"space": "prod"}, -1))
+		r.Register("b.example.com", route.NewEndpoint("app-b", "192.168.1.2", 1234, "", map[string]string{"space": "dev"}, 30))
+		r.Register("c.other.com", route.NewEndpoint("app-c", "192.168.1.3", 1234, "", map[string]string{"space": "prod"}, -1))
+	})
+
+	serve := func(query string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", "/routes"+query, nil)
+		w := httptest.NewRecorder()
+		r.ServeRoutes(w, req)
+		return w
+	}
+
+	servePage := func(query string) page {
+		w := serve(query)
+		Ω(w.Code).To(Equal(http.StatusOK))
+
+		var p page
+		Ω(json.Unmarshal(w.Body.Bytes(), &p)).To(Succeed())
+		return p
+	}
+
+	It("lists every route by URI without query parameters", func() {
+		w := serve("")
+		Ω(w.Code).To(Equal(http.StatusOK))
+		Ω(w.Body.String()).To(MatchJSON(`{"a.example.com": ["192.168.1.1:1234"], "b.example.com": ["192.168.1.2:1234"], "c.other.com": ["192.168.1.3:1234"]}`))
+	})
+
+	It("filters by host suffix", func() {
+		p := servePage("?host=*.example.com")
+		Ω(p.Total).To(Equal(2))
+		Ω(p.Routes[0].Uri).To(Equal("a.example.com"))
+		Ω(p.Routes[1].Uri).To(Equal("b.example.com"))
+	})
+
+	It("filters by exact host", func() {
+		p := servePage("?host=C.other.com")
+		Ω(p.Total).To(Equal(1))
+		Ω(p.Routes[0].Endpoints[0].Addr).To(Equal("192.168.1.3:1234"))
+	})
+
+	It("filters by tag", func() {
+		p := servePage("?tag=space:prod")
+		Ω(p.Total).To(Equal(2))
+		Ω(p.Routes[0].Uri).To(Equal("a.example.com"))
+		Ω(p.Routes[1].Uri).To(Equal("c.other.com"))
+	})
+
+	It("pages through the routes in order", func() {
+		p := servePage("?limit=2")
+		Ω(p.Total).To(Equal(3))
+		Ω(p.Routes).To(HaveLen(2))
+		Ω(p.NextOffset).To(Equal(2))
+
+		p = servePage("?limit=2&offset=2")
+		Ω(p.Routes).To(HaveLen(1))
+		Ω(p.Routes[0].Uri).To(Equal("c.other.com"))
+		Ω(p.NextOffset).To(BeZero())
+	})
+
+	It("leaves the endpoint details out unless asked for", func() {
+		p := servePage("?host=a.example.com")
+		Ω(p.Routes[0].Endpoints[0].App).To(BeEmpty())
+		Ω(p.Routes[0].Endpoints[0].UpdatedAt).To(BeNil())
+	})
+
+	It("details when endpoints were updated and go stale", func() {
+		fakeTime.Increment(time.Minute)
+
+		p := servePage("?detail=true")
+		a, b := p.Routes[0].Endpoints[0], p.Routes[1].Endpoints[0]
+		Ω(a.App).To(Equal("app-a"))
+		Ω(a.Tags).To(Equal(map[string]string{"space": "prod"}))
+		Ω(a.StaleAt.Sub(*a.UpdatedAt)).To(Equal(2 * time.Minute))
+		Ω(a.Stale).To(BeFalse())
+		Ω(b.StaleAt.Sub(*b.UpdatedAt)).To(Equal(30 * time.Second))
+		Ω(b.Stale).To(BeTrue())
+	})
+
+	It("rejects invalid query parameters", func() {
+		Ω(serve("?limit=0").Code).To(Equal(http.StatusBadRequest))
+		Ω(serve("?limit=5000").Code).To(Equal(http.StatusBadRequest))
+		Ω(serve("?offset=-1").Code).To(Equal(http.StatusBadRequest))
+		Ω(serve("?tag=prod").Code).To(Equal(http.StatusBadRequest))
+	})
+})
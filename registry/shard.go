@@ -0,0 +1,301 @@
+package registry
+
+import (
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cloudfoundry/gorouter/route"
+)
+
+// numShards is the number of shards that the HTTP routes are spread over by
+// the hash of their host. A new route copies the table of its shard, so
+// there are enough of them for tables to stay small with hundreds of
+// thousands of routes.
+const numShards = 1024
+
+// A shard holds the HTTP routes of the hosts that hash to it, so that the
+// registrations of hosts on different shards do not wait for each other.
+// Lookups take no lock at all: they read the table the shard last
+// published, which is never changed. Writers, one at a time under the lock
+// of the shard, change a copy of it instead, and publish the copy once they
+// are done. Registering an endpoint for a route that has one already only
+// changes its pool, and copies nothing.
+type shard struct {
+	sync.Mutex
+
+	table atomic.Value // *routeTable
+	dirty *routeTable
+}
+
+// A routeTable is the HTTP routes of a shard.
+type routeTable struct {
+	byUri   map[route.Uri]*route.Pool
+	byMatch map[route.Uri][]*matchedPool
+}
+
+func newShard() *shard {
+	s := &shard{}
+	s.table.Store(&routeTable{
+		byUri:   make(map[route.Uri]*route.Pool),
+		byMatch: make(map[route.Uri][]*matchedPool),
+	})
+	return s
+}
+
+// published returns the table that s last published, which must not be
+// changed.
+func (s *shard) published() *routeTable {
+	return s.table.Load().(*routeTable)
+}
+
+// view returns the table of s as its writer changed it so far. s must be
+// locked.
+func (s *shard) view() *routeTable {
+	if s.dirty != nil {
+		return s.dirty
+	}
+	return s.published()
+}
+
+// writable returns a copy of the table of s that can be changed until it is
+// published. s must be locked.
+func (s *shard) writable() *routeTable {
+	if s.dirty == nil {
+		t := s.published()
+		s.dirty = &routeTable{
+			byUri:   make(map[route.Uri]*route.Pool, len(t.byUri)),
+			byMatch: make(map[route.Uri][]*matchedPool, len(t.byMatch)),
+		}
+		for uri, pool := range t.byUri {
+			s.dirty.byUri[uri] = pool
+		}
+		for uri, matched := range t.byMatch {
+			s.dirty.byMatch[uri] = matched
+		}
+	}
+	return s.dirty
+}
+
+// publish has the lookups read the changes made to the table of s. s must
+// be locked.
+func (s *shard) publish() {
+	if s.dirty != nil {
+		s.table.Store(s.dirty)
+		s.dirty = nil
+	}
+}
+
+// shardIndex is the FNV-1a hash of uri, which must be lower case, modulo
+// the number of shards.
+func shardIndex(uri route.Uri) int {
+	h := uint32(2166136261)
+	for i := 0; i < len(uri); i++ {
+		h ^= uint32(uri[i])
+		h *= 16777619
+	}
+	return int(h % numShards)
+}
+
+func (r *RouteRegistry) shard(uri route.Uri) *shard {
+	return r.shards[shardIndex(uri)]
+}
+
+// hasRoute tells whether uri has endpoints registered with or without a
+// match. s must be locked.
+func (s *shard) hasRoute(uri route.Uri) bool {
+	t := s.view()
+	_, found := t.byUri[uri]
+	return found || len(t.byMatch[uri]) > 0
+}
+
+// applyHttp applies u, an update of the HTTP route of the lower case uri,
+// at time t. r must be read locked and s locked.
+func (r *RouteRegistry) applyHttp(s *shard, u Update, t time.Time) {
+	register := u.Operation == OperationRegister
+
+	switch {
+	case register && !r.valid(u.Uri):
+	case !u.Endpoint.Match.IsValid():
+		if register {
+			r.logger.Warnd(map[string]interface{}{"uri": u.Uri, "priority": u.Endpoint.Match.Priority}, "registry.register.priority-without-match")
+		}
+	case !u.Endpoint.Match.IsEmpty() && register:
+		r.registerMatched(s, u.Uri, u.Endpoint, t)
+	case !u.Endpoint.Match.IsEmpty():
+		r.unregisterMatched(s, u.Uri, u.Endpoint)
+	case register:
+		r.registerHttp(s, u.Uri, u.Endpoint, t)
+	default:
+		r.unregisterHttp(s, u.Uri, u.Endpoint)
+	}
+}
+
+// addRoute counts a new route, unless it is over the limit of routes.
+func (r *RouteRegistry) addRoute(uri route.Uri) bool {
+	if r.routeLimit.Exceeded(atomic.LoadInt64(&r.numHttpRoutes) + 1) {
+		r.logger.Warnd(map[string]interface{}{"uri": uri}, "registry.register.route-limit")
+		return false
+	}
+
+	atomic.AddInt64(&r.numHttpRoutes, 1)
+	return true
+}
+
+func (r *RouteRegistry) removeRoute(s *shard, uri route.Uri) {
+	if !s.hasRoute(uri) {
+		atomic.AddInt64(&r.numHttpRoutes, -1)
+	}
+}
+
+func (r *RouteRegistry) registerHttp(s *shard, uri route.Uri, endpoint *route.Endpoint, t time.Time) {
+	pool, found := s.view().byUri[uri]
+	if !found {
+		if !s.hasRoute(uri) && !r.addRoute(uri) {
+			return
+		}
+
+		pool = r.newPool()
+		pool.SetVersionPolicy(r.versionPolicies[uri])
+		s.writable().byUri[uri] = pool
+	}
+
+	pool.Put(endpoint)
+
+	r.setTimeOfLastUpdate(t)
+}
+
+func (r *RouteRegistry) unregisterHttp(s *shard, uri route.Uri, endpoint *route.Endpoint) {
+	pool, found := s.view().byUri[uri]
+	if found {
+		pool.Remove(endpoint)
+
+		if pool.IsEmpty() {
+			delete(s.writable().byUri, uri)
+			r.removeRoute(s, uri)
+		}
+	}
+}
+
+// registerMatched puts endpoint in the pool of uri for its match, keeping
+// the matches of uri in the order requests are tried against them.
+func (r *RouteRegistry) registerMatched(s *shard, uri route.Uri, endpoint *route.Endpoint, t time.Time) {
+	key := endpoint.Match.Key()
+
+	matched := s.view().byMatch[uri]
+	var pool *route.Pool
+	for _, m := range matched {
+		if m.key == key {
+			pool = m.pool
+			break
+		}
+	}
+
+	if pool == nil {
+		if !s.hasRoute(uri) && !r.addRoute(uri) {
+			return
+		}
+
+		pool = r.newPool()
+		pool.SetVersionPolicy(r.versionPolicies[uri])
+		// the matches that are published are read as they are sorted, so
+		// they are sorted in a copy
+		matched = append(matched[:len(matched):len(matched)], &matchedPool{match: endpoint.Match, key: key, pool: pool})
+		sort.SliceStable(matched, func(i, j int) bool {
+			return matched[i].match.Precedes(matched[j].match)
+		})
+		s.writable().byMatch[uri] = matched
+	}
+
+	pool.Put(endpoint)
+
+	r.setTimeOfLastUpdate(t)
+}
+
+func (r *RouteRegistry) unregisterMatched(s *shard, uri route.Uri, endpoint *route.Endpoint) {
+	key := endpoint.Match.Key()
+
+	matched := s.view().byMatch[uri]
+	for i, m := range matched {
+		if m.key != key {
+			continue
+		}
+
+		m.pool.Remove(endpoint)
+		if m.pool.IsEmpty() {
+			r.removeMatched(s, uri, i)
+		}
+		return
+	}
+}
+
+func (r *RouteRegistry) removeMatched(s *shard, uri route.Uri, i int) {
+	t := s.writable()
+	matched := append(t.byMatch[uri][:i:i], t.byMatch[uri][i+1:]...)
+	if len(matched) == 0 {
+		delete(t.byMatch, uri)
+		r.removeRoute(s, uri)
+	} else {
+		t.byMatch[uri] = matched
+	}
+}
+
+func (s *shard) lookup(uri route.Uri) *route.Pool {
+	return s.published().byUri[uri]
+}
+
+// lookupRequest returns the pool of the first match of uri that request
+// meets, or else the pool of uri without a match, and whether uri has a
+// route at all.
+func (s *shard) lookupRequest(uri route.Uri, request *http.Request) (*route.Pool, bool) {
+	t := s.published()
+
+	matched := t.byMatch[uri]
+	for _, m := range matched {
+		if m.match.Matches(request) {
+			return m.pool, true
+		}
+	}
+
+	pool, found := t.byUri[uri]
+	return pool, found || len(matched) > 0
+}
+
+// each calls f with every pool of s, those of matches included, as s last
+// published them.
+func (s *shard) each(f func(uri route.Uri, pool *route.Pool)) {
+	t := s.published()
+	for uri, pool := range t.byUri {
+		f(uri, pool)
+	}
+	for uri, matched := range t.byMatch {
+		for _, m := range matched {
+			f(uri, m.pool)
+		}
+	}
+}
+
+// prune removes the stale endpoints of s, and the routes left without any,
+// and returns the number of endpoints it removed. s must be locked.
+func (r *RouteRegistry) prune(s *shard) int {
+	pruned := 0
+	t := s.published()
+	for uri, pool := range t.byUri {
+		pruned += pool.PruneEndpoints(r.dropletStaleThreshold)
+		if pool.IsEmpty() {
+			delete(s.writable().byUri, uri)
+			r.removeRoute(s, uri)
+		}
+	}
+	for uri, matched := range t.byMatch {
+		for i := len(matched) - 1; i >= 0; i-- {
+			pruned += matched[i].pool.PruneEndpoints(r.dropletStaleThreshold)
+			if matched[i].pool.IsEmpty() {
+				r.removeMatched(s, uri, i)
+			}
+		}
+	}
+	return pruned
+}
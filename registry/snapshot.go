@@ -0,0 +1,274 @@
+package registry
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/cloudfoundry/gorouter/clock"
+	"github.com/cloudfoundry/gorouter/config"
+	"github.com/cloudfoundry/gorouter/ipfilter"
+	"github.com/cloudfoundry/gorouter/route"
+	steno "github.com/cloudfoundry/gosteno"
+)
+
+// A routeSnapshot is the route table of a router at a point in time.
+type routeSnapshot struct {
+	SavedAt time.Time          `json:"saved_at"`
+	Routes  []snapshotEndpoint `json:"routes"`
+}
+
+// A snapshotEndpoint is an endpoint of an HTTP route, of a TLS passthrough
+// route when TlsPassthrough is set, or of a TCP route when Port is set.
+type snapshotEndpoint struct {
+	Uri            route.Uri `json:"uri,omitempty"`
+	TlsPassthrough bool      `json:"tls_passthrough,omitempty"`
+	Port           uint16    `json:"port,omitempty"`
+
+	Addr                 string            `json:"addr"`
+	App                  string            `json:"app,omitempty"`
+	Tags                 map[string]string `json:"tags,omitempty"`
+	PrivateInstanceId    string            `json:"private_instance_id,omitempty"`
+	PrivateInstanceIndex string            `json:"private_instance_index,omitempty"`
+	HealthCheckPath      string            `json:"health_check_path,omitempty"`
+	StaleThreshold       time.Duration     `json:"stale_threshold,omitempty"`
+	Timeout              time.Duration     `json:"timeout,omitempty"`
+
+	RequiresAuthorizationHeader bool   `json:"requires_authorization_header,omitempty"`
+	RequiresSignedUrls          bool   `json:"requires_signed_urls,omitempty"`
+	RequiresJwt                 bool   `json:"requires_jwt,omitempty"`
+	Backup                      bool   `json:"backup,omitempty"`
+	MaxRequestBodyBytes         int64  `json:"max_request_body_bytes,omitempty"`
+	DisableCompression          bool   `json:"disable_compression,omitempty"`
+	DisableBanners              bool   `json:"disable_banners,omitempty"`
+	Group                       string `json:"group,omitempty"`
+	Weight                      int    `json:"weight,omitempty"`
+	AppVersion                  string `json:"app_version,omitempty"`
+
+	AllowedCidrs ipfilter.Networks `json:"allowed_cidrs,omitempty"`
+	DeniedCidrs  ipfilter.Networks `json:"denied_cidrs,omitempty"`
+
+	Match route.Match `json:"match"`
+}
+
+func newSnapshotEndpoint(e *route.Endpoint) snapshotEndpoint {
+	return snapshotEndpoint{
+		Addr:                        e.CanonicalAddr(),
+		App:                         e.ApplicationId,
+		Tags:                        e.Tags,
+		PrivateInstanceId:           e.PrivateInstanceId,
+		PrivateInstanceIndex:        e.PrivateInstanceIndex,
+		HealthCheckPath:             e.HealthCheckPath,
+		StaleThreshold:              e.StaleThreshold(),
+		Timeout:                     e.Timeout,
+		RequiresAuthorizationHeader: e.RequiresAuthorizationHeader,
+		RequiresSignedUrls:          e.RequiresSignedUrls,
+		RequiresJwt:                 e.RequiresJwt,
+		AllowedCidrs:                e.AllowedNetworks,
+		DeniedCidrs:                 e.DeniedNetworks,
+		Backup:                      e.Backup,
+		MaxRequestBodyBytes:         e.MaxRequestBodyBytes,
+		DisableCompression:          e.DisableCompression,
+		DisableBanners:              e.DisableBanners,
+		Group:                       e.Group,
+		Weight:                      e.Weight,
+		AppVersion:                  e.AppVersion,
+		Match:                       e.Match,
+	}
+}
+
+func (s snapshotEndpoint) endpoint() (*route.Endpoint, error) {
+	host, p, err := net.SplitHostPort(s.Addr)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.ParseUint(p, 10, 16)
+	if err != nil {
+		return nil, err
+	}
+
+	e := route.NewEndpoint(s.App, host, uint16(port), s.PrivateInstanceId, s.Tags, int(s.StaleThreshold/time.Second))
+	e.PrivateInstanceIndex = s.PrivateInstanceIndex
+	e.HealthCheckPath = s.HealthCheckPath
+	e.Timeout = s.Timeout
+	e.RequiresAuthorizationHeader = s.RequiresAuthorizationHeader
+	e.RequiresSignedUrls = s.RequiresSignedUrls
+	e.RequiresJwt = s.RequiresJwt
+	e.AllowedNetworks = s.AllowedCidrs
+	e.DeniedNetworks = s.DeniedCidrs
+	e.Backup = s.Backup
+	e.MaxRequestBodyBytes = s.MaxRequestBodyBytes
+	e.DisableCompression = s.DisableCompression
+	e.DisableBanners = s.DisableBanners
+	e.Group = s.Group
+	e.Weight = s.Weight
+	e.AppVersion = s.AppVersion
+	e.Match = s.Match
+	return e, nil
+}
+
+// snapshot returns the route table of r.
+func (r *RouteRegistry) snapshot() routeSnapshot {
+	r.RLock()
+	defer r.RUnlock()
+
+	s := routeSnapshot{SavedAt: r.clock.Now(), Routes: []snapshotEndpoint{}}
+	add := func(pool *route.Pool, f func(e *snapshotEndpoint)) {
+		pool.Each(func(endpoint *route.Endpoint) {
+			e := newSnapshotEndpoint(endpoint)
+			f(&e)
+			s.Routes = append(s.Routes, e)
+		})
+	}
+
+	r.eachShard(func(s *shard) {
+		s.each(func(uri route.Uri, pool *route.Pool) {
+			add(pool, func(e *snapshotEndpoint) { e.Uri = uri })
+		})
+	})
+	for uri, pool := range r.bySni {
+		add(pool, func(e *snapshotEndpoint) { e.Uri = uri; e.TlsPassthrough = true })
+	}
+	for port, pool := range r.byPort {
+		add(pool, func(e *snapshotEndpoint) { e.Port = port })
+	}
+	return s
+}
+
+// A Snapshotter writes the route table of a registry to a file, and
+// restores it from there when the router starts, so that a restarted router
+// serves the routes it knew while it waits for them to be registered again.
+// Restored endpoints are pruned like any other when no registration
+// refreshes them within their stale threshold.
+type Snapshotter struct {
+	sync.Mutex
+
+	registry *RouteRegistry
+	file     string
+	interval time.Duration
+	clock    clock.Clock
+	logger   *steno.Logger
+
+	ticker clock.Ticker
+}
+
+func NewSnapshotter(r *RouteRegistry, c config.RouteSnapshotConfig) *Snapshotter {
+	return &Snapshotter{
+		registry: r,
+		file:     c.File,
+		interval: c.Interval,
+		clock:    r.clock,
+		logger:   steno.NewLogger("router.registry.snapshot"),
+	}
+}
+
+// Restore registers the routes of the snapshot file, and returns how many
+// endpoints it registered. A missing file restores nothing, and so does a
+// snapshot older than the stale threshold of the registry, whose routes
+// would have been pruned had the router kept running.
+func (s *Snapshotter) Restore() (int, error) {
+	b, err := ioutil.ReadFile(s.file)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	var snapshot routeSnapshot
+	err = json.Unmarshal(b, &snapshot)
+	if err != nil {
+		return 0, err
+	}
+
+	age := s.clock.Since(snapshot.SavedAt)
+	if age > s.registry.dropletStaleThreshold {
+		s.logger.Infod(map[string]interface{}{"age": age.String()}, "registry.snapshot.stale")
+		return 0, nil
+	}
+
+	restored := 0
+	for _, r := range snapshot.Routes {
+		e, err := r.endpoint()
+		if err != nil {
+			s.logger.Warnd(map[string]interface{}{"addr": r.Addr, "error": err.Error()}, "registry.snapshot.invalid-endpoint")
+			continue
+		}
+
+		switch {
+		case r.Port != 0:
+			s.registry.RegisterTcp(r.Port, e)
+		case r.TlsPassthrough:
+			s.registry.RegisterTlsPassthrough(r.Uri, e)
+		default:
+			s.registry.Register(r.Uri, e)
+		}
+		restored++
+	}
+	return restored, nil
+}
+
+// Write writes the route table to the snapshot file. The table is written
+// to a file alongside first, and moved over the snapshot once complete, so
+// that a router stopped halfway leaves the previous snapshot behind.
+func (s *Snapshotter) Write() error {
+	b, err := json.Marshal(s.registry.snapshot())
+	if err != nil {
+		return err
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	f, err := ioutil.TempFile(filepath.Dir(s.file), filepath.Base(s.file)+".")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	_, err = f.Write(b)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), s.file)
+}
+
+// Start writes the route table every interval until Stop.
+func (s *Snapshotter) Start() {
+	s.Lock()
+	s.ticker = s.clock.NewTicker(s.interval)
+	ticker := s.ticker
+	s.Unlock()
+
+	go func() {
+		for range ticker.C() {
+			err := s.Write()
+			if err != nil {
+				s.logger.Warnd(map[string]interface{}{"error": err.Error()}, "registry.snapshot.write-failed")
+			}
+		}
+	}()
+}
+
+// Stop stops the periodic writes, and writes the route table one last time.
+func (s *Snapshotter) Stop() error {
+	s.Lock()
+	if s.ticker != nil {
+		s.ticker.Stop()
+	}
+	s.Unlock()
+
+	return s.Write()
+}
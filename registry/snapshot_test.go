@@ -0,0 +1,142 @@
+package registry_test
+
+import (
+	. "github.com/cloudfoundry/gorouter/registry"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/cloudfoundry/gorouter/clock"
+	"github.com/cloudfoundry/gorouter/clock/fakeclock"
+	"github.com/cloudfoundry/gorouter/config"
+	"github.com/cloudfoundry/gorouter/route"
+	"github.com/cloudfoundry/yagnats/fakeyagnats"
+
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+var _ = Describe("Snapshotter", func() {
+	var (
+		dir      string
+		c        config.RouteSnapshotConfig
+		fakeTime *fakeclock.FakeClock
+		r        *RouteRegistry
+	)
+
+	newRegistry := func() *RouteRegistry {
+		c := config.DefaultConfig()
+		c.TcpRouting.Ports = []uint16{5000}
+
+		registry := NewRouteRegistry(c, fakeyagnats.Connect())
+		registry.SetClock(fakeTime, clock.NewRandom())
+		return registry
+	}
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "snapshot")
+		Ω(err).NotTo(HaveOccurred())
+
+		c = config.RouteSnapshotConfig{
+			Enabled:  true,
+			File:     filepath.Join(dir, "routes.json"),
+			Interval: 10 * time.Second,
+		}
+		fakeTime = fakeclock.New(time.Now())
+		r = newRegistry()
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	It("restores the routes of the snapshot", func() {
+		e := route.NewEndpoint("app", "192.168.1.1", 1234, "instance", map[string]string{"space": "prod"}, 60)
+		e.Backup = true
+		e.Weight = 10
+		e.AppVersion = "v1"
+		e.Timeout = time.Minute
+		r.Register("app.example.com", e)
+
+		matched := route.NewEndpoint("app", "192.168.1.2", 1234, "", nil, -1)
+		matched.Match = route.Match{Headers: map[string]string{"X-Beta": "true"}}
+		r.Register("app.example.com", matched)
+
+		r.RegisterTlsPassthrough("tls.example.com", route.NewEndpoint("", "192.168.1.3", 443, "", nil, -1))
+		r.RegisterTcp(5000, route.NewEndpoint("", "192.168.1.4", 6000, "", nil, -1))
+
+		Ω(NewSnapshotter(r, c).Write()).To(Succeed())
+
+		restarted := newRegistry()
+		restored, err := NewSnapshotter(restarted, c).Restore()
+		Ω(err).NotTo(HaveOccurred())
+		Ω(restored).To(Equal(4))
+
+		restoredEndpoint := restarted.Lookup("app.example.com").Endpoints("").Next()
+		Ω(restoredEndpoint.CanonicalAddr()).To(Equal("192.168.1.1:1234"))
+		Ω(restoredEndpoint.ApplicationId).To(Equal("app"))
+		Ω(restoredEndpoint.PrivateInstanceId).To(Equal("instance"))
+		Ω(restoredEndpoint.Tags).To(Equal(map[string]string{"space": "prod"}))
+		Ω(restoredEndpoint.StaleThreshold()).To(Equal(time.Minute))
+		Ω(restoredEndpoint.Backup).To(BeTrue())
+		Ω(restoredEndpoint.Weight).To(Equal(10))
+		Ω(restoredEndpoint.AppVersion).To(Equal("v1"))
+		Ω(restoredEndpoint.Timeout).To(Equal(time.Minute))
+
+		Ω(restarted.NumUris()).To(Equal(1))
+		Ω(restarted.NumEndpoints()).To(Equal(2))
+		Ω(restarted.LookupTlsPassthrough("tls.example.com")).NotTo(BeNil())
+		Ω(restarted.LookupTcp(5000).Endpoints("").Next().CanonicalAddr()).To(Equal("192.168.1.4:6000"))
+	})
+
+	It("restores nothing without a snapshot", func() {
+		restored, err := NewSnapshotter(r, c).Restore()
+		Ω(err).NotTo(HaveOccurred())
+		Ω(restored).To(BeZero())
+	})
+
+	It("restores nothing from a snapshot older than the stale threshold", func() {
+		r.Register("app.example.com", route.NewEndpoint("", "192.168.1.1", 1234, "", nil, -1))
+		Ω(NewSnapshotter(r, c).Write()).To(Succeed())
+
+		fakeTime.Increment(3 * time.Minute)
+
+		restarted := newRegistry()
+		restored, err := NewSnapshotter(restarted, c).Restore()
+		Ω(err).NotTo(HaveOccurred())
+		Ω(restored).To(BeZero())
+		Ω(restarted.NumUris()).To(BeZero())
+	})
+
+	It("fails on a snapshot it cannot read", func() {
+		Ω(ioutil.WriteFile(c.File, []byte("{"), 0644)).To(Succeed())
+
+		_, err := NewSnapshotter(r, c).Restore()
+		Ω(err).To(HaveOccurred())
+	})
+
+	It("writes the route table every interval and when it stops", func() {
+		s := NewSnapshotter(r, c)
+		s.Start()
+
+		r.Register("app.example.com", route.NewEndpoint("", "192.168.1.1", 1234, "", nil, -1))
+		fakeTime.Increment(c.Interval)
+		Eventually(func() bool {
+			_, err := os.Stat(c.File)
+			return err == nil
+		}).Should(BeTrue())
+
+		r.Register("other.example.com", route.NewEndpoint("", "192.168.1.2", 1234, "", nil, -1))
+		Ω(s.Stop()).To(Succeed())
+
+		restarted := newRegistry()
+		restored, err := NewSnapshotter(restarted, c).Restore()
+		Ω(err).NotTo(HaveOccurred())
+		Ω(restored).To(Equal(2))
+
+		files, _ := ioutil.ReadDir(dir)
+		Ω(files).To(HaveLen(1))
+	})
+})
@@ -0,0 +1,131 @@
+package registry
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/cloudfoundry/gorouter/route"
+)
+
+// VersionPolicy returns the policy that steers the requests of uri between
+// the versions of its app.
+func (r *RouteRegistry) VersionPolicy(uri route.Uri) route.VersionPolicy {
+	r.RLock()
+	defer r.RUnlock()
+
+	return r.versionPolicies[uri.ToLower()]
+}
+
+// SetVersionPolicy has the pools of uri, those of its matches included,
+// steer new requests between the versions of its app by policy. The policy
+// outlives the endpoints of uri, and applies again when they register.
+func (r *RouteRegistry) SetVersionPolicy(uri route.Uri, policy route.VersionPolicy) {
+	r.Lock()
+	defer r.Unlock()
+
+	uri = uri.ToLower()
+	if policy.IsEmpty() {
+		delete(r.versionPolicies, uri)
+	} else {
+		r.versionPolicies[uri] = policy
+	}
+
+	s := r.shard(uri)
+	t := s.published()
+	if pool, found := t.byUri[uri]; found {
+		pool.SetVersionPolicy(policy)
+	}
+	for _, m := range t.byMatch[uri] {
+		m.pool.SetVersionPolicy(policy)
+	}
+}
+
+// Versions returns the stats of the versions of the app of every HTTP route
+// with endpoints registered with a version.
+func (r *RouteRegistry) Versions() map[route.Uri][]route.VersionStats {
+	versions := make(map[route.Uri][]route.VersionStats)
+	r.eachShard(func(s *shard) {
+		t := s.published()
+		for uri, pool := range t.byUri {
+			if stats := pool.Versions(); len(stats) > 0 {
+				versions[uri] = stats
+			}
+		}
+		for uri, matched := range t.byMatch {
+			stats := [][]route.VersionStats{versions[uri]}
+			for _, m := range matched {
+				stats = append(stats, m.pool.Versions())
+			}
+			if merged := route.MergeVersions(stats...); len(merged) > 0 {
+				versions[uri] = merged
+			}
+		}
+	})
+	return versions
+}
+
+// ServeVersions lists the versions of every route, or of the route of the
+// host query parameter, on GET. On POST it pins, unpins, drains or undrains
+// the version of the route of host, as the action query parameter says,
+// and lists the versions of the route.
+func (r *RouteRegistry) ServeVersions(w http.ResponseWriter, req *http.Request) {
+	host := route.Uri(req.URL.Query().Get("host")).ToLower()
+
+	switch req.Method {
+	case "GET":
+	case "POST":
+		version := req.URL.Query().Get("version")
+		if host == "" || version == "" {
+			http.Error(w, "host and version are required", http.StatusBadRequest)
+			return
+		}
+
+		policy, ok := applyVersionAction(r.VersionPolicy(host), req.URL.Query().Get("action"), version)
+		if !ok {
+			http.Error(w, "action must be pin, unpin, drain or undrain", http.StatusBadRequest)
+			return
+		}
+		r.SetVersionPolicy(host, policy)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	versions := r.Versions()
+	if host != "" {
+		versions = map[route.Uri][]route.VersionStats{host: versions[host]}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(versions)
+}
+
+func applyVersionAction(policy route.VersionPolicy, action, version string) (route.VersionPolicy, bool) {
+	drained := make([]string, 0, len(policy.Drained)+1)
+	for _, d := range policy.Drained {
+		if d != version {
+			drained = append(drained, d)
+		}
+	}
+
+	switch action {
+	case "pin":
+		policy.Pinned = version
+	case "unpin":
+		if policy.Pinned == version {
+			policy.Pinned = ""
+		}
+	case "drain":
+		drained = append(drained, version)
+	case "undrain":
+	default:
+		return policy, false
+	}
+
+	if len(drained) == 0 {
+		drained = nil
+	}
+	policy.Drained = drained
+	return policy, true
+}
@@ -0,0 +1,115 @@
+package registry_test
+
+import (
+	. "github.com/cloudfoundry/gorouter/registry"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/cloudfoundry/gorouter/config"
+	"github.com/cloudfoundry/gorouter/route"
+	"github.com/cloudfoundry/yagnats/fakeyagnats"
+
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+)
+
+var _ = Describe("Versions", func() {
+	var r *RouteRegistry
+	var v1, v2 *route.Endpoint
+
+	BeforeEach(func() {
+		r = NewRouteRegistry(config.DefaultConfig(), fakeyagnats.Connect())
+
+		v1 = route.NewEndpoint("", "192.168.1.1", 1234, "", nil, -1)
+		v1.AppVersion = "v1"
+		v2 = route.NewEndpoint("", "192.168.1.2", 1234, "", nil, -1)
+		v2.AppVersion = "v2"
+
+		r.Register("app.example.com", v1)
+		r.Register("app.example.com", v2)
+	})
+
+	serve := func(method, query string) (int, map[route.Uri][]route.VersionStats) {
+		req, _ := http.NewRequest(method, "/versions?"+query, nil)
+		w := httptest.NewRecorder()
+		r.ServeVersions(w, req)
+
+		var versions map[route.Uri][]route.VersionStats
+		if w.Code == http.StatusOK {
+			Ω(json.Unmarshal(w.Body.Bytes(), &versions)).To(Succeed())
+		}
+		return w.Code, versions
+	}
+
+	It("lists the versions of the routes", func() {
+		r.Register("other.example.com", route.NewEndpoint("", "192.168.1.3", 1234, "", nil, -1))
+
+		code, versions := serve("GET", "")
+		Ω(code).To(Equal(http.StatusOK))
+		Ω(versions).To(HaveLen(1))
+		Ω(versions["app.example.com"]).To(HaveLen(2))
+		Ω(versions["app.example.com"][0].Version).To(Equal("v1"))
+		Ω(versions["app.example.com"][0].Endpoints).To(Equal(1))
+	})
+
+	It("pins a version of a route", func() {
+		code, versions := serve("POST", "host=App.example.com&version=v2&action=pin")
+		Ω(code).To(Equal(http.StatusOK))
+		Ω(versions["app.example.com"][1].Pinned).To(BeTrue())
+
+		for i := 0; i < 5; i++ {
+			Ω(r.Lookup("app.example.com").Endpoints("").Next()).To(Equal(v2))
+		}
+	})
+
+	It("drains and undrains a version of a route", func() {
+		_, versions := serve("POST", "host=app.example.com&version=v1&action=drain")
+		Ω(versions["app.example.com"][0].Drained).To(BeTrue())
+		for i := 0; i < 5; i++ {
+			Ω(r.Lookup("app.example.com").Endpoints("").Next()).To(Equal(v2))
+		}
+
+		_, versions = serve("POST", "host=app.example.com&version=v1&action=undrain")
+		Ω(versions["app.example.com"][0].Drained).To(BeFalse())
+		Ω(r.VersionPolicy("app.example.com").IsEmpty()).To(BeTrue())
+	})
+
+	It("applies the policy of a route to endpoints that register later", func() {
+		r.SetVersionPolicy("new.example.com", route.VersionPolicy{Pinned: "v2"})
+		r.Register("new.example.com", v1)
+		r.Register("new.example.com", v2)
+
+		for i := 0; i < 5; i++ {
+			Ω(r.Lookup("new.example.com").Endpoints("").Next()).To(Equal(v2))
+		}
+	})
+
+	It("applies the policy to the endpoints of every match of a route", func() {
+		matched := route.NewEndpoint("", "192.168.1.4", 1234, "", nil, -1)
+		matched.AppVersion = "v1"
+		matched.Match = route.Match{Headers: map[string]string{"X-Beta": "true"}}
+		r.Register("app.example.com", matched)
+
+		r.SetVersionPolicy("app.example.com", route.VersionPolicy{Drained: []string{"v2"}})
+
+		versions := r.Versions()["app.example.com"]
+		Ω(versions[0].Endpoints).To(Equal(2))
+		Ω(versions[1].Drained).To(BeTrue())
+	})
+
+	It("rejects actions without a host or version", func() {
+		code, _ := serve("POST", "version=v1&action=pin")
+		Ω(code).To(Equal(http.StatusBadRequest))
+	})
+
+	It("rejects unknown actions", func() {
+		code, _ := serve("POST", "host=app.example.com&version=v1&action=promote")
+		Ω(code).To(Equal(http.StatusBadRequest))
+	})
+
+	It("rejects other methods", func() {
+		code, _ := serve("DELETE", "host=app.example.com")
+		Ω(code).To(Equal(http.StatusMethodNotAllowed))
+	})
+})
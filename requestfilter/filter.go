@@ -0,0 +1,120 @@
+package requestfilter
+
+import (
+	"net"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/cloudfoundry/gorouter/config"
+)
+
+// A Filter matches the requests that any of its rules matches. A nil Filter
+// matches nothing.
+type Filter struct {
+	rules []*Rule
+}
+
+// New returns a filter of the rules of configs, or nil when there are none.
+func New(configs []config.RequestFilterConfig) *Filter {
+	if len(configs) == 0 {
+		return nil
+	}
+
+	f := &Filter{}
+	for _, c := range configs {
+		f.rules = append(f.rules, NewRule(c))
+	}
+	return f
+}
+
+func (f *Filter) Matches(request *http.Request) bool {
+	if f == nil {
+		return false
+	}
+
+	for _, r := range f.rules {
+		if r.Matches(request) {
+			return true
+		}
+	}
+	return false
+}
+
+// A Rule matches requests by their host, path and method. Each of them
+// that the rule has no patterns for matches any request.
+type Rule struct {
+	routes  []string
+	paths   []string
+	methods []string
+}
+
+func NewRule(c config.RequestFilterConfig) *Rule {
+	r := &Rule{paths: c.Paths}
+	for _, route := range c.Routes {
+		r.routes = append(r.routes, strings.ToLower(route))
+	}
+	for _, method := range c.Methods {
+		r.methods = append(r.methods, strings.ToUpper(method))
+	}
+	return r
+}
+
+func (r *Rule) Matches(request *http.Request) bool {
+	return r.MatchesHost(Hostname(request.Host)) &&
+		r.matchesPath(request.URL.Path) &&
+		r.matchesMethod(request.Method)
+}
+
+// MatchesHost tells whether host, without a port and in lower case, is one
+// of the routes of the rule.
+func (r *Rule) MatchesHost(host string) bool {
+	if len(r.routes) == 0 {
+		return true
+	}
+
+	for _, pattern := range r.routes {
+		if strings.HasPrefix(pattern, "*.") {
+			if strings.HasSuffix(host, pattern[1:]) {
+				return true
+			}
+		} else if host == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Rule) matchesPath(p string) bool {
+	if len(r.paths) == 0 {
+		return true
+	}
+
+	for _, pattern := range r.paths {
+		if ok, _ := path.Match(pattern, p); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Rule) matchesMethod(method string) bool {
+	if len(r.methods) == 0 {
+		return true
+	}
+
+	for _, m := range r.methods {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
+
+// Hostname returns the host of a request without its port, in lower case.
+func Hostname(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.ToLower(host)
+}
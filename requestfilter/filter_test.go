@@ -0,0 +1,47 @@
+package requestfilter_test
+
+import (
+	"net/http"
+
+	"github.com/cloudfoundry/gorouter/config"
+	"github.com/cloudfoundry/gorouter/requestfilter"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Filter", func() {
+	request := func(method, url string) *http.Request {
+		r, err := http.NewRequest(method, url, nil)
+		Ω(err).NotTo(HaveOccurred())
+		return r
+	}
+
+	It("matches nothing without rules", func() {
+		f := requestfilter.New(nil)
+		Ω(f).To(BeNil())
+		Ω(f.Matches(request("GET", "http://app.example.com/healthz"))).To(BeFalse())
+	})
+
+	It("matches requests by path on every route", func() {
+		f := requestfilter.New([]config.RequestFilterConfig{
+			{Paths: []string{"/healthz", "/status/*"}},
+		})
+
+		Ω(f.Matches(request("GET", "http://app.example.com/healthz"))).To(BeTrue())
+		Ω(f.Matches(request("POST", "http://other.example.com/status/db"))).To(BeTrue())
+		Ω(f.Matches(request("GET", "http://app.example.com/status/db/replica"))).To(BeFalse())
+		Ω(f.Matches(request("GET", "http://app.example.com/"))).To(BeFalse())
+	})
+
+	It("matches requests by route and method", func() {
+		f := requestfilter.New([]config.RequestFilterConfig{
+			{Routes: []string{"api.example.com", "*.apps.example.com"}, Paths: []string{"/metrics"}, Methods: []string{"get"}},
+		})
+
+		Ω(f.Matches(request("GET", "http://API.example.com:8080/metrics"))).To(BeTrue())
+		Ω(f.Matches(request("GET", "http://app.apps.example.com/metrics"))).To(BeTrue())
+		Ω(f.Matches(request("POST", "http://api.example.com/metrics"))).To(BeFalse())
+		Ω(f.Matches(request("GET", "http://www.example.com/metrics"))).To(BeFalse())
+	})
+})
@@ -0,0 +1,13 @@
+package requestfilter_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestRequestfilter(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Requestfilter Suite")
+}
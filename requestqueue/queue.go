@@ -0,0 +1,229 @@
+package requestqueue
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cloudfoundry/gorouter/clock"
+	"github.com/cloudfoundry/gorouter/config"
+)
+
+var (
+	ErrFull    = errors.New("request queue is full")
+	ErrTimeout = errors.New("request timed out in the queue")
+)
+
+// Queue caps the requests in flight through the router. Requests over the
+// cap wait for one in flight to finish, and are let through by weighted
+// fair queuing between their routes: every waiting request is tagged with
+// the virtual time at which its route would be done with it if each route
+// were served at the rate of its weight, and the request with the earliest
+// tag goes first. A route that floods the queue only pushes its own tags
+// out, so requests of the other routes keep getting through. A nil Queue
+// lets every request through at once.
+type Queue struct {
+	maxInFlight int
+	maxQueued   int
+	timeout     time.Duration
+	weights     map[string]int
+	clock       clock.Clock
+
+	mu          sync.Mutex
+	inFlight    int
+	queued      int
+	routes      map[string]*routeQueue
+	virtualTime float64
+	seq         uint64
+}
+
+type routeQueue struct {
+	weight     int
+	lastFinish float64
+	waiters    []*waiter
+}
+
+type waiter struct {
+	route    string
+	finish   float64
+	seq      uint64
+	ready    chan struct{}
+	admitted bool
+}
+
+// New returns the queue configured by c, or nil when c sets no maximum of
+// requests in flight.
+func New(c config.RequestQueueConfig, clk clock.Clock) *Queue {
+	if c.MaxInFlight <= 0 {
+		return nil
+	}
+
+	q := &Queue{
+		maxInFlight: c.MaxInFlight,
+		maxQueued:   c.MaxQueued,
+		timeout:     c.Timeout,
+		weights:     make(map[string]int),
+		clock:       clk,
+		routes:      make(map[string]*routeQueue),
+	}
+	for host, weight := range c.Weights {
+		q.weights[normalize(host)] = weight
+	}
+
+	return q
+}
+
+// Acquire lets a request for the route of host through, waiting in the
+// queue while the router is at its cap. It returns ErrFull without waiting
+// when the queue is full, ErrTimeout when the request waited too long, and
+// the error of ctx when it is done first. Otherwise the caller must call
+// release once the request is answered.
+func (q *Queue) Acquire(ctx context.Context, host string) (release func(), err error) {
+	if q == nil {
+		return func() {}, nil
+	}
+
+	q.mu.Lock()
+	if q.inFlight < q.maxInFlight && q.queued == 0 {
+		q.inFlight++
+		q.mu.Unlock()
+		return q.release, nil
+	}
+	if q.queued >= q.maxQueued {
+		q.mu.Unlock()
+		return nil, ErrFull
+	}
+	w := q.enqueue(normalize(host))
+	q.mu.Unlock()
+
+	select {
+	case <-w.ready:
+		return q.release, nil
+	case <-q.clock.After(q.timeout):
+		err = ErrTimeout
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	// the request may have been let through as it gave up; its turn goes
+	// to the next one
+	if w.admitted {
+		q.admitNext()
+		return nil, err
+	}
+	q.remove(w)
+	return nil, err
+}
+
+// InFlight returns the number of requests let through that are not
+// answered yet.
+func (q *Queue) InFlight() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return q.inFlight
+}
+
+// Queued returns the number of requests waiting.
+func (q *Queue) Queued() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return q.queued
+}
+
+func (q *Queue) release() {
+	q.mu.Lock()
+	q.admitNext()
+	q.mu.Unlock()
+}
+
+// admitNext hands the turn of a request that is done to the waiting request
+// with the earliest finish tag, keeping it in flight, or frees it.
+func (q *Queue) admitNext() {
+	var next *routeQueue
+	for _, r := range q.routes {
+		if next == nil || before(r.waiters[0], next.waiters[0]) {
+			next = r
+		}
+	}
+	if next == nil {
+		q.inFlight--
+		return
+	}
+
+	w := next.waiters[0]
+	next.waiters = next.waiters[1:]
+	q.queued--
+	if len(next.waiters) == 0 {
+		delete(q.routes, w.route)
+	}
+
+	q.virtualTime = w.finish
+	w.admitted = true
+	close(w.ready)
+}
+
+func (q *Queue) enqueue(host string) *waiter {
+	r, ok := q.routes[host]
+	if !ok {
+		weight := q.weights[host]
+		if weight == 0 {
+			weight = 1
+		}
+		r = &routeQueue{weight: weight}
+		q.routes[host] = r
+	}
+
+	start := r.lastFinish
+	if start < q.virtualTime {
+		start = q.virtualTime
+	}
+	r.lastFinish = start + 1/float64(r.weight)
+
+	q.seq++
+	w := &waiter{
+		route:  host,
+		finish: r.lastFinish,
+		seq:    q.seq,
+		ready:  make(chan struct{}),
+	}
+	r.waiters = append(r.waiters, w)
+	q.queued++
+
+	return w
+}
+
+func (q *Queue) remove(w *waiter) {
+	r := q.routes[w.route]
+	for i, other := range r.waiters {
+		if other == w {
+			r.waiters = append(r.waiters[:i], r.waiters[i+1:]...)
+			q.queued--
+			break
+		}
+	}
+	if len(r.waiters) == 0 {
+		delete(q.routes, w.route)
+	}
+}
+
+func before(a, b *waiter) bool {
+	if a.finish != b.finish {
+		return a.finish < b.finish
+	}
+	return a.seq < b.seq
+}
+
+func normalize(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.ToLower(host)
+}
@@ -0,0 +1,179 @@
+package requestqueue_test
+
+import (
+	"context"
+	"time"
+
+	"github.com/cloudfoundry/gorouter/clock/fakeclock"
+	"github.com/cloudfoundry/gorouter/config"
+	. "github.com/cloudfoundry/gorouter/requestqueue"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Queue", func() {
+	var (
+		c     config.RequestQueueConfig
+		clock *fakeclock.FakeClock
+		q     *Queue
+	)
+
+	type admission struct {
+		host    string
+		release func()
+	}
+
+	BeforeEach(func() {
+		c = config.RequestQueueConfig{
+			MaxInFlight: 1,
+			MaxQueued:   10,
+			Timeout:     10 * time.Second,
+		}
+		clock = fakeclock.New(time.Now())
+	})
+
+	JustBeforeEach(func() {
+		q = New(c, clock)
+	})
+
+	// wait queues a request for each of hosts in turn and sends them on the
+	// returned channel as they are let through
+	wait := func(hosts ...string) <-chan admission {
+		admitted := make(chan admission, len(hosts))
+		for _, host := range hosts {
+			queued := q.Queued()
+			go func(host string) {
+				defer GinkgoRecover()
+				release, err := q.Acquire(context.Background(), host)
+				Expect(err).NotTo(HaveOccurred())
+				admitted <- admission{host, release}
+			}(host)
+			Eventually(q.Queued).Should(Equal(queued + 1))
+		}
+		return admitted
+	}
+
+	order := func(admitted <-chan admission, n int) []string {
+		var hosts []string
+		for i := 0; i < n; i++ {
+			var a admission
+			Eventually(admitted).Should(Receive(&a))
+			hosts = append(hosts, a.host)
+			a.release()
+		}
+		return hosts
+	}
+
+	It("is disabled without a maximum in flight", func() {
+		q := New(config.RequestQueueConfig{}, clock)
+		Expect(q).To(BeNil())
+
+		release, err := q.Acquire(context.Background(), "app.example.com")
+		Expect(err).NotTo(HaveOccurred())
+		release()
+	})
+
+	It("lets requests through up to the maximum in flight", func() {
+		c.MaxInFlight = 2
+
+		q := New(c, clock)
+		_, err := q.Acquire(context.Background(), "app.example.com")
+		Expect(err).NotTo(HaveOccurred())
+		release, err := q.Acquire(context.Background(), "app.example.com")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(q.InFlight()).To(Equal(2))
+
+		release()
+		Expect(q.InFlight()).To(Equal(1))
+	})
+
+	It("does not let one route starve the others", func() {
+		release, err := q.Acquire(context.Background(), "busy.example.com")
+		Expect(err).NotTo(HaveOccurred())
+
+		admitted := wait("busy.example.com", "busy.example.com", "busy.example.com", "quiet.example.com")
+		release()
+
+		Expect(order(admitted, 4)).To(Equal([]string{
+			"busy.example.com",
+			"quiet.example.com",
+			"busy.example.com",
+			"busy.example.com",
+		}))
+		Expect(q.InFlight()).To(Equal(0))
+	})
+
+	Context("with weights", func() {
+		BeforeEach(func() {
+			c.Weights = map[string]int{"heavy.example.com": 2}
+		})
+
+		It("lets routes through in proportion to their weights", func() {
+			release, err := q.Acquire(context.Background(), "light.example.com")
+			Expect(err).NotTo(HaveOccurred())
+
+			admitted := wait(
+				"light.example.com", "light.example.com",
+				"HEAVY.example.com:8080", "heavy.example.com", "heavy.example.com", "heavy.example.com",
+			)
+			release()
+
+			Expect(order(admitted, 6)).To(Equal([]string{
+				"HEAVY.example.com:8080",
+				"light.example.com",
+				"heavy.example.com",
+				"heavy.example.com",
+				"light.example.com",
+				"heavy.example.com",
+			}))
+		})
+	})
+
+	It("refuses requests when the queue is full", func() {
+		c.MaxQueued = 1
+
+		q := New(c, clock)
+		_, err := q.Acquire(context.Background(), "app.example.com")
+		Expect(err).NotTo(HaveOccurred())
+
+		go q.Acquire(context.Background(), "app.example.com")
+		Eventually(q.Queued).Should(Equal(1))
+
+		_, err = q.Acquire(context.Background(), "other.example.com")
+		Expect(err).To(Equal(ErrFull))
+	})
+
+	It("gives up on requests that wait too long", func() {
+		_, err := q.Acquire(context.Background(), "app.example.com")
+		Expect(err).NotTo(HaveOccurred())
+
+		errs := make(chan error, 1)
+		go func() {
+			_, err := q.Acquire(context.Background(), "app.example.com")
+			errs <- err
+		}()
+		Eventually(clock.WatcherCount).Should(Equal(1))
+
+		clock.Increment(10 * time.Second)
+		Eventually(errs).Should(Receive(Equal(ErrTimeout)))
+		Expect(q.Queued()).To(Equal(0))
+		Expect(q.InFlight()).To(Equal(1))
+	})
+
+	It("gives up on requests whose clients are gone", func() {
+		_, err := q.Acquire(context.Background(), "app.example.com")
+		Expect(err).NotTo(HaveOccurred())
+
+		ctx, cancel := context.WithCancel(context.Background())
+		errs := make(chan error, 1)
+		go func() {
+			_, err := q.Acquire(ctx, "app.example.com")
+			errs <- err
+		}()
+		Eventually(q.Queued).Should(Equal(1))
+
+		cancel()
+		Eventually(errs).Should(Receive(Equal(context.Canceled)))
+		Expect(q.Queued()).To(Equal(0))
+	})
+})
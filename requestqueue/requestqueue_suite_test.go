@@ -0,0 +1,13 @@
+package requestqueue_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestRequestqueue(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Requestqueue Suite")
+}
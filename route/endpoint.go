@@ -4,6 +4,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"time"
+
+	"github.com/cloudfoundry/gorouter/ipfilter"
 )
 
 func NewEndpoint(appId, host string, port uint16, privateInstanceId string,
@@ -18,11 +20,65 @@ func NewEndpoint(appId, host string, port uint16, privateInstanceId string,
 }
 
 type Endpoint struct {
-	ApplicationId     string
-	addr              string
-	Tags              map[string]string
-	PrivateInstanceId string
-	staleThreshold    time.Duration
+	ApplicationId        string
+	addr                 string
+	Tags                 map[string]string
+	PrivateInstanceId    string
+	PrivateInstanceIndex string
+	HealthCheckPath      string
+	staleThreshold       time.Duration
+
+	// Timeout overrides the router's endpoint timeout for requests to the
+	// endpoint when it is set.
+	Timeout time.Duration
+
+	// RequiresAuthorizationHeader has the router turn away requests without
+	// an Authorization header before they reach the endpoint.
+	RequiresAuthorizationHeader bool
+
+	// RequiresSignedUrls has the router turn away requests whose URL does
+	// not carry a valid signature before they reach the endpoint.
+	RequiresSignedUrls bool
+
+	// RequiresJwt has the router turn away requests without a valid bearer
+	// token before they reach the endpoint.
+	RequiresJwt bool
+
+	// AllowedNetworks and DeniedNetworks have the router turn away requests
+	// from the clients they do not allow before they reach the endpoint.
+	AllowedNetworks ipfilter.Networks
+	DeniedNetworks  ipfilter.Networks
+
+	// Backup endpoints only receive requests while none of the other
+	// endpoints of their pool are available.
+	Backup bool
+
+	// MaxRequestBodyBytes overrides the router's limit on the size of
+	// request bodies for requests to the endpoint when it is set.
+	MaxRequestBodyBytes int64
+
+	// DisableCompression has the router pass responses from the endpoint on
+	// as they are, even when response compression is enabled.
+	DisableCompression bool
+
+	// DisableBanners has the router pass HTML responses from the endpoint
+	// on without the maintenance banners operators configured.
+	DisableBanners bool
+
+	// Group is the deployment of the route the endpoint belongs to, such as
+	// a canary. When a pool has several groups, each gets Weight percent of
+	// its requests, and the groups without a weight share the rest.
+	Group  string
+	Weight int
+
+	// AppVersion identifies the version of the app the endpoint runs, such
+	// as its droplet, so that requests can be steered between versions
+	// during a deploy.
+	AppVersion string
+
+	// Match has the endpoint take only the requests of its route that carry
+	// the headers and cookies it asks for.
+	Match Match
 }
 
 func (e *Endpoint) MarshalJSON() ([]byte, error) {
@@ -33,6 +89,12 @@ func (e *Endpoint) CanonicalAddr() string {
 	return e.addr
 }
 
+// StaleThreshold is how long the endpoint stays registered without being
+// refreshed, or 0 when the router's default applies.
+func (e *Endpoint) StaleThreshold() time.Duration {
+	return e.staleThreshold
+}
+
 func (e *Endpoint) ToLogData() interface{} {
 	return struct {
 		ApplicationId string
@@ -1,6 +1,9 @@
 package route_test
 
 import (
+	"github.com/cloudfoundry/gorouter/clock/fakeclock"
+	"github.com/cloudfoundry/gorouter/config"
+	"github.com/cloudfoundry/gorouter/limits"
 	. "github.com/cloudfoundry/gorouter/route"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
@@ -195,4 +198,460 @@ var _ = Describe("EndpointIterator", func() {
 			Ω(n1).ShouldNot(Equal(n2))
 		})
 	})
+
+	Describe("with a health policy", func() {
+		var e1, e2 *Endpoint
+
+		BeforeEach(func() {
+			pool.SetHealthPolicy(&HealthPolicy{
+				ConsecutiveFailures: 3,
+				FailureRate:         0.5,
+				RequestVolume:       10,
+				BaseEjection:        100 * time.Millisecond,
+				MaxEjection:         300 * time.Millisecond,
+			})
+
+			e1 = NewEndpoint("", "1.2.3.4", 5678, "", nil, -1)
+			e2 = NewEndpoint("", "5.6.7.8", 1234, "", nil, -1)
+			pool.Put(e1)
+			pool.Put(e2)
+		})
+
+		// respond sends requests until one goes to endpoint and reports the
+		// outcome for it.
+		respond := func(iter EndpointIterator, endpoint *Endpoint, healthy bool) {
+			for iter.Next() != endpoint {
+			}
+			iter.EndpointResponded(healthy)
+		}
+
+		next := func(iter EndpointIterator, n int) []*Endpoint {
+			var endpoints []*Endpoint
+			for i := 0; i < n; i++ {
+				endpoints = append(endpoints, iter.Next())
+			}
+			return endpoints
+		}
+
+		It("ejects an endpoint after consecutive failures", func() {
+			iter := pool.Endpoints("")
+			respond(iter, e1, false)
+			respond(iter, e1, false)
+			Ω(next(iter, 4)).To(ContainElement(e1))
+
+			respond(iter, e1, false)
+			Ω(next(iter, 4)).To(ConsistOf(e2, e2, e2, e2))
+		})
+
+		It("does not eject an endpoint whose failures are not consecutive", func() {
+			iter := pool.Endpoints("")
+			respond(iter, e1, false)
+			respond(iter, e1, false)
+			respond(iter, e1, true)
+			respond(iter, e1, false)
+
+			Ω(next(iter, 4)).To(ContainElement(e1))
+		})
+
+		It("ejects an endpoint when too many of its recent requests failed", func() {
+			iter := pool.Endpoints("")
+			for i := 0; i < 5; i++ {
+				respond(iter, e1, true)
+				respond(iter, e1, false)
+			}
+
+			Ω(next(iter, 4)).To(ConsistOf(e2, e2, e2, e2))
+		})
+
+		It("skips an ejected endpoint that is asked for by id", func() {
+			iter := pool.Endpoints("")
+			for i := 0; i < 3; i++ {
+				respond(iter, e1, false)
+			}
+
+			iter = pool.Endpoints(e1.CanonicalAddr())
+			Ω(iter.Next()).To(Equal(e2))
+		})
+
+		It("sends a single probe once the ejection is over", func() {
+			iter := pool.Endpoints("")
+			for i := 0; i < 3; i++ {
+				respond(iter, e1, false)
+			}
+
+			time.Sleep(120 * time.Millisecond)
+
+			endpoints := next(iter, 4)
+			Ω(endpoints).To(ContainElement(e1))
+			Ω(endpoints).To(ConsistOf(e1, e2, e2, e2))
+		})
+
+		It("returns the endpoint to the pool when the probe succeeds", func() {
+			iter := pool.Endpoints("")
+			for i := 0; i < 3; i++ {
+				respond(iter, e1, false)
+			}
+
+			time.Sleep(120 * time.Millisecond)
+			respond(iter, e1, true)
+
+			Ω(next(iter, 4)).To(ConsistOf(e1, e2, e1, e2))
+		})
+
+		It("ejects the endpoint for longer when the probe fails", func() {
+			iter := pool.Endpoints("")
+			for i := 0; i < 3; i++ {
+				respond(iter, e1, false)
+			}
+
+			time.Sleep(120 * time.Millisecond)
+			respond(iter, e1, false)
+
+			time.Sleep(120 * time.Millisecond)
+			Ω(next(iter, 4)).To(ConsistOf(e2, e2, e2, e2))
+
+			time.Sleep(120 * time.Millisecond)
+			Ω(next(iter, 4)).To(ContainElement(e1))
+		})
+
+		It("still sends requests when every endpoint is ejected", func() {
+			iter := pool.Endpoints("")
+			for i := 0; i < 3; i++ {
+				respond(iter, e1, false)
+				respond(iter, e2, false)
+			}
+
+			Ω(iter.Next()).ToNot(BeNil())
+		})
+	})
+
+	Describe("with an in-flight limit", func() {
+		var e1, e2 *Endpoint
+
+		BeforeEach(func() {
+			pool.SetInFlightLimit(limits.New("endpoint_iterator_test", config.LimitConfig{Max: 1}))
+
+			e1 = NewEndpoint("", "1.2.3.4", 5678, "", nil, -1)
+			e2 = NewEndpoint("", "5.6.7.8", 1234, "", nil, -1)
+			pool.Put(e1)
+			pool.Put(e2)
+		})
+
+		It("passes over endpoints at the limit", func() {
+			iter1 := pool.Endpoints("")
+			iter2 := pool.Endpoints("")
+			Ω([]*Endpoint{iter1.Next(), iter2.Next()}).To(ConsistOf(e1, e2))
+
+			iter3 := pool.Endpoints("")
+			Ω(iter3.Next()).To(BeNil())
+			Ω(iter3.AtCapacity()).To(BeTrue())
+		})
+
+		It("takes requests again once they are done", func() {
+			iter1 := pool.Endpoints("")
+			iter2 := pool.Endpoints("")
+			first := iter1.Next()
+			iter2.Next()
+
+			iter1.Done()
+
+			iter3 := pool.Endpoints("")
+			Ω(iter3.Next()).To(Equal(first))
+			Ω(iter3.AtCapacity()).To(BeFalse())
+		})
+
+		It("keeps upgraded connections in flight without counting them against the limit", func() {
+			iter1 := pool.Endpoints("")
+			iter2 := pool.Endpoints("")
+			first := iter1.Next()
+			iter2.Next()
+
+			closeUpgraded := iter1.Upgrade()
+
+			iter3 := pool.Endpoints("")
+			Ω(iter3.Next()).To(Equal(first))
+			iter3.Done()
+			Ω(pool.InFlight()).To(ContainElement(EndpointInFlight{Endpoint: first, Requests: 1}))
+
+			closeUpgraded()
+			Ω(pool.InFlight()).NotTo(ContainElement(EndpointInFlight{Endpoint: first, Requests: 1}))
+		})
+
+		It("ends the request to the previous endpoint on a retry", func() {
+			iter := pool.Endpoints("")
+			first := iter.Next()
+			iter.EndpointFailed()
+			Ω(iter.Next()).ToNot(Equal(first))
+
+			Ω(pool.Endpoints(first.CanonicalAddr()).Next()).To(Equal(first))
+		})
+
+		It("does not stick to an endpoint at the limit", func() {
+			iter := pool.Endpoints("")
+			busy := iter.Next()
+
+			Ω(pool.Endpoints(busy.CanonicalAddr()).Next()).ToNot(Equal(busy))
+		})
+	})
+
+	Describe("with backup endpoints", func() {
+		var primary, backup *Endpoint
+
+		BeforeEach(func() {
+			pool.SetFailbackDelay(100 * time.Millisecond)
+
+			primary = NewEndpoint("", "1.2.3.4", 5678, "", nil, -1)
+			backup = NewEndpoint("", "5.6.7.8", 1234, "", nil, -1)
+			backup.Backup = true
+			pool.Put(primary)
+			pool.Put(backup)
+		})
+
+		next := func(n int) []*Endpoint {
+			var endpoints []*Endpoint
+			for i := 0; i < n; i++ {
+				endpoints = append(endpoints, pool.Endpoints("").Next())
+			}
+			return endpoints
+		}
+
+		It("sends requests to the primary endpoints only", func() {
+			Ω(next(4)).To(ConsistOf(primary, primary, primary, primary))
+		})
+
+		It("fails over to the backups when no primary is available", func() {
+			pool.SetEndpointHealthy(primary, false)
+
+			Ω(next(4)).To(ConsistOf(backup, backup, backup, backup))
+		})
+
+		It("fails over when the primaries cannot be reached", func() {
+			iter := pool.Endpoints("")
+			Ω(iter.Next()).To(Equal(primary))
+			iter.EndpointFailed()
+
+			Ω(iter.Next()).To(Equal(backup))
+		})
+
+		It("fails back once the primaries have been available for the failback delay", func() {
+			pool.SetEndpointHealthy(primary, false)
+			Ω(next(1)).To(ConsistOf(backup))
+
+			pool.SetEndpointHealthy(primary, true)
+			Ω(next(2)).To(ConsistOf(backup, backup))
+
+			time.Sleep(120 * time.Millisecond)
+			Ω(next(2)).To(ConsistOf(primary, primary))
+		})
+
+		It("starts the failback delay over when a primary fails again", func() {
+			pool.SetEndpointHealthy(primary, false)
+			Ω(next(1)).To(ConsistOf(backup))
+
+			pool.SetEndpointHealthy(primary, true)
+			Ω(next(1)).To(ConsistOf(backup))
+			time.Sleep(60 * time.Millisecond)
+
+			pool.SetEndpointHealthy(primary, false)
+			Ω(next(1)).To(ConsistOf(backup))
+			pool.SetEndpointHealthy(primary, true)
+			time.Sleep(60 * time.Millisecond)
+
+			Ω(next(1)).To(ConsistOf(backup))
+		})
+
+		It("does not stick to a backup while the primaries are available", func() {
+			Ω(pool.Endpoints(backup.CanonicalAddr()).Next()).To(Equal(primary))
+		})
+
+		It("sends requests to the backups when only backups are registered", func() {
+			pool.Remove(primary)
+
+			Ω(next(2)).To(ConsistOf(backup, backup))
+		})
+	})
+
+	Describe("with weighted groups", func() {
+		var stable1, stable2, canary *Endpoint
+
+		BeforeEach(func() {
+			stable1 = NewEndpoint("", "1.2.3.4", 5678, "", nil, -1)
+			stable2 = NewEndpoint("", "1.2.3.5", 5678, "", nil, -1)
+			canary = NewEndpoint("", "5.6.7.8", 1234, "", nil, -1)
+			canary.Group = "canary"
+			canary.Weight = 10
+			pool.Put(stable1)
+			pool.Put(stable2)
+			pool.Put(canary)
+		})
+
+		count := func(n int) map[*Endpoint]int {
+			counts := make(map[*Endpoint]int)
+			for i := 0; i < n; i++ {
+				iter := pool.Endpoints("")
+				counts[iter.Next()]++
+				iter.Done()
+			}
+			return counts
+		}
+
+		It("sends each group its share of the requests exactly", func() {
+			counts := count(100)
+			Ω(counts[canary]).To(Equal(10))
+			Ω(counts[stable1]).To(Equal(45))
+			Ω(counts[stable2]).To(Equal(45))
+		})
+
+		It("spreads the requests of a group out evenly", func() {
+			var canaryAt []int
+			for i := 0; i < 30; i++ {
+				if pool.Endpoints("").Next() == canary {
+					canaryAt = append(canaryAt, i)
+				}
+			}
+			Ω(canaryAt).To(HaveLen(3))
+			Ω(canaryAt[1] - canaryAt[0]).To(Equal(10))
+			Ω(canaryAt[2] - canaryAt[1]).To(Equal(10))
+		})
+
+		It("shares what is left among the groups without a weight", func() {
+			blue := NewEndpoint("", "9.9.9.9", 1234, "", nil, -1)
+			blue.Group = "blue"
+			pool.Put(blue)
+
+			counts := count(200)
+			Ω(counts[canary]).To(Equal(20))
+			Ω(counts[blue]).To(Equal(90))
+			Ω(counts[stable1] + counts[stable2]).To(Equal(90))
+		})
+
+		It("splits by relative weight when every group has one", func() {
+			// the endpoints register again with weights, as they would
+			stable1 = NewEndpoint("", "1.2.3.4", 5678, "", nil, -1)
+			stable1.Weight = 30
+			stable2 = NewEndpoint("", "1.2.3.5", 5678, "", nil, -1)
+			stable2.Weight = 30
+			pool.Put(stable1)
+			pool.Put(stable2)
+
+			counts := count(80)
+			Ω(counts[canary]).To(Equal(20))
+			Ω(counts[stable1] + counts[stable2]).To(Equal(60))
+		})
+
+		It("sends a group's share to the other groups while none of its endpoints are available", func() {
+			pool.SetEndpointHealthy(canary, false)
+
+			counts := count(20)
+			Ω(counts[canary]).To(BeZero())
+			Ω(counts[stable1] + counts[stable2]).To(Equal(20))
+		})
+
+		It("balances as before with a single group", func() {
+			pool.Remove(canary)
+
+			counts := count(10)
+			Ω(counts[stable1]).To(Equal(5))
+			Ω(counts[stable2]).To(Equal(5))
+		})
+
+		It("weighs the groups again as endpoints register", func() {
+			Ω(count(10)[canary]).To(Equal(1))
+
+			heavier := NewEndpoint("", "5.6.7.8", 1234, "", nil, -1)
+			heavier.Group = "canary"
+			heavier.Weight = 50
+			pool.Put(heavier)
+
+			Ω(count(10)[heavier]).To(Equal(5))
+		})
+	})
+
+	Describe("with app versions", func() {
+		var v1, v2a, v2b *Endpoint
+
+		BeforeEach(func() {
+			v1 = NewEndpoint("", "1.2.3.4", 5678, "id-1", nil, -1)
+			v1.AppVersion = "v1"
+			v2a = NewEndpoint("", "1.2.3.5", 5678, "id-2a", nil, -1)
+			v2a.AppVersion = "v2"
+			v2b = NewEndpoint("", "1.2.3.6", 5678, "id-2b", nil, -1)
+			v2b.AppVersion = "v2"
+			pool.Put(v1)
+			pool.Put(v2a)
+			pool.Put(v2b)
+		})
+
+		count := func(n int) map[*Endpoint]int {
+			counts := make(map[*Endpoint]int)
+			for i := 0; i < n; i++ {
+				iter := pool.Endpoints("")
+				counts[iter.Next()]++
+				iter.Done()
+			}
+			return counts
+		}
+
+		It("reports the endpoints and traffic share of each version", func() {
+			count(30)
+
+			Ω(pool.Versions()).To(Equal([]VersionStats{
+				{Version: "v1", Endpoints: 1, Requests: 10, RecentRequests: 10, Share: float64(10) / 30},
+				{Version: "v2", Endpoints: 2, Requests: 20, RecentRequests: 20, Share: float64(20) / 30},
+			}))
+		})
+
+		It("sends new requests only to the pinned version", func() {
+			pool.SetVersionPolicy(VersionPolicy{Pinned: "v2"})
+
+			counts := count(10)
+			Ω(counts[v1]).To(BeZero())
+			Ω(counts[v2a] + counts[v2b]).To(Equal(10))
+		})
+
+		It("sends no new requests to a drained version", func() {
+			pool.SetVersionPolicy(VersionPolicy{Drained: []string{"v2"}})
+
+			counts := count(10)
+			Ω(counts[v1]).To(Equal(10))
+
+			stats := pool.Versions()
+			Ω(stats[1].Drained).To(BeTrue())
+			Ω(stats[1].Share).To(BeZero())
+		})
+
+		It("keeps sticky sessions on a drained version", func() {
+			pool.SetVersionPolicy(VersionPolicy{Drained: []string{"v2"}})
+
+			Ω(pool.Endpoints("id-2a").Next()).To(Equal(v2a))
+		})
+
+		It("sets the policy aside while none of the endpoints it leaves are available", func() {
+			pool.SetVersionPolicy(VersionPolicy{Pinned: "v1"})
+			pool.SetEndpointHealthy(v1, false)
+
+			counts := count(10)
+			Ω(counts[v2a] + counts[v2b]).To(Equal(10))
+		})
+
+		It("counts only the requests of the last minute or two towards the share", func() {
+			clock := fakeclock.New(time.Now())
+			pool.SetClock(clock)
+
+			pool.SetVersionPolicy(VersionPolicy{Pinned: "v1"})
+			count(10)
+			clock.Increment(time.Minute)
+			pool.SetVersionPolicy(VersionPolicy{Pinned: "v2"})
+			count(10)
+
+			stats := pool.Versions()
+			Ω(stats[0].Share).To(Equal(0.5))
+
+			clock.Increment(time.Minute)
+			stats = pool.Versions()
+			Ω(stats[0].Requests).To(Equal(int64(10)))
+			Ω(stats[0].RecentRequests).To(BeZero())
+			Ω(stats[1].Share).To(Equal(float64(1)))
+		})
+	})
 })
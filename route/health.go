@@ -0,0 +1,130 @@
+package route
+
+import "time"
+
+// HealthPolicy decides when a pool ejects an endpoint because its requests
+// keep failing. An endpoint is ejected after ConsecutiveFailures failures in
+// a row, or once FailureRate of its last RequestVolume requests failed. It
+// stays out of the pool for BaseEjection, doubled with every ejection that
+// follows a failed probe, up to MaxEjection. When that time is up the
+// endpoint is sent a single probe request: success puts it back in the pool
+// and failure ejects it again.
+type HealthPolicy struct {
+	ConsecutiveFailures int
+	FailureRate         float64
+	RequestVolume       int
+	BaseEjection        time.Duration
+	MaxEjection         time.Duration
+}
+
+type endpointHealth struct {
+	consecutiveFailures int
+
+	outcomes []bool
+	next     int
+	failures int
+
+	ejections    int
+	ejectedUntil time.Time
+	probeSentAt  time.Time
+}
+
+// available reports whether an endpoint can be handed out, which an ejected
+// endpoint can only be once its ejection is over and no probe is in flight.
+// A probe that never reports back is given up on after the base ejection.
+func (h *endpointHealth) available(policy *HealthPolicy, now time.Time) bool {
+	if policy == nil || h.ejections == 0 {
+		return true
+	}
+	if now.Before(h.ejectedUntil) {
+		return false
+	}
+	return h.probeSentAt.IsZero() || now.Sub(h.probeSentAt) > policy.BaseEjection
+}
+
+func (h *endpointHealth) selected(policy *HealthPolicy, now time.Time) {
+	if policy != nil && h.ejections > 0 {
+		h.probeSentAt = now
+	}
+}
+
+func (h *endpointHealth) succeeded(policy *HealthPolicy) {
+	if policy == nil {
+		return
+	}
+
+	if h.ejections > 0 {
+		h.ejections = 0
+		h.ejectedUntil = time.Time{}
+		h.probeSentAt = time.Time{}
+	}
+
+	h.consecutiveFailures = 0
+	h.record(policy, true)
+}
+
+func (h *endpointHealth) failed(policy *HealthPolicy, now time.Time) {
+	if policy == nil {
+		return
+	}
+
+	if h.ejections > 0 {
+		if !h.probeSentAt.IsZero() {
+			h.eject(policy, now)
+		}
+		return
+	}
+
+	h.consecutiveFailures++
+	h.record(policy, false)
+
+	if policy.ConsecutiveFailures > 0 && h.consecutiveFailures >= policy.ConsecutiveFailures {
+		h.eject(policy, now)
+		return
+	}
+
+	n := len(h.outcomes)
+	if policy.FailureRate > 0 && n > 0 && n == policy.RequestVolume &&
+		float64(h.failures) >= policy.FailureRate*float64(n) {
+		h.eject(policy, now)
+	}
+}
+
+func (h *endpointHealth) record(policy *HealthPolicy, ok bool) {
+	if policy.RequestVolume <= 0 {
+		return
+	}
+
+	if len(h.outcomes) < policy.RequestVolume {
+		h.outcomes = append(h.outcomes, ok)
+	} else {
+		if !h.outcomes[h.next] {
+			h.failures--
+		}
+		h.outcomes[h.next] = ok
+		h.next = (h.next + 1) % policy.RequestVolume
+	}
+
+	if !ok {
+		h.failures++
+	}
+}
+
+func (h *endpointHealth) eject(policy *HealthPolicy, now time.Time) {
+	d := policy.BaseEjection
+	for i := 0; i < h.ejections && (policy.MaxEjection == 0 || d < policy.MaxEjection); i++ {
+		d *= 2
+	}
+	if policy.MaxEjection > 0 && d > policy.MaxEjection {
+		d = policy.MaxEjection
+	}
+
+	h.ejections++
+	h.ejectedUntil = now.Add(d)
+	h.probeSentAt = time.Time{}
+
+	h.consecutiveFailures = 0
+	h.outcomes = h.outcomes[:0]
+	h.next = 0
+	h.failures = 0
+}
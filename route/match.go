@@ -0,0 +1,201 @@
+package route
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// A Match is what a request has to carry to be routed to the endpoints
+// registered with it: the values of request headers by name, and of
+// cookies, and its path. An empty value only asks for the header or cookie
+// to be there. A path is matched exactly by Path, by whole segments by
+// PathPrefix, so that /api covers /api/users but not /apis, and by
+// PathRegex anywhere in it unless the regex is anchored. Matches with a
+// higher Priority are tried first. Endpoints registered with an empty
+// Match take the requests that meet none of the matches of their route.
+type Match struct {
+	Headers map[string]string `json:"headers,omitempty"`
+	Cookies map[string]string `json:"cookies,omitempty"`
+
+	Path       string  `json:"path,omitempty"`
+	PathPrefix string  `json:"path_prefix,omitempty"`
+	PathRegex  *Regexp `json:"path_regex,omitempty"`
+
+	Priority int `json:"priority,omitempty"`
+}
+
+// Regexp is a regular expression that is compiled as it is unmarshaled.
+type Regexp struct {
+	*regexp.Regexp
+}
+
+// nothing matches no path at all.
+var nothing = regexp.MustCompile(`[^\s\S]`)
+
+// UnmarshalJSON fails on an invalid expression, leaving a regexp that
+// matches nothing: registrations are handled with whatever of them was
+// unmarshaled, and an endpoint must not take every path of its route for
+// want of its regexp.
+func (r *Regexp) UnmarshalJSON(b []byte) error {
+	r.Regexp = nothing
+
+	var expr string
+	if err := json.Unmarshal(b, &expr); err != nil {
+		return err
+	}
+
+	compiled, err := regexp.Compile(expr)
+	if err != nil {
+		return err
+	}
+	r.Regexp = compiled
+	return nil
+}
+
+func (r *Regexp) MarshalJSON() ([]byte, error) {
+	return json.Marshal(r.String())
+}
+
+func (m Match) IsEmpty() bool {
+	return len(m.Headers) == 0 && len(m.Cookies) == 0 && !m.hasPath()
+}
+
+// IsValid tells whether m can be registered. A priority orders a match
+// among the other matches of its route, so a priority without conditions
+// is not a match: it would be taken for no match at all.
+func (m Match) IsValid() bool {
+	return !m.IsEmpty() || m.Priority == 0
+}
+
+func (m Match) hasPath() bool {
+	return m.Path != "" || m.PathPrefix != "" || m.PathRegex != nil
+}
+
+// Conditions is the number of headers, cookies and paths m looks at.
+func (m Match) Conditions() int {
+	n := len(m.Headers) + len(m.Cookies)
+	if m.Path != "" {
+		n++
+	}
+	if m.PathPrefix != "" {
+		n++
+	}
+	if m.PathRegex != nil {
+		n++
+	}
+	return n
+}
+
+// Precedes tells whether requests are tried against m before o: matches
+// with a higher priority come first, whatever they look at. Among those
+// of a priority, matches of an exact path come first, then those of a path
+// prefix, the longest first, then those of a path regex, then those of no
+// path at all, and among them, matches with more conditions come first.
+func (m Match) Precedes(o Match) bool {
+	if m.Priority != o.Priority {
+		return m.Priority > o.Priority
+	}
+	if rm, ro := m.pathRank(), o.pathRank(); rm != ro {
+		return rm > ro
+	}
+	if len(m.PathPrefix) != len(o.PathPrefix) {
+		return len(m.PathPrefix) > len(o.PathPrefix)
+	}
+	if cm, co := m.Conditions(), o.Conditions(); cm != co {
+		return cm > co
+	}
+	return m.Key() < o.Key()
+}
+
+func (m Match) pathRank() int {
+	switch {
+	case m.Path != "":
+		return 3
+	case m.PathPrefix != "":
+		return 2
+	case m.PathRegex != nil:
+		return 1
+	}
+	return 0
+}
+
+// Key identifies the conditions and the priority of m, whatever the case
+// of their header names and the order they were given in.
+func (m Match) Key() string {
+	if m.IsEmpty() {
+		return ""
+	}
+
+	conditions := make([]string, 0, m.Conditions())
+	for name, value := range m.Headers {
+		conditions = append(conditions, "header:"+http.CanonicalHeaderKey(name)+"="+value)
+	}
+	for name, value := range m.Cookies {
+		conditions = append(conditions, "cookie:"+name+"="+value)
+	}
+	if m.Path != "" {
+		conditions = append(conditions, "path:"+m.Path)
+	}
+	if m.PathPrefix != "" {
+		conditions = append(conditions, "path_prefix:"+m.PathPrefix)
+	}
+	if m.PathRegex != nil {
+		conditions = append(conditions, "path_regex:"+m.PathRegex.String())
+	}
+	sort.Strings(conditions)
+	if m.Priority != 0 {
+		conditions = append(conditions, "priority:"+strconv.Itoa(m.Priority))
+	}
+	return strings.Join(conditions, "\n")
+}
+
+// Matches tells whether request meets every condition of m.
+func (m Match) Matches(request *http.Request) bool {
+	for name, value := range m.Headers {
+		values, ok := request.Header[http.CanonicalHeaderKey(name)]
+		if !ok || !contains(values, value) {
+			return false
+		}
+	}
+
+	for name, value := range m.Cookies {
+		cookie, err := request.Cookie(name)
+		if err != nil || (value != "" && cookie.Value != value) {
+			return false
+		}
+	}
+
+	return m.matchesPath(request.URL.Path)
+}
+
+func (m Match) matchesPath(p string) bool {
+	if m.Path != "" && p != m.Path {
+		return false
+	}
+	if m.PathPrefix != "" {
+		prefix := strings.TrimSuffix(m.PathPrefix, "/")
+		if p != prefix && !strings.HasPrefix(p, prefix+"/") {
+			return false
+		}
+	}
+	if m.PathRegex != nil && !m.PathRegex.MatchString(p) {
+		return false
+	}
+	return true
+}
+
+func contains(values []string, value string) bool {
+	if value == "" {
+		return true
+	}
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,138 @@
+package route_test
+
+import (
+	"encoding/json"
+	"net/http"
+
+	. "github.com/cloudfoundry/gorouter/route"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Match", func() {
+	var request *http.Request
+
+	BeforeEach(func() {
+		var err error
+		request, err = http.NewRequest("GET", "http://app.example.com/", nil)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("matches any request when it is empty", func() {
+		Expect(Match{}.IsEmpty()).To(BeTrue())
+		Expect(Match{}.Matches(request)).To(BeTrue())
+	})
+
+	It("matches requests carrying every header and cookie", func() {
+		m := Match{
+			Headers: map[string]string{"x-beta": "true"},
+			Cookies: map[string]string{"beta": ""},
+		}
+		Expect(m.Matches(request)).To(BeFalse())
+
+		request.Header.Add("X-Beta", "false")
+		request.Header.Add("X-Beta", "true")
+		Expect(m.Matches(request)).To(BeFalse())
+
+		request.AddCookie(&http.Cookie{Name: "beta", Value: "anything"})
+		Expect(m.Matches(request)).To(BeTrue())
+	})
+
+	It("compares cookie values when they are given", func() {
+		m := Match{Cookies: map[string]string{"beta": "1"}}
+
+		request.AddCookie(&http.Cookie{Name: "beta", Value: "0"})
+		Expect(m.Matches(request)).To(BeFalse())
+	})
+
+	It("keys matches by their conditions, whatever the case of header names", func() {
+		a := Match{Headers: map[string]string{"x-beta": "true", "X-Team": "a"}}
+		b := Match{Headers: map[string]string{"X-Team": "a", "X-BETA": "true"}}
+
+		Expect(a.Key()).To(Equal(b.Key()))
+		Expect(a.Key()).NotTo(Equal(Match{Cookies: map[string]string{"X-Beta": "true"}}.Key()))
+		Expect(Match{}.Key()).To(BeEmpty())
+	})
+
+	Context("with paths", func() {
+		unmarshal := func(s string) Match {
+			var m Match
+			Expect(json.Unmarshal([]byte(s), &m)).To(Succeed())
+			return m
+		}
+
+		matches := func(m Match, path string) bool {
+			request.URL.Path = path
+			return m.Matches(request)
+		}
+
+		It("matches exact paths", func() {
+			m := Match{Path: "/api/users"}
+			Expect(m.IsEmpty()).To(BeFalse())
+			Expect(matches(m, "/api/users")).To(BeTrue())
+			Expect(matches(m, "/api/users/1")).To(BeFalse())
+		})
+
+		It("matches path prefixes by whole segments", func() {
+			m := Match{PathPrefix: "/api/"}
+			Expect(matches(m, "/api")).To(BeTrue())
+			Expect(matches(m, "/api/users")).To(BeTrue())
+			Expect(matches(m, "/apis")).To(BeFalse())
+		})
+
+		It("matches path regexes", func() {
+			m := unmarshal(`{"path_regex": "^/api/v[0-9]+/users"}`)
+			Expect(matches(m, "/api/v2/users/1")).To(BeTrue())
+			Expect(matches(m, "/api/beta/users")).To(BeFalse())
+			Expect(m.Key()).To(Equal("path_regex:^/api/v[0-9]+/users"))
+
+			b, err := json.Marshal(m)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(b)).To(Equal(`{"path_regex":"^/api/v[0-9]+/users"}`))
+		})
+
+		It("refuses invalid path regexes, which then match no path", func() {
+			var m Match
+			Expect(json.Unmarshal([]byte(`{"path_regex": "^/api/(v1"}`), &m)).NotTo(Succeed())
+			Expect(m.IsEmpty()).To(BeFalse())
+			Expect(matches(m, "/api/(v1")).To(BeFalse())
+			Expect(matches(m, "")).To(BeFalse())
+		})
+
+		It("puts exact paths before prefixes, and prefixes before regexes", func() {
+			exact := Match{Path: "/api/v1/users"}
+			long := Match{PathPrefix: "/api/v1"}
+			short := Match{PathPrefix: "/api", Headers: map[string]string{"X-Beta": "true"}}
+			regex := unmarshal(`{"path_regex": "^/api/v[0-9]+/users"}`)
+			header := Match{Headers: map[string]string{"X-Beta": "true"}}
+
+			ordered := []Match{exact, long, short, regex, header}
+			for i := range ordered {
+				for j := range ordered {
+					Expect(ordered[i].Precedes(ordered[j])).To(Equal(i < j))
+				}
+			}
+		})
+
+		It("puts matches of a higher priority first", func() {
+			override := unmarshal(`{"headers": {"X-Beta": "true"}, "priority": 10}`)
+			exact := Match{Path: "/api/v1/users"}
+			catchAll := Match{PathPrefix: "/", Priority: -1}
+
+			ordered := []Match{override, exact, catchAll}
+			for i := range ordered {
+				for j := range ordered {
+					Expect(ordered[i].Precedes(ordered[j])).To(Equal(i < j))
+				}
+			}
+
+			Expect(override.Key()).To(Equal("header:X-Beta=true\npriority:10"))
+		})
+
+		It("is not valid with a priority but no conditions", func() {
+			Expect(Match{}.IsValid()).To(BeTrue())
+			Expect(Match{Priority: 10}.IsValid()).To(BeFalse())
+			Expect(Match{Path: "/", Priority: 10}.IsValid()).To(BeTrue())
+		})
+	})
+})
@@ -2,16 +2,33 @@ package route
 
 import (
 	"encoding/json"
-	"math/rand"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/cloudfoundry/gorouter/clock"
+	"github.com/cloudfoundry/gorouter/ipfilter"
+	"github.com/cloudfoundry/gorouter/limits"
 )
 
-var random = rand.New(rand.NewSource(time.Now().UnixNano()))
+var random = clock.NewRandom()
 
 type EndpointIterator interface {
 	Next() *Endpoint
 	EndpointFailed()
+	EndpointResponded(healthy bool)
+
+	// Done ends the request to the last endpoint, which no longer counts
+	// against the endpoint's in-flight limit.
+	Done()
+	// Upgrade ends the request to the last endpoint like Done, but keeps
+	// it in flight as an upgraded connection until the returned func is
+	// called.
+	Upgrade() func()
+	// AtCapacity reports whether Next found no endpoint because every
+	// endpoint has as many requests in flight as the limit allows.
+	AtCapacity() bool
 }
 
 type endpointIterator struct {
@@ -19,31 +36,146 @@ type endpointIterator struct {
 
 	initialEndpoint string
 	lastEndpoint    *Endpoint
+	acquired        *endpointElem
+	atCapacity      bool
 }
 
 type endpointElem struct {
-	endpoint *Endpoint
-	index    int
-	updated  time.Time
-	failedAt *time.Time
+	endpoint  *Endpoint
+	index     int
+	updated   time.Time
+	failedAt  *time.Time
+	health    endpointHealth
+	unhealthy bool
+	inFlight  int64
+	// upgraded are the WebSocket and TCP connections open to the endpoint,
+	// which are in flight but do not count against its limit
+	upgraded int64
+}
+
+type group struct {
+	credit  int
+	nextIdx int
+}
+
+// groupWeights are the groups of the endpoints that may take a request, in
+// order, and the weight of each: the largest weight any of its endpoints
+// registered.
+type groupWeights struct {
+	names   []string
+	weights map[string]int
+}
+
+// routeSettings are what the endpoints of a pool registered for the
+// requests to the route as a whole, worked out whenever they change so
+// that requests read them without taking the lock.
+type routeSettings struct {
+	requiresAuthorizationHeader bool
+	requiresSignedUrls          bool
+	requiresJwt                 bool
+	compressionDisabled         bool
+	bannersDisabled             bool
+	maxRequestBodyBytes         int64
+	clientFilter                *ipfilter.Filter
+	match                       Match
+}
+
+type affinity struct {
+	addr    string
+	expires time.Time
 }
 
 type Pool struct {
 	lock      sync.Mutex
 	endpoints []*endpointElem
 	index     map[string]*endpointElem
+	settings  atomic.Value // *routeSettings
 
 	retryAfterFailure time.Duration
 	nextIdx           int
+
+	healthPolicy  *HealthPolicy
+	inFlightLimit *limits.Limit
+
+	failbackDelay      time.Duration
+	servingBackups     bool
+	primaryRecoveredAt time.Time
+
+	// groups carry the smooth weighted round-robin between the groups of
+	// the pool, and within each group, over from one request to the next.
+	// groupWeights are the weights of the groups the round-robin is over,
+	// by whether the backups take requests and the version policy applies
+	groups       map[string]*group
+	groupWeights [2][2]groupWeights
+
+	// affinities pin requests by a header value to the address of an
+	// endpoint until they expire
+	affinities map[string]affinity
+
+	// versionPolicy steers new requests between the versions of the app,
+	// and versionTraffic counts the requests each version took
+	versionPolicy      VersionPolicy
+	versionTraffic     map[string]*versionTraffic
+	versionWindowStart time.Time
+
+	clock  clock.Clock
+	random clock.Random
 }
 
 func NewPool(retryAfterFailure time.Duration) *Pool {
-	return &Pool{
+	p := &Pool{
 		endpoints:         make([]*endpointElem, 0, 1),
 		index:             make(map[string]*endpointElem),
+		affinities:        make(map[string]affinity),
+		versionTraffic:    make(map[string]*versionTraffic),
 		retryAfterFailure: retryAfterFailure,
 		nextIdx:           -1,
+		clock:             clock.New(),
+		random:            random,
 	}
+	p.settings.Store(&routeSettings{})
+	return p
+}
+
+// SetClock has the pool take the time from c, for updates, staleness,
+// failures and ejections.
+func (p *Pool) SetClock(c clock.Clock) {
+	p.lock.Lock()
+	p.clock = c
+	p.lock.Unlock()
+}
+
+// SetRandom has the pool pick the endpoint it starts balancing from with r.
+func (p *Pool) SetRandom(r clock.Random) {
+	p.lock.Lock()
+	p.random = r
+	p.lock.Unlock()
+}
+
+// SetHealthPolicy has the pool eject endpoints whose requests keep failing.
+// With a nil policy, only endpoints that could not be dialed are skipped.
+func (p *Pool) SetHealthPolicy(policy *HealthPolicy) {
+	p.lock.Lock()
+	p.healthPolicy = policy
+	p.lock.Unlock()
+}
+
+// SetFailbackDelay sets how long the primary endpoints of a pool that
+// failed over to its backup endpoints must be available before requests go
+// back to them, so that a flapping primary does not flip traffic back and
+// forth.
+func (p *Pool) SetFailbackDelay(delay time.Duration) {
+	p.lock.Lock()
+	p.failbackDelay = delay
+	p.lock.Unlock()
+}
+
+// SetInFlightLimit caps the requests each endpoint of the pool may have in
+// flight. Endpoints at the cap are passed over.
+func (p *Pool) SetInFlightLimit(limit *limits.Limit) {
+	p.lock.Lock()
+	p.inFlightLimit = limit
+	p.lock.Unlock()
 }
 
 func (p *Pool) Put(endpoint *Endpoint) bool {
@@ -75,16 +207,20 @@ func (p *Pool) Put(endpoint *Endpoint) bool {
 		p.index[endpoint.PrivateInstanceId] = e
 	}
 
-	e.updated = time.Now()
+	e.updated = p.clock.Now()
+	p.endpointsChanged()
 
 	return !found
 }
 
-func (p *Pool) PruneEndpoints(defaultThreshold time.Duration) {
+// PruneEndpoints removes the endpoints that have not been updated within
+// their stale threshold and returns how many were removed.
+func (p *Pool) PruneEndpoints(defaultThreshold time.Duration) int {
 	p.lock.Lock()
 
+	pruned := 0
 	last := len(p.endpoints)
-	now := time.Now()
+	now := p.clock.Now()
 
 	for i := 0; i < last; {
 		e := p.endpoints[i]
@@ -96,13 +232,22 @@ func (p *Pool) PruneEndpoints(defaultThreshold time.Duration) {
 
 		if e.updated.Before(staleTime) {
 			p.removeEndpoint(e)
+			pruned++
 			last--
 		} else {
 			i++
 		}
 	}
 
+	for key, a := range p.affinities {
+		if !now.Before(a.expires) {
+			delete(p.affinities, key)
+		}
+	}
+
 	p.lock.Unlock()
+
+	return pruned
 }
 
 func (p *Pool) Remove(endpoint *Endpoint) bool {
@@ -134,27 +279,109 @@ func (p *Pool) removeEndpoint(e *endpointElem) {
 
 	delete(p.index, e.endpoint.CanonicalAddr())
 	delete(p.index, e.endpoint.PrivateInstanceId)
+	p.endpointsChanged()
+}
+
+// endpointsChanged works out again what depends on the endpoints of the
+// pool as a whole, as they are added, replaced or removed.
+func (p *Pool) endpointsChanged() {
+	settings := &routeSettings{}
+	var allow, deny ipfilter.Networks
+	for _, e := range p.endpoints {
+		endpoint := e.endpoint
+		settings.requiresAuthorizationHeader = settings.requiresAuthorizationHeader || endpoint.RequiresAuthorizationHeader
+		settings.requiresSignedUrls = settings.requiresSignedUrls || endpoint.RequiresSignedUrls
+		settings.requiresJwt = settings.requiresJwt || endpoint.RequiresJwt
+		settings.compressionDisabled = settings.compressionDisabled || endpoint.DisableCompression
+		settings.bannersDisabled = settings.bannersDisabled || endpoint.DisableBanners
+		if endpoint.MaxRequestBodyBytes > settings.maxRequestBodyBytes {
+			settings.maxRequestBodyBytes = endpoint.MaxRequestBodyBytes
+		}
+		allow = append(allow, endpoint.AllowedNetworks...)
+		deny = append(deny, endpoint.DeniedNetworks...)
+	}
+	settings.clientFilter = ipfilter.New(allow, deny)
+	if len(p.endpoints) > 0 {
+		settings.match = p.endpoints[0].endpoint.Match
+	}
+	p.settings.Store(settings)
+	p.weighGroups()
+}
+
+// weighGroups works out the weights of the groups of the endpoints, for
+// each set of endpoints that requests may go to, as the endpoints or the
+// version policy change.
+func (p *Pool) weighGroups() {
+	for _, backup := range []bool{false, true} {
+		for _, steer := range []bool{false, true} {
+			weights := make(map[string]int)
+			for _, e := range p.endpoints {
+				if !p.candidate(e, backup, steer) {
+					continue
+				}
+				if w, ok := weights[e.endpoint.Group]; !ok || e.endpoint.Weight > w {
+					weights[e.endpoint.Group] = e.endpoint.Weight
+				}
+			}
+
+			names := make([]string, 0, len(weights))
+			for g := range weights {
+				names = append(names, g)
+			}
+			sort.Strings(names)
+
+			p.groupWeights[boolIndex(backup)][boolIndex(steer)] = groupWeights{names: names, weights: weights}
+		}
+	}
+}
+
+func boolIndex(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func (p *Pool) routeSettings() *routeSettings {
+	return p.settings.Load().(*routeSettings)
 }
 
 func (p *Pool) Endpoints(initial string) EndpointIterator {
 	return newEndpointIterator(p, initial)
 }
 
-func (p *Pool) next() *Endpoint {
+// next picks the endpoint for a request, and counts the request as in
+// flight to it. It returns false when there are endpoints, but all of them
+// are at their in-flight limit.
+func (p *Pool) next() (*endpointElem, bool) {
 	p.lock.Lock()
 	defer p.lock.Unlock()
 
 	last := len(p.endpoints)
 	if last == 0 {
-		return nil
+		return nil, true
 	}
 
 	if p.nextIdx == -1 {
-		p.nextIdx = random.Intn(last)
+		p.nextIdx = p.random.Intn(last)
 	} else if p.nextIdx >= last {
 		p.nextIdx = 0
 	}
 
+	now := p.clock.Now()
+	backup := p.failover(now)
+	steer := p.steering(now, backup)
+
+	// with several groups, the request goes to the group whose turn it is,
+	// or to any group when none of that group's endpoints can take it
+	if group, ok := p.nextGroup(backup, steer); ok {
+		if e := p.nextInGroup(now, backup, steer, group); e != nil {
+			return e, true
+		}
+	}
+
+	reset := false
+	var fallback *endpointElem
 	startIdx := p.nextIdx
 	curIdx := startIdx
 	for {
@@ -165,38 +392,340 @@ func (p *Pool) next() *Endpoint {
 			curIdx = 0
 		}
 
-		if e.failedAt != nil {
-			curTime := time.Now()
-			if curTime.Sub(*e.failedAt) > p.retryAfterFailure {
-				// exipired failure window
-				e.failedAt = nil
+		if p.candidate(e, backup, steer) {
+			if e.failedAt != nil {
+				if now.Sub(*e.failedAt) > p.retryAfterFailure {
+					// exipired failure window
+					e.failedAt = nil
+				}
 			}
-		}
 
-		if e.failedAt == nil {
-			p.nextIdx = curIdx
-			return e.endpoint
+			if e.failedAt == nil && p.available(e, now) {
+				// endpoints at their in-flight limit are passed over
+				if !p.inFlightLimit.Exceeded(e.inFlight + 1) {
+					p.nextIdx = curIdx
+					e.health.selected(p.healthPolicy, now)
+					e.inFlight++
+					return e, true
+				}
+			} else if fallback == nil {
+				fallback = e
+			}
 		}
 
 		if curIdx == startIdx {
+			if reset {
+				if fallback == nil || p.inFlightLimit.Exceeded(fallback.inFlight+1) {
+					// every endpoint is at its in-flight limit
+					return nil, false
+				}
+
+				// every endpoint is ejected or unhealthy; rather than fail
+				// the request, send it to one of them anyway
+				p.nextIdx = fallback.index + 1
+				fallback.inFlight++
+				return fallback, true
+			}
+
 			// all endpoints are marked failed so reset everything to available
 			for _, e2 := range p.endpoints {
-				e2.failedAt = nil
+				if p.candidate(e2, backup, steer) {
+					e2.failedAt = nil
+				}
 			}
+			reset = true
+		}
+	}
+}
+
+// nextGroup picks the group of the next request among the groups of the
+// endpoints that are backups or not, as backup says, and that the version
+// policy leaves when steer says it applies. The groups take turns
+// by smooth weighted round-robin, which splits requests by the weights
+// exactly and spreads the requests of each group out evenly. It returns
+// false when there are fewer than two groups.
+func (p *Pool) nextGroup(backup, steer bool) (string, bool) {
+	gw := p.groupWeights[boolIndex(backup)][boolIndex(steer)]
+	if len(gw.names) < 2 {
+		p.groups = nil
+		return "", false
+	}
+
+	weighted := 0
+	unweighted := 0
+	for _, w := range gw.weights {
+		if w > 0 {
+			weighted += w
+		} else {
+			unweighted++
+		}
+	}
+
+	// weights are percentages; scaled by the number of groups without one,
+	// those can share what is left over evenly in whole numbers
+	rest := 0
+	if weighted < 100 && unweighted > 0 {
+		rest = 100 - weighted
+	}
+	scale := unweighted
+	if scale == 0 {
+		scale = 1
+	}
+
+	// the groups that no longer take requests lose their state
+	if p.groups == nil {
+		p.groups = make(map[string]*group, len(gw.names))
+	}
+	for name := range p.groups {
+		if w, ok := gw.weights[name]; !ok || (w <= 0 && rest <= 0) {
+			delete(p.groups, name)
+		}
+	}
+
+	total := 0
+	var best *group
+	var bestName string
+	for _, name := range gw.names {
+		w := gw.weights[name] * scale
+		if w <= 0 {
+			w = rest
+		}
+		if w <= 0 {
+			continue
+		}
+
+		g := p.groups[name]
+		if g == nil {
+			g = &group{}
+			p.groups[name] = g
+		}
+		g.credit += w
+
+		total += w
+		if best == nil || g.credit > best.credit {
+			best = g
+			bestName = name
 		}
 	}
+	if best == nil {
+		return "", false
+	}
+
+	best.credit -= total
+	return bestName, true
 }
 
-func (p *Pool) findById(id string) *Endpoint {
-	var endpoint *Endpoint
+// nextInGroup picks the next endpoint of the group called name that can
+// take a request, without falling back on failed or unhealthy ones.
+func (p *Pool) nextInGroup(now time.Time, backup, steer bool, name string) *endpointElem {
+	g := p.groups[name]
+	last := len(p.endpoints)
+	for i := 0; i < last; i++ {
+		idx := (g.nextIdx + i) % last
+		e := p.endpoints[idx]
+
+		if !p.candidate(e, backup, steer) || e.endpoint.Group != name {
+			continue
+		}
+		if e.failedAt != nil && now.Sub(*e.failedAt) <= p.retryAfterFailure {
+			continue
+		}
+		if !p.available(e, now) || p.inFlightLimit.Exceeded(e.inFlight+1) {
+			continue
+		}
+
+		g.nextIdx = idx + 1
+		e.health.selected(p.healthPolicy, now)
+		e.inFlight++
+		return e
+	}
+
+	return nil
+}
+
+// failover reports whether requests go to the backup endpoints of the pool.
+// They do once no primary endpoint is available, and until the primaries
+// have been available again for the failback delay. Pools without backup
+// endpoints never fail over.
+func (p *Pool) failover(now time.Time) bool {
+	hasBackups := false
+	primaryAvailable := false
+	for _, e := range p.endpoints {
+		if e.endpoint.Backup {
+			hasBackups = true
+		} else if (e.failedAt == nil || now.Sub(*e.failedAt) > p.retryAfterFailure) && p.available(e, now) {
+			primaryAvailable = true
+		}
+	}
+
+	switch {
+	case !hasBackups:
+		p.servingBackups = false
+	case !primaryAvailable:
+		p.servingBackups = true
+		p.primaryRecoveredAt = time.Time{}
+	case p.servingBackups:
+		if p.primaryRecoveredAt.IsZero() {
+			p.primaryRecoveredAt = now
+		}
+		if now.Sub(p.primaryRecoveredAt) >= p.failbackDelay {
+			p.servingBackups = false
+		}
+	}
+
+	return p.servingBackups
+}
+
+func (p *Pool) available(e *endpointElem, now time.Time) bool {
+	return !e.unhealthy && e.health.available(p.healthPolicy, now)
+}
+
+func (p *Pool) findById(id string) *endpointElem {
 	p.lock.Lock()
+	defer p.lock.Unlock()
+
 	e := p.index[id]
-	if e != nil {
-		endpoint = e.endpoint
+	if e == nil {
+		return nil
 	}
+
+	now := p.clock.Now()
+	if e.endpoint.Backup != p.failover(now) || !p.available(e, now) || p.inFlightLimit.Exceeded(e.inFlight+1) {
+		return nil
+	}
+
+	e.health.selected(p.healthPolicy, now)
+	e.inFlight++
+	return e
+}
+
+// Has tells whether the pool has the endpoint of id, an instance id or an
+// address, available or not.
+func (p *Pool) Has(id string) bool {
+	p.lock.Lock()
+	_, ok := p.index[id]
+	p.lock.Unlock()
+
+	return ok
+}
+
+func (p *Pool) release(e *endpointElem) {
+	p.lock.Lock()
+	e.inFlight--
+	p.lock.Unlock()
+}
+
+func (p *Pool) upgrade(e *endpointElem) {
+	p.lock.Lock()
+	e.inFlight--
+	e.upgraded++
+	p.lock.Unlock()
+}
+
+func (p *Pool) closeUpgraded(e *endpointElem) {
+	p.lock.Lock()
+	e.upgraded--
 	p.lock.Unlock()
+}
+
+// EndpointInFlight is the number of requests in flight to an endpoint.
+type EndpointInFlight struct {
+	Endpoint *Endpoint
+	Requests int64
+}
+
+// InFlight returns the endpoints of the pool that have requests in flight,
+// from the time they are picked until they are done with, or upgraded
+// connections open, and how many.
+func (p *Pool) InFlight() []EndpointInFlight {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	var inFlight []EndpointInFlight
+	for _, e := range p.endpoints {
+		if requests := e.inFlight + e.upgraded; requests > 0 {
+			inFlight = append(inFlight, EndpointInFlight{Endpoint: e.endpoint, Requests: requests})
+		}
+	}
+	return inFlight
+}
+
+// RequiresAuthorizationHeader reports whether any endpoint of the pool was
+// registered as requiring requests to carry an Authorization header.
+func (p *Pool) RequiresAuthorizationHeader() bool {
+	return p.routeSettings().requiresAuthorizationHeader
+}
+
+// RequiresSignedUrls reports whether any endpoint of the pool was
+// registered as requiring requests to have signed URLs.
+func (p *Pool) RequiresSignedUrls() bool {
+	return p.routeSettings().requiresSignedUrls
+}
+
+// RequiresJwt reports whether any endpoint of the pool was registered as
+// requiring requests to carry a valid bearer token.
+func (p *Pool) RequiresJwt() bool {
+	return p.routeSettings().requiresJwt
+}
+
+// ClientFilter returns the filter of the clients that may send requests to
+// the pool, or nil when no endpoint filters clients. Clients denied by any
+// endpoint are denied, and, as soon as any endpoint allows only some
+// clients, only the clients that some endpoint allows are allowed.
+func (p *Pool) ClientFilter() *ipfilter.Filter {
+	return p.routeSettings().clientFilter
+}
+
+// MaxRequestBodyBytes returns the largest request body size that any
+// endpoint of the pool registered, or 0 when none did.
+func (p *Pool) MaxRequestBodyBytes() int64 {
+	return p.routeSettings().maxRequestBodyBytes
+}
+
+// CompressionDisabled reports whether any endpoint of the pool was
+// registered as not having its responses compressed.
+func (p *Pool) CompressionDisabled() bool {
+	return p.routeSettings().compressionDisabled
+}
+
+// Match returns the match the endpoints of the pool were registered with.
+// The registry keeps endpoints with different matches in different pools.
+func (p *Pool) Match() Match {
+	return p.routeSettings().match
+}
+
+// BannersDisabled reports whether any endpoint of the pool was registered
+// as not having banners injected into its responses.
+func (p *Pool) BannersDisabled() bool {
+	return p.routeSettings().bannersDisabled
+}
+
+// Affinity returns the address of the endpoint that requests carrying key
+// are pinned to, for Endpoints to start from, or "" when key is not pinned.
+func (p *Pool) Affinity(key string) string {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	a, ok := p.affinities[key]
+	if !ok {
+		return ""
+	}
+	if !p.clock.Now().Before(a.expires) {
+		delete(p.affinities, key)
+		return ""
+	}
+	return a.addr
+}
 
-	return endpoint
+// SetAffinity pins the requests carrying key to endpoint for ttl. Expired
+// affinities are dropped as the pool is pruned.
+func (p *Pool) SetAffinity(key string, endpoint *Endpoint, ttl time.Duration) {
+	p.lock.Lock()
+	p.affinities[key] = affinity{
+		addr:    endpoint.CanonicalAddr(),
+		expires: p.clock.Now().Add(ttl),
+	}
+	p.lock.Unlock()
 }
 
 func (p *Pool) IsEmpty() bool {
@@ -215,11 +744,38 @@ func (p *Pool) MarkUpdated(t time.Time) {
 	p.lock.Unlock()
 }
 
+// SetEndpointHealthy takes an endpoint out of the pool when a health check
+// finds it unhealthy, and puts it back when it recovers. The endpoint stays
+// out however often it is registered again.
+func (p *Pool) SetEndpointHealthy(endpoint *Endpoint, healthy bool) {
+	p.lock.Lock()
+	e := p.index[endpoint.CanonicalAddr()]
+	if e != nil {
+		e.unhealthy = !healthy
+	}
+	p.lock.Unlock()
+}
+
 func (p *Pool) endpointFailed(endpoint *Endpoint) {
 	p.lock.Lock()
 	e := p.index[endpoint.CanonicalAddr()]
 	if e != nil {
-		e.failed()
+		now := p.clock.Now()
+		e.failed(now)
+		e.health.failed(p.healthPolicy, now)
+	}
+	p.lock.Unlock()
+}
+
+func (p *Pool) endpointResponded(endpoint *Endpoint, healthy bool) {
+	p.lock.Lock()
+	e := p.index[endpoint.CanonicalAddr()]
+	if e != nil {
+		if healthy {
+			e.health.succeeded(p.healthPolicy)
+		} else {
+			e.health.failed(p.healthPolicy, p.clock.Now())
+		}
 	}
 	p.lock.Unlock()
 }
@@ -232,6 +788,16 @@ func (p *Pool) Each(f func(endpoint *Endpoint)) {
 	p.lock.Unlock()
 }
 
+// EachUpdated calls f with every endpoint and the time it was last
+// registered.
+func (p *Pool) EachUpdated(f func(endpoint *Endpoint, updated time.Time)) {
+	p.lock.Lock()
+	for _, e := range p.endpoints {
+		f(e.endpoint, e.updated)
+	}
+	p.lock.Unlock()
+}
+
 func (p *Pool) MarshalJSON() ([]byte, error) {
 	p.lock.Lock()
 	addresses := make([]string, 0, len(p.endpoints))
@@ -251,19 +817,51 @@ func newEndpointIterator(p *Pool, initial string) EndpointIterator {
 }
 
 func (i *endpointIterator) Next() *Endpoint {
-	var e *Endpoint
+	// a retry ends the request to the endpoint tried before
+	i.Done()
+
+	var e *endpointElem
 	if i.initialEndpoint != "" {
 		e = i.pool.findById(i.initialEndpoint)
 		i.initialEndpoint = ""
 	}
 
 	if e == nil {
-		e = i.pool.next()
+		var ok bool
+		e, ok = i.pool.next()
+		i.atCapacity = !ok
 	}
 
-	i.lastEndpoint = e
+	i.acquired = e
+	i.lastEndpoint = nil
+	if e != nil {
+		i.lastEndpoint = e.endpoint
+		i.pool.countVersion(e)
+	}
 
-	return e
+	return i.lastEndpoint
+}
+
+func (i *endpointIterator) Done() {
+	if i.acquired != nil {
+		i.pool.release(i.acquired)
+		i.acquired = nil
+	}
+}
+
+func (i *endpointIterator) Upgrade() func() {
+	e := i.acquired
+	if e == nil {
+		return func() {}
+	}
+
+	i.acquired = nil
+	i.pool.upgrade(e)
+	return func() { i.pool.closeUpgraded(e) }
+}
+
+func (i *endpointIterator) AtCapacity() bool {
+	return i.atCapacity
 }
 
 func (i *endpointIterator) EndpointFailed() {
@@ -272,7 +870,14 @@ func (i *endpointIterator) EndpointFailed() {
 	}
 }
 
-func (e *endpointElem) failed() {
-	t := time.Now()
-	e.failedAt = &t
+// EndpointResponded reports whether the last endpoint's response was a
+// healthy one, for the pool's health policy to act on.
+func (i *endpointIterator) EndpointResponded(healthy bool) {
+	if i.lastEndpoint != nil {
+		i.pool.endpointResponded(i.lastEndpoint, healthy)
+	}
+}
+
+func (e *endpointElem) failed(now time.Time) {
+	e.failedAt = &now
 }
@@ -2,9 +2,12 @@ package route_test
 
 import (
 	"fmt"
+	"github.com/cloudfoundry/gorouter/clock/fakeclock"
+	"github.com/cloudfoundry/gorouter/ipfilter"
 	. "github.com/cloudfoundry/gorouter/route"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	"net"
 	"time"
 )
 
@@ -58,6 +61,20 @@ var _ = Describe("Pool", func() {
 		})
 	})
 
+	Context("Has", func() {
+		It("has the endpoints it was given, by instance id and address", func() {
+			endpoint := NewEndpoint("", "1.2.3.4", 5678, "instance-a", nil, -1)
+			pool.Put(endpoint)
+
+			Ω(pool.Has("instance-a")).Should(BeTrue())
+			Ω(pool.Has("1.2.3.4:5678")).Should(BeTrue())
+			Ω(pool.Has("instance-b")).Should(BeFalse())
+
+			pool.Remove(endpoint)
+			Ω(pool.Has("instance-a")).Should(BeFalse())
+		})
+	})
+
 	Context("IsEmpty", func() {
 		It("starts empty", func() {
 			Ω(pool.IsEmpty()).To(BeTrue())
@@ -82,6 +99,25 @@ var _ = Describe("Pool", func() {
 	Context("PruneEndpoints", func() {
 		defaultThreshold := 1 * time.Minute
 
+		Context("with a fake clock", func() {
+			var clock *fakeclock.FakeClock
+
+			BeforeEach(func() {
+				clock = fakeclock.New(time.Now())
+				pool.SetClock(clock)
+			})
+
+			It("prunes endpoints once the clock passes their threshold", func() {
+				pool.Put(NewEndpoint("", "1.2.3.4", 5678, "", nil, 20))
+
+				clock.Increment(20 * time.Second)
+				Ω(pool.PruneEndpoints(defaultThreshold)).To(BeZero())
+
+				clock.Increment(time.Second)
+				Ω(pool.PruneEndpoints(defaultThreshold)).To(Equal(1))
+			})
+		})
+
 		Context("when an endpoint has a custom stale time", func() {
 			Context("when custom stale threshold is greater than default threshold", func() {
 				It("prunes the endpoint", func() {
@@ -106,7 +142,7 @@ var _ = Describe("Pool", func() {
 					pool.MarkUpdated(time.Now().Add(-25 * time.Second))
 
 					Ω(pool.IsEmpty()).To(Equal(false))
-					pool.PruneEndpoints(defaultThreshold)
+					Ω(pool.PruneEndpoints(defaultThreshold)).To(Equal(1))
 					Ω(pool.IsEmpty()).To(Equal(true))
 				})
 			})
@@ -191,6 +227,177 @@ var _ = Describe("Pool", func() {
 		})
 	})
 
+	Context("InFlight", func() {
+		It("counts the requests of each endpoint until they are done", func() {
+			e1 := NewEndpoint("", "1.2.3.4", 5678, "", nil, -1)
+			e2 := NewEndpoint("", "5.6.7.8", 1234, "", nil, -1)
+			pool.Put(e1)
+			pool.Put(e2)
+			Ω(pool.InFlight()).Should(BeEmpty())
+
+			first := pool.Endpoints(e1.CanonicalAddr())
+			first.Next()
+			second := pool.Endpoints(e1.CanonicalAddr())
+			second.Next()
+			Ω(pool.InFlight()).Should(Equal([]EndpointInFlight{{Endpoint: e1, Requests: 2}}))
+
+			first.Done()
+			second.Done()
+			Ω(pool.InFlight()).Should(BeEmpty())
+		})
+	})
+
+	Context("RequiresAuthorizationHeader", func() {
+		It("is false when no endpoint requires it", func() {
+			pool.Put(NewEndpoint("", "1.2.3.4", 5678, "", nil, -1))
+			Ω(pool.RequiresAuthorizationHeader()).To(BeFalse())
+		})
+
+		It("is true when any endpoint requires it", func() {
+			e := NewEndpoint("", "5.6.7.8", 5678, "", nil, -1)
+			e.RequiresAuthorizationHeader = true
+
+			pool.Put(NewEndpoint("", "1.2.3.4", 5678, "", nil, -1))
+			pool.Put(e)
+			Ω(pool.RequiresAuthorizationHeader()).To(BeTrue())
+		})
+	})
+
+	Context("RequiresSignedUrls", func() {
+		It("is false when no endpoint requires them", func() {
+			pool.Put(NewEndpoint("", "1.2.3.4", 5678, "", nil, -1))
+			Ω(pool.RequiresSignedUrls()).To(BeFalse())
+		})
+
+		It("is true when any endpoint requires them", func() {
+			e := NewEndpoint("", "5.6.7.8", 5678, "", nil, -1)
+			e.RequiresSignedUrls = true
+
+			pool.Put(NewEndpoint("", "1.2.3.4", 5678, "", nil, -1))
+			pool.Put(e)
+			Ω(pool.RequiresSignedUrls()).To(BeTrue())
+		})
+	})
+
+	Context("RequiresJwt", func() {
+		It("is true when any endpoint requires a bearer token", func() {
+			pool.Put(NewEndpoint("", "1.2.3.4", 5678, "", nil, -1))
+			Ω(pool.RequiresJwt()).To(BeFalse())
+
+			e := NewEndpoint("", "5.6.7.8", 5678, "", nil, -1)
+			e.RequiresJwt = true
+			pool.Put(e)
+			Ω(pool.RequiresJwt()).To(BeTrue())
+		})
+	})
+
+	Context("MaxRequestBodyBytes", func() {
+		It("is 0 when no endpoint sets it", func() {
+			pool.Put(NewEndpoint("", "1.2.3.4", 5678, "", nil, -1))
+			Ω(pool.MaxRequestBodyBytes()).To(BeZero())
+		})
+
+		It("is the largest that an endpoint sets", func() {
+			e1 := NewEndpoint("", "1.2.3.4", 5678, "", nil, -1)
+			e1.MaxRequestBodyBytes = 1024
+			e2 := NewEndpoint("", "5.6.7.8", 5678, "", nil, -1)
+			e2.MaxRequestBodyBytes = 4096
+
+			pool.Put(e1)
+			pool.Put(e2)
+			pool.Put(NewEndpoint("", "9.9.9.9", 5678, "", nil, -1))
+			Ω(pool.MaxRequestBodyBytes()).To(Equal(int64(4096)))
+		})
+	})
+
+	Context("CompressionDisabled", func() {
+		It("is true when any endpoint disables compression", func() {
+			pool.Put(NewEndpoint("", "1.2.3.4", 5678, "", nil, -1))
+			Ω(pool.CompressionDisabled()).To(BeFalse())
+
+			e := NewEndpoint("", "5.6.7.8", 5678, "", nil, -1)
+			e.DisableCompression = true
+			pool.Put(e)
+			Ω(pool.CompressionDisabled()).To(BeTrue())
+		})
+	})
+
+	Context("BannersDisabled", func() {
+		It("is true when any endpoint disables banners", func() {
+			pool.Put(NewEndpoint("", "1.2.3.4", 5678, "", nil, -1))
+			Ω(pool.BannersDisabled()).To(BeFalse())
+
+			e := NewEndpoint("", "5.6.7.8", 5678, "", nil, -1)
+			e.DisableBanners = true
+			pool.Put(e)
+			Ω(pool.BannersDisabled()).To(BeTrue())
+		})
+	})
+
+	Context("ClientFilter", func() {
+		It("is nil when no endpoint limits its clients", func() {
+			pool.Put(NewEndpoint("", "1.2.3.4", 5678, "", nil, -1))
+			Ω(pool.ClientFilter()).To(BeNil())
+		})
+
+		It("combines the networks that the endpoints allow and deny", func() {
+			allowed, err := ipfilter.ParseNetworks([]string{"10.0.0.0/8"})
+			Ω(err).ToNot(HaveOccurred())
+			denied, err := ipfilter.ParseNetworks([]string{"10.1.0.0/16"})
+			Ω(err).ToNot(HaveOccurred())
+
+			e := NewEndpoint("", "1.2.3.4", 5678, "", nil, -1)
+			e.AllowedNetworks = allowed
+			pool.Put(e)
+			e = NewEndpoint("", "5.6.7.8", 5678, "", nil, -1)
+			e.DeniedNetworks = denied
+			pool.Put(e)
+
+			filter := pool.ClientFilter()
+			Ω(filter.Allows(net.ParseIP("10.2.3.4"))).To(BeTrue())
+			Ω(filter.Allows(net.ParseIP("10.1.3.4"))).To(BeFalse())
+			Ω(filter.Allows(net.ParseIP("192.168.0.1"))).To(BeFalse())
+		})
+
+		It("builds the filter as endpoints change rather than per request", func() {
+			allowed, err := ipfilter.ParseNetworks([]string{"10.0.0.0/8"})
+			Ω(err).ToNot(HaveOccurred())
+
+			e := NewEndpoint("", "1.2.3.4", 5678, "", nil, -1)
+			e.AllowedNetworks = allowed
+			pool.Put(e)
+
+			filter := pool.ClientFilter()
+			Ω(filter).ToNot(BeNil())
+			Ω(pool.ClientFilter() == filter).To(BeTrue())
+
+			pool.Remove(e)
+			Ω(pool.ClientFilter()).To(BeNil())
+		})
+	})
+
+	Context("Affinity", func() {
+		var clock *fakeclock.FakeClock
+
+		BeforeEach(func() {
+			clock = fakeclock.New(time.Now())
+			pool.SetClock(clock)
+		})
+
+		It("pins a key to an endpoint until it expires", func() {
+			e := NewEndpoint("", "1.2.3.4", 5678, "", nil, -1)
+			pool.Put(e)
+			Ω(pool.Affinity("tenant-a")).To(BeEmpty())
+
+			pool.SetAffinity("tenant-a", e, time.Minute)
+			Ω(pool.Affinity("tenant-a")).To(Equal("1.2.3.4:5678"))
+			Ω(pool.Affinity("tenant-b")).To(BeEmpty())
+
+			clock.Increment(time.Minute)
+			Ω(pool.Affinity("tenant-a")).To(BeEmpty())
+		})
+	})
+
 	It("marshals json", func() {
 		e := NewEndpoint("", "1.2.3.4", 5678, "", nil, -1)
 		pool.Put(e)
@@ -11,6 +11,14 @@ func (u Uri) ToLower() Uri {
 	return Uri(strings.ToLower(string(u)))
 }
 
+// IsValid reports whether u is a host name, or a wildcard: "*." and the
+// domain whose subdomains it covers. A "*" anywhere else would never match
+// the host of a request.
+func (u Uri) IsValid() bool {
+	host := strings.TrimPrefix(string(u), "*.")
+	return host != "" && !strings.Contains(host, "*")
+}
+
 func (u Uri) NextWildcard() (Uri, error) {
 	uri := strings.TrimPrefix(string(u), "*.")
 
@@ -0,0 +1,220 @@
+package route
+
+import (
+	"sort"
+	"time"
+)
+
+// versionWindow is how long the requests to a version count towards its
+// share of the requests of its route.
+const versionWindow = time.Minute
+
+// A VersionPolicy steers the requests of a route between the versions of
+// its app during a deploy. Pinned, when set, has new requests go only to
+// the endpoints of that version, and Drained versions take no new
+// requests. Requests that stick to an endpoint by their session cookie
+// still reach it, so that sessions on a drained version can finish, and the
+// policy is set aside while none of the endpoints it leaves can take
+// requests, rather than failing them.
+type VersionPolicy struct {
+	Pinned  string   `json:"pinned,omitempty"`
+	Drained []string `json:"drained,omitempty"`
+}
+
+func (v VersionPolicy) IsEmpty() bool {
+	return v.Pinned == "" && len(v.Drained) == 0
+}
+
+// allows tells whether the policy lets new requests go to version.
+func (v VersionPolicy) allows(version string) bool {
+	if v.Pinned != "" && version != v.Pinned {
+		return false
+	}
+	return !v.drains(version)
+}
+
+func (v VersionPolicy) drains(version string) bool {
+	for _, d := range v.Drained {
+		if version == d {
+			return true
+		}
+	}
+	return false
+}
+
+// VersionStats are the endpoints of a version of the app of a route, and
+// the requests they took: all of them, and those of the last minute or
+// two, which Share is the fraction of among the versions of the route.
+type VersionStats struct {
+	Version        string  `json:"version"`
+	Endpoints      int     `json:"endpoints"`
+	Requests       int64   `json:"requests"`
+	RecentRequests int64   `json:"recent_requests"`
+	Share          float64 `json:"share"`
+	Pinned         bool    `json:"pinned,omitempty"`
+	Drained        bool    `json:"drained,omitempty"`
+}
+
+// MergeVersions adds up the stats of the versions of several pools of a
+// route, and works out the shares of the sums.
+func MergeVersions(stats ...[]VersionStats) []VersionStats {
+	byVersion := make(map[string]*VersionStats)
+	for _, s := range stats {
+		for _, v := range s {
+			m := byVersion[v.Version]
+			if m == nil {
+				m = &VersionStats{Version: v.Version}
+				byVersion[v.Version] = m
+			}
+			m.Endpoints += v.Endpoints
+			m.Requests += v.Requests
+			m.RecentRequests += v.RecentRequests
+			m.Pinned = m.Pinned || v.Pinned
+			m.Drained = m.Drained || v.Drained
+		}
+	}
+
+	merged := make([]VersionStats, 0, len(byVersion))
+	var recent int64
+	for _, m := range byVersion {
+		merged = append(merged, *m)
+		recent += m.RecentRequests
+	}
+	for i := range merged {
+		if recent > 0 {
+			merged[i].Share = float64(merged[i].RecentRequests) / float64(recent)
+		}
+	}
+	sort.Slice(merged, func(i, j int) bool { return merged[i].Version < merged[j].Version })
+	return merged
+}
+
+// versionTraffic counts the requests to a version, all of them and those
+// of the current and the previous window.
+type versionTraffic struct {
+	requests          int64
+	current, previous int64
+}
+
+// SetVersionPolicy has the pool steer new requests between the versions of
+// its endpoints by policy.
+func (p *Pool) SetVersionPolicy(policy VersionPolicy) {
+	p.lock.Lock()
+	p.versionPolicy = policy
+	p.weighGroups()
+	p.lock.Unlock()
+}
+
+// Versions returns the stats of the versions of the endpoints of the pool,
+// by version. Endpoints registered without a version are left out.
+func (p *Pool) Versions() []VersionStats {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.rotateVersionWindow(p.clock.Now())
+
+	byVersion := make(map[string]*VersionStats)
+	for _, e := range p.endpoints {
+		version := e.endpoint.AppVersion
+		if version == "" {
+			continue
+		}
+
+		v := byVersion[version]
+		if v == nil {
+			v = &VersionStats{
+				Version: version,
+				Pinned:  version == p.versionPolicy.Pinned,
+				Drained: p.versionPolicy.drains(version),
+			}
+			if t := p.versionTraffic[version]; t != nil {
+				v.Requests = t.requests
+				v.RecentRequests = t.current + t.previous
+			}
+			byVersion[version] = v
+		}
+		v.Endpoints++
+	}
+
+	stats := make([]VersionStats, 0, len(byVersion))
+	for _, v := range byVersion {
+		stats = append(stats, *v)
+	}
+	return MergeVersions(stats)
+}
+
+// steering tells whether the version policy of the pool applies to the
+// next request among the endpoints that are backups or not, as backup
+// says: it does when it leaves at least one of them that is available.
+func (p *Pool) steering(now time.Time, backup bool) bool {
+	if p.versionPolicy.IsEmpty() {
+		return false
+	}
+	for _, e := range p.endpoints {
+		if e.endpoint.Backup != backup || !p.versionPolicy.allows(e.endpoint.AppVersion) {
+			continue
+		}
+		if (e.failedAt == nil || now.Sub(*e.failedAt) > p.retryAfterFailure) && p.available(e, now) {
+			return true
+		}
+	}
+	return false
+}
+
+// candidate tells whether e may take the next request among the endpoints
+// that are backups or not, as backup says, with the version policy
+// applying or not, as steer says.
+func (p *Pool) candidate(e *endpointElem, backup, steer bool) bool {
+	if e.endpoint.Backup != backup {
+		return false
+	}
+	return !steer || p.versionPolicy.allows(e.endpoint.AppVersion)
+}
+
+// countVersion counts a request to the version of e.
+func (p *Pool) countVersion(e *endpointElem) {
+	version := e.endpoint.AppVersion
+	if version == "" {
+		return
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.rotateVersionWindow(p.clock.Now())
+
+	t := p.versionTraffic[version]
+	if t == nil {
+		t = &versionTraffic{}
+		p.versionTraffic[version] = t
+	}
+	t.requests++
+	t.current++
+}
+
+// rotateVersionWindow starts a new window once the current one is over,
+// and forgets the versions that no endpoint of the pool has anymore.
+func (p *Pool) rotateVersionWindow(now time.Time) {
+	elapsed := now.Sub(p.versionWindowStart)
+	if elapsed < versionWindow {
+		return
+	}
+
+	live := make(map[string]bool)
+	for _, e := range p.endpoints {
+		live[e.endpoint.AppVersion] = true
+	}
+
+	for version, t := range p.versionTraffic {
+		if !live[version] {
+			delete(p.versionTraffic, version)
+			continue
+		}
+		t.previous = t.current
+		if elapsed >= 2*versionWindow {
+			t.previous = 0
+		}
+		t.current = 0
+	}
+	p.versionWindowStart = now
+}
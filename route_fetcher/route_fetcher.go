@@ -1,6 +1,7 @@
 package route_fetcher
 
 import (
+	"sync"
 	"time"
 
 	"github.com/cloudfoundry-incubator/routing-api"
@@ -18,10 +19,26 @@ type RouteFetcher struct {
 	FetchRoutesInterval                time.Duration
 	SubscriptionRetryIntervalInSeconds int
 
+	// With Streaming the fetch cycle leaves the routes to the event stream
+	// while it is subscribed, and fetches them in full only every
+	// FullSyncInterval, or never when it is 0.
+	Streaming        bool
+	FullSyncInterval time.Duration
+
 	logger    *steno.Logger
 	endpoints []db.Route
 	ticker    *time.Ticker
 	client    routing_api.Client
+
+	fetchLock   sync.Mutex
+	lastFetched time.Time
+	lastError   error
+
+	streamLock   sync.Mutex
+	subscribed   bool
+	subscribedAt time.Time
+	lastEvent    time.Time
+	events       uint64
 }
 
 func NewRouteFetcher(logger *steno.Logger, tokenFetcher token_fetcher.TokenFetcher, routeRegistry registry.RegistryInterface, cfg *config.Config, client routing_api.Client, subscriptionRetryInterval int) *RouteFetcher {
@@ -31,6 +48,9 @@ func NewRouteFetcher(logger *steno.Logger, tokenFetcher token_fetcher.TokenFetch
 		FetchRoutesInterval:                cfg.PruneStaleDropletsInterval / 2,
 		SubscriptionRetryIntervalInSeconds: subscriptionRetryInterval,
 
+		Streaming:        cfg.RoutingApi.Streaming,
+		FullSyncInterval: cfg.RoutingApi.FullSyncInterval,
+
 		client: client,
 		logger: logger,
 	}
@@ -44,6 +64,9 @@ func (r *RouteFetcher) StartFetchCycle() {
 			for {
 				select {
 				case <-r.ticker.C:
+					if !r.fullSyncDue() {
+						continue
+					}
 					err := r.FetchRoutes()
 					if err != nil {
 						r.logger.Error(err.Error())
@@ -80,6 +103,18 @@ func (r *RouteFetcher) subscribeToEvents() {
 
 	defer source.Close()
 
+	r.setSubscribed(true)
+	defer r.setSubscribed(false)
+
+	// the events that were missed while unsubscribed are caught up on with
+	// a full sync, after which the stream keeps the routes current
+	if r.Streaming {
+		err := r.FetchRoutes()
+		if err != nil {
+			r.logger.Error(err.Error())
+		}
+	}
+
 	for {
 		event, err := source.Next()
 		if err != nil {
@@ -88,9 +123,74 @@ func (r *RouteFetcher) subscribeToEvents() {
 		}
 		r.HandleEvent(event)
 	}
+
+	if r.Streaming {
+		r.logger.Warn("Event stream ended, falling back to fetching routes in full.")
+	}
+}
+
+func (r *RouteFetcher) setSubscribed(subscribed bool) {
+	r.streamLock.Lock()
+	r.subscribed = subscribed
+	if subscribed {
+		r.subscribedAt = time.Now()
+	}
+	r.streamLock.Unlock()
+}
+
+// Subscribed tells whether the fetcher is subscribed to the event stream.
+func (r *RouteFetcher) Subscribed() bool {
+	r.streamLock.Lock()
+	defer r.streamLock.Unlock()
+	return r.subscribed
+}
+
+// Events returns the number of events received from the event stream.
+func (r *RouteFetcher) Events() uint64 {
+	r.streamLock.Lock()
+	defer r.streamLock.Unlock()
+	return r.events
+}
+
+// EventLag returns how long ago the last event arrived, or the fetcher
+// subscribed when no event has since, which grows while the stream is down
+// or has silently stalled. It is 0 before the first subscription.
+func (r *RouteFetcher) EventLag() time.Duration {
+	r.streamLock.Lock()
+	defer r.streamLock.Unlock()
+
+	last := r.subscribedAt
+	if r.lastEvent.After(last) {
+		last = r.lastEvent
+	}
+	if last.IsZero() {
+		return 0
+	}
+	return time.Since(last)
+}
+
+// fullSyncDue tells whether the fetch cycle should fetch the routes in full:
+// always, unless streaming keeps them current and the last full sync is
+// recent enough.
+func (r *RouteFetcher) fullSyncDue() bool {
+	if !r.Streaming || !r.Subscribed() {
+		return true
+	}
+	if r.FullSyncInterval <= 0 {
+		return false
+	}
+
+	r.fetchLock.Lock()
+	defer r.fetchLock.Unlock()
+	return time.Since(r.lastFetched) >= r.FullSyncInterval
 }
 
 func (r *RouteFetcher) HandleEvent(e routing_api.Event) error {
+	r.streamLock.Lock()
+	r.events++
+	r.lastEvent = time.Now()
+	r.streamLock.Unlock()
+
 	r.logger.Infof("Handling event: %v", e)
 	eventRoute := e.Route
 	uri := route.Uri(eventRoute.Route)
@@ -107,6 +207,33 @@ func (r *RouteFetcher) HandleEvent(e routing_api.Event) error {
 }
 
 func (r *RouteFetcher) FetchRoutes() error {
+	err := r.fetchRoutes()
+
+	r.fetchLock.Lock()
+	r.lastError = err
+	if err == nil {
+		r.lastFetched = time.Now()
+	}
+	r.fetchLock.Unlock()
+
+	return err
+}
+
+// LastFetch returns when routes were last fetched, and the error of the
+// last attempt to. While streaming keeps the routes current they count as
+// fetched now.
+func (r *RouteFetcher) LastFetch() (time.Time, error) {
+	streaming := r.Streaming && r.Subscribed()
+
+	r.fetchLock.Lock()
+	defer r.fetchLock.Unlock()
+	if streaming && !r.lastFetched.IsZero() {
+		return time.Now(), r.lastError
+	}
+	return r.lastFetched, r.lastError
+}
+
+func (r *RouteFetcher) fetchRoutes() error {
 	token, err := r.TokenFetcher.FetchToken()
 	if err != nil {
 		return err
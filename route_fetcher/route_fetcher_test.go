@@ -99,6 +99,10 @@ var _ = Describe("RouteFetcher", func() {
 
 			Expect(registry.RegisterCallCount()).To(Equal(3))
 
+			fetched, err := fetcher.LastFetch()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(fetched).To(BeTemporally("~", time.Now(), time.Second))
+
 			for i := 0; i < 3; i++ {
 				response := response[i]
 				uri, endpoint := registry.RegisterArgsForCall(i)
@@ -139,6 +143,10 @@ var _ = Describe("RouteFetcher", func() {
 
 				err := fetcher.FetchRoutes()
 				Expect(err).To(HaveOccurred())
+
+				fetched, lastErr := fetcher.LastFetch()
+				Expect(fetched.IsZero()).To(BeTrue())
+				Expect(lastErr).To(Equal(err))
 			})
 		})
 
@@ -281,6 +289,91 @@ var _ = Describe("RouteFetcher", func() {
 		})
 	})
 
+	Describe("streaming", func() {
+		var (
+			eventSource *fake_routing_api.FakeEventSource
+			events      chan routing_api.Event
+			down        chan struct{}
+		)
+
+		BeforeEach(func() {
+			cfg.PruneStaleDropletsInterval = 10 * time.Millisecond
+			cfg.RoutingApi.Streaming = true
+			cfg.RoutingApi.FullSyncInterval = 0
+			fetcher = NewRouteFetcher(logger, tokenFetcher, registry, cfg, client, 1)
+
+			tokenFetcher.FetchTokenReturns(token, nil)
+			client.RoutesReturns(response, nil)
+
+			// the stubs outlive the test in the goroutines of the fetcher, so
+			// they keep to the channels of their own test
+			events = make(chan routing_api.Event)
+			down = make(chan struct{})
+			eventSource = &fake_routing_api.FakeEventSource{}
+			eventSource.NextStub = func(events chan routing_api.Event) func() (routing_api.Event, error) {
+				return func() (routing_api.Event, error) {
+					event, ok := <-events
+					if !ok {
+						return routing_api.Event{}, errors.New("stream closed")
+					}
+					return event, nil
+				}
+			}(events)
+			client.SubscribeToEventsStub = func(down chan struct{}, eventSource routing_api.EventSource) func() (routing_api.EventSource, error) {
+				return func() (routing_api.EventSource, error) {
+					select {
+					case <-down:
+						// an API that does not answer keeps the stream down
+						// without the fetcher logging retries into later tests
+						select {}
+					default:
+						return eventSource, nil
+					}
+				}
+			}(down, eventSource)
+		})
+
+		It("fetches the routes in full once subscribed and then only handles events", func() {
+			fetcher.StartEventCycle()
+			Eventually(fetcher.Subscribed).Should(BeTrue())
+			Eventually(client.RoutesCallCount).Should(Equal(1))
+
+			fetcher.StartFetchCycle()
+			time.Sleep(cfg.PruneStaleDropletsInterval * 3)
+			Expect(client.RoutesCallCount()).To(Equal(1))
+
+			fetched, _ := fetcher.LastFetch()
+			Expect(fetched).To(BeTemporally("~", time.Now(), time.Second))
+
+			events <- routing_api.Event{Action: "Upsert", Route: db.Route{Route: "z.a.k", Port: 63, IP: "42.42.42.42", TTL: 1}}
+			Eventually(fetcher.Events).Should(Equal(uint64(1)))
+			Expect(fetcher.EventLag()).To(BeNumerically("<", time.Second))
+		})
+
+		It("falls back to fetching routes in full when the stream ends", func() {
+			fetcher.StartEventCycle()
+			Eventually(fetcher.Subscribed).Should(BeTrue())
+			Eventually(client.RoutesCallCount).Should(Equal(1))
+
+			close(down)
+			close(events)
+			Eventually(fetcher.Subscribed).Should(BeFalse())
+
+			fetcher.StartFetchCycle()
+			Eventually(client.RoutesCallCount).Should(BeNumerically(">=", 3))
+		})
+
+		It("fetches the routes in full every full sync interval", func() {
+			fetcher.FullSyncInterval = 20 * time.Millisecond
+			fetcher.StartEventCycle()
+			Eventually(fetcher.Subscribed).Should(BeTrue())
+			Eventually(client.RoutesCallCount).Should(Equal(1))
+
+			fetcher.StartFetchCycle()
+			Eventually(client.RoutesCallCount).Should(BeNumerically(">=", 2))
+		})
+	})
+
 	Describe(".HandleEvent", func() {
 		Context("When the event is an Upsert", func() {
 			It("registers the route from the registry", func() {
@@ -0,0 +1,175 @@
+package router
+
+import (
+	"crypto/subtle"
+	"crypto/tls"
+	"encoding/json"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/cloudfoundry/gorouter/config"
+	"github.com/cloudfoundry/gorouter/registry"
+	steno "github.com/cloudfoundry/gosteno"
+)
+
+// AdminApi registers and unregisters routes in the registry directly, for
+// debugging and for deployments where publishing to NATS is locked down.
+// POST /routes/register and /routes/unregister take the messages of
+// router.register and router.unregister, or of the TCP subjects when they
+// carry a router_port, or of the TLS passthrough subjects with
+// ?tls_passthrough=true. Routes registered through the API go stale like
+// those registered through NATS, after their stale_threshold_in_seconds or
+// the router's droplet_stale_threshold, so they have to be registered
+// again to stay.
+type AdminApi struct {
+	config   config.AdminApiConfig
+	registry *registry.RouteRegistry
+	mux      *http.ServeMux
+	logger   *steno.Logger
+}
+
+func NewAdminApi(c config.AdminApiConfig, r *registry.RouteRegistry) *AdminApi {
+	a := &AdminApi{
+		config:   c,
+		registry: r,
+		mux:      http.NewServeMux(),
+		logger:   steno.NewLogger("router.admin"),
+	}
+	a.mux.HandleFunc("/routes/register", a.register)
+	a.mux.HandleFunc("/routes/unregister", a.unregister)
+	return a
+}
+
+// Serve serves the API on l, the listener of the configured port, over TLS
+// when a certificate is configured.
+func (a *AdminApi) Serve(l net.Listener) error {
+	server := &http.Server{
+		Handler: a,
+	}
+
+	if a.config.Certificate == nil {
+		return server.Serve(l)
+	}
+
+	server.TLSConfig = &tls.Config{
+		Certificates: []tls.Certificate{*a.config.Certificate},
+		ClientCAs:    a.config.ClientCAs,
+	}
+	switch {
+	case a.config.ClientCAs != nil && len(a.config.Tokens) == 0:
+		server.TLSConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	case a.config.ClientCAs != nil:
+		server.TLSConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return server.ServeTLS(l, "", "")
+}
+
+func (a *AdminApi) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if !a.authenticated(req) {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	a.mux.ServeHTTP(w, req)
+}
+
+// authenticated tells whether req carries one of the tokens, or came with
+// a client certificate the configured CA verified.
+func (a *AdminApi) authenticated(req *http.Request) bool {
+	if a.config.ClientCAs != nil && req.TLS != nil && len(req.TLS.VerifiedChains) > 0 {
+		return true
+	}
+
+	auth := req.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return false
+	}
+	token := []byte(strings.TrimPrefix(auth, "Bearer "))
+
+	ok := false
+	for _, t := range a.config.Tokens {
+		if subtle.ConstantTimeCompare(token, []byte(t)) == 1 {
+			ok = true
+		}
+	}
+	return ok
+}
+
+func (a *AdminApi) register(w http.ResponseWriter, req *http.Request) {
+	msg, ok := a.message(w, req, registry.OperationRegister)
+	if !ok {
+		return
+	}
+
+	switch {
+	case msg.RouterPort != 0:
+		a.registry.RegisterTcp(msg.RouterPort, msg.makeEndpoint())
+	case req.URL.Query().Get("tls_passthrough") == "true":
+		for _, uri := range msg.Uris {
+			a.registry.RegisterTlsPassthrough(uri, msg.makeEndpoint())
+		}
+	default:
+		for _, uri := range msg.Uris {
+			a.registry.Register(uri, msg.makeEndpoint())
+		}
+	}
+
+	a.logger.Infod(map[string]interface{}{"message": msg, "remote_addr": req.RemoteAddr}, "router.admin.register")
+	a.respond(w, msg)
+}
+
+func (a *AdminApi) unregister(w http.ResponseWriter, req *http.Request) {
+	msg, ok := a.message(w, req, registry.OperationUnregister)
+	if !ok {
+		return
+	}
+
+	switch {
+	case msg.RouterPort != 0:
+		a.registry.UnregisterTcp(msg.RouterPort, msg.makeEndpoint())
+	case req.URL.Query().Get("tls_passthrough") == "true":
+		for _, uri := range msg.Uris {
+			a.registry.UnregisterTlsPassthrough(uri, msg.makeEndpoint())
+		}
+	default:
+		for _, uri := range msg.Uris {
+			a.registry.Unregister(uri, msg.makeEndpoint())
+		}
+	}
+
+	a.logger.Infod(map[string]interface{}{"message": msg, "remote_addr": req.RemoteAddr}, "router.admin.unregister")
+	a.respond(w, msg)
+}
+
+// message reads the registry message of req, or answers req when it is not
+// a POST of a message with an address and routes, or when the admin API is
+// over its registration limit.
+func (a *AdminApi) message(w http.ResponseWriter, req *http.Request, operation string) (*registryMessage, bool) {
+	if req.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return nil, false
+	}
+
+	var msg registryMessage
+	err := json.NewDecoder(req.Body).Decode(&msg)
+	if err != nil {
+		http.Error(w, "invalid message: "+err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+	if msg.Host == "" || msg.Port == 0 || (len(msg.Uris) == 0 && msg.RouterPort == 0) {
+		http.Error(w, "host, port and uris or router_port are required", http.StatusBadRequest)
+		return nil, false
+	}
+	if !a.registry.Registrars().Allow("admin_api", operation) {
+		http.Error(w, "over the registration limit", http.StatusTooManyRequests)
+		return nil, false
+	}
+	return &msg, true
+}
+
+func (a *AdminApi) respond(w http.ResponseWriter, msg *registryMessage) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(msg)
+}
@@ -0,0 +1,134 @@
+package router_test
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/cloudfoundry/gorouter/clock"
+	"github.com/cloudfoundry/gorouter/config"
+	"github.com/cloudfoundry/gorouter/registry"
+	. "github.com/cloudfoundry/gorouter/router"
+	"github.com/cloudfoundry/yagnats/fakeyagnats"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("AdminApi", func() {
+	const token = "0123456789abcdef"
+
+	var (
+		c   config.AdminApiConfig
+		r   *registry.RouteRegistry
+		api *AdminApi
+	)
+
+	BeforeEach(func() {
+		c = config.AdminApiConfig{Port: 8083, Tokens: []string{token}}
+		rc := config.DefaultConfig()
+		rc.TcpRouting.Ports = []uint16{5000}
+
+		r = registry.NewRouteRegistry(rc, fakeyagnats.Connect())
+	})
+
+	JustBeforeEach(func() {
+		api = NewAdminApi(c, r)
+	})
+
+	post := func(path, body, auth string) int {
+		req, _ := http.NewRequest("POST", path, strings.NewReader(body))
+		if auth != "" {
+			req.Header.Set("Authorization", "Bearer "+auth)
+		}
+		w := httptest.NewRecorder()
+		api.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	It("registers and unregisters routes", func() {
+		message := `{"host": "192.168.1.1", "port": 1234, "uris": ["app.example.com"], "app": "app-guid"}`
+
+		Ω(post("/routes/register", message, token)).To(Equal(http.StatusOK))
+		pool := r.Lookup("app.example.com")
+		Ω(pool).NotTo(BeNil())
+		Ω(pool.Endpoints("").Next().ApplicationId).To(Equal("app-guid"))
+
+		Ω(post("/routes/unregister", message, token)).To(Equal(http.StatusOK))
+		Ω(r.Lookup("app.example.com")).To(BeNil())
+	})
+
+	It("registers TCP and TLS passthrough routes", func() {
+		Ω(post("/routes/register", `{"host": "192.168.1.1", "port": 1234, "router_port": 5000}`, token)).To(Equal(http.StatusOK))
+		Ω(r.LookupTcp(5000)).NotTo(BeNil())
+
+		Ω(post("/routes/register?tls_passthrough=true", `{"host": "192.168.1.1", "port": 443, "uris": ["tls.example.com"]}`, token)).To(Equal(http.StatusOK))
+		Ω(r.LookupTlsPassthrough("tls.example.com")).NotTo(BeNil())
+		Ω(r.Lookup("tls.example.com")).To(BeNil())
+	})
+
+	It("rejects requests without a valid token", func() {
+		message := `{"host": "192.168.1.1", "port": 1234, "uris": ["app.example.com"]}`
+		Ω(post("/routes/register", message, "")).To(Equal(http.StatusUnauthorized))
+		Ω(post("/routes/register", message, "fedcba9876543210")).To(Equal(http.StatusUnauthorized))
+		Ω(r.Lookup("app.example.com")).To(BeNil())
+	})
+
+	It("rejects incomplete messages", func() {
+		Ω(post("/routes/register", `{"host": "192.168.1.1", "uris": ["app.example.com"]}`, token)).To(Equal(http.StatusBadRequest))
+		Ω(post("/routes/register", `{"host": "192.168.1.1", "port": 1234}`, token)).To(Equal(http.StatusBadRequest))
+		Ω(post("/routes/register", `{`, token)).To(Equal(http.StatusBadRequest))
+	})
+
+	It("rejects messages with an unknown auth", func() {
+		Ω(post("/routes/register", `{"host": "192.168.1.1", "port": 1234, "uris": ["app.example.com"], "auth": "basic"}`, token)).To(Equal(http.StatusBadRequest))
+		Ω(r.Lookup("app.example.com")).To(BeNil())
+
+		Ω(post("/routes/register", `{"host": "192.168.1.1", "port": 1234, "uris": ["app.example.com"], "auth": "jwt"}`, token)).To(Equal(http.StatusOK))
+		Ω(r.Lookup("app.example.com").RequiresJwt()).To(BeTrue())
+	})
+
+	It("rejects messages over the registration limit of the admin API", func() {
+		r.SetRegistrars(registry.NewRegistrars(config.RegistrationLimitsConfig{
+			Sources: map[string]config.RateLimitConfig{
+				"admin_api": {Enabled: true, RequestsPerSecond: 0.001, Burst: 1},
+			},
+		}, clock.New()))
+
+		Ω(post("/routes/register", `{"host": "192.168.1.1", "port": 1234, "uris": ["a.example.com"]}`, token)).To(Equal(http.StatusOK))
+		Ω(post("/routes/register", `{"host": "192.168.1.1", "port": 1234, "uris": ["b.example.com"]}`, token)).To(Equal(http.StatusTooManyRequests))
+		Ω(r.Lookup("b.example.com")).To(BeNil())
+		Ω(r.Registrars().Stats()["admin_api"].RateLimited).To(Equal(int64(1)))
+	})
+
+	It("rejects other methods", func() {
+		req, _ := http.NewRequest("GET", "/routes/register", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		api.ServeHTTP(w, req)
+		Ω(w.Code).To(Equal(http.StatusMethodNotAllowed))
+	})
+
+	Context("with client certificates", func() {
+		BeforeEach(func() {
+			c.Tokens = nil
+			c.ClientCAs = x509.NewCertPool()
+		})
+
+		It("accepts requests with a verified client certificate", func() {
+			req, _ := http.NewRequest("POST", "/routes/register", strings.NewReader(`{"host": "192.168.1.1", "port": 1234, "uris": ["app.example.com"]}`))
+			req.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{&x509.Certificate{}}}}
+			w := httptest.NewRecorder()
+			api.ServeHTTP(w, req)
+
+			Ω(w.Code).To(Equal(http.StatusOK))
+			Ω(r.Lookup("app.example.com")).NotTo(BeNil())
+		})
+
+		It("rejects requests without one", func() {
+			Ω(post("/routes/register", `{"host": "192.168.1.1", "port": 1234, "uris": ["app.example.com"]}`, token)).To(Equal(http.StatusUnauthorized))
+		})
+	})
+})
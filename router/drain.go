@@ -0,0 +1,98 @@
+package router
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/cloudfoundry/gorouter/proxy"
+)
+
+// drainable serves requests with handler, counting them in flight until
+// handler returns, which for WebSockets is when they close. Once the router
+// drains, it fails the heartbeats of load balancers in front of it.
+func (r *Router) drainable(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if r.component.Healthz.Draining() && proxy.IsLoadBalancerHeartbeat(req) {
+			w.Header().Set("Connection", "close")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte("draining\n"))
+			return
+		}
+
+		r.connLock.Lock()
+		r.inFlight++
+		r.connLock.Unlock()
+
+		defer func() {
+			r.connLock.Lock()
+			r.inFlight--
+			r.checkDrained()
+			r.connLock.Unlock()
+		}()
+
+		handler.ServeHTTP(w, req)
+	})
+}
+
+// checkDrained ends the drain once no connection is active and no request
+// is in flight. connLock must be locked.
+func (r *Router) checkDrained() {
+	if r.drainDone != nil && len(r.activeConns) == 0 && r.inFlight == 0 {
+		close(r.drainDone)
+		r.drainDone = nil
+	}
+}
+
+// Draining tells whether the router has started to drain.
+func (r *Router) Draining() bool {
+	return r.component.Healthz.Draining()
+}
+
+// SetDegraded has the status port report the router unhealthy for reason,
+// until it is set again with an empty one.
+func (r *Router) SetDegraded(reason string) {
+	r.component.Healthz.SetDegraded(reason)
+}
+
+// OutstandingRequests returns the number of requests in flight, which a
+// draining router waits for.
+func (r *Router) OutstandingRequests() int {
+	r.connLock.Lock()
+	defer r.connLock.Unlock()
+	return r.inFlight
+}
+
+// DrainRequests receives when a drain was asked for on the status port.
+func (r *Router) DrainRequests() <-chan struct{} {
+	return r.drainRequests
+}
+
+type drainStatus struct {
+	Draining            bool `json:"draining"`
+	OutstandingRequests int  `json:"outstanding_requests"`
+}
+
+// ServeDrain reports on the drain of the router, and asks for one on POST,
+// to the same effect as SIGUSR1.
+func (r *Router) ServeDrain(w http.ResponseWriter, req *http.Request) {
+	status := http.StatusOK
+	switch req.Method {
+	case "GET":
+	case "POST":
+		select {
+		case r.drainRequests <- struct{}{}:
+		default:
+		}
+		status = http.StatusAccepted
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(drainStatus{
+		Draining:            r.Draining(),
+		OutstandingRequests: r.OutstandingRequests(),
+	})
+}
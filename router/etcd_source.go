@@ -0,0 +1,411 @@
+package router
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	steno "github.com/cloudfoundry/gosteno"
+
+	"github.com/cloudfoundry/gorouter/config"
+	"github.com/cloudfoundry/gorouter/registry"
+	"github.com/cloudfoundry/gorouter/route"
+)
+
+const etcdRetryInterval = time.Second
+
+// EtcdSource registers the routes kept under a prefix of an etcd v3 cluster,
+// which it talks to through the JSON gateway of etcd 3.4 and later. Every
+// key holds a router.register message, and is watched, so that its routes
+// are registered as soon as it is written and unregistered as soon as it is
+// deleted, which is also what happens when the lease it is attached to
+// expires. The endpoints of a key with a lease go stale after the TTL the
+// lease was granted with, should the watch break in the meantime, and those
+// of other keys after their stale_threshold_in_seconds or the router's
+// droplet_stale_threshold, like routes registered through NATS. Since the
+// store is durable, a router that starts or loses its watch reads all of the
+// routes again.
+type EtcdSource struct {
+	config   config.EtcdConfig
+	registry registry.RegistryInterface
+	client   *http.Client
+	logger   *steno.Logger
+
+	lock     sync.Mutex
+	routes   map[string]*etcdRoute
+	endpoint int
+
+	stopCh chan struct{}
+}
+
+type etcdRoute struct {
+	uris     []route.Uri
+	endpoint *route.Endpoint
+}
+
+func NewEtcdSource(c config.EtcdConfig, r registry.RegistryInterface) *EtcdSource {
+	return &EtcdSource{
+		config:   c,
+		registry: r,
+		// the watch is a response that does not end, so only the unary
+		// requests are given the timeout
+		client: &http.Client{},
+		logger: steno.NewLogger("router.etcd"),
+
+		routes: make(map[string]*etcdRoute),
+		stopCh: make(chan struct{}),
+	}
+}
+
+// Run reads the routes and watches them until Stop is called, moving on to
+// the next endpoint of the cluster and reading all of them again whenever
+// the watch ends. The routes are registered again every refresh interval.
+func (s *EtcdSource) Run() {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-s.stopCh
+		cancel()
+	}()
+
+	go s.refresh()
+
+	for {
+		revision, err := s.Sync(ctx)
+		if err == nil {
+			err = s.watch(ctx, revision+1)
+		}
+
+		select {
+		case <-s.stopCh:
+			return
+		default:
+		}
+
+		s.logger.Warnd(map[string]interface{}{"endpoint": s.address(), "error": err.Error()}, "etcd.watch.failed")
+		s.lock.Lock()
+		s.endpoint = (s.endpoint + 1) % len(s.config.Endpoints)
+		s.lock.Unlock()
+
+		select {
+		case <-time.After(etcdRetryInterval):
+		case <-s.stopCh:
+			return
+		}
+	}
+}
+
+func (s *EtcdSource) Stop() {
+	close(s.stopCh)
+}
+
+func (s *EtcdSource) refresh() {
+	ticker := time.NewTicker(s.config.RefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.lock.Lock()
+			for _, r := range s.routes {
+				s.replace(nil, r)
+			}
+			s.lock.Unlock()
+		case <-s.stopCh:
+			return
+		}
+	}
+}
+
+// Sync reads every key under the prefix, registers the routes they hold and
+// unregisters those of keys that are gone, and returns the revision of the
+// store that it read.
+func (s *EtcdSource) Sync(ctx context.Context) (int64, error) {
+	start, end := s.keyRange()
+
+	var res struct {
+		Header etcdHeader `json:"header"`
+		Kvs    []etcdKv   `json:"kvs"`
+	}
+	err := s.call(ctx, "/v3/kv/range", map[string]interface{}{"key": start, "range_end": end}, &res)
+	if err != nil {
+		return 0, err
+	}
+
+	routes := make(map[string]*etcdRoute, len(res.Kvs))
+	for _, kv := range res.Kvs {
+		if r := s.parse(ctx, kv); r != nil {
+			routes[string(kv.Key)] = r
+		}
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for key, r := range s.routes {
+		if _, ok := routes[key]; !ok {
+			s.replace(r, nil)
+		}
+	}
+	for key, r := range routes {
+		s.replace(s.routes[key], r)
+	}
+	s.routes = routes
+
+	return int64(res.Header.Revision), nil
+}
+
+// watch applies the changes to the keys under the prefix from revision on,
+// until the watch ends.
+func (s *EtcdSource) watch(ctx context.Context, revision int64) error {
+	start, end := s.keyRange()
+	body, err := json.Marshal(map[string]interface{}{
+		"create_request": map[string]interface{}{
+			"key":            start,
+			"range_end":      end,
+			"start_revision": strconv.FormatInt(revision, 10),
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	request, err := http.NewRequest("POST", s.address()+"/v3/watch", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	res, err := s.client.Do(request.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("etcd answered the watch with %d", res.StatusCode)
+	}
+
+	decoder := json.NewDecoder(res.Body)
+	for {
+		var message struct {
+			Result *struct {
+				Canceled        bool        `json:"canceled"`
+				CancelReason    string      `json:"cancel_reason"`
+				CompactRevision etcdInt     `json:"compact_revision"`
+				Events          []etcdEvent `json:"events"`
+			} `json:"result"`
+			Error *etcdError `json:"error"`
+		}
+
+		err := decoder.Decode(&message)
+		if err == io.EOF {
+			return errors.New("etcd ended the watch")
+		}
+		if err != nil {
+			return err
+		}
+
+		if message.Error != nil {
+			return message.Error
+		}
+		if message.Result == nil {
+			continue
+		}
+		if message.Result.CompactRevision > 0 {
+			return fmt.Errorf("etcd compacted the revisions up to %d", message.Result.CompactRevision)
+		}
+		if message.Result.Canceled {
+			return errors.New("etcd canceled the watch: " + message.Result.CancelReason)
+		}
+
+		for _, event := range message.Result.Events {
+			s.apply(ctx, event)
+		}
+	}
+}
+
+func (s *EtcdSource) apply(ctx context.Context, event etcdEvent) {
+	key := string(event.Kv.Key)
+
+	var r *etcdRoute
+	if event.Type != "DELETE" {
+		r = s.parse(ctx, event.Kv)
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.replace(s.routes[key], r)
+	if r == nil {
+		delete(s.routes, key)
+	} else {
+		s.routes[key] = r
+	}
+}
+
+// parse makes the routes of a key out of the message it holds, or returns
+// nil when it holds none.
+func (s *EtcdSource) parse(ctx context.Context, kv etcdKv) *etcdRoute {
+	var msg registryMessage
+	err := json.Unmarshal(kv.Value, &msg)
+	if err != nil || len(msg.Uris) == 0 {
+		s.logger.Warnd(map[string]interface{}{"key": string(kv.Key)}, "etcd.key.invalid")
+		return nil
+	}
+
+	if kv.Lease != 0 {
+		ttl, err := s.leaseTTL(ctx, int64(kv.Lease))
+		if err != nil {
+			s.logger.Warnd(map[string]interface{}{"key": string(kv.Key), "error": err.Error()}, "etcd.lease.failed")
+		} else {
+			msg.StaleThresholdInSeconds = ttl
+		}
+	}
+
+	return &etcdRoute{uris: msg.Uris, endpoint: msg.makeEndpoint()}
+}
+
+func (s *EtcdSource) leaseTTL(ctx context.Context, id int64) (int, error) {
+	var res struct {
+		GrantedTTL etcdInt `json:"grantedTTL"`
+	}
+	err := s.call(ctx, "/v3/lease/timetolive", map[string]interface{}{"ID": strconv.FormatInt(id, 10)}, &res)
+	if err != nil {
+		return 0, err
+	}
+	return int(res.GrantedTTL), nil
+}
+
+// replace registers the routes of r and unregisters those of old that r
+// does not have. s.lock must be locked.
+func (s *EtcdSource) replace(old, r *etcdRoute) {
+	if old != nil {
+		for _, uri := range old.uris {
+			if !r.has(uri, old.endpoint) {
+				s.registry.Unregister(uri, old.endpoint)
+			}
+		}
+	}
+
+	if r != nil {
+		for _, uri := range r.uris {
+			s.registry.Register(uri, r.endpoint)
+		}
+	}
+}
+
+func (r *etcdRoute) has(uri route.Uri, endpoint *route.Endpoint) bool {
+	if r == nil || r.endpoint.CanonicalAddr() != endpoint.CanonicalAddr() {
+		return false
+	}
+	for _, u := range r.uris {
+		if u.ToLower() == uri.ToLower() {
+			return true
+		}
+	}
+	return false
+}
+
+// keyRange returns the range of the keys under the prefix, which ends
+// before the prefix with its last byte incremented.
+func (s *EtcdSource) keyRange() ([]byte, []byte) {
+	start := []byte(s.config.Prefix)
+	end := append([]byte(nil), start...)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return start, end[:i+1]
+		}
+	}
+	// a prefix of 0xff bytes only is followed by every key
+	return start, []byte{0}
+}
+
+func (s *EtcdSource) address() string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return strings.TrimSuffix(s.config.Endpoints[s.endpoint], "/")
+}
+
+func (s *EtcdSource) call(ctx context.Context, path string, args interface{}, v interface{}) error {
+	body, err := json.Marshal(args)
+	if err != nil {
+		return err
+	}
+
+	request, err := http.NewRequest("POST", s.address()+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, s.config.Timeout)
+	defer cancel()
+
+	res, err := s.client.Do(request.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		var e etcdError
+		b, _ := ioutil.ReadAll(io.LimitReader(res.Body, 4096))
+		if json.Unmarshal(b, &e) == nil && e.Message != "" {
+			return &e
+		}
+		return fmt.Errorf("etcd answered %s with %d", path, res.StatusCode)
+	}
+
+	return json.NewDecoder(res.Body).Decode(v)
+}
+
+type etcdHeader struct {
+	Revision etcdInt `json:"revision"`
+}
+
+type etcdKv struct {
+	Key   []byte  `json:"key"`
+	Value []byte  `json:"value"`
+	Lease etcdInt `json:"lease"`
+}
+
+type etcdEvent struct {
+	Type string `json:"type"`
+	Kv   etcdKv `json:"kv"`
+}
+
+type etcdError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *etcdError) Error() string {
+	return fmt.Sprintf("etcd error %d: %s", e.Code, e.Message)
+}
+
+// etcdInt is an int64 of the gateway, which writes them as strings.
+type etcdInt int64
+
+func (i *etcdInt) UnmarshalJSON(b []byte) error {
+	s := strings.Trim(string(b), `"`)
+	if s == "" || s == "null" {
+		*i = 0
+		return nil
+	}
+
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return err
+	}
+	*i = etcdInt(n)
+	return nil
+}
@@ -0,0 +1,215 @@
+package router_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+
+	"github.com/cloudfoundry/gorouter/config"
+	"github.com/cloudfoundry/gorouter/registry"
+	"github.com/cloudfoundry/gorouter/route"
+	. "github.com/cloudfoundry/gorouter/router"
+	"github.com/cloudfoundry/yagnats/fakeyagnats"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// fakeEtcd serves the parts of the JSON gateway of etcd that the source
+// uses, from keys that do not expire.
+type fakeEtcd struct {
+	sync.Mutex
+	kvs      map[string]fakeKv
+	revision int64
+	events   chan map[string]interface{}
+	leases   []string
+	ranges   int
+}
+
+type fakeKv struct {
+	value string
+	lease string
+}
+
+func newFakeEtcd() *fakeEtcd {
+	return &fakeEtcd{
+		kvs:      make(map[string]fakeKv),
+		revision: 1,
+		events:   make(chan map[string]interface{}, 10),
+	}
+}
+
+func (f *fakeEtcd) kv(key string) map[string]interface{} {
+	kv := f.kvs[key]
+	return map[string]interface{}{"key": []byte(key), "value": []byte(kv.value), "lease": kv.lease}
+}
+
+func (f *fakeEtcd) put(key, value, lease string) {
+	f.Lock()
+	f.revision++
+	f.kvs[key] = fakeKv{value: value, lease: lease}
+	event := map[string]interface{}{"kv": f.kv(key)}
+	f.Unlock()
+	f.events <- event
+}
+
+func (f *fakeEtcd) delete(key string) {
+	f.Lock()
+	f.revision++
+	delete(f.kvs, key)
+	f.Unlock()
+	f.events <- map[string]interface{}{"type": "DELETE", "kv": map[string]interface{}{"key": []byte(key)}}
+}
+
+func (f *fakeEtcd) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	var body map[string]interface{}
+	json.NewDecoder(req.Body).Decode(&body)
+
+	f.Lock()
+	switch req.URL.Path {
+	case "/v3/kv/range":
+		f.ranges++
+		var kvs []interface{}
+		for key := range f.kvs {
+			kvs = append(kvs, f.kv(key))
+		}
+		revision := f.revision
+		f.Unlock()
+		json.NewEncoder(w).Encode(map[string]interface{}{"header": map[string]string{"revision": fmt.Sprint(revision)}, "kvs": kvs})
+	case "/v3/lease/timetolive":
+		f.leases = append(f.leases, body["ID"].(string))
+		f.Unlock()
+		json.NewEncoder(w).Encode(map[string]string{"ID": body["ID"].(string), "TTL": "25", "grantedTTL": "30"})
+	case "/v3/watch":
+		f.Unlock()
+		encoder := json.NewEncoder(w)
+		encoder.Encode(map[string]interface{}{"result": map[string]interface{}{"created": true}})
+		w.(http.Flusher).Flush()
+		for {
+			select {
+			case event, ok := <-f.events:
+				if !ok {
+					return
+				}
+				encoder.Encode(map[string]interface{}{"result": map[string]interface{}{"events": []interface{}{event}}})
+				w.(http.Flusher).Flush()
+			case <-req.Context().Done():
+				return
+			}
+		}
+	default:
+		f.Unlock()
+		http.NotFound(w, req)
+	}
+}
+
+var _ = Describe("EtcdSource", func() {
+	var (
+		etcd   *fakeEtcd
+		server *httptest.Server
+		c      config.EtcdConfig
+		r      *registry.RouteRegistry
+		source *EtcdSource
+	)
+
+	BeforeEach(func() {
+		etcd = newFakeEtcd()
+		server = httptest.NewServer(etcd)
+
+		c = config.DefaultConfig().Etcd
+		c.Endpoints = []string{server.URL}
+		r = registry.NewRouteRegistry(config.DefaultConfig(), fakeyagnats.Connect())
+	})
+
+	JustBeforeEach(func() {
+		source = NewEtcdSource(c, r)
+	})
+
+	AfterEach(func() {
+		server.CloseClientConnections()
+		server.Close()
+	})
+
+	It("registers the routes of the keys under the prefix", func() {
+		etcd.kvs["/gorouter/routes/a"] = fakeKv{value: `{"host": "192.168.1.1", "port": 1234, "uris": ["a.example.com", "b.example.com"], "app": "app-guid"}`}
+		etcd.kvs["/gorouter/routes/b"] = fakeKv{value: `{`}
+
+		revision, err := source.Sync(context.Background())
+		Ω(err).NotTo(HaveOccurred())
+		Ω(revision).To(Equal(int64(1)))
+
+		pool := r.Lookup("a.example.com")
+		Ω(pool).NotTo(BeNil())
+		Ω(pool.Endpoints("").Next().ApplicationId).To(Equal("app-guid"))
+		Ω(r.Lookup("b.example.com")).NotTo(BeNil())
+	})
+
+	It("unregisters the routes of keys that are gone when it syncs again", func() {
+		etcd.kvs["/gorouter/routes/a"] = fakeKv{value: `{"host": "192.168.1.1", "port": 1234, "uris": ["a.example.com", "b.example.com"]}`}
+		_, err := source.Sync(context.Background())
+		Ω(err).NotTo(HaveOccurred())
+
+		etcd.kvs["/gorouter/routes/a"] = fakeKv{value: `{"host": "192.168.1.1", "port": 1234, "uris": ["a.example.com"]}`}
+		_, err = source.Sync(context.Background())
+		Ω(err).NotTo(HaveOccurred())
+
+		Ω(r.Lookup("a.example.com")).NotTo(BeNil())
+		Ω(r.Lookup("b.example.com")).To(BeNil())
+	})
+
+	It("has the endpoints of keys with a lease go stale after its TTL", func() {
+		etcd.kvs["/gorouter/routes/a"] = fakeKv{value: `{"host": "192.168.1.1", "port": 1234, "uris": ["a.example.com"]}`, lease: "7587848"}
+
+		_, err := source.Sync(context.Background())
+		Ω(err).NotTo(HaveOccurred())
+
+		Ω(etcd.leases).To(Equal([]string{"7587848"}))
+		Ω(r.Lookup("a.example.com").Endpoints("").Next().StaleThreshold()).To(Equal(30 * time.Second))
+	})
+
+	Context("when running", func() {
+		JustBeforeEach(func() {
+			go source.Run()
+		})
+
+		AfterEach(func() {
+			source.Stop()
+		})
+
+		It("registers and unregisters the routes of keys as they change", func() {
+			etcd.put("/gorouter/routes/a", `{"host": "192.168.1.1", "port": 1234, "uris": ["a.example.com"]}`, "")
+			Eventually(func() *route.Pool { return r.Lookup("a.example.com") }).ShouldNot(BeNil())
+
+			etcd.put("/gorouter/routes/a", `{"host": "192.168.1.2", "port": 1234, "uris": ["a.example.com"]}`, "")
+			Eventually(func() []string {
+				var addrs []string
+				r.Lookup("a.example.com").Each(func(e *route.Endpoint) {
+					addrs = append(addrs, e.CanonicalAddr())
+				})
+				return addrs
+			}).Should(Equal([]string{"192.168.1.2:1234"}))
+
+			etcd.delete("/gorouter/routes/a")
+			Eventually(func() *route.Pool { return r.Lookup("a.example.com") }).Should(BeNil())
+		})
+
+		It("reads the routes again when the watch ends", func() {
+			Eventually(func() int {
+				etcd.Lock()
+				defer etcd.Unlock()
+				return etcd.ranges
+			}).Should(Equal(1))
+
+			etcd.Lock()
+			etcd.kvs["/gorouter/routes/a"] = fakeKv{value: `{"host": "192.168.1.1", "port": 1234, "uris": ["a.example.com"]}`}
+			etcd.Unlock()
+			close(etcd.events)
+
+			Eventually(func() *route.Pool { return r.Lookup("a.example.com") }, 3*time.Second).ShouldNot(BeNil())
+		})
+	})
+})
@@ -0,0 +1,47 @@
+package router
+
+import (
+	"fmt"
+	"time"
+)
+
+// natsUnreachable fails the health of the router once NATS has not
+// answered for longer than the config allows.
+func (r *Router) natsUnreachable() string {
+	d := r.nats.UnreachableFor()
+	if d > r.config.Healthz.NatsUnreachableAfter {
+		return fmt.Sprintf("nats unreachable for %s", d)
+	}
+	return ""
+}
+
+// healthzDetail reports whether NATS answers, and how many routes there
+// are and how recently they were registered, for /healthz.
+func (r *Router) healthzDetail() map[string]interface{} {
+	nats := map[string]interface{}{
+		"reachable": r.nats.UnreachableSince().IsZero(),
+	}
+	if t := r.nats.LastAnswer(); !t.IsZero() {
+		nats["last_answer"] = t
+	}
+	if t := r.nats.UnreachableSince(); !t.IsZero() {
+		nats["unreachable_since"] = t
+	}
+
+	routes := map[string]interface{}{
+		"uris":      r.registry.NumUris(),
+		"endpoints": r.registry.NumEndpoints(),
+		"stale":     false,
+	}
+	if t := r.registry.TimeOfLastUpdate(); !t.IsZero() {
+		routes["last_update"] = t
+
+		staleAfter := r.config.HealthDetail.RegistryStaleAfter
+		routes["stale"] = staleAfter > 0 && time.Since(t) > staleAfter
+	}
+
+	return map[string]interface{}{
+		"nats":   nats,
+		"routes": routes,
+	}
+}
@@ -0,0 +1,104 @@
+package router_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/cloudfoundry/gorouter/access_log"
+	vcap "github.com/cloudfoundry/gorouter/common"
+	cfg "github.com/cloudfoundry/gorouter/config"
+	"github.com/cloudfoundry/gorouter/proxy"
+	rregistry "github.com/cloudfoundry/gorouter/registry"
+	"github.com/cloudfoundry/gorouter/route"
+	. "github.com/cloudfoundry/gorouter/router"
+	"github.com/cloudfoundry/gorouter/test_util"
+	vvarz "github.com/cloudfoundry/gorouter/varz"
+	"github.com/cloudfoundry/yagnats/fakeyagnats"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Healthz", func() {
+	var (
+		config     *cfg.Config
+		mbusClient *fakeyagnats.FakeNATSConn
+		registry   *rregistry.RouteRegistry
+		router     *Router
+	)
+
+	BeforeEach(func() {
+		config = test_util.SpecConfig(test_util.NextAvailPort(), test_util.NextAvailPort(), test_util.NextAvailPort())
+		config.Healthz.Detailed = true
+		config.Healthz.Strict = true
+
+		mbusClient = fakeyagnats.Connect()
+		registry = rregistry.NewRouteRegistry(config, mbusClient)
+		registry.Register("foo.example.com", route.NewEndpoint("", "10.0.0.1", 8080, "", nil, -1))
+	})
+
+	JustBeforeEach(func() {
+		varz := vvarz.NewVarz(registry)
+		p := proxy.NewProxy(proxy.ProxyArgs{
+			Ip:           config.Ip,
+			Registry:     registry,
+			Reporter:     varz,
+			AccessLogger: &access_log.NullAccessLogger{},
+		})
+
+		var err error
+		router, err = NewRouter(config, p, mbusClient, registry, varz, vcap.NewLogCounter())
+		Ω(err).ShouldNot(HaveOccurred())
+		router.Run()
+	})
+
+	AfterEach(func() {
+		router.Stop()
+	})
+
+	healthz := func() (int, map[string]interface{}) {
+		res, err := http.Get(fmt.Sprintf("http://%s:%d/healthz", config.Ip, config.Status.Port))
+		Ω(err).ShouldNot(HaveOccurred())
+		defer res.Body.Close()
+
+		var detail map[string]interface{}
+		Ω(json.NewDecoder(res.Body).Decode(&detail)).Should(Succeed())
+		return res.StatusCode, detail
+	}
+
+	It("reports on NATS, the routes and the drain state", func() {
+		Eventually(func() interface{} {
+			_, detail := healthz()
+			return detail["nats"]
+		}).Should(HaveKey("last_answer"))
+
+		code, detail := healthz()
+		Ω(code).Should(Equal(http.StatusOK))
+		Ω(detail).Should(HaveKeyWithValue("status", "ok"))
+		Ω(detail).Should(HaveKeyWithValue("draining", false))
+		Ω(detail).Should(HaveKey("uptime"))
+		Ω(detail["nats"]).Should(HaveKeyWithValue("reachable", true))
+		Ω(detail["routes"]).Should(HaveKeyWithValue("uris", BeEquivalentTo(1)))
+		Ω(detail["routes"]).Should(HaveKeyWithValue("endpoints", BeEquivalentTo(1)))
+		Ω(detail["routes"]).Should(HaveKeyWithValue("stale", false))
+	})
+
+	Context("when NATS does not answer for longer than allowed", func() {
+		BeforeEach(func() {
+			mbusClient.OnPing(func() bool { return false })
+			config.Healthz.NatsUnreachableAfter = 0
+		})
+
+		It("fails", func() {
+			Eventually(func() int {
+				code, _ := healthz()
+				return code
+			}).Should(Equal(http.StatusServiceUnavailable))
+
+			_, detail := healthz()
+			Ω(detail["status"]).Should(HavePrefix("degraded: nats unreachable for"))
+			Ω(detail["nats"]).Should(HaveKeyWithValue("reachable", false))
+			Ω(detail["nats"]).Should(HaveKey("unreachable_since"))
+		})
+	})
+})
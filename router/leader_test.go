@@ -0,0 +1,116 @@
+package router_test
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/apcera/nats"
+	"github.com/cloudfoundry/gorouter/access_log"
+	vcap "github.com/cloudfoundry/gorouter/common"
+	cfg "github.com/cloudfoundry/gorouter/config"
+	"github.com/cloudfoundry/gorouter/leader"
+	"github.com/cloudfoundry/gorouter/proxy"
+	rregistry "github.com/cloudfoundry/gorouter/registry"
+	. "github.com/cloudfoundry/gorouter/router"
+	"github.com/cloudfoundry/gorouter/test_util"
+	vvarz "github.com/cloudfoundry/gorouter/varz"
+	"github.com/cloudfoundry/yagnats/fakeyagnats"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Leader election", func() {
+	type candidate struct {
+		config *cfg.Config
+		mbus   *fakeyagnats.FakeNATSConn
+		router *Router
+	}
+
+	var leading, following *candidate
+	var partitioned int32
+
+	newCandidate := func() *candidate {
+		config := test_util.SpecConfig(test_util.NextAvailPort(), test_util.NextAvailPort(), test_util.NextAvailPort())
+		config.LeaderElection.Enabled = true
+		config.LeaderElection.HeartbeatInterval = 10 * time.Millisecond
+		config.LeaderElection.TTL = 50 * time.Millisecond
+		config.LeaderElection.ReconcileInterval = 10 * time.Millisecond
+
+		return &candidate{config: config, mbus: fakeyagnats.Connect()}
+	}
+
+	id := func(c *candidate) string {
+		return fmt.Sprintf("%s:%d", c.config.Ip, c.config.Port)
+	}
+
+	// each router has a NATS connection of its own; heartbeats get across
+	// unless the routers are partitioned
+	connect := func(from, to *candidate) {
+		from.mbus.WhenPublishing(leader.HeartbeatSubject, func(msg *nats.Msg) error {
+			if atomic.LoadInt32(&partitioned) == 0 {
+				for _, cb := range to.mbus.SubjectCallbacks(leader.HeartbeatSubject) {
+					cb(msg)
+				}
+			}
+			return nil
+		})
+	}
+
+	run := func(c *candidate) {
+		registry := rregistry.NewRouteRegistry(c.config, c.mbus)
+		varz := vvarz.NewVarz(registry)
+		p := proxy.NewProxy(proxy.ProxyArgs{
+			Ip:           c.config.Ip,
+			Registry:     registry,
+			Reporter:     varz,
+			AccessLogger: &access_log.NullAccessLogger{},
+		})
+
+		var err error
+		c.router, err = NewRouter(c.config, p, c.mbus, registry, varz, vcap.NewLogCounter())
+		Ω(err).ShouldNot(HaveOccurred())
+		c.router.Run()
+	}
+
+	starts := func(c *candidate) func() int {
+		return func() int {
+			return len(c.mbus.PublishedMessages("router.start"))
+		}
+	}
+
+	BeforeEach(func() {
+		atomic.StoreInt32(&partitioned, 0)
+
+		leading, following = newCandidate(), newCandidate()
+		if id(following) < id(leading) {
+			leading, following = following, leading
+		}
+
+		connect(leading, following)
+		connect(following, leading)
+		run(leading)
+		run(following)
+	})
+
+	AfterEach(func() {
+		leading.router.Stop()
+		following.router.Stop()
+	})
+
+	It("reconciles the route tables only from the leader", func() {
+		Eventually(starts(leading)).Should(BeNumerically(">", 2))
+
+		n := starts(following)()
+		Consistently(starts(following)).Should(Equal(n))
+	})
+
+	It("reconciles from a follower once it takes over", func() {
+		Eventually(starts(leading)).Should(BeNumerically(">", 2))
+		n := starts(following)()
+
+		atomic.StoreInt32(&partitioned, 1)
+
+		Eventually(starts(following)).Should(BeNumerically(">", n+1))
+	})
+})
@@ -0,0 +1,43 @@
+package router
+
+import (
+	"crypto/x509"
+	"time"
+)
+
+func (r *Router) setServing(name string, serving bool) {
+	r.servingLock.Lock()
+	r.serving[name] = serving
+	r.servingLock.Unlock()
+}
+
+// ListenerStates returns whether each listener of the router serves, by
+// its name: http, https, tls_passthrough, or tcp and its port.
+func (r *Router) ListenerStates() map[string]bool {
+	r.servingLock.Lock()
+	defer r.servingLock.Unlock()
+
+	states := make(map[string]bool, len(r.serving))
+	for name, serving := range r.serving {
+		states[name] = serving
+	}
+	return states
+}
+
+// CertificateExpiries returns when each certificate the router serves
+// expires: its default certificate, and those of the domains it was
+// configured with, by their domains.
+func (r *Router) CertificateExpiries() map[string]time.Time {
+	expiries := r.certs.NotAfter()
+	if expiries == nil {
+		expiries = make(map[string]time.Time)
+	}
+
+	if r.config.EnableSSL && len(r.config.SSLCertificate.Certificate) > 0 {
+		leaf, err := x509.ParseCertificate(r.config.SSLCertificate.Certificate[0])
+		if err == nil {
+			expiries["default"] = leaf.NotAfter
+		}
+	}
+	return expiries
+}
@@ -1,6 +1,11 @@
 package router
 
 import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/cloudfoundry/gorouter/ipfilter"
 	"github.com/cloudfoundry/gorouter/route"
 )
 
@@ -11,10 +16,80 @@ type registryMessage struct {
 	Tags                    map[string]string `json:"tags"`
 	App                     string            `json:"app"`
 	StaleThresholdInSeconds int               `json:"stale_threshold_in_seconds"`
+	TimeoutInSeconds        int               `json:"timeout_in_seconds"`
+
+	RequiresAuthorizationHeader bool `json:"requires_authorization_header"`
+	RequiresSignedUrls          bool `json:"requires_signed_urls"`
+	Backup                      bool `json:"backup"`
+
+	Auth authScheme `json:"auth"`
+
+	AllowedCidrs ipfilter.Networks `json:"allowed_cidrs"`
+	DeniedCidrs  ipfilter.Networks `json:"denied_cidrs"`
+
+	MaxRequestBodyBytes int64 `json:"max_request_body_bytes"`
+	DisableCompression  bool  `json:"disable_compression"`
+	DisableBanners      bool  `json:"disable_banners"`
+
+	Group  string `json:"group"`
+	Weight int    `json:"weight"`
+
+	AppVersion string `json:"app_version"`
+
+	// Origin names the component that sent the message, whose messages
+	// are counted and limited apart from those of other components.
+	Origin string `json:"origin"`
 
-	PrivateInstanceId string `json:"private_instance_id"`
+	Match route.Match `json:"match"`
+
+	PrivateInstanceId    string `json:"private_instance_id"`
+	PrivateInstanceIndex string `json:"private_instance_index"`
+	HealthCheckPath      string `json:"health_check_path"`
+
+	// Only used by router.tcp.register and router.tcp.unregister
+	RouterPort uint16 `json:"router_port"`
 }
 
 func (rm *registryMessage) makeEndpoint() *route.Endpoint {
-	return route.NewEndpoint(rm.App, rm.Host, rm.Port, rm.PrivateInstanceId, rm.Tags, rm.StaleThresholdInSeconds)
+	endpoint := route.NewEndpoint(rm.App, rm.Host, rm.Port, rm.PrivateInstanceId, rm.Tags, rm.StaleThresholdInSeconds)
+	endpoint.PrivateInstanceIndex = rm.PrivateInstanceIndex
+	endpoint.HealthCheckPath = rm.HealthCheckPath
+	endpoint.Timeout = time.Duration(rm.TimeoutInSeconds) * time.Second
+	endpoint.RequiresAuthorizationHeader = rm.RequiresAuthorizationHeader
+	endpoint.RequiresSignedUrls = rm.RequiresSignedUrls
+	endpoint.RequiresJwt = rm.Auth == authJwt
+	endpoint.AllowedNetworks = rm.AllowedCidrs
+	endpoint.DeniedNetworks = rm.DeniedCidrs
+	endpoint.Backup = rm.Backup
+	endpoint.MaxRequestBodyBytes = rm.MaxRequestBodyBytes
+	endpoint.DisableCompression = rm.DisableCompression
+	endpoint.DisableBanners = rm.DisableBanners
+	endpoint.Group = rm.Group
+	endpoint.Weight = rm.Weight
+	endpoint.AppVersion = rm.AppVersion
+	endpoint.Match = rm.Match
+	return endpoint
+}
+
+const authJwt = "jwt"
+
+// An authScheme is how the router authenticates requests to a route before
+// passing them on: with a bearer token for jwt, and not at all when it is
+// empty. Messages with any other scheme are rejected, rather than leaving
+// a route open that was meant to be protected.
+type authScheme string
+
+func (a *authScheme) UnmarshalJSON(b []byte) error {
+	var s string
+	err := json.Unmarshal(b, &s)
+	if err != nil {
+		return err
+	}
+
+	switch s {
+	case "", authJwt:
+		*a = authScheme(s)
+		return nil
+	}
+	return fmt.Errorf("unknown auth %q", s)
 }
@@ -0,0 +1,57 @@
+package router
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/cloudfoundry/gorouter/config"
+	"github.com/cloudfoundry/gorouter/registry"
+	"github.com/cloudfoundry/yagnats/fakeyagnats"
+)
+
+// FuzzRegistryMessage feeds registration messages, as they arrive over
+// NATS, through the router's handling of router.register and
+// router.unregister. Whatever the payload, the router must not panic, the
+// valid routes it registers must be found again, and unregistering must
+// remove them.
+func FuzzRegistryMessage(f *testing.F) {
+	f.Add([]byte(`{"host":"1.2.3.4","port":1234,"uris":["foo.example.com"],"app":"app-guid","tags":{"component":"web"},"private_instance_id":"instance","timeout_in_seconds":10}`))
+	f.Add([]byte(`{"host":"::1","port":65535,"uris":["*.Example.COM","","."],"stale_threshold_in_seconds":-1,"max_request_body_bytes":-5}`))
+	f.Add([]byte(`{"host":"10.0.0.1","port":8080,"uris":["a.b.c.d.e"],"backup":true,"disable_compression":true,"health_check_path":"/health"}`))
+	f.Add([]byte(`{"host":"10.0.0.2","port":8080,"uris":["api.example.com"],"auth":"jwt","allowed_cidrs":["10.0.0.0/8"]}`))
+	f.Add([]byte(`{"host":"10.0.0.3","port":"8080","uris":["partial.example.com"],"auth":"basic"}`))
+	f.Add([]byte(`{"uris":null,"tags":[]}`))
+	f.Add([]byte(`{"host":"10.0.0.4","port":8080,"uris":["wrong.example.com"],"requires_signed_urls":"true","match":["/api"]}`))
+	f.Add([]byte(`[]`))
+
+	f.Fuzz(func(t *testing.T, payload []byte) {
+		var msg registryMessage
+		// the router drops messages that fail to unmarshal, so the fuzzer
+		// does too
+		if json.Unmarshal(payload, &msg) != nil {
+			return
+		}
+
+		r := registry.NewRouteRegistry(config.DefaultConfig(), fakeyagnats.Connect())
+
+		for _, uri := range msg.Uris {
+			r.Register(uri, msg.makeEndpoint())
+		}
+		for _, uri := range msg.Uris {
+			// malformed uris and priorities without a match are refused
+			if !uri.IsValid() || !msg.Match.IsValid() {
+				continue
+			}
+			if r.Lookup(uri) == nil {
+				t.Fatalf("registered uri %q is not found", uri)
+			}
+		}
+
+		for _, uri := range msg.Uris {
+			r.Unregister(uri, msg.makeEndpoint())
+		}
+		if n := r.NumUris(); n != 0 {
+			t.Fatalf("%d uris are left after unregistering them all", n)
+		}
+	})
+}
@@ -5,10 +5,19 @@ import (
 
 	"github.com/apcera/nats"
 	"github.com/cloudfoundry/dropsonde"
+	"github.com/cloudfoundry/gorouter/certstore"
+	"github.com/cloudfoundry/gorouter/clock"
 	vcap "github.com/cloudfoundry/gorouter/common"
 	"github.com/cloudfoundry/gorouter/config"
+	"github.com/cloudfoundry/gorouter/handoff"
+	"github.com/cloudfoundry/gorouter/leader"
+	"github.com/cloudfoundry/gorouter/limits"
+	"github.com/cloudfoundry/gorouter/mbus"
+	"github.com/cloudfoundry/gorouter/parsing"
 	"github.com/cloudfoundry/gorouter/proxy"
+	"github.com/cloudfoundry/gorouter/ratelimit"
 	"github.com/cloudfoundry/gorouter/registry"
+	"github.com/cloudfoundry/gorouter/systemd"
 	"github.com/cloudfoundry/gorouter/varz"
 	steno "github.com/cloudfoundry/gosteno"
 	"github.com/cloudfoundry/yagnats"
@@ -22,6 +31,8 @@ import (
 	"fmt"
 	"net"
 	"net/http"
+	"os"
+	"strings"
 	"time"
 )
 
@@ -31,20 +42,34 @@ var noDeadline = time.Time{}
 type Router struct {
 	config     *config.Config
 	proxy      proxy.Proxy
+	tcpProxy   proxy.TcpProxy
+	tlsProxy   proxy.TlsPassthroughProxy
 	mbusClient yagnats.NATSConn
 	registry   *registry.RouteRegistry
+	updates    *registry.Batcher
 	varz       varz.Varz
 	component  *vcap.VcapComponent
-
-	listener         net.Listener
-	tlsListener      net.Listener
-	closeConnections bool
-	connLock         sync.Mutex
-	idleConns        map[net.Conn]struct{}
-	activeConns      map[net.Conn]struct{}
-	drainDone        chan struct{}
-	serveDone        chan struct{}
-	tlsServeDone     chan struct{}
+	elector    *leader.Elector
+	certs      *certstore.Store
+	nats       *mbus.Monitor
+
+	listener            net.Listener
+	tlsListener         net.Listener
+	tcpListeners        []net.Listener
+	passthroughListener net.Listener
+	closeConnections    bool
+	connLock            sync.Mutex
+	idleConns           map[net.Conn]struct{}
+	activeConns         map[net.Conn]struct{}
+	connectionLimit     *limits.Limit
+	inFlight            int
+	drainDone           chan struct{}
+	drainRequests       chan struct{}
+	serveDone           chan struct{}
+	tlsServeDone        chan struct{}
+	servingLock         sync.Mutex
+	serving             map[string]bool
+	handedOff           bool
 
 	logger *steno.Logger
 }
@@ -74,23 +99,64 @@ func NewRouter(cfg *config.Config, p proxy.Proxy, mbusClient yagnats.NATSConn, r
 		Config:      cfg,
 		Varz:        varz,
 		Healthz:     healthz,
-		InfoRoutes: map[string]json.Marshaler{
-			"/routes": r,
+		InfoRoutes:  map[string]json.Marshaler{},
+		Routes: map[string]http.Handler{
+			"/routes": http.HandlerFunc(r.ServeRoutes),
 		},
 	}
 
+	if cutover := r.Cutover(); cutover != nil {
+		component.Routes["/cutover"] = cutover
+	}
+
+	certs := certstore.New(cfg.TlsCertificates)
+	if certs != nil {
+		component.InfoRoutes["/certificates"] = certs
+	}
+
+	var elector *leader.Elector
+	if cfg.LeaderElection.Enabled {
+		id := fmt.Sprintf("%s:%d", cfg.Ip, cfg.Port)
+		elector = leader.NewElector(id, mbusClient, cfg.LeaderElection.HeartbeatInterval, cfg.LeaderElection.TTL)
+		component.InfoRoutes["/leader"] = elector
+	}
+
 	router := &Router{
-		config:       cfg,
-		proxy:        p,
-		mbusClient:   mbusClient,
-		registry:     r,
-		varz:         v,
-		component:    component,
-		serveDone:    make(chan struct{}),
-		tlsServeDone: make(chan struct{}),
-		idleConns:    make(map[net.Conn]struct{}),
-		activeConns:  make(map[net.Conn]struct{}),
-		logger:       steno.NewLogger("router"),
+		config:          cfg,
+		proxy:           p,
+		tcpProxy:        proxy.NewTcpProxy(r),
+		tlsProxy:        proxy.NewTlsPassthroughProxy(r),
+		mbusClient:      mbusClient,
+		registry:        r,
+		updates:         registry.NewBatcher(r, cfg.RegistrationBatch),
+		varz:            v,
+		component:       component,
+		elector:         elector,
+		certs:           certs,
+		serveDone:       make(chan struct{}),
+		tlsServeDone:    make(chan struct{}),
+		drainRequests:   make(chan struct{}, 1),
+		idleConns:       make(map[net.Conn]struct{}),
+		activeConns:     make(map[net.Conn]struct{}),
+		serving:         make(map[string]bool),
+		connectionLimit: limits.New("connections", cfg.Limits.Connections),
+		logger:          steno.NewLogger("router"),
+	}
+
+	component.Routes["/drain"] = http.HandlerFunc(router.ServeDrain)
+
+	if elector != nil && cfg.LeaderElection.ReconcileInterval > 0 {
+		elector.AddTask(router.reconcileRoutes)
+	}
+
+	if cfg.Healthz.Detailed || cfg.Healthz.Strict {
+		router.nats = mbus.NewMonitor(mbusClient.Ping, cfg.Healthz.NatsCheckInterval, clock.New())
+	}
+	if cfg.Healthz.Strict {
+		healthz.AddCheck(router.natsUnreachable)
+	}
+	if cfg.Healthz.Detailed {
+		healthz.SetDetail(router.healthzDetail)
 	}
 
 	if err := router.component.Start(); err != nil {
@@ -102,6 +168,11 @@ func NewRouter(cfg *config.Config, p proxy.Proxy, mbusClient yagnats.NATSConn, r
 
 func (r *Router) Run() <-chan error {
 	r.registry.StartPruningCycle()
+	r.updates.Start()
+
+	if r.nats != nil {
+		r.nats.Start()
+	}
 
 	r.RegisterComponent()
 
@@ -109,18 +180,43 @@ func (r *Router) Run() <-chan error {
 	r.SubscribeRegister()
 	r.HandleGreetings()
 	r.SubscribeUnregister()
+	r.SubscribeTcpRegister()
+	r.SubscribeTcpUnregister()
+	r.SubscribeTlsPassthroughRegister()
+	r.SubscribeTlsPassthroughUnregister()
 
 	// Kickstart sending start messages
 	r.SendStartMessage()
 
+	if r.elector != nil {
+		err := r.elector.Start()
+		if err != nil {
+			r.logger.Errorf("Error starting leader election: %s", err)
+		}
+	}
+
 	r.mbusClient.AddReconnectedCB(func(conn *nats.Conn) {
-		r.logger.Infof("Reconnecting to NATS server %s...", conn.Opts.Url)
+		// a NATS cluster that is connected to late comes without a conn
+		if conn != nil {
+			r.logger.Infof("Reconnecting to NATS server %s...", conn.Opts.Url)
+		}
 		r.SendStartMessage()
 	})
 
 	// Schedule flushing active app's app_id
 	r.ScheduleFlushApps()
 
+	errChan := make(chan error, 1)
+
+	// Load the certificates of the expected domains before listening, such
+	// that the first handshake for each of them finds its certificate ready.
+	err := r.certs.Preload()
+	if err != nil {
+		r.logger.Errorf("Error preloading tls certificates: %s", err)
+		errChan <- err
+		return errChan
+	}
+
 	// Wait for one start message send interval, such that the router's registry
 	// can be populated before serving requests.
 	if r.config.StartResponseDelayInterval != 0 {
@@ -129,13 +225,11 @@ func (r *Router) Run() <-chan error {
 	}
 
 	server := &http.Server{
-		Handler:   dropsonde.InstrumentedHandler(r.proxy),
+		Handler:   r.drainable(dropsonde.InstrumentedHandler(r.proxy)),
 		ConnState: r.HandleConnState,
 	}
 
-	errChan := make(chan error, 1)
-
-	err := r.serveHTTP(server, errChan)
+	err = r.serveHTTP(server, errChan)
 	if err != nil {
 		errChan <- err
 		return errChan
@@ -145,6 +239,20 @@ func (r *Router) Run() <-chan error {
 		errChan <- err
 		return errChan
 	}
+	err = r.serveTCP(errChan)
+	if err != nil {
+		errChan <- err
+		return errChan
+	}
+	err = r.serveTlsPassthrough(errChan)
+	if err != nil {
+		errChan <- err
+		return errChan
+	}
+
+	// the routes had the start response delay to be registered in
+	handoff.Ready()
+	r.notify(fmt.Sprintf("READY=1\nMAINPID=%d", os.Getpid()))
 
 	return errChan
 }
@@ -152,21 +260,28 @@ func (r *Router) Run() <-chan error {
 func (r *Router) serveHTTPS(server *http.Server, errChan chan error) error {
 	if r.config.EnableSSL {
 		tlsConfig := &tls.Config{
-			Certificates: []tls.Certificate{r.config.SSLCertificate},
-			CipherSuites: r.config.CipherSuites,
+			Certificates:   []tls.Certificate{r.config.SSLCertificate},
+			GetCertificate: r.certs.GetCertificate,
+			CipherSuites:   r.config.CipherSuites,
 		}
 
-		tlsListener, err := tls.Listen("tcp", fmt.Sprintf(":%d", r.config.SSLPort), tlsConfig)
+		listener, err := handoff.Listen(fmt.Sprintf(":%d", r.config.SSLPort))
 		if err != nil {
-			r.logger.Fatalf("tls.Listen: %s", err)
+			r.logger.Fatalf("handoff.Listen: %s", err)
 			return err
 		}
 
+		// connections are limited before the TLS handshake, and the server
+		// still sees TLS connections
+		tlsListener := tls.NewListener(ratelimit.LimitListener(listener, r.config.ClientLimits), tlsConfig)
+
 		r.tlsListener = tlsListener
 		r.logger.Infof("Listening on %s", tlsListener.Addr())
 
+		r.setServing("https", true)
 		go func() {
 			err := server.Serve(tlsListener)
+			r.setServing("https", false)
 			errChan <- err
 			close(r.tlsServeDone)
 		}()
@@ -175,24 +290,116 @@ func (r *Router) serveHTTPS(server *http.Server, errChan chan error) error {
 }
 
 func (r *Router) serveHTTP(server *http.Server, errChan chan error) error {
-	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", r.config.Port))
+	listener, err := handoff.Listen(fmt.Sprintf(":%d", r.config.Port))
 	if err != nil {
-		r.logger.Fatalf("net.Listen: %s", err)
+		r.logger.Fatalf("handoff.Listen: %s", err)
 		return err
 	}
 
+	listener = ratelimit.LimitListener(listener, r.config.ClientLimits)
+
+	// only plain connections can be looked at before the server parses
+	// them; the server needs TLS connections as they are
+	listener = parsing.New(r.config.HttpParsing).Listener(listener)
+
 	r.listener = listener
 	r.logger.Infof("Listening on %s", listener.Addr())
 
+	r.setServing("http", true)
 	go func() {
 		err := server.Serve(listener)
+		r.setServing("http", false)
 		errChan <- err
 		close(r.serveDone)
 	}()
 	return nil
 }
 
+func (r *Router) serveTCP(errChan chan error) error {
+	for _, port := range r.config.TcpRouting.Ports {
+		listener, err := handoff.Listen(fmt.Sprintf(":%d", port))
+		if err != nil {
+			r.logger.Fatalf("handoff.Listen: %s", err)
+			return err
+		}
+
+		r.tcpListeners = append(r.tcpListeners, listener)
+		r.logger.Infof("Listening for TCP routes on %s", listener.Addr())
+
+		name := fmt.Sprintf("tcp:%d", port)
+		r.setServing(name, true)
+		go func(port uint16) {
+			err := r.tcpProxy.Serve(listener, port)
+			r.setServing(name, false)
+			// the listener is closed when the router drains or stops
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			select {
+			case errChan <- err:
+			default:
+			}
+		}(port)
+	}
+	return nil
+}
+
+func (r *Router) serveTlsPassthrough(errChan chan error) error {
+	if r.config.TlsPassthrough.Port == 0 {
+		return nil
+	}
+
+	listener, err := handoff.Listen(fmt.Sprintf(":%d", r.config.TlsPassthrough.Port))
+	if err != nil {
+		r.logger.Fatalf("handoff.Listen: %s", err)
+		return err
+	}
+
+	r.passthroughListener = listener
+	r.logger.Infof("Listening for TLS passthrough on %s", listener.Addr())
+
+	r.setServing("tls_passthrough", true)
+	go func() {
+		err := r.tlsProxy.Serve(listener)
+		r.setServing("tls_passthrough", false)
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		select {
+		case errChan <- err:
+		default:
+		}
+	}()
+	return nil
+}
+
+// Drain has the router fail its health checks, waits the drain wait of its
+// config for load balancers to notice, and then stops accepting connections
+// and waits up to drainTimeout for the requests in flight, WebSockets
+// included, to complete.
 func (r *Router) Drain(drainTimeout time.Duration) error {
+	r.component.Healthz.SetDraining()
+	r.notify("STOPPING=1")
+
+	if r.config.DrainWait > 0 {
+		r.logger.Infof("Waiting %s before closing listeners...", r.config.DrainWait)
+		time.Sleep(r.config.DrainWait)
+	}
+
+	return r.drainConnections(drainTimeout)
+}
+
+// HandOff stops accepting connections, which the router that took over its
+// listeners accepts from then on, and waits up to drainTimeout for the
+// requests in flight to complete. Unlike Drain it does not fail the health
+// checks, which the other router answers on the same sockets.
+func (r *Router) HandOff(drainTimeout time.Duration) error {
+	// systemd follows the other router now
+	r.handedOff = true
+	return r.drainConnections(drainTimeout)
+}
+
+func (r *Router) drainConnections(drainTimeout time.Duration) error {
 	r.stopListening()
 
 	drained := make(chan struct{})
@@ -200,13 +407,11 @@ func (r *Router) Drain(drainTimeout time.Duration) error {
 
 	r.logger.Infof("Draining with %d outstanding active connections", len(r.activeConns))
 	r.logger.Infof("Draining with %d outstanding idle connections", len(r.idleConns))
+	r.logger.Infof("Draining with %d outstanding requests", r.inFlight)
 	r.closeIdleConns()
 
-	if len(r.activeConns) == 0 {
-		close(drained)
-	} else {
-		r.drainDone = drained
-	}
+	r.drainDone = drained
+	r.checkDrained()
 	r.connLock.Unlock()
 
 	select {
@@ -218,16 +423,68 @@ func (r *Router) Drain(drainTimeout time.Duration) error {
 	return nil
 }
 
+// reconcileRoutes has the registrars of the fleet send their routes again
+// every reconcile interval, so that the routes of all the routers are
+// brought back in line with them after a message was lost. It runs on the
+// leader only, so that the registrars are asked once for the fleet rather
+// than once by every router.
+func (r *Router) reconcileRoutes(stop <-chan struct{}) {
+	ticker := time.NewTicker(r.config.LeaderElection.ReconcileInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			r.logger.Info("leader.reconcile-routes")
+			r.SendStartMessage()
+		case <-stop:
+			return
+		}
+	}
+}
+
+// HandleStatus serves handler at path on the authenticated status port.
+func (r *Router) HandleStatus(path string, handler http.Handler) {
+	r.component.Handle(path, handler)
+}
+
+// ReloadCertificates serves the certificates of c in place of the per
+// domain certificates the router serves, once they are loaded.
+func (r *Router) ReloadCertificates(c []config.TlsCertificateConfig) error {
+	return r.certs.Reload(c)
+}
+
 func (r *Router) Stop() {
+	if !r.handedOff {
+		r.notify("STOPPING=1")
+	}
+
 	r.stopListening()
 
+	if r.elector != nil {
+		r.elector.Stop()
+	}
+
+	if r.nats != nil {
+		r.nats.Stop()
+	}
+
 	r.connLock.Lock()
 	r.closeIdleConns()
 	r.connLock.Unlock()
 
+	r.updates.Stop()
 	r.component.Stop()
 }
 
+// notify tells systemd of state, when the router runs under it.
+func (r *Router) notify(state string) {
+	err := systemd.Notify(state)
+	if err != nil {
+		r.logger.Warnf("Error notifying systemd: %s", err)
+	}
+}
+
 // connLock must be locked
 func (r *Router) closeIdleConns() {
 	r.closeConnections = true
@@ -240,6 +497,14 @@ func (r *Router) closeIdleConns() {
 func (r *Router) stopListening() {
 	r.listener.Close()
 
+	for _, listener := range r.tcpListeners {
+		listener.Close()
+	}
+
+	if r.passthroughListener != nil {
+		r.passthroughListener.Close()
+	}
+
 	if r.tlsListener != nil {
 		r.tlsListener.Close()
 		<-r.tlsServeDone
@@ -257,7 +522,7 @@ func (r *Router) SubscribeRegister() {
 		r.logger.Debugf("Got router.register: %v", registryMessage)
 
 		for _, uri := range registryMessage.Uris {
-			r.registry.Register(
+			r.updates.Register(
 				uri,
 				registryMessage.makeEndpoint(),
 			)
@@ -270,7 +535,55 @@ func (r *Router) SubscribeUnregister() {
 		r.logger.Debugf("Got router.unregister: %v", registryMessage)
 
 		for _, uri := range registryMessage.Uris {
-			r.registry.Unregister(
+			r.updates.Unregister(
+				uri,
+				registryMessage.makeEndpoint(),
+			)
+		}
+	})
+}
+
+func (r *Router) SubscribeTcpRegister() {
+	r.subscribeRegistry("router.tcp.register", func(registryMessage *registryMessage) {
+		r.logger.Debugf("Got router.tcp.register: %v", registryMessage)
+
+		r.updates.RegisterTcp(
+			registryMessage.RouterPort,
+			registryMessage.makeEndpoint(),
+		)
+	})
+}
+
+func (r *Router) SubscribeTcpUnregister() {
+	r.subscribeRegistry("router.tcp.unregister", func(registryMessage *registryMessage) {
+		r.logger.Debugf("Got router.tcp.unregister: %v", registryMessage)
+
+		r.updates.UnregisterTcp(
+			registryMessage.RouterPort,
+			registryMessage.makeEndpoint(),
+		)
+	})
+}
+
+func (r *Router) SubscribeTlsPassthroughRegister() {
+	r.subscribeRegistry("router.tls_passthrough.register", func(registryMessage *registryMessage) {
+		r.logger.Debugf("Got router.tls_passthrough.register: %v", registryMessage)
+
+		for _, uri := range registryMessage.Uris {
+			r.updates.RegisterTlsPassthrough(
+				uri,
+				registryMessage.makeEndpoint(),
+			)
+		}
+	})
+}
+
+func (r *Router) SubscribeTlsPassthroughUnregister() {
+	r.subscribeRegistry("router.tls_passthrough.unregister", func(registryMessage *registryMessage) {
+		r.logger.Debugf("Got router.tls_passthrough.unregister: %v", registryMessage)
+
+		for _, uri := range registryMessage.Uris {
+			r.updates.UnregisterTlsPassthrough(
 				uri,
 				registryMessage.makeEndpoint(),
 			)
@@ -321,6 +634,10 @@ func (r *Router) HandleConnState(conn net.Conn, state http.ConnState) {
 	r.connLock.Lock()
 
 	switch state {
+	case http.StateNew:
+		if r.connectionLimit.Exceeded(int64(len(r.activeConns) + len(r.idleConns) + 1)) {
+			conn.Close()
+		}
 	case http.StateActive:
 		r.activeConns[conn] = struct{}{}
 		delete(r.idleConns, conn)
@@ -348,10 +665,7 @@ func (r *Router) HandleConnState(conn net.Conn, state http.ConnState) {
 		}
 	}
 
-	if r.drainDone != nil && len(r.activeConns) == 0 {
-		close(r.drainDone)
-		r.drainDone = nil
-	}
+	r.checkDrained()
 
 	r.connLock.Unlock()
 }
@@ -384,8 +698,8 @@ func (r *Router) greetMessage() ([]byte, error) {
 	}
 
 	d := vcap.RouterStart{
-		Id:    r.component.UUID,
-		Hosts: []string{host},
+		Id:                               r.component.UUID,
+		Hosts:                            []string{host},
 		MinimumRegisterIntervalInSeconds: r.config.StartResponseDelayIntervalInSeconds,
 		PruneThresholdInSeconds:          r.config.DropletStaleThresholdInSeconds,
 	}
@@ -395,6 +709,12 @@ func (r *Router) greetMessage() ([]byte, error) {
 
 func (r *Router) subscribeRegistry(subject string, successCallback func(*registryMessage)) {
 	callback := func(message *nats.Msg) {
+		if reporter := r.registry.Reporter(); reporter != nil {
+			defer func(start time.Time) {
+				reporter.CaptureNatsMessage(subject, time.Since(start))
+			}(time.Now())
+		}
+
 		payload := message.Data
 
 		var msg registryMessage
@@ -403,11 +723,26 @@ func (r *Router) subscribeRegistry(subject string, successCallback func(*registr
 		if err != nil {
 			logMessage := fmt.Sprintf("%s: Error unmarshalling JSON (%d; %s): %s", subject, len(payload), payload, err)
 			r.logger.Warnd(map[string]interface{}{"payload": string(payload)}, logMessage)
+			// a message read in part would register a route without the
+			// settings that failed, such as its auth or what it matches
+			return
 		}
 
 		logMessage := fmt.Sprintf("%s: Received message", subject)
 		r.logger.Debugd(map[string]interface{}{"message": msg}, logMessage)
 
+		source := "nats"
+		if msg.Origin != "" {
+			source += "/" + msg.Origin
+		}
+		operation := registry.OperationRegister
+		if strings.HasSuffix(subject, ".unregister") {
+			operation = registry.OperationUnregister
+		}
+		if !r.registry.Registrars().Allow(source, operation) {
+			return
+		}
+
 		successCallback(&msg)
 	}
 
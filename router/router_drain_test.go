@@ -1,6 +1,7 @@
 package router_test
 
 import (
+	"fmt"
 	"io/ioutil"
 	"net/http"
 	"time"
@@ -123,6 +124,42 @@ var _ = Describe("Router", func() {
 			Ω(result).To(BeTrue())
 		})
 
+		It("fails the health checks of load balancers while it waits to stop listening", func() {
+			config.DrainWait = time.Second
+
+			go router.Drain(time.Second)
+
+			Eventually(func() int {
+				req, err := http.NewRequest("GET", fmt.Sprintf("http://%s:%d/", config.Ip, config.Port), nil)
+				Ω(err).ShouldNot(HaveOccurred())
+				req.Header.Set("User-Agent", "HTTP-Monitor/1.1")
+
+				resp, err := http.DefaultClient.Do(req)
+				Ω(err).ShouldNot(HaveOccurred())
+				resp.Body.Close()
+				return resp.StatusCode
+			}).Should(Equal(http.StatusServiceUnavailable))
+
+			resp, err := http.Get(fmt.Sprintf("http://%s:%d/healthz", config.Ip, config.Status.Port))
+			Ω(err).ShouldNot(HaveOccurred())
+			defer resp.Body.Close()
+			Ω(resp.StatusCode).Should(Equal(http.StatusServiceUnavailable))
+			Ω(router.Draining()).Should(BeTrue())
+		})
+
+		It("is asked for on the status port", func() {
+			req, err := http.NewRequest("POST", fmt.Sprintf("http://%s:%d/drain", config.Ip, config.Status.Port), nil)
+			Ω(err).ShouldNot(HaveOccurred())
+			req.SetBasicAuth(config.Status.User, config.Status.Pass)
+
+			resp, err := http.DefaultClient.Do(req)
+			Ω(err).ShouldNot(HaveOccurred())
+			defer resp.Body.Close()
+			Ω(resp.StatusCode).Should(Equal(http.StatusAccepted))
+
+			Eventually(router.DrainRequests()).Should(Receive())
+		})
+
 		It("times out if it takes too long", func() {
 			app := test.NewTestApp([]route.Uri{"draintimeout.vcap.me"}, config.Port, mbusClient, nil)
 
@@ -5,6 +5,7 @@ import (
 	"github.com/cloudfoundry/dropsonde"
 	"github.com/cloudfoundry/dropsonde/emitter/fake"
 	"github.com/cloudfoundry/gorouter/access_log"
+	"github.com/cloudfoundry/gorouter/clock"
 	vcap "github.com/cloudfoundry/gorouter/common"
 	cfg "github.com/cloudfoundry/gorouter/config"
 	"github.com/cloudfoundry/gorouter/proxy"
@@ -62,6 +63,7 @@ var _ = Describe("Router", func() {
 		config.SSLPort = 4443
 		config.SSLCertificate = cert
 		config.CipherSuites = []uint16{tls.TLS_RSA_WITH_AES_256_CBC_SHA}
+		config.TcpRouting.Ports = []uint16{test_util.NextAvailPort()}
 
 		mbusClient = natsRunner.MessageBus
 		registry = rregistry.NewRouteRegistry(config, mbusClient)
@@ -167,6 +169,67 @@ var _ = Describe("Router", func() {
 			app.VerifyAppStatus(404)
 		})
 
+		It("registers and unregisters tcp routes", func() {
+			port := config.TcpRouting.Ports[0]
+			msg := []byte(fmt.Sprintf(`{"app":"app1","host":"1.2.3.4","port":1234,"router_port":%d}`, port))
+
+			mbusClient.Publish("router.tcp.register", msg)
+			Eventually(func() *route.Pool { return registry.LookupTcp(port) }).ShouldNot(BeNil())
+
+			mbusClient.Publish("router.tcp.unregister", msg)
+			Eventually(func() *route.Pool { return registry.LookupTcp(port) }).Should(BeNil())
+		})
+
+		It("registers and unregisters tls passthrough routes", func() {
+			msg := []byte(`{"app":"app1","host":"1.2.3.4","port":1234,"uris":["secure.vcap.me"]}`)
+
+			mbusClient.Publish("router.tls_passthrough.register", msg)
+			Eventually(func() *route.Pool { return registry.LookupTlsPassthrough("secure.vcap.me") }).ShouldNot(BeNil())
+			Ω(registry.Lookup("secure.vcap.me")).Should(BeNil())
+
+			mbusClient.Publish("router.tls_passthrough.unregister", msg)
+			Eventually(func() *route.Pool { return registry.LookupTlsPassthrough("secure.vcap.me") }).Should(BeNil())
+		})
+
+		It("counts the messages of every origin and drops those over its limit", func() {
+			registry.SetRegistrars(rregistry.NewRegistrars(cfg.RegistrationLimitsConfig{
+				Sources: map[string]cfg.RateLimitConfig{
+					"nats/flood": {Enabled: true, RequestsPerSecond: 0.001, Burst: 1},
+				},
+			}, clock.New()))
+
+			mbusClient.Publish("router.register", []byte(`{"app":"app1","host":"1.2.3.4","port":1234,"uris":["a.vcap.me"],"origin":"flood"}`))
+			Eventually(func() *route.Pool { return registry.Lookup("a.vcap.me") }).ShouldNot(BeNil())
+
+			mbusClient.Publish("router.register", []byte(`{"app":"app1","host":"1.2.3.4","port":1234,"uris":["b.vcap.me"],"origin":"flood"}`))
+			Eventually(func() int64 { return registry.Registrars().Stats()["nats/flood"].RateLimited }).Should(Equal(int64(1)))
+			Ω(registry.Registrars().Stats()["nats/flood"].Registrations).To(Equal(int64(1)))
+			Ω(registry.Lookup("b.vcap.me")).To(BeNil())
+
+			mbusClient.Publish("router.unregister", []byte(`{"app":"app1","host":"1.2.3.4","port":1234,"uris":["c.vcap.me"]}`))
+			Eventually(func() int64 { return registry.Registrars().Stats()["nats"].Unregistrations }).Should(Equal(int64(1)))
+		})
+
+		It("does not register messages that fail to unmarshal", func() {
+			for field, value := range map[string]string{
+				"tags":                          `"rails"`,
+				"requires_signed_urls":          `"true"`,
+				"requires_authorization_header": `1`,
+				"auth":                          `true`,
+				"match":                         `["/api"]`,
+				"allowed_cidrs":                 `["nowhere"]`,
+			} {
+				uri := strings.Replace(field, "_", "-", -1) + ".vcap.me"
+				mbusClient.Publish("router.register", []byte(`{"app":"app1","host":"1.2.3.4","port":1234,"uris":["`+uri+`"],"`+field+`":`+value+`}`))
+			}
+			mbusClient.Publish("router.register", []byte(`{"app":"app1","host":"1.2.3.4","port":1234,"uris":["valid.vcap.me"],"requires_signed_urls":true}`))
+			Eventually(func() *route.Pool { return registry.Lookup("valid.vcap.me") }).ShouldNot(BeNil())
+
+			for _, uri := range []string{"tags", "requires-signed-urls", "requires-authorization-header", "auth", "match", "allowed-cidrs"} {
+				Ω(registry.Lookup(route.Uri(uri + ".vcap.me"))).To(BeNil())
+			}
+		})
+
 		It("sends start on a nats connect", func() {
 			started := make(chan bool)
 			cb := make(chan bool)
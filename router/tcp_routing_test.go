@@ -0,0 +1,77 @@
+package router_test
+
+import (
+	"fmt"
+
+	"github.com/cloudfoundry/gorouter/access_log"
+	vcap "github.com/cloudfoundry/gorouter/common"
+	cfg "github.com/cloudfoundry/gorouter/config"
+	"github.com/cloudfoundry/gorouter/proxy"
+	rregistry "github.com/cloudfoundry/gorouter/registry"
+	"github.com/cloudfoundry/gorouter/route"
+	. "github.com/cloudfoundry/gorouter/router"
+	"github.com/cloudfoundry/gorouter/test_util"
+	vvarz "github.com/cloudfoundry/gorouter/varz"
+	"github.com/cloudfoundry/yagnats/fakeyagnats"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("TCP routing", func() {
+	var (
+		config     *cfg.Config
+		mbusClient *fakeyagnats.FakeNATSConn
+		registry   *rregistry.RouteRegistry
+		router     *Router
+		errChan    <-chan error
+		port       uint16
+	)
+
+	BeforeEach(func() {
+		port = test_util.NextAvailPort()
+
+		config = test_util.SpecConfig(test_util.NextAvailPort(), test_util.NextAvailPort(), test_util.NextAvailPort())
+		config.TcpRouting.Ports = []uint16{port}
+
+		mbusClient = fakeyagnats.Connect()
+		registry = rregistry.NewRouteRegistry(config, mbusClient)
+
+		varz := vvarz.NewVarz(registry)
+		p := proxy.NewProxy(proxy.ProxyArgs{
+			Ip:           config.Ip,
+			Registry:     registry,
+			Reporter:     varz,
+			AccessLogger: &access_log.NullAccessLogger{},
+		})
+
+		var err error
+		router, err = NewRouter(config, p, mbusClient, registry, varz, vcap.NewLogCounter())
+		Ω(err).ShouldNot(HaveOccurred())
+		errChan = router.Run()
+	})
+
+	It("registers routes on the ports it listens on", func() {
+		mbusClient.Publish("router.tcp.register", []byte(fmt.Sprintf(`{"host":"1.2.3.4","port":1234,"router_port":%d}`, port)))
+		Eventually(func() *route.Pool { return registry.LookupTcp(port) }).ShouldNot(BeNil())
+
+		router.Stop()
+	})
+
+	It("does not register routes on other ports", func() {
+		mbusClient.Publish("router.tcp.register", []byte(`{"host":"1.2.3.4","port":1234}`))
+		mbusClient.Publish("router.tcp.register", []byte(fmt.Sprintf(`{"host":"1.2.3.4","port":1234,"router_port":%d}`, port+1)))
+		Consistently(registry.NumTcpPorts).Should(Equal(0))
+
+		router.Stop()
+	})
+
+	It("does not report its listeners closing as errors when it stops", func() {
+		router.Stop()
+
+		// the HTTP server reports its listener closing, and nothing else
+		var err error
+		Eventually(errChan).Should(Receive(&err))
+		Ω(err.Error()).ShouldNot(ContainSubstring(fmt.Sprintf(":%d", port)))
+		Consistently(errChan).ShouldNot(Receive())
+	})
+})
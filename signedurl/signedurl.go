@@ -0,0 +1,116 @@
+package signedurl
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cloudfoundry/gorouter/clock"
+	"github.com/cloudfoundry/gorouter/config"
+)
+
+const (
+	ExpiresParam   = "expires"
+	SignatureParam = "signature"
+)
+
+var (
+	ErrUnsigned = errors.New("URL is not signed")
+	ErrExpired  = errors.New("signed URL has expired")
+	ErrInvalid  = errors.New("signature of URL is invalid")
+	ErrNoKey    = errors.New("no key to verify signed URLs with")
+)
+
+// A Verifier checks the signatures of URLs. A URL is signed by appending
+// the Unix time it expires at as the expires query parameter, and then the
+// signature as the last query parameter. The signature is the unpadded
+// base64url HMAC-SHA256 of the host without its port, followed by the path
+// and query of the URL up to the signature. A nil verifier has no key, and
+// accepts no URL.
+type Verifier struct {
+	key   []byte
+	clock clock.Clock
+}
+
+// NewVerifier returns the verifier configured by c, which expires URLs by
+// the time of clk, or nil when no key is configured.
+func NewVerifier(c config.SignedUrlsConfig, clk clock.Clock) *Verifier {
+	if c.Key == "" {
+		return nil
+	}
+
+	return &Verifier{key: []byte(c.Key), clock: clk}
+}
+
+// Verify returns nil when the URL of request carries a valid signature that
+// has not expired.
+func (v *Verifier) Verify(request *http.Request) error {
+	if v == nil {
+		return ErrNoKey
+	}
+
+	uri := request.URL.RequestURI()
+	i := strings.LastIndex(uri, SignatureParam+"=")
+	if i < 1 || (uri[i-1] != '?' && uri[i-1] != '&') || strings.Contains(uri[i:], "&") {
+		return ErrUnsigned
+	}
+
+	signed, signature := uri[:i-1], uri[i+len(SignatureParam)+1:]
+
+	// the expiry is the last parameter of what was signed, after any
+	// expires parameters the URL has of its own
+	j := strings.LastIndex(signed, ExpiresParam+"=")
+	if j < 1 || (signed[j-1] != '?' && signed[j-1] != '&') || strings.Contains(signed[j:], "&") {
+		return ErrUnsigned
+	}
+	expires, err := strconv.ParseInt(signed[j+len(ExpiresParam)+1:], 10, 64)
+	if err != nil {
+		return ErrUnsigned
+	}
+
+	expected := v.sign(hostname(request.Host), signed)
+	if !hmac.Equal([]byte(signature), []byte(expected)) {
+		return ErrInvalid
+	}
+
+	// the expiry is part of what was signed, so it is only trusted now
+	if v.clock.Now().Unix() > expires {
+		return ErrExpired
+	}
+
+	return nil
+}
+
+func (v *Verifier) sign(host, uri string) string {
+	mac := hmac.New(sha256.New, v.key)
+	mac.Write([]byte(host))
+	mac.Write([]byte(uri))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Sign signs uri, a path with an optional query, for host with key, to
+// expire at expires. It returns uri with the expires and signature
+// parameters added.
+func Sign(key, host, uri string, expires time.Time) string {
+	sep := "?"
+	if strings.Contains(uri, "?") {
+		sep = "&"
+	}
+	uri += sep + ExpiresParam + "=" + strconv.FormatInt(expires.Unix(), 10)
+
+	v := &Verifier{key: []byte(key)}
+	return uri + "&" + SignatureParam + "=" + v.sign(hostname(host), uri)
+}
+
+func hostname(h string) string {
+	if name, _, err := net.SplitHostPort(h); err == nil {
+		h = name
+	}
+	return strings.ToLower(h)
+}
@@ -0,0 +1,57 @@
+package signedurl_test
+
+import (
+	"bufio"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cloudfoundry/gorouter/clock"
+	"github.com/cloudfoundry/gorouter/config"
+	. "github.com/cloudfoundry/gorouter/signedurl"
+)
+
+// FuzzVerify verifies the URLs of requests as the router reads them off the
+// wire. No URL a client makes up may pass, and every URL signed for a route
+// must, however unusual its path and query.
+func FuzzVerify(f *testing.F) {
+	const key = "0123456789abcdef"
+
+	f.Add("example.com", "/path?a=1")
+	f.Add("Example.COM:8080", "/%7Euser/a%20b?q=%26&x")
+	f.Add("example.com", "/?signature=abc&expires=99999999999")
+	f.Add("[::1]:80", "//double//slashes/../dots")
+	f.Add("example.com", "*")
+
+	verifier := NewVerifier(config.SignedUrlsConfig{Key: key}, clock.New())
+
+	read := func(host, uri string) (*http.Request, bool) {
+		req, err := http.ReadRequest(bufio.NewReader(strings.NewReader("GET " + uri + " HTTP/1.1\r\nHost: " + host + "\r\n\r\n")))
+		return req, err == nil
+	}
+
+	f.Fuzz(func(t *testing.T, host, uri string) {
+		req, ok := read(host, uri)
+		if !ok {
+			return
+		}
+		if verifier.Verify(req) == nil {
+			t.Fatalf("%q is accepted without being signed", uri)
+		}
+
+		// only paths are signed, not the * of OPTIONS requests
+		if !strings.HasPrefix(req.URL.RequestURI(), "/") {
+			return
+		}
+
+		signed := Sign(key, req.Host, req.URL.RequestURI(), time.Now().Add(time.Hour))
+		req, ok = read(req.Host, signed)
+		if !ok {
+			t.Fatalf("signed %q cannot be requested", signed)
+		}
+		if err := verifier.Verify(req); err != nil {
+			t.Fatalf("signed %q is refused: %s", signed, err)
+		}
+	})
+}
@@ -0,0 +1,13 @@
+package signedurl_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestSignedurl(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Signedurl Suite")
+}
@@ -0,0 +1,104 @@
+package signedurl_test
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/cloudfoundry/gorouter/clock/fakeclock"
+	"github.com/cloudfoundry/gorouter/config"
+	. "github.com/cloudfoundry/gorouter/signedurl"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Verifier", func() {
+	const key = "0123456789abcdef"
+
+	var verifier *Verifier
+	var clk *fakeclock.FakeClock
+
+	BeforeEach(func() {
+		clk = fakeclock.New(time.Now())
+		verifier = NewVerifier(config.SignedUrlsConfig{Key: key}, clk)
+	})
+
+	request := func(host, uri string) *http.Request {
+		req, err := http.NewRequest("GET", "http://"+host+uri, nil)
+		Ω(err).NotTo(HaveOccurred())
+		return req
+	}
+
+	It("accepts a signed URL", func() {
+		uri := Sign(key, "files.example.com", "/downloads/report.pdf?v=2", clk.Now().Add(time.Minute))
+		Ω(uri).To(HavePrefix("/downloads/report.pdf?v=2&expires="))
+
+		Ω(verifier.Verify(request("files.example.com", uri))).To(Succeed())
+	})
+
+	It("ignores the port and case of the host", func() {
+		uri := Sign(key, "files.example.com", "/report.pdf", clk.Now().Add(time.Minute))
+
+		Ω(verifier.Verify(request("Files.Example.com:8080", uri))).To(Succeed())
+	})
+
+	It("rejects an expired URL", func() {
+		uri := Sign(key, "files.example.com", "/report.pdf", clk.Now().Add(-time.Minute))
+
+		Ω(verifier.Verify(request("files.example.com", uri))).To(Equal(ErrExpired))
+	})
+
+	It("expires URLs by its clock", func() {
+		uri := Sign(key, "files.example.com", "/report.pdf", clk.Now().Add(time.Minute))
+		Ω(verifier.Verify(request("files.example.com", uri))).To(Succeed())
+
+		clk.Increment(2 * time.Minute)
+		Ω(verifier.Verify(request("files.example.com", uri))).To(Equal(ErrExpired))
+	})
+
+	It("goes by its own expiry when the URL has an expires parameter of its own", func() {
+		uri := Sign(key, "files.example.com", "/report.pdf?expires=99999999999", clk.Now().Add(-time.Minute))
+
+		Ω(verifier.Verify(request("files.example.com", uri))).To(Equal(ErrExpired))
+	})
+
+	It("rejects a URL whose expiry was changed", func() {
+		expires := clk.Now().Add(-time.Minute)
+		uri := Sign(key, "files.example.com", "/report.pdf", expires)
+
+		later := strings.Replace(uri, "expires=", "expires=9", 1)
+		Ω(verifier.Verify(request("files.example.com", later))).To(Equal(ErrInvalid))
+	})
+
+	It("rejects a URL signed for another path or host", func() {
+		uri := Sign(key, "files.example.com", "/report.pdf", clk.Now().Add(time.Minute))
+
+		Ω(verifier.Verify(request("files.example.com", strings.Replace(uri, "report", "secret", 1)))).To(Equal(ErrInvalid))
+		Ω(verifier.Verify(request("other.example.com", uri))).To(Equal(ErrInvalid))
+	})
+
+	It("rejects a URL signed with another key", func() {
+		uri := Sign("fedcba9876543210", "files.example.com", "/report.pdf", clk.Now().Add(time.Minute))
+
+		Ω(verifier.Verify(request("files.example.com", uri))).To(Equal(ErrInvalid))
+	})
+
+	It("rejects a URL with parameters after the signature", func() {
+		uri := Sign(key, "files.example.com", "/report.pdf", clk.Now().Add(time.Minute))
+
+		Ω(verifier.Verify(request("files.example.com", uri+"&admin=true"))).To(Equal(ErrUnsigned))
+	})
+
+	It("rejects an unsigned URL", func() {
+		Ω(verifier.Verify(request("files.example.com", "/report.pdf"))).To(Equal(ErrUnsigned))
+		Ω(verifier.Verify(request("files.example.com", "/report.pdf?xsignature=abc"))).To(Equal(ErrUnsigned))
+	})
+
+	It("rejects every URL without a key", func() {
+		verifier = NewVerifier(config.SignedUrlsConfig{}, clk)
+		uri := Sign(key, "files.example.com", "/report.pdf", clk.Now().Add(time.Minute))
+
+		Ω(verifier.Verify(request("files.example.com", uri))).To(Equal(ErrNoKey))
+	})
+})
@@ -0,0 +1,54 @@
+package simulation
+
+import (
+	"math"
+	"math/rand"
+	"time"
+)
+
+// A Distribution is how long the requests to a backend take.
+type Distribution interface {
+	Sample(random *rand.Rand) time.Duration
+}
+
+// Constant requests all take the same time.
+type Constant time.Duration
+
+func (c Constant) Sample(*rand.Rand) time.Duration {
+	return time.Duration(c)
+}
+
+// Exponential requests take Mean on average, most of them less.
+type Exponential struct {
+	Mean time.Duration
+}
+
+func (e Exponential) Sample(random *rand.Rand) time.Duration {
+	return time.Duration(random.ExpFloat64() * float64(e.Mean))
+}
+
+// LogNormal requests take Median or less half of the time, with a tail
+// that grows longer with Sigma; at a Sigma of 1 one request in a hundred
+// takes ten times the median.
+type LogNormal struct {
+	Median time.Duration
+	Sigma  float64
+}
+
+func (l LogNormal) Sample(random *rand.Rand) time.Duration {
+	return time.Duration(float64(l.Median) * math.Exp(l.Sigma*random.NormFloat64()))
+}
+
+// Bimodal requests take Slow with probability SlowRate, and Fast otherwise,
+// like a backend that now and then stalls on garbage collection or a lock.
+type Bimodal struct {
+	Fast, Slow Distribution
+	SlowRate   float64
+}
+
+func (b Bimodal) Sample(random *rand.Rand) time.Duration {
+	if random.Float64() < b.SlowRate {
+		return b.Slow.Sample(random)
+	}
+	return b.Fast.Sample(random)
+}
@@ -0,0 +1,105 @@
+package simulation
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"time"
+)
+
+// Result is how the requests of a run fared. Latencies are those the
+// clients saw, from arriving at the router until the response or the
+// timeout, of the requests that reached a backend. Shares are the fractions
+// of those requests each backend got, and Fairness is Jain's index of them:
+// 1 when every backend got the same share, down to 1/n when one backend got
+// them all.
+type Result struct {
+	Scenario string
+	Strategy string
+
+	Requests int
+	Rejected int
+	Failed   int
+	TimedOut int
+
+	P50  time.Duration
+	P99  time.Duration
+	P999 time.Duration
+	Max  time.Duration
+
+	Shares   []float64
+	Fairness float64
+}
+
+func (s *simulation) result(strategy Strategy) Result {
+	r := Result{
+		Scenario: s.scenario.Name,
+		Strategy: strategy.Name,
+		Requests: s.scenario.Requests,
+		Rejected: s.rejected,
+		Failed:   s.failed,
+		TimedOut: s.timedOut,
+	}
+
+	sort.Sort(durations(s.latencies))
+	r.P50 = percentile(s.latencies, 0.5)
+	r.P99 = percentile(s.latencies, 0.99)
+	r.P999 = percentile(s.latencies, 0.999)
+	r.Max = percentile(s.latencies, 1)
+
+	total := 0
+	for _, n := range s.served {
+		total += n
+	}
+	var sum, squares float64
+	for _, n := range s.served {
+		share := 0.0
+		if total > 0 {
+			share = float64(n) / float64(total)
+		}
+		r.Shares = append(r.Shares, share)
+		sum += share
+		squares += share * share
+	}
+	if squares > 0 {
+		r.Fairness = sum * sum / (float64(len(s.served)) * squares)
+	}
+
+	return r
+}
+
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := int(p*float64(len(sorted))+0.5) - 1
+	if i < 0 {
+		i = 0
+	}
+	if i >= len(sorted) {
+		i = len(sorted) - 1
+	}
+	return sorted[i]
+}
+
+type durations []time.Duration
+
+func (d durations) Len() int           { return len(d) }
+func (d durations) Less(i, j int) bool { return d[i] < d[j] }
+func (d durations) Swap(i, j int)      { d[i], d[j] = d[j], d[i] }
+
+// WriteTable writes results as a table, one row per run.
+func WriteTable(w io.Writer, results []Result) {
+	fmt.Fprintf(w, "%-16s %-20s %9s %9s %9s %9s %8s %8s %8s %8s\n",
+		"scenario", "strategy", "p50", "p99", "p99.9", "max", "rejected", "failed", "timeouts", "fairness")
+	for _, r := range results {
+		fmt.Fprintf(w, "%-16s %-20s %9s %9s %9s %9s %8d %8d %8d %8.3f\n",
+			r.Scenario, r.Strategy,
+			round(r.P50), round(r.P99), round(r.P999), round(r.Max),
+			r.Rejected, r.Failed, r.TimedOut, r.Fairness)
+	}
+}
+
+func round(d time.Duration) time.Duration {
+	return d - d%(100*time.Microsecond)
+}
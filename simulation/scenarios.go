@@ -0,0 +1,119 @@
+package simulation
+
+import (
+	"time"
+
+	"github.com/cloudfoundry/gorouter/config"
+	"github.com/cloudfoundry/gorouter/limits"
+	"github.com/cloudfoundry/gorouter/route"
+)
+
+// Strategies are the ways the router can have its pools balance requests.
+// Pools go round robin, passing over endpoints at the in-flight limit when
+// endpoint_in_flight is limited, and over endpoints ejected by the circuit
+// breaker when it is enabled.
+var Strategies = []Strategy{
+	{
+		Name:      "round-robin",
+		Configure: func(*route.Pool) {},
+	},
+	{
+		Name: "in-flight-limit",
+		Configure: func(pool *route.Pool) {
+			pool.SetInFlightLimit(inFlightLimit())
+		},
+	},
+	{
+		Name: "circuit-breaker",
+		Configure: func(pool *route.Pool) {
+			pool.SetHealthPolicy(healthPolicy())
+		},
+	},
+	{
+		Name: "limit-and-breaker",
+		Configure: func(pool *route.Pool) {
+			pool.SetInFlightLimit(inFlightLimit())
+			pool.SetHealthPolicy(healthPolicy())
+		},
+	},
+}
+
+func inFlightLimit() *limits.Limit {
+	return limits.New("simulation_endpoint_in_flight", config.LimitConfig{Max: 16})
+}
+
+// healthPolicy is the policy of the default circuit breaker config.
+func healthPolicy() *route.HealthPolicy {
+	return &route.HealthPolicy{
+		ConsecutiveFailures: 5,
+		FailureRate:         0.5,
+		RequestVolume:       20,
+		BaseEjection:        10 * time.Second,
+		MaxEjection:         300 * time.Second,
+	}
+}
+
+// Scenarios are backends of four kinds of skew, each at two thirds of the
+// capacity of its backends. Four backends of 8 workers at 20ms serve 1600
+// requests per second.
+var Scenarios = []Scenario{
+	{
+		Name:     "uniform",
+		Backends: backends(4, Backend{Workers: 8, Latency: Exponential{Mean: 20 * time.Millisecond}}),
+		Rate:     1000,
+		Requests: 20000,
+		Timeout:  time.Second,
+	},
+	{
+		Name: "one-slow",
+		Backends: append(
+			backends(3, Backend{Workers: 8, Latency: Exponential{Mean: 20 * time.Millisecond}}),
+			Backend{Workers: 8, Latency: Exponential{Mean: 200 * time.Millisecond}},
+		),
+		Rate:     800,
+		Requests: 20000,
+		Timeout:  time.Second,
+	},
+	{
+		Name: "heavy-tail",
+		Backends: append(
+			backends(3, Backend{Workers: 8, Latency: LogNormal{Median: 15 * time.Millisecond, Sigma: 0.5}}),
+			Backend{Workers: 8, Latency: LogNormal{Median: 15 * time.Millisecond, Sigma: 1.5}},
+		),
+		Rate:     1000,
+		Requests: 20000,
+		Timeout:  time.Second,
+	},
+	{
+		Name: "stalling",
+		Backends: append(
+			backends(3, Backend{Workers: 8, Latency: Exponential{Mean: 20 * time.Millisecond}}),
+			Backend{Workers: 8, Latency: Bimodal{
+				Fast:     Exponential{Mean: 10 * time.Millisecond},
+				Slow:     Constant(2 * time.Second),
+				SlowRate: 0.05,
+			}},
+		),
+		Rate:     1000,
+		Requests: 20000,
+		Timeout:  time.Second,
+	},
+	{
+		Name: "one-failing",
+		Backends: append(
+			backends(3, Backend{Workers: 8, Latency: Exponential{Mean: 20 * time.Millisecond}}),
+			Backend{Workers: 8, Latency: Exponential{Mean: 2 * time.Millisecond}, FailureRate: 0.8},
+		),
+		Rate:     1000,
+		Requests: 20000,
+		Timeout:  time.Second,
+	},
+}
+
+func backends(n int, b Backend) []Backend {
+	bs := make([]Backend, n)
+	for i := range bs {
+		bs[i] = b
+	}
+	return bs
+}
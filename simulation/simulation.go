@@ -0,0 +1,230 @@
+// Package simulation drives route pools with simulated traffic to backends
+// of skewed latency, so that changes to how pools balance requests can be
+// judged by the tail latency and the balance they lead to. Time is
+// simulated, so a run of many thousands of requests takes milliseconds and
+// the same seed always gives the same result.
+package simulation
+
+import (
+	"container/heap"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/cloudfoundry/gorouter/clock"
+	"github.com/cloudfoundry/gorouter/clock/fakeclock"
+	"github.com/cloudfoundry/gorouter/route"
+)
+
+// A Backend serves up to Workers requests at a time, and queues the rest.
+// Its requests take Latency, and FailureRate of them fail with a 5xx.
+type Backend struct {
+	Workers     int
+	Latency     Distribution
+	FailureRate float64
+}
+
+// A Scenario is a set of backends and the load sent to them: Requests
+// requests arriving at Rate per second, at random like independent
+// clients. Requests that take longer than Timeout time out, as they do in
+// the router.
+type Scenario struct {
+	Name     string
+	Backends []Backend
+	Rate     float64
+	Requests int
+	Timeout  time.Duration
+}
+
+// A Strategy configures the pool that balances requests, as the router
+// would configure it.
+type Strategy struct {
+	Name      string
+	Configure func(pool *route.Pool)
+}
+
+// retryAfterFailure is how long pools pass over an endpoint that failed,
+// as in a registry with the default droplet stale threshold.
+const retryAfterFailure = 30 * time.Second
+
+// Run sends the requests of scenario to a pool configured by strategy, and
+// reports how they fared.
+func Run(scenario Scenario, strategy Strategy, seed int64) Result {
+	fake := fakeclock.New(time.Unix(0, 0))
+
+	pool := route.NewPool(retryAfterFailure)
+	pool.SetClock(fake)
+	pool.SetRandom(clock.NewSeededRandom(seed))
+	strategy.Configure(pool)
+
+	s := &simulation{
+		scenario: scenario,
+		pool:     pool,
+		clock:    fake,
+		random:   rand.New(rand.NewSource(seed)),
+		backends: make(map[*route.Endpoint]*backend),
+	}
+	for i, b := range scenario.Backends {
+		endpoint := route.NewEndpoint("", fmt.Sprintf("10.0.0.%d", i+1), 8080, "", nil, -1)
+		pool.Put(endpoint)
+		s.backends[endpoint] = &backend{Backend: b, index: i}
+	}
+
+	s.run()
+	return s.result(strategy)
+}
+
+type simulation struct {
+	scenario Scenario
+	pool     *route.Pool
+	clock    *fakeclock.FakeClock
+	random   *rand.Rand
+	backends map[*route.Endpoint]*backend
+
+	now       time.Duration
+	events    events
+	latencies []time.Duration
+	served    []int
+	rejected  int
+	failed    int
+	timedOut  int
+}
+
+type backend struct {
+	Backend
+	index int
+	busy  int
+	queue []*request
+}
+
+type request struct {
+	arrived  time.Duration
+	iter     route.EndpointIterator
+	backend  *backend
+	fails    bool
+	finished bool
+}
+
+const (
+	arrival = iota
+	response
+	timeout
+	freed
+)
+
+type event struct {
+	at      time.Duration
+	kind    int
+	request *request
+	backend *backend
+}
+
+type events []*event
+
+func (e events) Len() int            { return len(e) }
+func (e events) Less(i, j int) bool  { return e[i].at < e[j].at }
+func (e events) Swap(i, j int)       { e[i], e[j] = e[j], e[i] }
+func (e *events) Push(x interface{}) { *e = append(*e, x.(*event)) }
+func (e *events) Pop() interface{} {
+	old := *e
+	x := old[len(old)-1]
+	*e = old[:len(old)-1]
+	return x
+}
+
+func (s *simulation) schedule(at time.Duration, kind int, r *request, b *backend) {
+	heap.Push(&s.events, &event{at: at, kind: kind, request: r, backend: b})
+}
+
+func (s *simulation) run() {
+	s.served = make([]int, len(s.scenario.Backends))
+
+	arrived := 0
+	s.schedule(0, arrival, nil, nil)
+
+	for s.events.Len() > 0 {
+		e := heap.Pop(&s.events).(*event)
+		s.clock.Increment(e.at - s.now)
+		s.now = e.at
+
+		switch e.kind {
+		case arrival:
+			s.arrive()
+			arrived++
+			if arrived < s.scenario.Requests {
+				gap := time.Duration(s.random.ExpFloat64() / s.scenario.Rate * float64(time.Second))
+				s.schedule(s.now+gap, arrival, nil, nil)
+			}
+		case response:
+			s.finish(e.request, false)
+		case timeout:
+			s.finish(e.request, true)
+		case freed:
+			e.backend.busy--
+			if len(e.backend.queue) > 0 {
+				r := e.backend.queue[0]
+				e.backend.queue = e.backend.queue[1:]
+				s.serve(r)
+			}
+		}
+	}
+}
+
+func (s *simulation) arrive() {
+	r := &request{arrived: s.now, iter: s.pool.Endpoints("")}
+
+	endpoint := r.iter.Next()
+	if endpoint == nil {
+		// the router answers 503 when every endpoint is at its limit
+		s.rejected++
+		r.iter.Done()
+		return
+	}
+
+	b := s.backends[endpoint]
+	r.backend = b
+	r.fails = s.random.Float64() < b.FailureRate
+	s.served[b.index]++
+
+	if s.scenario.Timeout > 0 {
+		s.schedule(s.now+s.scenario.Timeout, timeout, r, nil)
+	}
+
+	if b.busy < b.Workers {
+		s.serve(r)
+	} else {
+		b.queue = append(b.queue, r)
+	}
+}
+
+// serve starts work on a request. A backend keeps working on a request the
+// client has given up on, as real backends do.
+func (s *simulation) serve(r *request) {
+	b := r.backend
+	b.busy++
+
+	done := s.now + b.Latency.Sample(s.random)
+	s.schedule(done, freed, nil, b)
+	s.schedule(done, response, r, nil)
+}
+
+func (s *simulation) finish(r *request, timedOut bool) {
+	if r.finished {
+		return
+	}
+	r.finished = true
+
+	switch {
+	case timedOut:
+		s.timedOut++
+		r.iter.EndpointResponded(false)
+	case r.fails:
+		s.failed++
+		r.iter.EndpointResponded(false)
+	default:
+		r.iter.EndpointResponded(true)
+	}
+	r.iter.Done()
+
+	s.latencies = append(s.latencies, s.now-r.arrived)
+}
@@ -0,0 +1,32 @@
+package simulation_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cloudfoundry/gorouter/simulation"
+)
+
+// BenchmarkStrategies runs every scenario with every strategy, and reports
+// the tail latency, the balance and the share of requests that went wrong
+// alongside the time a run takes:
+//
+//	go test -run='^$' -bench=Strategies ./simulation
+func BenchmarkStrategies(b *testing.B) {
+	for _, scenario := range simulation.Scenarios {
+		for _, strategy := range simulation.Strategies {
+			scenario, strategy := scenario, strategy
+			b.Run(scenario.Name+"/"+strategy.Name, func(b *testing.B) {
+				var r simulation.Result
+				for i := 0; i < b.N; i++ {
+					r = simulation.Run(scenario, strategy, int64(i))
+				}
+
+				b.ReportMetric(float64(r.P99)/float64(time.Millisecond), "p99-ms")
+				b.ReportMetric(float64(r.P999)/float64(time.Millisecond), "p99.9-ms")
+				b.ReportMetric(r.Fairness, "fairness")
+				b.ReportMetric(100*float64(r.Rejected+r.Failed+r.TimedOut)/float64(r.Requests), "errors-%")
+			})
+		}
+	}
+}
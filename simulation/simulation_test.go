@@ -0,0 +1,102 @@
+package simulation_test
+
+import (
+	"bytes"
+	"math/rand"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cloudfoundry/gorouter/simulation"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Simulation", func() {
+	scenario := func(name string) simulation.Scenario {
+		for _, s := range simulation.Scenarios {
+			if s.Name == name {
+				return s
+			}
+		}
+		Fail("no scenario " + name)
+		return simulation.Scenario{}
+	}
+
+	strategy := func(name string) simulation.Strategy {
+		for _, s := range simulation.Strategies {
+			if s.Name == name {
+				return s
+			}
+		}
+		Fail("no strategy " + name)
+		return simulation.Strategy{}
+	}
+
+	It("gives the same result for the same seed", func() {
+		a := simulation.Run(scenario("one-slow"), strategy("round-robin"), 7)
+		b := simulation.Run(scenario("one-slow"), strategy("round-robin"), 7)
+		Ω(a).To(Equal(b))
+	})
+
+	It("reports every request", func() {
+		r := simulation.Run(scenario("uniform"), strategy("round-robin"), 1)
+		Ω(r.Requests).To(Equal(20000))
+		Ω(r.Shares).To(HaveLen(4))
+		Ω(r.P50).To(BeNumerically("<", r.P99))
+		Ω(r.P99).To(BeNumerically("<=", r.P999))
+		Ω(r.P999).To(BeNumerically("<=", r.Max))
+	})
+
+	It("balances backends of the same latency evenly", func() {
+		r := simulation.Run(scenario("uniform"), strategy("round-robin"), 1)
+		Ω(r.Fairness).To(BeNumerically(">", 0.999))
+		Ω(r.TimedOut).To(BeZero())
+	})
+
+	It("shows the in-flight limit keeping a slow backend from holding up requests", func() {
+		roundRobin := simulation.Run(scenario("one-slow"), strategy("round-robin"), 1)
+		limited := simulation.Run(scenario("one-slow"), strategy("in-flight-limit"), 1)
+
+		Ω(limited.P99).To(BeNumerically("<", roundRobin.P99))
+		Ω(limited.TimedOut).To(BeNumerically("<", roundRobin.TimedOut))
+		Ω(limited.Fairness).To(BeNumerically("<", roundRobin.Fairness))
+	})
+
+	It("shows the circuit breaker taking a failing backend out of the pool", func() {
+		roundRobin := simulation.Run(scenario("one-failing"), strategy("round-robin"), 1)
+		breaker := simulation.Run(scenario("one-failing"), strategy("circuit-breaker"), 1)
+
+		Ω(breaker.Failed).To(BeNumerically("<", roundRobin.Failed/10))
+		Ω(breaker.Shares[3]).To(BeNumerically("<", 0.05))
+	})
+
+	It("writes results as a table", func() {
+		var buf bytes.Buffer
+		simulation.WriteTable(&buf, []simulation.Result{
+			simulation.Run(scenario("uniform"), strategy("round-robin"), 1),
+			simulation.Run(scenario("uniform"), strategy("in-flight-limit"), 1),
+		})
+
+		lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+		Ω(lines).To(HaveLen(3))
+		Ω(lines[0]).To(HavePrefix("scenario"))
+		Ω(lines[2]).To(HavePrefix("uniform          in-flight-limit"))
+	})
+
+	Describe("LogNormal", func() {
+		It("takes the median or less half of the time", func() {
+			random := rand.New(rand.NewSource(1))
+			d := simulation.LogNormal{Median: 10 * time.Millisecond, Sigma: 1}
+
+			samples := make([]float64, 10001)
+			for i := range samples {
+				samples[i] = float64(d.Sample(random))
+			}
+			sort.Float64s(samples)
+
+			Ω(samples[5000]).To(BeNumerically("~", float64(10*time.Millisecond), float64(time.Millisecond)))
+		})
+	})
+})
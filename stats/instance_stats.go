@@ -0,0 +1,135 @@
+package stats
+
+import (
+	"container/list"
+	"sync"
+)
+
+const MaxTrackedInstances = 1000
+
+type InstanceCounts struct {
+	Requests  int64   `json:"requests"`
+	Responses int64   `json:"responses"`
+	Errors    int64   `json:"errors"`
+	ErrorRate float64 `json:"error_rate"`
+
+	// Tags are those of the endpoint of the instance that was last
+	// requested.
+	Tags map[string]string `json:"-"`
+}
+
+type instanceKey struct {
+	applicationId string
+	index         string
+}
+
+type instanceEntry struct {
+	key instanceKey
+	InstanceCounts
+}
+
+// InstanceStats counts requests and errors per application instance index.
+// At most max instances are tracked; when a new instance is seen beyond
+// that, the one that has gone longest without traffic is forgotten, so a
+// large or churning fleet cannot grow it without bound.
+type InstanceStats struct {
+	sync.Mutex
+
+	max     int
+	lru     *list.List
+	entries map[instanceKey]*list.Element
+	evicted int64
+}
+
+func NewInstanceStats(max int) *InstanceStats {
+	return &InstanceStats{
+		max:     max,
+		lru:     list.New(),
+		entries: make(map[instanceKey]*list.Element),
+	}
+}
+
+func (x *InstanceStats) MarkRequest(applicationId, index string, tags map[string]string) {
+	x.Lock()
+	defer x.Unlock()
+
+	if e := x.entry(applicationId, index); e != nil {
+		e.Requests++
+		e.Tags = tags
+	}
+}
+
+// MarkResponse counts a response with the given status code. A status code
+// of zero stands for a request that got no response. Both it and 5xx
+// responses count as errors.
+func (x *InstanceStats) MarkResponse(applicationId, index string, statusCode int) {
+	x.Lock()
+	defer x.Unlock()
+
+	e := x.entry(applicationId, index)
+	if e == nil {
+		return
+	}
+
+	e.Responses++
+	if statusCode == 0 || statusCode/100 == 5 {
+		e.Errors++
+	}
+}
+
+// Evicted returns how many instances were forgotten to make room.
+func (x *InstanceStats) Evicted() int64 {
+	x.Lock()
+	defer x.Unlock()
+
+	return x.evicted
+}
+
+// Snapshot returns the counts of every tracked instance by application id
+// and instance index.
+func (x *InstanceStats) Snapshot() map[string]map[string]InstanceCounts {
+	x.Lock()
+	defer x.Unlock()
+
+	y := make(map[string]map[string]InstanceCounts)
+	for key, element := range x.entries {
+		e := element.Value.(*instanceEntry)
+
+		counts := e.InstanceCounts
+		if counts.Responses > 0 {
+			counts.ErrorRate = float64(counts.Errors) / float64(counts.Responses)
+		}
+
+		instances, ok := y[key.applicationId]
+		if !ok {
+			instances = make(map[string]InstanceCounts)
+			y[key.applicationId] = instances
+		}
+		instances[key.index] = counts
+	}
+
+	return y
+}
+
+func (x *InstanceStats) entry(applicationId, index string) *instanceEntry {
+	if applicationId == "" || index == "" || x.max <= 0 {
+		return nil
+	}
+
+	key := instanceKey{applicationId: applicationId, index: index}
+	if element, ok := x.entries[key]; ok {
+		x.lru.MoveToFront(element)
+		return element.Value.(*instanceEntry)
+	}
+
+	if x.lru.Len() >= x.max {
+		oldest := x.lru.Back()
+		x.lru.Remove(oldest)
+		delete(x.entries, oldest.Value.(*instanceEntry).key)
+		x.evicted++
+	}
+
+	e := &instanceEntry{key: key}
+	x.entries[key] = x.lru.PushFront(e)
+	return e
+}
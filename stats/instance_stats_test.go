@@ -0,0 +1,65 @@
+package stats_test
+
+import (
+	. "github.com/cloudfoundry/gorouter/stats"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"strconv"
+)
+
+var _ = Describe("InstanceStats", func() {
+	var instanceStats *InstanceStats
+
+	BeforeEach(func() {
+		instanceStats = NewInstanceStats(3)
+	})
+
+	It("counts requests and errors per instance index", func() {
+		instanceStats.MarkRequest("a", "0", nil)
+		instanceStats.MarkResponse("a", "0", 200)
+		instanceStats.MarkRequest("a", "1", nil)
+		instanceStats.MarkResponse("a", "1", 503)
+		instanceStats.MarkRequest("a", "1", nil)
+		instanceStats.MarkResponse("a", "1", 404)
+
+		snapshot := instanceStats.Snapshot()
+		Ω(snapshot["a"]["0"]).To(Equal(InstanceCounts{Requests: 1, Responses: 1}))
+		Ω(snapshot["a"]["1"]).To(Equal(InstanceCounts{Requests: 2, Responses: 2, Errors: 1, ErrorRate: 0.5}))
+	})
+
+	It("keeps the tags of the endpoint last requested", func() {
+		instanceStats.MarkRequest("a", "0", map[string]string{"space": "dev"})
+		instanceStats.MarkRequest("a", "0", map[string]string{"space": "prod"})
+
+		Ω(instanceStats.Snapshot()["a"]["0"].Tags).To(Equal(map[string]string{"space": "prod"}))
+	})
+
+	It("counts missing responses as errors", func() {
+		instanceStats.MarkResponse("a", "0", 0)
+
+		Ω(instanceStats.Snapshot()["a"]["0"].Errors).To(Equal(int64(1)))
+	})
+
+	It("ignores endpoints without an application or an index", func() {
+		instanceStats.MarkRequest("", "0", nil)
+		instanceStats.MarkRequest("a", "", nil)
+
+		Ω(instanceStats.Snapshot()).To(BeEmpty())
+	})
+
+	It("forgets the least recently seen instance when full", func() {
+		for i := 0; i < 3; i++ {
+			instanceStats.MarkRequest("a", strconv.Itoa(i), nil)
+		}
+		instanceStats.MarkRequest("a", "0", nil)
+		instanceStats.MarkRequest("b", "0", nil)
+
+		snapshot := instanceStats.Snapshot()
+		Ω(snapshot["a"]).To(HaveKey("0"))
+		Ω(snapshot["a"]).ToNot(HaveKey("1"))
+		Ω(snapshot["a"]).To(HaveKey("2"))
+		Ω(snapshot["b"]).To(HaveKey("0"))
+		Ω(instanceStats.Evicted()).To(Equal(int64(1)))
+	})
+})
@@ -0,0 +1,163 @@
+package stickysession
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"io"
+	"strings"
+
+	"github.com/cloudfoundry/gorouter/config"
+)
+
+// A Codec turns the instance id that pins a client to an instance into the
+// value of its sticky session cookie and back. The value is the unpadded
+// base64url instance id, or its AES-GCM encryption when encrypting,
+// followed by a dot and the unpadded base64url HMAC-SHA256 of what comes
+// before the dot. A nil Codec uses the instance id as the value.
+//
+// When the router has an address for its peers to reach it by, the value
+// carries it as well, as a hint of where the instance can be reached should
+// the client come back through a router that does not know the instance.
+type Codec struct {
+	keys    []key
+	encrypt bool
+	hint    string
+}
+
+// hintSeparator separates the instance id from the hint in the payload of
+// a cookie.
+const hintSeparator = "\x00"
+
+type key struct {
+	sign []byte
+	aead cipher.AEAD
+}
+
+// NewCodec returns the codec configured by c, or nil when no keys are
+// configured.
+func NewCodec(c config.StickySessionsConfig) *Codec {
+	if len(c.Keys) == 0 {
+		return nil
+	}
+
+	codec := &Codec{encrypt: c.Encrypt, hint: c.RouterAddress}
+	for _, k := range c.Keys {
+		codec.keys = append(codec.keys, newKey(k))
+	}
+	return codec
+}
+
+// newKey derives separate keys to sign and encrypt with from k, so that no
+// key is used for both.
+func newKey(k string) key {
+	block, err := aes.NewCipher(derive(k, "encrypt"))
+	if err != nil {
+		panic(err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		panic(err)
+	}
+
+	return key{sign: derive(k, "sign"), aead: aead}
+}
+
+func derive(k, purpose string) []byte {
+	mac := hmac.New(sha256.New, []byte(k))
+	mac.Write([]byte(purpose))
+	return mac.Sum(nil)
+}
+
+// Encode returns the cookie value for instanceId, made with the first key.
+func (c *Codec) Encode(instanceId string) string {
+	if c == nil {
+		return instanceId
+	}
+
+	k := c.keys[0]
+	payload := []byte(instanceId)
+	if c.hint != "" {
+		payload = []byte(instanceId + hintSeparator + c.hint)
+	}
+	if c.encrypt {
+		nonce := make([]byte, k.aead.NonceSize())
+		if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+			panic(err)
+		}
+		payload = k.aead.Seal(nonce, nonce, payload, nil)
+	}
+
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + k.signature(encoded)
+}
+
+// Decode returns the instance id of a cookie value, and false when the
+// value was not made with any of the keys.
+func (c *Codec) Decode(value string) (string, bool) {
+	id, _, ok := c.DecodeHint(value)
+	return id, ok
+}
+
+// DecodeHint returns the instance id of a cookie value and the address of
+// the router that made it, or "" when the value carries none, and false
+// when the value was not made with any of the keys.
+func (c *Codec) DecodeHint(value string) (string, string, bool) {
+	if c == nil {
+		return value, "", true
+	}
+
+	payload, ok := c.open(value)
+	if !ok {
+		return "", "", false
+	}
+
+	if i := strings.Index(payload, hintSeparator); i >= 0 {
+		return payload[:i], payload[i+len(hintSeparator):], true
+	}
+	return payload, "", true
+}
+
+// open returns the payload of a cookie value, and false when the value was
+// not made with any of the keys.
+func (c *Codec) open(value string) (string, bool) {
+	i := strings.LastIndex(value, ".")
+	if i < 0 {
+		return "", false
+	}
+	encoded, signature := value[:i], value[i+1:]
+
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", false
+	}
+
+	for _, k := range c.keys {
+		if !hmac.Equal([]byte(signature), []byte(k.signature(encoded))) {
+			continue
+		}
+		if !c.encrypt {
+			return string(payload), true
+		}
+
+		n := k.aead.NonceSize()
+		if len(payload) < n {
+			return "", false
+		}
+		plain, err := k.aead.Open(nil, payload[:n], payload[n:], nil)
+		if err != nil {
+			return "", false
+		}
+		return string(plain), true
+	}
+	return "", false
+}
+
+func (k key) signature(encoded string) string {
+	mac := hmac.New(sha256.New, k.sign)
+	mac.Write([]byte(encoded))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,110 @@
+package stickysession_test
+
+import (
+	"strings"
+
+	"github.com/cloudfoundry/gorouter/config"
+	"github.com/cloudfoundry/gorouter/stickysession"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Codec", func() {
+	const (
+		oldKey = "old-key-0123456789"
+		newKey = "new-key-0123456789"
+	)
+
+	It("uses instance ids as they are without keys", func() {
+		codec := stickysession.NewCodec(config.StickySessionsConfig{})
+		Ω(codec).To(BeNil())
+
+		Ω(codec.Encode("instance-1")).To(Equal("instance-1"))
+		id, ok := codec.Decode("anything")
+		Ω(ok).To(BeTrue())
+		Ω(id).To(Equal("anything"))
+	})
+
+	It("signs instance ids", func() {
+		codec := stickysession.NewCodec(config.StickySessionsConfig{Keys: []string{newKey}})
+
+		value := codec.Encode("instance-1")
+		id, ok := codec.Decode(value)
+		Ω(ok).To(BeTrue())
+		Ω(id).To(Equal("instance-1"))
+
+		_, ok = codec.Decode("instance-1")
+		Ω(ok).To(BeFalse())
+
+		// instance-2, with the signature of instance-1
+		forged := "aW5zdGFuY2UtMg" + value[strings.Index(value, "."):]
+		_, ok = codec.Decode(forged)
+		Ω(ok).To(BeFalse())
+	})
+
+	It("encrypts instance ids when configured to", func() {
+		codec := stickysession.NewCodec(config.StickySessionsConfig{Keys: []string{newKey}, Encrypt: true})
+
+		value := codec.Encode("instance-1")
+		Ω(value).NotTo(ContainSubstring("aW5zdGFuY2UtMQ"))
+		Ω(codec.Encode("instance-1")).NotTo(Equal(value))
+
+		id, ok := codec.Decode(value)
+		Ω(ok).To(BeTrue())
+		Ω(id).To(Equal("instance-1"))
+	})
+
+	It("carries the address of the router as a hint when it has one", func() {
+		codec := stickysession.NewCodec(config.StickySessionsConfig{Keys: []string{newKey}, RouterAddress: "10.0.16.5:80"})
+
+		value := codec.Encode("instance-1")
+		id, hint, ok := codec.DecodeHint(value)
+		Ω(ok).To(BeTrue())
+		Ω(id).To(Equal("instance-1"))
+		Ω(hint).To(Equal("10.0.16.5:80"))
+
+		id, ok = codec.Decode(value)
+		Ω(ok).To(BeTrue())
+		Ω(id).To(Equal("instance-1"))
+
+		// a router without an address takes its cookies, and the other way round
+		plain := stickysession.NewCodec(config.StickySessionsConfig{Keys: []string{newKey}})
+		id, hint, ok = plain.DecodeHint(value)
+		Ω(ok).To(BeTrue())
+		Ω(id).To(Equal("instance-1"))
+		Ω(hint).To(Equal("10.0.16.5:80"))
+
+		id, hint, ok = codec.DecodeHint(plain.Encode("instance-2"))
+		Ω(ok).To(BeTrue())
+		Ω(id).To(Equal("instance-2"))
+		Ω(hint).To(BeEmpty())
+	})
+
+	It("has no hints without keys", func() {
+		var codec *stickysession.Codec
+
+		id, hint, ok := codec.DecodeHint("instance-1")
+		Ω(ok).To(BeTrue())
+		Ω(id).To(Equal("instance-1"))
+		Ω(hint).To(BeEmpty())
+	})
+
+	It("accepts cookies made with any of the keys", func() {
+		old := stickysession.NewCodec(config.StickySessionsConfig{Keys: []string{oldKey}, Encrypt: true})
+		rotated := stickysession.NewCodec(config.StickySessionsConfig{Keys: []string{newKey, oldKey}, Encrypt: true})
+		retired := stickysession.NewCodec(config.StickySessionsConfig{Keys: []string{newKey}, Encrypt: true})
+
+		value := old.Encode("instance-1")
+		id, ok := rotated.Decode(value)
+		Ω(ok).To(BeTrue())
+		Ω(id).To(Equal("instance-1"))
+
+		_, ok = retired.Decode(value)
+		Ω(ok).To(BeFalse())
+
+		id, ok = retired.Decode(rotated.Encode("instance-2"))
+		Ω(ok).To(BeTrue())
+		Ω(id).To(Equal("instance-2"))
+	})
+})
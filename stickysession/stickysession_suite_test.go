@@ -0,0 +1,13 @@
+package stickysession_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestStickysession(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Stickysession Suite")
+}
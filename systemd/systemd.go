@@ -0,0 +1,60 @@
+// Package systemd takes the sockets that systemd activates the router with,
+// and tells systemd how the router is doing when it runs as a notify
+// service.
+package systemd
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"syscall"
+)
+
+// listenFdsStart is the first descriptor that sockets are passed on.
+const listenFdsStart = 3
+
+// Listeners returns the sockets that systemd activated the router with, if
+// any, and clears them from the environment so that they are not passed on
+// to the processes the router starts.
+func Listeners() []*os.File {
+	defer func() {
+		os.Unsetenv("LISTEN_PID")
+		os.Unsetenv("LISTEN_FDS")
+		os.Unsetenv("LISTEN_FDNAMES")
+	}()
+
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil
+	}
+
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n <= 0 {
+		return nil
+	}
+
+	files := make([]*os.File, 0, n)
+	for fd := listenFdsStart; fd < listenFdsStart+n; fd++ {
+		syscall.CloseOnExec(fd)
+		files = append(files, os.NewFile(uintptr(fd), "LISTEN_FD_"+strconv.Itoa(fd)))
+	}
+	return files
+}
+
+// Notify sends state, such as READY=1, to systemd. It does nothing unless
+// systemd gave the router a socket to notify it on.
+func Notify(state string) error {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return nil
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.Write([]byte(state))
+	return err
+}
@@ -0,0 +1,69 @@
+package systemd_test
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/cloudfoundry/gorouter/systemd"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Systemd", func() {
+	Describe("Notify", func() {
+		var (
+			dir    string
+			socket *net.UnixConn
+		)
+
+		BeforeEach(func() {
+			var err error
+			dir, err = ioutil.TempDir("", "systemd")
+			Ω(err).NotTo(HaveOccurred())
+
+			path := filepath.Join(dir, "notify")
+			socket, err = net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+			Ω(err).NotTo(HaveOccurred())
+
+			os.Setenv("NOTIFY_SOCKET", path)
+		})
+
+		AfterEach(func() {
+			os.Unsetenv("NOTIFY_SOCKET")
+			socket.Close()
+			os.RemoveAll(dir)
+		})
+
+		It("sends the state to the notify socket", func() {
+			Ω(systemd.Notify("READY=1")).To(Succeed())
+
+			b := make([]byte, 64)
+			n, err := socket.Read(b)
+			Ω(err).NotTo(HaveOccurred())
+			Ω(string(b[:n])).To(Equal("READY=1"))
+		})
+
+		It("does nothing without a notify socket", func() {
+			os.Unsetenv("NOTIFY_SOCKET")
+
+			Ω(systemd.Notify("READY=1")).To(Succeed())
+		})
+	})
+
+	Describe("Listeners", func() {
+		It("ignores sockets passed to another process", func() {
+			os.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()+1))
+			os.Setenv("LISTEN_FDS", "2")
+
+			Ω(systemd.Listeners()).To(BeEmpty())
+			Ω(os.Getenv("LISTEN_FDS")).To(BeEmpty())
+		})
+
+		It("returns nothing when not socket activated", func() {
+			Ω(systemd.Listeners()).To(BeEmpty())
+		})
+	})
+})
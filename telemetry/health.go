@@ -0,0 +1,235 @@
+// Package telemetry keeps track of whether the sinks the router emits
+// metrics and logs to are reachable and taking what it sends, so that empty
+// dashboards can be explained from the router itself.
+package telemetry
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/cloudfoundry/gorouter/clock"
+)
+
+const (
+	StatusOK      = "ok"
+	StatusIdle    = "idle"
+	StatusStale   = "stale"
+	StatusFailing = "failing"
+
+	checkTimeout = 5 * time.Second
+)
+
+var errCheckTimedOut = errors.New("check timed out")
+
+// Health is the list of the router's telemetry sinks. It serves a report
+// of them as JSON, running their checks first, with status 503 when one of
+// them is not healthy.
+type Health struct {
+	clock clock.Clock
+
+	mu    sync.Mutex
+	sinks []*Sink
+}
+
+// A Sink records the outcome of every emission to one telemetry sink. A
+// nil Sink records nothing, so emitters need not know whether they are
+// watched.
+type Sink struct {
+	name   string
+	maxAge time.Duration
+	check  func() error
+	clock  clock.Clock
+	added  time.Time
+
+	mu          sync.Mutex
+	lastSuccess time.Time
+	lastFailure time.Time
+	lastError   string
+	successes   uint64
+	failures    uint64
+}
+
+type Report struct {
+	Healthy bool         `json:"healthy"`
+	Sinks   []SinkReport `json:"sinks"`
+}
+
+type SinkReport struct {
+	Name        string     `json:"name"`
+	Status      string     `json:"status"`
+	LastSuccess *time.Time `json:"last_success,omitempty"`
+	LastFailure *time.Time `json:"last_failure,omitempty"`
+	LastError   string     `json:"last_error,omitempty"`
+	Successes   uint64     `json:"successes"`
+	Failures    uint64     `json:"failures"`
+	CheckError  string     `json:"check_error,omitempty"`
+}
+
+func NewHealth(c clock.Clock) *Health {
+	return &Health{clock: c}
+}
+
+// AddSink watches the sink called name. Its check, when it has one, is run
+// for every report to find out whether the sink is reachable. A sink that
+// is expected to take emissions regularly has a maxAge, and is stale when
+// its last success, or its being added, is longer ago; zero leaves sinks
+// that only take emissions when there is traffic idle rather than stale.
+func (h *Health) AddSink(name string, maxAge time.Duration, check func() error) *Sink {
+	s := &Sink{
+		name:   name,
+		maxAge: maxAge,
+		check:  check,
+		clock:  h.clock,
+		added:  h.clock.Now(),
+	}
+
+	h.mu.Lock()
+	h.sinks = append(h.sinks, s)
+	h.mu.Unlock()
+
+	return s
+}
+
+func (s *Sink) Succeeded() {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	s.lastSuccess = s.clock.Now()
+	s.successes++
+	s.mu.Unlock()
+}
+
+func (s *Sink) Failed(err error) {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	s.lastFailure = s.clock.Now()
+	s.lastError = err.Error()
+	s.failures++
+	s.mu.Unlock()
+}
+
+// Report runs the checks of the sinks concurrently and reports on them.
+func (h *Health) Report() Report {
+	h.mu.Lock()
+	sinks := append([]*Sink(nil), h.sinks...)
+	h.mu.Unlock()
+
+	checkErrors := make([]error, len(sinks))
+	var wg sync.WaitGroup
+	for i, s := range sinks {
+		if s.check == nil {
+			continue
+		}
+
+		wg.Add(1)
+		go func(i int, s *Sink) {
+			defer wg.Done()
+			checkErrors[i] = h.runCheck(s.check)
+		}(i, s)
+	}
+	wg.Wait()
+
+	report := Report{Healthy: true, Sinks: make([]SinkReport, len(sinks))}
+	for i, s := range sinks {
+		r := s.report(checkErrors[i])
+		if r.Status == StatusFailing || r.Status == StatusStale {
+			report.Healthy = false
+		}
+		report.Sinks[i] = r
+	}
+
+	return report
+}
+
+func (h *Health) runCheck(check func() error) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- check()
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-h.clock.After(checkTimeout):
+		return errCheckTimedOut
+	}
+}
+
+func (s *Sink) report(checkErr error) SinkReport {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r := SinkReport{
+		Name:      s.name,
+		Successes: s.successes,
+		Failures:  s.failures,
+		LastError: s.lastError,
+	}
+	if !s.lastSuccess.IsZero() {
+		t := s.lastSuccess
+		r.LastSuccess = &t
+	}
+	if !s.lastFailure.IsZero() {
+		t := s.lastFailure
+		r.LastFailure = &t
+	}
+	if checkErr != nil {
+		r.CheckError = checkErr.Error()
+	}
+
+	since := s.lastSuccess
+	if since.IsZero() {
+		since = s.added
+	}
+
+	switch {
+	case checkErr != nil || s.lastFailure.After(s.lastSuccess):
+		r.Status = StatusFailing
+	case s.maxAge > 0 && s.clock.Since(since) > s.maxAge:
+		r.Status = StatusStale
+	case s.lastSuccess.IsZero():
+		r.Status = StatusIdle
+	default:
+		r.Status = StatusOK
+	}
+
+	return r
+}
+
+func (h *Health) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	report := h.Report()
+
+	w.Header().Set("Content-Type", "application/json")
+	if report.Healthy {
+		w.WriteHeader(http.StatusOK)
+	} else {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(report)
+}
+
+// DialCheck returns a check that succeeds when one of addresses takes a
+// connection over network.
+func DialCheck(network string, addresses ...string) func() error {
+	return func() error {
+		err := errors.New("no addresses")
+		for _, address := range addresses {
+			var conn net.Conn
+			conn, err = net.DialTimeout(network, address, checkTimeout)
+			if err == nil {
+				conn.Close()
+				return nil
+			}
+		}
+		return err
+	}
+}
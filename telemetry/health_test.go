@@ -0,0 +1,130 @@
+package telemetry_test
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/cloudfoundry/gorouter/clock/fakeclock"
+	"github.com/cloudfoundry/gorouter/telemetry"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Health", func() {
+	var fakeClock *fakeclock.FakeClock
+	var health *telemetry.Health
+
+	BeforeEach(func() {
+		fakeClock = fakeclock.New(time.Unix(1500000000, 0))
+		health = telemetry.NewHealth(fakeClock)
+	})
+
+	It("is healthy without sinks", func() {
+		report := health.Report()
+		Ω(report.Healthy).To(BeTrue())
+		Ω(report.Sinks).To(BeEmpty())
+	})
+
+	It("reports sinks that have not taken emissions yet as idle", func() {
+		health.AddSink("kafka", 0, nil)
+
+		report := health.Report()
+		Ω(report.Healthy).To(BeTrue())
+		Ω(report.Sinks[0].Name).To(Equal("kafka"))
+		Ω(report.Sinks[0].Status).To(Equal(telemetry.StatusIdle))
+		Ω(report.Sinks[0].LastSuccess).To(BeNil())
+	})
+
+	It("reports the last successful emission", func() {
+		sink := health.AddSink("kafka", 0, nil)
+		sink.Failed(errors.New("broker down"))
+		fakeClock.Increment(time.Second)
+		sink.Succeeded()
+
+		report := health.Report()
+		Ω(report.Healthy).To(BeTrue())
+		Ω(report.Sinks[0].Status).To(Equal(telemetry.StatusOK))
+		Ω(*report.Sinks[0].LastSuccess).To(Equal(fakeClock.Now()))
+		Ω(report.Sinks[0].LastError).To(Equal("broker down"))
+		Ω(report.Sinks[0].Successes).To(BeEquivalentTo(1))
+		Ω(report.Sinks[0].Failures).To(BeEquivalentTo(1))
+	})
+
+	It("is unhealthy while the last emission to a sink failed", func() {
+		sink := health.AddSink("syslog", 0, nil)
+		sink.Succeeded()
+		fakeClock.Increment(time.Second)
+		sink.Failed(errors.New("connection refused"))
+
+		report := health.Report()
+		Ω(report.Healthy).To(BeFalse())
+		Ω(report.Sinks[0].Status).To(Equal(telemetry.StatusFailing))
+	})
+
+	It("is unhealthy when a sink that should take emissions regularly has not", func() {
+		sink := health.AddSink("prometheus", time.Minute, nil)
+		Ω(health.Report().Sinks[0].Status).To(Equal(telemetry.StatusIdle))
+
+		fakeClock.Increment(2 * time.Minute)
+		report := health.Report()
+		Ω(report.Healthy).To(BeFalse())
+		Ω(report.Sinks[0].Status).To(Equal(telemetry.StatusStale))
+
+		sink.Succeeded()
+		Ω(health.Report().Sinks[0].Status).To(Equal(telemetry.StatusOK))
+	})
+
+	It("runs the checks of sinks", func() {
+		health.AddSink("metron", 0, func() error { return nil })
+		health.AddSink("loggregator_v2", 0, func() error { return errors.New("unreachable") })
+
+		report := health.Report()
+		Ω(report.Healthy).To(BeFalse())
+		Ω(report.Sinks[0].Status).To(Equal(telemetry.StatusIdle))
+		Ω(report.Sinks[1].Status).To(Equal(telemetry.StatusFailing))
+		Ω(report.Sinks[1].CheckError).To(Equal("unreachable"))
+	})
+
+	It("ignores recording on a nil sink", func() {
+		var sink *telemetry.Sink
+		sink.Succeeded()
+		sink.Failed(errors.New("ignored"))
+	})
+
+	It("serves the report as JSON, with 503 when unhealthy", func() {
+		health.AddSink("kafka", 0, nil).Succeeded()
+
+		w := httptest.NewRecorder()
+		health.ServeHTTP(w, httptest.NewRequest("GET", "/metrics-health", nil))
+		Ω(w.Code).To(Equal(http.StatusOK))
+		Ω(w.Header().Get("Content-Type")).To(Equal("application/json"))
+
+		var report telemetry.Report
+		Ω(json.Unmarshal(w.Body.Bytes(), &report)).To(Succeed())
+		Ω(report.Sinks[0].Status).To(Equal(telemetry.StatusOK))
+
+		health.AddSink("syslog", 0, nil).Failed(errors.New("connection refused"))
+
+		w = httptest.NewRecorder()
+		health.ServeHTTP(w, httptest.NewRequest("GET", "/metrics-health", nil))
+		Ω(w.Code).To(Equal(http.StatusServiceUnavailable))
+	})
+
+	Describe("DialCheck", func() {
+		It("succeeds when one of the addresses takes a connection", func() {
+			ln, err := net.Listen("tcp", "127.0.0.1:0")
+			Ω(err).ToNot(HaveOccurred())
+			addr := ln.Addr().String()
+
+			Ω(telemetry.DialCheck("tcp", "127.0.0.1:1", addr)()).To(Succeed())
+
+			ln.Close()
+			Ω(telemetry.DialCheck("tcp", addr)()).ToNot(Succeed())
+		})
+	})
+})
@@ -0,0 +1,163 @@
+package tracing
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// OTLPExporter sends spans to an OpenTelemetry collector using OTLP over
+// HTTP with the JSON encoding.
+type OTLPExporter struct {
+	endpoint    string
+	serviceName string
+	resource    map[string]interface{}
+	client      *http.Client
+}
+
+func NewOTLPExporter(endpoint, serviceName string, resource map[string]interface{}) *OTLPExporter {
+	return &OTLPExporter{
+		endpoint:    endpoint,
+		serviceName: serviceName,
+		resource:    resource,
+		client:      &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceId           string         `json:"traceId"`
+	SpanId            string         `json:"spanId"`
+	ParentSpanId      string         `json:"parentSpanId,omitempty"`
+	Name              string         `json:"name"`
+	Kind              SpanKind       `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	Status            otlpStatus     `json:"status"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpKeyValue struct {
+	Key   string                 `json:"key"`
+	Value map[string]interface{} `json:"value"`
+}
+
+const (
+	otlpStatusUnset = 0
+	otlpStatusError = 2
+)
+
+func (e *OTLPExporter) Export(spans []*Span) error {
+	resource := map[string]interface{}{"service.name": e.serviceName}
+	for k, v := range e.resource {
+		resource[k] = v
+	}
+
+	scope := otlpScopeSpans{
+		Scope: otlpScope{Name: "gorouter"},
+		Spans: make([]otlpSpan, 0, len(spans)),
+	}
+	for _, s := range spans {
+		scope.Spans = append(scope.Spans, toOTLPSpan(s))
+	}
+
+	body, err := json.Marshal(otlpRequest{
+		ResourceSpans: []otlpResourceSpans{
+			{
+				Resource:   otlpResource{Attributes: toOTLPAttributes(resource)},
+				ScopeSpans: []otlpScopeSpans{scope},
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	res, err := e.client.Post(e.endpoint, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+
+	if res.StatusCode/100 != 2 {
+		return fmt.Errorf("otlp exporter: unexpected status %d", res.StatusCode)
+	}
+
+	return nil
+}
+
+func toOTLPSpan(s *Span) otlpSpan {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	o := otlpSpan{
+		TraceId:           s.Context.TraceId.String(),
+		SpanId:            s.Context.SpanId.String(),
+		Name:              s.Name,
+		Kind:              s.Kind,
+		StartTimeUnixNano: strconv.FormatInt(s.StartTime.UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(s.EndTime.UnixNano(), 10),
+		Attributes:        toOTLPAttributes(s.Attributes),
+		Status:            otlpStatus{Code: otlpStatusUnset},
+	}
+
+	if s.ParentSpanId.IsValid() {
+		o.ParentSpanId = s.ParentSpanId.String()
+	}
+
+	if s.Error != "" {
+		o.Status = otlpStatus{Code: otlpStatusError, Message: s.Error}
+	}
+
+	return o
+}
+
+func toOTLPAttributes(m map[string]interface{}) []otlpKeyValue {
+	kvs := make([]otlpKeyValue, 0, len(m))
+	for k, v := range m {
+		var value map[string]interface{}
+		switch x := v.(type) {
+		case int:
+			value = map[string]interface{}{"intValue": strconv.Itoa(x)}
+		case int64:
+			value = map[string]interface{}{"intValue": strconv.FormatInt(x, 10)}
+		case bool:
+			value = map[string]interface{}{"boolValue": x}
+		case float64:
+			value = map[string]interface{}{"doubleValue": x}
+		default:
+			value = map[string]interface{}{"stringValue": fmt.Sprint(x)}
+		}
+		kvs = append(kvs, otlpKeyValue{Key: k, Value: value})
+	}
+	return kvs
+}
@@ -0,0 +1,82 @@
+package tracing
+
+import (
+	"sync"
+	"time"
+)
+
+type SpanKind int
+
+// Values match the OTLP SpanKind enumeration.
+const (
+	SpanKindServer SpanKind = 2
+	SpanKindClient SpanKind = 3
+)
+
+type Span struct {
+	lock sync.Mutex
+
+	tracer *Tracer
+
+	Name         string
+	Kind         SpanKind
+	Context      TraceContext
+	ParentSpanId SpanId
+	StartTime    time.Time
+	EndTime      time.Time
+	Attributes   map[string]interface{}
+	Error        string
+
+	ended bool
+}
+
+func (s *Span) SetAttribute(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+
+	s.lock.Lock()
+	s.Attributes[key] = value
+	s.lock.Unlock()
+}
+
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+
+	s.lock.Lock()
+	s.Error = err.Error()
+	s.lock.Unlock()
+}
+
+// StartChild starts a span in the same trace whose parent is s.
+func (s *Span) StartChild(name string, kind SpanKind) *Span {
+	if s == nil {
+		return nil
+	}
+
+	parent := s.Context
+	return s.tracer.StartSpan(name, kind, &parent)
+}
+
+// End records the end time and hands sampled spans to the exporter. Only the
+// first call has an effect.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+
+	s.lock.Lock()
+	if s.ended {
+		s.lock.Unlock()
+		return
+	}
+	s.ended = true
+	s.EndTime = time.Now()
+	s.lock.Unlock()
+
+	if s.Context.Sampled {
+		s.tracer.enqueue(s)
+	}
+}
@@ -0,0 +1,84 @@
+package tracing
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+const TraceparentHeader = "Traceparent"
+
+type TraceId [16]byte
+type SpanId [8]byte
+
+func (t TraceId) String() string { return hex.EncodeToString(t[:]) }
+func (s SpanId) String() string  { return hex.EncodeToString(s[:]) }
+
+func (t TraceId) IsValid() bool { return t != TraceId{} }
+func (s SpanId) IsValid() bool  { return s != SpanId{} }
+
+// TraceContext is the part of a span that crosses process boundaries, as
+// described by the W3C Trace Context recommendation.
+type TraceContext struct {
+	TraceId TraceId
+	SpanId  SpanId
+	Sampled bool
+}
+
+// ParseTraceparent parses a version 00 traceparent header value.
+func ParseTraceparent(value string) (TraceContext, bool) {
+	var c TraceContext
+
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) < 4 || parts[0] == "ff" || len(parts[0]) != 2 {
+		return c, false
+	}
+	if parts[0] == "00" && len(parts) != 4 {
+		return c, false
+	}
+
+	if !decodeHex(c.TraceId[:], parts[1]) || !decodeHex(c.SpanId[:], parts[2]) {
+		return c, false
+	}
+
+	var flags [1]byte
+	if !decodeHex(flags[:], parts[3]) {
+		return c, false
+	}
+	c.Sampled = flags[0]&1 == 1
+
+	if !c.TraceId.IsValid() || !c.SpanId.IsValid() {
+		return c, false
+	}
+
+	return c, true
+}
+
+func (c TraceContext) Traceparent() string {
+	flags := 0
+	if c.Sampled {
+		flags = 1
+	}
+	return fmt.Sprintf("00-%s-%s-%02x", c.TraceId, c.SpanId, flags)
+}
+
+func decodeHex(dst []byte, s string) bool {
+	if len(s) != 2*len(dst) || strings.ToLower(s) != s {
+		return false
+	}
+	_, err := hex.Decode(dst, []byte(s))
+	return err == nil
+}
+
+func NewTraceId() TraceId {
+	var t TraceId
+	rand.Read(t[:])
+	return t
+}
+
+func NewSpanId() SpanId {
+	var s SpanId
+	rand.Read(s[:])
+	return s
+}
@@ -0,0 +1,47 @@
+package tracing_test
+
+import (
+	. "github.com/cloudfoundry/gorouter/tracing"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("TraceContext", func() {
+	It("round-trips a traceparent header", func() {
+		value := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+		c, ok := ParseTraceparent(value)
+		Ω(ok).To(BeTrue())
+		Ω(c.TraceId.String()).To(Equal("4bf92f3577b34da6a3ce929d0e0e4736"))
+		Ω(c.SpanId.String()).To(Equal("00f067aa0ba902b7"))
+		Ω(c.Sampled).To(BeTrue())
+		Ω(c.Traceparent()).To(Equal(value))
+	})
+
+	It("reads the sampled flag", func() {
+		c, ok := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+		Ω(ok).To(BeTrue())
+		Ω(c.Sampled).To(BeFalse())
+	})
+
+	It("accepts future versions with extra fields", func() {
+		_, ok := ParseTraceparent("01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra")
+		Ω(ok).To(BeTrue())
+	})
+
+	It("rejects invalid values", func() {
+		for _, value := range []string{
+			"",
+			"garbage",
+			"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+			"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+			"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+			"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+			"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+			"00-4bf92f3577b34da6a3ce929d0e0e47-00f067aa0ba902b7-01",
+		} {
+			_, ok := ParseTraceparent(value)
+			Ω(ok).To(BeFalse(), value)
+		}
+	})
+})
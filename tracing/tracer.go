@@ -0,0 +1,141 @@
+package tracing
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+
+	steno "github.com/cloudfoundry/gosteno"
+)
+
+const (
+	maxQueuedSpans = 2048
+	maxBatchSize   = 256
+)
+
+type Exporter interface {
+	Export(spans []*Span) error
+}
+
+// Tracer creates spans and exports the sampled ones in batches from a
+// background goroutine, so exporting never blocks a request.
+type Tracer struct {
+	exporter      Exporter
+	sampleRatio   float64
+	flushInterval time.Duration
+	logger        *steno.Logger
+
+	queue  chan *Span
+	stopCh chan struct{}
+	done   chan struct{}
+
+	randLock sync.Mutex
+	random   *rand.Rand
+}
+
+func NewTracer(exporter Exporter, sampleRatio float64, flushInterval time.Duration) *Tracer {
+	return &Tracer{
+		exporter:      exporter,
+		sampleRatio:   sampleRatio,
+		flushInterval: flushInterval,
+		logger:        steno.NewLogger("router.tracing"),
+		queue:         make(chan *Span, maxQueuedSpans),
+		stopCh:        make(chan struct{}),
+		done:          make(chan struct{}),
+		random:        rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// StartSpan starts a span. When parent is nil a new trace is started and the
+// sampling decision is made here; otherwise the parent's decision is kept.
+func (t *Tracer) StartSpan(name string, kind SpanKind, parent *TraceContext) *Span {
+	s := &Span{
+		tracer:     t,
+		Name:       name,
+		Kind:       kind,
+		StartTime:  time.Now(),
+		Attributes: make(map[string]interface{}),
+	}
+
+	if parent != nil {
+		s.Context.TraceId = parent.TraceId
+		s.Context.Sampled = parent.Sampled
+		s.ParentSpanId = parent.SpanId
+	} else {
+		s.Context.TraceId = NewTraceId()
+		s.Context.Sampled = t.sample()
+	}
+	s.Context.SpanId = NewSpanId()
+
+	return s
+}
+
+func (t *Tracer) Run() {
+	defer close(t.done)
+
+	ticker := time.NewTicker(t.flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]*Span, 0, maxBatchSize)
+	for {
+		select {
+		case s := <-t.queue:
+			batch = append(batch, s)
+			if len(batch) == maxBatchSize {
+				batch = t.export(batch)
+			}
+		case <-ticker.C:
+			batch = t.export(batch)
+		case <-t.stopCh:
+			for {
+				select {
+				case s := <-t.queue:
+					batch = append(batch, s)
+				default:
+					t.export(batch)
+					return
+				}
+			}
+		}
+	}
+}
+
+// Stop flushes the spans that are still queued and stops exporting.
+func (t *Tracer) Stop() {
+	close(t.stopCh)
+	<-t.done
+}
+
+func (t *Tracer) export(batch []*Span) []*Span {
+	if len(batch) == 0 {
+		return batch
+	}
+
+	err := t.exporter.Export(batch)
+	if err != nil {
+		t.logger.Warnd(map[string]interface{}{"error": err.Error(), "spans": len(batch)}, "tracing.export.failed")
+	}
+
+	return make([]*Span, 0, maxBatchSize)
+}
+
+func (t *Tracer) enqueue(s *Span) {
+	select {
+	case t.queue <- s:
+	default:
+		t.logger.Debug("tracing.queue.full")
+	}
+}
+
+func (t *Tracer) sample() bool {
+	if t.sampleRatio >= 1 {
+		return true
+	}
+	if t.sampleRatio <= 0 {
+		return false
+	}
+
+	t.randLock.Lock()
+	defer t.randLock.Unlock()
+	return t.random.Float64() < t.sampleRatio
+}
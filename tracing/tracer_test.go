@@ -0,0 +1,152 @@
+package tracing_test
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+
+	. "github.com/cloudfoundry/gorouter/tracing"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type fakeExporter struct {
+	sync.Mutex
+	spans []*Span
+}
+
+func (e *fakeExporter) Export(spans []*Span) error {
+	e.Lock()
+	defer e.Unlock()
+	e.spans = append(e.spans, spans...)
+	return nil
+}
+
+func (e *fakeExporter) Spans() []*Span {
+	e.Lock()
+	defer e.Unlock()
+	return e.spans
+}
+
+var _ = Describe("Tracer", func() {
+	var exporter *fakeExporter
+	var tracer *Tracer
+
+	BeforeEach(func() {
+		exporter = &fakeExporter{}
+		tracer = NewTracer(exporter, 1, 10*time.Millisecond)
+		go tracer.Run()
+	})
+
+	AfterEach(func() {
+		tracer.Stop()
+	})
+
+	It("exports ended spans", func() {
+		span := tracer.StartSpan("proxy GET", SpanKindServer, nil)
+		span.SetAttribute("http.method", "GET")
+		span.End()
+
+		Eventually(exporter.Spans).Should(HaveLen(1))
+
+		exported := exporter.Spans()[0]
+		Ω(exported.Name).To(Equal("proxy GET"))
+		Ω(exported.Attributes).To(HaveKeyWithValue("http.method", "GET"))
+		Ω(exported.EndTime).ToNot(BeTemporally("<", exported.StartTime))
+	})
+
+	It("continues the trace of a parent context", func() {
+		parent, _ := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+
+		span := tracer.StartSpan("proxy GET", SpanKindServer, &parent)
+		Ω(span.Context.TraceId).To(Equal(parent.TraceId))
+		Ω(span.Context.SpanId).ToNot(Equal(parent.SpanId))
+		Ω(span.ParentSpanId).To(Equal(parent.SpanId))
+
+		child := span.StartChild("backend GET", SpanKindClient)
+		Ω(child.Context.TraceId).To(Equal(parent.TraceId))
+		Ω(child.ParentSpanId).To(Equal(span.Context.SpanId))
+	})
+
+	It("does not export spans of unsampled traces", func() {
+		parent, _ := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+
+		tracer.StartSpan("proxy GET", SpanKindServer, &parent).End()
+
+		Consistently(exporter.Spans, 50*time.Millisecond).Should(BeEmpty())
+	})
+
+	It("does not sample new traces at a ratio of zero", func() {
+		unsampled := NewTracer(exporter, 0, time.Second)
+		Ω(unsampled.StartSpan("proxy GET", SpanKindServer, nil).Context.Sampled).To(BeFalse())
+	})
+
+	It("exports a span only once", func() {
+		span := tracer.StartSpan("proxy GET", SpanKindServer, nil)
+		span.End()
+		span.End()
+
+		Eventually(exporter.Spans).Should(HaveLen(1))
+		Consistently(exporter.Spans, 50*time.Millisecond).Should(HaveLen(1))
+	})
+
+	It("tolerates nil spans", func() {
+		var span *Span
+		span.SetAttribute("key", "value")
+		span.SetError(errors.New("boom"))
+		Ω(span.StartChild("child", SpanKindClient)).To(BeNil())
+		span.End()
+	})
+})
+
+var _ = Describe("OTLPExporter", func() {
+	It("posts spans as OTLP JSON", func() {
+		bodies := make(chan []byte, 1)
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			Ω(r.Header.Get("Content-Type")).To(Equal("application/json"))
+			b, _ := ioutil.ReadAll(r.Body)
+			bodies <- b
+		}))
+		defer server.Close()
+
+		tracer := NewTracer(nil, 1, time.Second)
+		span := tracer.StartSpan("backend GET", SpanKindClient, nil)
+		span.SetError(errors.New("connection refused"))
+
+		exporter := NewOTLPExporter(server.URL, "gorouter", nil)
+		Ω(exporter.Export([]*Span{span})).To(Succeed())
+
+		var request map[string]interface{}
+		Ω(json.Unmarshal(<-bodies, &request)).To(Succeed())
+
+		resourceSpans := request["resourceSpans"].([]interface{})[0].(map[string]interface{})
+		Ω(resourceSpans["resource"]).To(Equal(map[string]interface{}{
+			"attributes": []interface{}{
+				map[string]interface{}{"key": "service.name", "value": map[string]interface{}{"stringValue": "gorouter"}},
+			},
+		}))
+
+		spans := resourceSpans["scopeSpans"].([]interface{})[0].(map[string]interface{})["spans"].([]interface{})
+		Ω(spans).To(HaveLen(1))
+
+		exported := spans[0].(map[string]interface{})
+		Ω(exported["traceId"]).To(Equal(span.Context.TraceId.String()))
+		Ω(exported["spanId"]).To(Equal(span.Context.SpanId.String()))
+		Ω(exported["kind"]).To(BeNumerically("==", 3))
+		Ω(exported["status"]).To(Equal(map[string]interface{}{"code": 2.0, "message": "connection refused"}))
+	})
+
+	It("fails on an error response", func() {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}))
+		defer server.Close()
+
+		exporter := NewOTLPExporter(server.URL, "gorouter", nil)
+		Ω(exporter.Export(nil)).ToNot(Succeed())
+	})
+})
@@ -0,0 +1,222 @@
+package usage
+
+import (
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cloudfoundry/gorouter/access_log"
+	"github.com/cloudfoundry/gorouter/metrics"
+)
+
+const maxRoutesPerDomain = 10000
+
+// Accountant keeps hourly per-domain usage totals for the requests that pass
+// through the access log and hands every record on to the next logger.
+type Accountant struct {
+	sync.Mutex
+
+	next      access_log.AccessLogger
+	retention int
+	hours     map[int64]map[string]*domainUsage
+}
+
+type domainUsage struct {
+	requests      int64
+	bytesReceived int64
+	bytesSent     int64
+	routes        map[string]struct{}
+	latency       *metrics.Digest
+}
+
+func NewAccountant(next access_log.AccessLogger, retentionHours int) *Accountant {
+	return &Accountant{
+		next:      next,
+		retention: retentionHours,
+		hours:     make(map[int64]map[string]*domainUsage),
+	}
+}
+
+func (a *Accountant) Run() {
+	a.next.Run()
+}
+
+func (a *Accountant) Stop() {
+	a.next.Stop()
+}
+
+func (a *Accountant) Log(record access_log.AccessLogRecord) {
+	a.record(&record)
+	a.next.Log(record)
+}
+
+func (a *Accountant) record(r *access_log.AccessLogRecord) {
+	if r.Request == nil {
+		return
+	}
+
+	host := hostWithoutPort(r.Request.Host)
+	hour := r.StartedAt.Truncate(time.Hour).Unix()
+
+	a.Lock()
+	defer a.Unlock()
+
+	domains, ok := a.hours[hour]
+	if !ok {
+		domains = make(map[string]*domainUsage)
+		a.hours[hour] = domains
+		a.prune(hour)
+	}
+
+	domain := domainOf(host)
+	u, ok := domains[domain]
+	if !ok {
+		u = &domainUsage{
+			routes:  make(map[string]struct{}),
+			latency: metrics.NewDigest(metrics.DefaultDigestCompression),
+		}
+		domains[domain] = u
+	}
+
+	u.requests++
+	if r.Request.ContentLength > 0 {
+		u.bytesReceived += r.Request.ContentLength
+	}
+	u.bytesSent += r.BodyBytesSent
+	if len(u.routes) < maxRoutesPerDomain {
+		u.routes[host] = struct{}{}
+	}
+	if !r.FinishedAt.IsZero() {
+		u.latency.Add(r.FinishedAt.Sub(r.StartedAt).Seconds())
+	}
+}
+
+// prune drops the hours that have fallen out of the retention window ending
+// at hour.
+func (a *Accountant) prune(hour int64) {
+	oldest := hour - int64(a.retention-1)*int64(time.Hour/time.Second)
+	for h := range a.hours {
+		if h < oldest {
+			delete(a.hours, h)
+		}
+	}
+}
+
+// Report sums the usage of the given number of hours up to and including
+// the hour of now.
+func (a *Accountant) Report(now time.Time, hours int) Report {
+	to := now.Truncate(time.Hour).Add(time.Hour)
+	from := to.Add(-time.Duration(hours) * time.Hour)
+
+	type total struct {
+		DomainReport
+		routes  map[string]struct{}
+		latency *metrics.Digest
+	}
+	totals := make(map[string]*total)
+
+	a.Lock()
+	for hour, domains := range a.hours {
+		start := time.Unix(hour, 0)
+		if start.Before(from) || !start.Before(to) {
+			continue
+		}
+
+		for domain, u := range domains {
+			t, ok := totals[domain]
+			if !ok {
+				t = &total{
+					DomainReport: DomainReport{Domain: domain},
+					routes:       make(map[string]struct{}),
+					latency:      metrics.NewDigest(metrics.DefaultDigestCompression),
+				}
+				totals[domain] = t
+			}
+
+			t.Requests += u.requests
+			t.BytesReceived += u.bytesReceived
+			t.BytesSent += u.bytesSent
+			for route := range u.routes {
+				t.routes[route] = struct{}{}
+			}
+			t.latency.Merge(u.latency)
+		}
+	}
+	a.Unlock()
+
+	report := Report{
+		From:    from,
+		To:      to,
+		Domains: make([]DomainReport, 0, len(totals)),
+	}
+	for _, t := range totals {
+		t.UniqueRoutes = len(t.routes)
+		if t.latency.Count() > 0 {
+			t.P95LatencySeconds = t.latency.Quantile(0.95)
+		}
+		report.Domains = append(report.Domains, t.DomainReport)
+	}
+	sort.Sort(byDomain(report.Domains))
+
+	return report
+}
+
+// ServeHTTP renders the report for the last `hours` hours, which defaults
+// to the whole retention window, as JSON or, with format=csv, as CSV.
+func (a *Accountant) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != "GET" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	hours := a.retention
+	if v := req.URL.Query().Get("hours"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > a.retention {
+			http.Error(w, "hours must be between 1 and "+strconv.Itoa(a.retention), http.StatusBadRequest)
+			return
+		}
+		hours = n
+	}
+
+	report := a.Report(time.Now(), hours)
+
+	switch req.URL.Query().Get("format") {
+	case "", "json":
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		report.WriteJSON(w)
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv")
+		w.WriteHeader(http.StatusOK)
+		report.WriteCSV(w)
+	default:
+		http.Error(w, "format must be json or csv", http.StatusBadRequest)
+	}
+}
+
+func hostWithoutPort(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.ToLower(host)
+}
+
+// domainOf returns the domain an app route lives under, which is its host
+// without the first label, e.g. example.com for app.example.com.
+func domainOf(host string) string {
+	if net.ParseIP(host) != nil {
+		return host
+	}
+
+	labels := strings.SplitN(host, ".", 2)
+	if len(labels) < 2 || !strings.Contains(labels[1], ".") {
+		return host
+	}
+
+	return labels[1]
+}
@@ -0,0 +1,139 @@
+package usage_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	"github.com/cloudfoundry/gorouter/access_log"
+	. "github.com/cloudfoundry/gorouter/usage"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type countingAccessLogger struct {
+	access_log.NullAccessLogger
+	records int
+}
+
+func (l *countingAccessLogger) Log(access_log.AccessLogRecord) {
+	l.records++
+}
+
+var _ = Describe("Accountant", func() {
+	var next *countingAccessLogger
+	var accountant *Accountant
+	var now time.Time
+
+	BeforeEach(func() {
+		next = &countingAccessLogger{}
+		accountant = NewAccountant(next, 24)
+		now = time.Date(2015, 6, 1, 12, 30, 0, 0, time.UTC)
+	})
+
+	record := func(host string, startedAt time.Time, latency time.Duration, received, sent int64) {
+		req, _ := http.NewRequest("POST", "http://"+host+"/", strings.NewReader(strings.Repeat("x", int(received))))
+		accountant.Log(access_log.AccessLogRecord{
+			Request:       req,
+			StatusCode:    http.StatusOK,
+			StartedAt:     startedAt,
+			FinishedAt:    startedAt.Add(latency),
+			BodyBytesSent: sent,
+		})
+	}
+
+	It("passes records on to the next logger", func() {
+		record("app.example.com", now, time.Millisecond, 0, 0)
+		Ω(next.records).To(Equal(1))
+	})
+
+	It("sums usage per domain", func() {
+		for i := 1; i <= 100; i++ {
+			record("app.example.com", now, time.Duration(i)*time.Millisecond, 10, 100)
+		}
+		record("api.example.com:8080", now, time.Millisecond, 0, 5)
+		record("www.example.org", now, time.Millisecond, 0, 5)
+
+		report := accountant.Report(now, 1)
+		Ω(report.From).To(Equal(time.Date(2015, 6, 1, 12, 0, 0, 0, time.UTC)))
+		Ω(report.To).To(Equal(time.Date(2015, 6, 1, 13, 0, 0, 0, time.UTC)))
+		Ω(report.Domains).To(HaveLen(2))
+
+		com := report.Domains[0]
+		Ω(com.Domain).To(Equal("example.com"))
+		Ω(com.Requests).To(BeNumerically("==", 101))
+		Ω(com.BytesReceived).To(BeNumerically("==", 1000))
+		Ω(com.BytesSent).To(BeNumerically("==", 10005))
+		Ω(com.UniqueRoutes).To(Equal(2))
+		Ω(com.P95LatencySeconds).To(BeNumerically("~", 0.095, 0.002))
+
+		Ω(report.Domains[1].Domain).To(Equal("example.org"))
+	})
+
+	It("only reports the requested hours", func() {
+		record("app.example.com", now, time.Millisecond, 0, 0)
+		record("app.example.com", now.Add(-time.Hour), time.Millisecond, 0, 0)
+		record("app.example.com", now.Add(-3*time.Hour), time.Millisecond, 0, 0)
+
+		Ω(accountant.Report(now, 1).Domains[0].Requests).To(BeNumerically("==", 1))
+		Ω(accountant.Report(now, 2).Domains[0].Requests).To(BeNumerically("==", 2))
+		Ω(accountant.Report(now, 24).Domains[0].Requests).To(BeNumerically("==", 3))
+	})
+
+	It("forgets hours past the retention window", func() {
+		record("app.example.com", now.Add(-30*time.Hour), time.Millisecond, 0, 0)
+		record("app.example.com", now, time.Millisecond, 0, 0)
+
+		report := accountant.Report(now, 48)
+		Ω(report.Domains[0].Requests).To(BeNumerically("==", 1))
+	})
+
+	It("writes the report as CSV", func() {
+		record("app.example.com", now, time.Second, 3, 4)
+
+		b := &strings.Builder{}
+		Ω(accountant.Report(now, 1).WriteCSV(b)).To(Succeed())
+
+		Ω(b.String()).To(Equal(
+			"from,to,domain,requests,bytes_received,bytes_sent,unique_routes,p95_latency_seconds\n" +
+				"2015-06-01T12:00:00Z,2015-06-01T13:00:00Z,example.com,1,3,4,1,1.000000\n"))
+	})
+
+	Describe("ServeHTTP", func() {
+		BeforeEach(func() {
+			record("app.example.com", time.Now(), time.Millisecond, 0, 0)
+		})
+
+		It("serves JSON by default", func() {
+			req, _ := http.NewRequest("GET", "/usage?hours=2", nil)
+			w := httptest.NewRecorder()
+
+			accountant.ServeHTTP(w, req)
+
+			Ω(w.Code).To(Equal(http.StatusOK))
+			Ω(w.Header().Get("Content-Type")).To(Equal("application/json"))
+			Ω(w.Body.String()).To(ContainSubstring(`"domain":"example.com","requests":1`))
+		})
+
+		It("serves CSV", func() {
+			req, _ := http.NewRequest("GET", "/usage?format=csv", nil)
+			w := httptest.NewRecorder()
+
+			accountant.ServeHTTP(w, req)
+
+			Ω(w.Code).To(Equal(http.StatusOK))
+			Ω(w.Header().Get("Content-Type")).To(Equal("text/csv"))
+			Ω(w.Body.String()).To(ContainSubstring(",example.com,1,"))
+		})
+
+		It("rejects hours outside the retention window", func() {
+			req, _ := http.NewRequest("GET", "/usage?hours=25", nil)
+			w := httptest.NewRecorder()
+
+			accountant.ServeHTTP(w, req)
+
+			Ω(w.Code).To(Equal(http.StatusBadRequest))
+		})
+	})
+})
@@ -0,0 +1,60 @@
+package usage
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"strconv"
+	"time"
+)
+
+type Report struct {
+	From    time.Time      `json:"from"`
+	To      time.Time      `json:"to"`
+	Domains []DomainReport `json:"domains"`
+}
+
+type DomainReport struct {
+	Domain            string  `json:"domain"`
+	Requests          int64   `json:"requests"`
+	BytesReceived     int64   `json:"bytes_received"`
+	BytesSent         int64   `json:"bytes_sent"`
+	UniqueRoutes      int     `json:"unique_routes"`
+	P95LatencySeconds float64 `json:"p95_latency_seconds"`
+}
+
+type byDomain []DomainReport
+
+func (d byDomain) Len() int           { return len(d) }
+func (d byDomain) Less(i, j int) bool { return d[i].Domain < d[j].Domain }
+func (d byDomain) Swap(i, j int)      { d[i], d[j] = d[j], d[i] }
+
+func (r Report) WriteJSON(w io.Writer) error {
+	return json.NewEncoder(w).Encode(r)
+}
+
+// WriteCSV writes one row per domain. The reporting window is repeated on
+// every row so that reports can be concatenated.
+func (r Report) WriteCSV(w io.Writer) error {
+	c := csv.NewWriter(w)
+
+	c.Write([]string{"from", "to", "domain", "requests", "bytes_received", "bytes_sent", "unique_routes", "p95_latency_seconds"})
+
+	from := r.From.UTC().Format(time.RFC3339)
+	to := r.To.UTC().Format(time.RFC3339)
+	for _, d := range r.Domains {
+		c.Write([]string{
+			from,
+			to,
+			d.Domain,
+			strconv.FormatInt(d.Requests, 10),
+			strconv.FormatInt(d.BytesReceived, 10),
+			strconv.FormatInt(d.BytesSent, 10),
+			strconv.Itoa(d.UniqueRoutes),
+			strconv.FormatFloat(d.P95LatencySeconds, 'f', 6, 64),
+		})
+	}
+
+	c.Flush()
+	return c.Error()
+}
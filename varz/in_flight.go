@@ -0,0 +1,35 @@
+package varz
+
+import (
+	"github.com/cloudfoundry/gorouter/route"
+)
+
+// routeInFlight is the number of requests in flight to a route, in all and
+// by the address of its endpoints.
+type routeInFlight struct {
+	Requests  int64            `json:"requests"`
+	Endpoints map[string]int64 `json:"endpoints"`
+}
+
+// routesInFlight adds up the requests in flight to the endpoints of every
+// route that keep takes.
+func routesInFlight(inFlight map[route.Uri][]route.EndpointInFlight, keep func(*route.Endpoint) bool) map[string]routeInFlight {
+	y := make(map[string]routeInFlight)
+
+	for uri, endpoints := range inFlight {
+		r := routeInFlight{Endpoints: make(map[string]int64)}
+		for _, e := range endpoints {
+			if !keep(e.Endpoint) {
+				continue
+			}
+			r.Requests += e.Requests
+			r.Endpoints[e.Endpoint.CanonicalAddr()] += e.Requests
+		}
+
+		if r.Requests > 0 {
+			y[string(uri)] = r
+		}
+	}
+
+	return y
+}
@@ -0,0 +1,182 @@
+package varz
+
+import (
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/cloudfoundry/gorouter/route"
+	"github.com/cloudfoundry/gorouter/stats"
+)
+
+const (
+	sortByRequests = "requests"
+	sortByLatency  = "latency"
+)
+
+// A varzQuery keeps what the endpoints registered with every tag of tags
+// served, and, when top is set, only the top routes, applications and tag
+// values, ranked by the number of requests or by their 99th percentile
+// latency.
+type varzQuery struct {
+	top  int
+	sort string
+	tags map[string]string
+}
+
+func parseVarzQuery(values url.Values) (varzQuery, string) {
+	q := varzQuery{
+		sort: sortByRequests,
+		tags: make(map[string]string),
+	}
+
+	for _, tag := range values["tag"] {
+		i := strings.IndexByte(tag, ':')
+		if i < 1 {
+			return q, "tag must be key:value"
+		}
+		q.tags[tag[:i]] = tag[i+1:]
+	}
+
+	if v := values.Get("top"); v != "" {
+		top, err := strconv.Atoi(v)
+		if err != nil || top < 1 {
+			return q, "top must be a number of 1 or more"
+		}
+		q.top = top
+	}
+
+	switch v := values.Get("sort"); v {
+	case "":
+	case sortByRequests, sortByLatency:
+		q.sort = v
+	default:
+		return q, "sort must be requests or latency"
+	}
+	return q, ""
+}
+
+func (q varzQuery) matchesTags(tags map[string]string) bool {
+	for key, value := range q.tags {
+		if tags[key] != value {
+			return false
+		}
+	}
+	return true
+}
+
+// ranked returns the names whose scores are the top highest, or all the
+// names without top.
+func (q varzQuery) ranked(scores map[string]float64) map[string]bool {
+	names := make([]string, 0, len(scores))
+	for name := range scores {
+		names = append(names, name)
+	}
+
+	if q.top > 0 && q.top < len(names) {
+		sort.Slice(names, func(i, j int) bool {
+			if scores[names[i]] != scores[names[j]] {
+				return scores[names[i]] > scores[names[j]]
+			}
+			return names[i] < names[j]
+		})
+		names = names[:q.top]
+	}
+
+	kept := make(map[string]bool, len(names))
+	for _, name := range names {
+		kept[name] = true
+	}
+	return kept
+}
+
+// taggedMetrics keeps the metrics of the tag names and values of the
+// query, if any, and the top values of each tag name.
+func (q varzQuery) taggedMetrics(tags map[string]TaggedHttpMetric) map[string]TaggedHttpMetric {
+	y := make(map[string]TaggedHttpMetric)
+
+	for name, tagged := range tags {
+		if len(q.tags) > 0 {
+			value, ok := q.tags[name]
+			if !ok {
+				continue
+			}
+
+			z := NewTaggedHttpMetric()
+			if m, ok := tagged[value]; ok {
+				z[value] = m
+			}
+			y[name] = z
+			continue
+		}
+
+		scores := make(map[string]float64, len(tagged))
+		for value, m := range tagged {
+			if q.sort == sortByLatency {
+				scores[value] = m.Latency.Percentile(0.99)
+			} else {
+				scores[value] = float64(m.Requests.Count())
+			}
+		}
+
+		z := NewTaggedHttpMetric()
+		for value := range q.ranked(scores) {
+			z[value] = tagged[value]
+		}
+		y[name] = z
+	}
+
+	return y
+}
+
+// appInstances keeps the instances whose endpoints carry the tags of the
+// query, of the top applications by requests. Instances have no latency,
+// so they are ranked by requests whatever the sort.
+func (q varzQuery) appInstances(instances map[string]map[string]stats.InstanceCounts) map[string]map[string]stats.InstanceCounts {
+	y := make(map[string]map[string]stats.InstanceCounts)
+	scores := make(map[string]float64)
+
+	for app, indexes := range instances {
+		for index, counts := range indexes {
+			if !q.matchesTags(counts.Tags) {
+				continue
+			}
+
+			if y[app] == nil {
+				y[app] = make(map[string]stats.InstanceCounts)
+			}
+			y[app][index] = counts
+			scores[app] += float64(counts.Requests)
+		}
+	}
+
+	kept := q.ranked(scores)
+	for app := range y {
+		if !kept[app] {
+			delete(y, app)
+		}
+	}
+	return y
+}
+
+// inFlight keeps the requests in flight to the endpoints with the tags of
+// the query, of the top routes by requests in flight whatever the sort.
+func (q varzQuery) inFlight(inFlight map[route.Uri][]route.EndpointInFlight) map[string]routeInFlight {
+	y := routesInFlight(inFlight, func(e *route.Endpoint) bool {
+		return q.matchesTags(e.Tags)
+	})
+
+	scores := make(map[string]float64, len(y))
+	for uri, r := range y {
+		scores[uri] = float64(r.Requests)
+	}
+
+	kept := q.ranked(scores)
+	for uri := range y {
+		if !kept[uri] {
+			delete(y, uri)
+		}
+	}
+	return y
+}
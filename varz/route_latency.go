@@ -0,0 +1,135 @@
+package varz
+
+import (
+	"container/list"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	metrics "github.com/rcrowley/go-metrics"
+)
+
+const (
+	MaxTrackedRoutes = 500
+
+	routeLatencySampleSize = 256
+)
+
+var routeLatencyPercentiles = []float64{0.50, 0.95, 0.99}
+
+type routeLatencyEntry struct {
+	uri     string
+	tags    map[string]string
+	latency metrics.Histogram
+}
+
+// RouteLatency keeps a latency histogram per route. Only the maxRoutes most
+// recently used routes are tracked, so the memory it takes is bounded no
+// matter how many routes are registered. It is not safe for concurrent
+// use; RealVarz guards it with its own lock.
+type RouteLatency struct {
+	maxRoutes int
+	lru       *list.List
+	entries   map[string]*list.Element
+}
+
+func NewRouteLatency(maxRoutes int) *RouteLatency {
+	return &RouteLatency{
+		maxRoutes: maxRoutes,
+		lru:       list.New(),
+		entries:   make(map[string]*list.Element),
+	}
+}
+
+// Capture records the latency of a response of the route, and the tags of
+// the endpoint that served it.
+func (x *RouteLatency) Capture(uri string, tags map[string]string, duration time.Duration) {
+	if uri == "" || x.maxRoutes <= 0 {
+		return
+	}
+
+	element, ok := x.entries[uri]
+	if ok {
+		x.lru.MoveToFront(element)
+	} else {
+		if x.lru.Len() >= x.maxRoutes {
+			oldest := x.lru.Back()
+			x.lru.Remove(oldest)
+			delete(x.entries, oldest.Value.(*routeLatencyEntry).uri)
+		}
+
+		element = x.lru.PushFront(&routeLatencyEntry{
+			uri:     uri,
+			latency: metrics.NewHistogram(metrics.NewExpDecaySample(routeLatencySampleSize, 0.015)),
+		})
+		x.entries[uri] = element
+	}
+
+	e := element.Value.(*routeLatencyEntry)
+	e.tags = tags
+	e.latency.Update(duration.Nanoseconds())
+}
+
+func (x *RouteLatency) Len() int {
+	return x.lru.Len()
+}
+
+// MarshalJSON renders the 50th, 95th and 99th latency percentiles of every
+// tracked route in seconds, along with the number of samples.
+func (x *RouteLatency) MarshalJSON() ([]byte, error) {
+	y := make(map[string]map[string]float64, len(x.entries))
+
+	for uri, element := range x.entries {
+		y[uri] = percentiles(element.Value.(*routeLatencyEntry).latency)
+	}
+
+	return json.Marshal(y)
+}
+
+// query renders the latency of the routes last served by endpoints with the
+// tags of the query, and only of the top routes by their number of samples
+// or their 99th percentile latency.
+func (x *RouteLatency) query(q varzQuery) map[string]map[string]float64 {
+	scores := make(map[string]float64)
+	for uri, element := range x.entries {
+		e := element.Value.(*routeLatencyEntry)
+		if !q.matchesTags(e.tags) {
+			continue
+		}
+
+		if q.sort == sortByLatency {
+			scores[uri] = e.latency.Percentile(0.99)
+		} else {
+			scores[uri] = float64(e.latency.Count())
+		}
+	}
+
+	y := make(map[string]map[string]float64)
+	for uri := range q.ranked(scores) {
+		y[uri] = percentiles(x.entries[uri].Value.(*routeLatencyEntry).latency)
+	}
+	return y
+}
+
+func percentiles(latency metrics.Histogram) map[string]float64 {
+	z := latency.Percentiles(routeLatencyPercentiles)
+
+	y := make(map[string]float64)
+	for i, p := range routeLatencyPercentiles {
+		y[fmt.Sprintf("%d", int(p*100))] = z[i] / float64(time.Second)
+	}
+	y["samples"] = float64(latency.Count())
+
+	return y
+}
+
+// routeOf returns the route a request was made for: its host without the
+// port, in lower case.
+func routeOf(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.ToLower(host)
+}
@@ -0,0 +1,51 @@
+package varz_test
+
+import (
+	. "github.com/cloudfoundry/gorouter/varz"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"encoding/json"
+	"time"
+)
+
+var _ = Describe("RouteLatency", func() {
+	var routeLatency *RouteLatency
+
+	BeforeEach(func() {
+		routeLatency = NewRouteLatency(2)
+	})
+
+	latencies := func() map[string]map[string]float64 {
+		b, err := json.Marshal(routeLatency)
+		Ω(err).NotTo(HaveOccurred())
+
+		var y map[string]map[string]float64
+		Ω(json.Unmarshal(b, &y)).To(Succeed())
+		return y
+	}
+
+	It("renders percentiles in seconds", func() {
+		for i := 1; i <= 100; i++ {
+			routeLatency.Capture("a.example.com", nil, time.Duration(i)*time.Millisecond)
+		}
+
+		y := latencies()["a.example.com"]
+		Ω(y["50"]).To(BeNumerically("~", 0.0505, 0.001))
+		Ω(y["95"]).To(BeNumerically("~", 0.095, 0.001))
+		Ω(y["99"]).To(BeNumerically("~", 0.099, 0.001))
+		Ω(y["samples"]).To(Equal(float64(100)))
+	})
+
+	It("evicts the least recently used route when full", func() {
+		routeLatency.Capture("a.example.com", nil, time.Millisecond)
+		routeLatency.Capture("b.example.com", nil, time.Millisecond)
+		routeLatency.Capture("a.example.com", nil, time.Millisecond)
+		routeLatency.Capture("c.example.com", nil, time.Millisecond)
+
+		Ω(routeLatency.Len()).To(Equal(2))
+		Ω(latencies()).To(HaveKey("a.example.com"))
+		Ω(latencies()).ToNot(HaveKey("b.example.com"))
+		Ω(latencies()).To(HaveKey("c.example.com"))
+	})
+})
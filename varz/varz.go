@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"net/url"
 	"sync"
 	"time"
 
@@ -20,20 +21,32 @@ type topAppsEntry struct {
 }
 
 type varz struct {
-	All  *HttpMetric `json:"all"`
-	Tags struct {
-		Component TaggedHttpMetric `json:"component"`
-	} `json:"tags"`
+	All *HttpMetric `json:"all"`
+
+	// Tags has the requests to the endpoints registered with a tag by the
+	// tag's name and value, such as tags.space.prod
+	Tags map[string]TaggedHttpMetric `json:"tags"`
 
 	Urls     int `json:"urls"`
 	Droplets int `json:"droplets"`
 
 	BadRequests    int     `json:"bad_requests"`
 	BadGateways    int     `json:"bad_gateways"`
+	RateLimited    int     `json:"rate_limited_requests"`
+	ClientDenied   int     `json:"client_denied_requests"`
+	BackendClosed  int     `json:"backend_closed_connections"`
 	RequestsPerSec float64 `json:"requests_per_sec"`
 
 	TopApps []topAppsEntry `json:"top10_app_requests"`
 
+	AppInstances        map[string]map[string]stats.InstanceCounts `json:"app_instances"`
+	AppInstancesEvicted int64                                      `json:"app_instances_evicted"`
+
+	RouteLatency *RouteLatency `json:"route_latency"`
+
+	// InFlight has the requests in flight to the routes that have any
+	InFlight map[string]routeInFlight `json:"in_flight"`
+
 	MillisSinceLastRegistryUpdate int64 `json:"ms_since_last_registry_update"`
 }
 
@@ -164,6 +177,9 @@ type Varz interface {
 
 	CaptureBadRequest(req *http.Request)
 	CaptureBadGateway(req *http.Request)
+	CaptureRateLimited(b *route.Endpoint, req *http.Request)
+	CaptureClientDenied(req *http.Request)
+	CaptureBackendClosedConnection(b *route.Endpoint, req *http.Request)
 	CaptureRoutingRequest(b *route.Endpoint, req *http.Request)
 	CaptureRoutingResponse(b *route.Endpoint, res *http.Response, startedAt time.Time, d time.Duration)
 }
@@ -173,6 +189,7 @@ type RealVarz struct {
 	r          *registry.RouteRegistry
 	activeApps *stats.ActiveApps
 	topApps    *stats.TopApps
+	instances  *stats.InstanceStats
 	varz
 }
 
@@ -181,9 +198,11 @@ func NewVarz(r *registry.RouteRegistry) Varz {
 
 	x.activeApps = stats.NewActiveApps()
 	x.topApps = stats.NewTopApps()
+	x.instances = stats.NewInstanceStats(stats.MaxTrackedInstances)
 
 	x.All = NewHttpMetric()
-	x.Tags.Component = make(map[string]*HttpMetric)
+	x.Tags = map[string]TaggedHttpMetric{"component": NewTaggedHttpMetric()}
+	x.RouteLatency = NewRouteLatency(MaxTrackedRoutes)
 
 	return x
 }
@@ -192,6 +211,40 @@ func (x *RealVarz) MarshalJSON() ([]byte, error) {
 	x.Lock()
 	defer x.Unlock()
 
+	x.update()
+	x.varz.AppInstances = x.instances.Snapshot()
+	x.varz.InFlight = routesInFlight(x.r.InFlight(), func(*route.Endpoint) bool { return true })
+
+	return json.Marshal(render(x.varz))
+}
+
+// QueryVarz renders varz for the tag, top and sort parameters of a /varz
+// request, which narrow down the tags, app_instances, route_latency and
+// in_flight.
+func (x *RealVarz) QueryVarz(values url.Values) (interface{}, string) {
+	q, problem := parseVarzQuery(values)
+	if problem != "" {
+		return nil, problem
+	}
+
+	x.Lock()
+	defer x.Unlock()
+
+	x.update()
+
+	v := x.varz
+	v.Tags = q.taggedMetrics(x.varz.Tags)
+	v.AppInstances = q.appInstances(x.instances.Snapshot())
+	v.InFlight = q.inFlight(x.r.InFlight())
+	v.RouteLatency = nil
+
+	d := render(v)
+	d["route_latency"] = x.varz.RouteLatency.query(q)
+
+	return d, ""
+}
+
+func (x *RealVarz) update() {
 	x.varz.Urls = x.r.NumUris()
 	x.varz.Droplets = x.r.NumEndpoints()
 
@@ -200,13 +253,16 @@ func (x *RealVarz) MarshalJSON() ([]byte, error) {
 	x.varz.MillisSinceLastRegistryUpdate = time.Since(x.r.TimeOfLastUpdate()).Nanoseconds() / millis_per_nano
 
 	x.updateTop()
+	x.varz.AppInstancesEvicted = x.instances.Evicted()
+}
 
+func render(v varz) map[string]interface{} {
 	d := make(map[string]interface{})
-	transform(x.varz.All, d)
-	transform(x.varz, d)
+	transform(v.All, d)
+	transform(v, d)
 	delete(d, "all")
 
-	return json.Marshal(d)
+	return d
 }
 
 func (x *RealVarz) updateTop() {
@@ -239,6 +295,24 @@ func (x *RealVarz) CaptureBadGateway(*http.Request) {
 	x.Unlock()
 }
 
+func (x *RealVarz) CaptureRateLimited(*route.Endpoint, *http.Request) {
+	x.Lock()
+	x.RateLimited++
+	x.Unlock()
+}
+
+func (x *RealVarz) CaptureClientDenied(*http.Request) {
+	x.Lock()
+	x.ClientDenied++
+	x.Unlock()
+}
+
+func (x *RealVarz) CaptureBackendClosedConnection(*route.Endpoint, *http.Request) {
+	x.Lock()
+	x.BackendClosed++
+	x.Unlock()
+}
+
 func (x *RealVarz) CaptureAppStats(b *route.Endpoint, t time.Time) {
 	if b.ApplicationId != "" {
 		x.activeApps.Mark(b.ApplicationId, t)
@@ -249,15 +323,12 @@ func (x *RealVarz) CaptureAppStats(b *route.Endpoint, t time.Time) {
 func (x *RealVarz) CaptureRoutingRequest(b *route.Endpoint, req *http.Request) {
 	x.Lock()
 
-	var t string
-	var ok bool
-
-	t, ok = b.Tags["component"]
-	if ok {
-		x.varz.Tags.Component.CaptureRequest(t)
+	for name, value := range b.Tags {
+		x.tagged(name).CaptureRequest(value)
 	}
 
 	x.varz.All.CaptureRequest()
+	x.instances.MarkRequest(b.ApplicationId, b.PrivateInstanceIndex, b.Tags)
 
 	x.Unlock()
 }
@@ -265,20 +336,34 @@ func (x *RealVarz) CaptureRoutingRequest(b *route.Endpoint, req *http.Request) {
 func (x *RealVarz) CaptureRoutingResponse(endpoint *route.Endpoint, response *http.Response, startedAt time.Time, duration time.Duration) {
 	x.Lock()
 
-	var tags string
-	var ok bool
-
-	tags, ok = endpoint.Tags["component"]
-	if ok {
-		x.varz.Tags.Component.CaptureResponse(tags, response, duration)
+	for name, value := range endpoint.Tags {
+		x.tagged(name).CaptureResponse(value, response, duration)
 	}
 
 	x.CaptureAppStats(endpoint, startedAt)
 	x.varz.All.CaptureResponse(response, duration)
 
+	var statusCode int
+	if response != nil {
+		statusCode = response.StatusCode
+		if response.Request != nil {
+			x.varz.RouteLatency.Capture(routeOf(response.Request.Host), endpoint.Tags, duration)
+		}
+	}
+	x.instances.MarkResponse(endpoint.ApplicationId, endpoint.PrivateInstanceIndex, statusCode)
+
 	x.Unlock()
 }
 
+func (x *RealVarz) tagged(name string) TaggedHttpMetric {
+	t := x.varz.Tags[name]
+	if t == nil {
+		t = NewTaggedHttpMetric()
+		x.varz.Tags[name] = t
+	}
+	return t
+}
+
 func transform(x interface{}, y map[string]interface{}) error {
 	var b []byte
 	var err error
@@ -12,6 +12,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"net/url"
 	"time"
 )
 
@@ -43,6 +44,9 @@ var _ = Describe("Varz", func() {
 			"bad_gateways",
 			"requests_per_sec",
 			"top10_app_requests",
+			"app_instances",
+			"route_latency",
+			"in_flight",
 			"ms_since_last_registry_update",
 		}
 
@@ -101,6 +105,11 @@ var _ = Describe("Varz", func() {
 		Ω(findValue(Varz, "bad_gateways")).To(Equal(float64(2)))
 	})
 
+	It("updates requests of clients that are not allowed", func() {
+		Varz.CaptureClientDenied(&http.Request{})
+		Ω(findValue(Varz, "client_denied_requests")).To(Equal(float64(1)))
+	})
+
 	It("updates requests", func() {
 		b := &route.Endpoint{}
 		r := http.Request{}
@@ -134,6 +143,22 @@ var _ = Describe("Varz", func() {
 		Ω(findValue(Varz, "tags", "component", "cc", "requests")).To(Equal(float64(2)))
 	})
 
+	It("updates requests with any tag", func() {
+		b := &route.Endpoint{
+			Tags: map[string]string{
+				"space":       "prod",
+				"environment": "eu",
+			},
+		}
+
+		Varz.CaptureRoutingRequest(b, &http.Request{})
+		Varz.CaptureRoutingResponse(b, &http.Response{StatusCode: 200}, time.Now(), time.Millisecond)
+
+		Ω(findValue(Varz, "tags", "space", "prod", "requests")).To(Equal(float64(1)))
+		Ω(findValue(Varz, "tags", "environment", "eu", "responses_2xx")).To(Equal(float64(1)))
+		Ω(findValue(Varz, "tags", "component")).To(BeEmpty())
+	})
+
 	It("updates responses", func() {
 		var b *route.Endpoint = &route.Endpoint{}
 		var t time.Time
@@ -187,6 +212,149 @@ var _ = Describe("Varz", func() {
 		Ω(findValue(Varz, "tags", "component", "cc", "responses_4xx")).To(Equal(float64(2)))
 	})
 
+	It("updates requests and errors per instance index", func() {
+		b0 := route.NewEndpoint("app", "192.168.1.1", 1234, "", nil, -1)
+		b0.PrivateInstanceIndex = "0"
+		b1 := route.NewEndpoint("app", "192.168.1.2", 1234, "", nil, -1)
+		b1.PrivateInstanceIndex = "1"
+
+		r := &http.Request{}
+		for i := 0; i < 4; i++ {
+			Varz.CaptureRoutingRequest(b0, r)
+			Varz.CaptureRoutingResponse(b0, &http.Response{StatusCode: http.StatusOK}, time.Now(), time.Millisecond)
+		}
+		Varz.CaptureRoutingRequest(b1, r)
+		Varz.CaptureRoutingResponse(b1, &http.Response{StatusCode: http.StatusBadGateway}, time.Now(), time.Millisecond)
+		Varz.CaptureRoutingRequest(b1, r)
+		Varz.CaptureRoutingResponse(b1, nil, time.Now(), time.Millisecond)
+
+		Ω(findValue(Varz, "app_instances", "app", "0", "requests")).To(Equal(float64(4)))
+		Ω(findValue(Varz, "app_instances", "app", "0", "error_rate")).To(Equal(float64(0)))
+		Ω(findValue(Varz, "app_instances", "app", "1", "requests")).To(Equal(float64(2)))
+		Ω(findValue(Varz, "app_instances", "app", "1", "errors")).To(Equal(float64(2)))
+		Ω(findValue(Varz, "app_instances", "app", "1", "error_rate")).To(Equal(float64(1)))
+	})
+
+	It("updates response latency per route", func() {
+		b := &route.Endpoint{}
+		slow := &http.Response{Request: &http.Request{Host: "Slow.example.com:80"}}
+		fast := &http.Response{Request: &http.Request{Host: "fast.example.com"}}
+
+		for i := 0; i < 10; i++ {
+			Varz.CaptureRoutingResponse(b, slow, time.Now(), 2*time.Second)
+			Varz.CaptureRoutingResponse(b, fast, time.Now(), 10*time.Millisecond)
+		}
+
+		Ω(findValue(Varz, "route_latency", "slow.example.com", "50")).To(Equal(float64(2)))
+		Ω(findValue(Varz, "route_latency", "slow.example.com", "99")).To(Equal(float64(2)))
+		Ω(findValue(Varz, "route_latency", "slow.example.com", "samples")).To(Equal(float64(10)))
+		Ω(findValue(Varz, "route_latency", "fast.example.com", "95")).To(Equal(float64(0.01)))
+	})
+
+	It("has the requests in flight to each route and endpoint", func() {
+		b := route.NewEndpoint("app", "192.168.1.1", 1234, "", nil, -1)
+		Registry.Register("foo.vcap.me", b)
+		Registry.Register("bar.vcap.me", b)
+		Ω(findValue(Varz, "in_flight")).To(BeEmpty())
+
+		iter := Registry.Lookup("foo.vcap.me").Endpoints("")
+		iter.Next()
+
+		Ω(findValue(Varz, "in_flight")).To(HaveLen(1))
+		Ω(findValue(Varz, "in_flight", "foo.vcap.me", "requests")).To(Equal(float64(1)))
+		Ω(findValue(Varz, "in_flight", "foo.vcap.me", "endpoints", "192.168.1.1:1234")).To(Equal(float64(1)))
+
+		iter.Done()
+		Ω(findValue(Varz, "in_flight")).To(BeEmpty())
+	})
+
+	Describe("queries", func() {
+		query := func(values url.Values) map[string]interface{} {
+			v, problem := Varz.(*RealVarz).QueryVarz(values)
+			Ω(problem).To(BeEmpty())
+
+			b, err := json.Marshal(v)
+			Ω(err).ShouldNot(HaveOccurred())
+
+			d := make(map[string]interface{})
+			Ω(json.Unmarshal(b, &d)).To(Succeed())
+			return d
+		}
+
+		serve := func(host, app, space string, requests int, duration time.Duration) {
+			b := route.NewEndpoint(app, "192.168.1.1", 1234, "", map[string]string{"space": space}, -1)
+			b.PrivateInstanceIndex = "0"
+			res := &http.Response{StatusCode: http.StatusOK, Request: &http.Request{Host: host}}
+
+			for i := 0; i < requests; i++ {
+				Varz.CaptureRoutingRequest(b, &http.Request{})
+				Varz.CaptureRoutingResponse(b, res, time.Now(), duration)
+			}
+		}
+
+		BeforeEach(func() {
+			serve("a.example.com", "a", "prod", 3, time.Millisecond)
+			serve("b.example.com", "b", "prod", 2, time.Second)
+			serve("c.example.com", "c", "dev", 1, time.Millisecond)
+		})
+
+		It("keeps the busiest routes, apps and tag values", func() {
+			d := query(url.Values{"top": {"1"}})
+
+			Ω(d["route_latency"]).To(HaveLen(1))
+			Ω(d["route_latency"]).To(HaveKey("a.example.com"))
+			Ω(d["app_instances"]).To(HaveLen(1))
+			Ω(d["app_instances"]).To(HaveKey("a"))
+			Ω(d["tags"]).To(HaveKeyWithValue("space", HaveLen(1)))
+			Ω(d["tags"]).To(HaveKeyWithValue("space", HaveKey("prod")))
+			Ω(d).To(HaveKeyWithValue("requests", BeEquivalentTo(6)))
+		})
+
+		It("keeps the routes with the most requests in flight to the endpoints with the tags", func() {
+			for host, space := range map[string]string{"a.example.com": "prod", "b.example.com": "prod", "c.example.com": "dev"} {
+				Registry.Register(route.Uri(host), route.NewEndpoint("", "192.168.1.1", 1234, "", map[string]string{"space": space}, -1))
+			}
+			for _, host := range []string{"a.example.com", "b.example.com", "b.example.com", "c.example.com"} {
+				Registry.Lookup(route.Uri(host)).Endpoints("").Next()
+			}
+
+			d := query(url.Values{"top": {"1"}, "tag": {"space:prod"}})
+			Ω(d["in_flight"]).To(HaveLen(1))
+			Ω(d["in_flight"]).To(HaveKeyWithValue("b.example.com", HaveKeyWithValue("requests", BeEquivalentTo(2))))
+		})
+
+		It("keeps the slowest routes", func() {
+			d := query(url.Values{"top": {"1"}, "sort": {"latency"}})
+
+			Ω(d["route_latency"]).To(HaveLen(1))
+			Ω(d["route_latency"]).To(HaveKey("b.example.com"))
+		})
+
+		It("keeps what the endpoints with the tags served", func() {
+			d := query(url.Values{"tag": {"space:dev"}})
+
+			Ω(d["route_latency"]).To(HaveLen(1))
+			Ω(d["route_latency"]).To(HaveKey("c.example.com"))
+			Ω(d["app_instances"]).To(HaveLen(1))
+			Ω(d["app_instances"]).To(HaveKey("c"))
+			Ω(d["tags"]).To(HaveLen(1))
+			Ω(d["tags"]).To(HaveKeyWithValue("space", HaveLen(1)))
+			Ω(d["tags"]).To(HaveKeyWithValue("space", HaveKeyWithValue("dev", HaveKeyWithValue("requests", BeEquivalentTo(1)))))
+		})
+
+		It("tells what is wrong with a query", func() {
+			for _, q := range []url.Values{
+				{"top": {"0"}},
+				{"top": {"many"}},
+				{"sort": {"name"}},
+				{"tag": {"space"}},
+			} {
+				_, problem := Varz.(*RealVarz).QueryVarz(q)
+				Ω(problem).ToNot(BeEmpty(), q.Encode())
+			}
+		})
+	})
+
 	It("updates response latency", func() {
 		var routeEndpoint *route.Endpoint = &route.Endpoint{}
 		var startedAt = time.Now()